@@ -29,8 +29,10 @@ import (
 	"github.com/spf13/cobra"
 	"go.uber.org/zap"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"kubegems.io/kubegems/pkg/apis/plugins"
 	pluginv1beta1 "kubegems.io/kubegems/pkg/apis/plugins/v1beta1"
 	"kubegems.io/kubegems/pkg/installer/bundle"
+	"kubegems.io/kubegems/pkg/installer/pluginmanager"
 	"kubegems.io/kubegems/pkg/installer/utils"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
@@ -49,6 +51,8 @@ func NewBundleControllerCmd() *cobra.Command {
 	cmd.AddCommand(
 		NewDownloadCmd(globalOptions),
 		NewTemplateCmd(globalOptions),
+		NewExportCmd(),
+		NewImportCmd(globalOptions),
 	)
 	cmd.PersistentFlags().StringVarP(&globalOptions.CacheDir, "cache-dir", "c", globalOptions.CacheDir, "cache directory")
 	return cmd
@@ -106,6 +110,82 @@ bundle -c bundles download helm-bundle.yaml
 	return cmd
 }
 
+func NewExportCmd() *cobra.Command {
+	options := pluginmanager.ExportOptions{Repository: plugins.GenKubeGemsChartsRepoURL()}
+	output := "kubegems-plugins.tar.gz"
+	cmd := &cobra.Command{
+		Use:   "export",
+		Short: "export plugins with dependencies and images list into an archive for offline install",
+		Example: `
+# export plugins with their dependencies
+kubegems plugins export --repo https://charts.kubegems.io/kubegems -o plugins.tar.gz monitoring logging@1.0.0
+		`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+			defer cancel()
+
+			zapl, _ := zap.NewDevelopment()
+			ctx = logr.NewContext(ctx, zapr.NewLogger(zapl))
+
+			f, err := os.Create(output)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+
+			options.Plugins = args
+			manifest, err := pluginmanager.Export(ctx, options, f)
+			if err != nil {
+				os.Remove(output)
+				return err
+			}
+			fmt.Printf("exported %d plugins and %d images into %s\n", len(manifest.Plugins), len(manifest.Images), output)
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&options.Repository, "repo", options.Repository, "plugins repository address")
+	cmd.Flags().StringVarP(&output, "output", "o", output, "output archive")
+	return cmd
+}
+
+func NewImportCmd(options *bundle.Options) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "import",
+		Short: "import an exported plugins archive into cache directory",
+		Example: `
+# import plugins into installer cache directory
+kubegems plugins -c /app/plugins import plugins.tar.gz
+		`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+			defer cancel()
+
+			zapl, _ := zap.NewDevelopment()
+			ctx = logr.NewContext(ctx, zapr.NewLogger(zapl))
+
+			f, err := os.Open(args[0])
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+
+			manifest, err := pluginmanager.Import(ctx, f, options.CacheDir)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("imported %d plugins\n", len(manifest.Plugins))
+			for _, repository := range manifest.Repositories {
+				repodir, _ := filepath.Abs(bundle.PerRepoCacheDir(repository, options.CacheDir))
+				fmt.Printf("repository %s: file://%s\n", repository, repodir)
+			}
+			return nil
+		},
+	}
+	return cmd
+}
+
 func forBundleInPathes(pathes []string, fun func(*pluginv1beta1.Plugin) error) error {
 	return ForBundleInPathes(pathes, PluginFromDir, func(plugin *pluginv1beta1.Plugin) error {
 		return fun(plugin)
@@ -41,6 +41,7 @@ type Options struct {
 	Exporter  *prometheus.ExporterOptions `json:"exporter,omitempty"`
 	Otel      *otel.Options               `json:"otel,omitempty"`
 	Installer *installerapi.ClientOptions `json:"installer,omitempty"`
+	Tunnel    *TunnelOptions              `json:"tunnel,omitempty" description:"connect to kubegems by tunnel"`
 }
 
 func DefaultOptions() *Options {
@@ -54,6 +55,7 @@ func DefaultOptions() *Options {
 		Exporter:  prometheus.DefaultExporterOptions(),
 		Otel:      otel.NewDefaultOptions(),
 		Installer: installerapi.NewDefaultClientOptions(),
+		Tunnel:    NewDefaultTunnelOptions(),
 	}
 	defaultoptions.System.Listen = ":8041"
 	return defaultoptions
@@ -94,5 +96,8 @@ func Run(ctx context.Context, options *Options) error {
 	eg.Go(func() error {
 		return exporterHandler.Run(ctx, options.Exporter)
 	})
+	eg.Go(func() error {
+		return RunTunnel(ctx, c, options.Tunnel, options.System)
+	})
 	return eg.Wait()
 }
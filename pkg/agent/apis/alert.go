@@ -15,10 +15,13 @@
 package apis
 
 import (
+	"encoding/json"
 	"io"
 
 	"github.com/gin-gonic/gin"
 	"kubegems.io/kubegems/pkg/utils/msgbus"
+	"kubegems.io/kubegems/pkg/utils/prometheus"
+	"kubegems.io/kubegems/pkg/utils/prometheus/channels"
 )
 
 // 获取各个集群的告警信息
@@ -31,15 +34,34 @@ type AlertHandler struct {
 // @Description kubegems default alert webhook
 // @Accept      json
 // @Produce     json
-// @Success     200 {object} handlers.ResponseStruct{Data=string} ""
+// @Param       type query    string                               false "为oncall时按值班表及升级策略通知，为wecom、msteams、telegram时由kubegems发送到对应渠道"
+// @Success     200  {object} handlers.ResponseStruct{Data=string} ""
 // @Router      /alert [post]
 // @Security    JWT
 func (h *AlertHandler) Webhook(c *gin.Context) {
 	b, _ := io.ReadAll(c.Request.Body)
+	switch channels.ChannelType(c.Query("type")) {
+	case channels.TypeWeCom, channels.TypeMSTeams, channels.TypeTelegram:
+		alert := prometheus.WebhookAlert{}
+		if err := json.Unmarshal(b, &alert); err != nil {
+			NotOK(c, err)
+			return
+		}
+		if err := channels.NotifyByWebhook(c.Request, alert); err != nil {
+			NotOK(c, err)
+			return
+		}
+		OK(c, nil)
+		return
+	}
 	msg := msgbus.NotifyMessage{
 		MessageType: msgbus.Alert,
 		Content:     string(b),
 	}
+	// 值班渠道的告警由 msgbus 按值班表及升级策略通知
+	if c.Query("type") == string(channels.TypeOnCall) {
+		msg.MessageType = msgbus.OnCall
+	}
 	h.Watcher.DispatchMessage(msg)
 	OK(c, nil)
 }
@@ -0,0 +1,118 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"net"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"kubegems.io/kubegems/pkg/agent/cluster"
+	"kubegems.io/kubegems/pkg/edge/tunnel"
+	"kubegems.io/kubegems/pkg/log"
+	"kubegems.io/kubegems/pkg/utils/agents"
+	"kubegems.io/kubegems/pkg/utils/kube"
+	"kubegems.io/kubegems/pkg/utils/system"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+const (
+	TunnelAgentKeySecret = "kubegems-agent-tunnel"
+	tunnelAgentKeyKey    = "agent-key"
+)
+
+type TunnelOptions struct {
+	Addr               string `json:"addr,omitempty" description:"tunnel server address,enable tunnel mode if set"`
+	Token              string `json:"token,omitempty" description:"bootstrap token of the cluster"`
+	ClusterName        string `json:"clustername,omitempty" description:"cluster name registered in kubegems"`
+	InsecureSkipVerify bool   `json:"insecureSkipVerify,omitempty" description:"skip tls verify of tunnel server"`
+}
+
+func NewDefaultTunnelOptions() *TunnelOptions {
+	return &TunnelOptions{}
+}
+
+// RunTunnel 主动连接至 kubegems 的隧道服务，控制面通过隧道访问 agent
+func RunTunnel(ctx context.Context, c cluster.Interface, options *TunnelOptions, systemoptions *system.Options) error {
+	if options == nil || options.Addr == "" {
+		return nil
+	}
+	if options.ClusterName == "" {
+		return fmt.Errorf("tunnel cluster name is required")
+	}
+	agentkey, err := getTunnelAgentKey(ctx, c.GetClient())
+	if err != nil {
+		return err
+	}
+	scheme := "http"
+	if systemoptions.IsTLSConfigEnabled() {
+		scheme = "https"
+	}
+	// 隧道在 agent 本地建立连接，仅使用监听地址中的端口
+	_, port, err := net.SplitHostPort(systemoptions.Listen)
+	if err != nil {
+		return fmt.Errorf("invalid listen address %s: %w", systemoptions.Listen, err)
+	}
+	sv, _ := c.Discovery().ServerVersion()
+	annotations := tunnel.Annotations{
+		agents.AnnotationKeyAgentAddress: scheme + "://" + net.JoinHostPort("127.0.0.1", port),
+	}
+	if sv != nil {
+		annotations[agents.AnnotationKeyKubernetesVerion] = sv.String()
+	}
+	server := tunnel.GrpcTunnelServer{TunnelServer: tunnel.NewTunnelServer(options.ClusterName, nil)}
+	tlsconfig := &tls.Config{InsecureSkipVerify: options.InsecureSkipVerify}
+
+	log.FromContextOrDiscard(ctx).Info("connecting tunnel", "addr", options.Addr, "cluster", options.ClusterName)
+	// 首次接入后 bootstrap token 失效，之后使用 agent key 认证
+	return server.ConnectUpstreamWithRetry(ctx, options.Addr, tlsconfig, options.Token+"."+agentkey, annotations)
+}
+
+// getTunnelAgentKey 获取 agent 接入隧道的凭证，不存在时生成并保存
+func getTunnelAgentKey(ctx context.Context, cli client.Client) (string, error) {
+	agentkey := ""
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      TunnelAgentKeySecret,
+			Namespace: kube.LocalNamespaceOrDefault("kubegems-local"),
+		},
+	}
+	_, err := controllerutil.CreateOrPatch(ctx, cli, secret, func() error {
+		if secret.Data == nil {
+			secret.Data = map[string][]byte{}
+		}
+		if exists := string(secret.Data[tunnelAgentKeyKey]); exists != "" {
+			agentkey = exists
+			return nil
+		}
+		data := make([]byte, 32)
+		if _, err := rand.Read(data); err != nil {
+			return err
+		}
+		agentkey = hex.EncodeToString(data)
+		secret.Data[tunnelAgentKeyKey] = []byte(agentkey)
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return agentkey, nil
+}
@@ -35,4 +35,7 @@ const (
 
 	// edge agent default address
 	AnnotationValueDefaultEdgeAgentAddress = "http://127.0.0.1:8080"
+
+	// edge agent path to receive rotated certificates
+	EdgeAgentCertificatePath = "/internal/edge/certificate"
 )
@@ -0,0 +1,118 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1beta1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	runtime "k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+const (
+	LabelEdgeTaskSet          = "edge.kubegems.io/edgetaskset"
+	AnnotationEdgeTaskSetHash = "edge.kubegems.io/edgetaskset-hash"
+)
+
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:scope=Namespaced
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Status",type="string",JSONPath=".status.phase",description="Status"
+// +kubebuilder:printcolumn:name="Clusters",type="integer",JSONPath=".status.clusters",description="Selected edge clusters"
+// +kubebuilder:printcolumn:name="Updated",type="integer",JSONPath=".status.updated",description="Edge clusters updated"
+// +kubebuilder:printcolumn:name="Ready",type="integer",JSONPath=".status.ready",description="Edge clusters ready"
+type EdgeTaskSet struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              EdgeTaskSetSpec   `json:"spec,omitempty"`
+	Status            EdgeTaskSetStatus `json:"status,omitempty"`
+}
+
+type EdgeTaskSetSpec struct {
+	// Selector selects edge clusters by labels
+	Selector *metav1.LabelSelector `json:"selector,omitempty"`
+	// ManufactureSelector selects edge clusters by manufacture in status
+	ManufactureSelector *metav1.LabelSelector `json:"manufactureSelector,omitempty"`
+	Template            EdgeTaskTemplate      `json:"template,omitempty"`
+	Overrides           []EdgeTaskOverride    `json:"overrides,omitempty"`
+	Strategy            EdgeTaskSetStrategy   `json:"strategy,omitempty"`
+	// Paused stops updating edge tasks
+	Paused bool `json:"paused,omitempty"`
+}
+
+type EdgeTaskTemplate struct {
+	Labels      map[string]string `json:"labels,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	// +kubebuilder:pruning:PreserveUnknownFields
+	Resources []runtime.RawExtension `json:"resources,omitempty"`
+}
+
+// EdgeTaskOverride patches template resources of the edge clusters.
+// A patch applies to the resource with same apiVersion,kind,name and namespace(if set) in json merge patch.
+type EdgeTaskOverride struct {
+	EdgeClusterNames []string `json:"edgeClusterNames,omitempty"`
+	// +kubebuilder:pruning:PreserveUnknownFields
+	Patches []runtime.RawExtension `json:"patches,omitempty"`
+}
+
+type EdgeTaskSetStrategy struct {
+	// BatchSize is the max number of edge clusters updated at once,default 1
+	BatchSize *intstr.IntOrString `json:"batchSize,omitempty"`
+	// MaxUnavailable is the max number of edge clusters not ready during rollout,default same as batch size
+	MaxUnavailable *intstr.IntOrString `json:"maxUnavailable,omitempty"`
+	// PauseOnFailure stops rollout once an updated edge task failed
+	PauseOnFailure bool `json:"pauseOnFailure,omitempty"`
+}
+
+type EdgeTaskSetPhase string
+
+const (
+	EdgeTaskSetPhaseProgressing EdgeTaskSetPhase = "Progressing"
+	EdgeTaskSetPhaseCompleted   EdgeTaskSetPhase = "Completed"
+	EdgeTaskSetPhasePaused      EdgeTaskSetPhase = "Paused"
+	EdgeTaskSetPhaseFailed      EdgeTaskSetPhase = "Failed"
+	EdgeTaskSetPhaseNoTargets   EdgeTaskSetPhase = "NoTargets" // no edge cluster selected
+)
+
+type EdgeTaskSetStatus struct {
+	Phase          EdgeTaskSetPhase `json:"phase,omitempty"`
+	Message        string           `json:"message,omitempty"`
+	Clusters       int32            `json:"clusters,omitempty"`       // selected edge clusters
+	Updated        int32            `json:"updated,omitempty"`        // edge clusters with latest template
+	Ready          int32            `json:"ready,omitempty"`          // edge clusters updated and all resources ready
+	Failed         int32            `json:"failed,omitempty"`         // edge clusters updated but failed
+	Resources      int32            `json:"resources,omitempty"`      // resources of all edge tasks
+	ResourcesReady int32            `json:"resourcesReady,omitempty"` // ready resources of all edge tasks
+	// +listType=map
+	// +listMapKey=edgeClusterName
+	Tasks []EdgeTaskSetTaskStatus `json:"tasks,omitempty"`
+}
+
+type EdgeTaskSetTaskStatus struct {
+	EdgeClusterName string        `json:"edgeClusterName"`
+	TaskName        string        `json:"taskName,omitempty"`
+	Phase           EdgeTaskPhase `json:"phase,omitempty"`
+	Updated         bool          `json:"updated,omitempty"`
+	Failed          bool          `json:"failed,omitempty"`
+	Resources       int32         `json:"resources,omitempty"`
+	ResourcesReady  int32         `json:"resourcesReady,omitempty"`
+	Message         string        `json:"message,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+type EdgeTaskSetList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []EdgeTaskSet `json:"items"`
+}
@@ -42,6 +42,7 @@ type RegisterInfo struct {
 	Image          string       `json:"image,omitempty"`          // edge certs
 	BootstrapToken string       `json:"bootstrapToken,omitempty"` // edge token
 	Certs          *Certs       `json:"certs,omitempty"`          // pre generated certs
+	Revoked        bool         `json:"revoked,omitempty"`        // revoked edge can not connect
 }
 
 type EdgePhase string
@@ -57,6 +58,7 @@ type EdgeClusterStatus struct {
 	Register    RegisterStatus    `json:"register,omitempty"`
 	Tunnel      TunnelStatus      `json:"tunnel,omitempty"`
 	Manufacture ManufactureStatus `json:"manufacture,omitempty"`
+	Certificate CertificateStatus `json:"certificate,omitempty"`
 }
 
 // +kubebuilder:object:root=true
@@ -92,6 +94,22 @@ type RegisterStatus struct {
 	URL               string       `json:"url,omitempty"`
 }
 
+type CertificateRotationPhase string
+
+const (
+	CertificateRotationPhaseSucceeded CertificateRotationPhase = "Succeeded"
+	CertificateRotationPhaseFailed    CertificateRotationPhase = "Failed"
+)
+
+type CertificateStatus struct {
+	IssuedAt         *metav1.Time             `json:"issuedAt,omitempty"`         // current certificate issued at
+	ExpiresAt        *metav1.Time             `json:"expiresAt,omitempty"`        // current certificate expires at
+	LastRotation     *metav1.Time             `json:"lastRotation,omitempty"`     // last rotation attempt
+	RotationPhase    CertificateRotationPhase `json:"rotationPhase,omitempty"`    // result of last rotation
+	RotationMessage  string                   `json:"rotationMessage,omitempty"`  // error message of last rotation
+	RotationFailures int                      `json:"rotationFailures,omitempty"` // continuous failures of rotation
+}
+
 type TunnelStatus struct {
 	Connected              bool         `json:"connected,omitempty"`
 	LastOnlineTimestamp    *metav1.Time `json:"lastOnlineTimestamp,omitempty"`
@@ -124,4 +142,6 @@ var _ = SchemeBuilder.Register(
 	&EdgeHubList{},
 	&EdgeTask{},
 	&EdgeTaskList{},
+	&EdgeTaskSet{},
+	&EdgeTaskSetList{},
 )
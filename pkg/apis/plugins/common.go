@@ -28,6 +28,15 @@ const (
 	AnnotationIgnoreOptionOnDelete = "OnDelete"
 )
 
+const (
+	// set "true" to hold the changes of bundle in PendingApproval phase until the plan is approved
+	AnnotationRequireApproval = "bundle.kubegems.io/require-approval"
+	// hash of the approved plan
+	AnnotationApprovedPlan = "bundle.kubegems.io/approved-plan"
+	// revision requested to rollback to,the stored manifests of it are applied until the spec changed
+	AnnotationRollbackRevision = "bundle.kubegems.io/rollback-revision"
+)
+
 const (
 	// mark a helm chart as a kubegems plugin
 	AnnotationIsPlugin = "plugins.kubegems.io/is-plugin"
@@ -58,6 +67,9 @@ const (
 
 	// specified which engine to render this plugin
 	AnnotationRenderBy = "plugins.kubegems.io/render-by"
+
+	// base64 encoded cosign signature of the chart archive
+	AnnotationSignature = "plugins.kubegems.io/signature"
 )
 
 const (
@@ -26,7 +26,7 @@ type PluginSpec struct {
 	// Kind bundle kind.
 	Kind BundleKind `json:"kind,omitempty"`
 
-	// URL is the URL of helm repository, git clone url, tarball url, s3 url, etc.
+	// URL is the URL of helm repository, git clone url, tarball url, oci url, s3 url, etc.
 	// +kubebuilder:validation:Required
 	URL string `json:"url,omitempty"`
 
@@ -40,6 +40,21 @@ type PluginSpec struct {
 	// Path is the path in a tarball to the chart/kustomize.
 	Path string `json:"path,omitempty"`
 
+	// AuthSecretRef is a reference to a secret in the namespace of the bundle,
+	// which contains credentials to access the URL.
+	// keys: username/password for oci registry(or a .dockerconfigjson),
+	// accessKey/secretKey/region/endpoint for s3, and optional insecureSkipVerify.
+	// +kubebuilder:validation:Optional
+	AuthSecretRef *corev1.LocalObjectReference `json:"authSecretRef,omitempty"`
+
+	// Digest is the expected digest of the downloaded bundle archive, e.g. "sha256:{hex}".
+	// +kubebuilder:validation:Optional
+	Digest string `json:"digest,omitempty"`
+
+	// Signature is used to verify the downloaded bundle archive.
+	// +kubebuilder:validation:Optional
+	Signature *BundleSignature `json:"signature,omitempty"`
+
 	// InstallNamespace is the namespace to install the bundle into.
 	// If not specified, the bundle will be installed into the namespace of the bundle.
 	InstallNamespace string `json:"installNamespace,omitempty"`
@@ -48,6 +63,11 @@ type PluginSpec struct {
 	// The bundle will be installed after all dependencies are exists.
 	Dependencies []corev1.ObjectReference `json:"dependencies,omitempty"` // dependends on other bundle
 
+	// Requirements are version constraints on other bundles in the same namespace.
+	// The bundle will be installed after all required bundles are installed with matched versions.
+	// +kubebuilder:validation:Optional
+	Requirements []BundleRequirement `json:"requirements,omitempty"`
+
 	// Values is a nested map of helm values.
 	// +kubebuilder:pruning:PreserveUnknownFields
 	// +kubebuilder:validation:Optional
@@ -59,6 +79,38 @@ type PluginSpec struct {
 	ValuesFrom []ValuesFrom `json:"valuesFrom,omitempty"`
 }
 
+type BundleRequirement struct {
+	// Name is the name of the required bundle.
+	Name string `json:"name"`
+
+	// Version is a semver range of the required bundle, e.g. ">=1.2 <2".
+	// Any version is matched if empty.
+	// +kubebuilder:validation:Optional
+	Version string `json:"version,omitempty"`
+}
+
+// +kubebuilder:validation:Enum=provenance;cosign
+type SignatureKind string
+
+const (
+	SignatureKindProvenance SignatureKind = "provenance" // helm provenance file
+	SignatureKindCosign     SignatureKind = "cosign"     // cosign sign-blob signature
+)
+
+type BundleSignature struct {
+	// Kind is the kind of signature.
+	Kind SignatureKind `json:"kind"`
+
+	// Signature is the base64 encoded signature for cosign.
+	// For provenance, it is the url of .prov file, default to "{chart url}.prov".
+	// +kubebuilder:validation:Optional
+	Signature string `json:"signature,omitempty"`
+
+	// PublicKey is the PEM encoded public key for cosign,
+	// or the armored PGP keyring for provenance.
+	PublicKey string `json:"publicKey"`
+}
+
 const (
 	ValuesFromKindConfigmap = "ConfigMap"
 	ValuesFromKindSecret    = "Secret"
@@ -109,6 +161,30 @@ type PluginStatus struct {
 
 	// Resources is a list of resources created/managed by the bundle.
 	Resources []ManagedResource `json:"resources,omitempty"`
+
+	// AppliedPlan is the hash of the last applied plan,
+	// only recorded when approval is required.
+	AppliedPlan string `json:"appliedPlan,omitempty"`
+
+	// Revisions are the latest successful applied revisions of the bundle, the newest is the last.
+	// Rendered manifests and values of a revision are stored in secret "{name}-revision-{revision}".
+	Revisions []PluginRevision `json:"revisions,omitempty"`
+
+	// RolledBackPlan is the hash of the plan which was rolled back,
+	// it will not be applied again until the bundle changed.
+	RolledBackPlan string `json:"rolledBackPlan,omitempty"`
+
+	// Conditions of the bundle.
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+type PluginRevision struct {
+	Revision  int64       `json:"revision"`
+	Version   string      `json:"version,omitempty"`
+	Plan      string      `json:"plan,omitempty"`
+	Timestamp metav1.Time `json:"timestamp,omitempty"`
 }
 
 type ManagedResource struct {
@@ -129,8 +205,30 @@ const (
 	BundleKindTemplate  BundleKind = "template"
 )
 
+const (
+	// ConditionTypeVerified indicates the downloaded bundle passed digest and signature verification.
+	ConditionTypeVerified = "Verified"
+
+	ConditionReasonVerified           = "Verified"
+	ConditionReasonVerificationFailed = "VerificationFailed"
+
+	// ConditionTypeHealthy indicates the workloads of the applied bundle are healthy.
+	ConditionTypeHealthy = "Healthy"
+
+	ConditionReasonHealthy     = "Healthy"
+	ConditionReasonProgressing = "Progressing"
+	ConditionReasonUnhealthy   = "Unhealthy"
+
+	// ConditionTypeRolledBack indicates the bundle was rolled back to a previous revision.
+	ConditionTypeRolledBack = "RolledBack"
+
+	ConditionReasonApplyFailed = "ApplyFailed"
+)
+
 const (
 	PhaseDisabled  Phase = "Disabled"  // Bundle is disabled. the .spce.disbaled field is set to true or DeletionTimestamp is set.
 	PhaseFailed    Phase = "Failed"    // Failed on install.
 	PhaseInstalled Phase = "Installed" // Bundle is installed
+	// Changes of bundle are waiting for approval, the previous installed version is kept.
+	PhasePendingApproval Phase = "PendingApproval"
 )
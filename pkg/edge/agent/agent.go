@@ -17,7 +17,6 @@ package agent
 import (
 	"bytes"
 	"context"
-	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -58,10 +57,6 @@ type EdgeAgent struct {
 func Run(ctx context.Context, options *Options) error {
 	ctx = log.NewContext(ctx, log.LogrLogger)
 
-	tlsconfig := &tls.Config{
-		InsecureSkipVerify: options.TLS.InsecureSkipVerify,
-	}
-
 	c, err := cluster.NewLocalAgentClusterAndStart(ctx)
 	if err != nil {
 		return err
@@ -77,6 +72,15 @@ func Run(ctx context.Context, options *Options) error {
 	if err != nil {
 		return err
 	}
+	certs, err := NewCertificateManager(c.GetClient(), clientid, options.Token, options.TLS)
+	if err != nil {
+		return err
+	}
+	// client certificate is reloaded on rotation,connected tunnel keeps alive
+	tlsconfig := certs.TLSConfig(options.TLS.InsecureSkipVerify)
+
+	tunserver := tunnel.NewTunnelServer(clientid, nil)
+	tunserver.SetFlowControl(options.FlowControl)
 	ea := &EdgeAgent{
 		config:       c.GetConfig(),
 		manufectures: manufectures,
@@ -84,13 +88,13 @@ func Run(ctx context.Context, options *Options) error {
 		options:      options,
 		annotations:  nil,
 		cluster:      c,
-		httpapi:      &AgentAPI{cluster: c},
-		tunserver:    tunnel.GrpcTunnelServer{TunnelServer: tunnel.NewTunnelServer(clientid, nil)},
+		httpapi:      &AgentAPI{cluster: c, certs: certs},
+		tunserver:    tunnel.GrpcTunnelServer{TunnelServer: tunserver},
 	}
 
 	eg, ctx := errgroup.WithContext(ctx)
 	eg.Go(func() error {
-		return ea.tunserver.ConnectUpstreamWithRetry(ctx, options.EdgeHubAddr, tlsconfig, "", ea.getAnnotations(ctx))
+		return ea.tunserver.ConnectUpstreamWithRetry(ctx, options.EdgeHubAddr, tlsconfig, options.Token, ea.getAnnotations(ctx))
 	})
 	eg.Go(func() error {
 		return ea.RunKeepAliveRouter(ctx, ea.options.KeepAliveInterval, ea.getAnnotations)
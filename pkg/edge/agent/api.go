@@ -16,16 +16,19 @@ package agent
 
 import (
 	"context"
+	"net/http"
 
 	"github.com/gin-gonic/gin"
 	"kubegems.io/kubegems/pkg/agent/apis"
 	"kubegems.io/kubegems/pkg/agent/cluster"
+	"kubegems.io/kubegems/pkg/apis/edge/common"
 	"kubegems.io/kubegems/pkg/log"
 	"kubegems.io/kubegems/pkg/utils/system"
 )
 
 type AgentAPI struct {
 	cluster *cluster.Cluster
+	certs   *CertificateManager
 }
 
 func (a *AgentAPI) Run(ctx context.Context, listen string) error {
@@ -43,5 +46,9 @@ func (a *AgentAPI) Run(ctx context.Context, listen string) error {
 		return err
 	}
 	ginr.Any("/*path", ginhandler)
-	return system.ListenAndServeContext(ctx, listen, nil, ginr)
+
+	mux := http.NewServeMux()
+	mux.Handle(common.EdgeAgentCertificatePath, a.certs)
+	mux.Handle("/", ginr)
+	return system.ListenAndServeContext(ctx, listen, nil, mux)
 }
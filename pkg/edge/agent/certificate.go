@@ -0,0 +1,260 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"kubegems.io/kubegems/pkg/apis/edge/v1beta1"
+	"kubegems.io/kubegems/pkg/log"
+	"kubegems.io/kubegems/pkg/utils/httputil/response"
+	"kubegems.io/kubegems/pkg/utils/kube"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// CertificateManager holds the client certificate of the agent,
+// the certificate can be replaced at runtime without reconnecting.
+type CertificateManager struct {
+	clientID string
+	token    string // bootstrap token,pushed certificates must be sent with it
+	secret   string
+	cli      client.Client
+	roots    *x509.CertPool // trusted ca from mounted secret
+
+	mu      sync.RWMutex
+	current *tls.Certificate
+	issuer  *x509.Certificate
+}
+
+func NewCertificateManager(cli client.Client, clientID string, token string, options *ClientTLS) (*CertificateManager, error) {
+	m := &CertificateManager{clientID: clientID, token: token, secret: options.CertsSecret, cli: cli}
+	if options.CAFile != "" {
+		capem, err := os.ReadFile(options.CAFile)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		if len(capem) != 0 {
+			m.roots = x509.NewCertPool()
+			if !m.roots.AppendCertsFromPEM(capem) {
+				return nil, fmt.Errorf("no valid ca certificate in %s", options.CAFile)
+			}
+		}
+	}
+	if options.CertFile == "" || options.KeyFile == "" {
+		return m, nil
+	}
+	certpem, err := os.ReadFile(options.CertFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			log.Info("no client certificate found", "file", options.CertFile)
+			return m, nil
+		}
+		return nil, err
+	}
+	keypem, err := os.ReadFile(options.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	if err := m.set(certpem, keypem); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// TLSConfig returns a tls config which always use the latest client certificate
+func (m *CertificateManager) TLSConfig(insecureSkipVerify bool) *tls.Config {
+	return &tls.Config{
+		InsecureSkipVerify:   insecureSkipVerify,
+		GetClientCertificate: m.GetClientCertificate,
+	}
+}
+
+func (m *CertificateManager) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.current == nil {
+		// send no certificate
+		return &tls.Certificate{}, nil
+	}
+	return m.current, nil
+}
+
+// NotAfter returns the expiration of current certificate
+func (m *CertificateManager) NotAfter() time.Time {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.current == nil || m.current.Leaf == nil {
+		return time.Time{}
+	}
+	return m.current.Leaf.NotAfter
+}
+
+// Rotate validates the certificate and persists it into secret,then replace current certificate,
+// established connections keep using the previous one until reconnected.
+func (m *CertificateManager) Rotate(ctx context.Context, certs v1beta1.Certs) error {
+	cert, err := tls.X509KeyPair(certs.Cert, certs.Key)
+	if err != nil {
+		return err
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return err
+	}
+	if leaf.Subject.CommonName != m.clientID {
+		return fmt.Errorf("certificate common name %s not match client id %s", leaf.Subject.CommonName, m.clientID)
+	}
+	if now := time.Now(); now.Before(leaf.NotBefore) || now.After(leaf.NotAfter) {
+		return fmt.Errorf("certificate is not valid at %s", now.Format(time.RFC3339))
+	}
+	// the new certificate must be issued by the trusted ca
+	roots := m.trustedRoots()
+	if roots == nil {
+		return errors.New("no trusted ca to verify certificate")
+	}
+	intermediates := x509.NewCertPool()
+	for _, raw := range cert.Certificate[1:] {
+		if c, err := x509.ParseCertificate(raw); err == nil {
+			intermediates.AddCert(c)
+		}
+	}
+	verifyopts := x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}
+	if _, err := leaf.Verify(verifyopts); err != nil {
+		return fmt.Errorf("verify certificate: %w", err)
+	}
+	// persist first so that the certificate survives restarts,
+	// then it's used by new connections immediately.
+	if err := m.save(ctx, certs); err != nil {
+		return fmt.Errorf("save certificate: %w", err)
+	}
+	return m.set(certs.Cert, certs.Key)
+}
+
+// trustedRoots returns ca from mounted secret,
+// falls back to the issuer of current certificate if no ca mounted.
+func (m *CertificateManager) trustedRoots() *x509.CertPool {
+	if m.roots != nil {
+		return m.roots
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.issuer == nil {
+		return nil
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(m.issuer)
+	return roots
+}
+
+func (m *CertificateManager) set(certpem, keypem []byte) error {
+	cert, err := tls.X509KeyPair(certpem, keypem)
+	if err != nil {
+		return err
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return err
+	}
+	cert.Leaf = leaf
+	var issuer *x509.Certificate
+	// issued certificate is followed by issuer
+	if len(cert.Certificate) > 1 {
+		if issuer, err = x509.ParseCertificate(cert.Certificate[1]); err != nil {
+			return err
+		}
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.current, m.issuer = &cert, issuer
+	return nil
+}
+
+func (m *CertificateManager) save(ctx context.Context, certs v1beta1.Certs) error {
+	if m.cli == nil || m.secret == "" {
+		return nil
+	}
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      m.secret,
+			Namespace: kube.LocalNamespaceOrDefault("kubegems-edge"),
+		},
+	}
+	_, err := controllerutil.CreateOrPatch(ctx, m.cli, secret, func() error {
+		if secret.Data == nil {
+			secret.Data = map[string][]byte{}
+		}
+		secret.Data[corev1.TLSCertKey] = certs.Cert
+		secret.Data[corev1.TLSPrivateKeyKey] = certs.Key
+		if len(certs.CA) != 0 {
+			secret.Data[corev1.ServiceAccountRootCAKey] = certs.CA
+		}
+		return nil
+	})
+	return err
+}
+
+// ServeHTTP receives rotated certificates pushed by edge server
+func (m *CertificateManager) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		response.Error(w, response.NewError(http.StatusMethodNotAllowed, "method not allowed"))
+		return
+	}
+	if err := m.authenticate(r); err != nil {
+		response.Error(w, err)
+		return
+	}
+	certs := v1beta1.Certs{}
+	if err := json.NewDecoder(r.Body).Decode(&certs); err != nil {
+		response.BadRequest(w, err.Error())
+		return
+	}
+	if err := m.Rotate(r.Context(), certs); err != nil {
+		log.Error(err, "rotate certificate")
+		response.BadRequest(w, err.Error())
+		return
+	}
+	notafter := m.NotAfter()
+	log.Info("certificate rotated", "expiresAt", notafter)
+	response.OK(w, map[string]any{"expiresAt": notafter})
+}
+
+// authenticate checks the bootstrap token sent by edge server
+func (m *CertificateManager) authenticate(r *http.Request) error {
+	if m.token == "" {
+		return response.NewError(http.StatusForbidden, "certificate rotation requires bootstrap token")
+	}
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(m.token)) != 1 {
+		return response.NewError(http.StatusUnauthorized, "invalid token")
+	}
+	return nil
+}
@@ -0,0 +1,167 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"k8s.io/client-go/util/cert"
+	"k8s.io/client-go/util/keyutil"
+	"kubegems.io/kubegems/pkg/apis/edge/v1beta1"
+	"kubegems.io/kubegems/pkg/utils/certificate"
+)
+
+func TestCertificateManager_Rotate(t *testing.T) {
+	ctx := context.Background()
+	cacert, cakey := testCA(t, "edge-ca")
+	othercert, otherkey := testCA(t, "other-ca")
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(cacert)
+
+	issue := func(cacert, cakey []byte, cn string, expire time.Time) v1beta1.Certs {
+		certpem, keypem, err := certificate.IssueCertificate(cacert, cacert, cakey, certificate.CertOptions{CommonName: cn, ExpireAt: &expire})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v1beta1.Certs{CA: cacert, Cert: certpem, Key: keypem}
+	}
+	m := &CertificateManager{clientID: "edge-1", roots: roots}
+
+	tests := []struct {
+		name    string
+		certs   v1beta1.Certs
+		wantErr bool
+	}{
+		{name: "issued by trusted ca", certs: issue(cacert, cakey, "edge-1", time.Now().Add(time.Hour))},
+		{name: "common name not match", certs: issue(cacert, cakey, "edge-2", time.Now().Add(2*time.Hour)), wantErr: true},
+		{name: "issued by untrusted ca", certs: issue(othercert, otherkey, "edge-1", time.Now().Add(2*time.Hour)), wantErr: true},
+		{name: "expired", certs: issue(cacert, cakey, "edge-1", time.Now().Add(-time.Minute)), wantErr: true},
+		{name: "invalid key pair", certs: v1beta1.Certs{Cert: issue(cacert, cakey, "edge-1", time.Now().Add(2*time.Hour)).Cert, Key: cakey}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := m.NotAfter()
+			err := m.Rotate(ctx, tt.certs)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Rotate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				if !m.NotAfter().Equal(before) {
+					t.Errorf("certificate replaced on error")
+				}
+				return
+			}
+			// used by new connections immediately
+			current, err := m.GetClientCertificate(nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if current.Leaf == nil || current.Leaf.Subject.CommonName != "edge-1" || !current.Leaf.NotAfter.Equal(m.NotAfter()) {
+				t.Errorf("client certificate not replaced")
+			}
+		})
+	}
+}
+
+func TestCertificateManager_RotateWithoutCA(t *testing.T) {
+	cacert, cakey := testCA(t, "edge-ca")
+	expire := time.Now().Add(time.Hour)
+	certpem, keypem, err := certificate.IssueCertificate(cacert, cacert, cakey, certificate.CertOptions{CommonName: "edge-1", ExpireAt: &expire})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &CertificateManager{clientID: "edge-1"}
+	if err := m.Rotate(context.Background(), v1beta1.Certs{Cert: certpem, Key: keypem}); err == nil {
+		t.Fatal("Rotate() without trusted ca: want error")
+	}
+	// the issuer of current certificate is trusted
+	if err := m.set(certpem, keypem); err != nil {
+		t.Fatal(err)
+	}
+	expire = expire.Add(time.Hour)
+	certpem, keypem, err = certificate.IssueCertificate(cacert, cacert, cakey, certificate.CertOptions{CommonName: "edge-1", ExpireAt: &expire})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Rotate(context.Background(), v1beta1.Certs{Cert: certpem, Key: keypem}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCertificateManager_ServeHTTP(t *testing.T) {
+	cacert, cakey := testCA(t, "edge-ca")
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(cacert)
+	expire := time.Now().Add(time.Hour)
+	certpem, keypem, err := certificate.IssueCertificate(cacert, cacert, cakey, certificate.CertOptions{CommonName: "edge-1", ExpireAt: &expire})
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := json.Marshal(v1beta1.Certs{CA: cacert, Cert: certpem, Key: keypem})
+
+	tests := []struct {
+		name       string
+		token      string
+		method     string
+		auth       string
+		wantStatus int
+	}{
+		{name: "no bootstrap token", token: "", method: http.MethodPut, auth: "Bearer ", wantStatus: http.StatusForbidden},
+		{name: "invalid token", token: "token", method: http.MethodPut, auth: "Bearer other", wantStatus: http.StatusUnauthorized},
+		{name: "method not allowed", token: "token", method: http.MethodPost, auth: "Bearer token", wantStatus: http.StatusMethodNotAllowed},
+		{name: "rotated", token: "token", method: http.MethodPut, auth: "Bearer token", wantStatus: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &CertificateManager{clientID: "edge-1", token: tt.token, roots: roots}
+			req := httptest.NewRequest(tt.method, "/internal/edge/certificate", bytes.NewReader(body))
+			req.Header.Set("Authorization", tt.auth)
+			rec := httptest.NewRecorder()
+			m.ServeHTTP(rec, req)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("ServeHTTP() status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if rotated := !m.NotAfter().IsZero(); rotated != (tt.wantStatus == http.StatusOK) {
+				t.Errorf("certificate rotated = %v", rotated)
+			}
+		})
+	}
+}
+
+func testCA(t *testing.T, cn string) ([]byte, []byte) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := cert.NewSelfSignedCACert(cert.Config{CommonName: cn}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keypem, err := keyutil.MarshalPrivateKeyToPEM(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: cert.CertificateBlockType, Bytes: ca.Raw}), keypem
+}
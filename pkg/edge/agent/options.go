@@ -14,27 +14,38 @@
 
 package agent
 
-import "time"
+import (
+	"time"
+
+	"kubegems.io/kubegems/pkg/edge/tunnel"
+)
 
 const (
 	ClientIDSecret           = "kubegems-edge-agent-id"
+	CertsSecret              = "kubegems-edge-agent-secret"
 	DefaultKeepAliveInterval = 30 * time.Minute
 )
 
 type Options struct {
-	Listen            string        `json:"listen,omitempty"`
-	DeviceID          string        `json:"deviceID,omitempty" description:"device id in kubegems edge,use random generated client-id by default"`
-	DeviceIDKey       string        `json:"deviceIDKey,omitempty" description:"use value of key as device-id in manufacture"`
-	ManufactureFile   []string      `json:"manufactureFile,omitempty" description:"file with manufacture info in json object format"`
-	ManufactureRemap  []string      `json:"manufactureRemap,omitempty" description:"remap manufacture file key to newkey,example 'newkey=existskey'"`
-	Manufacture       []string      `json:"manufacture,omitempty" description:"manufacture kvs,example 'some-key=value,foo=bar'"`
-	EdgeHubAddr       string        `json:"edgeHubAddr,omitempty"`
-	KeepAliveInterval time.Duration `json:"keepAliveInterval,omitempty"`
-	TLS               *ClientTLS    `json:"tls,omitempty" description:"skip server tls verify"`
+	Listen            string                     `json:"listen,omitempty"`
+	DeviceID          string                     `json:"deviceID,omitempty" description:"device id in kubegems edge,use random generated client-id by default"`
+	DeviceIDKey       string                     `json:"deviceIDKey,omitempty" description:"use value of key as device-id in manufacture"`
+	ManufactureFile   []string                   `json:"manufactureFile,omitempty" description:"file with manufacture info in json object format"`
+	ManufactureRemap  []string                   `json:"manufactureRemap,omitempty" description:"remap manufacture file key to newkey,example 'newkey=existskey'"`
+	Manufacture       []string                   `json:"manufacture,omitempty" description:"manufacture kvs,example 'some-key=value,foo=bar'"`
+	EdgeHubAddr       string                     `json:"edgeHubAddr,omitempty"`
+	Token             string                     `json:"token,omitempty" description:"bootstrap token to connect edge hub,also authenticates certificate rotation"`
+	KeepAliveInterval time.Duration              `json:"keepAliveInterval,omitempty"`
+	TLS               *ClientTLS                 `json:"tls,omitempty" description:"skip server tls verify"`
+	FlowControl       *tunnel.FlowControlOptions `json:"flowControl,omitempty"`
 }
 
 type ClientTLS struct {
-	InsecureSkipVerify bool `json:"insecureSkipVerify,omitempty"`
+	InsecureSkipVerify bool   `json:"insecureSkipVerify,omitempty"`
+	CAFile             string `json:"caFile,omitempty" description:"ca of edge server,rotated certificates must be issued by it"`
+	CertFile           string `json:"certFile,omitempty" description:"client certificate,reloaded on rotation"`
+	KeyFile            string `json:"keyFile,omitempty"`
+	CertsSecret        string `json:"certsSecret,omitempty" description:"secret to persist rotated certificates"`
 }
 
 func NewDefaultOptions() *Options {
@@ -47,6 +58,12 @@ func NewDefaultOptions() *Options {
 		ManufactureFile:   []string{"/etc/os-release"},
 		ManufactureRemap:  []string{},
 		Manufacture:       []string{},
-		TLS:               &ClientTLS{},
+		TLS: &ClientTLS{
+			CAFile:      "certs/ca.crt",
+			CertFile:    "certs/tls.crt",
+			KeyFile:     "certs/tls.key",
+			CertsSecret: CertsSecret,
+		},
+		FlowControl: tunnel.NewDefaultFlowControlOptions(),
 	}
 }
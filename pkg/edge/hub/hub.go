@@ -20,6 +20,7 @@ import (
 	"net/http"
 
 	"golang.org/x/sync/errgroup"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"kubegems.io/kubegems/pkg/apis/edge/common"
 	"kubegems.io/kubegems/pkg/edge/tunnel"
 	"kubegems.io/kubegems/pkg/log"
@@ -47,6 +48,14 @@ func New(options *Options) (*EdgeHubServer, error) {
 		return nil, err
 	}
 	cert, key := certificate.EncodeToX509Pair(tlsConfig.Certificates[0])
+	var auth tunnel.AuthenticationManager
+	if options.Token != "" {
+		// credentials of edge clusters are synchronized from edge server,
+		// hub verifies but never issues session tokens
+		auth = tunnel.NewHubTokenAuthManager()
+	}
+	tunserver := tunnel.NewTunnelServer(options.ServerID, auth)
+	tunserver.SetFlowControl(options.FlowControl)
 	hub := &EdgeHubServer{
 		upstreamAnnotations: map[string]string{
 			common.AnnotationKeyEdgeHubAddress: options.Host,
@@ -54,7 +63,7 @@ func New(options *Options) (*EdgeHubServer, error) {
 			common.AnnotationKeyEdgeHubKey:     string(key),
 		},
 		GrpcTunnelServer: tunnel.GrpcTunnelServer{
-			TunnelServer: tunnel.NewTunnelServer(options.ServerID, nil),
+			TunnelServer: tunserver,
 		},
 		tlsConfig: tlsConfig,
 		options:   options,
@@ -91,7 +100,12 @@ func (s *EdgeHubServer) Run(ctx context.Context) error {
 	eg.Go(func() error {
 		c := s.tlsConfig.Clone()
 		c.InsecureSkipVerify = true
-		return s.ConnectUpstreamWithRetry(ctx, s.options.EdgeServerAddr, c, "", s.upstreamAnnotations)
+		return wait.PollImmediateInfiniteWithContext(ctx, tunnel.DefaultRetryInterval, func(ctx context.Context) (bool, error) {
+			if err := s.ConnectUpstream(ctx, s.options.EdgeServerAddr, c, s.options.Token, s.upstreamAnnotations); err != nil {
+				log.Error(err, "on connect upstream")
+			}
+			return false, nil
+		})
 	})
 	eg.Go(func() error {
 		return pprof.Run(ctx)
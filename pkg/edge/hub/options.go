@@ -14,15 +14,20 @@
 
 package hub
 
-import "kubegems.io/kubegems/pkg/utils/system"
+import (
+	"kubegems.io/kubegems/pkg/edge/tunnel"
+	"kubegems.io/kubegems/pkg/utils/system"
+)
 
 type Options struct {
-	Listen         string      `json:"listen,omitempty"`
-	ListenGrpc     string      `json:"listenGrpc,omitempty"`
-	Host           string      `json:"host,omitempty" validate:"required"`
-	ServerID       string      `json:"serverID,omitempty" validate:"required"`
-	TLS            *system.TLS `json:"tls,omitempty"`
-	EdgeServerAddr string      `json:"edgeServerAddr,omitempty"`
+	Listen         string                     `json:"listen,omitempty"`
+	ListenGrpc     string                     `json:"listenGrpc,omitempty"`
+	Host           string                     `json:"host,omitempty" validate:"required"`
+	ServerID       string                     `json:"serverID,omitempty" validate:"required"`
+	TLS            *system.TLS                `json:"tls,omitempty"`
+	EdgeServerAddr string                     `json:"edgeServerAddr,omitempty"`
+	FlowControl    *tunnel.FlowControlOptions `json:"flowControl,omitempty"`
+	Token          string                     `json:"token,omitempty" description:"token to connect edge server,same as hubToken of edge server,enable token authentication of edge clusters if set"`
 }
 
 func NewDefaultOptions() *Options {
@@ -32,5 +37,6 @@ func NewDefaultOptions() *Options {
 		TLS:            system.NewDefaultTLS(),
 		ServerID:       "",
 		EdgeServerAddr: "127.0.0.1:50052",
+		FlowControl:    tunnel.NewDefaultFlowControlOptions(),
 	}
 }
@@ -15,13 +15,12 @@
 package server
 
 import (
-	"strings"
-
 	"github.com/emicklei/go-restful/v3"
 	"github.com/google/uuid"
 	"k8s.io/apimachinery/pkg/labels"
 	"kubegems.io/kubegems/pkg/apis/edge/v1beta1"
 	"kubegems.io/kubegems/pkg/edge/tunnel"
+	"kubegems.io/kubegems/pkg/log"
 	"kubegems.io/kubegems/pkg/utils/httputil/request"
 	"kubegems.io/kubegems/pkg/utils/httputil/response"
 	"kubegems.io/kubegems/pkg/utils/route"
@@ -30,6 +29,7 @@ import (
 type EdgeClusterAPI struct {
 	Cluster *EdgeManager
 	Tunnel  *tunnel.TunnelServer
+	Auth    *tunnel.TokenAuthManager // nil if token authentication disabled
 }
 
 func (a *EdgeClusterAPI) ListEdgeClusters(req *restful.Request, resp *restful.Response) {
@@ -83,7 +83,7 @@ func (a *EdgeClusterAPI) PreCreateEdgeCluster(req *restful.Request, resp *restfu
 		cluster.Name = uuid.NewString()
 	}
 	if cluster.Spec.Register.BootstrapToken == "" {
-		cluster.Spec.Register.BootstrapToken = strings.ReplaceAll(uuid.NewString(), "-", "")
+		cluster.Spec.Register.BootstrapToken = NewBootstrapToken()
 	}
 	created, err := a.Cluster.PreCreate(req.Request.Context(), cluster)
 	if err != nil {
@@ -135,6 +135,46 @@ func (a *EdgeClusterAPI) UpdateEdgeCluster(req *restful.Request, resp *restful.R
 	}
 }
 
+func (a *EdgeClusterAPI) RotateBootstrapToken(req *restful.Request, resp *restful.Response) {
+	uid := req.PathParameter("uid")
+	cluster, err := a.Cluster.RotateBootstrapToken(req.Request.Context(), uid)
+	if err != nil {
+		response.Error(resp, err)
+		return
+	}
+	a.syncCredentials(req, cluster)
+	response.OK(resp, cluster)
+}
+
+func (a *EdgeClusterAPI) RevokeEdgeCluster(req *restful.Request, resp *restful.Response) {
+	a.setRevoked(req, resp, true)
+}
+
+func (a *EdgeClusterAPI) UnrevokeEdgeCluster(req *restful.Request, resp *restful.Response) {
+	a.setRevoked(req, resp, false)
+}
+
+func (a *EdgeClusterAPI) setRevoked(req *restful.Request, resp *restful.Response, revoked bool) {
+	uid := req.PathParameter("uid")
+	cluster, err := a.Cluster.SetRevoked(req.Request.Context(), uid, revoked)
+	if err != nil {
+		response.Error(resp, err)
+		return
+	}
+	a.syncCredentials(req, cluster)
+	response.OK(resp, cluster)
+}
+
+// syncCredentials send credentials to the hub of edge cluster immediately
+func (a *EdgeClusterAPI) syncCredentials(req *restful.Request, cluster *v1beta1.EdgeCluster) {
+	if a.Auth == nil {
+		return
+	}
+	if err := a.Cluster.SyncCredentials(req.Request.Context(), a.Tunnel, a.Auth, cluster.Spec.Register.HubName); err != nil {
+		log.Error(err, "sync credentials", "cluster", cluster.Name)
+	}
+}
+
 func (a *EdgeClusterAPI) InstallAgentTemplate(req *restful.Request, resp *restful.Response) {
 	uid, token := req.PathParameter("uid"), request.Query(req.Request, "token", "")
 	rendered, err := a.Cluster.RenderInstallManifests(req.Request.Context(), uid, token)
@@ -204,6 +244,15 @@ func (a *EdgeClusterAPI) RegisterRoute(r *route.Group) {
 			route.DELETE("/{uid}").To(a.RemoveEdgeCluster).Parameters(
 				route.PathParameter("uid", "uid name"),
 			),
+			route.POST("/{uid}/bootstrap-token").To(a.RotateBootstrapToken).ShortDesc("rotate bootstrap token").Parameters(
+				route.PathParameter("uid", "uid name"),
+			).Response(v1beta1.EdgeCluster{}),
+			route.POST("/{uid}/revoke").To(a.RevokeEdgeCluster).ShortDesc("revoke edge cluster and disconnect it").Parameters(
+				route.PathParameter("uid", "uid name"),
+			).Response(v1beta1.EdgeCluster{}),
+			route.DELETE("/{uid}/revoke").To(a.UnrevokeEdgeCluster).ShortDesc("cancel revocation of edge cluster").Parameters(
+				route.PathParameter("uid", "uid name"),
+			).Response(v1beta1.EdgeCluster{}),
 		).AddSubGroup(
 			route.NewGroup("/{uid}/proxy/{path:*}").Tag("proxy").Parameters(
 				route.PathParameter("uid", "uid name"),
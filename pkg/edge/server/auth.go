@@ -0,0 +1,166 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"kubegems.io/kubegems/pkg/apis/edge/common"
+	"kubegems.io/kubegems/pkg/apis/edge/v1beta1"
+	"kubegems.io/kubegems/pkg/edge/tunnel"
+	"kubegems.io/kubegems/pkg/log"
+)
+
+const DefaultCredentialsSyncInterval = time.Minute
+
+// BootstrapToken returns bootstrap token of the edge cluster
+func (m *EdgeManager) BootstrapToken(ctx context.Context, name string) (string, error) {
+	cluster, err := m.ClusterStore.Get(ctx, name)
+	if err != nil {
+		return "", err
+	}
+	return cluster.Spec.Register.BootstrapToken, nil
+}
+
+// BootstrapTokenGetter returns bootstrap token of edge clusters.
+// Peers connected to the server directly which are not edge clusters are hubs and use hubtoken,
+// peers behind hubs are edge clusters and never fall back to hubtoken.
+func (m *EdgeManager) BootstrapTokenGetter(hubtoken string) tunnel.BootstrapTokenGetter {
+	return func(ctx context.Context, name string) (string, error) {
+		token, err := m.BootstrapToken(ctx, name)
+		if apierrors.IsNotFound(err) && tunnel.IsDirectPeer(ctx) {
+			return hubtoken, nil
+		}
+		return token, err
+	}
+}
+
+// Credentials returns bootstrap token hashes of edge clusters by hub,and all revoked edge clusters
+func (m *EdgeManager) Credentials(ctx context.Context) (map[string]map[string]string, []string, error) {
+	_, list, err := m.ClusterStore.List(ctx, ListOptions{})
+	if err != nil {
+		return nil, nil, err
+	}
+	tokens, revoked := map[string]map[string]string{}, []string{}
+	for _, cluster := range list {
+		if cluster.Spec.Register.Revoked {
+			revoked = append(revoked, cluster.Name)
+			continue
+		}
+		hub, token := cluster.Spec.Register.HubName, cluster.Spec.Register.BootstrapToken
+		if hub == "" || token == "" {
+			continue
+		}
+		if tokens[hub] == nil {
+			tokens[hub] = map[string]string{}
+		}
+		tokens[hub][cluster.Name] = tunnel.HashBootstrapToken(token)
+	}
+	return tokens, revoked, nil
+}
+
+// SyncCredentials refresh revocation list and send credentials to hubs,send to all online hubs if no hub specified.
+func (m *EdgeManager) SyncCredentials(ctx context.Context, server *tunnel.TunnelServer, auth *tunnel.TokenAuthManager, hubs ...string) error {
+	tokens, revoked, err := m.Credentials(ctx)
+	if err != nil {
+		return err
+	}
+	auth.SetRevoked(revoked)
+	// disconnect revoked edge clusters connected to us
+	server.Revoke(revoked...)
+
+	if len(hubs) == 0 {
+		_, list, err := m.HubStore.List(ctx, ListOptions{})
+		if err != nil {
+			return err
+		}
+		for _, hub := range list {
+			if hub.Status.Tunnel.Connected {
+				hubs = append(hubs, hub.Name)
+			}
+		}
+	}
+	for _, hub := range hubs {
+		credentials := tunnel.PacketDataCredentials{Tokens: tokens[hub], Revoked: revoked, SessionKeys: auth.VerificationKeys()}
+		if err := server.SendCredentials(hub, credentials); err != nil {
+			log.Error(err, "send credentials", "hub", hub)
+		}
+	}
+	return nil
+}
+
+// SyncCredentialsTo send credentials to hubs on hub connected and periodically
+func (m *EdgeManager) SyncCredentialsTo(ctx context.Context, server *tunnel.TunnelServer, auth *tunnel.TokenAuthManager) error {
+	log.Info("start syncing credentials")
+	watcher := server.Wacth(ctx)
+	defer watcher.Close()
+
+	ticker := time.NewTicker(DefaultCredentialsSyncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if err := m.SyncCredentials(ctx, server, auth); err != nil {
+				log.Error(err, "sync credentials")
+			}
+		case event, ok := <-watcher.Result():
+			if !ok {
+				return nil
+			}
+			if event.Kind != tunnel.EventKindConnected {
+				continue
+			}
+			hubs := []string{}
+			for name, anno := range event.Peers {
+				if _, ok := anno[common.AnnotationKeyEdgeHubAddress]; ok {
+					hubs = append(hubs, name)
+				}
+			}
+			if len(hubs) == 0 {
+				continue
+			}
+			if err := m.SyncCredentials(ctx, server, auth, hubs...); err != nil {
+				log.Error(err, "sync credentials", "hubs", hubs)
+			}
+		}
+	}
+}
+
+// RotateBootstrapToken generate a new bootstrap token for edge cluster,the previous one is invalid
+func (m *EdgeManager) RotateBootstrapToken(ctx context.Context, name string) (*v1beta1.EdgeCluster, error) {
+	return m.ClusterStore.Update(ctx, name, func(cluster *v1beta1.EdgeCluster) error {
+		cluster.Spec.Register.BootstrapToken = NewBootstrapToken()
+		cluster.Status.Register.URL = m.registerURL(cluster.Name, cluster.Spec.Register.BootstrapToken)
+		return nil
+	})
+}
+
+// SetRevoked set edge cluster revoked or not
+func (m *EdgeManager) SetRevoked(ctx context.Context, name string, revoked bool) (*v1beta1.EdgeCluster, error) {
+	return m.ClusterStore.Update(ctx, name, func(cluster *v1beta1.EdgeCluster) error {
+		cluster.Spec.Register.Revoked = revoked
+		return nil
+	})
+}
+
+func NewBootstrapToken() string {
+	return strings.ReplaceAll(uuid.NewString(), "-", "")
+}
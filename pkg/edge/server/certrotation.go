@@ -0,0 +1,229 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"kubegems.io/kubegems/pkg/apis/edge/common"
+	"kubegems.io/kubegems/pkg/apis/edge/v1beta1"
+	"kubegems.io/kubegems/pkg/edge/tunnel"
+	"kubegems.io/kubegems/pkg/log"
+	"kubegems.io/kubegems/pkg/utils/certificate"
+)
+
+const (
+	DefaultCertRenewFraction  = 2.0 / 3
+	DefaultCertCheckInterval  = time.Hour
+	DefaultCertRotateTimeout  = 30 * time.Second
+	DefaultCertLifetime       = certificate.DurationYear
+	certRotationMessageMaxLen = 256
+)
+
+type CertRotationOptions struct {
+	Enabled       bool          `json:"enabled,omitempty"`
+	RenewFraction float64       `json:"renewFraction,omitempty" description:"renew edge certificate when the fraction of its lifetime passed"`
+	CheckInterval time.Duration `json:"checkInterval,omitempty"`
+	Lifetime      time.Duration `json:"lifetime,omitempty" description:"lifetime of renewed edge certificates"`
+}
+
+func NewDefaultCertRotationOptions() *CertRotationOptions {
+	return &CertRotationOptions{
+		Enabled:       true,
+		RenewFraction: DefaultCertRenewFraction,
+		CheckInterval: DefaultCertCheckInterval,
+		Lifetime:      DefaultCertLifetime,
+	}
+}
+
+// NeedRotation returns true if the fraction of certificate lifetime passed,
+// certificate with unknown lifetime is always rotated.
+func (o *CertRotationOptions) NeedRotation(status v1beta1.CertificateStatus, now time.Time) bool {
+	if status.IssuedAt == nil || status.ExpiresAt == nil {
+		return true
+	}
+	fraction := o.RenewFraction
+	if fraction <= 0 || fraction >= 1 {
+		fraction = DefaultCertRenewFraction
+	}
+	lifetime := status.ExpiresAt.Sub(status.IssuedAt.Time)
+	renewAt := status.IssuedAt.Add(time.Duration(float64(lifetime) * fraction))
+	return !now.Before(renewAt)
+}
+
+// RunCertRotation check online edge clusters periodically and rotate their certificates
+func (m *EdgeManager) RunCertRotation(ctx context.Context, server *tunnel.TunnelServer, options *CertRotationOptions) error {
+	log.Info("start certificate rotation", "fraction", options.RenewFraction, "interval", options.CheckInterval)
+	interval := options.CheckInterval
+	if interval <= 0 {
+		interval = DefaultCertCheckInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if err := m.RotateExpiringCerts(ctx, server, options); err != nil {
+				log.Error(err, "rotate certificates")
+			}
+		}
+	}
+}
+
+func (m *EdgeManager) RotateExpiringCerts(ctx context.Context, server *tunnel.TunnelServer, options *CertRotationOptions) error {
+	_, list, err := m.ClusterStore.List(ctx, ListOptions{})
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, cluster := range list {
+		// certificates can only be pushed to online edge clusters
+		if !cluster.Status.Tunnel.Connected || cluster.Spec.Register.Revoked {
+			continue
+		}
+		if !options.NeedRotation(cluster.Status.Certificate, now) {
+			continue
+		}
+		if _, err := m.RotateCert(ctx, server, cluster.Name, options.Lifetime); err != nil {
+			log.Error(err, "rotate certificate", "cluster", cluster.Name)
+		}
+	}
+	return nil
+}
+
+// RotateCert issue a new certificate and push it to edge agent,the rotation result is recorded in status.
+func (m *EdgeManager) RotateCert(ctx context.Context, server *tunnel.TunnelServer, name string, lifetime time.Duration) (*v1beta1.EdgeCluster, error) {
+	return m.rotateCert(ctx, server.TransportOnTunnel(name), name, lifetime)
+}
+
+func (m *EdgeManager) rotateCert(ctx context.Context, transport http.RoundTripper, name string, lifetime time.Duration) (*v1beta1.EdgeCluster, error) {
+	cluster, err := m.ClusterStore.Get(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if lifetime <= 0 {
+		lifetime = DefaultCertLifetime
+	}
+	log.Info("rotate edge certificate", "cluster", name)
+	certs, rotateErr := m.issueAndPushCert(ctx, transport, cluster, time.Now().Add(lifetime))
+	updated, err := m.ClusterStore.Update(ctx, name, func(cluster *v1beta1.EdgeCluster) error {
+		now := metav1.Now()
+		status := &cluster.Status.Certificate
+		status.LastRotation = &now
+		if rotateErr != nil {
+			status.RotationPhase = v1beta1.CertificateRotationPhaseFailed
+			status.RotationMessage = truncate(rotateErr.Error(), certRotationMessageMaxLen)
+			status.RotationFailures++
+			return nil
+		}
+		status.RotationPhase = v1beta1.CertificateRotationPhaseSucceeded
+		status.RotationMessage = ""
+		status.RotationFailures = 0
+		if err := setCertificateStatus(status, certs.Cert); err != nil {
+			return err
+		}
+		// keep pre generated certificate up to date
+		if cluster.Spec.Register.Certs != nil {
+			cluster.Spec.Register.Certs = certs
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return updated, rotateErr
+}
+
+func (m *EdgeManager) issueAndPushCert(ctx context.Context, transport http.RoundTripper,
+	cluster *v1beta1.EdgeCluster, expire time.Time,
+) (*v1beta1.Certs, error) {
+	hub, err := m.HubStore.Get(ctx, cluster.Spec.Register.HubName)
+	if err != nil {
+		return nil, fmt.Errorf("get edge hub %s: %w", cluster.Spec.Register.HubName, err)
+	}
+	certs, err := m.gencert(cluster.Name, &expire, hub)
+	if err != nil {
+		return nil, err
+	}
+	if err := m.pushCert(ctx, transport, cluster.Name, certs); err != nil {
+		return nil, err
+	}
+	return certs, nil
+}
+
+// pushCert sends certificates to edge agent,
+// the cluster is read again to use the latest bootstrap token and agent address.
+func (m *EdgeManager) pushCert(ctx context.Context, transport http.RoundTripper, name string, certs *v1beta1.Certs) error {
+	cluster, err := m.ClusterStore.Get(ctx, name)
+	if err != nil {
+		return err
+	}
+	agentaddress := cluster.Status.Manufacture[common.AnnotationKeyEdgeAgentAddress]
+	if agentaddress == "" {
+		agentaddress = common.AnnotationValueDefaultEdgeAgentAddress // fallback
+	}
+	body, err := json.Marshal(certs)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, DefaultCertRotateTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut,
+		strings.TrimSuffix(agentaddress, "/")+common.EdgeAgentCertificatePath, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	// agent authenticates the push by its bootstrap token
+	req.Header.Set("Authorization", "Bearer "+cluster.Spec.Register.BootstrapToken)
+	resp, err := (&http.Client{Transport: transport}).Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		content, _ := io.ReadAll(io.LimitReader(resp.Body, certRotationMessageMaxLen))
+		return fmt.Errorf("push certificate: %s: %s", resp.Status, string(content))
+	}
+	return nil
+}
+
+// setCertificateStatus set issue time and expiration from certificate
+func setCertificateStatus(status *v1beta1.CertificateStatus, certpem []byte) error {
+	info, err := certificate.ParseCertInfo(certpem)
+	if err != nil {
+		return err
+	}
+	issuedAt, expiresAt := metav1.NewTime(info.NotBefore), metav1.NewTime(info.NotAfter)
+	status.IssuedAt, status.ExpiresAt = &issuedAt, &expiresAt
+	return nil
+}
+
+func truncate(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+	return s[:max]
+}
@@ -0,0 +1,276 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/cert"
+	"k8s.io/client-go/util/keyutil"
+	"kubegems.io/kubegems/pkg/apis/edge/common"
+	"kubegems.io/kubegems/pkg/apis/edge/v1beta1"
+	"kubegems.io/kubegems/pkg/utils/certificate"
+	"kubegems.io/kubegems/pkg/utils/httputil/response"
+)
+
+func TestCertRotationOptions_NeedRotation(t *testing.T) {
+	now := time.Now()
+	at := func(d time.Duration) *metav1.Time {
+		ret := metav1.NewTime(now.Add(d))
+		return &ret
+	}
+	tests := []struct {
+		name     string
+		fraction float64
+		status   v1beta1.CertificateStatus
+		want     bool
+	}{
+		{name: "unknown lifetime", status: v1beta1.CertificateStatus{}, want: true},
+		{name: "fresh", fraction: 0.5, status: v1beta1.CertificateStatus{IssuedAt: at(-time.Hour), ExpiresAt: at(3 * time.Hour)}, want: false},
+		{name: "half passed", fraction: 0.5, status: v1beta1.CertificateStatus{IssuedAt: at(-2 * time.Hour), ExpiresAt: at(2 * time.Hour)}, want: true},
+		{name: "expired", fraction: 0.5, status: v1beta1.CertificateStatus{IssuedAt: at(-2 * time.Hour), ExpiresAt: at(-time.Hour)}, want: true},
+		{name: "invalid fraction uses default", fraction: 2, status: v1beta1.CertificateStatus{IssuedAt: at(-2 * time.Hour), ExpiresAt: at(2 * time.Hour)}, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := &CertRotationOptions{RenewFraction: tt.fraction}
+			if got := o.NeedRotation(tt.status, now); got != tt.want {
+				t.Errorf("NeedRotation() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEdgeManager_RotateCert(t *testing.T) {
+	ctx := context.Background()
+	cacert, cakey := testCA(t)
+
+	var (
+		mu        sync.Mutex
+		status    = http.StatusOK
+		gotToken  string
+		gotCerts  v1beta1.Certs
+		pushCount int
+	)
+	agent := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if r.Method != http.MethodPut || r.URL.Path != common.EdgeAgentCertificatePath {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		pushCount++
+		gotToken = r.Header.Get("Authorization")
+		_ = json.NewDecoder(r.Body).Decode(&gotCerts)
+		w.WriteHeader(status)
+	}))
+	defer agent.Close()
+
+	clusters := &memClusterStore{items: map[string]*v1beta1.EdgeCluster{
+		"edge-1": {
+			ObjectMeta: metav1.ObjectMeta{Name: "edge-1"},
+			Spec:       v1beta1.EdgeClusterSpec{Register: v1beta1.RegisterInfo{HubName: "hub", BootstrapToken: "token-1"}},
+			Status: v1beta1.EdgeClusterStatus{
+				Manufacture: v1beta1.ManufactureStatus{common.AnnotationKeyEdgeAgentAddress: agent.URL},
+			},
+		},
+	}}
+	hubs := &memHubStore{
+		items: map[string]*v1beta1.EdgeHub{
+			"hub": {
+				ObjectMeta: metav1.ObjectMeta{Name: "hub"},
+				Status: v1beta1.EdgeHubStatus{Manufacture: v1beta1.ManufactureStatus{
+					common.AnnotationKeyEdgeHubCA:   string(cacert),
+					common.AnnotationKeyEdgeHubCert: string(cacert),
+					common.AnnotationKeyEdgeHubKey:  string(cakey),
+				}},
+			},
+		},
+		// the token is refreshed while the certificate is issuing
+		onGet: func() {
+			_, _ = clusters.Update(ctx, "edge-1", func(cluster *v1beta1.EdgeCluster) error {
+				cluster.Spec.Register.BootstrapToken = "token-2"
+				return nil
+			})
+		},
+	}
+	m := &EdgeManager{ClusterStore: clusters, HubStore: hubs}
+
+	updated, err := m.rotateCert(ctx, http.DefaultTransport, "edge-1", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gotToken != "Bearer token-2" {
+		t.Errorf("pushed with token %q, want the latest token", gotToken)
+	}
+	info, err := certificate.ParseCertInfo(gotCerts.Cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Subject.CommonName != "edge-1" || string(gotCerts.CA) != string(cacert) {
+		t.Errorf("pushed certificate of %s", info.Subject.CommonName)
+	}
+	certstatus := updated.Status.Certificate
+	if certstatus.RotationPhase != v1beta1.CertificateRotationPhaseSucceeded || certstatus.LastRotation == nil {
+		t.Errorf("rotation status = %+v", certstatus)
+	}
+	if expire := time.Now().Add(time.Hour); certstatus.ExpiresAt == nil || !certstatus.ExpiresAt.Time.Equal(info.NotAfter) ||
+		info.NotAfter.Before(expire.Add(-time.Minute)) || info.NotAfter.After(expire) {
+		t.Errorf("certificate expires at %v, status %v", info.NotAfter, certstatus.ExpiresAt)
+	}
+
+	// rejected by agent
+	mu.Lock()
+	status = http.StatusBadRequest
+	mu.Unlock()
+	for i := 1; i <= 2; i++ {
+		updated, err = m.rotateCert(ctx, http.DefaultTransport, "edge-1", time.Hour)
+		if err == nil {
+			t.Fatal("rotateCert() rejected by agent: want error")
+		}
+		certstatus = updated.Status.Certificate
+		if certstatus.RotationPhase != v1beta1.CertificateRotationPhaseFailed || certstatus.RotationFailures != i {
+			t.Errorf("rotation status = %+v, want %d failures", certstatus, i)
+		}
+		// the certificate in use is kept
+		if !certstatus.ExpiresAt.Time.Equal(info.NotAfter) {
+			t.Errorf("certificate status changed on failure: %v", certstatus.ExpiresAt)
+		}
+	}
+	if pushCount != 3 {
+		t.Errorf("pushed %d times, want 3", pushCount)
+	}
+}
+
+func testCA(t *testing.T) ([]byte, []byte) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := cert.NewSelfSignedCACert(cert.Config{CommonName: "edge-ca"}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keypem, err := keyutil.MarshalPrivateKeyToPEM(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: cert.CertificateBlockType, Bytes: ca.Raw}), keypem
+}
+
+type memClusterStore struct {
+	mu    sync.Mutex
+	items map[string]*v1beta1.EdgeCluster
+}
+
+func (s *memClusterStore) List(ctx context.Context, options ListOptions) (int, []v1beta1.EdgeCluster, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := []v1beta1.EdgeCluster{}
+	for _, item := range s.items {
+		list = append(list, *item.DeepCopy())
+	}
+	return len(list), list, nil
+}
+
+func (s *memClusterStore) Get(ctx context.Context, name string) (*v1beta1.EdgeCluster, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	item, ok := s.items[name]
+	if !ok {
+		return nil, response.NewError(http.StatusNotFound, "not found")
+	}
+	return item.DeepCopy(), nil
+}
+
+func (s *memClusterStore) Update(ctx context.Context, name string, fun func(cluster *v1beta1.EdgeCluster) error) (*v1beta1.EdgeCluster, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	item, ok := s.items[name]
+	if !ok {
+		return nil, response.NewError(http.StatusNotFound, "not found")
+	}
+	item = item.DeepCopy()
+	if err := fun(item); err != nil {
+		return nil, err
+	}
+	s.items[name] = item
+	return item.DeepCopy(), nil
+}
+
+func (s *memClusterStore) Delete(ctx context.Context, name string) (*v1beta1.EdgeCluster, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	item, ok := s.items[name]
+	if !ok {
+		return nil, response.NewError(http.StatusNotFound, "not found")
+	}
+	delete(s.items, name)
+	return item, nil
+}
+
+type memHubStore struct {
+	items map[string]*v1beta1.EdgeHub
+	onGet func()
+}
+
+func (s *memHubStore) List(ctx context.Context, options ListOptions) (int, []v1beta1.EdgeHub, error) {
+	list := []v1beta1.EdgeHub{}
+	for _, item := range s.items {
+		list = append(list, *item.DeepCopy())
+	}
+	return len(list), list, nil
+}
+
+func (s *memHubStore) Get(ctx context.Context, name string) (*v1beta1.EdgeHub, error) {
+	if s.onGet != nil {
+		s.onGet()
+	}
+	item, ok := s.items[name]
+	if !ok {
+		return nil, response.NewError(http.StatusNotFound, "not found")
+	}
+	return item.DeepCopy(), nil
+}
+
+func (s *memHubStore) Update(ctx context.Context, name string, fun func(hub *v1beta1.EdgeHub) error) (*v1beta1.EdgeHub, error) {
+	item, ok := s.items[name]
+	if !ok {
+		return nil, response.NewError(http.StatusNotFound, "not found")
+	}
+	if err := fun(item); err != nil {
+		return nil, err
+	}
+	return item.DeepCopy(), nil
+}
+
+func (s *memHubStore) Delete(ctx context.Context, name string) (*v1beta1.EdgeHub, error) {
+	item, ok := s.items[name]
+	if !ok {
+		return nil, response.NewError(http.StatusNotFound, "not found")
+	}
+	delete(s.items, name)
+	return item, nil
+}
@@ -133,17 +133,20 @@ func (m *EdgeManager) PreCreate(ctx context.Context, example *v1beta1.EdgeCluste
 		if in.Status.Phase != v1beta1.EdgePhaseOnline {
 			in.Status.Phase = v1beta1.EdgePhaseWaiting
 		}
-		selfaddr := m.SelfAddress
-		if !strings.HasPrefix(selfaddr, "http") {
-			selfaddr = "http://" + selfaddr
-		}
-		manifestAddress := fmt.Sprintf("%s/v1/edge-clusters/%s/agent-installer.yaml?token=%s", selfaddr, in.Name, in.Spec.Register.BootstrapToken)
-		in.Status.Register.URL = manifestAddress
+		in.Status.Register.URL = m.registerURL(in.Name, in.Spec.Register.BootstrapToken)
 		return nil
 	}
 	return m.ClusterStore.Update(ctx, example.Name, updatespec)
 }
 
+func (m *EdgeManager) registerURL(name, token string) string {
+	selfaddr := m.SelfAddress
+	if !strings.HasPrefix(selfaddr, "http") {
+		selfaddr = "http://" + selfaddr
+	}
+	return fmt.Sprintf("%s/v1/edge-clusters/%s/agent-installer.yaml?token=%s", selfaddr, name, token)
+}
+
 type InstallerTemplateValues struct {
 	EdgeAddress string
 	AgentImage  string
@@ -160,6 +163,9 @@ func (m *EdgeManager) RenderInstallManifests(ctx context.Context, uid, token str
 	if exists.Spec.Register.BootstrapToken != token {
 		return nil, fmt.Errorf("invalid token: %s", token)
 	}
+	if exists.Spec.Register.Revoked {
+		return nil, fmt.Errorf("edge cluster %s is revoked", uid)
+	}
 	if exists.Spec.Register.HubName == "" {
 		return nil, fmt.Errorf("no hub name specified for the edge cluster")
 	}
@@ -190,12 +196,16 @@ func (m *EdgeManager) RenderInstallManifests(ctx context.Context, uid, token str
 		now := metav1.Now()
 		cluster.Status.Register.LastRegister = &now
 		cluster.Status.Register.LastRegisterToken = token
+		// record lifetime of the installed certificate for rotation
+		if err := setCertificateStatus(&cluster.Status.Certificate, edgecerts.Cert); err != nil {
+			log.Error(err, "parse edge certificate", "uid", uid)
+		}
 		return nil
 	}); err != nil {
 		return nil, err
 	}
 	// render template
-	objects := RenderManifets(uid, exists.Spec.Register.Image, hubaddress, *edgecerts)
+	objects := RenderManifets(uid, exists.Spec.Register.Image, hubaddress, token, *edgecerts)
 	printer := printers.YAMLPrinter{}
 	buf := bytes.NewBuffer(nil)
 	for _, obj := range objects {
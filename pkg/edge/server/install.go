@@ -27,7 +27,7 @@ import (
 const DefaultEdgeAgentImage = "docker.io/kubegems/kubegems-edge-agent:latest"
 
 // nolint: gomnd,funlen
-func RenderManifets(uid string, image string, edgehubaddress string, certs v1beta1.Certs) []client.Object {
+func RenderManifets(uid string, image string, edgehubaddress string, token string, certs v1beta1.Certs) []client.Object {
 	if image == "" {
 		image = DefaultEdgeAgentImage
 	}
@@ -67,6 +67,7 @@ func RenderManifets(uid string, image string, edgehubaddress string, certs v1bet
 									"--listen=:8080",
 									"--edgehubaddr=" + edgehubaddress,
 									"--clientid=" + uid,
+									"--token=" + token,
 								},
 								Ports: []corev1.ContainerPort{
 									{
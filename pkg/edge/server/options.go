@@ -21,19 +21,26 @@ import (
 )
 
 type Options struct {
-	Listen     string           `json:"listen,omitempty"`
-	Host       string           `json:"host,omitempty"`
-	ListenGrpc string           `json:"listenGrpc,omitempty"`
-	ServerID   string           `json:"serverID,omitempty"`
-	TLS        *system.TLS      `json:"tls,omitempty"`
-	Database   database.Options `json:"database,omitempty"`
+	Listen       string                     `json:"listen,omitempty"`
+	Host         string                     `json:"host,omitempty"`
+	ListenGrpc   string                     `json:"listenGrpc,omitempty"`
+	ServerID     string                     `json:"serverID,omitempty"`
+	TLS          *system.TLS                `json:"tls,omitempty"`
+	Database     database.Options           `json:"database,omitempty"`
+	FlowControl  *tunnel.FlowControlOptions `json:"flowControl,omitempty"`
+	Auth         *tunnel.TokenAuthOptions   `json:"auth,omitempty" description:"token authentication of edge clusters,signing keys are kept on server only"`
+	HubToken     string                     `json:"hubToken,omitempty" description:"token of edge hubs to connect,required if token authentication enabled"`
+	CertRotation *CertRotationOptions       `json:"certRotation,omitempty" description:"rotate edge certificates before expiration"`
 }
 
 func NewDefaultOptions() *Options {
 	return &Options{
-		Listen:     ":8080",
-		ListenGrpc: ":50052",
-		TLS:        system.NewDefaultTLS(),
-		ServerID:   tunnel.RandomServerID("server-"),
+		Listen:       ":8080",
+		ListenGrpc:   ":50052",
+		TLS:          system.NewDefaultTLS(),
+		ServerID:     tunnel.RandomServerID("server-"),
+		FlowControl:  tunnel.NewDefaultFlowControlOptions(),
+		Auth:         tunnel.NewDefaultTokenAuthOptions(),
+		CertRotation: NewDefaultCertRotationOptions(),
 	}
 }
@@ -17,6 +17,7 @@ package server
 import (
 	"context"
 	"crypto/tls"
+	"errors"
 	"net/http"
 
 	"golang.org/x/sync/errgroup"
@@ -38,6 +39,7 @@ func Run(ctx context.Context, options *Options) error {
 type EdgeServer struct {
 	server    *tunnel.GrpcTunnelServer
 	clusters  *EdgeManager
+	auth      *tunnel.TokenAuthManager
 	tlsConfig *tls.Config
 	options   *Options
 }
@@ -52,13 +54,24 @@ func NewEdgeServer(ctx context.Context, options *Options) (*EdgeServer, error) {
 		return nil, err
 	}
 	server := &EdgeServer{
-		server: &tunnel.GrpcTunnelServer{
-			TunnelServer: tunnel.NewTunnelServer(options.ServerID, nil),
-		},
 		tlsConfig: tlsConfig,
 		options:   options,
 		clusters:  edgemanager,
 	}
+	var auth tunnel.AuthenticationManager
+	if options.Auth.IsEnabled() {
+		if options.HubToken == "" {
+			return nil, errors.New("hub token is required if token authentication enabled")
+		}
+		tokenauth, err := tunnel.NewTokenAuthManager(options.Auth, edgemanager.BootstrapTokenGetter(options.HubToken))
+		if err != nil {
+			return nil, err
+		}
+		server.auth, auth = tokenauth, tokenauth
+	}
+	tunserver := tunnel.NewTunnelServer(options.ServerID, auth)
+	tunserver.SetFlowControl(options.FlowControl)
+	server.server = &tunnel.GrpcTunnelServer{TunnelServer: tunserver}
 	return server, nil
 }
 
@@ -86,6 +99,16 @@ func (s *EdgeServer) Run(ctx context.Context) error {
 	eg.Go(func() error {
 		return s.clusters.SyncTunnelStatusFrom(ctx, s.server.TunnelServer)
 	})
+	if s.auth != nil {
+		eg.Go(func() error {
+			return s.clusters.SyncCredentialsTo(ctx, s.server.TunnelServer, s.auth)
+		})
+	}
+	if s.options.CertRotation != nil && s.options.CertRotation.Enabled {
+		eg.Go(func() error {
+			return s.clusters.RunCertRotation(ctx, s.server.TunnelServer, s.options.CertRotation)
+		})
+	}
 	eg.Go(func() error {
 		return pprof.Run(ctx)
 	})
@@ -96,6 +119,7 @@ func (s *EdgeServer) HTTPAPI() http.Handler {
 	edgeapi := &EdgeClusterAPI{
 		Cluster: s.clusters,
 		Tunnel:  s.server.TunnelServer,
+		Auth:    s.auth,
 	}
 	return apiutil.NewRestfulAPI("v1", nil, []apiutil.RestModule{edgeapi})
 }
@@ -44,8 +44,9 @@ const (
 )
 
 const (
-	IndexFieldEdgeTaskStatusPhase    = "status.phase"
-	IndexFieldEdgeClusterStatusPhase = "status.phase"
+	IndexFieldEdgeTaskStatusPhase     = "status.phase"
+	IndexFieldEdgeTaskEdgeClusterName = "spec.edgeClusterName"
+	IndexFieldEdgeClusterStatusPhase  = "status.phase"
 )
 
 type Reconciler struct {
@@ -58,6 +59,9 @@ func (r *Reconciler) SetupWithManager(ctx context.Context, mgr ctrl.Manager, con
 	mgr.GetFieldIndexer().IndexField(ctx, &edgev1beta1.EdgeTask{}, IndexFieldEdgeTaskStatusPhase, func(rawObj client.Object) []string {
 		return []string{string(rawObj.(*edgev1beta1.EdgeTask).Status.Phase)}
 	})
+	mgr.GetFieldIndexer().IndexField(ctx, &edgev1beta1.EdgeTask{}, IndexFieldEdgeTaskEdgeClusterName, func(rawObj client.Object) []string {
+		return []string{EdgeClusterNameOf(rawObj.(*edgev1beta1.EdgeTask))}
+	})
 	mgr.GetFieldIndexer().IndexField(ctx, &edgev1beta1.EdgeCluster{}, IndexFieldEdgeClusterStatusPhase, func(rawObj client.Object) []string {
 		return []string{string(rawObj.(*edgev1beta1.EdgeCluster).Status.Phase)}
 	})
@@ -65,7 +69,7 @@ func (r *Reconciler) SetupWithManager(ctx context.Context, mgr ctrl.Manager, con
 		For(&edgev1beta1.EdgeTask{}).
 		WithOptions(controller.Options{MaxConcurrentReconciles: concurrent}).
 		Watches(&source.Kind{Type: &edgev1beta1.EdgeCluster{}}, EdgeClusterTrigger(ctx, mgr.GetClient())).
-		Watches(r.EdgeClients.SourceFunc(), nil). // watch edge clusters' resources change
+		Watches(r.EdgeClients.SourceFunc(mgr.GetClient()), nil). // watch edge clusters' resources change
 		Complete(r)
 }
 
@@ -93,20 +97,42 @@ func EdgeClusterTrigger(ctx context.Context, cli client.Client) handler.EventHan
 			}
 			log.Info("edgecluster status changed", "old", previous.Status.Phase, "new", current.Status.Phase)
 			log.Info("edgecluster is coming online, trigger the uncompleted tasks for it")
-			task := &edgev1beta1.EdgeTask{}
-			if err := cli.Get(ctx, client.ObjectKeyFromObject(current), task); err != nil {
-				log.Error(err, "failed to get edge task")
+			tasks, err := TasksOfEdgeCluster(ctx, cli, current.Namespace, current.Name)
+			if err != nil {
+				log.Error(err, "failed to list edge tasks")
 				return
 			}
-			if task.Status.Phase == edgev1beta1.EdgeTaskPhaseRunning {
-				return
+			for _, task := range tasks {
+				if task.Status.Phase == edgev1beta1.EdgeTaskPhaseRunning {
+					continue
+				}
+				log.Info("trigger edge task", "name", task.Name, "namespace", task.Namespace)
+				rli.Add(ctrl.Request{NamespacedName: client.ObjectKeyFromObject(&task)})
 			}
-			log.Info("trigger edge task", "name", task.Name, "namespace", task.Namespace)
-			rli.Add(ctrl.Request{NamespacedName: client.ObjectKeyFromObject(task)})
 		},
 	}
 }
 
+// EdgeClusterNameOf returns the edge cluster name of the task,use task name if not specified
+func EdgeClusterNameOf(task *edgev1beta1.EdgeTask) string {
+	if task.Spec.EdgeClusterName != "" {
+		return task.Spec.EdgeClusterName
+	}
+	return task.Name
+}
+
+// TasksOfEdgeCluster list edge tasks of the edge cluster
+func TasksOfEdgeCluster(ctx context.Context, cli client.Client, namespace, edgecluster string) ([]edgev1beta1.EdgeTask, error) {
+	list := &edgev1beta1.EdgeTaskList{}
+	if err := cli.List(ctx, list,
+		client.InNamespace(namespace),
+		client.MatchingFields{IndexFieldEdgeTaskEdgeClusterName: edgecluster},
+	); err != nil {
+		return nil, err
+	}
+	return list.Items, nil
+}
+
 func (r *Reconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
 	log := logr.FromContextOrDiscard(ctx)
 	edgeTask := &edgev1beta1.EdgeTask{}
@@ -226,10 +252,7 @@ func (r *Reconciler) stageWaitForEdgeCluster(ctx context.Context, edgeTask *edge
 	log := logr.FromContextOrDiscard(ctx).WithValues("edgetask", edgeTask.Name, "namespace", edgeTask.Namespace)
 	log.Info("stage wait for edge cluster")
 	// wait for the edge cluster to be online
-	edgeclustername := edgeTask.Spec.EdgeClusterName
-	if edgeclustername == "" {
-		edgeclustername = edgeTask.Name
-	}
+	edgeclustername := EdgeClusterNameOf(edgeTask)
 	edgeCluster := &edgev1beta1.EdgeCluster{}
 	if err := r.Get(ctx, client.ObjectKey{Namespace: edgeTask.Namespace, Name: edgeclustername}, edgeCluster); err != nil {
 		edgeTask.Status.Phase = edgev1beta1.EdgeTaskPhaseWaiting
@@ -327,7 +350,7 @@ func HashResources(obj any) string {
 
 func (r *Reconciler) applyResources(ctx context.Context, edgeTask *edgev1beta1.EdgeTask, resources []*unstructured.Unstructured,
 ) ([]edgev1beta1.EdgeTaskResourceStatus, error) {
-	edgecli, err := r.EdgeClients.Get(edgeTask.Name)
+	edgecli, err := r.EdgeClients.Get(EdgeClusterNameOf(edgeTask))
 	if err != nil {
 		return nil, fmt.Errorf("get edge client: %w", err)
 	}
@@ -342,7 +365,7 @@ func (r *Reconciler) applyResources(ctx context.Context, edgeTask *edgev1beta1.E
 }
 
 func (r *Reconciler) removeResources(ctx context.Context, edgeTask *edgev1beta1.EdgeTask) error {
-	edgecli, err := r.EdgeClients.Get(edgeTask.Name)
+	edgecli, err := r.EdgeClients.Get(EdgeClusterNameOf(edgeTask))
 	if err != nil {
 		return fmt.Errorf("get edge client: %w", err)
 	}
@@ -365,7 +388,7 @@ func (r *Reconciler) stageCheckResource(ctx context.Context, task *edgev1beta1.E
 			Reason: "Waiting",
 		})
 	}
-	edgecli, err := r.EdgeClients.Get(task.Name)
+	edgecli, err := r.EdgeClients.Get(EdgeClusterNameOf(task))
 	if err != nil {
 		log.Error(err, "get edge client")
 		return r.UpdateEdgeTaskCondition(ctx, task, edgev1beta1.EdgeTaskCondition{
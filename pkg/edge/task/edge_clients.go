@@ -92,7 +92,7 @@ func (c *EdgeClientsHolder) eventhandler(uid string) cache.ResourceEventHandler
 }
 
 // Start behaves like controller-runtime's source.Source interface
-func (c *EdgeClientsHolder) SourceFunc() source.Func {
+func (c *EdgeClientsHolder) SourceFunc(cli client.Client) source.Func {
 	defaultns := "kubegems-edge"
 	// it's a producer to reconciler
 	return func(ctx context.Context, _ handler.EventHandler, queue workqueue.RateLimitingInterface, _ ...predicate.Predicate) error {
@@ -105,10 +105,17 @@ func (c *EdgeClientsHolder) SourceFunc() source.Func {
 					return
 				case event := <-c.events:
 					// resources change from edge cluster uid
-					// trigger the task of name uid to reconcile
-					// TODO: we can find target edge task from resource's annotations instead of use uid as task name
+					// trigger the tasks of the edge cluster to reconcile
 					uid := event.UID
-					queue.Add(reconcile.Request{NamespacedName: types.NamespacedName{Name: uid, Namespace: defaultns}})
+					tasks, err := TasksOfEdgeCluster(ctx, cli, defaultns, uid)
+					if err != nil || len(tasks) == 0 {
+						// fallback to the task of name uid
+						queue.Add(reconcile.Request{NamespacedName: types.NamespacedName{Name: uid, Namespace: defaultns}})
+						continue
+					}
+					for _, task := range tasks {
+						queue.Add(reconcile.Request{NamespacedName: types.NamespacedName{Name: task.Name, Namespace: task.Namespace}})
+					}
 				}
 			}
 		}()
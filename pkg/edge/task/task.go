@@ -72,6 +72,10 @@ func Run(ctx context.Context, options *Options) error {
 	if err := r.SetupWithManager(ctx, mgr, options.MaxConcurrentReconciles); err != nil {
 		log.Error(err, "unable to create controller", "controller", "EdgeTask")
 	}
+	tasksets := &TaskSetReconciler{Client: mgr.GetClient()}
+	if err := tasksets.SetupWithManager(ctx, mgr, options.MaxConcurrentReconciles); err != nil {
+		log.Error(err, "unable to create controller", "controller", "EdgeTaskSet")
+	}
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
 		log.Error(err, "unable to set up health check")
 		return err
@@ -0,0 +1,468 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package task
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	jsonpatch "github.com/evanphx/json-patch/v5"
+	"github.com/go-logr/logr"
+	"golang.org/x/exp/slices"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	edgev1beta1 "kubegems.io/kubegems/pkg/apis/edge/v1beta1"
+	"kubegems.io/kubegems/pkg/installer/utils"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+type TaskSetReconciler struct {
+	client.Client
+}
+
+func (r *TaskSetReconciler) SetupWithManager(ctx context.Context, mgr ctrl.Manager, concurrent int) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&edgev1beta1.EdgeTaskSet{}).
+		Owns(&edgev1beta1.EdgeTask{}).
+		WithOptions(controller.Options{MaxConcurrentReconciles: concurrent}).
+		Watches(&source.Kind{Type: &edgev1beta1.EdgeCluster{}}, EdgeClusterTaskSetTrigger(ctx, mgr.GetClient())).
+		Complete(r)
+}
+
+// EdgeClusterTaskSetTrigger triggers all edge task sets in namespace on edge cluster changed,
+// edge clusters' labels,manufacture and phase affect the selection and rollout.
+func EdgeClusterTaskSetTrigger(ctx context.Context, cli client.Client) handler.EventHandler {
+	log := logr.FromContextOrDiscard(ctx)
+	return handler.EnqueueRequestsFromMapFunc(func(obj client.Object) []reconcile.Request {
+		list := &edgev1beta1.EdgeTaskSetList{}
+		if err := cli.List(ctx, list, client.InNamespace(obj.GetNamespace())); err != nil {
+			log.Error(err, "failed to list edge task sets")
+			return nil
+		}
+		requests := make([]reconcile.Request, 0, len(list.Items))
+		for _, item := range list.Items {
+			requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&item)})
+		}
+		return requests
+	})
+}
+
+func (r *TaskSetReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := logr.FromContextOrDiscard(ctx).WithValues("edgetaskset", req.Name, "namespace", req.Namespace)
+	ctx = logr.NewContext(ctx, log)
+
+	taskset := &edgev1beta1.EdgeTaskSet{}
+	if err := r.Get(ctx, req.NamespacedName, taskset); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	// edge tasks are removed by garbage collector,their resources are cleaned by edge task finalizer
+	if taskset.GetDeletionTimestamp() != nil {
+		return ctrl.Result{}, nil
+	}
+	log.Info("reconcile edge task set")
+	status, err := r.rollout(ctx, taskset)
+	if err != nil {
+		log.Error(err, "rollout edge task set")
+	}
+	if !reflect.DeepEqual(taskset.Status, status) {
+		taskset.Status = status
+		if err := r.Status().Update(ctx, taskset); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+	return ctrl.Result{}, err
+}
+
+type taskSetItem struct {
+	edgecluster *edgev1beta1.EdgeCluster
+	desired     *edgev1beta1.EdgeTask
+	existing    *edgev1beta1.EdgeTask
+	updated     bool // existing task has the desired spec
+	available   bool // existing task applied and running,no matter it is updated or not
+	ready       bool // updated and available
+	failed      bool // updated but failed
+}
+
+func (i taskSetItem) online() bool {
+	return i.edgecluster.Status.Phase == edgev1beta1.EdgePhaseOnline
+}
+
+// nolint: funlen,gocognit
+func (r *TaskSetReconciler) rollout(ctx context.Context, taskset *edgev1beta1.EdgeTaskSet) (edgev1beta1.EdgeTaskSetStatus, error) {
+	log := logr.FromContextOrDiscard(ctx)
+	status := edgev1beta1.EdgeTaskSetStatus{}
+
+	edgeclusters, err := r.selectEdgeClusters(ctx, taskset)
+	if err != nil {
+		status.Phase, status.Message = edgev1beta1.EdgeTaskSetPhaseFailed, err.Error()
+		return status, err
+	}
+	existings, err := r.ownedTasks(ctx, taskset)
+	if err != nil {
+		return taskset.Status, err
+	}
+	items := make([]taskSetItem, 0, len(edgeclusters))
+	for i := range edgeclusters {
+		edgecluster := &edgeclusters[i]
+		desired, err := RenderEdgeTask(taskset, edgecluster.Name)
+		if err != nil {
+			status.Phase, status.Message = edgev1beta1.EdgeTaskSetPhaseFailed, err.Error()
+			return status, nil // do not requeue on invalid template
+		}
+		item := taskSetItem{edgecluster: edgecluster, desired: desired}
+		if existing, ok := existings[desired.Name]; ok {
+			item.existing = existing
+			delete(existings, desired.Name)
+			item.updated = existing.Annotations[edgev1beta1.AnnotationEdgeTaskSetHash] == desired.Annotations[edgev1beta1.AnnotationEdgeTaskSetHash]
+			item.failed = item.updated && IsEdgeTaskFailed(existing)
+			item.available = IsEdgeTaskApplied(ctx, existing) && existing.Status.Phase == edgev1beta1.EdgeTaskPhaseRunning
+			item.ready = item.updated && item.available
+		}
+		items = append(items, item)
+	}
+	// remove tasks of unselected edge clusters
+	for _, task := range existings {
+		log.Info("remove edge task of unselected edge cluster", "task", task.Name, "edgecluster", EdgeClusterNameOf(task))
+		if err := r.Delete(ctx, task); client.IgnoreNotFound(err) != nil {
+			return taskset.Status, err
+		}
+	}
+
+	// edge tasks on offline edge clusters are updated without limit,they are applied once the edge cluster online.
+	inflight, unavailable, failed := 0, 0, []string{}
+	for _, item := range items {
+		if item.failed {
+			failed = append(failed, item.edgecluster.Name)
+		}
+		if item.existing == nil || !item.online() {
+			continue
+		}
+		if !item.available {
+			unavailable++
+		}
+		if item.updated && !item.ready && !item.failed {
+			inflight++
+		}
+	}
+	batchSize, maxUnavailable := RolloutLimits(taskset.Spec.Strategy, len(items))
+	budget := batchSize - inflight
+	if left := maxUnavailable - unavailable; left < budget {
+		budget = left
+	}
+
+	paused := ""
+	switch {
+	case taskset.Spec.Paused:
+		paused = "rollout paused"
+	case taskset.Spec.Strategy.PauseOnFailure && len(failed) > 0:
+		paused = fmt.Sprintf("rollout paused on failure of edge clusters: %s", strings.Join(failed, ","))
+	}
+	for i := range items {
+		item := &items[i]
+		if item.updated || paused != "" {
+			continue
+		}
+		if item.online() {
+			if budget <= 0 {
+				continue
+			}
+			budget--
+		}
+		if err := r.applyTask(ctx, taskset, item); err != nil {
+			return taskset.Status, err
+		}
+		item.updated = true
+	}
+
+	// aggregate status
+	for _, item := range items {
+		status.Clusters++
+		taskstatus := edgev1beta1.EdgeTaskSetTaskStatus{
+			EdgeClusterName: item.edgecluster.Name,
+			TaskName:        item.desired.Name,
+			Updated:         item.updated,
+			Failed:          item.failed,
+		}
+		if item.updated {
+			status.Updated++
+		}
+		if item.ready {
+			status.Ready++
+		}
+		if item.failed {
+			status.Failed++
+		}
+		if item.existing != nil {
+			taskstatus.Phase = item.existing.Status.Phase
+			taskstatus.Resources, taskstatus.ResourcesReady, taskstatus.Message = AggregateEdgeTaskStatus(item.existing)
+			status.Resources += taskstatus.Resources
+			status.ResourcesReady += taskstatus.ResourcesReady
+		}
+		status.Tasks = append(status.Tasks, taskstatus)
+	}
+	switch {
+	case status.Clusters == 0:
+		status.Phase, status.Message = edgev1beta1.EdgeTaskSetPhaseNoTargets, "no edge cluster selected"
+	case paused != "":
+		status.Phase, status.Message = edgev1beta1.EdgeTaskSetPhasePaused, paused
+	case status.Ready == status.Clusters:
+		status.Phase = edgev1beta1.EdgeTaskSetPhaseCompleted
+	default:
+		status.Phase = edgev1beta1.EdgeTaskSetPhaseProgressing
+	}
+	return status, nil
+}
+
+func (r *TaskSetReconciler) applyTask(ctx context.Context, taskset *edgev1beta1.EdgeTaskSet, item *taskSetItem) error {
+	log := logr.FromContextOrDiscard(ctx)
+	task := &edgev1beta1.EdgeTask{ObjectMeta: metav1.ObjectMeta{Name: item.desired.Name, Namespace: item.desired.Namespace}}
+	result, err := controllerutil.CreateOrUpdate(ctx, r.Client, task, func() error {
+		if task.Labels == nil {
+			task.Labels = map[string]string{}
+		}
+		for k, v := range item.desired.Labels {
+			task.Labels[k] = v
+		}
+		if task.Annotations == nil {
+			task.Annotations = map[string]string{}
+		}
+		for k, v := range item.desired.Annotations {
+			task.Annotations[k] = v
+		}
+		task.Spec = item.desired.Spec
+		return controllerutil.SetControllerReference(taskset, task, r.Scheme())
+	})
+	if err != nil {
+		return fmt.Errorf("apply edge task %s: %w", task.Name, err)
+	}
+	log.Info("edge task applied", "task", task.Name, "edgecluster", item.edgecluster.Name, "result", result)
+	item.existing = task
+	return nil
+}
+
+// selectEdgeClusters returns edge clusters matched both label selector and manufacture selector,sorted by name.
+// no edge cluster selected if no selector specified.
+func (r *TaskSetReconciler) selectEdgeClusters(ctx context.Context, taskset *edgev1beta1.EdgeTaskSet) ([]edgev1beta1.EdgeCluster, error) {
+	if taskset.Spec.Selector == nil && taskset.Spec.ManufactureSelector == nil {
+		return nil, nil
+	}
+	listopts := []client.ListOption{client.InNamespace(taskset.Namespace)}
+	if taskset.Spec.Selector != nil {
+		selector, err := metav1.LabelSelectorAsSelector(taskset.Spec.Selector)
+		if err != nil {
+			return nil, fmt.Errorf("invalid selector: %w", err)
+		}
+		listopts = append(listopts, client.MatchingLabelsSelector{Selector: selector})
+	}
+	manufacture := labels.Everything()
+	if taskset.Spec.ManufactureSelector != nil {
+		selector, err := metav1.LabelSelectorAsSelector(taskset.Spec.ManufactureSelector)
+		if err != nil {
+			return nil, fmt.Errorf("invalid manufacture selector: %w", err)
+		}
+		manufacture = selector
+	}
+	list := &edgev1beta1.EdgeClusterList{}
+	if err := r.List(ctx, list, listopts...); err != nil {
+		return nil, err
+	}
+	selected := []edgev1beta1.EdgeCluster{}
+	for _, item := range list.Items {
+		if item.GetDeletionTimestamp() != nil || !manufacture.Matches(labels.Set(item.Status.Manufacture)) {
+			continue
+		}
+		selected = append(selected, item)
+	}
+	sort.Slice(selected, func(i, j int) bool { return selected[i].Name < selected[j].Name })
+	return selected, nil
+}
+
+func (r *TaskSetReconciler) ownedTasks(ctx context.Context, taskset *edgev1beta1.EdgeTaskSet) (map[string]*edgev1beta1.EdgeTask, error) {
+	list := &edgev1beta1.EdgeTaskList{}
+	if err := r.List(ctx, list,
+		client.InNamespace(taskset.Namespace),
+		client.MatchingLabels{edgev1beta1.LabelEdgeTaskSet: taskset.Name},
+	); err != nil {
+		return nil, err
+	}
+	ret := make(map[string]*edgev1beta1.EdgeTask, len(list.Items))
+	for i := range list.Items {
+		task := &list.Items[i]
+		if !metav1.IsControlledBy(task, taskset) {
+			continue
+		}
+		ret[task.Name] = task
+	}
+	return ret, nil
+}
+
+func EdgeTaskSetTaskName(taskset, edgecluster string) string {
+	return taskset + "-" + edgecluster
+}
+
+// RenderEdgeTask renders the edge task of the edge cluster from template with overrides of the edge cluster.
+func RenderEdgeTask(taskset *edgev1beta1.EdgeTaskSet, edgecluster string) (*edgev1beta1.EdgeTask, error) {
+	patches := [][]byte{}
+	for _, override := range taskset.Spec.Overrides {
+		if !slices.Contains(override.EdgeClusterNames, edgecluster) {
+			continue
+		}
+		for _, patch := range override.Patches {
+			patches = append(patches, patch.Raw)
+		}
+	}
+	resources := []runtime.RawExtension{}
+	for i, raw := range taskset.Spec.Template.Resources {
+		list, err := utils.SplitYAML(raw.Raw)
+		if err != nil {
+			return nil, fmt.Errorf("split template resource on index %d: %w", i, err)
+		}
+		for _, obj := range list {
+			content, err := PatchResource(obj, patches)
+			if err != nil {
+				return nil, fmt.Errorf("patch %s %s: %w", obj.GetKind(), obj.GetName(), err)
+			}
+			resources = append(resources, runtime.RawExtension{Raw: content})
+		}
+	}
+	task := &edgev1beta1.EdgeTask{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        EdgeTaskSetTaskName(taskset.Name, edgecluster),
+			Namespace:   taskset.Namespace,
+			Labels:      map[string]string{},
+			Annotations: map[string]string{},
+		},
+		Spec: edgev1beta1.EdgeTaskSpec{
+			EdgeClusterName: edgecluster,
+			Resources:       resources,
+		},
+	}
+	for k, v := range taskset.Spec.Template.Labels {
+		task.Labels[k] = v
+	}
+	for k, v := range taskset.Spec.Template.Annotations {
+		task.Annotations[k] = v
+	}
+	task.Labels[edgev1beta1.LabelEdgeTaskSet] = taskset.Name
+	task.Annotations[edgev1beta1.AnnotationEdgeTaskSetHash] = HashResources(task.Spec)
+	return task, nil
+}
+
+// PatchResource applies json merge patches which have same apiVersion,kind,name and namespace(if set) to the resource.
+func PatchResource(obj *unstructured.Unstructured, patches [][]byte) ([]byte, error) {
+	content, err := obj.MarshalJSON()
+	if err != nil {
+		return nil, err
+	}
+	for _, patch := range patches {
+		target := &unstructured.Unstructured{}
+		if err := target.UnmarshalJSON(patch); err != nil {
+			return nil, fmt.Errorf("invalid patch: %w", err)
+		}
+		if target.GetAPIVersion() != obj.GetAPIVersion() || target.GetKind() != obj.GetKind() || target.GetName() != obj.GetName() {
+			continue
+		}
+		if ns := target.GetNamespace(); ns != "" && ns != obj.GetNamespace() {
+			continue
+		}
+		if content, err = jsonpatch.MergePatch(content, patch); err != nil {
+			return nil, err
+		}
+	}
+	return content, nil
+}
+
+// RolloutLimits returns batch size and max unavailable of total edge clusters,both are at least 1.
+func RolloutLimits(strategy edgev1beta1.EdgeTaskSetStrategy, total int) (int, int) {
+	batchSize := scaledValue(strategy.BatchSize, total, true)
+	maxUnavailable := batchSize
+	if strategy.MaxUnavailable != nil {
+		maxUnavailable = scaledValue(strategy.MaxUnavailable, total, false)
+	}
+	return batchSize, maxUnavailable
+}
+
+func scaledValue(val *intstr.IntOrString, total int, roundUp bool) int {
+	if val == nil {
+		return 1
+	}
+	scaled, err := intstr.GetScaledValueFromIntOrPercent(val, total, roundUp)
+	if err != nil || scaled < 1 {
+		return 1
+	}
+	return scaled
+}
+
+// IsEdgeTaskApplied check the edge task resources have been applied by edge task controller
+func IsEdgeTaskApplied(ctx context.Context, task *edgev1beta1.EdgeTask) bool {
+	resources, err := ParseResources(ctx, task)
+	if err != nil {
+		return false
+	}
+	return task.Annotations[AnnotationEdgeTaskResourcesHash] == HashResources(resources)
+}
+
+// IsEdgeTaskFailed check the edge task failed to prepare or distribute resources
+func IsEdgeTaskFailed(task *edgev1beta1.EdgeTask) bool {
+	if task.Status.Phase == edgev1beta1.EdgeTaskPhaseFailed {
+		return true
+	}
+	for _, condtype := range []edgev1beta1.EdgeTaskConditionType{
+		edgev1beta1.EdgeTaskConditionTypePrepared,
+		edgev1beta1.EdgeTaskConditionTypeDistributed,
+	} {
+		if _, cond := GetEdgeTaskCondition(&task.Status, condtype); cond != nil && cond.Status == corev1.ConditionFalse {
+			return true
+		}
+	}
+	return false
+}
+
+// AggregateEdgeTaskStatus returns resources count,ready resources count and the first not ready message
+func AggregateEdgeTaskStatus(task *edgev1beta1.EdgeTask) (int32, int32, string) {
+	total, ready, message := int32(0), int32(0), ""
+	for _, resource := range task.Status.ResourcesStatus {
+		total++
+		if resource.Ready {
+			ready++
+			continue
+		}
+		if message == "" && resource.Message != "" {
+			message = fmt.Sprintf("%s %s: %s", resource.Kind, resource.Name, resource.Message)
+		}
+	}
+	if message == "" {
+		for _, cond := range task.Status.Conditions {
+			if cond.Status == corev1.ConditionFalse && cond.Message != "" {
+				message = cond.Message
+				break
+			}
+		}
+	}
+	return total, ready, message
+}
@@ -0,0 +1,408 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package task
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	edgev1beta1 "kubegems.io/kubegems/pkg/apis/edge/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+const testDeployment = `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: app
+  namespace: default
+spec:
+  replicas: 1
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: app-config
+  namespace: default
+data:
+  key: value
+`
+
+func TestRolloutLimits(t *testing.T) {
+	intOrStr := func(val intstr.IntOrString) *intstr.IntOrString { return &val }
+	tests := []struct {
+		name               string
+		strategy           edgev1beta1.EdgeTaskSetStrategy
+		total              int
+		wantBatchSize      int
+		wantMaxUnavailable int
+	}{
+		{
+			name:               "default",
+			total:              10,
+			wantBatchSize:      1,
+			wantMaxUnavailable: 1,
+		},
+		{
+			name:               "batch size",
+			strategy:           edgev1beta1.EdgeTaskSetStrategy{BatchSize: intOrStr(intstr.FromInt(3))},
+			total:              10,
+			wantBatchSize:      3,
+			wantMaxUnavailable: 3,
+		},
+		{
+			name: "percent",
+			strategy: edgev1beta1.EdgeTaskSetStrategy{
+				BatchSize:      intOrStr(intstr.FromString("25%")),
+				MaxUnavailable: intOrStr(intstr.FromString("15%")),
+			},
+			total:              10,
+			wantBatchSize:      3, // round up
+			wantMaxUnavailable: 1, // round down
+		},
+		{
+			name: "at least 1",
+			strategy: edgev1beta1.EdgeTaskSetStrategy{
+				BatchSize:      intOrStr(intstr.FromInt(0)),
+				MaxUnavailable: intOrStr(intstr.FromString("1%")),
+			},
+			total:              10,
+			wantBatchSize:      1,
+			wantMaxUnavailable: 1,
+		},
+		{
+			name:               "invalid",
+			strategy:           edgev1beta1.EdgeTaskSetStrategy{BatchSize: intOrStr(intstr.FromString("abc"))},
+			total:              10,
+			wantBatchSize:      1,
+			wantMaxUnavailable: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			batchSize, maxUnavailable := RolloutLimits(tt.strategy, tt.total)
+			if batchSize != tt.wantBatchSize {
+				t.Errorf("RolloutLimits() batchSize = %v, want %v", batchSize, tt.wantBatchSize)
+			}
+			if maxUnavailable != tt.wantMaxUnavailable {
+				t.Errorf("RolloutLimits() maxUnavailable = %v, want %v", maxUnavailable, tt.wantMaxUnavailable)
+			}
+		})
+	}
+}
+
+func TestPatchResource(t *testing.T) {
+	obj := &unstructured.Unstructured{}
+	obj.SetAPIVersion("apps/v1")
+	obj.SetKind("Deployment")
+	obj.SetName("app")
+	obj.SetNamespace("default")
+	_ = unstructured.SetNestedField(obj.Object, int64(1), "spec", "replicas")
+
+	tests := []struct {
+		name         string
+		patches      []string
+		wantReplicas int64
+		wantErr      bool
+	}{
+		{
+			name:         "no patch",
+			wantReplicas: 1,
+		},
+		{
+			name:         "patched",
+			patches:      []string{`{"apiVersion":"apps/v1","kind":"Deployment","metadata":{"name":"app"},"spec":{"replicas":3}}`},
+			wantReplicas: 3,
+		},
+		{
+			name: "patched in order",
+			patches: []string{
+				`{"apiVersion":"apps/v1","kind":"Deployment","metadata":{"name":"app"},"spec":{"replicas":3}}`,
+				`{"apiVersion":"apps/v1","kind":"Deployment","metadata":{"name":"app","namespace":"default"},"spec":{"replicas":5}}`,
+			},
+			wantReplicas: 5,
+		},
+		{
+			name: "not matched",
+			patches: []string{
+				`{"apiVersion":"apps/v1","kind":"Deployment","metadata":{"name":"other"},"spec":{"replicas":3}}`,
+				`{"apiVersion":"apps/v1","kind":"StatefulSet","metadata":{"name":"app"},"spec":{"replicas":3}}`,
+				`{"apiVersion":"apps/v1","kind":"Deployment","metadata":{"name":"app","namespace":"other"},"spec":{"replicas":3}}`,
+			},
+			wantReplicas: 1,
+		},
+		{
+			name:    "invalid patch",
+			patches: []string{`not json`},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			patches := [][]byte{}
+			for _, patch := range tt.patches {
+				patches = append(patches, []byte(patch))
+			}
+			content, err := PatchResource(obj, patches)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("PatchResource() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			patched := &unstructured.Unstructured{}
+			if err := patched.UnmarshalJSON(content); err != nil {
+				t.Fatal(err)
+			}
+			replicas, _, _ := unstructured.NestedInt64(patched.Object, "spec", "replicas")
+			if replicas != tt.wantReplicas {
+				t.Errorf("PatchResource() replicas = %v, want %v", replicas, tt.wantReplicas)
+			}
+			if patched.GetName() != "app" || patched.GetNamespace() != "default" {
+				t.Errorf("PatchResource() changed identity of resource: %s/%s", patched.GetNamespace(), patched.GetName())
+			}
+		})
+	}
+}
+
+func TestRenderEdgeTask(t *testing.T) {
+	taskset := newTestTaskSet()
+	taskset.Spec.Template.Labels = map[string]string{"app": "demo"}
+	taskset.Spec.Overrides = []edgev1beta1.EdgeTaskOverride{
+		{
+			EdgeClusterNames: []string{"edge-a"},
+			Patches: []runtime.RawExtension{
+				{Raw: []byte(`{"apiVersion":"apps/v1","kind":"Deployment","metadata":{"name":"app"},"spec":{"replicas":3}}`)},
+			},
+		},
+	}
+
+	taska, err := RenderEdgeTask(taskset, "edge-a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	taskb, err := RenderEdgeTask(taskset, "edge-b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if taska.Name != "demo-edge-a" || taska.Namespace != taskset.Namespace || taska.Spec.EdgeClusterName != "edge-a" {
+		t.Errorf("RenderEdgeTask() unexpected task %s/%s on %s", taska.Namespace, taska.Name, taska.Spec.EdgeClusterName)
+	}
+	if taska.Labels["app"] != "demo" || taska.Labels[edgev1beta1.LabelEdgeTaskSet] != taskset.Name {
+		t.Errorf("RenderEdgeTask() unexpected labels %v", taska.Labels)
+	}
+	// multi documents template is split into resources
+	if len(taska.Spec.Resources) != 2 || len(taskb.Spec.Resources) != 2 {
+		t.Fatalf("RenderEdgeTask() want 2 resources, got %d and %d", len(taska.Spec.Resources), len(taskb.Spec.Resources))
+	}
+	if replicas := replicasOf(t, taska.Spec.Resources[0]); replicas != 3 {
+		t.Errorf("RenderEdgeTask() override not applied, replicas = %v", replicas)
+	}
+	if replicas := replicasOf(t, taskb.Spec.Resources[0]); replicas != 1 {
+		t.Errorf("RenderEdgeTask() override applied to other edge cluster, replicas = %v", replicas)
+	}
+	hasha, hashb := taska.Annotations[edgev1beta1.AnnotationEdgeTaskSetHash], taskb.Annotations[edgev1beta1.AnnotationEdgeTaskSetHash]
+	if hasha == "" || hasha == hashb {
+		t.Errorf("RenderEdgeTask() hash should differ on overrides, got %s and %s", hasha, hashb)
+	}
+	again, err := RenderEdgeTask(taskset, "edge-a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.Annotations[edgev1beta1.AnnotationEdgeTaskSetHash] != hasha {
+		t.Errorf("RenderEdgeTask() hash is not stable")
+	}
+
+	taskset.Spec.Template.Resources = []runtime.RawExtension{{Raw: []byte("- invalid")}}
+	if _, err := RenderEdgeTask(taskset, "edge-a"); err == nil {
+		t.Errorf("RenderEdgeTask() want error on invalid template")
+	}
+}
+
+func TestTaskSetReconciler_rollout(t *testing.T) {
+	ctx := context.Background()
+	taskset := newTestTaskSet()
+	taskset.Spec.Strategy.BatchSize = &intstr.IntOrString{Type: intstr.Int, IntVal: 2}
+	taskset.Spec.Strategy.PauseOnFailure = true
+
+	objs := []client.Object{
+		taskset,
+		newTestEdgeCluster("edge-a", edgev1beta1.EdgePhaseOnline),
+		newTestEdgeCluster("edge-b", edgev1beta1.EdgePhaseOnline),
+		newTestEdgeCluster("edge-c", edgev1beta1.EdgePhaseOnline),
+		newTestEdgeCluster("edge-d", edgev1beta1.EdgePhaseOnline),
+		newTestEdgeCluster("edge-e", edgev1beta1.EdgePhaseOffline),
+	}
+	unselected := newTestEdgeCluster("edge-x", edgev1beta1.EdgePhaseOnline)
+	unselected.Labels = nil
+	objs = append(objs, unselected)
+
+	scheme := runtime.NewScheme()
+	if err := edgev1beta1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	cli := fake.NewClientBuilder().WithScheme(scheme).WithObjects(objs...).Build()
+	r := &TaskSetReconciler{Client: cli}
+
+	// first batch,edge task on offline edge cluster is updated without limit
+	status := rolloutAndCheck(ctx, t, r, taskset, edgev1beta1.EdgeTaskSetPhaseProgressing, "edge-a", "edge-b", "edge-e")
+	if status.Clusters != 5 || status.Updated != 3 || status.Ready != 0 {
+		t.Errorf("rollout() unexpected status %+v", status)
+	}
+	// first batch is not ready,no more edge tasks updated
+	rolloutAndCheck(ctx, t, r, taskset, edgev1beta1.EdgeTaskSetPhaseProgressing, "edge-a", "edge-b", "edge-e")
+
+	// one edge task of first batch ready,left one slot
+	setTestTaskReady(ctx, t, cli, "demo-edge-a")
+	rolloutAndCheck(ctx, t, r, taskset, edgev1beta1.EdgeTaskSetPhaseProgressing, "edge-a", "edge-b", "edge-c", "edge-e")
+
+	// failed edge task pauses the rollout
+	setTestTaskFailed(ctx, t, cli, "demo-edge-b")
+	status = rolloutAndCheck(ctx, t, r, taskset, edgev1beta1.EdgeTaskSetPhasePaused, "edge-a", "edge-b", "edge-c", "edge-e")
+	if status.Failed != 1 {
+		t.Errorf("rollout() want 1 failed, got %d", status.Failed)
+	}
+
+	// failure recovered,rollout continues
+	setTestTaskReady(ctx, t, cli, "demo-edge-b")
+	setTestTaskReady(ctx, t, cli, "demo-edge-c")
+	rolloutAndCheck(ctx, t, r, taskset, edgev1beta1.EdgeTaskSetPhaseProgressing, "edge-a", "edge-b", "edge-c", "edge-d", "edge-e")
+	setTestTaskReady(ctx, t, cli, "demo-edge-d")
+	setTestTaskReady(ctx, t, cli, "demo-edge-e")
+	status = rolloutAndCheck(ctx, t, r, taskset, edgev1beta1.EdgeTaskSetPhaseCompleted, "edge-a", "edge-b", "edge-c", "edge-d", "edge-e")
+	if status.Ready != 5 {
+		t.Errorf("rollout() want 5 ready, got %d", status.Ready)
+	}
+
+	// template changed,edge tasks are updated in batch again
+	taskset.Spec.Template.Resources = []runtime.RawExtension{{Raw: []byte(strings.Replace(testDeployment, "replicas: 1", "replicas: 2", 1))}}
+	status = rolloutAndCheck(ctx, t, r, taskset, edgev1beta1.EdgeTaskSetPhaseProgressing, "edge-a", "edge-b", "edge-c", "edge-d", "edge-e")
+	if status.Updated != 3 {
+		t.Errorf("rollout() want 3 updated, got %d", status.Updated)
+	}
+
+	// paused
+	taskset.Spec.Paused = true
+	status = rolloutAndCheck(ctx, t, r, taskset, edgev1beta1.EdgeTaskSetPhasePaused, "edge-a", "edge-b", "edge-c", "edge-d", "edge-e")
+	if status.Updated != 3 {
+		t.Errorf("rollout() want 3 updated on paused, got %d", status.Updated)
+	}
+
+	// edge task of unselected edge cluster is removed
+	taskset.Spec.Paused = false
+	taskset.Spec.Selector = &metav1.LabelSelector{MatchLabels: map[string]string{"name": "edge-a"}}
+	rolloutAndCheck(ctx, t, r, taskset, edgev1beta1.EdgeTaskSetPhaseProgressing, "edge-a")
+
+	// no edge cluster selected
+	taskset.Spec.Selector = &metav1.LabelSelector{MatchLabels: map[string]string{"name": "edge-none"}}
+	rolloutAndCheck(ctx, t, r, taskset, edgev1beta1.EdgeTaskSetPhaseNoTargets)
+}
+
+func rolloutAndCheck(ctx context.Context, t *testing.T, r *TaskSetReconciler,
+	taskset *edgev1beta1.EdgeTaskSet, wantPhase edgev1beta1.EdgeTaskSetPhase, wantClusters ...string,
+) edgev1beta1.EdgeTaskSetStatus {
+	t.Helper()
+	status, err := r.rollout(ctx, taskset)
+	if err != nil {
+		t.Fatalf("rollout() error = %v", err)
+	}
+	if status.Phase != wantPhase {
+		t.Errorf("rollout() phase = %v, want %v: %s", status.Phase, wantPhase, status.Message)
+	}
+	tasks, err := r.ownedTasks(ctx, taskset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tasks) != len(wantClusters) {
+		t.Fatalf("rollout() want edge tasks on %v, got %d edge tasks", wantClusters, len(tasks))
+	}
+	for _, cluster := range wantClusters {
+		task, ok := tasks[EdgeTaskSetTaskName(taskset.Name, cluster)]
+		if !ok {
+			t.Fatalf("rollout() edge task on %s not found", cluster)
+		}
+		if task.Spec.EdgeClusterName != cluster {
+			t.Errorf("rollout() edge task %s on %s, want %s", task.Name, task.Spec.EdgeClusterName, cluster)
+		}
+	}
+	return status
+}
+
+func setTestTaskReady(ctx context.Context, t *testing.T, cli client.Client, name string) {
+	t.Helper()
+	task := &edgev1beta1.EdgeTask{}
+	if err := cli.Get(ctx, client.ObjectKey{Namespace: "default", Name: name}, task); err != nil {
+		t.Fatal(err)
+	}
+	resources, err := ParseResources(ctx, task)
+	if err != nil {
+		t.Fatal(err)
+	}
+	task.Annotations[AnnotationEdgeTaskResourcesHash] = HashResources(resources)
+	task.Status = edgev1beta1.EdgeTaskStatus{Phase: edgev1beta1.EdgeTaskPhaseRunning}
+	if err := cli.Update(ctx, task); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func setTestTaskFailed(ctx context.Context, t *testing.T, cli client.Client, name string) {
+	t.Helper()
+	task := &edgev1beta1.EdgeTask{}
+	if err := cli.Get(ctx, client.ObjectKey{Namespace: "default", Name: name}, task); err != nil {
+		t.Fatal(err)
+	}
+	task.Status = edgev1beta1.EdgeTaskStatus{Phase: edgev1beta1.EdgeTaskPhaseFailed}
+	if err := cli.Update(ctx, task); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func newTestTaskSet() *edgev1beta1.EdgeTaskSet {
+	return &edgev1beta1.EdgeTaskSet{
+		ObjectMeta: metav1.ObjectMeta{Name: "demo", Namespace: "default", UID: "demo-uid"},
+		Spec: edgev1beta1.EdgeTaskSetSpec{
+			Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"group": "demo"}},
+			Template: edgev1beta1.EdgeTaskTemplate{
+				Resources: []runtime.RawExtension{{Raw: []byte(testDeployment)}},
+			},
+		},
+	}
+}
+
+func newTestEdgeCluster(name string, phase edgev1beta1.EdgePhase) *edgev1beta1.EdgeCluster {
+	return &edgev1beta1.EdgeCluster{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "default",
+			Labels:    map[string]string{"group": "demo", "name": name},
+		},
+		Status: edgev1beta1.EdgeClusterStatus{Phase: phase},
+	}
+}
+
+func replicasOf(t *testing.T, raw runtime.RawExtension) int64 {
+	t.Helper()
+	obj := &unstructured.Unstructured{}
+	if err := obj.UnmarshalJSON(raw.Raw); err != nil {
+		t.Fatal(err)
+	}
+	replicas, _, _ := unstructured.NestedInt64(obj.Object, "spec", "replicas")
+	return replicas
+}
@@ -0,0 +1,211 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tunnel
+
+import (
+	"errors"
+	"sync"
+)
+
+// Flow control
+// Every connection has a receive window, the remote peer can not send more data than the window
+// until we read the data and send back a window update(PacketKindWindow).
+// The window is negotiated on connection open, a peer without flow control support
+// does not advertise a window and the connection falls back to unlimited sending.
+//
+// All packets sent on a tunnel go through a scheduler, data of different connections
+// are sent in round robin so one busy connection can not starve the others.
+
+const (
+	DefaultWindowSize    = 256 << 10
+	DefaultMaxPacketSize = 16 << 10
+	DefaultMaxBufferSize = 16 << 20
+)
+
+var ErrTunnelClosed = errors.New("tunnel closed")
+
+type FlowControlOptions struct {
+	WindowSize    int64 `json:"windowSize,omitempty" description:"receive window size of a tunnel connection in bytes"`
+	MaxPacketSize int   `json:"maxPacketSize,omitempty" description:"max data size of a packet,large writes are split into packets"`
+	MaxBufferSize int64 `json:"maxBufferSize,omitempty" description:"max buffered bytes of a tunnel connection,on both received and to send"`
+}
+
+func NewDefaultFlowControlOptions() *FlowControlOptions {
+	return &FlowControlOptions{
+		WindowSize:    DefaultWindowSize,
+		MaxPacketSize: DefaultMaxPacketSize,
+		MaxBufferSize: DefaultMaxBufferSize,
+	}
+}
+
+// complete fill empty fields with default values
+func (o FlowControlOptions) complete() FlowControlOptions {
+	if o.WindowSize <= 0 {
+		o.WindowSize = DefaultWindowSize
+	}
+	if o.MaxPacketSize <= 0 {
+		o.MaxPacketSize = DefaultMaxPacketSize
+	}
+	if o.MaxBufferSize <= 0 {
+		o.MaxBufferSize = DefaultMaxBufferSize
+	}
+	if o.MaxBufferSize < o.WindowSize {
+		o.MaxBufferSize = o.WindowSize
+	}
+	return o
+}
+
+type streamKey struct {
+	src string
+	cid int64
+}
+
+type sendQueue struct {
+	packets []*Packet
+	size    int64
+}
+
+// sendScheduler serializes packets sent on a tunnel.
+// control packets are sent first, data packets are sent in round robin of connections.
+type sendScheduler struct {
+	tunnel   Tunnel
+	maxqueue int64
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	control []*Packet
+	queues  map[streamKey]*sendQueue
+	ready   []streamKey // connections with pending packets,in round robin order
+	err     error
+}
+
+func newSendScheduler(tunnel Tunnel, maxqueue int64) *sendScheduler {
+	s := &sendScheduler{
+		tunnel:   tunnel,
+		maxqueue: maxqueue,
+		queues:   map[streamKey]*sendQueue{},
+	}
+	s.cond = sync.NewCond(&s.mu)
+	go s.run()
+	return s
+}
+
+func isControlPacket(pkt *Packet) bool {
+	switch pkt.Kind {
+	case PacketKindData, PacketKindOpen, PacketKindClose:
+		// must keep order in the connection
+		return false
+	default:
+		return true
+	}
+}
+
+// enqueue add packet to send queue.
+// if queue of the connection is full, block until space available when block is true, or return ErrFullChannel.
+func (s *sendScheduler) enqueue(pkt *Packet, block bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if isControlPacket(pkt) {
+		if s.err != nil {
+			return s.err
+		}
+		s.control = append(s.control, pkt)
+		s.cond.Broadcast()
+		return nil
+	}
+	key := streamKey{src: pkt.Src, cid: pkt.SrcCID}
+	for {
+		if s.err != nil {
+			return s.err
+		}
+		q, ok := s.queues[key]
+		if !ok {
+			q = &sendQueue{}
+			s.queues[key] = q
+			s.ready = append(s.ready, key)
+		}
+		// always accept one packet even if it's larger than the limit
+		if len(q.packets) == 0 || q.size+int64(len(pkt.Data)) <= s.maxqueue {
+			q.packets = append(q.packets, pkt)
+			q.size += int64(len(pkt.Data))
+			s.cond.Broadcast()
+			return nil
+		}
+		if !block {
+			return ErrFullChannel
+		}
+		s.cond.Wait()
+	}
+}
+
+// next returns next packet to send,nil if scheduler closed
+func (s *sendScheduler) next() *Packet {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for {
+		if s.err != nil {
+			return nil
+		}
+		if len(s.control) > 0 {
+			pkt := s.control[0]
+			s.control = s.control[1:]
+			return pkt
+		}
+		if len(s.ready) > 0 {
+			key := s.ready[0]
+			s.ready = s.ready[1:]
+			q := s.queues[key]
+			pkt := q.packets[0]
+			q.packets = q.packets[1:]
+			q.size -= int64(len(pkt.Data))
+			if len(q.packets) > 0 {
+				// move to the tail
+				s.ready = append(s.ready, key)
+			} else {
+				delete(s.queues, key)
+			}
+			// wake up writers waiting for space
+			s.cond.Broadcast()
+			return pkt
+		}
+		s.cond.Wait()
+	}
+}
+
+func (s *sendScheduler) run() {
+	for {
+		pkt := s.next()
+		if pkt == nil {
+			return
+		}
+		if err := s.tunnel.Send(pkt); err != nil {
+			s.close(err)
+			return
+		}
+	}
+}
+
+func (s *sendScheduler) close(err error) {
+	if err == nil {
+		err = ErrTunnelClosed
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err == nil {
+		s.err = err
+	}
+	s.control, s.queues, s.ready = nil, map[streamKey]*sendQueue{}, nil
+	s.cond.Broadcast()
+}
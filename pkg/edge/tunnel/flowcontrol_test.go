@@ -0,0 +1,313 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tunnel
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// pipeTunnel is an in memory Tunnel,send blocks when the peer is not receiving like a real stream.
+type pipeTunnel struct {
+	in     chan *Packet
+	out    chan *Packet
+	closed chan struct{}
+	once   *sync.Once
+}
+
+func newPipeTunnel(size int) (*pipeTunnel, *pipeTunnel) {
+	a2b, b2a := make(chan *Packet, size), make(chan *Packet, size)
+	closed, once := make(chan struct{}), &sync.Once{}
+	return &pipeTunnel{in: b2a, out: a2b, closed: closed, once: once},
+		&pipeTunnel{in: a2b, out: b2a, closed: closed, once: once}
+}
+
+func (p *pipeTunnel) Recv(into *Packet) error {
+	select {
+	case pkt := <-p.in:
+		*into = *pkt
+		return nil
+	case <-p.closed:
+		return io.EOF
+	}
+}
+
+func (p *pipeTunnel) Send(pkt *Packet) error {
+	copied := *pkt
+	select {
+	case p.out <- &copied:
+		return nil
+	case <-p.closed:
+		return io.EOF
+	}
+}
+
+func (p *pipeTunnel) Close() error {
+	p.once.Do(func() { close(p.closed) })
+	return nil
+}
+
+// setupTunnelPair connect client to server,returns client side server
+func setupTunnelPair(t *testing.T, flow *FlowControlOptions) (*TunnelServer, *TunnelServer) {
+	ctx, cancel := context.WithCancel(context.Background())
+	server, client := NewTunnelServer("server", nil), NewTunnelServer("client", nil)
+	server.SetFlowControl(flow)
+	client.SetFlowControl(flow)
+
+	serverside, clientside := newPipeTunnel(16)
+	go server.Connect(ctx, serverside, "", nil, TunnelOptions{})
+	go client.Connect(ctx, clientside, "", nil, TunnelOptions{SendRouteChange: true, IsDefaultOut: true})
+	t.Cleanup(func() {
+		cancel()
+		serverside.Close()
+	})
+	// wait route exchanged
+	deadline := time.Now().Add(5 * time.Second)
+	for !server.routeTable.Exists("client") || !client.routeTable.Exists("server") {
+		if time.Now().After(deadline) {
+			t.Fatal("tunnel not connected")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return server, client
+}
+
+// listen starts a tcp server runs handler on every connection
+func listen(t *testing.T, handler func(conn net.Conn)) string {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { lis.Close() })
+	go func() {
+		for {
+			conn, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				handler(conn)
+			}()
+		}
+	}()
+	return lis.Addr().String()
+}
+
+func TestTunnelConn_Transfer(t *testing.T) {
+	server, _ := setupTunnelPair(t, &FlowControlOptions{WindowSize: 1 << 10, MaxPacketSize: 128})
+
+	payload := bytes.Repeat([]byte("0123456789abcdef"), 4<<10) // 64KiB,much larger than window
+	addr := listen(t, func(conn net.Conn) {
+		conn.Write(payload)
+	})
+	conn, err := server.DialerOn("client").DialTimeout("tcp", addr, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	received := make([]byte, len(payload))
+	if _, err := io.ReadFull(conn, received); err != nil {
+		t.Fatalf("read error = %v", err)
+	}
+	if !bytes.Equal(received, payload) {
+		t.Fatal("received data mismatch")
+	}
+}
+
+func TestTunnelConn_SlowConsumer(t *testing.T) {
+	flow := &FlowControlOptions{WindowSize: 64 << 10, MaxPacketSize: 4 << 10, MaxBufferSize: 1 << 20}
+	server, _ := setupTunnelPair(t, flow)
+
+	// a bulk connection never read by the consumer
+	bulkdone := make(chan struct{})
+	bulkaddr := listen(t, func(conn net.Conn) {
+		chunk := make([]byte, 32<<10)
+		for {
+			if _, err := conn.Write(chunk); err != nil {
+				close(bulkdone)
+				return
+			}
+		}
+	})
+	echoaddr := listen(t, func(conn net.Conn) {
+		io.Copy(conn, conn)
+	})
+
+	dialer := server.DialerOn("client")
+	bulk, err := dialer.DialTimeout("tcp", bulkaddr, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bulk.Close()
+	// let the bulk connection fill the window and the tcp buffers
+	time.Sleep(200 * time.Millisecond)
+
+	echo, err := dialer.DialTimeout("tcp", echoaddr, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer echo.Close()
+
+	// interactive traffic must not be blocked by the bulk connection
+	for i := 0; i < 20; i++ {
+		msg := []byte("ping")
+		start := time.Now()
+		if _, err := echo.Write(msg); err != nil {
+			t.Fatalf("echo write error = %v", err)
+		}
+		reply := make([]byte, len(msg))
+		if _, err := io.ReadFull(echo, reply); err != nil {
+			t.Fatalf("echo read error = %v", err)
+		}
+		if !bytes.Equal(reply, msg) {
+			t.Fatalf("echo reply = %s, want %s", reply, msg)
+		}
+		if cost := time.Since(start); cost > time.Second {
+			t.Fatalf("echo round trip took %s", cost)
+		}
+	}
+
+	// unread data of the bulk connection is limited by the window
+	tunconn := bulk.(*TunnelConn)
+	tunconn.mu.Lock()
+	buffered := tunconn.buffered
+	tunconn.mu.Unlock()
+	if buffered == 0 || buffered > flow.WindowSize {
+		t.Fatalf("bulk connection buffered %d bytes, want (0, %d]", buffered, flow.WindowSize)
+	}
+
+	// read resumes the bulk connection
+	if _, err := io.ReadFull(bulk, make([]byte, 4*flow.WindowSize)); err != nil {
+		t.Fatalf("bulk read error = %v", err)
+	}
+	select {
+	case <-bulkdone:
+		t.Fatal("bulk connection closed unexpectedly")
+	default:
+	}
+}
+
+func TestTunnelConn_LegacyPeer(t *testing.T) {
+	flow := NewDefaultFlowControlOptions().complete()
+	conns := &Connections{local: "server", flow: flow, connections: map[int64]*TunnelConn{}}
+
+	// a peer without flow control acks with empty data
+	opened := conns.pending(nil, "client", 0)
+	if err := opened.recv(1, nil, ""); err != nil {
+		t.Fatal(err)
+	}
+	ack := <-opened.ack
+	opened.opened(ack.remoteID, ack.data)
+	// a peer without flow control opens without window
+	accepted := conns.pending(nil, "client", 2)
+	accepted.acceptFrom(PacketDataOpen{Network: "tcp", Address: "127.0.0.1:80"})
+
+	for _, conn := range []*TunnelConn{opened, accepted} {
+		if conn.remoteFlow || conn.sendWindow != -1 {
+			t.Fatalf("remoteFlow = %v, sendWindow = %d, want unlimited", conn.remoteFlow, conn.sendWindow)
+		}
+		// sending is not limited by window
+		if size, err := conn.acquire(int(flow.WindowSize) * 2); err != nil || size != flow.MaxPacketSize {
+			t.Fatalf("acquire() = %d, %v, want %d", size, err, flow.MaxPacketSize)
+		}
+		// no window update is sent back
+		if err := conn.recv(1, make([]byte, flow.WindowSize), ""); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.ReadFull(conn, make([]byte, flow.WindowSize)); err != nil {
+			t.Fatalf("read error = %v", err)
+		}
+	}
+}
+
+func TestSendScheduler_RoundRobin(t *testing.T) {
+	local, remote := newPipeTunnel(0)
+	defer local.Close()
+	s := newSendScheduler(local, 1<<20)
+	defer s.close(nil)
+
+	// block the sender until all packets queued
+	s.mu.Lock()
+	for i := 0; i < 3; i++ {
+		s.control = append(s.control, &Packet{Kind: PacketKindRoute})
+	}
+	s.mu.Unlock()
+	for i := 0; i < 3; i++ {
+		for _, cid := range []int64{1, 2} {
+			if err := s.enqueue(&Packet{Kind: PacketKindData, Src: "a", SrcCID: cid, Data: []byte{byte(i)}}, true); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := s.enqueue(&Packet{Kind: PacketKindWindow, Src: "a", SrcCID: 1}, true); err != nil {
+		t.Fatal(err)
+	}
+
+	got := []int64{}
+	for i := 0; i < 10; i++ {
+		pkt := &Packet{}
+		if err := remote.Recv(pkt); err != nil {
+			t.Fatal(err)
+		}
+		if pkt.Kind == PacketKindData {
+			got = append(got, pkt.SrcCID)
+		}
+	}
+	// connections are interleaved instead of draining one by one
+	for i := 1; i < len(got); i++ {
+		if got[i] == got[i-1] {
+			t.Fatalf("packets sent in order %v, want interleaved", got)
+		}
+	}
+}
+
+func TestSendScheduler_QueueLimit(t *testing.T) {
+	local, _ := newPipeTunnel(0)
+	defer local.Close()
+	s := newSendScheduler(local, 10)
+
+	// the first packet is taken by the sender and blocked on the pipe
+	if err := s.enqueue(&Packet{Kind: PacketKindData, SrcCID: 1, Data: make([]byte, 10)}, false); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if err := s.enqueue(&Packet{Kind: PacketKindData, SrcCID: 1, Data: make([]byte, 10)}, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.enqueue(&Packet{Kind: PacketKindData, SrcCID: 1, Data: make([]byte, 1)}, false); err != ErrFullChannel {
+		t.Fatalf("enqueue() error = %v, want %v", err, ErrFullChannel)
+	}
+	// other connections are not affected
+	if err := s.enqueue(&Packet{Kind: PacketKindData, SrcCID: 2, Data: make([]byte, 10)}, false); err != nil {
+		t.Fatal(err)
+	}
+	// blocked enqueue returns on close
+	errch := make(chan error)
+	go func() {
+		errch <- s.enqueue(&Packet{Kind: PacketKindData, SrcCID: 1, Data: make([]byte, 1)}, true)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	s.close(nil)
+	if err := <-errch; err != ErrTunnelClosed {
+		t.Fatalf("enqueue() error = %v, want %v", err, ErrTunnelClosed)
+	}
+}
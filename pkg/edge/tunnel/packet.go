@@ -25,6 +25,8 @@ const (
 	PacketKindOpen                      // open connection
 	PacketKindClose                     // close connect/stream
 	PacketKindRoute                     // route update
+	PacketKindWindow                    // flow control window update
+	PacketKindAuth                      // session token and credentials from upstream
 )
 
 type PacketKind int
@@ -65,6 +67,27 @@ type PacketDataOpen struct {
 	Network string        `json:"network,omitempty"`
 	Address string        `json:"address,omitempty"`
 	Timeout time.Duration `json:"timeout,omitempty"`
+	Window  int64         `json:"window,omitempty"` // receive window of the opener,empty if flow control not supported
+}
+
+// PacketDataWindow is the initial window in open ack,or the window increment in window update.
+type PacketDataWindow struct {
+	Size int64 `json:"size,omitempty"`
+}
+
+// PacketDataAuth is sent by upstream to a connected downstream peer.
+type PacketDataAuth struct {
+	Session     string                    `json:"session,omitempty"`     // session token issued to the receiver
+	ExpireAt    *time.Time                `json:"expireAt,omitempty"`    // expiration of the session token
+	Credentials *PacketDataCredentials    `json:"credentials,omitempty"` // replace credentials of the receiver if set
+	Relay       map[string]PacketDataAuth `json:"relay,omitempty"`       // session tokens of peers behind the receiver,the receiver relays them
+}
+
+// PacketDataCredentials is credentials of peers connected to a hub.
+type PacketDataCredentials struct {
+	Tokens      map[string]string `json:"tokens,omitempty"`      // peer -> sha256 of bootstrap token
+	Revoked     []string          `json:"revoked,omitempty"`     // revoked peers
+	SessionKeys []string          `json:"sessionKeys,omitempty"` // public keys to verify session tokens
 }
 
 func PacketEncode(data any) []byte {
@@ -0,0 +1,382 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tunnel
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Token authentication
+// A peer authenticates with either a bootstrap token or a session token.
+//   - bootstrap token is a long-lived token of the peer,it's checked by BootstrapTokenGetter on the server,
+//     the server synchronizes sha256 of bootstrap tokens to downstream hubs,so hubs can check them too.
+//   - session token is a short-lived token issued by the server only,it's sent to connected peers
+//     by PacketKindAuth and renewed before expired,peers behind a hub receive it relayed by the hub.
+//     Peers use it to reconnect.
+//
+// Session tokens are signed by ed25519 keys derived from the signing keys,which are kept on the server,
+// hubs receive the public keys along with the credentials and can verify but not issue session tokens.
+// A session token carries the generation of the peer's bootstrap token,
+// rotating the bootstrap token invalidates all sessions issued before.
+//
+// Signing keys are rotated by adding a new key to the head of the keys,
+// the first key signs new tokens and all keys are used to verify.
+// Revoked peers are rejected on both server and hubs whatever the token is.
+
+const (
+	DefaultSessionTTL  = 1 * time.Hour
+	sessionTokenPrefix = "session."
+	generationLength   = 16
+)
+
+var (
+	ErrInvalidToken = errors.New("invalid token")
+	ErrTokenExpired = errors.New("token expired")
+	ErrPeerRevoked  = errors.New("peer revoked")
+)
+
+type TokenAuthOptions struct {
+	SigningKeys []string      `json:"signingKeys,omitempty" description:"keys to sign session tokens,the first one signs and all verify,enable token authentication if set"`
+	SessionTTL  time.Duration `json:"sessionTTL,omitempty" description:"ttl of session tokens"`
+}
+
+func NewDefaultTokenAuthOptions() *TokenAuthOptions {
+	return &TokenAuthOptions{
+		SessionTTL: DefaultSessionTTL,
+	}
+}
+
+func (o *TokenAuthOptions) IsEnabled() bool {
+	return o != nil && len(o.SigningKeys) > 0
+}
+
+// BootstrapTokenGetter returns the bootstrap token of peer,
+// use IsDirectPeer to check whether the peer is connected directly or behind a hub.
+type BootstrapTokenGetter func(ctx context.Context, name string) (string, error)
+
+type directPeerKey struct{}
+
+// WithDirectPeer marks the peer in context is connected to us directly,not behind a hub.
+func WithDirectPeer(ctx context.Context) context.Context {
+	return context.WithValue(ctx, directPeerKey{}, true)
+}
+
+// IsDirectPeer returns true if the peer in context is connected to us directly.
+func IsDirectPeer(ctx context.Context) bool {
+	direct, _ := ctx.Value(directPeerKey{}).(bool)
+	return direct
+}
+
+type TokenAuthManager struct {
+	bootstrap BootstrapTokenGetter
+	ttl       time.Duration
+
+	mu        sync.RWMutex
+	signers   []ed25519.PrivateKey // empty on hubs
+	verifiers []ed25519.PublicKey
+	hashes    map[string]string // peer -> sha256 of bootstrap token,synchronized from upstream
+	revoked   map[string]struct{}
+}
+
+// NewTokenAuthManager returns the manager of the server,it issues and verifies session tokens.
+func NewTokenAuthManager(options *TokenAuthOptions, bootstrap BootstrapTokenGetter) (*TokenAuthManager, error) {
+	if !options.IsEnabled() {
+		return nil, errors.New("no signing keys provided")
+	}
+	if bootstrap == nil {
+		return nil, errors.New("no bootstrap token getter provided")
+	}
+	m := &TokenAuthManager{
+		bootstrap: bootstrap,
+		ttl:       options.SessionTTL,
+		hashes:    map[string]string{},
+		revoked:   map[string]struct{}{},
+	}
+	if m.ttl <= 0 {
+		m.ttl = DefaultSessionTTL
+	}
+	m.SetSigningKeys(options.SigningKeys)
+	return m, nil
+}
+
+// NewHubTokenAuthManager returns the manager of a hub,
+// bootstrap token hashes,revoked peers and verification keys are synchronized from upstream by SetCredentials.
+func NewHubTokenAuthManager() *TokenAuthManager {
+	return &TokenAuthManager{
+		hashes:  map[string]string{},
+		revoked: map[string]struct{}{},
+	}
+}
+
+func (m *TokenAuthManager) Authentication(ctx context.Context, name string, token string) error {
+	if m.IsRevoked(name) {
+		return ErrPeerRevoked
+	}
+	if token == "" {
+		return ErrInvalidToken
+	}
+	if strings.HasPrefix(token, sessionTokenPrefix) {
+		return m.verifySession(ctx, name, token)
+	}
+	return m.verifyBootstrap(ctx, name, token)
+}
+
+// SetSigningKeys replace signing keys,tokens signed by removed keys are invalid
+func (m *TokenAuthManager) SetSigningKeys(keys []string) {
+	signers := make([]ed25519.PrivateKey, 0, len(keys))
+	verifiers := make([]ed25519.PublicKey, 0, len(keys))
+	for _, key := range keys {
+		if key == "" {
+			continue
+		}
+		seed := sha256.Sum256([]byte(key))
+		signer := ed25519.NewKeyFromSeed(seed[:])
+		signers = append(signers, signer)
+		verifiers = append(verifiers, signer.Public().(ed25519.PublicKey))
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.signers, m.verifiers = signers, verifiers
+}
+
+// VerificationKeys returns public keys to verify session tokens,they are synchronized to hubs.
+func (m *TokenAuthManager) VerificationKeys() []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	keys := make([]string, 0, len(m.verifiers))
+	for _, key := range m.verifiers {
+		keys = append(keys, base64.StdEncoding.EncodeToString(key))
+	}
+	return keys
+}
+
+// CanIssue returns true if the manager holds signing keys,only the server can issue session tokens.
+func (m *TokenAuthManager) CanIssue() bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return len(m.signers) > 0 && m.bootstrap != nil
+}
+
+func (m *TokenAuthManager) SessionTTL() time.Duration {
+	return m.ttl
+}
+
+type sessionClaims struct {
+	Subject    string `json:"sub"`
+	Generation string `json:"gen"` // generation of the bootstrap token
+	IssuedAt   int64  `json:"iat"`
+	ExpireAt   int64  `json:"exp"`
+}
+
+// IssueSessionToken issue a session token for peer
+func (m *TokenAuthManager) IssueSessionToken(ctx context.Context, name string) (string, time.Time, error) {
+	if !m.CanIssue() {
+		return "", time.Time{}, errors.New("no signing keys")
+	}
+	generation, err := m.generationOf(ctx, name)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	now := time.Now()
+	expireAt := now.Add(m.ttl)
+	claims, err := json.Marshal(sessionClaims{Subject: name, Generation: generation, IssuedAt: now.Unix(), ExpireAt: expireAt.Unix()})
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	payload := base64.RawURLEncoding.EncodeToString(claims)
+	m.mu.RLock()
+	signature := base64.RawURLEncoding.EncodeToString(ed25519.Sign(m.signers[0], []byte(payload)))
+	m.mu.RUnlock()
+	return sessionTokenPrefix + payload + "." + signature, expireAt, nil
+}
+
+func (m *TokenAuthManager) verifySession(ctx context.Context, name string, token string) error {
+	payload, signature, ok := strings.Cut(strings.TrimPrefix(token, sessionTokenPrefix), ".")
+	if !ok {
+		return ErrInvalidToken
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil {
+		return ErrInvalidToken
+	}
+	if !m.verifySignature(payload, sig) {
+		return ErrInvalidToken
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return ErrInvalidToken
+	}
+	claims := sessionClaims{}
+	if err := json.Unmarshal(raw, &claims); err != nil {
+		return ErrInvalidToken
+	}
+	if claims.Subject != name {
+		return fmt.Errorf("%w: session token is not issued to %s", ErrInvalidToken, name)
+	}
+	if time.Now().Unix() >= claims.ExpireAt {
+		return ErrTokenExpired
+	}
+	generation, err := m.generationOf(ctx, name)
+	if err != nil {
+		return err
+	}
+	if subtle.ConstantTimeCompare([]byte(generation), []byte(claims.Generation)) != 1 {
+		return fmt.Errorf("%w: bootstrap token of %s has been rotated", ErrInvalidToken, name)
+	}
+	return nil
+}
+
+func (m *TokenAuthManager) verifySignature(payload string, sig []byte) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, key := range m.verifiers {
+		if ed25519.Verify(key, []byte(payload), sig) {
+			return true
+		}
+	}
+	return false
+}
+
+// generationOf returns the generation of peer's current bootstrap token
+func (m *TokenAuthManager) generationOf(ctx context.Context, name string) (string, error) {
+	hash, err := m.bootstrapHashOf(ctx, name)
+	if err != nil {
+		return "", err
+	}
+	return hash[:generationLength], nil
+}
+
+func (m *TokenAuthManager) bootstrapHashOf(ctx context.Context, name string) (string, error) {
+	if m.bootstrap != nil {
+		token, err := m.bootstrap(ctx, name)
+		if err != nil {
+			return "", fmt.Errorf("get bootstrap token of %s: %w", name, err)
+		}
+		if token == "" {
+			return "", ErrInvalidToken
+		}
+		return HashBootstrapToken(token), nil
+	}
+	m.mu.RLock()
+	hash := m.hashes[name]
+	m.mu.RUnlock()
+	if len(hash) < generationLength {
+		return "", ErrInvalidToken
+	}
+	return hash, nil
+}
+
+func (m *TokenAuthManager) verifyBootstrap(ctx context.Context, name string, token string) error {
+	hash, err := m.bootstrapHashOf(ctx, name)
+	if err != nil {
+		return err
+	}
+	if subtle.ConstantTimeCompare([]byte(hash), []byte(HashBootstrapToken(token))) != 1 {
+		return ErrInvalidToken
+	}
+	return nil
+}
+
+// SetCredentials replace bootstrap token hashes,verification keys and revoked peers,
+// returns peers revoked.
+func (m *TokenAuthManager) SetCredentials(credentials PacketDataCredentials) []string {
+	hashes := map[string]string{}
+	for k, v := range credentials.Tokens {
+		hashes[k] = v
+	}
+	verifiers := make([]ed25519.PublicKey, 0, len(credentials.SessionKeys))
+	for _, key := range credentials.SessionKeys {
+		raw, err := base64.StdEncoding.DecodeString(key)
+		if err != nil || len(raw) != ed25519.PublicKeySize {
+			continue
+		}
+		verifiers = append(verifiers, ed25519.PublicKey(raw))
+	}
+	m.mu.Lock()
+	m.hashes = hashes
+	m.verifiers = verifiers
+	m.mu.Unlock()
+	return m.SetRevoked(credentials.Revoked)
+}
+
+// Revoke add peers to revocation list
+func (m *TokenAuthManager) Revoke(names ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, name := range names {
+		m.revoked[name] = struct{}{}
+	}
+}
+
+// Unrevoke remove peers from revocation list
+func (m *TokenAuthManager) Unrevoke(names ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, name := range names {
+		delete(m.revoked, name)
+	}
+}
+
+// SetRevoked replace revocation list,returns peers newly revoked.
+func (m *TokenAuthManager) SetRevoked(names []string) []string {
+	revoked := make(map[string]struct{}, len(names))
+	added := []string{}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, name := range names {
+		revoked[name] = struct{}{}
+		if _, ok := m.revoked[name]; !ok {
+			added = append(added, name)
+		}
+	}
+	m.revoked = revoked
+	return added
+}
+
+func (m *TokenAuthManager) IsRevoked(name string) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	_, ok := m.revoked[name]
+	return ok
+}
+
+func (m *TokenAuthManager) Revoked() []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	ret := make([]string, 0, len(m.revoked))
+	for name := range m.revoked {
+		ret = append(ret, name)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// HashBootstrapToken returns hash of bootstrap token synchronized to hubs
+func HashBootstrapToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
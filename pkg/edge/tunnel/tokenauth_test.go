@@ -0,0 +1,356 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tunnel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func bootstrapTokens(tokens map[string]string) BootstrapTokenGetter {
+	return func(ctx context.Context, name string) (string, error) {
+		token, ok := tokens[name]
+		if !ok {
+			return "", fmt.Errorf("%s not found", name)
+		}
+		return token, nil
+	}
+}
+
+func TestTokenAuthManager_Authentication(t *testing.T) {
+	ctx := context.Background()
+	m, err := NewTokenAuthManager(&TokenAuthOptions{SigningKeys: []string{"key1"}}, bootstrapTokens(map[string]string{"edge": "bootstrap"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	session, _, err := m.IssueSessionToken(ctx, "edge")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		peer    string
+		token   string
+		wantErr bool
+	}{
+		{name: "bootstrap token", peer: "edge", token: "bootstrap"},
+		{name: "invalid bootstrap token", peer: "edge", token: "invalid", wantErr: true},
+		{name: "unknown peer", peer: "unknown", token: "bootstrap", wantErr: true},
+		{name: "empty token", peer: "edge", token: "", wantErr: true},
+		{name: "session token", peer: "edge", token: session},
+		{name: "session token of other peer", peer: "other", token: session, wantErr: true},
+		{name: "tampered session token", peer: "edge", token: session + "x", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := m.Authentication(ctx, tt.peer, tt.token); (err != nil) != tt.wantErr {
+				t.Errorf("Authentication() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestTokenAuthManager_Session(t *testing.T) {
+	ctx := context.Background()
+	tokens := map[string]string{"edge": "bootstrap"}
+	m, err := NewTokenAuthManager(&TokenAuthOptions{SigningKeys: []string{"key1"}}, bootstrapTokens(tokens))
+	if err != nil {
+		t.Fatal(err)
+	}
+	signedByKey1, _, _ := m.IssueSessionToken(ctx, "edge")
+
+	// expired
+	m.ttl = -time.Second
+	expired, _, _ := m.IssueSessionToken(ctx, "edge")
+	if err := m.Authentication(ctx, "edge", expired); !errors.Is(err, ErrTokenExpired) {
+		t.Errorf("Authentication() error = %v, want %v", err, ErrTokenExpired)
+	}
+	m.ttl = time.Hour
+
+	// rotate keys,tokens signed by previous key are still valid
+	m.SetSigningKeys([]string{"key2", "key1"})
+	signedByKey2, _, _ := m.IssueSessionToken(ctx, "edge")
+	for _, token := range []string{signedByKey1, signedByKey2} {
+		if err := m.Authentication(ctx, "edge", token); err != nil {
+			t.Errorf("Authentication() error = %v after rotation", err)
+		}
+	}
+	// remove previous key
+	m.SetSigningKeys([]string{"key2"})
+	if err := m.Authentication(ctx, "edge", signedByKey1); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Authentication() error = %v, want %v", err, ErrInvalidToken)
+	}
+	if err := m.Authentication(ctx, "edge", signedByKey2); err != nil {
+		t.Errorf("Authentication() error = %v", err)
+	}
+
+	// rotate bootstrap token,sessions issued before are invalid
+	tokens["edge"] = "rotated"
+	if err := m.Authentication(ctx, "edge", signedByKey2); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Authentication() error = %v after bootstrap token rotated, want %v", err, ErrInvalidToken)
+	}
+	rotated, _, _ := m.IssueSessionToken(ctx, "edge")
+	if err := m.Authentication(ctx, "edge", rotated); err != nil {
+		t.Errorf("Authentication() error = %v", err)
+	}
+}
+
+func TestTokenAuthManager_Credentials(t *testing.T) {
+	ctx := context.Background()
+	server, err := NewTokenAuthManager(&TokenAuthOptions{SigningKeys: []string{"key"}},
+		bootstrapTokens(map[string]string{"edge": "bootstrap", "revoked": "bootstrap"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	session, _, _ := server.IssueSessionToken(ctx, "edge")
+	revokedSession, _, _ := server.IssueSessionToken(ctx, "revoked")
+
+	// hub checks credentials synchronized from server and never issues session tokens
+	m := NewHubTokenAuthManager()
+	if _, _, err := m.IssueSessionToken(ctx, "edge"); err == nil {
+		t.Error("IssueSessionToken() on hub error = nil, want error")
+	}
+	for _, token := range []string{"bootstrap", session} {
+		if err := m.Authentication(ctx, "edge", token); err == nil {
+			t.Fatal("Authentication() want error before credentials synchronized")
+		}
+	}
+	credentials := PacketDataCredentials{
+		Tokens:      map[string]string{"edge": HashBootstrapToken("bootstrap"), "revoked": HashBootstrapToken("bootstrap")},
+		Revoked:     []string{"revoked"},
+		SessionKeys: server.VerificationKeys(),
+	}
+	revoked := m.SetCredentials(credentials)
+	if len(revoked) != 1 || revoked[0] != "revoked" {
+		t.Errorf("SetCredentials() = %v, want [revoked]", revoked)
+	}
+	for _, token := range []string{"bootstrap", session} {
+		if err := m.Authentication(ctx, "edge", token); err != nil {
+			t.Errorf("Authentication() error = %v", err)
+		}
+	}
+	for _, token := range []string{"bootstrap", revokedSession} {
+		if err := m.Authentication(ctx, "revoked", token); !errors.Is(err, ErrPeerRevoked) {
+			t.Errorf("Authentication() error = %v, want %v", err, ErrPeerRevoked)
+		}
+	}
+	// revoked again is not newly revoked
+	if revoked := m.SetRevoked([]string{"revoked"}); len(revoked) != 0 {
+		t.Errorf("SetRevoked() = %v, want empty", revoked)
+	}
+	m.Unrevoke("revoked")
+	if err := m.Authentication(ctx, "revoked", revokedSession); err != nil {
+		t.Errorf("Authentication() error = %v after unrevoke", err)
+	}
+
+	// bootstrap token rotated on server
+	credentials.Tokens["edge"] = HashBootstrapToken("rotated")
+	m.SetCredentials(credentials)
+	if err := m.Authentication(ctx, "edge", session); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Authentication() error = %v after bootstrap token rotated, want %v", err, ErrInvalidToken)
+	}
+}
+
+func TestTunnelServer_Revoke(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	auth, err := NewTokenAuthManager(&TokenAuthOptions{SigningKeys: []string{"key"}}, bootstrapTokens(map[string]string{"client": "bootstrap"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, client := NewTunnelServer("server", auth), NewTunnelServer("client", nil)
+	connect := func(token string) chan error {
+		serverside, clientside := newPipeTunnel(16)
+		errch := make(chan error, 2)
+		go func() { errch <- server.Connect(ctx, serverside, "", nil, TunnelOptions{}) }()
+		go func() {
+			errch <- client.Connect(ctx, clientside, client.upstreamToken(token), nil, TunnelOptions{SendRouteChange: true, IsDefaultOut: true})
+		}()
+		return errch
+	}
+	waitFor := func(cond func() bool) {
+		deadline := time.Now().Add(5 * time.Second)
+		for !cond() {
+			if time.Now().After(deadline) {
+				t.Fatal("timeout")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	connect("bootstrap")
+	waitFor(func() bool { return server.routeTable.Exists("client") })
+	// session token issued to client
+	waitFor(func() bool { return client.upstreamToken("bootstrap") != "bootstrap" })
+
+	// reconnect with session token
+	server.kick("client")
+	waitFor(func() bool { return !server.routeTable.Exists("client") && !client.routeTable.Exists("server") })
+	connect("")
+	waitFor(func() bool { return server.routeTable.Exists("client") })
+
+	// revoked client is disconnected and can not connect again
+	server.Revoke("client")
+	waitFor(func() bool { return !server.routeTable.Exists("client") && !client.routeTable.Exists("server") })
+	errch := connect("bootstrap")
+	if err := <-errch; err == nil {
+		t.Fatal("revoked client connected")
+	}
+	if server.routeTable.Exists("client") {
+		t.Fatal("revoked client connected")
+	}
+}
+
+func TestTunnelServer_RelaySession(t *testing.T) {
+	defer func(interval time.Duration) { sessionCheckInterval = interval }(sessionCheckInterval)
+	sessionCheckInterval = 10 * time.Millisecond
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	serverauth, err := NewTokenAuthManager(&TokenAuthOptions{SigningKeys: []string{"key"}},
+		bootstrapTokens(map[string]string{"edge": "bootstrap", "hub": "hubtoken"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	hubauth := NewHubTokenAuthManager()
+	hubauth.SetCredentials(PacketDataCredentials{
+		Tokens:      map[string]string{"edge": HashBootstrapToken("bootstrap")},
+		SessionKeys: serverauth.VerificationKeys(),
+	})
+	server, hub, edge := NewTunnelServer("server", serverauth), NewTunnelServer("hub", hubauth), NewTunnelServer("edge", nil)
+
+	serverside, hubup := newPipeTunnel(16)
+	go server.Connect(ctx, serverside, "", nil, TunnelOptions{})
+	go hub.Connect(ctx, hubup, "hubtoken", nil, TunnelOptions{SendRouteChange: true, IsDefaultOut: true})
+	connectEdge := func(token string) {
+		hubdown, edgeup := newPipeTunnel(16)
+		go hub.Connect(ctx, hubdown, "", nil, TunnelOptions{})
+		go edge.Connect(ctx, edgeup, edge.upstreamToken(token), nil, TunnelOptions{SendRouteChange: true, IsDefaultOut: true})
+	}
+	waitFor := func(cond func() bool) {
+		deadline := time.Now().Add(5 * time.Second)
+		for !cond() {
+			if time.Now().After(deadline) {
+				t.Fatal("timeout")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	// edge connects after hub connected,or server may not know the edge is behind the hub
+	waitFor(func() bool { return server.routeTable.Exists("hub") && hub.routeTable.Exists("server") })
+	connectEdge("bootstrap")
+	waitFor(func() bool { return hub.routeTable.Exists("edge") })
+	// session tokens issued by server,the edge's one is relayed by hub
+	waitFor(func() bool { return hub.upstreamToken("hubtoken") != "hubtoken" })
+	waitFor(func() bool { return edge.upstreamToken("bootstrap") != "bootstrap" })
+	session := edge.upstreamToken("")
+	if err := serverauth.Authentication(ctx, "edge", session); err != nil {
+		t.Fatalf("relayed session token is invalid: %v", err)
+	}
+
+	// reconnect to hub with session token
+	hub.kick("edge")
+	waitFor(func() bool { return !hub.routeTable.Exists("edge") && !edge.routeTable.Exists("hub") })
+	connectEdge("")
+	waitFor(func() bool { return hub.routeTable.Exists("edge") })
+}
+
+func TestTunnelServer_DirectPeerHubToken(t *testing.T) {
+	defer func(interval time.Duration) { sessionCheckInterval = interval }(sessionCheckInterval)
+	sessionCheckInterval = 10 * time.Millisecond
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// same as the edge server,peers not found use hubtoken only if they are connected directly
+	getter := func(ctx context.Context, name string) (string, error) {
+		if name == "edge" {
+			return "bootstrap", nil
+		}
+		if IsDirectPeer(ctx) {
+			return "hubtoken", nil
+		}
+		return "", fmt.Errorf("%s not found", name)
+	}
+	serverauth, err := NewTokenAuthManager(&TokenAuthOptions{SigningKeys: []string{"key"}}, getter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := serverauth.Authentication(ctx, "hub", "hubtoken"); err == nil {
+		t.Fatal("hubtoken accepted for peer not connected directly")
+	}
+	if _, _, err := serverauth.IssueSessionToken(ctx, "unknown"); err == nil {
+		t.Fatal("session token issued for unknown peer behind hub")
+	}
+
+	server, hub := NewTunnelServer("server", serverauth), NewTunnelServer("hub", nil)
+	serverside, hubup := newPipeTunnel(16)
+	go server.Connect(ctx, serverside, "", nil, TunnelOptions{})
+	go hub.Connect(ctx, hubup, "hubtoken", nil, TunnelOptions{SendRouteChange: true, IsDefaultOut: true})
+	deadline := time.Now().Add(5 * time.Second)
+	for hub.upstreamToken("hubtoken") == "hubtoken" {
+		if time.Now().After(deadline) {
+			t.Fatal("hub not connected or no session token issued")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestTunnelServer_DropForwardedAuth(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hub, edge, attacker := NewTunnelServer("hub", nil), NewTunnelServer("edge", nil), NewTunnelServer("attacker", nil)
+	connect := func(peer *TunnelServer) {
+		hubdown, peerup := newPipeTunnel(16)
+		go hub.Connect(ctx, hubdown, "", nil, TunnelOptions{})
+		go peer.Connect(ctx, peerup, "", nil, TunnelOptions{SendRouteChange: true, IsDefaultOut: true})
+	}
+	waitFor := func(cond func() bool) {
+		deadline := time.Now().Add(5 * time.Second)
+		for !cond() {
+			if time.Now().After(deadline) {
+				t.Fatal("timeout")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	connect(edge)
+	connect(attacker)
+	waitFor(func() bool { return hub.routeTable.Exists("edge") && hub.routeTable.Exists("attacker") })
+
+	// attacker sends an auth packet to edge through hub,pretending it's from hub
+	tun, err := attacker.routeTable.Select("hub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expireAt := time.Now().Add(time.Hour)
+	if err := tun.Send(&Packet{
+		Kind: PacketKindAuth,
+		Src:  "hub",
+		Dest: "edge",
+		Data: PacketEncode(PacketDataAuth{Session: "forged", ExpireAt: &expireAt}),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if token := edge.upstreamToken("bootstrap"); token != "bootstrap" {
+		t.Errorf("edge accepted forwarded auth packet,session = %s", token)
+	}
+}
@@ -14,6 +14,8 @@
 
 package tunnel
 
+import "context"
+
 type Tunnel interface {
 	Recv(*Packet) error
 	Send(*Packet) error
@@ -30,4 +32,40 @@ type ConnectedTunnel struct {
 	ID              string
 	AnnotationsSent Annotations
 	Options         TunnelOptions
+
+	scheduler *sendScheduler
+	cancel    context.CancelFunc
+}
+
+// Send queue packet to the tunnel,return ErrFullChannel if the connection's queue is full.
+func (t *ConnectedTunnel) Send(pkt *Packet) error {
+	if t.scheduler == nil {
+		return t.Tunnel.Send(pkt)
+	}
+	return t.scheduler.enqueue(pkt, false)
+}
+
+// SendWait queue packet to the tunnel,block until the connection's queue has space.
+func (t *ConnectedTunnel) SendWait(pkt *Packet) error {
+	if t.scheduler == nil {
+		return t.Tunnel.Send(pkt)
+	}
+	return t.scheduler.enqueue(pkt, true)
+}
+
+func (t *ConnectedTunnel) startScheduler(maxqueue int64) {
+	t.scheduler = newSendScheduler(t.Tunnel, maxqueue)
+}
+
+func (t *ConnectedTunnel) stopScheduler(err error) {
+	if t.scheduler != nil {
+		t.scheduler.close(err)
+	}
+}
+
+// kick disconnect the tunnel
+func (t *ConnectedTunnel) kick() {
+	if t.cancel != nil {
+		t.cancel()
+	}
 }
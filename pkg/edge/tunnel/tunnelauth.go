@@ -0,0 +1,192 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tunnel
+
+import (
+	"context"
+	"time"
+
+	"golang.org/x/exp/slices"
+	"kubegems.io/kubegems/pkg/log"
+)
+
+// sessionCheckInterval is the max interval to check peers need session tokens
+var sessionCheckInterval = time.Minute
+
+// renewSession issue session tokens to the downstream peer and peers behind it periodically,
+// only the server holding signing keys issues session tokens,hubs relay them to their peers.
+func (s *TunnelServer) renewSession(ctx context.Context, tun *ConnectedTunnel) {
+	issuer, ok := s.auth.(*TokenAuthManager)
+	if !ok || !issuer.CanIssue() {
+		return
+	}
+	// peers behind the tunnel are checked periodically,new peers receive session tokens in time
+	interval := issuer.SessionTTL() / 2
+	if interval > sessionCheckInterval {
+		interval = sessionCheckInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	issued := map[string]time.Time{} // peer -> expiration of the session token issued
+	for {
+		peers := append([]string{tun.ID}, s.routeTable.ChildrenOf(tun.ID)...)
+		data := PacketDataAuth{}
+		for _, peer := range peers {
+			// renew before half of ttl
+			if time.Until(issued[peer]) > issuer.SessionTTL()/2 {
+				continue
+			}
+			peerctx := ctx
+			if peer == tun.ID {
+				peerctx = WithDirectPeer(ctx)
+			}
+			token, expireAt, err := issuer.IssueSessionToken(peerctx, peer)
+			if err != nil {
+				log.Error(err, "issue session token", "peer", peer)
+				continue
+			}
+			issued[peer] = expireAt
+			if peer == tun.ID {
+				data.Session, data.ExpireAt = token, &expireAt
+				continue
+			}
+			if data.Relay == nil {
+				data.Relay = map[string]PacketDataAuth{}
+			}
+			data.Relay[peer] = PacketDataAuth{Session: token, ExpireAt: &expireAt}
+		}
+		for peer := range issued {
+			if !slices.Contains(peers, peer) {
+				delete(issued, peer)
+			}
+		}
+		if data.Session != "" || len(data.Relay) > 0 {
+			if err := tun.Send(&Packet{
+				Kind: PacketKindAuth,
+				Src:  s.id,
+				Dest: tun.ID,
+				Data: PacketEncode(data),
+			}); err != nil {
+				log.Error(err, "send session token", "peer", tun.ID)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *TunnelServer) onAuth(data PacketDataAuth) {
+	if data.Session != "" && data.ExpireAt != nil {
+		s.setSession(data.Session, *data.ExpireAt)
+	}
+	if len(data.Relay) > 0 {
+		s.relaySessions(data.Relay)
+	}
+	if data.Credentials == nil {
+		return
+	}
+	receiver, ok := s.auth.(*TokenAuthManager)
+	if !ok {
+		return
+	}
+	receiver.SetCredentials(*data.Credentials)
+	// disconnect revoked peers connected to us
+	s.kick(data.Credentials.Revoked...)
+}
+
+// relaySessions send session tokens issued by upstream to downstream peers,
+// peers only accept auth packets from their upstream,so tokens are relayed hop by hop.
+func (s *TunnelServer) relaySessions(sessions map[string]PacketDataAuth) {
+	bytunnel := map[*ConnectedTunnel]PacketDataAuth{}
+	for peer, session := range sessions {
+		tun, err := s.routeTable.Select(peer)
+		if err != nil || tun.Options.IsDefaultOut {
+			// never send back to upstream
+			continue
+		}
+		data := bytunnel[tun]
+		if tun.ID == peer {
+			data.Session, data.ExpireAt = session.Session, session.ExpireAt
+		} else {
+			if data.Relay == nil {
+				data.Relay = map[string]PacketDataAuth{}
+			}
+			data.Relay[peer] = session
+		}
+		bytunnel[tun] = data
+	}
+	for tun, data := range bytunnel {
+		if err := tun.Send(&Packet{
+			Kind: PacketKindAuth,
+			Src:  s.id,
+			Dest: tun.ID,
+			Data: PacketEncode(data),
+		}); err != nil {
+			log.Error(err, "relay session token", "peer", tun.ID)
+		}
+	}
+}
+
+func (s *TunnelServer) setSession(token string, expireAt time.Time) {
+	s.sessionmu.Lock()
+	defer s.sessionmu.Unlock()
+	s.session, s.sessionExpireAt = token, expireAt
+}
+
+// upstreamToken returns the session token issued by upstream if not expired,or the token provided.
+func (s *TunnelServer) upstreamToken(token string) string {
+	s.sessionmu.Lock()
+	defer s.sessionmu.Unlock()
+	// leave some time for connecting
+	if s.session != "" && time.Until(s.sessionExpireAt) > time.Minute {
+		return s.session
+	}
+	return token
+}
+
+// Revoke add peers to revocation list and disconnect them immediately,
+// peers connected to downstream hubs are disconnected after credentials synchronized.
+func (s *TunnelServer) Revoke(names ...string) {
+	if manager, ok := s.auth.(*TokenAuthManager); ok {
+		manager.Revoke(names...)
+	}
+	s.kick(names...)
+}
+
+func (s *TunnelServer) kick(names ...string) {
+	for _, name := range names {
+		if s.routeTable.Kick(name) {
+			log.Info("peer kicked", "peer", name)
+		}
+	}
+}
+
+// SendCredentials send credentials to a downstream hub,replace all credentials on the hub.
+func (s *TunnelServer) SendCredentials(dest string, credentials PacketDataCredentials) error {
+	tun, err := s.routeTable.Select(dest)
+	if err != nil {
+		return err
+	}
+	return tun.Send(&Packet{
+		Kind: PacketKindAuth,
+		Src:  s.id,
+		Dest: dest,
+		Data: PacketEncode(PacketDataAuth{Credentials: &credentials}),
+	})
+}
@@ -18,6 +18,7 @@ import (
 	"errors"
 	"io"
 	"net"
+	"sync"
 	"time"
 
 	"golang.org/x/sync/errgroup"
@@ -37,27 +38,108 @@ type TunnelConn struct {
 	remote             string
 	remoteConnectionID int64
 
-	rdata  []byte
-	closed bool
-	ack    chan *connectData
+	ack chan *connectData // open ack
+
+	flow        FlowControlOptions
+	mu          sync.Mutex
+	cond        *sync.Cond
+	closed      bool
+	established bool     // open ack received
+	rbuf        [][]byte // received but not read
+	rerr        error    // error received from remote
+	buffered    int64    // bytes in rbuf
+	consumed    int64    // bytes read but not sent back by window update
+	remoteFlow  bool     // remote supports flow control
+	sendWindow  int64    // bytes can send to remote,-1 means no limit
+}
+
+func newTunnelConn(c *Connections, tun *ConnectedTunnel, remote string, remotecid int64, localcid int64, flow FlowControlOptions) *TunnelConn {
+	conn := &TunnelConn{
+		c:                  c,
+		channel:            tun,
+		remote:             remote,
+		remoteConnectionID: remotecid,
+		local:              c.local,
+		localConnectionID:  localcid,
+		ack:                make(chan *connectData, 1),
+		flow:               flow,
+		// accepted connection has no ack
+		established: remotecid != 0,
+		sendWindow:  -1,
+	}
+	conn.cond = sync.NewCond(&conn.mu)
+	return conn
 }
 
 func (c *TunnelConn) recv(remotecid int64, data []byte, err string) error {
-	select {
-	case c.ack <- &connectData{remoteID: remotecid, err: err, data: data}:
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return net.ErrClosed
+	}
+	// the first packet is the open ack
+	if !c.established {
+		c.established = true
+		c.ack <- &connectData{remoteID: remotecid, err: err, data: data}
 		return nil
-	default:
+	}
+	if err != "" {
+		if err == "EOF" {
+			c.rerr = io.EOF
+		} else {
+			c.rerr = errors.New(err)
+		}
+		c.cond.Broadcast()
+		return nil
+	}
+	if len(data) == 0 {
+		c.rerr = io.EOF
+		c.cond.Broadcast()
+		return nil
+	}
+	if c.buffered+int64(len(data)) > c.flow.MaxBufferSize {
 		log.Error(ErrFullChannel, "drop packet",
 			"cid", c.localConnectionID,
 			"remote", c.channel.ID,
 			"remote cid", c.remoteConnectionID,
+			"buffered", c.buffered,
 		)
 		return ErrFullChannel
 	}
+	c.rbuf = append(c.rbuf, data)
+	c.buffered += int64(len(data))
+	c.cond.Broadcast()
+	return nil
 }
 
-func (c *TunnelConn) opened(remotecid int64) {
+func (c *TunnelConn) opened(remotecid int64, ackdata []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	c.remoteConnectionID = remotecid
+	// remote advertised it's window in ack
+	if len(ackdata) > 0 {
+		if window := PacketDecode[PacketDataWindow](ackdata); window.Size > 0 {
+			c.remoteFlow, c.sendWindow = true, window.Size
+		}
+	}
+}
+
+// acceptFrom set flow control from opener's open options
+func (c *TunnelConn) acceptFrom(options PacketDataOpen) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if options.Window > 0 {
+		c.remoteFlow, c.sendWindow = true, options.Window
+	}
+}
+
+func (c *TunnelConn) updateWindow(increment int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.sendWindow >= 0 && increment > 0 {
+		c.sendWindow += increment
+		c.cond.Broadcast()
+	}
 }
 
 func (c *TunnelConn) accepted(conn net.Conn) {
@@ -81,43 +163,81 @@ type connectData struct {
 }
 
 func (c *TunnelConn) Read(b []byte) (n int, err error) {
-	var data []byte
-	if c.rdata != nil {
-		data = c.rdata
-	} else {
-		ack := <-c.ack
-		if ack == nil /*closed*/ {
-			return 0, net.ErrClosed
+	c.mu.Lock()
+	for len(c.rbuf) == 0 {
+		if c.rerr != nil {
+			c.mu.Unlock()
+			return 0, c.rerr
 		}
-		if ack.err == "EOF" {
-			return 0, io.EOF
-		}
-		if ack.err != "" {
-			return 0, errors.New(ack.err)
+		if c.closed {
+			c.mu.Unlock()
+			return 0, net.ErrClosed
 		}
-		data = ack.data
+		c.cond.Wait()
+	}
+	data := c.rbuf[0]
+	n = copy(b, data)
+	if n < len(data) {
+		c.rbuf[0] = data[n:]
+	} else {
+		c.rbuf[0] = nil
+		c.rbuf = c.rbuf[1:]
 	}
-	if data == nil {
-		return 0, io.EOF
+	c.buffered -= int64(n)
+	c.consumed += int64(n)
+
+	// send back window after half of the window consumed
+	var increment int64
+	if c.remoteFlow && !c.closed && c.consumed >= c.flow.WindowSize/2 {
+		increment, c.consumed = c.consumed, 0
 	}
-	if len(data) > len(b) {
-		copy(b, data[:len(b)])
-		c.rdata = data[len(b):]
-		return len(b), nil
+	c.mu.Unlock()
+
+	if increment > 0 {
+		if err := c.sendWindowUpdate(increment); err != nil {
+			return n, err
+		}
 	}
-	c.rdata = nil
-	copy(b, data)
-	return len(data), nil
+	return n, nil
 }
 
 func (c *TunnelConn) Write(b []byte) (n int, err error) {
+	for len(b) > 0 {
+		size, err := c.acquire(len(b))
+		if err != nil {
+			return n, err
+		}
+		// data is sent asynchronously,copy it as b may be reused by caller
+		if err := c.sendData(append([]byte(nil), b[:size]...)); err != nil {
+			return n, err
+		}
+		n += size
+		b = b[size:]
+	}
+	return n, nil
+}
+
+// acquire wait until remote window available,returns bytes can send
+func (c *TunnelConn) acquire(size int) (int, error) {
+	if size > c.flow.MaxPacketSize {
+		size = c.flow.MaxPacketSize
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for c.sendWindow == 0 && !c.closed {
+		c.cond.Wait()
+	}
 	if c.closed {
 		return 0, net.ErrClosed
 	}
-	if err = c.sendData(b); err != nil {
-		return 0, err
+	if c.sendWindow < 0 {
+		return size, nil
+	}
+	if int64(size) > c.sendWindow {
+		size = int(c.sendWindow)
 	}
-	return len(b), err
+	c.sendWindow -= int64(size)
+	return size, nil
 }
 
 // Close tunnel connection and close raw connection,remove self from connection manager
@@ -126,7 +246,19 @@ func (c *TunnelConn) Close() error {
 }
 
 func (c *TunnelConn) close() error {
-	return c.c.close(c.remoteConnectionID)
+	return c.c.close(c.localConnectionID)
+}
+
+// markClosed wake up all blocked reads and writes
+func (c *TunnelConn) markClosed() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return
+	}
+	c.closed = true
+	close(c.ack)
+	c.cond.Broadcast()
 }
 
 func (c *TunnelConn) LocalAddr() net.Addr {
@@ -156,6 +288,21 @@ func (c *TunnelConn) sendData(data []byte) error {
 	})
 }
 
+// sendAck ack the open request with our receive window
+func (c *TunnelConn) sendAck() error {
+	return c.sendPkt(func(pkt *Packet) {
+		pkt.Kind = PacketKindData
+		pkt.Data = PacketEncode(PacketDataWindow{Size: c.flow.WindowSize})
+	})
+}
+
+func (c *TunnelConn) sendWindowUpdate(increment int64) error {
+	return c.sendPkt(func(pkt *Packet) {
+		pkt.Kind = PacketKindWindow
+		pkt.Data = PacketEncode(PacketDataWindow{Size: increment})
+	})
+}
+
 func (c *TunnelConn) sendClose(err error) error {
 	return c.sendPkt(func(pkt *Packet) {
 		pkt.Kind = PacketKindClose
@@ -181,5 +328,5 @@ func (c *TunnelConn) sendPkt(fun func(pkt *Packet)) error {
 		DestCID: c.remoteConnectionID,
 	}
 	fun(pkt)
-	return c.channel.Send(pkt)
+	return c.channel.SendWait(pkt)
 }
@@ -27,38 +27,25 @@ import (
 )
 
 const (
-	DefaultDataChannelSize = 512
-	MaxOpenConnectTimeout  = 30 * time.Second
+	MaxOpenConnectTimeout = 30 * time.Second
 )
 
 type Connections struct {
 	local       string
+	flow        FlowControlOptions
 	autoinc     int64
 	mu          sync.RWMutex
 	connections map[int64]*TunnelConn // localcid -> tunnel
 }
 
 func (c *Connections) pending(tun *ConnectedTunnel, remote string, remotecid int64) *TunnelConn {
-	tunconn := &TunnelConn{
-		c:                  c,
-		channel:            tun,
-		remote:             remote,
-		remoteConnectionID: remotecid,
-		local:              c.local,
-		localConnectionID:  atomic.AddInt64(&c.autoinc, 1),
-		ack:                make(chan *connectData, DefaultDataChannelSize),
-		closed:             false,
-	}
+	tunconn := newTunnelConn(c, tun, remote, remotecid, atomic.AddInt64(&c.autoinc, 1), c.flow)
 	c.mu.Lock()
 	c.connections[tunconn.localConnectionID] = tunconn
 	c.mu.Unlock()
 	return tunconn
 }
 
-func (c *Connections) opened(tun *TunnelConn, remotecid int64) {
-	tun.remoteConnectionID = remotecid
-}
-
 func (c *Connections) get(localcid int64) *TunnelConn {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
@@ -82,8 +69,7 @@ func (c *Connections) closeWihoutLock(localcid int64) (err error) {
 			"remote", conn.remote,
 			"remote cid", conn.remoteConnectionID,
 		)
-		close(conn.ack)
-		conn.closed = true
+		conn.markClosed()
 		if conn.rawConn != nil {
 			// https://man7.org/linux/man-pages/man2/close.2.html
 			// close() will fail when a routine on block write()
@@ -104,6 +90,7 @@ func (c *Connections) flush() {
 
 type ConnectionManager struct {
 	s       *TunnelServer
+	mu      sync.Mutex
 	tunnels map[string]*Connections
 }
 
@@ -115,11 +102,14 @@ func NewConectionManager(s *TunnelServer) *ConnectionManager {
 }
 
 func (cm *ConnectionManager) tunnel(tun *ConnectedTunnel) *Connections {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
 	val, ok := cm.tunnels[tun.ID]
 	if !ok {
 		val = &Connections{
 			connections: make(map[int64]*TunnelConn),
 			local:       cm.s.id,
+			flow:        cm.s.flow,
 		}
 		cm.tunnels[tun.ID] = val
 	}
@@ -142,7 +132,8 @@ func (cm *ConnectionManager) Open(network, address string, timeout time.Duration
 		"peer", dest, "cid", tunconn.localConnectionID,
 		"network", network, "address", address,
 	)
-	if err := tunconn.sendOpen(PacketDataOpen{Network: network, Address: address, Timeout: timeout}); err != nil {
+	openOptions := PacketDataOpen{Network: network, Address: address, Timeout: timeout, Window: tunconn.flow.WindowSize}
+	if err := tunconn.sendOpen(openOptions); err != nil {
 		return nil, err
 	}
 	// wait open ack
@@ -161,7 +152,7 @@ func (cm *ConnectionManager) Open(network, address string, timeout time.Duration
 			return nil, errors.New("empty remote connection id")
 		}
 		// established
-		tunconn.opened(ack.remoteID)
+		tunconn.opened(ack.remoteID, ack.data)
 		log.Info("connection opend",
 			"network", network, "address", address,
 			"cid", tunconn.localConnectionID,
@@ -181,6 +172,7 @@ func (cm *ConnectionManager) Open(network, address string, timeout time.Duration
 func (cm *ConnectionManager) accept(fromtunnel *ConnectedTunnel, remote string, remotecid int64, dialOptions PacketDataOpen) {
 	tunConn := cm.tunnel(fromtunnel).pending(fromtunnel, remote, remotecid)
 	defer tunConn.Close()
+	tunConn.acceptFrom(dialOptions)
 
 	log := log.LogrLogger.WithValues(
 		"local cid", tunConn.localConnectionID,
@@ -196,7 +188,7 @@ func (cm *ConnectionManager) accept(fromtunnel *ConnectedTunnel, remote string,
 	}
 	defer conn.Close()
 
-	if err := tunConn.sendData([]byte{}); err != nil {
+	if err := tunConn.sendAck(); err != nil {
 		log.Error(err, "connection send ack")
 		return
 	}
@@ -210,12 +202,20 @@ func (cm *ConnectionManager) accept(fromtunnel *ConnectedTunnel, remote string,
 func (cm *ConnectionManager) recv(fromtunnel *ConnectedTunnel, from string, fromCID int64, localcid int64, data []byte, err string) error {
 	log.Info("packet recv", "cid", localcid, "remote", from, "remote cid", fromCID)
 	conn := cm.tunnel(fromtunnel).get(localcid)
-	if conn == nil || conn.closed {
+	if conn == nil {
 		return net.ErrClosed
 	}
 	return conn.recv(fromCID, data, err)
 }
 
+func (cm *ConnectionManager) window(fromtunnel *ConnectedTunnel, localcid int64, increment int64) {
+	conn := cm.tunnel(fromtunnel).get(localcid)
+	if conn == nil {
+		return
+	}
+	conn.updateWindow(increment)
+}
+
 func (cm *ConnectionManager) close(fromtunnel *ConnectedTunnel, remote string, remotecid int64, localcid int64) (err error) {
 	return cm.tunnel(fromtunnel).close(localcid)
 }
@@ -261,6 +261,29 @@ func (t *RouteTable) advertise(changesFrom string, changes PacketDataRoute) {
 	}
 }
 
+// Kick disconnect the tunnel direct connected,returns false if not found
+func (t *RouteTable) Kick(id string) bool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	val, ok := t.records[id]
+	if !ok {
+		return false
+	}
+	val.Channel.kick()
+	return true
+}
+
+// ChildrenOf returns peers connected through the tunnel direct connected
+func (t *RouteTable) ChildrenOf(id string) []string {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	val, ok := t.records[id]
+	if !ok {
+		return nil
+	}
+	return maps.Keys(val.Children)
+}
+
 func (t *RouteTable) Exists(id string) bool {
 	t.mu.RLock()
 	defer t.mu.RUnlock()
@@ -36,6 +36,11 @@ type TunnelServer struct {
 	routeTable         *RouteTable
 	eventer            *TunnelEventer
 	statefultransports sync.Map
+	flow               FlowControlOptions
+
+	sessionmu       sync.Mutex
+	session         string    // session token issued by upstream
+	sessionExpireAt time.Time // expiration of session token
 }
 
 func NewTunnelServer(id string, auth AuthenticationManager) *TunnelServer {
@@ -45,6 +50,7 @@ func NewTunnelServer(id string, auth AuthenticationManager) *TunnelServer {
 	s := &TunnelServer{
 		id:   id,
 		auth: auth,
+		flow: NewDefaultFlowControlOptions().complete(),
 	}
 	s.routeTable = NewEmptyRouteTable(s)
 	s.connections = NewConectionManager(s)
@@ -53,11 +59,14 @@ func NewTunnelServer(id string, auth AuthenticationManager) *TunnelServer {
 }
 
 func (s *TunnelServer) Connect(ctx context.Context, channel Tunnel, token string, annotations Annotations, options TunnelOptions) error {
-	connectedChannel, err := s.authStage(ctx, channel, token)
+	connectedChannel, err := s.authStage(ctx, channel, token, options)
 	if err != nil {
 		return err
 	}
 	connectedChannel.Options = options
+	// all packets send to the channel are scheduled from now on
+	connectedChannel.startScheduler(s.flow.MaxBufferSize)
+	defer connectedChannel.stopScheduler(nil)
 	// check exists tunnel
 	if err := s.existsCheckStage(ctx, connectedChannel); err != nil {
 		return err
@@ -66,23 +75,52 @@ func (s *TunnelServer) Connect(ctx context.Context, channel Tunnel, token string
 	if err != nil {
 		return err
 	}
+	// tunnel can be kicked by cancel the context
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	connectedChannel.cancel = cancel
+
 	// connected
 	s.routeTable.Connect(connectedChannel, *routedata)
 	defer s.routeTable.Disconnect(connectedChannel)
 
-	for {
-		pkt := new(Packet)
-		if err := connectedChannel.Recv(pkt); err != nil {
-			return err
+	if !options.IsDefaultOut {
+		go s.renewSession(ctx, connectedChannel)
+	}
+
+	errch := make(chan error, 1)
+	go func() {
+		for {
+			pkt := new(Packet)
+			if err := connectedChannel.Recv(pkt); err != nil {
+				errch <- err
+				return
+			}
+			s.preRouting(connectedChannel, pkt)
 		}
-		s.preRouting(connectedChannel, pkt)
+	}()
+	select {
+	case err := <-errch:
+		return err
+	case <-ctx.Done():
+		_ = connectedChannel.Close()
+		return ctx.Err()
 	}
 }
 
-func (s *TunnelServer) authStage(ctx context.Context, channel Tunnel, token string) (*ConnectedTunnel, error) {
+// SetFlowControl set flow control options of connections,must be called before any tunnel connected.
+func (s *TunnelServer) SetFlowControl(options *FlowControlOptions) {
+	if options == nil {
+		return
+	}
+	s.flow = options.complete()
+}
+
+func (s *TunnelServer) authStage(ctx context.Context, channel Tunnel, token string, options TunnelOptions) (*ConnectedTunnel, error) {
 	// send meta and auth
 	connectData := PacketDataConnect{Token: token}
-	log.Info("connect send", "data", connectData)
+	// never log the token
+	log.Info("connect send", "local", s.id)
 	if err := channel.Send(&Packet{
 		Kind: PacketKindConnect,
 		Src:  s.id,
@@ -101,18 +139,20 @@ func (s *TunnelServer) authStage(ctx context.Context, channel Tunnel, token stri
 
 	remoteid := connectpkt.Src
 	connectData = PacketDecode[PacketDataConnect](connectpkt.Data)
-	log.Info("connect recv", "remote", remoteid, "data", connectData)
+	log.Info("connect recv", "remote", remoteid)
 	// check not empty remote id
 	if remoteid == "" {
 		err := errors.New("empty tunnel id")
 		_ = channel.Send(&Packet{Kind: PacketKindClose, Error: err.Error()})
 		return nil, err
 	}
-	// check remote auth
-	if err := s.auth.Authentication(ctx, remoteid, connectData.Token); err != nil {
-		_ = channel.Send(&Packet{Kind: PacketKindClose, Error: err.Error()})
-		log.Error(err, "auth faild", "remote", remoteid, "token", connectData.Token)
-		return nil, err
+	// check remote auth,upstream is not authenticated by us
+	if !options.IsDefaultOut {
+		if err := s.auth.Authentication(WithDirectPeer(ctx), remoteid, connectData.Token); err != nil {
+			_ = channel.Send(&Packet{Kind: PacketKindClose, Error: err.Error()})
+			log.Error(err, "auth faild", "remote", remoteid)
+			return nil, err
+		}
 	}
 	// send ack
 	if err := channel.Send(&Packet{Kind: PacketKindData, Src: s.id, Dest: remoteid}); err != nil {
@@ -124,6 +164,8 @@ func (s *TunnelServer) authStage(ctx context.Context, channel Tunnel, token stri
 		return nil, err
 	}
 	if ackpkt.Kind == PacketKindClose || ackpkt.Error != "" {
+		// the session token may be rejected,use the original token next time
+		s.setSession("", time.Time{})
 		return nil, fmt.Errorf("remote channel closed: %s", ackpkt.Error)
 	}
 	log.Info("auth success", "remote", remoteid)
@@ -155,6 +197,12 @@ func (s *TunnelServer) preRouting(income *ConnectedTunnel, pkt *Packet) {
 }
 
 func (s *TunnelServer) forward(income *ConnectedTunnel, pkt *Packet) error {
+	// auth packets are sent hop by hop between peers connected directly,
+	// a forwarded one may carry a forged src,drop it.
+	if pkt.Kind == PacketKindAuth {
+		log.Info("drop forwarded auth packet", "src", pkt.Src, "dest", pkt.Dest, "tunnel", income.ID)
+		return nil
+	}
 	log.Info("packet forward", "src", pkt.Src, "dest", pkt.Dest)
 	targetPeer, err := s.routeTable.Select(pkt.Dest)
 	if err != nil {
@@ -204,6 +252,15 @@ func (s *TunnelServer) localIn(channel *ConnectedTunnel, pkt *Packet) {
 		go s.connections.close(channel, pkt.Src, pkt.SrcCID, pkt.DestCID)
 	case PacketKindRoute:
 		go s.routeTable.OnChange(channel, PacketDecode[PacketDataRoute](pkt.Data))
+	case PacketKindWindow:
+		s.connections.window(channel, pkt.DestCID, PacketDecode[PacketDataWindow](pkt.Data).Size)
+	case PacketKindAuth:
+		// only accept from upstream itself
+		if !channel.Options.IsDefaultOut || pkt.Src != channel.ID {
+			log.Info("ignore auth packet", "src", pkt.Src, "tunnel", channel.ID)
+			return
+		}
+		go s.onAuth(PacketDecode[PacketDataAuth](pkt.Data))
 	}
 }
 
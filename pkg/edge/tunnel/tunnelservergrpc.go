@@ -36,19 +36,21 @@ const (
 )
 
 type Options struct {
-	PeerID          string `json:"peerID,omitempty"`
-	Listen          string `json:"listen,omitempty"`
-	UpstreamAddr    string `json:"upstreamAddr,omitempty"`
-	EnableClientTLS bool   `json:"enableClientTLS,omitempty"`
-	Token           string `json:"token,omitempty"`
-	TLS             *TLS   `json:"tls,omitempty"`
+	PeerID          string              `json:"peerID,omitempty"`
+	Listen          string              `json:"listen,omitempty"`
+	UpstreamAddr    string              `json:"upstreamAddr,omitempty"`
+	EnableClientTLS bool                `json:"enableClientTLS,omitempty"`
+	Token           string              `json:"token,omitempty"`
+	TLS             *TLS                `json:"tls,omitempty"`
+	FlowControl     *FlowControlOptions `json:"flowControl,omitempty"`
 }
 
 func NewDefaultOptions() *Options {
 	return &Options{
-		Listen: "",
-		PeerID: uuid.NewString(),
-		TLS:    NewDefaultTLS(),
+		Listen:      "",
+		PeerID:      uuid.NewString(),
+		TLS:         NewDefaultTLS(),
+		FlowControl: NewDefaultFlowControlOptions(),
 	}
 }
 
@@ -103,6 +105,7 @@ func Run(ctx context.Context, options *Options) error {
 	server := GrpcTunnelServer{
 		TunnelServer: NewTunnelServer(options.PeerID, nil),
 	}
+	server.TunnelServer.SetFlowControl(options.FlowControl)
 	eg := errgroup.Group{}
 	if listen := options.Listen; listen != "" {
 		eg.Go(func() error {
@@ -183,7 +186,8 @@ func (s GrpcTunnelServer) ConnectUpstream(ctx context.Context, addr string, tlsC
 		return err
 	}
 	peer := &GRPCTunnel[proto.PeerService_ConnectClient]{inner: stream}
-	return s.TunnelServer.Connect(ctx, peer, token, annotations, TunnelOptions{
+	// prefer session token issued by upstream
+	return s.TunnelServer.Connect(ctx, peer, s.TunnelServer.upstreamToken(token), annotations, TunnelOptions{
 		SendRouteChange: true,
 		IsDefaultOut:    true, // as default out if no route info
 	})
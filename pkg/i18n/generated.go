@@ -19,14 +19,22 @@ func initEnUS(tag language.Tag) {
 	_ = message.SetString(tag, "Template and native promql cannot be empty at the same time", "Template and native promql cannot be empty at the same time")
 	_ = message.SetString(tag, "URL parameter mismatched with body", "URL parameter mismatched with body")
 	_ = message.SetString(tag, "account", "account")
+	_ = message.SetString(tag, "acknowledge", "acknowledge")
+	_ = message.SetString(tag, "acknowledged alert incident %s", "acknowledged alert incident %s")
 	_ = message.SetString(tag, "add", "add")
 	_ = message.SetString(tag, "add a new cluster %s into kubegems", "add a new cluster %s into kubegems")
 	_ = message.SetString(tag, "add user %s to environment %s member as role %s", "add user %s to environment %s member as role %s")
 	_ = message.SetString(tag, "add user %s to project %s members as role %s", "add user %s to project %s members as role %s")
 	_ = message.SetString(tag, "add user %s to tenant %s members as role %s", "add user %s to tenant %s members as role %s")
+	_ = message.SetString(tag, "added note to alert incident %s", "added note to alert incident %s")
+	_ = message.SetString(tag, "alert incident", "alert incident")
+	_ = message.SetString(tag, "alert incident %s opened", "alert incident %s opened")
+	_ = message.SetString(tag, "alert incident %s resolved", "alert incident %s resolved")
 	_ = message.SetString(tag, "alert rule", "alert rule")
 	_ = message.SetString(tag, "app %s has been collected by flow %s", "app %s has been collected by flow %s")
 	_ = message.SetString(tag, "app label %s is not valid, must be one of %v", "app label %s is not valid, must be one of %v")
+	_ = message.SetString(tag, "assign", "assign")
+	_ = message.SetString(tag, "assigned alert incident %s", "assigned alert incident %s")
 	_ = message.SetString(tag, "auth source not exist", "auth source not exist")
 	_ = message.SetString(tag, "auth source not exists or not enabled", "auth source not exists or not enabled")
 	_ = message.SetString(tag, "batch delete", "batch delete")
@@ -142,8 +150,11 @@ func initEnUS(tag language.Tag) {
 	_ = message.SetString(tag, "recover", "recover")
 	_ = message.SetString(tag, "rejected", "rejected")
 	_ = message.SetString(tag, "repo %s started syncing on background", "repo %s started syncing on background")
+	_ = message.SetString(tag, "resolve", "resolve")
+	_ = message.SetString(tag, "resolved alert incident %s", "resolved alert incident %s")
 	_ = message.SetString(tag, "rule %s already exist", "rule %s already exist")
 	_ = message.SetString(tag, "scrap target %s not found", "scrap target %s not found")
+	_ = message.SetString(tag, "service level objective", "service level objective")
 	_ = message.SetString(tag, "set", "set")
 	_ = message.SetString(tag, "set user %s to environment %s member as role %s", "set user %s to environment %s member as role %s")
 	_ = message.SetString(tag, "set user %s to tenant %s members as role %s", "set user %s to tenant %s members as role %s")
@@ -155,6 +166,8 @@ func initEnUS(tag language.Tag) {
 	_ = message.SetString(tag, "sync", "sync")
 	_ = message.SetString(tag, "system error", "system error")
 	_ = message.SetString(tag, "system role", "system role")
+	_ = message.SetString(tag, "task %s of application %s cancelled", "Task %s of application %s cancelled")
+	_ = message.SetString(tag, "task %s of application %s paused", "Task %s of application %s paused")
 	_ = message.SetString(tag, "tenant", "tenant")
 	_ = message.SetString(tag, "tenant %s / cluster %s", "tenant %s / cluster %s")
 	_ = message.SetString(tag, "tenant %s / user %s", "tenant %s / user %s")
@@ -220,15 +233,23 @@ func initJaJP(tag language.Tag) {
 	_ = message.SetString(tag, "Template and native promql cannot be empty at the same time", "テンプレートとネイティブproqlを同時に空にすることはできません")
 	_ = message.SetString(tag, "URL parameter mismatched with body", "URLパラメータがbodyと一致しません")
 	_ = message.SetString(tag, "account", "メンバーアカウント")
+	_ = message.SetString(tag, "acknowledge", "確認")
+	_ = message.SetString(tag, "acknowledged alert incident %s", "アラートインシデント %s を確認しました")
 	_ = message.SetString(tag, "add", "追加")
 	_ = message.SetString(tag, "add a new cluster %s into kubegems", "新しいクラスタ %s をkubegemsに追加する")
 	_ = message.SetString(tag, "add user %s to environment %s member as role %s", "ユーザー %s をロール %sとして環境 %s メンバーに追加する")
 	_ = message.SetString(tag, "add user %s to project %s members as role %s", "ロール %sとしてプロジェクト %s メンバーにユーザー %s を追加")
 	_ = message.SetString(tag, "add user %s to tenant %s members as role %s", "ユーザー %s をロール %sとしてテナント %s メンバーに追加")
+	_ = message.SetString(tag, "added note to alert incident %s", "アラートインシデント %s にメモを追加しました")
+	_ = message.SetString(tag, "alert incident", "アラートインシデント")
+	_ = message.SetString(tag, "alert incident %s opened", "アラートインシデント %s が発生しました")
+	_ = message.SetString(tag, "alert incident %s resolved", "アラートインシデント %s は解決されました")
 	_ = message.SetString(tag, "alert receiver", "アラート受信機")
 	_ = message.SetString(tag, "alert rule %s not found", "アラートルール %s が見つかりません")
 	_ = message.SetString(tag, "app %s has been collected by flow %s", "アプリ %s がフロー %sによって収集されました")
 	_ = message.SetString(tag, "app label %s is not valid, must be one of %v", "アプリのラベル %s が無効です。 %vのいずれかでなければなりません")
+	_ = message.SetString(tag, "assign", "割り当て")
+	_ = message.SetString(tag, "assigned alert incident %s", "アラートインシデント %s を割り当てました")
 	_ = message.SetString(tag, "auth source not exist", "認証ソースが存在しません")
 	_ = message.SetString(tag, "auth source not exists or not enabled", "認証ソースが存在しないか、有効になっていません")
 	_ = message.SetString(tag, "batch delete", "一括削除")
@@ -343,8 +364,11 @@ func initJaJP(tag language.Tag) {
 	_ = message.SetString(tag, "recover", "回復")
 	_ = message.SetString(tag, "rejected", "拒絶されました")
 	_ = message.SetString(tag, "repo %s started syncing on background", "リポジトリ %s がバックグラウンドで同期を開始しました")
+	_ = message.SetString(tag, "resolve", "解決")
+	_ = message.SetString(tag, "resolved alert incident %s", "アラートインシデント %s を解決しました")
 	_ = message.SetString(tag, "rule %s already exist", "ルール %s は既に存在します")
 	_ = message.SetString(tag, "scrap target %s not found", "スクラップターゲット %s が見つかりません")
+	_ = message.SetString(tag, "service level objective", "サービスレベル目標")
 	_ = message.SetString(tag, "set", "設定されている")
 	_ = message.SetString(tag, "set user %s to environment %s member as role %s", "ユーザー %s をロール %sとして環境 %s メンバーに設定する")
 	_ = message.SetString(tag, "set user %s to tenant %s members as role %s", "ユーザー %s をテナント %s メンバーにロール %sとして設定します。")
@@ -356,6 +380,8 @@ func initJaJP(tag language.Tag) {
 	_ = message.SetString(tag, "sync", "同期する")
 	_ = message.SetString(tag, "system error", "システム不具合")
 	_ = message.SetString(tag, "system role", "システム権限")
+	_ = message.SetString(tag, "task %s of application %s cancelled", "アプリケーション %[2]s のタスク %[1]s はキャンセルされました")
+	_ = message.SetString(tag, "task %s of application %s paused", "アプリケーション %[2]s のタスク %[1]s は一時停止されました")
 	_ = message.SetString(tag, "tenant", "テナント")
 	_ = message.SetString(tag, "tenant %s / cluster %s", "テナント %s /クラスター %s")
 	_ = message.SetString(tag, "tenant %s / user %s", "テナント %s /ユーザー %s")
@@ -422,15 +448,23 @@ func initZhCN(tag language.Tag) {
 	_ = message.SetString(tag, "Template and native promql cannot be empty at the same time", "模板和原生promql 不能同时为空")
 	_ = message.SetString(tag, "URL parameter mismatched with body", "URL参数与正文不匹配")
 	_ = message.SetString(tag, "account", "帐户")
+	_ = message.SetString(tag, "acknowledge", "确认")
+	_ = message.SetString(tag, "acknowledged alert incident %s", "确认了告警事件 %s")
 	_ = message.SetString(tag, "add", "添加")
 	_ = message.SetString(tag, "add a new cluster %s into kubegems", "将 %s 新群集添加到 kubegems")
 	_ = message.SetString(tag, "add user %s to environment %s member as role %s", "将用户 %s 添加到环境 %s 成员角色 %s")
 	_ = message.SetString(tag, "add user %s to project %s members as role %s", "将用户 %s 添加到项目 %s 成员作为角色 %s")
 	_ = message.SetString(tag, "add user %s to tenant %s members as role %s", "将用户 %s 添加到租户 %s 成员作为角色 %s")
+	_ = message.SetString(tag, "added note to alert incident %s", "为告警事件 %s 添加了备注")
+	_ = message.SetString(tag, "alert incident", "告警事件")
+	_ = message.SetString(tag, "alert incident %s opened", "告警事件 %s 已触发")
+	_ = message.SetString(tag, "alert incident %s resolved", "告警事件 %s 已恢复")
 	_ = message.SetString(tag, "alert receiver", "警报接收器")
 	_ = message.SetString(tag, "alert rule %s not found", "未找到警报规则 %s")
 	_ = message.SetString(tag, "app %s has been collected by flow %s", "应用程序 %s 已经由 flow %s 收集。")
 	_ = message.SetString(tag, "app label %s is not valid, must be one of %v", "应用标签 %s 无效，必须是 %v 之一")
+	_ = message.SetString(tag, "assign", "指派")
+	_ = message.SetString(tag, "assigned alert incident %s", "指派了告警事件 %s")
 	_ = message.SetString(tag, "auth source not exist", "身份验证源不存在")
 	_ = message.SetString(tag, "auth source not exists or not enabled", "身份验证源不存在或未启用")
 	_ = message.SetString(tag, "batch delete", "批量删除")
@@ -545,8 +579,11 @@ func initZhCN(tag language.Tag) {
 	_ = message.SetString(tag, "recover", "恢复")
 	_ = message.SetString(tag, "rejected", "已拒绝")
 	_ = message.SetString(tag, "repo %s started syncing on background", "repo %s 在后台开始同步")
+	_ = message.SetString(tag, "resolve", "解决")
+	_ = message.SetString(tag, "resolved alert incident %s", "解决了告警事件 %s")
 	_ = message.SetString(tag, "rule %s already exist", "规则 %s 已存在")
 	_ = message.SetString(tag, "scrap target %s not found", "找不到抓取目标 %s")
+	_ = message.SetString(tag, "service level objective", "服务等级目标")
 	_ = message.SetString(tag, "set", "设置")
 	_ = message.SetString(tag, "set user %s to environment %s member as role %s", "设置用户 %s 为环境 %s 成员为角色 %s")
 	_ = message.SetString(tag, "set user %s to tenant %s members as role %s", "设置用户 %s 为租户成员 %s 为角色 %s")
@@ -558,6 +595,8 @@ func initZhCN(tag language.Tag) {
 	_ = message.SetString(tag, "sync", "同步")
 	_ = message.SetString(tag, "system error", "系统错误")
 	_ = message.SetString(tag, "system role", "系统角色")
+	_ = message.SetString(tag, "task %s of application %s cancelled", "应用 %[2]s 的任务 %[1]s 已取消")
+	_ = message.SetString(tag, "task %s of application %s paused", "应用 %[2]s 的任务 %[1]s 已暂停")
 	_ = message.SetString(tag, "tenant", "租户")
 	_ = message.SetString(tag, "tenant %s / cluster %s", "租户 %s / 组 %s")
 	_ = message.SetString(tag, "tenant %s / user %s", "租户 %s / 用户 %s")
@@ -624,15 +663,23 @@ func initZhTW(tag language.Tag) {
 	_ = message.SetString(tag, "Template and native promql cannot be empty at the same time", "範本機 promql 不能同時為空")
 	_ = message.SetString(tag, "URL parameter mismatched with body", "網址參數與正文不匹配")
 	_ = message.SetString(tag, "account", "帳戶")
+	_ = message.SetString(tag, "acknowledge", "確認")
+	_ = message.SetString(tag, "acknowledged alert incident %s", "確認了告警事件 %s")
 	_ = message.SetString(tag, "add", "加")
 	_ = message.SetString(tag, "add a new cluster %s into kubegems", "將新的集群 %s 添加到 kubegems 中")
 	_ = message.SetString(tag, "add user %s to environment %s member as role %s", "將使用者 %s 作為角色 %s添加到環境 %s 成員")
 	_ = message.SetString(tag, "add user %s to project %s members as role %s", "將使用者 %s 作為角色 %s添加到專案 %s 成員")
 	_ = message.SetString(tag, "add user %s to tenant %s members as role %s", "將使用者 %s 作為角色 %s添加到租戶 %s 成員")
+	_ = message.SetString(tag, "added note to alert incident %s", "為告警事件 %s 添加了備註")
+	_ = message.SetString(tag, "alert incident", "告警事件")
+	_ = message.SetString(tag, "alert incident %s opened", "告警事件 %s 已觸發")
+	_ = message.SetString(tag, "alert incident %s resolved", "告警事件 %s 已恢復")
 	_ = message.SetString(tag, "alert receiver", "警報接收器")
 	_ = message.SetString(tag, "alert rule %s not found", "找不到警報規則 %s")
 	_ = message.SetString(tag, "app %s has been collected by flow %s", "應用 %s 已由流 %s收集")
 	_ = message.SetString(tag, "app label %s is not valid, must be one of %v", "應用標籤 %s 無效，必須是 %v之一")
+	_ = message.SetString(tag, "assign", "指派")
+	_ = message.SetString(tag, "assigned alert incident %s", "指派了告警事件 %s")
 	_ = message.SetString(tag, "auth source not exist", "身份驗證源不存在")
 	_ = message.SetString(tag, "auth source not exists or not enabled", "身份驗證源不存在或未啟用")
 	_ = message.SetString(tag, "batch delete", "批量刪除")
@@ -747,8 +794,11 @@ func initZhTW(tag language.Tag) {
 	_ = message.SetString(tag, "recover", "恢復")
 	_ = message.SetString(tag, "rejected", "拒絕")
 	_ = message.SetString(tag, "repo %s started syncing on background", "存儲庫 %s 開始在後台同步")
+	_ = message.SetString(tag, "resolve", "解決")
+	_ = message.SetString(tag, "resolved alert incident %s", "解決了告警事件 %s")
 	_ = message.SetString(tag, "rule %s already exist", "規則 %s 已存在")
 	_ = message.SetString(tag, "scrap target %s not found", "找不到報廢目標 %s")
+	_ = message.SetString(tag, "service level objective", "服務等級目標")
 	_ = message.SetString(tag, "set", "設置")
 	_ = message.SetString(tag, "set user %s to environment %s member as role %s", "將使用者 %s 設置為角色 %s%s 成員的環境")
 	_ = message.SetString(tag, "set user %s to tenant %s members as role %s", "將租戶 %s 成員的使用者 %s 設置為角色 %s")
@@ -760,6 +810,8 @@ func initZhTW(tag language.Tag) {
 	_ = message.SetString(tag, "sync", "同步")
 	_ = message.SetString(tag, "system error", "系統錯誤")
 	_ = message.SetString(tag, "system role", "系統角色")
+	_ = message.SetString(tag, "task %s of application %s cancelled", "應用 %[2]s 的任務 %[1]s 已取消")
+	_ = message.SetString(tag, "task %s of application %s paused", "應用 %[2]s 的任務 %[1]s 已暫停")
 	_ = message.SetString(tag, "tenant", "房客")
 	_ = message.SetString(tag, "tenant %s / cluster %s", "租戶 %s /群集 %s")
 	_ = message.SetString(tag, "tenant %s / user %s", "租戶 %s /使用者 %s")
@@ -75,6 +75,12 @@ func (o *PluginsAPI) RegisterRoute(rg *route.Group) {
 			route.GET("/{name}").To(o.GetPlugin),
 			route.PUT("/{name}").To(o.EnablePlugin),
 			route.DELETE("/{name}").To(o.RemovePlugin),
+			route.GET("/{name}/plan").To(o.PendingPlan),
+			route.POST("/{name}/plan").To(o.PlanPlugin),
+			route.POST("/{name}/approve").To(o.ApprovePlan),
+			route.GET("/{name}/revisions").To(o.ListRevisions),
+			route.GET("/{name}/revisions/{revision}").To(o.GetRevision),
+			route.POST("/{name}/rollback").To(o.RollbackPlugin),
 		),
 		route.NewGroup("/repos").AddRoutes(
 			route.POST("").To(o.RepoAdd),
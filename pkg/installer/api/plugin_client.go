@@ -21,6 +21,8 @@ import (
 	"strconv"
 
 	"kubegems.io/kubegems/pkg/apis/plugins/v1beta1"
+	"kubegems.io/kubegems/pkg/installer/bundle"
+	"kubegems.io/kubegems/pkg/installer/controller"
 	"kubegems.io/kubegems/pkg/installer/pluginmanager"
 	"kubegems.io/kubegems/pkg/utils/httputil/clientutil"
 	"kubegems.io/kubegems/pkg/utils/httputil/response"
@@ -99,3 +101,58 @@ func (c *PluginsClient) Install(ctx context.Context, name string, version string
 func (c *PluginsClient) UnInstall(ctx context.Context, name string) error {
 	return c.BaseClient.Request(ctx, http.MethodDelete, "/v1/plugins/"+name, nil, nil, nil)
 }
+
+func (c *PluginsClient) Plan(ctx context.Context, name string, version string, values map[string]any) (*bundle.Plan, error) {
+	queries := map[string]string{"version": version}
+	body := pluginmanager.PluginVersion{
+		Values: v1beta1.Values{Object: values},
+	}
+	ret := &bundle.Plan{}
+	if err := c.BaseClient.Request(ctx, http.MethodPost, "/v1/plugins/"+name+"/plan", queries, body, ret); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+func (c *PluginsClient) PendingPlan(ctx context.Context, name string) (*bundle.Plan, error) {
+	ret := &bundle.Plan{}
+	if err := c.BaseClient.Request(ctx, http.MethodGet, "/v1/plugins/"+name+"/plan", nil, nil, ret); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+func (c *PluginsClient) Approve(ctx context.Context, name string, hash string) (*bundle.Plan, error) {
+	ret := &bundle.Plan{}
+	queries := map[string]string{"hash": hash}
+	if err := c.BaseClient.Request(ctx, http.MethodPost, "/v1/plugins/"+name+"/approve", queries, nil, ret); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+func (c *PluginsClient) Revisions(ctx context.Context, name string) ([]v1beta1.PluginRevision, error) {
+	ret := []v1beta1.PluginRevision{}
+	if err := c.BaseClient.Request(ctx, http.MethodGet, "/v1/plugins/"+name+"/revisions", nil, nil, &ret); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+func (c *PluginsClient) GetRevision(ctx context.Context, name string, revision int64) (*controller.Revision, error) {
+	ret := &controller.Revision{}
+	path := "/v1/plugins/" + name + "/revisions/" + strconv.FormatInt(revision, 10)
+	if err := c.BaseClient.Request(ctx, http.MethodGet, path, nil, nil, ret); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+func (c *PluginsClient) Rollback(ctx context.Context, name string, revision int64) (*controller.Revision, error) {
+	ret := &controller.Revision{}
+	queries := map[string]string{"revision": strconv.FormatInt(revision, 10)}
+	if err := c.BaseClient.Request(ctx, http.MethodPost, "/v1/plugins/"+name+"/rollback", queries, nil, ret); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
@@ -15,7 +15,10 @@
 package api
 
 import (
+	"errors"
+	"net/http"
 	"sort"
+	"strconv"
 
 	"github.com/emicklei/go-restful/v3"
 	"kubegems.io/kubegems/pkg/installer/pluginmanager"
@@ -157,6 +160,10 @@ func (o *PluginsAPI) EnablePlugin(req *restful.Request, resp *restful.Response)
 		return
 	}
 	if err := o.PM.Install(req.Request.Context(), name, version, pv.Values.Object); err != nil {
+		if pluginmanager.IsDependencyError(err) {
+			response.BadRequest(resp, err.Error())
+			return
+		}
 		response.Error(resp, err)
 		return
 	}
@@ -171,3 +178,94 @@ func (o *PluginsAPI) RemovePlugin(req *restful.Request, resp *restful.Response)
 	}
 	response.OK(resp, "ok")
 }
+
+// PlanPlugin returns the changes of enabling plugin with the version and values, nothing is applied.
+func (o *PluginsAPI) PlanPlugin(req *restful.Request, resp *restful.Response) {
+	name := req.PathParameter("name")
+	version := req.QueryParameter("version")
+
+	pv := &pluginmanager.PluginVersion{}
+	if err := request.Body(req.Request, pv); err != nil {
+		response.Error(resp, err)
+		return
+	}
+	plan, err := o.PM.Plan(req.Request.Context(), name, version, pv.Values.Object)
+	if err != nil {
+		response.Error(resp, err)
+		return
+	}
+	response.OK(resp, plan)
+}
+
+// PendingPlan returns the changes of current plugin which may be waiting for approval.
+func (o *PluginsAPI) PendingPlan(req *restful.Request, resp *restful.Response) {
+	name := req.PathParameter("name")
+	plan, err := o.PM.PendingPlan(req.Request.Context(), name)
+	if err != nil {
+		response.Error(resp, err)
+		return
+	}
+	response.OK(resp, plan)
+}
+
+// ApprovePlan approves the pending plan, the hash of reviewed plan is required.
+func (o *PluginsAPI) ApprovePlan(req *restful.Request, resp *restful.Response) {
+	name := req.PathParameter("name")
+	hash := req.QueryParameter("hash")
+	if hash == "" {
+		response.BadRequest(resp, "hash of the plan is required")
+		return
+	}
+	plan, err := o.PM.Approve(req.Request.Context(), name, hash)
+	if err != nil {
+		if errors.Is(err, pluginmanager.ErrPlanChanged) {
+			response.Error(resp, response.NewError(http.StatusConflict, err.Error()))
+			return
+		}
+		response.Error(resp, err)
+		return
+	}
+	response.OK(resp, plan)
+}
+
+func (o *PluginsAPI) ListRevisions(req *restful.Request, resp *restful.Response) {
+	name := req.PathParameter("name")
+	revisions, err := o.PM.Revisions(req.Request.Context(), name)
+	if err != nil {
+		response.Error(resp, err)
+		return
+	}
+	response.OK(resp, revisions)
+}
+
+// GetRevision returns the revision with rendered manifests and values.
+func (o *PluginsAPI) GetRevision(req *restful.Request, resp *restful.Response) {
+	name := req.PathParameter("name")
+	revision, err := strconv.ParseInt(req.PathParameter("revision"), 10, 64)
+	if err != nil {
+		response.BadRequest(resp, "invalid revision")
+		return
+	}
+	rev, err := o.PM.GetRevision(req.Request.Context(), name, revision)
+	if err != nil {
+		response.Error(resp, err)
+		return
+	}
+	response.OK(resp, rev)
+}
+
+// RollbackPlugin rollbacks the plugin to a previous revision, works for all kinds of bundle.
+func (o *PluginsAPI) RollbackPlugin(req *restful.Request, resp *restful.Response) {
+	name := req.PathParameter("name")
+	revision, err := strconv.ParseInt(req.QueryParameter("revision"), 10, 64)
+	if err != nil {
+		response.BadRequest(resp, "invalid revision")
+		return
+	}
+	rev, err := o.PM.Rollback(req.Request.Context(), name, revision)
+	if err != nil {
+		response.Error(resp, err)
+		return
+	}
+	response.OK(resp, rev)
+}
@@ -17,7 +17,9 @@ package bundle
 import (
 	"context"
 	"fmt"
+	"net/url"
 
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/client-go/rest"
 	plugins "kubegems.io/kubegems/pkg/apis/plugins"
 	pluginsv1beta1 "kubegems.io/kubegems/pkg/apis/plugins/v1beta1"
@@ -34,8 +36,14 @@ type Apply interface {
 	Template(ctx context.Context, bundle *pluginsv1beta1.Plugin, into string) ([]byte, error)
 }
 
+// Renderer is implemented by appliers whose Template does not render with values of the bundle.
+type Renderer interface {
+	Render(ctx context.Context, bundle *pluginsv1beta1.Plugin, into string) ([]byte, error)
+}
+
 type BundleApplier struct {
 	Options  *Options
+	cli      client.Client
 	appliers map[pluginsv1beta1.BundleKind]Apply
 }
 
@@ -50,6 +58,7 @@ func NewDefaultOptions() *Options {
 func NewDefaultApply(cfg *rest.Config, cli client.Client, options *Options) *BundleApplier {
 	return &BundleApplier{
 		Options: options,
+		cli:     cli,
 		appliers: map[pluginsv1beta1.BundleKind]Apply{
 			pluginsv1beta1.BundleKindHelm:      helm.New(cfg),
 			pluginsv1beta1.BundleKindKustomize: native.New(cli, kustomize.KustomizeBuildFunc),
@@ -69,18 +78,68 @@ func (b *BundleApplier) Template(ctx context.Context, bundle *pluginsv1beta1.Plu
 	return nil, fmt.Errorf("unknown bundle kind: %s", bundle.Spec.Kind)
 }
 
+// Render templates the bundle with its values,the result is same as what to apply.
+func (b *BundleApplier) Render(ctx context.Context, bundle *pluginsv1beta1.Plugin) ([]byte, error) {
+	into, err := b.Download(ctx, bundle)
+	if err != nil {
+		return nil, fmt.Errorf("download: %w", err)
+	}
+	apply, ok := b.appliers[bundle.Spec.Kind]
+	if !ok {
+		return nil, fmt.Errorf("unknown bundle kind: %s", bundle.Spec.Kind)
+	}
+	if renderer, ok := apply.(Renderer); ok {
+		return renderer.Render(ctx, bundle, into)
+	}
+	return apply.Template(ctx, bundle, into)
+}
+
 func (b *BundleApplier) Download(ctx context.Context, bundle *pluginsv1beta1.Plugin) (string, error) {
 	name := bundle.Name
 	if chart := bundle.Spec.Chart; chart != "" {
 		name = chart
 	}
-	return Download(ctx,
+	cred, err := b.credentials(ctx, bundle)
+	if err != nil {
+		return "", err
+	}
+	bundlepath, err := DownloadWithCredentials(ctx,
 		bundle.Spec.URL,
 		name,
 		bundle.Spec.Version,
 		bundle.Spec.Path,
 		b.Options.CacheDir,
+		cred,
 	)
+	if err != nil {
+		return "", err
+	}
+	// verify on both downloaded and cached bundle
+	if err := b.Verify(ctx, bundle, bundlepath); err != nil {
+		b.removeFromCache(bundle, bundlepath)
+		return "", &VerificationError{Bundle: bundle.Name, Err: err}
+	}
+	return bundlepath, nil
+}
+
+// credentials resolves credentials from the auth secret in namespace of the bundle.
+func (b *BundleApplier) credentials(ctx context.Context, bundle *pluginsv1beta1.Plugin) (*Credentials, error) {
+	ref := bundle.Spec.AuthSecretRef
+	if ref == nil || ref.Name == "" {
+		return nil, nil
+	}
+	if b.cli == nil {
+		return nil, fmt.Errorf("no kubernetes client to get auth secret %s", ref.Name)
+	}
+	secret := &corev1.Secret{}
+	if err := b.cli.Get(ctx, client.ObjectKey{Namespace: bundle.Namespace, Name: ref.Name}, secret); err != nil {
+		return nil, fmt.Errorf("get auth secret: %w", err)
+	}
+	host := ""
+	if u, err := url.Parse(bundle.Spec.URL); err == nil {
+		host = u.Host
+	}
+	return CredentialsFromSecret(secret, host), nil
 }
 
 func (b *BundleApplier) Apply(ctx context.Context, bundle *pluginsv1beta1.Plugin) error {
@@ -94,6 +153,21 @@ func (b *BundleApplier) Apply(ctx context.Context, bundle *pluginsv1beta1.Plugin
 	return fmt.Errorf("unknown bundle kind: %s", bundle.Spec.Kind)
 }
 
+// ManifestsApply is implemented by appliers which can apply rendered manifests directly.
+type ManifestsApply interface {
+	// ApplyManifests applies the rendered manifests,force to apply even if the bundle seems uptodate.
+	ApplyManifests(ctx context.Context, bundle *pluginsv1beta1.Plugin, rendered []byte, force bool) error
+}
+
+// ApplyManifests applies the rendered manifests of bundle,
+// bundles can't be applied from manifests(helm) are applied from the spec.
+func (b *BundleApplier) ApplyManifests(ctx context.Context, bundle *pluginsv1beta1.Plugin, rendered []byte, force bool) error {
+	if apply, ok := b.appliers[bundle.Spec.Kind].(ManifestsApply); ok && len(rendered) > 0 {
+		return apply.ApplyManifests(ctx, bundle, rendered, force)
+	}
+	return b.Apply(ctx, bundle)
+}
+
 func (b *BundleApplier) Remove(ctx context.Context, bundle *pluginsv1beta1.Plugin) error {
 	if apply, ok := b.appliers[bundle.Spec.Kind]; ok {
 		return apply.Remove(ctx, bundle)
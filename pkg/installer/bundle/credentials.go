@@ -0,0 +1,97 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bundle
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// keys in auth secret
+const (
+	CredentialsKeyUsername           = "username"
+	CredentialsKeyPassword           = "password"
+	CredentialsKeyAccessKey          = "accessKey"
+	CredentialsKeySecretKey          = "secretKey"
+	CredentialsKeyRegion             = "region"
+	CredentialsKeyEndpoint           = "endpoint"
+	CredentialsKeyInsecureSkipVerify = "insecureSkipVerify"
+)
+
+// Credentials used to access oci registry or s3.
+type Credentials struct {
+	// oci registry
+	Username string
+	Password string
+	// s3
+	AccessKey string
+	SecretKey string
+	Region    string
+	Endpoint  string // s3 endpoint, e.g. http://minio.kubegems-local:9000
+
+	InsecureSkipVerify bool
+}
+
+// CredentialsFromSecret reads credentials from secret data.
+// a kubernetes.io/dockerconfigjson secret is also accepted,the auth matched host is used.
+func CredentialsFromSecret(secret *corev1.Secret, host string) *Credentials {
+	data := secret.Data
+	cred := &Credentials{
+		Username:  string(data[CredentialsKeyUsername]),
+		Password:  string(data[CredentialsKeyPassword]),
+		AccessKey: string(data[CredentialsKeyAccessKey]),
+		SecretKey: string(data[CredentialsKeySecretKey]),
+		Region:    string(data[CredentialsKeyRegion]),
+		Endpoint:  string(data[CredentialsKeyEndpoint]),
+	}
+	cred.InsecureSkipVerify, _ = strconv.ParseBool(string(data[CredentialsKeyInsecureSkipVerify]))
+	if dockerconfig, ok := data[corev1.DockerConfigJsonKey]; ok && cred.Username == "" {
+		cred.Username, cred.Password = dockerConfigAuth(dockerconfig, host)
+	}
+	return cred
+}
+
+func dockerConfigAuth(dockerconfigjson []byte, host string) (string, string) {
+	config := struct {
+		Auths map[string]struct {
+			Username string `json:"username,omitempty"`
+			Password string `json:"password,omitempty"`
+			Auth     string `json:"auth,omitempty"`
+		} `json:"auths"`
+	}{}
+	if err := json.Unmarshal(dockerconfigjson, &config); err != nil {
+		return "", ""
+	}
+	for server, auth := range config.Auths {
+		server = strings.TrimPrefix(strings.TrimPrefix(server, "https://"), "http://")
+		if strings.TrimSuffix(server, "/") != host {
+			continue
+		}
+		if auth.Username != "" {
+			return auth.Username, auth.Password
+		}
+		decoded, err := base64.StdEncoding.DecodeString(auth.Auth)
+		if err != nil {
+			return "", ""
+		}
+		username, password, _ := strings.Cut(string(decoded), ":")
+		return username, password
+	}
+	return "", ""
+}
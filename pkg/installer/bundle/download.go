@@ -44,6 +44,12 @@ const (
 	defaultFileMode = 0o644
 )
 
+// archives of extracted bundles are kept as "{name}-{version}.archive.tgz" or "{name}-{version}.archive.zip"
+const (
+	ArchiveSuffixTgz = ".archive.tgz"
+	ArchiveSuffixZip = ".archive.zip"
+)
+
 type DownloadMeta struct {
 	Name    string
 	URL     string
@@ -55,6 +61,11 @@ type DownloadMeta struct {
 // we cache "bundle" in a directory with name
 // "{repo host}/{name}-{version} or {repo host}/{name}-{version}.tgz" under cache directory
 func Download(ctx context.Context, repo, name, version, path, cacheDir string) (string, error) {
+	return DownloadWithCredentials(ctx, repo, name, version, path, cacheDir, nil)
+}
+
+// DownloadWithCredentials is same as Download, the credentials are used to access oci registry and s3.
+func DownloadWithCredentials(ctx context.Context, repo, name, version, path, cacheDir string, cred *Credentials) (string, error) {
 	log := logr.FromContextOrDiscard(ctx)
 	if name == "" {
 		return "", errors.New("empty name")
@@ -84,6 +95,14 @@ func Download(ctx context.Context, repo, name, version, path, cacheDir string) (
 	cacheIn := filepath.Join(perRepoCacheDir, basename)
 	log.Info("downloading...", "cache", cacheIn)
 
+	// is oci ?
+	if strings.HasPrefix(repo, OCIScheme) {
+		return DownloadOCI(ctx, repo, name, version, path, perRepoCacheDir, cred)
+	}
+	// is s3 ?
+	if strings.HasPrefix(repo, S3Scheme) {
+		return DownloadS3(ctx, repo, name, version, path, perRepoCacheDir, cred)
+	}
 	// is git ?
 	if strings.HasSuffix(repo, ".git") {
 		return cacheIn, DownloadGit(ctx, repo, version, path, cacheIn)
@@ -146,7 +165,14 @@ func DownloadZip(ctx context.Context, uri, subpath, into string) error {
 	if err != nil {
 		return err
 	}
+	// keep the archive for verification
+	if err := saveFile(bytes.NewReader(raw), into+ArchiveSuffixZip); err != nil {
+		return err
+	}
+	return UnZip(raw, subpath, into)
+}
 
+func UnZip(raw []byte, subpath, into string) error {
 	r := bytes.NewReader(raw)
 	zipr, err := zip.NewReader(r, r.Size())
 	if err != nil {
@@ -200,7 +226,12 @@ func DownloadTgz(ctx context.Context, uri, subpath, into string) error {
 	}
 	defer resp.Body.Close()
 
-	return UnTarGz(resp.Body, subpath, into)
+	// keep the archive for verification
+	archive := into + ArchiveSuffixTgz
+	if err := saveFile(resp.Body, archive); err != nil {
+		return err
+	}
+	return ExtractArchive(archive, subpath, into)
 }
 
 func DownloadFile(ctx context.Context, src string, subpath, into string) error {
@@ -322,6 +353,14 @@ func UnTarGz(r io.Reader, subpath, into string) error {
 	}
 	defer gz.Close()
 
+	// links extracted before may point out of the directory,so paths are also checked after symlinks resolved
+	if err := os.MkdirAll(into, defaultDirMode); err != nil {
+		return err
+	}
+	realinto, err := filepath.EvalSymlinks(into)
+	if err != nil {
+		return err
+	}
 	tr := tar.NewReader(gz)
 	for {
 		hdr, err := tr.Next()
@@ -338,6 +377,45 @@ func UnTarGz(r io.Reader, subpath, into string) error {
 
 		filename := strings.TrimPrefix(hdr.Name, subpath)
 		filename = filepath.Join(into, filename)
+		// avoid writing files out of the directory, e.g. "../../etc/passwd"
+		if !strings.HasPrefix(filename, filepath.Clean(into)+string(os.PathSeparator)) && filename != filepath.Clean(into) {
+			return fmt.Errorf("invalid file path in archive: %s", hdr.Name)
+		}
+		realdir, err := realPath(filepath.Dir(filename))
+		if err != nil {
+			return err
+		}
+		if !isSubPath(realinto, realdir) && realdir != realinto {
+			return fmt.Errorf("invalid file path in archive: %s", hdr.Name)
+		}
+		// never write through a link extracted before
+		if fi, err := os.Lstat(filename); err == nil && fi.Mode()&os.ModeSymlink != 0 {
+			if err := os.Remove(filename); err != nil {
+				return err
+			}
+		}
+
+		if hdr.Typeflag == tar.TypeSymlink {
+			// links must not point out of the directory either
+			target := hdr.Linkname
+			if !filepath.IsAbs(target) {
+				target = filepath.Join(realdir, target)
+			}
+			realtarget, err := realPath(target)
+			if err != nil {
+				return err
+			}
+			if !isSubPath(realinto, realtarget) {
+				return fmt.Errorf("invalid link in archive: %s -> %s", hdr.Name, hdr.Linkname)
+			}
+			if err := os.MkdirAll(filepath.Dir(filename), defaultDirMode); err != nil {
+				return err
+			}
+			if err := os.Symlink(hdr.Linkname, filename); err != nil {
+				return err
+			}
+			continue
+		}
 
 		if hdr.FileInfo().IsDir() {
 			if err := os.MkdirAll(filename, defaultDirMode); err != nil {
@@ -361,6 +439,87 @@ func UnTarGz(r io.Reader, subpath, into string) error {
 	return nil
 }
 
+// realPath returns path with symlinks in its existing part resolved.
+func realPath(path string) (string, error) {
+	existing, rest := filepath.Clean(path), ""
+	for {
+		_, err := os.Lstat(existing)
+		if err == nil {
+			break
+		}
+		if !os.IsNotExist(err) {
+			return "", err
+		}
+		parent := filepath.Dir(existing)
+		if parent == existing {
+			break
+		}
+		rest, existing = filepath.Join(filepath.Base(existing), rest), parent
+	}
+	real, err := filepath.EvalSymlinks(existing)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(real, rest), nil
+}
+
+func isSubPath(dir, path string) bool {
+	return strings.HasPrefix(path, dir+string(os.PathSeparator))
+}
+
+// ArchiveOf returns the archive which the bundle extracted from,
+// a chart archive is the archive itself.
+func ArchiveOf(bundlepath string) (string, bool) {
+	if fi, err := os.Stat(bundlepath); err == nil && !fi.IsDir() {
+		return bundlepath, true
+	}
+	for _, archive := range []string{bundlepath + ArchiveSuffixTgz, bundlepath + ArchiveSuffixZip} {
+		if _, err := os.Stat(archive); err == nil {
+			return archive, true
+		}
+	}
+	return "", false
+}
+
+// ExtractArchive extracts a tgz or zip archive into directory.
+func ExtractArchive(archive, subpath, into string) error {
+	if strings.HasSuffix(archive, ".zip") {
+		raw, err := os.ReadFile(archive)
+		if err != nil {
+			return err
+		}
+		return UnZip(raw, subpath, into)
+	}
+	f, err := os.Open(archive)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return UnTarGz(f, subpath, into)
+}
+
+func saveFile(r io.Reader, filename string) error {
+	if err := os.MkdirAll(filepath.Dir(filename), defaultDirMode); err != nil {
+		return err
+	}
+	// write into a temp file first,avoid a broken file in cache
+	tmp := filename + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, defaultFileMode)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, filename)
+}
+
 func cachePath(path string, name, version string) string {
 	basename := name + "-" + version
 	tgzfile := filepath.Join(path, basename+".tgz")
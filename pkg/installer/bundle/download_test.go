@@ -14,7 +14,22 @@
 
 package bundle
 
-import "testing"
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
 
 func TestPerRepoCacheDir(t *testing.T) {
 	tests := []struct {
@@ -27,6 +42,16 @@ func TestPerRepoCacheDir(t *testing.T) {
 			basedir: "/app/plugins",
 			want:    "/app/plugins/foo.com/bar",
 		},
+		{
+			repo:    "oci://harbor.example.com/charts",
+			basedir: "/app/plugins",
+			want:    "/app/plugins/harbor.example.com/charts",
+		},
+		{
+			repo:    "s3://bundles/charts?endpoint=http://minio:9000",
+			basedir: "/app/plugins",
+			want:    "/app/plugins/bundles/charts",
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.repo, func(t *testing.T) {
@@ -36,3 +61,445 @@ func TestPerRepoCacheDir(t *testing.T) {
 		})
 	}
 }
+
+func TestParseOCIReference(t *testing.T) {
+	tests := []struct {
+		repo, name, version string
+		want                *OCIReference
+		wantErr             bool
+	}{
+		{
+			repo: "oci://harbor.example.com/charts", name: "nginx", version: "1.0.0",
+			want: &OCIReference{Registry: "harbor.example.com", Repository: "charts/nginx", Tag: "1.0.0"},
+		},
+		{
+			repo: "oci://harbor.example.com:8443/", name: "nginx",
+			want: &OCIReference{Registry: "harbor.example.com:8443", Repository: "nginx", Tag: "latest"},
+		},
+		{
+			repo: "oci://harbor.example.com/charts?plainHTTP=true&insecureSkipVerify=true", name: "nginx", version: "1.0.0",
+			want: &OCIReference{Registry: "harbor.example.com", Repository: "charts/nginx", Tag: "1.0.0", PlainHTTP: true, InsecureSkipVerify: true},
+		},
+		{
+			repo: "https://harbor.example.com/charts", name: "nginx", wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.repo, func(t *testing.T) {
+			got, err := ParseOCIReference(tt.repo, tt.name, tt.version)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseOCIReference() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseOCIReference() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseS3Object(t *testing.T) {
+	tests := []struct {
+		repo, name, version string
+		want                *S3Object
+	}{
+		{
+			repo: "s3://bundles/charts?endpoint=http://minio:9000&region=cn", name: "nginx", version: "1.0.0",
+			want: &S3Object{Bucket: "bundles", Key: "charts/nginx-1.0.0.tgz", Endpoint: "http://minio:9000", Region: "cn", Chart: true},
+		},
+		{
+			repo: "s3://bundles/kustomize/app.tar.gz", name: "app", version: "1.0.0",
+			want: &S3Object{Bucket: "bundles", Key: "kustomize/app.tar.gz"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.repo, func(t *testing.T) {
+			got, err := ParseS3Object(tt.repo, tt.name, tt.version)
+			if err != nil {
+				t.Fatalf("ParseS3Object() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseS3Object() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseChallenge(t *testing.T) {
+	scheme, params := parseChallenge(`Bearer realm="https://harbor.example.com/service/token",service="harbor-registry",scope="repository:charts/nginx:pull"`)
+	if scheme != "Bearer" {
+		t.Errorf("parseChallenge() scheme = %v", scheme)
+	}
+	want := map[string]string{
+		"realm":   "https://harbor.example.com/service/token",
+		"service": "harbor-registry",
+		"scope":   "repository:charts/nginx:pull",
+	}
+	if !reflect.DeepEqual(params, want) {
+		t.Errorf("parseChallenge() params = %v, want %v", params, want)
+	}
+}
+
+func TestDownloadOCI(t *testing.T) {
+	chart, prov := []byte("chart content"), []byte("provenance content")
+	tarball := testTarGz(t, map[string]string{"app/kustomization.yaml": "resources: []"})
+
+	tests := []struct {
+		name     string
+		auth     string
+		artifact string
+		cred     *Credentials
+		tamper   func([]byte) []byte // modifies blobs after pushed
+		wantErr  bool
+	}{
+		{
+			name:     "helm chart with bearer token",
+			auth:     "bearer",
+			artifact: "nginx",
+			cred:     &Credentials{Username: "admin", Password: "secret"},
+		},
+		{
+			name:     "tarball with basic auth",
+			auth:     "basic",
+			artifact: "app",
+			cred:     &Credentials{Username: "admin", Password: "secret"},
+		},
+		{
+			name:     "anonymous",
+			artifact: "nginx",
+		},
+		{
+			name:     "invalid credentials",
+			auth:     "basic",
+			artifact: "nginx",
+			cred:     &Credentials{Username: "admin", Password: "invalid"},
+			wantErr:  true,
+		},
+		{
+			name:     "no credentials",
+			auth:     "bearer",
+			artifact: "nginx",
+			wantErr:  true,
+		},
+		{
+			name:     "digest mismatch",
+			artifact: "app",
+			tamper:   func(b []byte) []byte { return append([]byte{b[0] ^ 0xff}, b[1:]...) },
+			wantErr:  true,
+		},
+		{
+			name:     "size mismatch",
+			artifact: "nginx",
+			tamper:   func(b []byte) []byte { return append(b, "more"...) },
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			registry := newTestRegistry(t, tt.auth)
+			registry.push("charts/nginx", "1.0.0", MediaTypeHelmChartContent, chart, MediaTypeHelmProvenance, prov)
+			registry.push("charts/app", "1.0.0", MediaTypeOCILayerTarGzip, tarball)
+			if tt.tamper != nil {
+				for digest, blob := range registry.blobs {
+					registry.blobs[digest] = tt.tamper(blob)
+				}
+			}
+
+			repo := "oci://" + strings.TrimPrefix(registry.URL, "http://") + "/charts?plainHTTP=true"
+			cachedir := PerRepoCacheDir(repo, t.TempDir())
+			got, err := DownloadOCI(context.Background(), repo, tt.artifact, "1.0.0", "", cachedir, tt.cred)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("DownloadOCI() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				if tt.tamper != nil {
+					if entries, _ := os.ReadDir(cachedir); len(entries) != 0 {
+						t.Errorf("DownloadOCI() left %d files of tampered blob in cache", len(entries))
+					}
+				}
+				return
+			}
+			switch tt.artifact {
+			case "nginx":
+				if got != filepath.Join(cachedir, "nginx-1.0.0.tgz") {
+					t.Errorf("DownloadOCI() = %v", got)
+				}
+				assertFileContent(t, got, chart)
+				assertFileContent(t, got+".prov", prov)
+			case "app":
+				if got != filepath.Join(cachedir, "app-1.0.0") {
+					t.Errorf("DownloadOCI() = %v", got)
+				}
+				assertFileContent(t, filepath.Join(got, "app", "kustomization.yaml"), []byte("resources: []"))
+			}
+		})
+	}
+}
+
+type testRegistry struct {
+	*httptest.Server
+	manifests map[string][]byte
+	blobs     map[string][]byte
+}
+
+// newTestRegistry starts a plain http oci registry,
+// auth "bearer" requires a token issued with basic auth,"basic" requires basic auth,others allow anonymous access.
+func newTestRegistry(t *testing.T, auth string) *testRegistry {
+	const username, password, token = "admin", "secret", "test-token"
+	registry := &testRegistry{manifests: map[string][]byte{}, blobs: map[string][]byte{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if u, p, ok := r.BasicAuth(); !ok || u != username || p != password {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]string{"token": token})
+	})
+	mux.HandleFunc("/v2/", func(w http.ResponseWriter, r *http.Request) {
+		switch auth {
+		case "bearer":
+			if r.Header.Get("Authorization") != "Bearer "+token {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="`+registry.URL+`/token",service="test-registry"`)
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+		case "basic":
+			if u, p, ok := r.BasicAuth(); !ok || u != username || p != password {
+				w.Header().Set("WWW-Authenticate", `Basic realm="test-registry"`)
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+		}
+		path := strings.TrimPrefix(r.URL.Path, "/v2/")
+		if repository, tag, ok := strings.Cut(path, "/manifests/"); ok {
+			manifest, ok := registry.manifests[repository+":"+tag]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Header().Set("Content-Type", MediaTypeOCIManifest)
+			_, _ = w.Write(manifest)
+			return
+		}
+		if _, digest, ok := strings.Cut(path, "/blobs/"); ok {
+			blob, ok := registry.blobs[digest]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			_, _ = w.Write(blob)
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	})
+	registry.Server = httptest.NewServer(mux)
+	t.Cleanup(registry.Close)
+	return registry
+}
+
+// push saves layers in pairs of media type and content
+func (r *testRegistry) push(repository, tag string, layers ...any) {
+	manifest := ociManifest{MediaType: MediaTypeOCIManifest}
+	for i := 0; i+1 < len(layers); i += 2 {
+		content := layers[i+1].([]byte)
+		sum := sha256.Sum256(content)
+		digest := "sha256:" + hex.EncodeToString(sum[:])
+		r.blobs[digest] = content
+		manifest.Layers = append(manifest.Layers, ociDescriptor{
+			MediaType: layers[i].(string),
+			Digest:    digest,
+			Size:      int64(len(content)),
+		})
+	}
+	r.manifests[repository+":"+tag], _ = json.Marshal(manifest)
+}
+
+func TestUnTarGz(t *testing.T) {
+	tests := []struct {
+		name    string
+		files   map[string]string
+		subpath string
+		want    map[string]string
+		wantErr bool
+	}{
+		{
+			name:  "extract",
+			files: map[string]string{"Chart.yaml": "name: foo", "templates/cm.yaml": "kind: ConfigMap"},
+			want:  map[string]string{"Chart.yaml": "name: foo", "templates/cm.yaml": "kind: ConfigMap"},
+		},
+		{
+			name:    "subpath",
+			files:   map[string]string{"foo/Chart.yaml": "name: foo", "bar/Chart.yaml": "name: bar"},
+			subpath: "foo/",
+			want:    map[string]string{"Chart.yaml": "name: foo"},
+		},
+		{
+			name:    "out of directory",
+			files:   map[string]string{"../evil": "evil"},
+			wantErr: true,
+		},
+		{
+			name:    "out of directory after subpath trimmed",
+			files:   map[string]string{"foo/../../evil": "evil"},
+			subpath: "foo/",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			into := filepath.Join(t.TempDir(), "into")
+			err := UnTarGz(bytes.NewReader(testTarGz(t, tt.files)), tt.subpath, into)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("UnTarGz() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if _, err := os.Stat(filepath.Join(filepath.Dir(into), "evil")); !os.IsNotExist(err) {
+				t.Errorf("file out of directory should not be written")
+			}
+			for name, content := range tt.want {
+				assertFileContent(t, filepath.Join(into, name), []byte(content))
+			}
+		})
+	}
+}
+
+func TestUnTarGzSymlink(t *testing.T) {
+	tests := []struct {
+		name     string
+		linkname string
+		wantErr  bool
+	}{
+		{name: "in directory", linkname: "../values.yaml"},
+		{name: "out of directory", linkname: "../../evil", wantErr: true},
+		{name: "absolute", linkname: "/etc/passwd", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := &bytes.Buffer{}
+			gw := gzip.NewWriter(buf)
+			tw := tar.NewWriter(gw)
+			hdr := &tar.Header{Name: "templates/values.yaml", Typeflag: tar.TypeSymlink, Linkname: tt.linkname, Mode: 0o777}
+			if err := tw.WriteHeader(hdr); err != nil {
+				t.Fatal(err)
+			}
+			if err := tw.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if err := gw.Close(); err != nil {
+				t.Fatal(err)
+			}
+			into := t.TempDir()
+			err := UnTarGz(buf, "", into)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("UnTarGz() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if link, err := os.Readlink(filepath.Join(into, "templates", "values.yaml")); err != nil || link != tt.linkname {
+				t.Errorf("symlink = %s(%v), want %s", link, err, tt.linkname)
+			}
+		})
+	}
+}
+
+func TestUnTarGzThroughSymlink(t *testing.T) {
+	type entry struct{ name, linkname, content string }
+	tests := []struct {
+		name    string
+		entries []entry
+		wantErr bool
+	}{
+		{
+			name: "link out of directory by a link extracted before",
+			entries: []entry{
+				{name: "s/t/d", linkname: ".."},
+				{name: "s/t/d/l", linkname: "../../evil"},
+				{name: "s/t/d/l", content: "evil"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "file written to a linked directory out of directory",
+			entries: []entry{
+				{name: "s/t/d", linkname: ".."},
+				{name: "s/t/d/l", linkname: "../.."},
+				{name: "s/t/d/l/evil", content: "evil"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "file replaces a link",
+			entries: []entry{
+				{name: "values.yaml", linkname: "target.yaml"},
+				{name: "values.yaml", content: "foo: bar"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := &bytes.Buffer{}
+			gw := gzip.NewWriter(buf)
+			tw := tar.NewWriter(gw)
+			for _, e := range tt.entries {
+				hdr := &tar.Header{Name: e.name, Mode: 0o644, Size: int64(len(e.content))}
+				if e.linkname != "" {
+					hdr = &tar.Header{Name: e.name, Typeflag: tar.TypeSymlink, Linkname: e.linkname, Mode: 0o777}
+				}
+				if err := tw.WriteHeader(hdr); err != nil {
+					t.Fatal(err)
+				}
+				if _, err := tw.Write([]byte(e.content)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := tw.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if err := gw.Close(); err != nil {
+				t.Fatal(err)
+			}
+			into := filepath.Join(t.TempDir(), "into")
+			err := UnTarGz(buf, "", into)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("UnTarGz() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if _, err := os.Stat(filepath.Join(filepath.Dir(into), "evil")); !os.IsNotExist(err) {
+				t.Errorf("file out of directory should not be written")
+			}
+			if _, err := os.Stat(filepath.Join(into, "target.yaml")); !os.IsNotExist(err) {
+				t.Errorf("file should not be written through a link")
+			}
+		})
+	}
+}
+
+func testTarGz(t *testing.T, files map[string]string) []byte {
+	buf := &bytes.Buffer{}
+	gw := gzip.NewWriter(buf)
+	tw := tar.NewWriter(gw)
+	for name, content := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: int64(len(content))}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func assertFileContent(t *testing.T, filename string, want []byte) {
+	t.Helper()
+	got, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("content of %s = %s, want %s", filename, got, want)
+	}
+}
@@ -17,9 +17,11 @@ package helm
 import (
 	"context"
 	"fmt"
+	"sort"
 	"time"
 
 	"github.com/go-logr/logr"
+	"golang.org/x/exp/maps"
 	"helm.sh/helm/v3/pkg/release"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/rest"
@@ -41,6 +43,12 @@ func (r *Apply) Template(ctx context.Context, bundle *pluginsv1beta1.Plugin, dir
 	return TemplateChart(ctx, rls.Name, rls.Namespace, dir, nil)
 }
 
+// Render templates the chart with values of the bundle,same as what to apply.
+func (r *Apply) Render(ctx context.Context, bundle *pluginsv1beta1.Plugin, dir string) ([]byte, error) {
+	rls := r.getPreRelease(bundle)
+	return TemplateChart(ctx, rls.Name, rls.Namespace, dir, rls.Config)
+}
+
 func (r *Apply) Apply(ctx context.Context, bundle *pluginsv1beta1.Plugin, into string) error {
 	rls := r.getPreRelease(bundle)
 	applyedRelease, err := ApplyChart(ctx, r.Config, rls.Name, rls.Namespace, into, rls.Config)
@@ -63,8 +71,15 @@ func (r *Apply) Apply(ctx context.Context, bundle *pluginsv1beta1.Plugin, into s
 }
 
 func ParseResourceReferences(resources []byte) []v1beta1.ManagedResource {
+	managedResources, _ := ParseResourceReferencesAndImages(resources)
+	return managedResources
+}
+
+// ParseResourceReferencesAndImages also returns the container images used by the resources.
+func ParseResourceReferencesAndImages(resources []byte) ([]v1beta1.ManagedResource, []string) {
 	ress, _ := utils.SplitYAML(resources)
 	managedResources := make([]v1beta1.ManagedResource, len(ress))
+	images := map[string]struct{}{}
 	for i, res := range ress {
 		managedResources[i] = v1beta1.ManagedResource{
 			APIVersion: res.GetObjectKind().GroupVersionKind().GroupVersion().String(),
@@ -72,8 +87,11 @@ func ParseResourceReferences(resources []byte) []v1beta1.ManagedResource {
 			Name:       res.GetName(),
 			Namespace:  res.GetNamespace(),
 		}
+		utils.FindImages(res.Object, images)
 	}
-	return managedResources
+	sortedImages := maps.Keys(images)
+	sort.Strings(sortedImages)
+	return managedResources, sortedImages
 }
 
 // https://github.com/golang/go/issues/19502
@@ -168,6 +168,58 @@ func downloadChart(ctx context.Context, repourl, name, version string) (string,
 	return filename, nil
 }
 
+// DownloadProvenance downloads the provenance file "{chart url}.prov" of the chart into file,
+// tls of the repository is verified unless insecureSkipTLSVerify.
+func DownloadProvenance(ctx context.Context, repourl, name, version, into string, insecureSkipTLSVerify bool) error {
+	repou, err := url.Parse(repourl)
+	if err != nil {
+		return err
+	}
+	var content []byte
+	if repou.Scheme == FileProtocolSchema {
+		chartpath, err := LocateChartSuper(ctx, repourl, name, version)
+		if err != nil {
+			return err
+		}
+		if content, err = os.ReadFile(chartpath + ".prov"); err != nil {
+			return err
+		}
+	} else {
+		settings := cli.New()
+		getters := getter.All(settings)
+		chartURL, err := repo.FindChartInAuthAndTLSAndPassRepoURL(
+			repourl,
+			"", "", // username password
+			name, version,
+			"", "", "", // cert key ca
+			insecureSkipTLSVerify, false, // insecureTLS passCredentialsAll
+			getters)
+		if err != nil {
+			return err
+		}
+		provu, err := url.Parse(chartURL + ".prov")
+		if err != nil {
+			return err
+		}
+		g, err := getters.ByScheme(provu.Scheme)
+		if err != nil {
+			return err
+		}
+		buf, err := g.Get(provu.String(),
+			getter.WithUserAgent(KubegemsUserAgent()),
+			getter.WithInsecureSkipVerifyTLS(insecureSkipTLSVerify),
+		)
+		if err != nil {
+			return fmt.Errorf("failed to download provenance of %s: %w", name, err)
+		}
+		content = buf.Bytes()
+	}
+	if err := os.MkdirAll(filepath.Dir(into), DefaultDirectoryMode); err != nil {
+		return err
+	}
+	return os.WriteFile(into, content, DefaultFileMode)
+}
+
 func KubegemsUserAgent() string {
 	return "Kubegems-installer/" + strings.TrimPrefix(version.Get().GitVersion, "v")
 }
@@ -44,12 +44,22 @@ func (p *Apply) Template(ctx context.Context, bundle *pluginsv1beta1.Plugin, int
 }
 
 func (p *Apply) Apply(ctx context.Context, bundle *pluginsv1beta1.Plugin, into string) error {
-	log := logr.FromContextOrDiscard(ctx)
-
 	rendered, err := p.Template(ctx, bundle, into)
 	if err != nil {
 		return err
 	}
+	return p.apply(ctx, bundle, rendered, false)
+}
+
+// ApplyManifests applies the rendered manifests of bundle,
+// force to apply even if it seems uptodate, e.g. manifests of a previous revision.
+func (p *Apply) ApplyManifests(ctx context.Context, bundle *pluginsv1beta1.Plugin, rendered []byte, force bool) error {
+	return p.apply(ctx, bundle, rendered, force)
+}
+
+func (p *Apply) apply(ctx context.Context, bundle *pluginsv1beta1.Plugin, rendered []byte, force bool) error {
+	log := logr.FromContextOrDiscard(ctx)
+
 	resources, err := utils.SplitYAML(rendered)
 	if err != nil {
 		return err
@@ -60,7 +70,7 @@ func (p *Apply) Apply(ctx context.Context, bundle *pluginsv1beta1.Plugin, into s
 		ns = bundle.Namespace
 	}
 	diffresult := utils.DiffWithDefaultNamespace(p.Cli.Client, ns, convertList(bundle.Status.Resources), resources)
-	if bundle.Status.Phase == pluginsv1beta1.PhaseInstalled &&
+	if !force && bundle.Status.Phase == pluginsv1beta1.PhaseInstalled &&
 		bundle.Spec.Version == bundle.Status.Version &&
 		utils.EqualMapValues(bundle.Status.Values.Object, bundle.Spec.Values.Object) &&
 		len(diffresult.Creats) == 0 &&
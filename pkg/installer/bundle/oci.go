@@ -0,0 +1,417 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bundle
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	OCIScheme = "oci://"
+
+	MediaTypeOCIManifest       = "application/vnd.oci.image.manifest.v1+json"
+	MediaTypeDockerManifest    = "application/vnd.docker.distribution.manifest.v2+json"
+	MediaTypeHelmChartContent  = "application/vnd.cncf.helm.chart.content.v1.tar+gzip"
+	MediaTypeHelmProvenance    = "application/vnd.cncf.helm.chart.provenance.v1.prov"
+	MediaTypeOCILayerTarGzip   = "application/vnd.oci.image.layer.v1.tar+gzip"
+	MediaTypeDockerLayerTarGz  = "application/vnd.docker.image.rootfs.diff.tar.gzip"
+	mediaTypeSuffixTarGzip     = "tar+gzip"
+	ociManifestMaxSize         = 4 << 20
+	ociErrorResponseMaxSize    = 1024
+	defaultOCIReferenceVersion = "latest"
+)
+
+// OCIReference is the reference of an artifact in oci registry.
+// "oci://{registry}/{path}" with chart name and version refers to "{registry}/{path}/{name}:{version}".
+// "oci://{registry}/{path}?plainHTTP=true&insecureSkipVerify=true" accesses the registry over http or skips tls verify.
+type OCIReference struct {
+	Registry           string
+	Repository         string
+	Tag                string
+	PlainHTTP          bool
+	InsecureSkipVerify bool
+}
+
+func (r OCIReference) String() string {
+	return r.Registry + "/" + r.Repository + ":" + r.Tag
+}
+
+func ParseOCIReference(repo, name, version string) (*OCIReference, error) {
+	if !strings.HasPrefix(repo, OCIScheme) {
+		return nil, fmt.Errorf("invalid oci url: %s", repo)
+	}
+	location, rawquery, _ := strings.Cut(strings.TrimPrefix(repo, OCIScheme), "?")
+	query, err := url.ParseQuery(rawquery)
+	if err != nil {
+		return nil, fmt.Errorf("invalid oci url: %s: %w", repo, err)
+	}
+	registry, path, _ := strings.Cut(strings.TrimSuffix(location, "/"), "/")
+	if registry == "" {
+		return nil, fmt.Errorf("no registry in oci url: %s", repo)
+	}
+	repository := name
+	if path != "" {
+		repository = path + "/" + name
+	}
+	if version == "" {
+		version = defaultOCIReferenceVersion
+	}
+	ref := &OCIReference{Registry: registry, Repository: repository, Tag: version}
+	ref.PlainHTTP, _ = strconv.ParseBool(query.Get("plainHTTP"))
+	ref.InsecureSkipVerify, _ = strconv.ParseBool(query.Get("insecureSkipVerify"))
+	return ref, nil
+}
+
+type ociDescriptor struct {
+	MediaType string `json:"mediaType"`
+	Digest    string `json:"digest"`
+	Size      int64  `json:"size"`
+}
+
+type ociManifest struct {
+	MediaType string          `json:"mediaType"`
+	Config    ociDescriptor   `json:"config"`
+	Layers    []ociDescriptor `json:"layers"`
+}
+
+// DownloadOCI pulls an artifact from oci registry.
+// helm chart is saved as "{name}-{version}.tgz" in cachedir,
+// others(kustomize/template tarball) are extracted into "{name}-{version}" under cachedir.
+func DownloadOCI(ctx context.Context, repo, name, version, subpath, cachedir string, cred *Credentials) (string, error) {
+	ref, err := ParseOCIReference(repo, name, version)
+	if err != nil {
+		return "", err
+	}
+	cli := NewOCIClient(ref, cred)
+	manifest, err := cli.Manifest(ctx, ref.Repository, ref.Tag)
+	if err != nil {
+		return "", fmt.Errorf("get manifest of %s: %w", ref, err)
+	}
+	layer, err := chooseLayer(manifest)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", ref, err)
+	}
+	blob, err := cli.VerifiedBlob(ctx, ref.Repository, *layer)
+	if err != nil {
+		return "", fmt.Errorf("get blob %s of %s: %w", layer.Digest, ref, err)
+	}
+	defer blob.Close()
+
+	basename := name
+	if version != "" {
+		basename = name + "-" + version
+	}
+	if layer.MediaType == MediaTypeHelmChartContent {
+		chartpath := filepath.Join(cachedir, basename+".tgz")
+		if err := saveFile(blob, chartpath); err != nil {
+			return "", err
+		}
+		// provenance is pushed along with the chart
+		if prov := findLayer(manifest, MediaTypeHelmProvenance); prov != nil {
+			provblob, err := cli.VerifiedBlob(ctx, ref.Repository, *prov)
+			if err != nil {
+				return "", fmt.Errorf("get provenance of %s: %w", ref, err)
+			}
+			defer provblob.Close()
+			if err := saveFile(provblob, chartpath+".prov"); err != nil {
+				return "", err
+			}
+		}
+		return chartpath, nil
+	}
+	into := filepath.Join(cachedir, basename)
+	archive := into + ArchiveSuffixTgz
+	if err := saveFile(blob, archive); err != nil {
+		return "", err
+	}
+	return into, ExtractArchive(archive, subpath, into)
+}
+
+func findLayer(manifest *ociManifest, mediaType string) *ociDescriptor {
+	for i, layer := range manifest.Layers {
+		if layer.MediaType == mediaType {
+			return &manifest.Layers[i]
+		}
+	}
+	return nil
+}
+
+func chooseLayer(manifest *ociManifest) (*ociDescriptor, error) {
+	if layer := findLayer(manifest, MediaTypeHelmChartContent); layer != nil {
+		return layer, nil
+	}
+	for i, layer := range manifest.Layers {
+		switch {
+		case layer.MediaType == MediaTypeOCILayerTarGzip,
+			layer.MediaType == MediaTypeDockerLayerTarGz,
+			strings.HasSuffix(layer.MediaType, mediaTypeSuffixTarGzip):
+			return &manifest.Layers[i], nil
+		}
+	}
+	return nil, fmt.Errorf("no tar+gzip layer found in manifest")
+}
+
+// OCIClient is a minimal oci distribution client only used to pull artifacts.
+// https://github.com/opencontainers/distribution-spec/blob/main/spec.md#pulling-manifests
+type OCIClient struct {
+	Registry  string
+	Username  string
+	Password  string
+	PlainHTTP bool
+	client    *http.Client
+	token     string
+}
+
+func NewOCIClient(ref *OCIReference, cred *Credentials) *OCIClient {
+	cli := &OCIClient{Registry: ref.Registry, PlainHTTP: ref.PlainHTTP, client: http.DefaultClient}
+	insecureSkipVerify := ref.InsecureSkipVerify
+	if cred != nil {
+		cli.Username, cli.Password = cred.Username, cred.Password
+		insecureSkipVerify = insecureSkipVerify || cred.InsecureSkipVerify
+	}
+	if insecureSkipVerify {
+		cli.client = &http.Client{
+			Transport: &http.Transport{
+				Proxy:           http.ProxyFromEnvironment,
+				TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+			},
+		}
+	}
+	return cli
+}
+
+// end-3 GET /v2/<name>/manifests/<reference>
+func (c *OCIClient) Manifest(ctx context.Context, repository, reference string) (*ociManifest, error) {
+	resp, err := c.get(ctx, "/v2/"+repository+"/manifests/"+reference,
+		strings.Join([]string{MediaTypeOCIManifest, MediaTypeDockerManifest}, ","))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	manifest := &ociManifest{}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, ociManifestMaxSize)).Decode(manifest); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// end-2 GET /v2/<name>/blobs/<digest>
+func (c *OCIClient) Blob(ctx context.Context, repository, digest string) (io.ReadCloser, error) {
+	resp, err := c.get(ctx, "/v2/"+repository+"/blobs/"+digest, "")
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// VerifiedBlob returns the blob content,reading it to the end fails if size or digest mismatched with the descriptor.
+func (c *OCIClient) VerifiedBlob(ctx context.Context, repository string, desc ociDescriptor) (io.ReadCloser, error) {
+	algorithm, encoded, _ := strings.Cut(desc.Digest, ":")
+	var hasher hash.Hash
+	switch algorithm {
+	case "sha256":
+		hasher = sha256.New()
+	case "sha512":
+		hasher = sha512.New()
+	default:
+		return nil, fmt.Errorf("unsupported digest %q", desc.Digest)
+	}
+	if desc.Size < 0 {
+		return nil, fmt.Errorf("invalid size %d of blob %s", desc.Size, desc.Digest)
+	}
+	blob, err := c.Blob(ctx, repository, desc.Digest)
+	if err != nil {
+		return nil, err
+	}
+	return &blobVerifier{
+		ReadCloser: blob,
+		reader:     io.LimitReader(blob, desc.Size+1), // one more byte to find oversized content
+		hasher:     hasher,
+		desc:       desc,
+		encoded:    encoded,
+	}, nil
+}
+
+type blobVerifier struct {
+	io.ReadCloser
+	reader  io.Reader
+	hasher  hash.Hash
+	desc    ociDescriptor
+	encoded string
+	size    int64
+}
+
+func (v *blobVerifier) Read(p []byte) (int, error) {
+	n, err := v.reader.Read(p)
+	v.hasher.Write(p[:n])
+	v.size += int64(n)
+	if v.size > v.desc.Size {
+		return n, fmt.Errorf("blob %s is larger than %d bytes", v.desc.Digest, v.desc.Size)
+	}
+	if err == io.EOF {
+		if v.size != v.desc.Size {
+			return n, fmt.Errorf("blob %s size mismatch: expected %d, got %d", v.desc.Digest, v.desc.Size, v.size)
+		}
+		if actual := hex.EncodeToString(v.hasher.Sum(nil)); actual != v.encoded {
+			return n, fmt.Errorf("blob %s digest mismatch: got %s", v.desc.Digest, actual)
+		}
+	}
+	return n, err
+}
+
+func (c *OCIClient) get(ctx context.Context, path, accept string) (*http.Response, error) {
+	resp, err := c.do(ctx, path, accept)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		challenge := resp.Header.Get("WWW-Authenticate")
+		resp.Body.Close()
+		if err := c.authorize(ctx, challenge); err != nil {
+			return nil, err
+		}
+		if resp, err = c.do(ctx, path, accept); err != nil {
+			return nil, err
+		}
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		content, _ := io.ReadAll(io.LimitReader(resp.Body, ociErrorResponseMaxSize))
+		return nil, fmt.Errorf("%s: %s", resp.Status, string(content))
+	}
+	return resp, nil
+}
+
+func (c *OCIClient) do(ctx context.Context, path, accept string) (*http.Response, error) {
+	scheme := "https://"
+	if c.PlainHTTP {
+		scheme = "http://"
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, scheme+c.Registry+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	switch {
+	case c.token != "":
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	case c.Username != "":
+		req.SetBasicAuth(c.Username, c.Password)
+	}
+	return c.client.Do(req)
+}
+
+// authorize gets a bearer token or uses basic auth according to the challenge
+// https://docs.docker.com/registry/spec/auth/token/
+func (c *OCIClient) authorize(ctx context.Context, challenge string) error {
+	scheme, params := parseChallenge(challenge)
+	switch {
+	case strings.EqualFold(scheme, "basic"):
+		if c.Username == "" {
+			return fmt.Errorf("unauthorized: no credentials for basic auth of %s", c.Registry)
+		}
+		// basic auth has been sent if no token
+		if c.token == "" {
+			return fmt.Errorf("unauthorized: invalid credentials for %s", c.Registry)
+		}
+		c.token = ""
+		return nil
+	case !strings.EqualFold(scheme, "bearer"):
+		return fmt.Errorf("unauthorized: unsupported auth challenge %q", challenge)
+	}
+	realm, err := url.Parse(params["realm"])
+	if err != nil || realm.Host == "" {
+		return fmt.Errorf("unauthorized: invalid realm in challenge %q", challenge)
+	}
+	query := realm.Query()
+	if service := params["service"]; service != "" {
+		query.Set("service", service)
+	}
+	if scope := params["scope"]; scope != "" {
+		query.Set("scope", scope)
+	}
+	realm.RawQuery = query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, realm.String(), nil)
+	if err != nil {
+		return err
+	}
+	if c.Username != "" {
+		req.SetBasicAuth(c.Username, c.Password)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		content, _ := io.ReadAll(io.LimitReader(resp.Body, ociErrorResponseMaxSize))
+		return fmt.Errorf("get token: %s: %s", resp.Status, string(content))
+	}
+	token := struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return err
+	}
+	if c.token = token.Token; c.token == "" {
+		c.token = token.AccessToken
+	}
+	if c.token == "" {
+		return fmt.Errorf("get token: empty token")
+	}
+	return nil
+}
+
+// parseChallenge parses `Bearer realm="https://auth.io/token",service="registry.io",scope="repository:foo:pull"`
+func parseChallenge(challenge string) (string, map[string]string) {
+	scheme, rest, _ := strings.Cut(strings.TrimSpace(challenge), " ")
+	params := map[string]string{}
+	for rest = strings.TrimSpace(rest); rest != ""; rest = strings.TrimSpace(rest) {
+		key, value, ok := strings.Cut(rest, "=")
+		if !ok {
+			break
+		}
+		key = strings.TrimSpace(key)
+		if strings.HasPrefix(value, `"`) {
+			end := strings.Index(value[1:], `"`)
+			if end < 0 {
+				params[key] = value[1:]
+				break
+			}
+			params[key] = value[1 : end+1]
+			rest = strings.TrimPrefix(strings.TrimSpace(value[end+2:]), ",")
+		} else {
+			params[key], rest, _ = strings.Cut(value, ",")
+		}
+	}
+	return scheme, params
+}
@@ -0,0 +1,108 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bundle
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"kubegems.io/kubegems/pkg/apis/plugins"
+	pluginsv1beta1 "kubegems.io/kubegems/pkg/apis/plugins/v1beta1"
+	"kubegems.io/kubegems/pkg/installer/utils"
+	"kubegems.io/kubegems/pkg/utils/generic"
+)
+
+// Plan is the changes would be made when apply the bundle.
+type Plan struct {
+	Name        string               `json:"name"`
+	Namespace   string               `json:"namespace"`
+	FromVersion string               `json:"fromVersion,omitempty"`
+	ToVersion   string               `json:"toVersion,omitempty"`
+	Hash        string               `json:"hash"`
+	Approved    bool                 `json:"approved"`
+	Resources   []utils.ResourceDiff `json:"resources"`
+}
+
+func (p *Plan) HasChanges() bool {
+	for _, res := range p.Resources {
+		if res.Action != utils.DiffActionNone {
+			return true
+		}
+	}
+	return false
+}
+
+// Plan templates the bundle and computes per-resource diff against live managed resources,nothing is applied.
+// values of the bundle must be resolved before.
+func (b *BundleApplier) Plan(ctx context.Context, bundle *pluginsv1beta1.Plugin) (*Plan, error) {
+	if b.cli == nil {
+		return nil, errors.New("no kubernetes client to compute plan")
+	}
+	rendered, err := b.Render(ctx, bundle)
+	if err != nil {
+		return nil, fmt.Errorf("template: %w", err)
+	}
+	resources, err := utils.SplitYAML(rendered)
+	if err != nil {
+		return nil, err
+	}
+	ns := bundle.Spec.InstallNamespace
+	if ns == "" {
+		ns = bundle.Namespace
+	}
+	managed := generic.MapList(bundle.Status.Resources, func(item pluginsv1beta1.ManagedResource) utils.ManagedResource {
+		return utils.ManagedResource{Kind: item.Kind, APIVersion: item.APIVersion, Name: item.Name, Namespace: item.Namespace}
+	})
+	diff := utils.DiffWithDefaultNamespace(b.cli, ns, managed, resources)
+	hash := PlanHash(bundle)
+	return &Plan{
+		Name:        bundle.Name,
+		Namespace:   bundle.Namespace,
+		FromVersion: bundle.Status.Version,
+		ToVersion:   bundle.Spec.Version,
+		Hash:        hash,
+		Approved:    bundle.Annotations[plugins.AnnotationApprovedPlan] == hash,
+		Resources:   utils.PlanDiff(ctx, b.cli, diff, utils.NewDefaultSyncOptions()),
+	}, nil
+}
+
+// PlanHash returns the hash of what to apply,the rendered manifests are not used
+// because of templates may generate random values.
+func PlanHash(bundle *pluginsv1beta1.Plugin) string {
+	spec := bundle.Spec
+	content, _ := json.Marshal(map[string]any{
+		"kind":             spec.Kind,
+		"url":              spec.URL,
+		"chart":            spec.Chart,
+		"version":          spec.Version,
+		"path":             spec.Path,
+		"digest":           spec.Digest,
+		"installNamespace": spec.InstallNamespace,
+		"values":           spec.Values.Object,
+	})
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// RequireApproval returns true if changes of the bundle must be approved before apply.
+func RequireApproval(bundle *pluginsv1beta1.Plugin) bool {
+	required, _ := strconv.ParseBool(bundle.Annotations[plugins.AnnotationRequireApproval])
+	return required
+}
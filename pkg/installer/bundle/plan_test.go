@@ -0,0 +1,64 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bundle
+
+import (
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"kubegems.io/kubegems/pkg/apis/plugins"
+	pluginsv1beta1 "kubegems.io/kubegems/pkg/apis/plugins/v1beta1"
+)
+
+func TestPlanHash(t *testing.T) {
+	newPlugin := func() *pluginsv1beta1.Plugin {
+		return &pluginsv1beta1.Plugin{
+			ObjectMeta: metav1.ObjectMeta{Name: "nginx", Namespace: "kubegems-installer"},
+			Spec: pluginsv1beta1.PluginSpec{
+				Kind:    pluginsv1beta1.BundleKindHelm,
+				URL:     "https://charts.example.com",
+				Version: "1.0.0",
+				Values:  pluginsv1beta1.Values{Object: map[string]any{"replicas": 1}},
+			},
+		}
+	}
+	base := PlanHash(newPlugin())
+	if base == "" || base != PlanHash(newPlugin()) {
+		t.Fatalf("PlanHash() is not stable")
+	}
+
+	// changes not applied do not change the plan
+	unchanged := newPlugin()
+	unchanged.Labels = map[string]string{"foo": "bar"}
+	unchanged.Annotations = map[string]string{plugins.AnnotationApprovedPlan: base}
+	unchanged.Status = pluginsv1beta1.PluginStatus{Phase: pluginsv1beta1.PhaseInstalled, Version: "0.9.0"}
+	if got := PlanHash(unchanged); got != base {
+		t.Errorf("PlanHash() changed on labels,annotations or status")
+	}
+
+	for name, change := range map[string]func(p *pluginsv1beta1.Plugin){
+		"version":   func(p *pluginsv1beta1.Plugin) { p.Spec.Version = "1.0.1" },
+		"values":    func(p *pluginsv1beta1.Plugin) { p.Spec.Values.Object["replicas"] = 2 },
+		"url":       func(p *pluginsv1beta1.Plugin) { p.Spec.URL = "oci://harbor.example.com/charts" },
+		"digest":    func(p *pluginsv1beta1.Plugin) { p.Spec.Digest = "sha256:abc" },
+		"namespace": func(p *pluginsv1beta1.Plugin) { p.Spec.InstallNamespace = "default" },
+	} {
+		changed := newPlugin()
+		change(changed)
+		if PlanHash(changed) == base {
+			t.Errorf("PlanHash() not changed on %s changed", name)
+		}
+	}
+}
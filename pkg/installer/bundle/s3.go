@@ -0,0 +1,172 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bundle
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+const (
+	S3Scheme        = "s3://"
+	defaultS3Region = "us-east-1"
+)
+
+// S3Object is the object location of a s3 url.
+// "s3://{bucket}/{key}?endpoint={endpoint}&region={region}"
+// when key is not end with .tgz/.tar.gz/.zip, it is a prefix and object "{prefix}/{name}-{version}.tgz" is used.
+type S3Object struct {
+	Bucket   string
+	Key      string
+	Endpoint string
+	Region   string
+	Chart    bool // key is joined from prefix,name and version
+}
+
+func ParseS3Object(repo, name, version string) (*S3Object, error) {
+	u, err := url.Parse(repo)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "s3" || u.Host == "" {
+		return nil, fmt.Errorf("invalid s3 url: %s", repo)
+	}
+	obj := &S3Object{
+		Bucket:   u.Host,
+		Key:      strings.TrimPrefix(u.Path, "/"),
+		Endpoint: u.Query().Get("endpoint"),
+		Region:   u.Query().Get("region"),
+	}
+	if !isArchive(obj.Key) {
+		basename := name
+		if version != "" {
+			basename = name + "-" + version
+		}
+		obj.Key = path.Join(obj.Key, basename+".tgz")
+		obj.Chart = true
+	}
+	return obj, nil
+}
+
+func isArchive(name string) bool {
+	return strings.HasSuffix(name, ".zip") || strings.HasSuffix(name, ".tar.gz") || strings.HasSuffix(name, ".tgz")
+}
+
+// DownloadS3 downloads a object from s3.
+// zip and tarball objects specified in url are extracted into "{name}-{version}" under cachedir,
+// objects under a prefix are treated as helm chart archive and saved as "{name}-{version}.tgz".
+func DownloadS3(ctx context.Context, repo, name, version, subpath, cachedir string, cred *Credentials) (string, error) {
+	obj, err := ParseS3Object(repo, name, version)
+	if err != nil {
+		return "", err
+	}
+	if cred == nil {
+		cred = &Credentials{}
+	}
+	if obj.Endpoint == "" {
+		obj.Endpoint = cred.Endpoint
+	}
+	if obj.Region == "" {
+		obj.Region = cred.Region
+	}
+	cli, err := newS3Client(ctx, obj, cred)
+	if err != nil {
+		return "", err
+	}
+	out, err := cli.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(obj.Bucket),
+		Key:    aws.String(obj.Key),
+	})
+	if err != nil {
+		return "", fmt.Errorf("get object %s/%s: %w", obj.Bucket, obj.Key, err)
+	}
+	defer out.Body.Close()
+
+	basename := name
+	if version != "" {
+		basename = name + "-" + version
+	}
+	into := filepath.Join(cachedir, basename)
+	switch {
+	case obj.Chart:
+		chartpath := into + ".tgz"
+		return chartpath, saveFile(out.Body, chartpath)
+	case strings.HasSuffix(obj.Key, ".zip"):
+		archive := into + ArchiveSuffixZip
+		if err := saveFile(out.Body, archive); err != nil {
+			return "", err
+		}
+		return into, ExtractArchive(archive, subpath, into)
+	default:
+		archive := into + ArchiveSuffixTgz
+		if err := saveFile(out.Body, archive); err != nil {
+			return "", err
+		}
+		return into, ExtractArchive(archive, subpath, into)
+	}
+}
+
+func newS3Client(ctx context.Context, obj *S3Object, cred *Credentials) (*s3.Client, error) {
+	options := []func(*config.LoadOptions) error{}
+	// use default credentials chain(env,shared config...) when no credentials specified
+	if cred.AccessKey != "" {
+		options = append(options, config.WithCredentialsProvider(
+			credentials.StaticCredentialsProvider{
+				Value: aws.Credentials{AccessKeyID: cred.AccessKey, SecretAccessKey: cred.SecretKey},
+			},
+		))
+	}
+	if obj.Endpoint != "" {
+		options = append(options, config.WithEndpointResolverWithOptions(
+			aws.EndpointResolverWithOptionsFunc(
+				func(service, region string, options ...interface{}) (aws.Endpoint, error) {
+					return aws.Endpoint{URL: obj.Endpoint}, nil
+				},
+			),
+		))
+	}
+	if cred.InsecureSkipVerify {
+		options = append(options, config.WithHTTPClient(&http.Client{
+			Transport: &http.Transport{
+				Proxy:           http.ProxyFromEnvironment,
+				TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+			},
+		}))
+	}
+	cfg, err := config.LoadDefaultConfig(ctx, options...)
+	if err != nil {
+		return nil, err
+	}
+	return s3.NewFromConfig(cfg, func(o *s3.Options) {
+		if obj.Region != "" {
+			o.Region = obj.Region
+		} else if o.Region == "" {
+			o.Region = defaultS3Region
+		}
+		// minio requires path style
+		o.UsePathStyle = obj.Endpoint != ""
+	}), nil
+}
@@ -0,0 +1,314 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bundle
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/openpgp"
+	"helm.sh/helm/v3/pkg/provenance"
+	pluginsv1beta1 "kubegems.io/kubegems/pkg/apis/plugins/v1beta1"
+	"kubegems.io/kubegems/pkg/installer/bundle/helm"
+)
+
+const ProvenanceSuffix = ".prov"
+
+// VerificationError indicates the downloaded bundle failed on digest or signature verification.
+type VerificationError struct {
+	Bundle string
+	Err    error
+}
+
+func (e *VerificationError) Error() string {
+	return fmt.Sprintf("verify bundle %s: %v", e.Bundle, e.Err)
+}
+
+func (e *VerificationError) Unwrap() error {
+	return e.Err
+}
+
+func IsVerificationError(err error) bool {
+	verr := &VerificationError{}
+	return errors.As(err, &verr)
+}
+
+// NeedVerify returns true if the bundle has a digest or signature.
+func NeedVerify(bundle *pluginsv1beta1.Plugin) bool {
+	return bundle.Spec.Digest != "" || bundle.Spec.Signature != nil
+}
+
+// Verify verifies the archive of the downloaded bundle at bundlepath,
+// an extracted bundle is extracted again from the verified archive.
+func (b *BundleApplier) Verify(ctx context.Context, bundle *pluginsv1beta1.Plugin, bundlepath string) error {
+	if !NeedVerify(bundle) {
+		return nil
+	}
+	archive, ok := ArchiveOf(bundlepath)
+	if !ok {
+		return fmt.Errorf("no archive found of %s, only archive bundles can be verified", bundlepath)
+	}
+	if digest := bundle.Spec.Digest; digest != "" {
+		if err := VerifyDigest(archive, digest); err != nil {
+			return err
+		}
+	}
+	if signature := bundle.Spec.Signature; signature != nil {
+		switch signature.Kind {
+		case pluginsv1beta1.SignatureKindCosign:
+			if err := VerifyCosignSignature(archive, signature.Signature, signature.PublicKey); err != nil {
+				return err
+			}
+		case pluginsv1beta1.SignatureKindProvenance:
+			provfile, err := b.provenanceOf(ctx, bundle, archive)
+			if err != nil {
+				return err
+			}
+			if err := VerifyProvenance(archive, provfile, signature.PublicKey); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unknown signature kind: %s", signature.Kind)
+		}
+	}
+	if archive == bundlepath {
+		return nil
+	}
+	// the extracted files may be changed after extracted
+	return refreshExtracted(archive, bundle.Spec.Path, bundlepath)
+}
+
+// refreshExtracted extracts the archive into a temporary directory and compares it with the extracted bundle,
+// the extracted bundle is replaced only if it doesn't match.
+func refreshExtracted(archive, subpath, bundlepath string) error {
+	tmp, err := os.MkdirTemp(filepath.Dir(bundlepath), filepath.Base(bundlepath)+".verify-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+	if err := os.Chmod(tmp, defaultDirMode); err != nil {
+		return err
+	}
+	if err := ExtractArchive(archive, subpath, tmp); err != nil {
+		return err
+	}
+	expected, err := dirDigest(tmp)
+	if err != nil {
+		return err
+	}
+	if actual, err := dirDigest(bundlepath); err == nil && actual == expected {
+		return nil
+	}
+	// move the changed bundle away then rename the extracted one into place
+	old := tmp + ".old"
+	if err := os.Rename(bundlepath, old); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	defer os.RemoveAll(old)
+	return os.Rename(tmp, bundlepath)
+}
+
+// dirDigest returns the sha256 digest of the names,modes,contents and link targets in directory.
+func dirDigest(dir string) (string, error) {
+	h := sha256.New()
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil || rel == "." {
+			return err
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(h, "%s\x00%s\x00%d\x00", filepath.ToSlash(rel), fi.Mode(), fi.Size())
+		switch {
+		case fi.Mode()&fs.ModeSymlink != 0:
+			target, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			io.WriteString(h, target)
+		case fi.Mode().IsRegular():
+			f, err := os.Open(path)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			if _, err := io.Copy(h, f); err != nil {
+				return err
+			}
+		}
+		h.Write([]byte{0})
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func (b *BundleApplier) provenanceOf(ctx context.Context, bundle *pluginsv1beta1.Plugin, archive string) (string, error) {
+	provfile := archive + ProvenanceSuffix
+	if _, err := os.Stat(provfile); err == nil {
+		return provfile, nil
+	}
+	cred, err := b.credentials(ctx, bundle)
+	if err != nil {
+		return "", err
+	}
+	insecureSkipVerify := cred != nil && cred.InsecureSkipVerify
+	if provurl := bundle.Spec.Signature.Signature; provurl != "" {
+		return provfile, downloadFile(ctx, provurl, provfile, insecureSkipVerify)
+	}
+	name := bundle.Name
+	if chart := bundle.Spec.Chart; chart != "" {
+		name = chart
+	}
+	return provfile, helm.DownloadProvenance(ctx, bundle.Spec.URL, name, bundle.Spec.Version, provfile, insecureSkipVerify)
+}
+
+// removeFromCache removes the bundle and its archive,so it can be downloaded again.
+func (b *BundleApplier) removeFromCache(bundle *pluginsv1beta1.Plugin, bundlepath string) {
+	// never remove bundles out of cache directory, e.g. file://
+	if !strings.HasPrefix(bundlepath, PerRepoCacheDir(bundle.Spec.URL, b.Options.CacheDir)) {
+		return
+	}
+	archive, ok := ArchiveOf(bundlepath)
+	if ok {
+		os.Remove(archive)
+		os.Remove(archive + ProvenanceSuffix)
+	}
+	os.RemoveAll(bundlepath)
+}
+
+func downloadFile(ctx context.Context, uri, into string, insecureSkipVerify bool) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, uri, nil)
+	if err != nil {
+		return err
+	}
+	cli := http.DefaultClient
+	if insecureSkipVerify {
+		cli = &http.Client{
+			Transport: &http.Transport{
+				Proxy:           http.ProxyFromEnvironment,
+				TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+			},
+		}
+	}
+	resp, err := cli.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("download %s: %s", uri, resp.Status)
+	}
+	return saveFile(resp.Body, into)
+}
+
+// VerifyDigest checks the digest of file, digest is in format "sha256:{hex}" or "{hex}".
+func VerifyDigest(filename string, digest string) error {
+	algorithm, expected, ok := strings.Cut(digest, ":")
+	if !ok {
+		algorithm, expected = "sha256", digest
+	}
+	if algorithm != "sha256" {
+		return fmt.Errorf("unsupported digest algorithm: %s", algorithm)
+	}
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return err
+	}
+	if actual := hex.EncodeToString(h.Sum(nil)); !strings.EqualFold(actual, expected) {
+		return fmt.Errorf("digest mismatch, expected %s but got sha256:%s", digest, actual)
+	}
+	return nil
+}
+
+// VerifyCosignSignature verifies signature created by "cosign sign-blob --key" with the PEM encoded public key.
+func VerifyCosignSignature(filename string, signature string, publickey string) error {
+	block, _ := pem.Decode([]byte(publickey))
+	if block == nil {
+		return errors.New("invalid public key: no PEM data found")
+	}
+	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return fmt.Errorf("invalid public key: %w", err)
+	}
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(signature))
+	if err != nil {
+		return fmt.Errorf("invalid signature: %w", err)
+	}
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(content)
+	switch key := pub.(type) {
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(key, sum[:], sig) {
+			return errors.New("invalid signature")
+		}
+	case *rsa.PublicKey:
+		if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, sum[:], sig); err != nil {
+			return fmt.Errorf("invalid signature: %w", err)
+		}
+	case ed25519.PublicKey:
+		if !ed25519.Verify(key, content, sig) {
+			return errors.New("invalid signature")
+		}
+	default:
+		return fmt.Errorf("unsupported public key type %T", pub)
+	}
+	return nil
+}
+
+// VerifyProvenance verifies the chart archive with helm provenance file and armored keyring.
+func VerifyProvenance(chartpath, provfile string, keyring string) error {
+	ring, err := openpgp.ReadArmoredKeyRing(strings.NewReader(keyring))
+	if err != nil {
+		return fmt.Errorf("invalid keyring: %w", err)
+	}
+	signatory := &provenance.Signatory{KeyRing: ring}
+	if _, err := signatory.Verify(chartpath, provfile); err != nil {
+		return err
+	}
+	return nil
+}
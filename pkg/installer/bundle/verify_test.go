@@ -0,0 +1,140 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bundle
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestVerifyDigest(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "foo-1.0.0.tgz")
+	if err := os.WriteFile(filename, []byte("foo"), defaultFileMode); err != nil {
+		t.Fatal(err)
+	}
+	const sha256foo = "2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae"
+	tests := []struct {
+		digest  string
+		wantErr bool
+	}{
+		{digest: "sha256:" + sha256foo},
+		{digest: sha256foo},
+		{digest: "sha256:0000", wantErr: true},
+		{digest: "md5:acbd18db4cc2f85cedef654fccc4a4d8", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.digest, func(t *testing.T) {
+			if err := VerifyDigest(filename, tt.digest); (err != nil) != tt.wantErr {
+				t.Errorf("VerifyDigest() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestVerifyCosignSignature(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publickey := string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+
+	content := []byte("foo")
+	filename := filepath.Join(t.TempDir(), "foo-1.0.0.tgz")
+	if err := os.WriteFile(filename, content, defaultFileMode); err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(content)
+	sig, err := ecdsa.SignASN1(rand.Reader, key, sum[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyCosignSignature(filename, base64.StdEncoding.EncodeToString(sig), publickey); err != nil {
+		t.Errorf("VerifyCosignSignature() error = %v", err)
+	}
+	if err := os.WriteFile(filename, []byte("bar"), defaultFileMode); err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyCosignSignature(filename, base64.StdEncoding.EncodeToString(sig), publickey); err == nil {
+		t.Errorf("VerifyCosignSignature() expect error on modified file")
+	}
+}
+
+func TestRefreshExtracted(t *testing.T) {
+	dir := t.TempDir()
+	archive := filepath.Join(dir, "bundle.tgz")
+	bundlepath := filepath.Join(dir, "bundle")
+	if err := os.WriteFile(archive, testTarGz(t, map[string]string{
+		"Chart.yaml":            "name: bundle",
+		"templates/deploy.yaml": "kind: Deployment",
+	}), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	// not extracted yet
+	if err := refreshExtracted(archive, "", bundlepath); err != nil {
+		t.Fatal(err)
+	}
+	assertFileContent(t, filepath.Join(bundlepath, "Chart.yaml"), []byte("name: bundle"))
+
+	// kept if not changed
+	before, err := os.Stat(filepath.Join(bundlepath, "templates"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := refreshExtracted(archive, "", bundlepath); err != nil {
+		t.Fatal(err)
+	}
+	after, err := os.Stat(filepath.Join(bundlepath, "templates"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !os.SameFile(before, after) {
+		t.Error("unchanged bundle extracted again")
+	}
+
+	// replaced if changed
+	if err := os.WriteFile(filepath.Join(bundlepath, "templates", "deploy.yaml"), []byte("kind: Pod"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(bundlepath, "templates", "extra.yaml"), []byte("kind: Secret"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := refreshExtracted(archive, "", bundlepath); err != nil {
+		t.Fatal(err)
+	}
+	assertFileContent(t, filepath.Join(bundlepath, "templates", "deploy.yaml"), []byte("kind: Deployment"))
+	if _, err := os.Stat(filepath.Join(bundlepath, "templates", "extra.yaml")); !os.IsNotExist(err) {
+		t.Errorf("file added after extracted is kept: %v", err)
+	}
+	// no temporary directories left
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Errorf("entries in cache directory: %v", entries)
+	}
+}
@@ -18,12 +18,14 @@ import (
 	"context"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/go-logr/logr"
 	"helm.sh/helm/v3/pkg/strvals"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
@@ -33,6 +35,7 @@ import (
 	"kubegems.io/kubegems/pkg/apis/plugins"
 	pluginsv1beta1 "kubegems.io/kubegems/pkg/apis/plugins/v1beta1"
 	"kubegems.io/kubegems/pkg/installer/bundle"
+	"kubegems.io/kubegems/pkg/installer/utils"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller"
@@ -64,6 +67,10 @@ type Options struct {
 	MetricsAddr          string `json:"metricsAddr,omitempty" description:"The address the metric endpoint binds to."`
 	EnableLeaderElection bool   `json:"enableLeaderElection,omitempty" description:"Enable leader election for controller manager."`
 	ProbeAddr            string `json:"probeAddr,omitempty" description:"The address the probe endpoint binds to."`
+
+	MaxRevisions   int           `json:"maxRevisions,omitempty" description:"Max successful revisions kept for each plugin, 0 to disable revisions and rollback."`
+	AutoRollback   bool          `json:"autoRollback,omitempty" description:"Rollback to the last successful revision when upgrade failed, requires maxRevisions > 0."`
+	HealthyTimeout time.Duration `json:"healthyTimeout,omitempty" description:"Rollback when the plugin is not healthy in the duration after upgraded."`
 }
 
 func NewDefaultOptions() *Options {
@@ -71,6 +78,9 @@ func NewDefaultOptions() *Options {
 		MetricsAddr:          "127.0.0.1:9100", // default run under kube-rbac-proxy
 		EnableLeaderElection: false,
 		ProbeAddr:            ":8081", // depracated
+		MaxRevisions:         DefaultMaxRevisions,
+		AutoRollback:         false,
+		HealthyTimeout:       DefaultHealthyTimeout,
 	}
 }
 
@@ -88,9 +98,17 @@ func Run(ctx context.Context, options *Options, cachedir string) error {
 		return err
 	}
 
+	if options.AutoRollback && options.MaxRevisions <= 0 {
+		return fmt.Errorf("auto rollback requires max revisions greater than 0")
+	}
 	bundleoptions := bundle.NewDefaultOptions()
 	bundleoptions.CacheDir = cachedir
-	if err := Setup(ctx, mgr, bundleoptions); err != nil {
+	rollbackoptions := &RollbackOptions{
+		MaxRevisions:   options.MaxRevisions,
+		AutoRollback:   options.AutoRollback,
+		HealthyTimeout: options.HealthyTimeout,
+	}
+	if err := Setup(ctx, mgr, bundleoptions, rollbackoptions); err != nil {
 		setupLog.Error(err, "unable to create plugin controller", "controller", "plugin")
 		return err
 	}
@@ -112,10 +130,11 @@ func Run(ctx context.Context, options *Options, cachedir string) error {
 	return nil
 }
 
-func Setup(ctx context.Context, mgr ctrl.Manager, options *bundle.Options) error {
+func Setup(ctx context.Context, mgr ctrl.Manager, options *bundle.Options, rollbackoptions *RollbackOptions) error {
 	r := &Reconciler{
-		Client:  mgr.GetClient(),
-		Applier: bundle.NewDefaultApply(mgr.GetConfig(), mgr.GetClient(), options),
+		Client:   mgr.GetClient(),
+		Applier:  bundle.NewDefaultApply(mgr.GetConfig(), mgr.GetClient(), options),
+		Rollback: rollbackoptions,
 	}
 	handler := ConfigMapOrSecretTrigger(ctx, mgr.GetClient())
 	return ctrl.NewControllerManagedBy(mgr).
@@ -124,12 +143,22 @@ func Setup(ctx context.Context, mgr ctrl.Manager, options *bundle.Options) error
 		Watches(&source.Kind{Type: &corev1.ConfigMap{}}, handler).
 		Watches(&source.Kind{Type: &corev1.Secret{}}, handler).
 		Watches(&source.Kind{Type: &pluginsv1beta1.Plugin{}}, PluginUnhealthyTrigger(ctx, mgr.GetClient())).
+		Watches(&source.Kind{Type: &pluginsv1beta1.Plugin{}}, PluginDependentsTrigger(ctx, mgr.GetClient())).
 		Complete(r)
 }
 
+// Applier applies bundles,implemented by bundle.BundleApplier.
+type Applier interface {
+	Apply(ctx context.Context, bundle *pluginsv1beta1.Plugin) error
+	ApplyManifests(ctx context.Context, bundle *pluginsv1beta1.Plugin, rendered []byte, force bool) error
+	Render(ctx context.Context, bundle *pluginsv1beta1.Plugin) ([]byte, error)
+	Remove(ctx context.Context, bundle *pluginsv1beta1.Plugin) error
+}
+
 type Reconciler struct {
 	client.Client
-	Applier *bundle.BundleApplier
+	Applier  Applier
+	Rollback *RollbackOptions
 }
 
 func (r *Reconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
@@ -173,14 +202,42 @@ func (r *Reconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Resu
 		plugin.Status.Phase = pluginsv1beta1.PhaseFailed
 		plugin.Status.Message = err.Error()
 	}
+	setVerifiedCondition(plugin, err)
 
 	// update status if updated whenever the sync has error or no
 	if err := r.Status().Update(ctx, plugin); err != nil {
 		return ctrl.Result{}, err
 	}
+	if err == nil && waitingHealthy(plugin) {
+		// check healthy again later
+		return ctrl.Result{RequeueAfter: healthCheckInterval}, nil
+	}
 	return ctrl.Result{}, err
 }
 
+// setVerifiedCondition records the verification result of the bundle
+func setVerifiedCondition(plugin *pluginsv1beta1.Plugin, err error) {
+	switch {
+	case bundle.IsVerificationError(err):
+		meta.SetStatusCondition(&plugin.Status.Conditions, metav1.Condition{
+			Type:               pluginsv1beta1.ConditionTypeVerified,
+			Status:             metav1.ConditionFalse,
+			ObservedGeneration: plugin.Generation,
+			Reason:             pluginsv1beta1.ConditionReasonVerificationFailed,
+			Message:            err.Error(),
+		})
+	case err == nil && bundle.NeedVerify(plugin) && !plugin.Spec.Disabled && plugin.DeletionTimestamp == nil:
+		meta.SetStatusCondition(&plugin.Status.Conditions, metav1.Condition{
+			Type:               pluginsv1beta1.ConditionTypeVerified,
+			Status:             metav1.ConditionTrue,
+			ObservedGeneration: plugin.Generation,
+			Reason:             pluginsv1beta1.ConditionReasonVerified,
+		})
+	case !bundle.NeedVerify(plugin):
+		meta.RemoveStatusCondition(&plugin.Status.Conditions, pluginsv1beta1.ConditionTypeVerified)
+	}
+}
+
 func PluginUnhealthyTrigger(ctx context.Context, cli client.Client) handler.EventHandler {
 	log := logr.FromContextOrDiscard(ctx)
 	return handler.EnqueueRequestsFromMapFunc(func(obj client.Object) []reconcile.Request {
@@ -252,17 +309,58 @@ func (r *Reconciler) Sync(ctx context.Context, bundle *pluginsv1beta1.Plugin) er
 		if err := r.checkDepenency(ctx, bundle); err != nil {
 			return err
 		}
+		// the spec before values resolved is recorded in revision
+		spec := bundle.Spec.DeepCopy()
 		// resolve valuesRef
 		if err := r.resolveValuesRef(ctx, bundle); err != nil {
 			return err
 		}
-		if err := r.Applier.Apply(ctx, bundle); err != nil {
+		// the revision requested to rollback to is applied with its values
+		rev, err := r.requestedRevision(ctx, bundle, spec)
+		if err != nil {
+			return err
+		}
+		if rev != nil {
+			bundle.Spec.Values = pluginsv1beta1.Values{Object: rev.Values}
+		}
+		// hold changes until approved,the requested rollback is approved by the requester
+		plan, pending := checkApproval(bundle)
+		if pending && rev == nil {
+			bundle.Status.Phase = pluginsv1beta1.PhasePendingApproval
+			bundle.Status.Message = fmt.Sprintf("plan %s is waiting for approval", plan)
+			return nil
+		}
+		if err := r.apply(ctx, bundle, spec, rev); err != nil {
 			return err
 		}
+		if plan != "" && plan != bundle.Status.RolledBackPlan {
+			bundle.Status.AppliedPlan = plan
+		}
 		return r.checkResourcesStatus(ctx, bundle)
 	}
 }
 
+// checkApproval returns the plan hash and whether the plan is waiting for approval,
+// an empty plan is returned if approval is not required.
+func checkApproval(plugin *pluginsv1beta1.Plugin) (string, bool) {
+	if !bundle.RequireApproval(plugin) {
+		return "", false
+	}
+	plan := bundle.PlanHash(plugin)
+	switch {
+	case plugin.Status.AppliedPlan == plan:
+		return plan, false
+	case plugin.Status.AppliedPlan == "" &&
+		plugin.Status.Phase == pluginsv1beta1.PhaseInstalled &&
+		plugin.Status.Version == plugin.Spec.Version &&
+		utils.EqualMapValues(plugin.Status.Values.Object, plugin.Spec.Values.Object):
+		// approval required after installed, take the installed as approved
+		return plan, false
+	default:
+		return plan, plugin.Annotations[plugins.AnnotationApprovedPlan] != plan
+	}
+}
+
 // ResourcesStatus fill resource status
 func (r *Reconciler) checkResourcesStatus(ctx context.Context, bundle *pluginsv1beta1.Plugin) error {
 	for i, res := range bundle.Status.Resources {
@@ -302,6 +400,9 @@ func (e DependencyError) Error() string {
 }
 
 func (r *Reconciler) checkDepenency(ctx context.Context, bundle *pluginsv1beta1.Plugin) error {
+	if err := r.checkCycle(ctx, bundle); err != nil {
+		return err
+	}
 	for _, dep := range bundle.Spec.Dependencies {
 		if dep.Name == "" {
 			continue
@@ -335,22 +436,33 @@ func (r *Reconciler) checkDepenency(ctx context.Context, bundle *pluginsv1beta1.
 		// status check
 		switch obj := depobj.(type) {
 		case *pluginsv1beta1.Plugin:
-			if obj.Status.Phase != pluginsv1beta1.PhaseInstalled {
+			if !isInstalled(obj) {
 				return DependencyError{Reason: "not installed", Object: dep}
 			}
 		}
 	}
-	return nil
+	return r.checkRequirements(ctx, bundle)
+}
+
+func isInstalled(plugin *pluginsv1beta1.Plugin) bool {
+	// a plugin pending approval keeps the previous installed version
+	pendingInstalled := plugin.Status.Phase == pluginsv1beta1.PhasePendingApproval && plugin.Status.AppliedPlan != ""
+	return plugin.Status.Phase == pluginsv1beta1.PhaseInstalled || pendingInstalled
 }
 
 func (r *Reconciler) resolveValuesRef(ctx context.Context, bundle *pluginsv1beta1.Plugin) error {
+	return ResolveValuesRef(ctx, r.Client, bundle)
+}
+
+// ResolveValuesRef merges values from referenced configmaps and secrets with inlined values into spec.values.
+func ResolveValuesRef(ctx context.Context, cli client.Client, bundle *pluginsv1beta1.Plugin) error {
 	base := map[string]interface{}{}
 
 	for _, ref := range bundle.Spec.ValuesFrom {
 		switch strings.ToLower(ref.Kind) {
 		case "secret", "secrets":
 			secret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: ref.Name, Namespace: bundle.Namespace}}
-			if err := r.Client.Get(ctx, client.ObjectKeyFromObject(secret), secret); err != nil {
+			if err := cli.Get(ctx, client.ObjectKeyFromObject(secret), secret); err != nil {
 				if ref.Optional && apierrors.IsNotFound(err) {
 					continue
 				}
@@ -364,7 +476,7 @@ func (r *Reconciler) resolveValuesRef(ctx context.Context, bundle *pluginsv1beta
 			}
 		case "configmap", "configmaps":
 			configmap := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: ref.Name, Namespace: bundle.Namespace}}
-			if err := r.Client.Get(ctx, client.ObjectKeyFromObject(configmap), configmap); err != nil {
+			if err := cli.Get(ctx, client.ObjectKeyFromObject(configmap), configmap); err != nil {
 				if ref.Optional && apierrors.IsNotFound(err) {
 					continue
 				}
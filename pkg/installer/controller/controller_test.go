@@ -18,7 +18,11 @@ import (
 	"reflect"
 	"testing"
 
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
+	"kubegems.io/kubegems/pkg/apis/plugins"
+	pluginsv1beta1 "kubegems.io/kubegems/pkg/apis/plugins/v1beta1"
+	"kubegems.io/kubegems/pkg/installer/bundle"
 )
 
 func Test_mergeMaps(t *testing.T) {
@@ -54,3 +58,108 @@ func Test_mergeMaps(t *testing.T) {
 		})
 	}
 }
+
+func Test_checkApproval(t *testing.T) {
+	newPlugin := func(requireApproval bool) *pluginsv1beta1.Plugin {
+		plugin := &pluginsv1beta1.Plugin{
+			ObjectMeta: metav1.ObjectMeta{Name: "nginx", Namespace: "kubegems-installer", Annotations: map[string]string{}},
+			Spec: pluginsv1beta1.PluginSpec{
+				Kind:    pluginsv1beta1.BundleKindHelm,
+				URL:     "https://charts.example.com",
+				Version: "1.0.1",
+				Values:  pluginsv1beta1.Values{Object: map[string]any{"replicas": int64(2)}},
+			},
+			Status: pluginsv1beta1.PluginStatus{
+				Phase:   pluginsv1beta1.PhaseInstalled,
+				Version: "1.0.0",
+				Values:  pluginsv1beta1.Values{Object: map[string]any{"replicas": int64(1)}},
+			},
+		}
+		if requireApproval {
+			plugin.Annotations[plugins.AnnotationRequireApproval] = "true"
+		}
+		return plugin
+	}
+	tests := []struct {
+		name        string
+		plugin      func() *pluginsv1beta1.Plugin
+		wantPlan    bool
+		wantPending bool
+	}{
+		{
+			name:   "approval not required",
+			plugin: func() *pluginsv1beta1.Plugin { return newPlugin(false) },
+		},
+		{
+			name:        "waiting for approval",
+			plugin:      func() *pluginsv1beta1.Plugin { return newPlugin(true) },
+			wantPlan:    true,
+			wantPending: true,
+		},
+		{
+			name: "approved",
+			plugin: func() *pluginsv1beta1.Plugin {
+				plugin := newPlugin(true)
+				plugin.Annotations[plugins.AnnotationApprovedPlan] = bundle.PlanHash(plugin)
+				return plugin
+			},
+			wantPlan: true,
+		},
+		{
+			name: "approved an outdated plan",
+			plugin: func() *pluginsv1beta1.Plugin {
+				plugin := newPlugin(true)
+				plugin.Annotations[plugins.AnnotationApprovedPlan] = bundle.PlanHash(plugin)
+				plugin.Spec.Version = "1.0.2"
+				return plugin
+			},
+			wantPlan:    true,
+			wantPending: true,
+		},
+		{
+			name: "plan applied",
+			plugin: func() *pluginsv1beta1.Plugin {
+				plugin := newPlugin(true)
+				plugin.Status.AppliedPlan = bundle.PlanHash(plugin)
+				return plugin
+			},
+			wantPlan: true,
+		},
+		{
+			name: "installed before approval required",
+			plugin: func() *pluginsv1beta1.Plugin {
+				plugin := newPlugin(true)
+				plugin.Status.Version, plugin.Status.Values = plugin.Spec.Version, plugin.Spec.Values
+				return plugin
+			},
+			wantPlan: true,
+		},
+		{
+			name: "failed before approval required",
+			plugin: func() *pluginsv1beta1.Plugin {
+				plugin := newPlugin(true)
+				plugin.Status.Version, plugin.Status.Values = plugin.Spec.Version, plugin.Spec.Values
+				plugin.Status.Phase = pluginsv1beta1.PhaseFailed
+				return plugin
+			},
+			wantPlan:    true,
+			wantPending: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plugin := tt.plugin()
+			plan, pending := checkApproval(plugin)
+			if pending != tt.wantPending {
+				t.Errorf("checkApproval() pending = %v, want %v", pending, tt.wantPending)
+			}
+			wantPlan := ""
+			if tt.wantPlan {
+				wantPlan = bundle.PlanHash(plugin)
+			}
+			if plan != wantPlan {
+				t.Errorf("checkApproval() plan = %v, want %v", plan, wantPlan)
+			}
+		})
+	}
+}
@@ -0,0 +1,139 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	pluginsv1beta1 "kubegems.io/kubegems/pkg/apis/plugins/v1beta1"
+	"kubegems.io/kubegems/pkg/installer/utils"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// checkRequirements checks required plugins are installed with matched versions.
+func (r *Reconciler) checkRequirements(ctx context.Context, bundle *pluginsv1beta1.Plugin) error {
+	for _, req := range bundle.Spec.Requirements {
+		ref := corev1.ObjectReference{
+			APIVersion: pluginsv1beta1.SchemeGroupVersion.String(),
+			Kind:       "Plugin",
+			Namespace:  bundle.Namespace,
+			Name:       req.Name,
+		}
+		required := &pluginsv1beta1.Plugin{}
+		if err := r.Client.Get(ctx, client.ObjectKey{Namespace: bundle.Namespace, Name: req.Name}, required); err != nil {
+			if apierrors.IsNotFound(err) {
+				return DependencyError{Reason: err.Error(), Object: ref}
+			}
+			return err
+		}
+		if !isInstalled(required) {
+			return DependencyError{Reason: "not installed", Object: ref}
+		}
+		if req.Version == "" {
+			continue
+		}
+		constraint, err := semver.NewConstraint(req.Version)
+		if err != nil {
+			return DependencyError{Reason: fmt.Sprintf("invalid version requirement %s: %v", req.Version, err), Object: ref}
+		}
+		version, err := semver.NewVersion(required.Status.Version)
+		if err != nil {
+			// we cant check version so adopt any.
+			continue
+		}
+		if !constraint.Check(version) {
+			return DependencyError{Reason: fmt.Sprintf("version %s installed, but %s required", required.Status.Version, req.Version), Object: ref}
+		}
+	}
+	return nil
+}
+
+// checkCycle checks the bundle is not in a dependency cycle, a cycle never be installed.
+func (r *Reconciler) checkCycle(ctx context.Context, bundle *pluginsv1beta1.Plugin) error {
+	if len(bundle.Spec.Dependencies) == 0 && len(bundle.Spec.Requirements) == 0 {
+		return nil
+	}
+	list := &pluginsv1beta1.PluginList{}
+	if err := r.Client.List(ctx, list, client.InNamespace(bundle.Namespace)); err != nil {
+		return err
+	}
+	edges := map[string][]string{}
+	for i := range list.Items {
+		edges[list.Items[i].Name] = dependencyNames(&list.Items[i])
+	}
+	edges[bundle.Name] = dependencyNames(bundle)
+	if _, err := utils.TopologicalSort(edges, bundle.Name); err != nil {
+		cycle := &utils.CycleError{}
+		if errors.As(err, &cycle) {
+			return DependencyError{Reason: err.Error(), Object: corev1.ObjectReference{Namespace: bundle.Namespace, Name: bundle.Name}}
+		}
+		return err
+	}
+	return nil
+}
+
+// dependencyNames returns names of plugins in the same namespace the plugin depends on.
+func dependencyNames(plugin *pluginsv1beta1.Plugin) []string {
+	names := []string{}
+	for _, dep := range plugin.Spec.Dependencies {
+		if dep.Name == "" || (dep.Namespace != "" && dep.Namespace != plugin.Namespace) {
+			continue
+		}
+		if dep.Kind != "" && dep.Kind != "Plugin" {
+			continue
+		}
+		names = append(names, dep.Name)
+	}
+	for _, req := range plugin.Spec.Requirements {
+		names = append(names, req.Name)
+	}
+	return names
+}
+
+// PluginDependentsTrigger triggers plugins waiting for the installed plugin,
+// so plugins are installed and upgraded in topological order.
+func PluginDependentsTrigger(ctx context.Context, cli client.Client) handler.EventHandler {
+	log := logr.FromContextOrDiscard(ctx)
+	return handler.EnqueueRequestsFromMapFunc(func(obj client.Object) []reconcile.Request {
+		plugin, ok := obj.(*pluginsv1beta1.Plugin)
+		if !ok || !isInstalled(plugin) {
+			return nil
+		}
+		plugins := pluginsv1beta1.PluginList{}
+		_ = cli.List(ctx, &plugins, client.InNamespace(plugin.Namespace))
+		var requests []reconcile.Request
+		for _, item := range plugins.Items {
+			if item.Status.Phase == pluginsv1beta1.PhaseInstalled {
+				continue
+			}
+			for _, name := range dependencyNames(&item) {
+				if name == plugin.Name {
+					log.Info("triggering reconciliation", "plugin", item.Name, "dependency", plugin.Name, "namespace", item.Namespace)
+					requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&item)})
+					break
+				}
+			}
+		}
+		return requests
+	})
+}
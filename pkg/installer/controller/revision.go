@@ -0,0 +1,351 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"kubegems.io/kubegems/pkg/apis/plugins"
+	pluginsv1beta1 "kubegems.io/kubegems/pkg/apis/plugins/v1beta1"
+	"kubegems.io/kubegems/pkg/installer/bundle"
+	"kubegems.io/kubegems/pkg/installer/utils"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+const (
+	DefaultMaxRevisions   = 5
+	DefaultHealthyTimeout = 5 * time.Minute
+	healthCheckInterval   = 15 * time.Second
+
+	LabelRevisionOf = "plugins.kubegems.io/revision-of"
+
+	// revisions contain values and manifests resolved from secrets,so they are stored in secrets too
+	RevisionSecretType corev1.SecretType = "plugins.kubegems.io/revision"
+
+	revisionKeyRevision  = "revision"
+	revisionKeySpec      = "spec"
+	revisionKeyValues    = "values"
+	revisionKeyManifests = "manifests.gz"
+)
+
+type RollbackOptions struct {
+	MaxRevisions   int           // max successful revisions kept, 0 to disable revisions and rollback
+	AutoRollback   bool          // rollback on apply failed or not healthy in HealthyTimeout
+	HealthyTimeout time.Duration // duration to wait for healthy after upgraded
+}
+
+func (o *RollbackOptions) enabled() bool {
+	return o != nil && o.MaxRevisions > 0
+}
+
+// Revision is a successful applied revision of plugin.
+type Revision struct {
+	pluginsv1beta1.PluginRevision `json:",inline"`
+	Spec                          pluginsv1beta1.PluginSpec `json:"spec"`             // spec before values resolved
+	Values                        map[string]any            `json:"values,omitempty"` // resolved values
+	Manifests                     string                    `json:"manifests,omitempty"`
+}
+
+func RevisionName(name string, revision int64) string {
+	return fmt.Sprintf("%s-revision-%d", name, revision)
+}
+
+// GetRevision gets the revision of plugin from secret.
+func GetRevision(ctx context.Context, cli client.Client, plugin *pluginsv1beta1.Plugin, revision int64) (*Revision, error) {
+	secret := &corev1.Secret{}
+	if err := cli.Get(ctx, client.ObjectKey{Namespace: plugin.Namespace, Name: RevisionName(plugin.Name, revision)}, secret); err != nil {
+		return nil, err
+	}
+	if secret.Type != RevisionSecretType {
+		return nil, fmt.Errorf("invalid revision %s: secret type %s", secret.Name, secret.Type)
+	}
+	rev := &Revision{}
+	if err := json.Unmarshal(secret.Data[revisionKeyRevision], &rev.PluginRevision); err != nil {
+		return nil, fmt.Errorf("invalid revision %s: %w", secret.Name, err)
+	}
+	if err := json.Unmarshal(secret.Data[revisionKeySpec], &rev.Spec); err != nil {
+		return nil, fmt.Errorf("invalid spec of revision %s: %w", secret.Name, err)
+	}
+	if values := secret.Data[revisionKeyValues]; len(values) > 0 {
+		if err := json.Unmarshal(values, &rev.Values); err != nil {
+			return nil, fmt.Errorf("invalid values of revision %s: %w", secret.Name, err)
+		}
+	}
+	if content := secret.Data[revisionKeyManifests]; len(content) > 0 {
+		manifests, err := gunzip(content)
+		if err != nil {
+			return nil, fmt.Errorf("invalid manifests of revision %s: %w", secret.Name, err)
+		}
+		rev.Manifests = string(manifests)
+	}
+	return rev, nil
+}
+
+func lastRevision(plugin *pluginsv1beta1.Plugin) *pluginsv1beta1.PluginRevision {
+	if len(plugin.Status.Revisions) == 0 {
+		return nil
+	}
+	return &plugin.Status.Revisions[len(plugin.Status.Revisions)-1]
+}
+
+// requestedRevision returns the revision requested to rollback to,
+// nil if no rollback requested or the spec changed after the request.
+func (r *Reconciler) requestedRevision(ctx context.Context, plugin *pluginsv1beta1.Plugin, spec *pluginsv1beta1.PluginSpec) (*Revision, error) {
+	val, ok := plugin.Annotations[plugins.AnnotationRollbackRevision]
+	if !ok || !r.Rollback.enabled() {
+		return nil, nil
+	}
+	revision, err := strconv.ParseInt(val, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid rollback revision %s: %w", val, err)
+	}
+	rev, err := GetRevision(ctx, r.Client, plugin, revision)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			logr.FromContextOrDiscard(ctx).Info("revision to rollback not found", "revision", revision)
+			return nil, nil
+		}
+		return nil, err
+	}
+	if !equalSpec(&rev.Spec, spec) {
+		return nil, nil
+	}
+	return rev, nil
+}
+
+func equalSpec(a, b *pluginsv1beta1.PluginSpec) bool {
+	ja, erra := json.Marshal(a)
+	jb, errb := json.Marshal(b)
+	return erra == nil && errb == nil && bytes.Equal(ja, jb)
+}
+
+// apply applies the rendered manifests of bundle, records them as a revision once healthy,
+// and rollback to the last revision if apply failed or not healthy in time.
+// rev is the revision requested to rollback to, its stored manifests are applied instead of rendering.
+func (r *Reconciler) apply(ctx context.Context, plugin *pluginsv1beta1.Plugin, spec *pluginsv1beta1.PluginSpec, rev *Revision) error {
+	if !r.Rollback.enabled() {
+		return r.Applier.Apply(ctx, plugin)
+	}
+	plan := bundle.PlanHash(plugin)
+	if rev != nil {
+		plan = rev.Plan
+	}
+	if plan == plugin.Status.RolledBackPlan {
+		// keep the rolled back revision until the bundle changed
+		return nil
+	}
+	if rev == nil {
+		// the manifests applied are exactly the manifests recorded
+		rendered, err := r.Applier.Render(ctx, plugin)
+		if err != nil {
+			return r.applyFailed(ctx, plugin, plan, err)
+		}
+		rev = &Revision{Spec: *spec, Values: plugin.Spec.Values.Object, Manifests: string(rendered)}
+	}
+	if err := r.Applier.ApplyManifests(ctx, plugin, []byte(rev.Manifests), false); err != nil {
+		return r.applyFailed(ctx, plugin, plan, err)
+	}
+	if plugin.Status.RolledBackPlan != "" {
+		plugin.Status.RolledBackPlan = ""
+		meta.RemoveStatusCondition(&plugin.Status.Conditions, pluginsv1beta1.ConditionTypeRolledBack)
+	}
+	if last := lastRevision(plugin); last != nil && last.Plan == plan {
+		return nil
+	}
+	healthcheck := plugin.Annotations[plugins.AnnotationHealthCheck]
+	if err := utils.CheckHealth(ctx, r.Client, plugin.Status.Namespace, healthcheck); err != nil {
+		if time.Since(plugin.Status.UpgradeTimestamp.Time) < r.Rollback.HealthyTimeout {
+			setHealthyCondition(plugin, metav1.ConditionFalse, pluginsv1beta1.ConditionReasonProgressing, err.Error())
+			return nil
+		}
+		setHealthyCondition(plugin, metav1.ConditionFalse, pluginsv1beta1.ConditionReasonUnhealthy, err.Error())
+		if !r.Rollback.AutoRollback || lastRevision(plugin) == nil {
+			return nil
+		}
+		return r.rollback(ctx, plugin, plan, pluginsv1beta1.ConditionReasonUnhealthy, err)
+	}
+	setHealthyCondition(plugin, metav1.ConditionTrue, pluginsv1beta1.ConditionReasonHealthy, "")
+	return r.recordRevision(ctx, plugin, rev, plan)
+}
+
+func (r *Reconciler) applyFailed(ctx context.Context, plugin *pluginsv1beta1.Plugin, plan string, err error) error {
+	// nothing applied if the bundle not verified
+	if !r.Rollback.AutoRollback || bundle.IsVerificationError(err) {
+		return err
+	}
+	return r.rollback(ctx, plugin, plan, pluginsv1beta1.ConditionReasonApplyFailed, err)
+}
+
+// rollback applies the last revision and marks the plan as rolled back,
+// the plugin keeps installed with the RolledBack condition until the bundle changed.
+func (r *Reconciler) rollback(ctx context.Context, plugin *pluginsv1beta1.Plugin, plan string, reason string, cause error) error {
+	log := logr.FromContextOrDiscard(ctx)
+	last := lastRevision(plugin)
+	if last == nil {
+		return cause
+	}
+	rev, err := GetRevision(ctx, r.Client, plugin, last.Revision)
+	if err != nil {
+		return fmt.Errorf("%v, get revision %d to rollback: %v", cause, last.Revision, err)
+	}
+	log.Info("rolling back", "revision", rev.Revision, "version", rev.Version, "reason", reason)
+
+	target := plugin.DeepCopy()
+	target.Spec = rev.Spec
+	target.Spec.Values = pluginsv1beta1.Values{Object: rev.Values}
+	if err := r.Applier.ApplyManifests(ctx, target, []byte(rev.Manifests), true); err != nil {
+		return fmt.Errorf("%v, rollback to revision %d: %v", cause, rev.Revision, err)
+	}
+	plugin.Status = target.Status
+	plugin.Status.RolledBackPlan = plan
+	if plugin.Status.AppliedPlan != "" {
+		plugin.Status.AppliedPlan = rev.Plan
+	}
+	// the healthy condition is of the rolled back plan
+	meta.RemoveStatusCondition(&plugin.Status.Conditions, pluginsv1beta1.ConditionTypeHealthy)
+	meta.SetStatusCondition(&plugin.Status.Conditions, metav1.Condition{
+		Type:               pluginsv1beta1.ConditionTypeRolledBack,
+		Status:             metav1.ConditionTrue,
+		ObservedGeneration: plugin.Generation,
+		Reason:             reason,
+		Message:            fmt.Sprintf("%v, rolled back to revision %d(version %s)", cause, rev.Revision, rev.Version),
+	})
+	return nil
+}
+
+// recordRevision saves the applied manifests as a new revision and removes the outdated.
+func (r *Reconciler) recordRevision(ctx context.Context, plugin *pluginsv1beta1.Plugin, applied *Revision, plan string) error {
+	next := int64(1)
+	if last := lastRevision(plugin); last != nil {
+		next = last.Revision + 1
+	}
+	rev := &Revision{
+		PluginRevision: pluginsv1beta1.PluginRevision{
+			Revision:  next,
+			Version:   plugin.Spec.Version,
+			Plan:      plan,
+			Timestamp: metav1.Now(),
+		},
+		Spec:      applied.Spec,
+		Values:    applied.Values,
+		Manifests: applied.Manifests,
+	}
+	if err := r.saveRevision(ctx, plugin, rev); err != nil {
+		return fmt.Errorf("save revision: %w", err)
+	}
+	revisions := append(plugin.Status.Revisions, rev.PluginRevision)
+	if max := r.Rollback.MaxRevisions; len(revisions) > max {
+		for _, outdated := range revisions[:len(revisions)-max] {
+			secret := &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: RevisionName(plugin.Name, outdated.Revision), Namespace: plugin.Namespace},
+			}
+			if err := r.Client.Delete(ctx, secret); client.IgnoreNotFound(err) != nil {
+				return fmt.Errorf("remove revision: %w", err)
+			}
+		}
+		revisions = revisions[len(revisions)-max:]
+	}
+	plugin.Status.Revisions = revisions
+	return nil
+}
+
+func (r *Reconciler) saveRevision(ctx context.Context, plugin *pluginsv1beta1.Plugin, rev *Revision) error {
+	revision, err := json.Marshal(rev.PluginRevision)
+	if err != nil {
+		return err
+	}
+	spec, err := json.Marshal(rev.Spec)
+	if err != nil {
+		return err
+	}
+	values, err := json.Marshal(rev.Values)
+	if err != nil {
+		return err
+	}
+	// rendered manifests may be large,e.g. CRDs
+	manifests, err := gzipBytes([]byte(rev.Manifests))
+	if err != nil {
+		return err
+	}
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: RevisionName(plugin.Name, rev.Revision), Namespace: plugin.Namespace},
+		Type:       RevisionSecretType,
+	}
+	_, err = controllerutil.CreateOrUpdate(ctx, r.Client, secret, func() error {
+		if secret.Labels == nil {
+			secret.Labels = map[string]string{}
+		}
+		secret.Labels[LabelRevisionOf] = plugin.Name
+		secret.Data = map[string][]byte{
+			revisionKeyRevision:  revision,
+			revisionKeySpec:      spec,
+			revisionKeyValues:    values,
+			revisionKeyManifests: manifests,
+		}
+		// removed along with the plugin
+		return controllerutil.SetControllerReference(plugin, secret, r.Scheme())
+	})
+	return err
+}
+
+func setHealthyCondition(plugin *pluginsv1beta1.Plugin, status metav1.ConditionStatus, reason, message string) {
+	meta.SetStatusCondition(&plugin.Status.Conditions, metav1.Condition{
+		Type:               pluginsv1beta1.ConditionTypeHealthy,
+		Status:             status,
+		ObservedGeneration: plugin.Generation,
+		Reason:             reason,
+		Message:            message,
+	})
+}
+
+// waitingHealthy returns true if the applied bundle is not healthy yet.
+func waitingHealthy(plugin *pluginsv1beta1.Plugin) bool {
+	return plugin.Status.Phase == pluginsv1beta1.PhaseInstalled &&
+		meta.IsStatusConditionFalse(plugin.Status.Conditions, pluginsv1beta1.ConditionTypeHealthy)
+}
+
+func gzipBytes(content []byte) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	w := gzip.NewWriter(buf)
+	if _, err := w.Write(content); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func gunzip(content []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(content))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
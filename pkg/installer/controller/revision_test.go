@@ -0,0 +1,316 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"kubegems.io/kubegems/pkg/apis/plugins"
+	pluginsv1beta1 "kubegems.io/kubegems/pkg/apis/plugins/v1beta1"
+	"kubegems.io/kubegems/pkg/installer/bundle"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// fakeApplier renders the version of bundle as manifests and records the applied manifests.
+type fakeApplier struct {
+	failVersion string
+	applied     []string
+	forced      []bool
+}
+
+func (f *fakeApplier) Render(ctx context.Context, bundle *pluginsv1beta1.Plugin) ([]byte, error) {
+	return []byte("version: " + bundle.Spec.Version), nil
+}
+
+func (f *fakeApplier) Apply(ctx context.Context, bundle *pluginsv1beta1.Plugin) error {
+	return errors.New("bundle must be applied from manifests")
+}
+
+func (f *fakeApplier) ApplyManifests(ctx context.Context, bundle *pluginsv1beta1.Plugin, rendered []byte, force bool) error {
+	f.applied = append(f.applied, string(rendered))
+	f.forced = append(f.forced, force)
+	if bundle.Spec.Version == f.failVersion {
+		return fmt.Errorf("apply %s failed", bundle.Spec.Version)
+	}
+	bundle.Status.Phase = pluginsv1beta1.PhaseInstalled
+	bundle.Status.Version = bundle.Spec.Version
+	bundle.Status.Namespace = bundle.Namespace
+	bundle.Status.UpgradeTimestamp = metav1.Now()
+	return nil
+}
+
+func (f *fakeApplier) Remove(ctx context.Context, bundle *pluginsv1beta1.Plugin) error {
+	bundle.Status.Phase = pluginsv1beta1.PhaseDisabled
+	return nil
+}
+
+func (f *fakeApplier) lastApplied() string {
+	if len(f.applied) == 0 {
+		return ""
+	}
+	return f.applied[len(f.applied)-1]
+}
+
+func newTestReconciler(t *testing.T, rollback *RollbackOptions, objs ...client.Object) (*Reconciler, *fakeApplier) {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := pluginsv1beta1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	applier := &fakeApplier{}
+	cli := fake.NewClientBuilder().WithScheme(scheme).WithObjects(objs...).Build()
+	return &Reconciler{Client: cli, Applier: applier, Rollback: rollback}, applier
+}
+
+func newTestPlugin(version string) *pluginsv1beta1.Plugin {
+	return &pluginsv1beta1.Plugin{
+		ObjectMeta: metav1.ObjectMeta{Name: "foo", Namespace: "default", UID: "foo-uid"},
+		Spec: pluginsv1beta1.PluginSpec{
+			Kind:    pluginsv1beta1.BundleKindTemplate,
+			Version: version,
+			Values:  pluginsv1beta1.Values{Object: map[string]any{"version": version}},
+		},
+	}
+}
+
+// upgrade changes the version of plugin and applies it.
+func upgrade(ctx context.Context, r *Reconciler, plugin *pluginsv1beta1.Plugin, version string) error {
+	plugin.Spec.Version = version
+	plugin.Spec.Values = pluginsv1beta1.Values{Object: map[string]any{"version": version}}
+	return r.apply(ctx, plugin, plugin.Spec.DeepCopy(), nil)
+}
+
+func revisionNumbers(plugin *pluginsv1beta1.Plugin) []int64 {
+	var numbers []int64
+	for _, rev := range plugin.Status.Revisions {
+		numbers = append(numbers, rev.Revision)
+	}
+	return numbers
+}
+
+func TestReconciler_apply(t *testing.T) {
+	ctx := context.Background()
+	r, applier := newTestReconciler(t, &RollbackOptions{MaxRevisions: 5, AutoRollback: true})
+	plugin := newTestPlugin("1.0.0")
+
+	if err := upgrade(ctx, r, plugin, "1.0.0"); err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	if got := revisionNumbers(plugin); len(got) != 1 || got[0] != 1 {
+		t.Fatalf("revisions = %v, want [1]", got)
+	}
+	rev, err := GetRevision(ctx, r.Client, plugin, 1)
+	if err != nil {
+		t.Fatalf("get revision: %v", err)
+	}
+	// the recorded manifests are the applied
+	if rev.Manifests != applier.lastApplied() || rev.Version != "1.0.0" || rev.Plan != bundle.PlanHash(plugin) {
+		t.Errorf("unexpected revision %+v", rev)
+	}
+	if !meta.IsStatusConditionTrue(plugin.Status.Conditions, pluginsv1beta1.ConditionTypeHealthy) {
+		t.Errorf("plugin should be healthy")
+	}
+
+	// reapply the same plan records no revision
+	if err := upgrade(ctx, r, plugin, "1.0.0"); err != nil {
+		t.Fatalf("reapply: %v", err)
+	}
+	if got := revisionNumbers(plugin); len(got) != 1 {
+		t.Errorf("revisions = %v, want [1]", got)
+	}
+
+	// upgrade failed and rolled back
+	applier.failVersion = "2.0.0"
+	if err := upgrade(ctx, r, plugin, "2.0.0"); err != nil {
+		t.Fatalf("rolled back upgrade should not fail: %v", err)
+	}
+	if applier.lastApplied() != rev.Manifests || !applier.forced[len(applier.forced)-1] {
+		t.Errorf("revision 1 should be applied forcibly, applied %q", applier.lastApplied())
+	}
+	if plugin.Status.Phase != pluginsv1beta1.PhaseInstalled || plugin.Status.Version != "1.0.0" {
+		t.Errorf("plugin should keep installed at 1.0.0, got %s %s", plugin.Status.Phase, plugin.Status.Version)
+	}
+	if plugin.Status.RolledBackPlan != bundle.PlanHash(plugin) {
+		t.Errorf("rolled back plan = %s, want %s", plugin.Status.RolledBackPlan, bundle.PlanHash(plugin))
+	}
+	if !meta.IsStatusConditionTrue(plugin.Status.Conditions, pluginsv1beta1.ConditionTypeRolledBack) {
+		t.Errorf("plugin should have RolledBack condition")
+	}
+
+	// the rolled back plan is not applied again
+	applied := len(applier.applied)
+	if err := upgrade(ctx, r, plugin, "2.0.0"); err != nil {
+		t.Fatalf("reconcile rolled back plan: %v", err)
+	}
+	if len(applier.applied) != applied {
+		t.Errorf("rolled back plan should not be applied again")
+	}
+
+	// a new plan clears the rollback
+	if err := upgrade(ctx, r, plugin, "3.0.0"); err != nil {
+		t.Fatalf("upgrade: %v", err)
+	}
+	if plugin.Status.RolledBackPlan != "" ||
+		meta.FindStatusCondition(plugin.Status.Conditions, pluginsv1beta1.ConditionTypeRolledBack) != nil {
+		t.Errorf("rollback should be cleared after upgraded")
+	}
+	if got := revisionNumbers(plugin); len(got) != 2 || got[1] != 2 {
+		t.Errorf("revisions = %v, want [1 2]", got)
+	}
+}
+
+func TestReconciler_apply_noAutoRollback(t *testing.T) {
+	ctx := context.Background()
+	r, applier := newTestReconciler(t, &RollbackOptions{MaxRevisions: 5})
+	plugin := newTestPlugin("1.0.0")
+	if err := upgrade(ctx, r, plugin, "1.0.0"); err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	applier.failVersion = "2.0.0"
+	if err := upgrade(ctx, r, plugin, "2.0.0"); err == nil {
+		t.Fatalf("apply error expected")
+	}
+	if plugin.Status.RolledBackPlan != "" || applier.lastApplied() != "version: 2.0.0" {
+		t.Errorf("should not rollback if auto rollback disabled")
+	}
+}
+
+func TestReconciler_apply_unhealthy(t *testing.T) {
+	ctx := context.Background()
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default"},
+		Status:     appsv1.DeploymentStatus{Replicas: 1, ReadyReplicas: 1},
+	}
+	r, applier := newTestReconciler(t, &RollbackOptions{MaxRevisions: 5, AutoRollback: true, HealthyTimeout: time.Hour}, deployment)
+	plugin := newTestPlugin("1.0.0")
+	plugin.Annotations = map[string]string{plugins.AnnotationHealthCheck: "deployment/app"}
+	if err := upgrade(ctx, r, plugin, "1.0.0"); err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+
+	deployment.Status.ReadyReplicas = 0
+	if err := r.Client.Update(ctx, deployment); err != nil {
+		t.Fatal(err)
+	}
+	// waiting for healthy
+	if err := upgrade(ctx, r, plugin, "2.0.0"); err != nil {
+		t.Fatalf("upgrade: %v", err)
+	}
+	cond := meta.FindStatusCondition(plugin.Status.Conditions, pluginsv1beta1.ConditionTypeHealthy)
+	if cond == nil || cond.Reason != pluginsv1beta1.ConditionReasonProgressing || !waitingHealthy(plugin) {
+		t.Fatalf("plugin should be progressing, got %+v", cond)
+	}
+	if got := revisionNumbers(plugin); len(got) != 1 {
+		t.Errorf("unhealthy plan should not be recorded, revisions %v", got)
+	}
+
+	// not healthy in time
+	r.Rollback.HealthyTimeout = 0
+	if err := upgrade(ctx, r, plugin, "2.0.0"); err != nil {
+		t.Fatalf("rolled back upgrade should not fail: %v", err)
+	}
+	cond = meta.FindStatusCondition(plugin.Status.Conditions, pluginsv1beta1.ConditionTypeRolledBack)
+	if cond == nil || cond.Reason != pluginsv1beta1.ConditionReasonUnhealthy {
+		t.Fatalf("plugin should be rolled back as unhealthy, got %+v", cond)
+	}
+	if applier.lastApplied() != "version: 1.0.0" || plugin.Status.Version != "1.0.0" || waitingHealthy(plugin) {
+		t.Errorf("plugin should be rolled back to 1.0.0")
+	}
+}
+
+func TestReconciler_apply_prune(t *testing.T) {
+	ctx := context.Background()
+	r, _ := newTestReconciler(t, &RollbackOptions{MaxRevisions: 2})
+	plugin := newTestPlugin("1.0.0")
+	for i := 1; i <= 4; i++ {
+		if err := upgrade(ctx, r, plugin, strconv.Itoa(i)+".0.0"); err != nil {
+			t.Fatalf("apply %d: %v", i, err)
+		}
+	}
+	if got := revisionNumbers(plugin); len(got) != 2 || got[0] != 3 || got[1] != 4 {
+		t.Fatalf("revisions = %v, want [3 4]", got)
+	}
+	for revision, exists := range map[int64]bool{1: false, 2: false, 3: true, 4: true} {
+		secret := &corev1.Secret{}
+		err := r.Client.Get(ctx, client.ObjectKey{Namespace: plugin.Namespace, Name: RevisionName(plugin.Name, revision)}, secret)
+		if exists && err != nil {
+			t.Errorf("revision %d should be kept: %v", revision, err)
+		}
+		if !exists && !apierrors.IsNotFound(err) {
+			t.Errorf("revision %d should be removed, got %v", revision, err)
+		}
+		if exists && secret.Labels[LabelRevisionOf] != plugin.Name {
+			t.Errorf("revision %d should be labeled with plugin name", revision)
+		}
+		if exists && secret.Type != RevisionSecretType {
+			t.Errorf("revision %d should be stored in secret of type %s, got %s", revision, RevisionSecretType, secret.Type)
+		}
+	}
+}
+
+func TestReconciler_apply_requestedRevision(t *testing.T) {
+	ctx := context.Background()
+	r, applier := newTestReconciler(t, &RollbackOptions{MaxRevisions: 5})
+	plugin := newTestPlugin("1.0.0")
+	for _, version := range []string{"1.0.0", "2.0.0"} {
+		if err := upgrade(ctx, r, plugin, version); err != nil {
+			t.Fatalf("apply %s: %v", version, err)
+		}
+	}
+	first, err := GetRevision(ctx, r.Client, plugin, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// what PluginManager.Rollback does
+	plugin.Spec = first.Spec
+	plugin.Annotations = map[string]string{plugins.AnnotationRollbackRevision: "1"}
+	rev, err := r.requestedRevision(ctx, plugin, plugin.Spec.DeepCopy())
+	if err != nil || rev == nil {
+		t.Fatalf("requested revision not found: %v", err)
+	}
+	plugin.Spec.Values = pluginsv1beta1.Values{Object: rev.Values}
+	if err := r.apply(ctx, plugin, plugin.Spec.DeepCopy(), rev); err != nil {
+		t.Fatalf("rollback: %v", err)
+	}
+	if applier.lastApplied() != first.Manifests || plugin.Status.Version != "1.0.0" {
+		t.Errorf("stored manifests of revision 1 should be applied, applied %q", applier.lastApplied())
+	}
+	if got := revisionNumbers(plugin); len(got) != 3 || lastRevision(plugin).Plan != first.Plan {
+		t.Errorf("revision 1 should be recorded as revision 3, revisions %v", got)
+	}
+
+	// the request is ignored once the spec changed
+	spec := plugin.Spec.DeepCopy()
+	spec.Version = "3.0.0"
+	if rev, err := r.requestedRevision(ctx, plugin, spec); err != nil || rev != nil {
+		t.Errorf("requested revision should be ignored after spec changed, got %v %v", rev, err)
+	}
+}
@@ -101,17 +101,27 @@ func (i Bootstrap) Install(ctx context.Context, values GlobalValues) error {
 		"storageClass":    values.StorageClass,
 		"runtime":         values.Runtime,
 	}
-	if err := pm.Install(ctx, plugins.KubegemsChartGlobal, "", globalvals); err != nil {
-		return err
-	}
 	cahrtversion := strings.TrimPrefix(kubegemsVersion, "v")
-	if err := pm.Install(ctx, plugins.KubegemsChartInstaller, cahrtversion, nil); err != nil {
-		return err
-	}
-	if err := pm.Install(ctx, plugins.KubegemsChartLocal, cahrtversion, nil); err != nil {
-		return err
+	toinstall := []struct {
+		name    string
+		version string
+		values  map[string]any
+	}{
+		{name: plugins.KubegemsChartGlobal, values: globalvals},
+		{name: plugins.KubegemsChartInstaller, version: cahrtversion},
+		{name: plugins.KubegemsChartLocal, version: cahrtversion},
+	}
+	pvs := make([]PluginVersion, 0, len(toinstall))
+	for _, item := range toinstall {
+		pv, err := pm.GetPluginVersion(ctx, item.name, item.version, false, false)
+		if err != nil {
+			return err
+		}
+		pv.Values = pluginsv1beta1.Values{Object: item.values}.FullFill()
+		pvs = append(pvs, *pv)
 	}
-	return nil
+	// installed in dependency order
+	return pm.InstallPlugins(ctx, pvs...)
 }
 
 func (i Bootstrap) Remove(ctx context.Context) error {
@@ -0,0 +1,230 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pluginmanager
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+	"kubegems.io/kubegems/pkg/installer/utils"
+)
+
+// DependencyError indicates the requirements of plugin can't be satisfied.
+type DependencyError struct {
+	Plugin  string
+	Reasons []string
+}
+
+func (e *DependencyError) Error() string {
+	return fmt.Sprintf("requirements of %s can't be satisfied: %s", e.Plugin, strings.Join(e.Reasons, "; "))
+}
+
+func IsDependencyError(err error) bool {
+	derr := &DependencyError{}
+	return errors.As(err, &derr)
+}
+
+// DependencyGraph is the graph of enabled plugins, edges are from plugin to its requirements.
+type DependencyGraph map[string]PluginVersion
+
+func NewDependencyGraph(installed map[string]PluginVersion) DependencyGraph {
+	g := DependencyGraph{}
+	for name, pv := range installed {
+		if pv.Enabled {
+			g[name] = pv
+		}
+	}
+	return g
+}
+
+// With returns a new graph with the plugin added or replaced.
+func (g DependencyGraph) With(pv PluginVersion) DependencyGraph {
+	ret := DependencyGraph{}
+	for name, item := range g {
+		ret[name] = item
+	}
+	ret[pv.Name] = pv
+	return ret
+}
+
+func (g DependencyGraph) edges() map[string][]string {
+	edges := map[string][]string{}
+	for name, pv := range g {
+		for _, req := range pv.Requirements {
+			edges[name] = append(edges[name], req.Name)
+		}
+	}
+	return edges
+}
+
+// Sort returns enabled plugins in topological order, the required come first.
+func (g DependencyGraph) Sort() ([]string, error) {
+	sorted, err := utils.TopologicalSort(g.edges(), g.names()...)
+	if err != nil {
+		return nil, err
+	}
+	ret := []string{}
+	for _, name := range sorted {
+		if _, ok := g[name]; ok {
+			ret = append(ret, name)
+		}
+	}
+	return ret, nil
+}
+
+// InstallOrder checks the graph with the plugins enabled,
+// and returns the plugins in topological order, the required come first.
+func (g DependencyGraph) InstallOrder(available map[string][]PluginVersion, pvs ...PluginVersion) ([]PluginVersion, error) {
+	toinstall := map[string]PluginVersion{}
+	for _, pv := range pvs {
+		pv.Enabled = true
+		g = g.With(pv)
+		toinstall[pv.Name] = pv
+	}
+	for _, pv := range pvs {
+		if err := g.Validate(pv.Name, available); err != nil {
+			return nil, err
+		}
+	}
+	sorted, err := g.Sort()
+	if err != nil {
+		return nil, err
+	}
+	ret := []PluginVersion{}
+	for _, name := range sorted {
+		if pv, ok := toinstall[name]; ok {
+			ret = append(ret, pv)
+		}
+	}
+	return ret, nil
+}
+
+func (g DependencyGraph) names() []string {
+	names := make([]string, 0, len(g))
+	for name := range g {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+type requiredBy struct {
+	From       string
+	Expr       string
+	Constraint *semver.Constraints
+}
+
+// Validate checks requirements of the plugin up front, before it is enabled:
+// no dependency cycle, plugins required directly or indirectly are enabled with versions matched all constraints,
+// and version of the plugin matches constraints of plugins require it.
+// available versions are used to tell whether the constraints are conflicting.
+func (g DependencyGraph) Validate(name string, available map[string][]PluginVersion) error {
+	closure, err := utils.TopologicalSort(g.edges(), name)
+	if err != nil {
+		return &DependencyError{Plugin: name, Reasons: []string{err.Error()}}
+	}
+	inClosure := map[string]bool{}
+	for _, item := range closure {
+		inClosure[item] = true
+	}
+
+	reasons := []string{}
+	requires := map[string][]requiredBy{}
+	for _, from := range g.names() {
+		for _, req := range g[from].Requirements {
+			if !inClosure[from] && req.Name != name {
+				continue
+			}
+			expr := strings.TrimSpace(req.Expr)
+			constraint, err := semver.NewConstraint(expr)
+			if err != nil {
+				reasons = append(reasons, fmt.Sprintf("%s requires %s with invalid version %q: %v", from, req.Name, expr, err))
+				continue
+			}
+			requires[req.Name] = append(requires[req.Name], requiredBy{From: from, Expr: expr, Constraint: constraint})
+		}
+	}
+	required := make([]string, 0, len(requires))
+	for dep := range requires {
+		required = append(required, dep)
+	}
+	sort.Strings(required)
+	for _, dep := range required {
+		reasons = append(reasons, g.checkRequired(dep, requires[dep], available[dep])...)
+	}
+	if len(reasons) > 0 {
+		return &DependencyError{Plugin: name, Reasons: reasons}
+	}
+	return nil
+}
+
+func (g DependencyGraph) checkRequired(name string, requires []requiredBy, available []PluginVersion) []string {
+	reasons := []string{}
+	pv, ok := g[name]
+	if !ok {
+		for _, req := range requires {
+			reasons = append(reasons, fmt.Sprintf("%s requires %s %s, but it is not enabled", req.From, name, req.Expr))
+		}
+		return reasons
+	}
+	version, err := semver.NewVersion(pv.Version)
+	if err != nil {
+		// we cant check version so adopt any.
+		return nil
+	}
+	unmatched := []requiredBy{}
+	for _, req := range requires {
+		if !req.Constraint.Check(version) {
+			unmatched = append(unmatched, req)
+		}
+	}
+	if len(unmatched) == 0 {
+		return nil
+	}
+	if len(requires) > 1 && len(available) > 0 && !anyVersionMatches(available, requires) {
+		all := []string{}
+		for _, req := range requires {
+			all = append(all, fmt.Sprintf("%s requires %s", req.From, req.Expr))
+		}
+		return []string{fmt.Sprintf("conflicting requirements on %s: %s", name, strings.Join(all, ", "))}
+	}
+	for _, req := range unmatched {
+		reasons = append(reasons, fmt.Sprintf("%s requires %s %s, but version %s is enabled", req.From, name, req.Expr, pv.Version))
+	}
+	return reasons
+}
+
+func anyVersionMatches(available []PluginVersion, requires []requiredBy) bool {
+	for _, pv := range available {
+		version, err := semver.NewVersion(pv.Version)
+		if err != nil {
+			continue
+		}
+		matched := true
+		for _, req := range requires {
+			if !req.Constraint.Check(version) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
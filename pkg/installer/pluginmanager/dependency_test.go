@@ -0,0 +1,128 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pluginmanager
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDependencyGraphSort(t *testing.T) {
+	g := NewDependencyGraph(map[string]PluginVersion{
+		"app":        {Name: "app", Enabled: true, Requirements: ParseRequirements("monitoring >=1.2 <2,logging")},
+		"monitoring": {Name: "monitoring", Enabled: true, Requirements: ParseRequirements("storage")},
+		"logging":    {Name: "logging", Enabled: true, Requirements: ParseRequirements("storage")},
+		"storage":    {Name: "storage", Enabled: true},
+		"disabled":   {Name: "disabled", Requirements: ParseRequirements("app")},
+	})
+	got, err := g.Sort()
+	if err != nil {
+		t.Fatalf("Sort() error = %v", err)
+	}
+	want := []string{"storage", "logging", "monitoring", "app"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Sort() = %v, want %v", got, want)
+	}
+}
+
+func TestDependencyGraphInstallOrder(t *testing.T) {
+	installed := map[string]PluginVersion{
+		"storage": {Name: "storage", Version: "1.0.0", Enabled: true},
+	}
+	toinstall := []PluginVersion{
+		{Name: "app", Version: "1.0.0", Requirements: ParseRequirements("monitoring >=1.2 <2,logging")},
+		{Name: "logging", Version: "1.0.0", Requirements: ParseRequirements("storage")},
+		{Name: "monitoring", Version: "1.3.0", Requirements: ParseRequirements("storage")},
+	}
+	sorted, err := NewDependencyGraph(installed).InstallOrder(nil, toinstall...)
+	if err != nil {
+		t.Fatalf("InstallOrder() error = %v", err)
+	}
+	got := []string{}
+	for _, pv := range sorted {
+		if !pv.Enabled {
+			t.Errorf("plugin %s should be enabled", pv.Name)
+		}
+		got = append(got, pv.Name)
+	}
+	// installed plugins are not included
+	want := []string{"logging", "monitoring", "app"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("InstallOrder() = %v, want %v", got, want)
+	}
+
+	// all of the plugins are checked before any installed
+	toinstall[2].Version = "2.0.0"
+	if _, err := NewDependencyGraph(installed).InstallOrder(nil, toinstall...); !IsDependencyError(err) {
+		t.Errorf("InstallOrder() error = %v, want a DependencyError", err)
+	}
+}
+
+func TestDependencyGraphValidate(t *testing.T) {
+	installed := map[string]PluginVersion{
+		"monitoring": {Name: "monitoring", Version: "1.3.0", Enabled: true},
+		"logging":    {Name: "logging", Version: "1.0.0", Enabled: true, Requirements: ParseRequirements("monitoring <1.4")},
+		"tracing":    {Name: "tracing", Version: "1.0.0", Enabled: true, Requirements: ParseRequirements("app")},
+	}
+	available := map[string][]PluginVersion{
+		"monitoring": {{Name: "monitoring", Version: "2.0.0"}, {Name: "monitoring", Version: "1.3.0"}},
+	}
+	tests := []struct {
+		name    string
+		plugin  PluginVersion
+		wantErr bool
+	}{
+		{
+			name:   "matched",
+			plugin: PluginVersion{Name: "app", Version: "1.0.0", Requirements: ParseRequirements("monitoring >=1.2 <2")},
+		},
+		{
+			name:    "not enabled",
+			plugin:  PluginVersion{Name: "app", Version: "1.0.0", Requirements: ParseRequirements("storage")},
+			wantErr: true,
+		},
+		{
+			name:    "version not matched",
+			plugin:  PluginVersion{Name: "app", Version: "1.0.0", Requirements: ParseRequirements("monitoring >=1.4")},
+			wantErr: true,
+		},
+		{
+			name:    "upgrade breaks dependents",
+			plugin:  PluginVersion{Name: "monitoring", Version: "2.0.0"},
+			wantErr: true,
+		},
+		{
+			name:    "conflicting",
+			plugin:  PluginVersion{Name: "app", Version: "1.0.0", Requirements: ParseRequirements("monitoring >=2")},
+			wantErr: true,
+		},
+		{
+			name:    "cycle",
+			plugin:  PluginVersion{Name: "app", Version: "1.0.0", Requirements: ParseRequirements("tracing")},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := NewDependencyGraph(installed).With(tt.plugin).Validate(tt.plugin.Name, available)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !IsDependencyError(err) {
+				t.Errorf("Validate() error = %v, want a DependencyError", err)
+			}
+		})
+	}
+}
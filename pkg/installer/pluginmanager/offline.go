@@ -0,0 +1,461 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pluginmanager
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/go-logr/logr"
+	"helm.sh/helm/v3/pkg/repo"
+	"k8s.io/apimachinery/pkg/runtime"
+	pluginsv1beta1 "kubegems.io/kubegems/pkg/apis/plugins/v1beta1"
+	"kubegems.io/kubegems/pkg/installer/bundle"
+	"kubegems.io/kubegems/pkg/installer/bundle/helm"
+	"kubegems.io/kubegems/pkg/installer/utils"
+)
+
+// layout of offline archive
+const (
+	OfflineManifestFile = "manifest.json" // OfflineManifest
+	OfflineImagesFile   = "images.txt"    // images used, one per line
+	OfflineBundlesDir   = "bundles"       // a cache directory of bundles, same layout as installer cache
+)
+
+type OfflineManifest struct {
+	Repository   string          `json:"repository"`
+	Repositories []string        `json:"repositories"` // chart repositories indexed, including the repositories of nested plugins
+	Plugins      []OfflinePlugin `json:"plugins"`
+	Images       []string        `json:"images"`
+	CreatedAt    time.Time       `json:"createdAt"`
+}
+
+type OfflinePlugin struct {
+	Name    string                    `json:"name"`
+	Version string                    `json:"version"`
+	Kind    pluginsv1beta1.BundleKind `json:"kind,omitempty"`
+	URL     string                    `json:"url"`
+	Path    string                    `json:"path"` // path of the bundle in bundles directory, a chart archive or an extracted directory
+}
+
+type ExportOptions struct {
+	Repository string   // plugins repository address
+	Plugins    []string // "{name}" or "{name}@{version}", latest version is used if no version
+}
+
+// Export archives the plugins, their requirements and plugins rendered in them, with repo index and images list.
+func Export(ctx context.Context, options ExportOptions, w io.Writer) (*OfflineManifest, error) {
+	log := logr.FromContextOrDiscard(ctx)
+
+	repository := &Repository{Address: options.Repository}
+	if err := repository.RefreshRepoIndex(ctx); err != nil {
+		return nil, fmt.Errorf("load index of %s: %w", options.Repository, err)
+	}
+	selected, err := ResolveOfflinePlugins(repository, options.Plugins)
+	if err != nil {
+		return nil, err
+	}
+
+	workdir, err := os.MkdirTemp("", "kubegems-plugins-export-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(workdir)
+
+	bundlesdir := filepath.Join(workdir, OfflineBundlesDir)
+	applier := bundle.NewDefaultApply(nil, nil, &bundle.Options{CacheDir: bundlesdir})
+	manifest := &OfflineManifest{Repository: options.Repository, CreatedAt: time.Now()}
+	images := map[string]struct{}{}
+	repositories := map[string]bool{}
+	visited := map[string]bool{}
+
+	var export func(plugin *pluginsv1beta1.Plugin) error
+	export = func(plugin *pluginsv1beta1.Plugin) error {
+		name := plugin.Spec.Chart
+		if name == "" {
+			name = plugin.Name
+		}
+		key := plugin.Spec.URL + "#" + name + "@" + plugin.Spec.Version
+		if visited[key] {
+			return nil
+		}
+		visited[key] = true
+
+		log.Info("exporting", "name", name, "version", plugin.Spec.Version, "url", plugin.Spec.URL)
+		bundlepath, err := applier.Download(ctx, plugin)
+		if err != nil {
+			return fmt.Errorf("download %s-%s: %w", name, plugin.Spec.Version, err)
+		}
+		// bundles in file:// repository are not copied into cache
+		bundlepath, err = cacheBundle(bundlepath, bundle.PerRepoCacheDir(plugin.Spec.URL, bundlesdir))
+		if err != nil {
+			return fmt.Errorf("cache %s-%s: %w", name, plugin.Spec.Version, err)
+		}
+		rel, err := filepath.Rel(bundlesdir, bundlepath)
+		if err != nil {
+			return err
+		}
+		// chart archives are indexed, extracted bundles are found in cache by url
+		if filepath.Ext(bundlepath) == ".tgz" {
+			repositories[plugin.Spec.URL] = true
+		}
+		manifest.Plugins = append(manifest.Plugins, OfflinePlugin{
+			Name:    name,
+			Version: plugin.Spec.Version,
+			Kind:    plugin.Spec.Kind,
+			URL:     plugin.Spec.URL,
+			Path:    filepath.ToSlash(rel),
+		})
+		rendered, err := applier.Render(ctx, plugin)
+		if err != nil {
+			// values may be resolved on install, images of the plugin can't be found
+			log.Error(err, "template, images of the plugin are ignored", "name", name)
+			return nil
+		}
+		_, renderedImages := helm.ParseResourceReferencesAndImages(rendered)
+		for _, image := range renderedImages {
+			images[image] = struct{}{}
+		}
+		// plugins rendered in plugin
+		for _, nested := range parsePlugins(rendered) {
+			if err := export(nested); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for _, pv := range selected {
+		if err := export(pv.ToPlugin()); err != nil {
+			return nil, err
+		}
+	}
+
+	// build index of the repositories
+	for repository := range repositories {
+		manifest.Repositories = append(manifest.Repositories, repository)
+	}
+	sort.Strings(manifest.Repositories)
+	for _, repository := range manifest.Repositories {
+		indexpath := bundle.PerRepoCacheDir(repository, bundlesdir)
+		index, err := repo.IndexDirectory(indexpath, "")
+		if err != nil {
+			return nil, fmt.Errorf("index %s: %w", repository, err)
+		}
+		index.SortEntries()
+		if err := index.WriteFile(filepath.Join(indexpath, helm.IndexFileName), helm.DefaultFileMode); err != nil {
+			return nil, err
+		}
+	}
+
+	for image := range images {
+		manifest.Images = append(manifest.Images, image)
+	}
+	sort.Strings(manifest.Images)
+	content, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(workdir, OfflineManifestFile), content, helm.DefaultFileMode); err != nil {
+		return nil, err
+	}
+	imagelist := strings.Join(manifest.Images, "\n") + "\n"
+	if err := os.WriteFile(filepath.Join(workdir, OfflineImagesFile), []byte(imagelist), helm.DefaultFileMode); err != nil {
+		return nil, err
+	}
+	return manifest, tarGzDir(workdir, w)
+}
+
+// ResolveOfflinePlugins finds the plugins and their requirements recursively in repository.
+func ResolveOfflinePlugins(repository *Repository, names []string) ([]PluginVersion, error) {
+	selected := map[string]PluginVersion{}
+	queue := []Requirement{}
+	for _, item := range names {
+		name, version, _ := strings.Cut(item, "@")
+		expr := "*"
+		if version != "" {
+			expr = "=" + strings.TrimPrefix(version, "v")
+		}
+		queue = append(queue, Requirement{Name: name, Expr: expr})
+	}
+	for len(queue) > 0 {
+		req := queue[0]
+		queue = queue[1:]
+
+		constraint, err := semver.NewConstraint(strings.TrimSpace(req.Expr))
+		if err != nil {
+			return nil, fmt.Errorf("invalid requirement %s %s: %w", req.Name, req.Expr, err)
+		}
+		if exist, ok := selected[req.Name]; ok {
+			if !versionMatches(constraint, exist.Version) {
+				return nil, fmt.Errorf("conflicting requirements on %s: %s selected, but %s required", req.Name, exist.Version, req.Expr)
+			}
+			continue
+		}
+		// use the latest version matched
+		var found *PluginVersion
+		var foundver *semver.Version
+		for _, pv := range repository.Plugins[req.Name] {
+			ver, err := semver.NewVersion(pv.Version)
+			if err != nil || !constraint.Check(ver) {
+				continue
+			}
+			if foundver == nil || ver.GreaterThan(foundver) {
+				pv := pv
+				found, foundver = &pv, ver
+			}
+		}
+		if found == nil {
+			return nil, fmt.Errorf("no version of %s matches %s in %s", req.Name, req.Expr, repository.Address)
+		}
+		selected[req.Name] = *found
+		queue = append(queue, found.Requirements...)
+	}
+	ret := make([]PluginVersion, 0, len(selected))
+	for _, pv := range selected {
+		ret = append(ret, pv)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Name < ret[j].Name })
+	return ret, nil
+}
+
+func versionMatches(constraint *semver.Constraints, version string) bool {
+	ver, err := semver.NewVersion(version)
+	if err != nil {
+		return false
+	}
+	return constraint.Check(ver)
+}
+
+func parsePlugins(rendered []byte) []*pluginsv1beta1.Plugin {
+	ress, _ := utils.SplitYAML(rendered)
+	ret := []*pluginsv1beta1.Plugin{}
+	for _, res := range ress {
+		gvk := res.GroupVersionKind()
+		if gvk.Group != pluginsv1beta1.SchemeGroupVersion.Group || gvk.Kind != "Plugin" {
+			continue
+		}
+		plugin := &pluginsv1beta1.Plugin{}
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(res.Object, plugin); err != nil {
+			continue
+		}
+		ret = append(ret, plugin)
+	}
+	return ret
+}
+
+// Import extracts the offline archive into installer cache directory,
+// chart urls in index of the repositories are rewritten to "file://" in cache.
+// bundles keep the cache layout, so plugins with the original url are installed from cache.
+func Import(ctx context.Context, r io.Reader, cachedir string) (*OfflineManifest, error) {
+	log := logr.FromContextOrDiscard(ctx)
+
+	workdir, err := os.MkdirTemp("", "kubegems-plugins-import-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(workdir)
+	if err := bundle.UnTarGz(r, "", workdir); err != nil {
+		return nil, fmt.Errorf("extract archive: %w", err)
+	}
+	content, err := os.ReadFile(filepath.Join(workdir, OfflineManifestFile))
+	if err != nil {
+		return nil, fmt.Errorf("invalid offline archive: %w", err)
+	}
+	manifest := &OfflineManifest{}
+	if err := json.Unmarshal(content, manifest); err != nil {
+		return nil, fmt.Errorf("invalid offline archive: %w", err)
+	}
+
+	bundlesdir := filepath.Join(workdir, OfflineBundlesDir)
+	err = filepath.Walk(bundlesdir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(bundlesdir, path)
+		if err != nil {
+			return err
+		}
+		return copyFile(path, filepath.Join(cachedir, rel), info.Mode())
+	})
+	if err != nil {
+		return nil, fmt.Errorf("copy bundles: %w", err)
+	}
+	for _, plugin := range manifest.Plugins {
+		if _, err := os.Stat(filepath.Join(cachedir, filepath.FromSlash(plugin.Path))); err != nil {
+			return nil, fmt.Errorf("invalid offline archive, bundle of %s-%s: %w", plugin.Name, plugin.Version, err)
+		}
+	}
+	for _, repository := range manifest.Repositories {
+		abspath, err := rewriteIndex(bundle.PerRepoCacheDir(repository, cachedir))
+		if err != nil {
+			return nil, fmt.Errorf("rewrite index of %s: %w", repository, err)
+		}
+		log.Info("imported", "repository", repository, "path", helm.FileProtocolSchema+"://"+abspath)
+	}
+	log.Info("imported", "plugins", len(manifest.Plugins))
+	return manifest, nil
+}
+
+// rewriteIndex rewrites relative chart urls in index of the repository directory to "file://",
+// the absolute path of the directory is returned.
+func rewriteIndex(indexpath string) (string, error) {
+	abspath, err := filepath.Abs(indexpath)
+	if err != nil {
+		return "", err
+	}
+	indexfile := filepath.Join(indexpath, helm.IndexFileName)
+	index, err := helm.LoadLocalIndex(indexfile)
+	if err != nil {
+		return "", err
+	}
+	for _, cvs := range index.Entries {
+		for _, cv := range cvs {
+			for i, u := range cv.URLs {
+				if !strings.Contains(u, "://") {
+					cv.URLs[i] = helm.FileProtocolSchema + "://" + filepath.Join(abspath, u)
+				}
+			}
+		}
+	}
+	return abspath, index.WriteFile(indexfile, helm.DefaultFileMode)
+}
+
+// cacheBundle copies the bundle into cache directory if it is not in.
+func cacheBundle(bundlepath, cachedir string) (string, error) {
+	abspath, err := filepath.Abs(bundlepath)
+	if err != nil {
+		return "", err
+	}
+	abscachedir, err := filepath.Abs(cachedir)
+	if err != nil {
+		return "", err
+	}
+	if strings.HasPrefix(abspath, abscachedir+string(os.PathSeparator)) {
+		return abspath, nil
+	}
+	dest := filepath.Join(abscachedir, filepath.Base(abspath))
+	err = filepath.Walk(abspath, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(abspath, path)
+		if err != nil {
+			return err
+		}
+		return copyFile(path, filepath.Join(dest, rel), info.Mode())
+	})
+	return dest, err
+}
+
+func copyFile(src, dest string, mode os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(dest), helm.DefaultDirectoryMode); err != nil {
+		return err
+	}
+	if mode&os.ModeSymlink != 0 {
+		link, err := os.Readlink(src)
+		if err != nil {
+			return err
+		}
+		_ = os.Remove(dest)
+		return os.Symlink(link, dest)
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dest, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	_, err = io.Copy(out, in)
+	return err
+}
+
+func tarGzDir(dir string, w io.Writer) error {
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil || rel == "." {
+			return err
+		}
+		link := ""
+		if info.Mode()&os.ModeSymlink != 0 {
+			if link, err = readRelativeLink(dir, path); err != nil {
+				return err
+			}
+		}
+		hdr, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		hdr.Name = filepath.ToSlash(rel)
+		if info.IsDir() {
+			hdr.Name += "/"
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gw.Close()
+}
+
+// readRelativeLink reads the symlink target relative to the link,
+// the target must be in the directory so the link keeps valid after extracted.
+func readRelativeLink(dir, path string) (string, error) {
+	link, err := os.Readlink(path)
+	if err != nil {
+		return "", err
+	}
+	target := link
+	if !filepath.IsAbs(target) {
+		target = filepath.Join(filepath.Dir(path), target)
+	}
+	if !strings.HasPrefix(target, filepath.Clean(dir)+string(os.PathSeparator)) {
+		return "", fmt.Errorf("symlink %s points out of %s", path, dir)
+	}
+	return filepath.Rel(filepath.Dir(path), target)
+}
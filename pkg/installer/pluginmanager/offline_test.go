@@ -0,0 +1,190 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pluginmanager
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"helm.sh/helm/v3/pkg/repo"
+	"kubegems.io/kubegems/pkg/apis/plugins"
+	"kubegems.io/kubegems/pkg/installer/bundle"
+	"kubegems.io/kubegems/pkg/installer/bundle/helm"
+)
+
+func TestExportImport(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	// the plugin rendered in app is in another repository
+	nestedrepo := filepath.Join(dir, "nested")
+	writeTestChart(t, nestedrepo, "nested", "1.0.0", map[string]string{
+		"templates/deployment.yaml": `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: nested
+spec:
+  template:
+    spec:
+      containers:
+        - name: nginx
+          image: docker.io/library/nginx:1.23
+`,
+	})
+	mainrepo := filepath.Join(dir, "main")
+	writeTestChart(t, mainrepo, "app", "1.0.0", map[string]string{
+		"templates/plugin.yaml": `apiVersion: plugins.kubegems.io/v1beta1
+kind: Plugin
+metadata:
+  name: nested
+spec:
+  kind: template
+  url: file://` + nestedrepo + `
+  version: 1.0.0
+`,
+	})
+
+	archive := &bytes.Buffer{}
+	exported, err := Export(ctx, ExportOptions{Repository: "file://" + mainrepo, Plugins: []string{"app"}}, archive)
+	if err != nil {
+		t.Fatalf("Export() error = %v", err)
+	}
+	paths := map[string]string{}
+	for _, plugin := range exported.Plugins {
+		paths[plugin.Name] = plugin.Path
+	}
+	wantpaths := map[string]string{"app": "main/app-1.0.0.tgz", "nested": "nested/nested-1.0.0.tgz"}
+	if !reflect.DeepEqual(paths, wantpaths) {
+		t.Errorf("exported plugins = %v, want %v", paths, wantpaths)
+	}
+	wantrepos := []string{"file://" + mainrepo, "file://" + nestedrepo}
+	if !reflect.DeepEqual(exported.Repositories, wantrepos) {
+		t.Errorf("exported repositories = %v, want %v", exported.Repositories, wantrepos)
+	}
+	if !reflect.DeepEqual(exported.Images, []string{"docker.io/library/nginx:1.23"}) {
+		t.Errorf("exported images = %v", exported.Images)
+	}
+
+	cachedir := filepath.Join(dir, "cache")
+	imported, err := Import(ctx, archive, cachedir)
+	if err != nil {
+		t.Fatalf("Import() error = %v", err)
+	}
+	for _, repository := range imported.Repositories {
+		indexpath := bundle.PerRepoCacheDir(repository, cachedir)
+		index, err := helm.LoadLocalIndex(filepath.Join(indexpath, helm.IndexFileName))
+		if err != nil {
+			t.Fatalf("load index of %s: %v", repository, err)
+		}
+		if len(index.Entries) == 0 {
+			t.Errorf("index of %s is empty", repository)
+		}
+		for _, cvs := range index.Entries {
+			for _, cv := range cvs {
+				for _, u := range cv.URLs {
+					if !strings.HasPrefix(u, "file://"+cachedir) {
+						t.Errorf("chart url %s is not rewritten into cache", u)
+					}
+					if _, err := os.Stat(strings.TrimPrefix(u, "file://")); err != nil {
+						t.Errorf("chart of %s: %v", u, err)
+					}
+				}
+			}
+		}
+	}
+	// sources removed, plugins are installed from cache with the original urls
+	if err := os.RemoveAll(mainrepo); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.RemoveAll(nestedrepo); err != nil {
+		t.Fatal(err)
+	}
+	for _, plugin := range imported.Plugins {
+		bundlepath, err := bundle.Download(ctx, plugin.URL, plugin.Name, plugin.Version, "", cachedir)
+		if err != nil {
+			t.Errorf("download %s from cache: %v", plugin.Name, err)
+			continue
+		}
+		if want := filepath.Join(cachedir, plugin.Path); bundlepath != want {
+			t.Errorf("bundle of %s = %s, want %s", plugin.Name, bundlepath, want)
+		}
+	}
+}
+
+func TestTarGzDirSymlink(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	if err := os.MkdirAll(filepath.Join(src, "templates"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(src, "values.yaml"), []byte("foo: bar"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("../values.yaml", filepath.Join(src, "templates", "values.yaml")); err != nil {
+		t.Fatal(err)
+	}
+	buf := &bytes.Buffer{}
+	if err := tarGzDir(src, buf); err != nil {
+		t.Fatalf("tarGzDir() error = %v", err)
+	}
+	into := filepath.Join(dir, "into")
+	if err := bundle.UnTarGz(buf, "", into); err != nil {
+		t.Fatalf("UnTarGz() error = %v", err)
+	}
+	link, err := os.Readlink(filepath.Join(into, "templates", "values.yaml"))
+	if err != nil || link != "../values.yaml" {
+		t.Errorf("symlink = %s(%v), want ../values.yaml", link, err)
+	}
+
+	// links out of the directory are not archived
+	if err := os.Symlink(filepath.Join(dir, "outside"), filepath.Join(src, "outside")); err != nil {
+		t.Fatal(err)
+	}
+	if err := tarGzDir(src, &bytes.Buffer{}); err == nil {
+		t.Errorf("tarGzDir() should fail on symlink out of directory")
+	}
+}
+
+func writeTestChart(t *testing.T, repodir, name, version string, templates map[string]string) {
+	t.Helper()
+	c := &chart.Chart{
+		Metadata: &chart.Metadata{
+			APIVersion:  chart.APIVersionV2,
+			Name:        name,
+			Version:     version,
+			Annotations: map[string]string{plugins.AnnotationIsPlugin: "true"},
+		},
+	}
+	for filename, content := range templates {
+		c.Templates = append(c.Templates, &chart.File{Name: filename, Data: []byte(content)})
+	}
+	if _, err := chartutil.Save(c, repodir); err != nil {
+		t.Fatal(err)
+	}
+	index, err := repo.IndexDirectory(repodir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := index.WriteFile(filepath.Join(repodir, helm.IndexFileName), helm.DefaultFileMode); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,101 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pluginmanager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"kubegems.io/kubegems/pkg/apis/plugins"
+	pluginsv1beta1 "kubegems.io/kubegems/pkg/apis/plugins/v1beta1"
+	"kubegems.io/kubegems/pkg/installer/bundle"
+	"kubegems.io/kubegems/pkg/installer/controller"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var ErrPlanChanged = errors.New("plan changed, please review the plan again")
+
+// Plan computes the changes of installing the version of plugin with values, nothing is applied.
+func (m *PluginManager) Plan(ctx context.Context, name string, version string, values map[string]any) (*bundle.Plan, error) {
+	pv, err := m.GetPluginVersion(ctx, name, version, false, false)
+	if err != nil {
+		return nil, err
+	}
+	pv.Values = pluginsv1beta1.Values{Object: values}.FullFill()
+	target := pv.ToPlugin()
+	target.Namespace = plugins.KubeGemsNamespaceInstaller
+
+	// diff against resources managed by the installed plugin
+	exists := &pluginsv1beta1.Plugin{}
+	if err := m.Client.Get(ctx, client.ObjectKeyFromObject(target), exists); err != nil {
+		if !apierrors.IsNotFound(err) {
+			return nil, err
+		}
+	} else {
+		for k, v := range exists.Annotations {
+			if _, ok := target.Annotations[k]; !ok {
+				target.Annotations[k] = v
+			}
+		}
+		target.Status = exists.Status
+	}
+	return m.plan(ctx, target)
+}
+
+// PendingPlan computes the changes of current plugin spec,it is the plan waiting for approval.
+func (m *PluginManager) PendingPlan(ctx context.Context, name string) (*bundle.Plan, error) {
+	plugin := &pluginsv1beta1.Plugin{}
+	if err := m.Client.Get(ctx, client.ObjectKey{Namespace: plugins.KubeGemsNamespaceInstaller, Name: name}, plugin); err != nil {
+		return nil, err
+	}
+	return m.plan(ctx, plugin)
+}
+
+// Approve approves the pending plan of plugin,hash must be same with the current pending plan.
+func (m *PluginManager) Approve(ctx context.Context, name string, hash string) (*bundle.Plan, error) {
+	plan, err := m.PendingPlan(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if plan.Hash != hash {
+		return nil, ErrPlanChanged
+	}
+	plugin := &pluginsv1beta1.Plugin{}
+	if err := m.Client.Get(ctx, client.ObjectKey{Namespace: plugins.KubeGemsNamespaceInstaller, Name: name}, plugin); err != nil {
+		return nil, err
+	}
+	patch := client.MergeFrom(plugin.DeepCopy())
+	if plugin.Annotations == nil {
+		plugin.Annotations = map[string]string{}
+	}
+	plugin.Annotations[plugins.AnnotationApprovedPlan] = hash
+	if err := m.Client.Patch(ctx, plugin, patch); err != nil {
+		return nil, err
+	}
+	plan.Approved = true
+	return plan, nil
+}
+
+func (m *PluginManager) plan(ctx context.Context, plugin *pluginsv1beta1.Plugin) (*bundle.Plan, error) {
+	if m.Applier == nil {
+		return nil, fmt.Errorf("plan is not supported")
+	}
+	if err := controller.ResolveValuesRef(ctx, m.Client, plugin); err != nil {
+		return nil, err
+	}
+	return m.Applier.Plan(ctx, plugin)
+}
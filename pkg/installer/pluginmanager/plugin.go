@@ -48,6 +48,7 @@ type Plugin struct {
 type PluginManager struct {
 	CacheDir         string
 	Client           client.Client
+	Applier          *bundle.BundleApplier // used to compute plans
 	builtinRepoCache *Repository
 }
 
@@ -64,7 +65,11 @@ func DefaultPluginManager(cachedir string) (*PluginManager, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &PluginManager{CacheDir: cachedir, Client: cli}, nil
+	return &PluginManager{
+		CacheDir: cachedir,
+		Client:   cli,
+		Applier:  bundle.NewDefaultApply(cfg, cli, &bundle.Options{CacheDir: cachedir}),
+	}, nil
 }
 
 func (m *PluginManager) Install(ctx context.Context, name string, version string, values map[string]any) error {
@@ -72,19 +77,41 @@ func (m *PluginManager) Install(ctx context.Context, name string, version string
 	if err != nil {
 		return err
 	}
-	// check dependencies
-	installed, _ := m.ListInstalled(ctx, false)
-	if err := CheckDependecies(pv.Requirements, installed); err != nil {
+	pv.Values = pluginsv1beta1.Values{Object: values}.FullFill()
+	return m.InstallPlugins(ctx, *pv)
+}
+
+// InstallPlugins installs or upgrades plugins together,
+// the dependency graph with all of them enabled is checked up front,
+// and plugins are applied in topological order, the required come first.
+func (m *PluginManager) InstallPlugins(ctx context.Context, pvs ...PluginVersion) error {
+	installed, err := m.ListInstalled(ctx, false)
+	if err != nil {
 		return err
 	}
+	available, err := m.ListRemote(ctx)
+	if err != nil {
+		return err
+	}
+	sorted, err := NewDependencyGraph(installed).InstallOrder(available, pvs...)
+	if err != nil {
+		return err
+	}
+	for _, pv := range sorted {
+		if err := m.apply(ctx, pv); err != nil {
+			return fmt.Errorf("install %s: %w", pv.Name, err)
+		}
+	}
+	return nil
+}
 
-	pv.Values = pluginsv1beta1.Values{Object: values}.FullFill()
+func (m *PluginManager) apply(ctx context.Context, pv PluginVersion) error {
 	apiplugin := pv.ToPlugin()
 	// all of plugins must install in installer namespace
 	apiplugin.Namespace = plugins.KubeGemsNamespaceInstaller
 
 	exists := apiplugin.DeepCopy()
-	_, err = controllerutil.CreateOrUpdate(ctx, m.Client, exists, func() error {
+	_, err := controllerutil.CreateOrUpdate(ctx, m.Client, exists, func() error {
 		if exists.Annotations == nil {
 			exists.Annotations = map[string]string{}
 		}
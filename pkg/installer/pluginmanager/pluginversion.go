@@ -24,6 +24,7 @@ import (
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	plugins "kubegems.io/kubegems/pkg/apis/plugins"
 	pluginsv1beta1 "kubegems.io/kubegems/pkg/apis/plugins/v1beta1"
+	"kubegems.io/kubegems/pkg/utils/generic"
 )
 
 type Requirement struct {
@@ -35,26 +36,28 @@ type Requirement struct {
 type Requirements []Requirement
 
 type PluginVersion struct {
-	Name             string                      `json:"name,omitempty"`
-	Namespace        string                      `json:"namespace,omitempty"`
-	Enabled          bool                        `json:"enabled,omitempty"`
-	InstallNamespace string                      `json:"installNamespace,omitempty"`
-	Kind             pluginsv1beta1.BundleKind   `json:"kind,omitempty"`
-	Description      string                      `json:"description,omitempty"`
-	HelathCheck      string                      `json:"helathCheck,omitempty"`
-	MainCategory     string                      `json:"mainCategory,omitempty"`
-	Category         string                      `json:"category,omitempty"`
-	Repository       string                      `json:"repository,omitempty"`
-	RepositoryName   string                      `json:"repositoryName,omitempty"`
-	Version          string                      `json:"version,omitempty"`
-	Healthy          bool                        `json:"healthy,omitempty"`
-	Required         bool                        `json:"required,omitempty"`
-	Requirements     Requirements                `json:"requirements,omitempty"` // dependecies requirements
-	Message          string                      `json:"message,omitempty"`
-	Values           pluginsv1beta1.Values       `json:"values,omitempty"`
-	Files            map[string]string           `json:"files,omitempty"`
-	ValuesFrom       []pluginsv1beta1.ValuesFrom `json:"valuesFrom,omitempty"`
-	Priority         int                         `json:"priority,omitempty"`
+	Name             string                          `json:"name,omitempty"`
+	Namespace        string                          `json:"namespace,omitempty"`
+	Enabled          bool                            `json:"enabled,omitempty"`
+	InstallNamespace string                          `json:"installNamespace,omitempty"`
+	Kind             pluginsv1beta1.BundleKind       `json:"kind,omitempty"`
+	Description      string                          `json:"description,omitempty"`
+	HelathCheck      string                          `json:"helathCheck,omitempty"`
+	MainCategory     string                          `json:"mainCategory,omitempty"`
+	Category         string                          `json:"category,omitempty"`
+	Repository       string                          `json:"repository,omitempty"`
+	RepositoryName   string                          `json:"repositoryName,omitempty"`
+	Version          string                          `json:"version,omitempty"`
+	Healthy          bool                            `json:"healthy,omitempty"`
+	Required         bool                            `json:"required,omitempty"`
+	Requirements     Requirements                    `json:"requirements,omitempty"` // dependecies requirements
+	Message          string                          `json:"message,omitempty"`
+	Values           pluginsv1beta1.Values           `json:"values,omitempty"`
+	Files            map[string]string               `json:"files,omitempty"`
+	ValuesFrom       []pluginsv1beta1.ValuesFrom     `json:"valuesFrom,omitempty"`
+	Priority         int                             `json:"priority,omitempty"`
+	Digest           string                          `json:"digest,omitempty"`
+	Signature        *pluginsv1beta1.BundleSignature `json:"signature,omitempty"`
 }
 
 func (pv PluginVersion) ToPlugin() *pluginsv1beta1.Plugin {
@@ -80,6 +83,11 @@ func (pv PluginVersion) ToPlugin() *pluginsv1beta1.Plugin {
 			Version:          pv.Version,
 			Values:           pv.Values,
 			ValuesFrom:       pv.ValuesFrom,
+			Digest:           pv.Digest,
+			Signature:        pv.Signature,
+			Requirements: generic.MapList(pv.Requirements, func(req Requirement) pluginsv1beta1.BundleRequirement {
+				return pluginsv1beta1.BundleRequirement{Name: req.Name, Version: strings.TrimSpace(req.Expr)}
+			}),
 		},
 	}
 }
@@ -106,6 +114,14 @@ func PluginVersionFrom(plugin pluginsv1beta1.Plugin) PluginVersion {
 		Values:           plugin.Spec.Values,
 		ValuesFrom:       plugin.Spec.ValuesFrom,
 		Required:         required,
+		Digest:           plugin.Spec.Digest,
+		Signature:        plugin.Spec.Signature,
+		Requirements: generic.MapList(plugin.Spec.Requirements, func(req pluginsv1beta1.BundleRequirement) Requirement {
+			if req.Version == "" {
+				return Requirement{Name: req.Name, Expr: "*"}
+			}
+			return Requirement{Name: req.Name, Expr: req.Version}
+		}),
 	}
 	if plugin.Status.Phase == pluginsv1beta1.PhaseInstalled {
 		pv.Healthy = true
@@ -150,6 +166,23 @@ func PluginVersionFromRepoChartVersion(repo *Repository, cv *repo.ChartVersion)
 	}
 	maincate, cate := parseCategory(annotations)
 	required, _ := strconv.ParseBool(annotations[plugins.AnnotationRequired])
+
+	digest := ""
+	if cv.Digest != "" {
+		// digest in helm index is the sha256 of chart archive
+		digest = "sha256:" + cv.Digest
+	}
+	// public key is configured in repository, never trust keys in index
+	var signature *pluginsv1beta1.BundleSignature
+	if repo.SignatureKind != "" && repo.PublicKey != "" {
+		signature = &pluginsv1beta1.BundleSignature{
+			Kind:      repo.SignatureKind,
+			PublicKey: repo.PublicKey,
+		}
+		if repo.SignatureKind == pluginsv1beta1.SignatureKindCosign {
+			signature.Signature = annotations[plugins.AnnotationSignature]
+		}
+	}
 	return PluginVersion{
 		Name:             cv.Name,
 		Repository:       repo.Address,
@@ -170,6 +203,8 @@ func PluginVersionFromRepoChartVersion(repo *Repository, cv *repo.ChartVersion)
 		}(),
 		MainCategory: maincate,
 		Category:     cate,
+		Digest:       digest,
+		Signature:    signature,
 	}
 }
 
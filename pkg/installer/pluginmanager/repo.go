@@ -27,6 +27,7 @@ import (
 	"k8s.io/apimachinery/pkg/api/errors"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"kubegems.io/kubegems/pkg/apis/plugins"
+	pluginsv1beta1 "kubegems.io/kubegems/pkg/apis/plugins/v1beta1"
 	"kubegems.io/kubegems/pkg/installer/bundle"
 	"kubegems.io/kubegems/pkg/installer/bundle/helm"
 	"kubegems.io/kubegems/pkg/log"
@@ -43,6 +44,10 @@ type Repository struct {
 	Static   bool                       `json:"static,omitempty"` // is static repository
 	Plugins  map[string][]PluginVersion `json:"plugins,omitempty"`
 	LastSync time.Time                  `json:"lastSync,omitempty"`
+
+	// SignatureKind and PublicKey are used to verify signatures of plugins in the repository
+	SignatureKind pluginsv1beta1.SignatureKind `json:"signatureKind,omitempty"`
+	PublicKey     string                       `json:"publicKey,omitempty"`
 }
 
 func (repository *Repository) RefreshRepoIndex(ctx context.Context) error {
@@ -174,6 +179,9 @@ func repoFromSecret(secret corev1.Secret) Repository {
 		Plugins:  plugins,
 		LastSync: lastsync,
 		Priority: priority,
+
+		SignatureKind: pluginsv1beta1.SignatureKind(secret.Data["signatureKind"]),
+		PublicKey:     string(secret.Data["publicKey"]),
 	}
 }
 
@@ -199,6 +207,8 @@ func (p *PluginManager) UpdateRepo(ctx context.Context, repo *Repository) error
 		reposecret.Data["plugins"] = pluginsraw
 		reposecret.Data["address"] = []byte(repo.Address)
 		reposecret.Data["lastSync"] = []byte(repo.LastSync.String())
+		reposecret.Data["signatureKind"] = []byte(repo.SignatureKind)
+		reposecret.Data["publicKey"] = []byte(repo.PublicKey)
 		return nil
 	})
 	return err
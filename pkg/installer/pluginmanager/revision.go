@@ -0,0 +1,66 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pluginmanager
+
+import (
+	"context"
+	"strconv"
+
+	"kubegems.io/kubegems/pkg/apis/plugins"
+	pluginsv1beta1 "kubegems.io/kubegems/pkg/apis/plugins/v1beta1"
+	"kubegems.io/kubegems/pkg/installer/controller"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Revisions lists the successful revisions of plugin, the newest is the last.
+func (m *PluginManager) Revisions(ctx context.Context, name string) ([]pluginsv1beta1.PluginRevision, error) {
+	plugin := &pluginsv1beta1.Plugin{}
+	if err := m.Client.Get(ctx, client.ObjectKey{Namespace: plugins.KubeGemsNamespaceInstaller, Name: name}, plugin); err != nil {
+		return nil, err
+	}
+	return plugin.Status.Revisions, nil
+}
+
+// GetRevision returns the revision of plugin with rendered manifests and values.
+func (m *PluginManager) GetRevision(ctx context.Context, name string, revision int64) (*controller.Revision, error) {
+	plugin := &pluginsv1beta1.Plugin{}
+	if err := m.Client.Get(ctx, client.ObjectKey{Namespace: plugins.KubeGemsNamespaceInstaller, Name: name}, plugin); err != nil {
+		return nil, err
+	}
+	return controller.GetRevision(ctx, m.Client, plugin, revision)
+}
+
+// Rollback restores the plugin spec to the revision and requests the controller to rollback,
+// the controller applies the stored manifests of the revision and records it as a new revision.
+func (m *PluginManager) Rollback(ctx context.Context, name string, revision int64) (*controller.Revision, error) {
+	plugin := &pluginsv1beta1.Plugin{}
+	if err := m.Client.Get(ctx, client.ObjectKey{Namespace: plugins.KubeGemsNamespaceInstaller, Name: name}, plugin); err != nil {
+		return nil, err
+	}
+	rev, err := controller.GetRevision(ctx, m.Client, plugin, revision)
+	if err != nil {
+		return nil, err
+	}
+	patch := client.MergeFrom(plugin.DeepCopy())
+	plugin.Spec = rev.Spec
+	if plugin.Annotations == nil {
+		plugin.Annotations = map[string]string{}
+	}
+	plugin.Annotations[plugins.AnnotationRollbackRevision] = strconv.FormatInt(revision, 10)
+	if err := m.Client.Patch(ctx, plugin, patch); err != nil {
+		return nil, err
+	}
+	return rev, nil
+}
@@ -0,0 +1,84 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package utils
+
+import (
+	"sort"
+	"strings"
+)
+
+// CycleError indicates a cycle in the dependency graph.
+type CycleError struct {
+	Cycle []string // e.g. [a b a]
+}
+
+func (e *CycleError) Error() string {
+	return "dependency cycle: " + strings.Join(e.Cycle, " -> ")
+}
+
+// TopologicalSort sorts nodes in edges(node -> dependencies) with dependencies first,
+// only nodes reachable from roots are sorted if roots specified.
+// a *CycleError is returned when there is a cycle.
+func TopologicalSort(edges map[string][]string, roots ...string) ([]string, error) {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	states := map[string]int{}
+	sorted := []string{}
+	path := []string{}
+
+	var visit func(node string) error
+	visit = func(node string) error {
+		switch states[node] {
+		case visited:
+			return nil
+		case visiting:
+			for i := range path {
+				if path[i] == node {
+					return &CycleError{Cycle: append(append([]string{}, path[i:]...), node)}
+				}
+			}
+		}
+		states[node] = visiting
+		path = append(path, node)
+		dependencies := append([]string{}, edges[node]...)
+		sort.Strings(dependencies)
+		for _, dep := range dependencies {
+			if err := visit(dep); err != nil {
+				return err
+			}
+		}
+		path = path[:len(path)-1]
+		states[node] = visited
+		sorted = append(sorted, node)
+		return nil
+	}
+
+	if len(roots) == 0 {
+		for node := range edges {
+			roots = append(roots, node)
+		}
+	}
+	roots = append([]string{}, roots...)
+	sort.Strings(roots)
+	for _, node := range roots {
+		if err := visit(node); err != nil {
+			return nil, err
+		}
+	}
+	return sorted, nil
+}
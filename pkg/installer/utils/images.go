@@ -0,0 +1,43 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package utils
+
+// FindImages finds container images in a resource,
+// images in pod templates of workloads and crds are found by "containers" and "initContainers".
+func FindImages(obj any, into map[string]struct{}) {
+	switch val := obj.(type) {
+	case map[string]any:
+		for k, v := range val {
+			switch k {
+			case "containers", "initContainers", "ephemeralContainers":
+				if containers, ok := v.([]any); ok {
+					for _, container := range containers {
+						if c, ok := container.(map[string]any); ok {
+							if image, ok := c["image"].(string); ok && image != "" {
+								into[image] = struct{}{}
+							}
+						}
+					}
+				}
+			default:
+				FindImages(v, into)
+			}
+		}
+	case []any:
+		for _, item := range val {
+			FindImages(item, into)
+		}
+	}
+}
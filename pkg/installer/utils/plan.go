@@ -0,0 +1,169 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package utils
+
+import (
+	"context"
+
+	"github.com/pmezard/go-difflib/difflib"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"kubegems.io/kubegems/pkg/apis/plugins"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+)
+
+type DiffAction string
+
+const (
+	DiffActionCreate DiffAction = "Create"
+	DiffActionUpdate DiffAction = "Update"
+	DiffActionDelete DiffAction = "Delete"
+	DiffActionNone   DiffAction = "None"
+)
+
+type ResourceDiff struct {
+	ManagedResource `json:",inline"`
+	Action          DiffAction `json:"action"`
+	Diff            string     `json:"diff,omitempty"`  // unified diff from live to target in yaml
+	Error           string     `json:"error,omitempty"` // error on dry-run
+}
+
+// PlanDiff computes per-resource diff between live objects and the objects after applied.
+// the objects after applied are got from server side apply dry-run, nothing changed in cluster.
+func PlanDiff(ctx context.Context, cli client.Client, diff DiffResult, options *SyncOptions) []ResourceDiff {
+	ret := []ResourceDiff{}
+	for _, item := range append(append([]*unstructured.Unstructured{}, diff.Creats...), diff.Applys...) {
+		ret = append(ret, planApply(ctx, cli, item, options))
+	}
+	for _, item := range diff.Removes {
+		if IsCRD(item) && !options.CleanCRD {
+			continue
+		}
+		ret = append(ret, planRemove(ctx, cli, item))
+	}
+	return ret
+}
+
+func planApply(ctx context.Context, cli client.Client, item *unstructured.Unstructured, options *SyncOptions) ResourceDiff {
+	result := ResourceDiff{ManagedResource: GetReference(item)}
+	live, err := getLive(ctx, cli, item)
+	if err != nil {
+		result.Error = err.Error()
+	}
+	if live != nil && IsSkipedOn(item, plugins.AnnotationIgnoreOptionOnUpdate) {
+		result.Action = DiffActionNone
+		return result
+	}
+	target := item.DeepCopy()
+	if err := dryRunApply(ctx, cli, target, live != nil, options); err != nil {
+		// use the rendered object as target
+		target, result.Error = item.DeepCopy(), err.Error()
+	}
+	from, to := normalizedYAML(live), normalizedYAML(target)
+	switch {
+	case live == nil:
+		result.Action = DiffActionCreate
+	case from == to:
+		result.Action = DiffActionNone
+		return result
+	default:
+		result.Action = DiffActionUpdate
+	}
+	result.Diff = UnifiedDiff(from, to)
+	return result
+}
+
+func planRemove(ctx context.Context, cli client.Client, item *unstructured.Unstructured) ResourceDiff {
+	result := ResourceDiff{ManagedResource: GetReference(item), Action: DiffActionDelete}
+	live, err := getLive(ctx, cli, item)
+	if err != nil {
+		result.Error = err.Error()
+	}
+	if live == nil {
+		result.Action = DiffActionNone
+		return result
+	}
+	if IsSkipedOn(live, plugins.AnnotationIgnoreOptionOnDelete) {
+		result.Action = DiffActionNone
+		return result
+	}
+	result.Diff = UnifiedDiff(normalizedYAML(live), "")
+	return result
+}
+
+// getLive returns nil if not found
+func getLive(ctx context.Context, cli client.Client, item *unstructured.Unstructured) (*unstructured.Unstructured, error) {
+	live := &unstructured.Unstructured{}
+	live.SetGroupVersionKind(item.GroupVersionKind())
+	if err := cli.Get(ctx, client.ObjectKeyFromObject(item), live); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return live, nil
+}
+
+func dryRunApply(ctx context.Context, cli client.Client, obj *unstructured.Unstructured, exists bool, options *SyncOptions) error {
+	if !exists {
+		return cli.Create(ctx, obj, client.DryRunAll)
+	}
+	if !options.ServerSideApply {
+		// no way to dry-run a strategic merge without the live object, show the rendered object
+		return nil
+	}
+	obj.SetManagedFields(nil)
+	return cli.Patch(ctx, obj, client.Apply, client.DryRunAll, client.FieldOwner("bundler"), client.ForceOwnership)
+}
+
+// normalizedYAML removes fields managed by server.
+func normalizedYAML(obj *unstructured.Unstructured) string {
+	if obj == nil {
+		return ""
+	}
+	obj = obj.DeepCopy()
+	for _, field := range [][]string{
+		{"status"},
+		{"metadata", "managedFields"},
+		{"metadata", "resourceVersion"},
+		{"metadata", "uid"},
+		{"metadata", "generation"},
+		{"metadata", "creationTimestamp"},
+		{"metadata", "selfLink"},
+		{"metadata", "annotations", "kubectl.kubernetes.io/last-applied-configuration"},
+	} {
+		unstructured.RemoveNestedField(obj.Object, field...)
+	}
+	if len(obj.GetAnnotations()) == 0 {
+		unstructured.RemoveNestedField(obj.Object, "metadata", "annotations")
+	}
+	content, err := yaml.Marshal(obj.Object)
+	if err != nil {
+		return ""
+	}
+	return string(content)
+}
+
+func UnifiedDiff(from, to string) string {
+	diff, _ := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(from),
+		B:        difflib.SplitLines(to),
+		FromFile: "live",
+		ToFile:   "target",
+		Context:  3,
+	})
+	return diff
+}
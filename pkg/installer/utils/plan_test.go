@@ -0,0 +1,119 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package utils
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/scheme"
+	"kubegems.io/kubegems/pkg/apis/plugins"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestPlanDiff(t *testing.T) {
+	cli := fake.NewClientBuilder().WithScheme(scheme.Scheme).WithObjects(
+		testConfigMap("same", "1", ""),
+		testConfigMap("changed", "1", ""),
+		testConfigMap("ignore-update", "1", ""),
+		testConfigMap("removed", "1", ""),
+		testConfigMap("ignore-delete", "1", plugins.AnnotationIgnoreOptionOnDelete),
+	).Build()
+
+	diff := DiffResult{
+		Creats: []*unstructured.Unstructured{
+			testConfigMapUnstructured(t, "created", "1", ""),
+		},
+		Applys: []*unstructured.Unstructured{
+			testConfigMapUnstructured(t, "same", "1", ""),
+			testConfigMapUnstructured(t, "changed", "2", ""),
+			testConfigMapUnstructured(t, "ignore-update", "2", plugins.AnnotationIgnoreOptionOnUpdate),
+		},
+		Removes: []*unstructured.Unstructured{
+			testConfigMapUnstructured(t, "removed", "1", ""),
+			testConfigMapUnstructured(t, "ignore-delete", "1", ""),
+			testConfigMapUnstructured(t, "not-exists", "1", ""),
+		},
+	}
+	want := map[string]DiffAction{
+		"created":       DiffActionCreate,
+		"same":          DiffActionNone,
+		"changed":       DiffActionUpdate,
+		"ignore-update": DiffActionNone,
+		"removed":       DiffActionDelete,
+		"ignore-delete": DiffActionNone,
+		"not-exists":    DiffActionNone,
+	}
+	for _, options := range []*SyncOptions{NewDefaultSyncOptions(), {ServerSideApply: false}} {
+		got := PlanDiff(context.Background(), cli, diff, options)
+		if len(got) != len(want) {
+			t.Fatalf("PlanDiff() got %d resources, want %d", len(got), len(want))
+		}
+		for _, res := range got {
+			if res.Action != want[res.Name] {
+				t.Errorf("PlanDiff() action of %s = %v, want %v", res.Name, res.Action, want[res.Name])
+			}
+			if res.Kind != "ConfigMap" || res.Namespace != "default" {
+				t.Errorf("PlanDiff() unexpected reference %v", res.ManagedResource)
+			}
+			if res.Error != "" {
+				t.Errorf("PlanDiff() error of %s: %s", res.Name, res.Error)
+			}
+			switch res.Name {
+			case "changed":
+				if !strings.Contains(res.Diff, `-  key: "1"`) || !strings.Contains(res.Diff, `+  key: "2"`) {
+					t.Errorf("PlanDiff() unexpected diff of %s:\n%s", res.Name, res.Diff)
+				}
+			case "created":
+				if !strings.Contains(res.Diff, "+kind: ConfigMap") {
+					t.Errorf("PlanDiff() unexpected diff of %s:\n%s", res.Name, res.Diff)
+				}
+			case "removed":
+				if !strings.Contains(res.Diff, "-kind: ConfigMap") {
+					t.Errorf("PlanDiff() unexpected diff of %s:\n%s", res.Name, res.Diff)
+				}
+			default:
+				if res.Diff != "" {
+					t.Errorf("PlanDiff() want no diff of %s, got:\n%s", res.Name, res.Diff)
+				}
+			}
+		}
+	}
+}
+
+func testConfigMap(name, value, ignore string) *corev1.ConfigMap {
+	cm := &corev1.ConfigMap{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "ConfigMap"},
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+		Data:       map[string]string{"key": value},
+	}
+	if ignore != "" {
+		cm.Annotations = map[string]string{plugins.AnnotationIgnoreOptions: ignore}
+	}
+	return cm
+}
+
+func testConfigMapUnstructured(t *testing.T, name, value, ignore string) *unstructured.Unstructured {
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(testConfigMap(name, value, ignore))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &unstructured.Unstructured{Object: content}
+}
@@ -55,6 +55,7 @@ func (s *Server) RemoveRepository(w http.ResponseWriter, r *http.Request) {
 		BadRequest(w, err.Error())
 	} else {
 		OK(w, "")
+		s.notify(s.OnRemove, r)
 	}
 }
 
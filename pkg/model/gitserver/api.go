@@ -14,6 +14,521 @@
 
 package gitserver
 
-import "net/http"
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
 
-func (s *Server) ListFiles(w http.ResponseWriter, r *http.Request) {}
+	"kubegems.io/kubegems/pkg/log"
+)
+
+const (
+	defaultCommitsLimit = 20
+	maxContentSize      = 1 << 20 // content of larger files are not returned
+	maxDiffSize         = 1 << 20
+	lfsPointerPrefix    = "version https://git-lfs.github.com/spec/v1"
+	lfsPointerMaxSize   = 1024
+)
+
+var ErrInvalidRevision = errors.New("invalid revision")
+
+type Ref struct {
+	Name string `json:"name"`
+	Type string `json:"type"` // branch or tag
+	Hash string `json:"hash"`
+}
+
+type TreeEntry struct {
+	Name string `json:"name"`
+	Path string `json:"path"`
+	Type string `json:"type"` // blob, tree or commit(submodule)
+	Mode string `json:"mode"`
+	Hash string `json:"hash"`
+	Size int64  `json:"size"`
+}
+
+type FileContent struct {
+	Path     string      `json:"path"`
+	Hash     string      `json:"hash"`
+	Size     int64       `json:"size"`
+	Binary   bool        `json:"binary,omitempty"`
+	Content  string      `json:"content,omitempty"` // empty if binary or too large
+	LFS      *LFSPointer `json:"lfs,omitempty"`
+	Download *Link       `json:"download,omitempty"` // download link of the lfs object
+}
+
+type LFSPointer struct {
+	OID  string `json:"oid"`
+	Size int64  `json:"size"`
+}
+
+type Commit struct {
+	Hash        string    `json:"hash"`
+	Author      string    `json:"author"`
+	Email       string    `json:"email"`
+	Date        time.Time `json:"date"`
+	Message     string    `json:"message"`
+	ParentHashs []string  `json:"parents,omitempty"`
+}
+
+type DiffFile struct {
+	Path      string `json:"path"`
+	Additions int    `json:"additions"` // -1 for binary files
+	Deletions int    `json:"deletions"`
+}
+
+type Diff struct {
+	From      string     `json:"from"`
+	To        string     `json:"to"`
+	Files     []DiffFile `json:"files"`
+	Patch     string     `json:"patch,omitempty"`
+	Truncated bool       `json:"truncated,omitempty"`
+}
+
+// ListRefs lists branches and tags.
+func (s *Server) ListRefs(w http.ResponseWriter, r *http.Request) {
+	out, err := GitOutput(r.Context(), s.repositoryDir(r),
+		"for-each-ref", "--format=%(refname)%00%(objectname)", "refs/heads", "refs/tags")
+	if err != nil {
+		gitError(w, err)
+		return
+	}
+	refs := []Ref{}
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		name, hash, ok := strings.Cut(line, "\x00")
+		if !ok {
+			continue
+		}
+		switch {
+		case strings.HasPrefix(name, "refs/heads/"):
+			refs = append(refs, Ref{Name: strings.TrimPrefix(name, "refs/heads/"), Type: "branch", Hash: hash})
+		case strings.HasPrefix(name, "refs/tags/"):
+			refs = append(refs, Ref{Name: strings.TrimPrefix(name, "refs/tags/"), Type: "tag", Hash: hash})
+		}
+	}
+	OK(w, refs)
+}
+
+// ListFiles lists the tree at ?ref= and ?path=, ref defaults to HEAD.
+func (s *Server) ListFiles(w http.ResponseWriter, r *http.Request) {
+	ref, dir, err := refAndPath(r)
+	if err != nil {
+		BadRequest(w, err.Error())
+		return
+	}
+	treeish := ref
+	if dir != "" {
+		treeish = ref + ":" + dir
+	}
+	out, err := GitOutput(r.Context(), s.repositoryDir(r), "ls-tree", "-z", "-l", treeish)
+	if err != nil {
+		gitError(w, err)
+		return
+	}
+	entries := []TreeEntry{}
+	for _, line := range strings.Split(string(out), "\x00") {
+		// <mode> SP <type> SP <object> SP <object size> TAB <file>
+		meta, name, ok := strings.Cut(line, "\t")
+		if !ok {
+			continue
+		}
+		fields := strings.Fields(meta)
+		if len(fields) != 4 {
+			continue
+		}
+		size, _ := strconv.ParseInt(fields[3], 10, 64)
+		entries = append(entries, TreeEntry{
+			Name: name,
+			Path: path.Join(dir, name),
+			Mode: fields[0],
+			Type: fields[1],
+			Hash: fields[2],
+			Size: size,
+		})
+	}
+	OK(w, entries)
+}
+
+// GetFileContent returns the file at ?ref= and ?path=, lfs pointers are resolved to the object and its download link.
+// the raw content is returned with ?raw=true.
+func (s *Server) GetFileContent(w http.ResponseWriter, r *http.Request) {
+	ref, filename, err := refAndPath(r)
+	if err != nil {
+		BadRequest(w, err.Error())
+		return
+	}
+	if filename == "" {
+		BadRequest(w, "path is required")
+		return
+	}
+	ctx, wd, object := r.Context(), s.repositoryDir(r), ref+":"+filename
+	hash, err := GitOutput(ctx, wd, "rev-parse", "--verify", "--quiet", object)
+	if err != nil {
+		gitError(w, err)
+		return
+	}
+	if objtype, err := GitOutput(ctx, wd, "cat-file", "-t", object); err != nil {
+		gitError(w, err)
+		return
+	} else if t := strings.TrimSpace(string(objtype)); t != "blob" {
+		BadRequest(w, fmt.Sprintf("%s is a %s", filename, t))
+		return
+	}
+	sizeout, err := GitOutput(ctx, wd, "cat-file", "-s", object)
+	if err != nil {
+		gitError(w, err)
+		return
+	}
+	size, _ := strconv.ParseInt(strings.TrimSpace(string(sizeout)), 10, 64)
+
+	if raw, _ := strconv.ParseBool(r.URL.Query().Get("raw")); raw {
+		cmd := gitCommand(ctx, wd, "cat-file", "blob", object)
+		stdout, stderr := &countWriter{Writer: w}, &bytes.Buffer{}
+		cmd.Stdout, cmd.Stderr = stdout, stderr
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+		if err := cmd.Run(); err != nil {
+			err = &GitError{Args: cmd.Args[1:], Stderr: stderr.String(), Err: err}
+			if stdout.n == 0 {
+				// nothing sent, the error can still be responded
+				w.Header().Del("Content-Length")
+				gitError(w, err)
+				return
+			}
+			// client finds the body shorter than Content-Length
+			log.Error(err, "write raw content", "path", filename)
+		}
+		return
+	}
+
+	file := &FileContent{Path: filename, Hash: strings.TrimSpace(string(hash)), Size: size}
+	if size > maxContentSize {
+		OK(w, file)
+		return
+	}
+	content, err := GitOutput(ctx, wd, "cat-file", "blob", object)
+	if err != nil {
+		gitError(w, err)
+		return
+	}
+	if pointer := ParseLFSPointer(content); pointer != nil {
+		file.LFS = pointer
+		if s.LFS != nil {
+			if link, err := s.LFS.Download(ctx, s.RepositoryPath(r), pointer.OID); err == nil {
+				file.Download = s.absLink(r, link)
+			}
+		}
+		OK(w, file)
+		return
+	}
+	if !utf8.Valid(content) || bytes.IndexByte(content, 0) >= 0 {
+		file.Binary = true
+	} else {
+		file.Content = string(content)
+	}
+	OK(w, file)
+}
+
+// ListCommits lists commits of ?ref=, only commits changed ?path= if set.
+func (s *Server) ListCommits(w http.ResponseWriter, r *http.Request) {
+	ref, filename, err := refAndPath(r)
+	if err != nil {
+		BadRequest(w, err.Error())
+		return
+	}
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	if limit <= 0 {
+		limit = defaultCommitsLimit
+	}
+	skip, _ := strconv.Atoi(r.URL.Query().Get("skip"))
+	args := []string{
+		"log", "--format=%H%x00%an%x00%ae%x00%at%x00%P%x00%B%x1e",
+		"-n", strconv.Itoa(limit), "--skip", strconv.Itoa(skip), ref, "--",
+	}
+	if filename != "" {
+		args = append(args, filename)
+	}
+	out, err := GitOutput(r.Context(), s.repositoryDir(r), args...)
+	if err != nil {
+		gitError(w, err)
+		return
+	}
+	commits := []Commit{}
+	for _, record := range strings.Split(string(out), "\x1e") {
+		fields := strings.SplitN(strings.TrimLeft(record, "\n"), "\x00", 6)
+		if len(fields) != 6 {
+			continue
+		}
+		ts, _ := strconv.ParseInt(fields[3], 10, 64)
+		commits = append(commits, Commit{
+			Hash:        fields[0],
+			Author:      fields[1],
+			Email:       fields[2],
+			Date:        time.Unix(ts, 0),
+			ParentHashs: strings.Fields(fields[4]),
+			Message:     strings.TrimSpace(fields[5]),
+		})
+	}
+	OK(w, commits)
+}
+
+// Diff shows changes between ?from= and ?to=.
+func (s *Server) Diff(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	from, to := query.Get("from"), query.Get("to")
+	if to == "" {
+		to = "HEAD"
+	}
+	if !validRevision(to) || (from != "" && !validRevision(from)) {
+		BadRequest(w, ErrInvalidRevision.Error())
+		return
+	}
+	ctx, wd := r.Context(), s.repositoryDir(r)
+	if from == "" {
+		parent, err := parentOrEmptyTree(ctx, wd, to)
+		if err != nil {
+			gitError(w, err)
+			return
+		}
+		from = parent
+	}
+	numstat, err := GitOutput(ctx, wd, "diff", "--numstat", "-z", from, to, "--")
+	if err != nil {
+		gitError(w, err)
+		return
+	}
+	diff := &Diff{From: from, To: to, Files: parseNumstat(numstat)}
+	patch, truncated, err := GitOutputLimit(ctx, wd, maxDiffSize, "diff", from, to, "--")
+	if err != nil {
+		gitError(w, err)
+		return
+	}
+	diff.Patch, diff.Truncated = string(patch), truncated
+	OK(w, diff)
+}
+
+// parentOrEmptyTree returns the first parent of the commit, the empty tree for a root commit.
+func parentOrEmptyTree(ctx context.Context, wd string, rev string) (string, error) {
+	if _, err := GitOutput(ctx, wd, "rev-parse", "--verify", rev+"^{commit}"); err != nil {
+		return "", err
+	}
+	if _, err := GitOutput(ctx, wd, "rev-parse", "--verify", "--quiet", rev+"^"); err == nil {
+		return rev + "^", nil
+	}
+	// hash of the empty tree depends on the object format of repository
+	emptytree, err := GitOutput(ctx, wd, "hash-object", "-t", "tree", "--stdin")
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(emptytree)), nil
+}
+
+// parseNumstat parses output of "git diff --numstat -z"
+func parseNumstat(out []byte) []DiffFile {
+	files := []DiffFile{}
+	fields := strings.Split(string(out), "\x00")
+	for i := 0; i < len(fields); i++ {
+		// <added> TAB <deleted> TAB <path>, renamed has an empty path followed by <src> and <dst>
+		parts := strings.SplitN(fields[i], "\t", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		file := DiffFile{Path: parts[2], Additions: -1, Deletions: -1}
+		if parts[2] == "" && i+2 < len(fields) {
+			file.Path = fields[i+2]
+			i += 2
+		}
+		if parts[0] != "-" {
+			file.Additions, _ = strconv.Atoi(parts[0])
+			file.Deletions, _ = strconv.Atoi(parts[1])
+		}
+		files = append(files, file)
+	}
+	return files
+}
+
+// ParseLFSPointer returns nil if content is not a lfs pointer file.
+// https://github.com/git-lfs/git-lfs/blob/main/docs/spec.md#the-pointer
+func ParseLFSPointer(content []byte) *LFSPointer {
+	if len(content) > lfsPointerMaxSize || !bytes.HasPrefix(content, []byte(lfsPointerPrefix)) {
+		return nil
+	}
+	pointer := &LFSPointer{}
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		key, value, _ := strings.Cut(scanner.Text(), " ")
+		switch key {
+		case "oid":
+			pointer.OID = strings.TrimPrefix(value, "sha256:")
+		case "size":
+			pointer.Size, _ = strconv.ParseInt(value, 10, 64)
+		}
+	}
+	if !ValidOID(pointer.OID) {
+		return nil
+	}
+	return pointer
+}
+
+func refAndPath(r *http.Request) (string, string, error) {
+	query := r.URL.Query()
+	ref := query.Get("ref")
+	if ref == "" {
+		ref = "HEAD"
+	}
+	if !validRevision(ref) {
+		return "", "", ErrInvalidRevision
+	}
+	p := strings.Trim(query.Get("path"), "/")
+	if p != "" {
+		p = path.Clean(p)
+	}
+	if p == "." || strings.HasPrefix(p, "../") || p == ".." {
+		p = ""
+	}
+	return ref, p, nil
+}
+
+// validRevision avoids revisions treated as options or containing pathes.
+func validRevision(rev string) bool {
+	return rev != "" && !strings.HasPrefix(rev, "-") && !strings.ContainsAny(rev, ": \t\n\x00")
+}
+
+func (s *Server) repositoryDir(r *http.Request) string {
+	return filepath.Join(s.GitBase, s.RepositoryPath(r))
+}
+
+type GitError struct {
+	Args   []string
+	Stderr string
+	Err    error
+}
+
+func (e *GitError) Error() string {
+	return fmt.Sprintf("git %s: %v: %s", strings.Join(e.Args, " "), e.Err, strings.TrimSpace(e.Stderr))
+}
+
+func (e *GitError) Unwrap() error {
+	return e.Err
+}
+
+// messages of git failing on missing repositories, revisions or pathes
+var gitNotFoundMessages = []string{
+	"not a git repository",
+	"not a valid object name",
+	"bad revision",
+	"bad object",
+	"unknown revision",
+	"needed a single revision",
+	"does not exist",
+	"exists on disk, but not in",
+}
+
+// IsGitNotFound reports whether the repository, revision or path git working on is not found.
+func IsGitNotFound(err error) bool {
+	// the repository directory not exists
+	if errors.Is(err, fs.ErrNotExist) {
+		return true
+	}
+	gerr := &GitError{}
+	if !errors.As(err, &gerr) {
+		return false
+	}
+	var exiterr *exec.ExitError
+	if !errors.As(gerr.Err, &exiterr) {
+		return false
+	}
+	stderr := strings.ToLower(gerr.Stderr)
+	for _, message := range gitNotFoundMessages {
+		if strings.Contains(stderr, message) {
+			return true
+		}
+	}
+	return false
+}
+
+func gitCommand(ctx context.Context, wd string, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = wd
+	// messages are matched in IsGitNotFound
+	cmd.Env = append(os.Environ(), "LC_ALL=C")
+	return cmd
+}
+
+// GitOutput runs git and returns the stdout, stderr is in the error.
+func GitOutput(ctx context.Context, wd string, args ...string) ([]byte, error) {
+	cmd := gitCommand(ctx, wd, args...)
+	stderr := &bytes.Buffer{}
+	cmd.Stderr = stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, &GitError{Args: args, Stderr: stderr.String(), Err: err}
+	}
+	return out, nil
+}
+
+// GitOutputLimit runs git and returns at most limit bytes of the stdout,
+// git is stopped once the stdout exceeds the limit and truncated is true.
+func GitOutputLimit(ctx context.Context, wd string, limit int64, args ...string) ([]byte, bool, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	cmd := gitCommand(ctx, wd, args...)
+	stderr := &bytes.Buffer{}
+	cmd.Stderr = stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, false, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, false, &GitError{Args: args, Stderr: stderr.String(), Err: err}
+	}
+	out, readerr := io.ReadAll(io.LimitReader(stdout, limit+1))
+	truncated := int64(len(out)) > limit
+	if truncated {
+		// the rest output is not needed
+		cancel()
+	}
+	if err := cmd.Wait(); err != nil && !truncated {
+		return nil, false, &GitError{Args: args, Stderr: stderr.String(), Err: err}
+	}
+	if readerr != nil {
+		return nil, false, readerr
+	}
+	if truncated {
+		out = out[:limit]
+	}
+	return out, truncated, nil
+}
+
+type countWriter struct {
+	io.Writer
+	n int64
+}
+
+func (w *countWriter) Write(p []byte) (int, error) {
+	n, err := w.Writer.Write(p)
+	w.n += int64(n)
+	return n, err
+}
+
+// gitError responds 404 on unknown repositories, revisions or pathes.
+func gitError(w http.ResponseWriter, err error) {
+	if IsGitNotFound(err) {
+		NotFound(w)
+		return
+	}
+	InternalServerError(w, err.Error())
+}
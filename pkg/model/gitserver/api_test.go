@@ -0,0 +1,201 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gitserver
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+const testLFSPointer = `version https://git-lfs.github.com/spec/v1
+oid sha256:4d7a214614ab2935c943f9e0ff69d22eadbb8f32b1258daaa5e2ca24d17e2393
+size 12345
+`
+
+func setupTestRepository(t *testing.T, gitbase string) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not found")
+	}
+	bare := filepath.Join(gitbase, "user", "model.git")
+	work := t.TempDir()
+	run := func(dir string, args ...string) {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(), "GIT_AUTHOR_NAME=tester", "GIT_AUTHOR_EMAIL=tester@example.com",
+			"GIT_COMMITTER_NAME=tester", "GIT_COMMITTER_EMAIL=tester@example.com")
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v: %s", args, err, out)
+		}
+	}
+	if err := os.MkdirAll(bare, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	run(bare, "init", "--bare", "--initial-branch=main", ".")
+	run(work, "init", "--initial-branch=main", ".")
+	os.WriteFile(filepath.Join(work, "README.md"), []byte("# model\n"), 0o644)
+	run(work, "add", ".")
+	run(work, "commit", "-m", "init")
+	os.MkdirAll(filepath.Join(work, "weights"), 0o755)
+	os.WriteFile(filepath.Join(work, "weights", "model.bin"), []byte(testLFSPointer), 0o644)
+	os.WriteFile(filepath.Join(work, "README.md"), []byte("# model\n\nmodel card\n"), 0o644)
+	run(work, "add", ".")
+	run(work, "commit", "-m", "add weights")
+	run(work, "tag", "v1")
+	run(work, "push", bare, "main", "v1")
+}
+
+func TestServer_Browse(t *testing.T) {
+	s := &Server{GitBase: t.TempDir(), LFS: &LocalContentManager{Dir: t.TempDir()}}
+	setupTestRepository(t, s.GitBase)
+	srv := httptest.NewServer(s.routes(true, false))
+	defer srv.Close()
+
+	get := func(path string, into any) int {
+		resp, err := http.Get(srv.URL + "/user/model" + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if into != nil && resp.StatusCode == http.StatusOK {
+			if err := json.NewDecoder(resp.Body).Decode(into); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return resp.StatusCode
+	}
+
+	refs := []Ref{}
+	if get("/refs", &refs); len(refs) != 2 {
+		t.Errorf("refs: want branch and tag, got %v", refs)
+	}
+	files := []TreeEntry{}
+	if get("/files?ref=main", &files); len(files) != 2 {
+		t.Errorf("files: want 2 entries, got %v", files)
+	}
+	if get("/files?ref=v1&path=weights", &files); len(files) != 1 || files[0].Path != "weights/model.bin" {
+		t.Errorf("files in weights: got %v", files)
+	}
+	if code := get("/files?ref=notexists", nil); code != http.StatusNotFound {
+		t.Errorf("files of unknown ref: want 404, got %d", code)
+	}
+	if code := get("/files?ref=--output=/tmp/x", nil); code != http.StatusBadRequest {
+		t.Errorf("files of invalid ref: want 400, got %d", code)
+	}
+
+	readme := &FileContent{}
+	if get("/contents?path=README.md", readme); readme.Content != "# model\n\nmodel card\n" {
+		t.Errorf("readme: got %q", readme.Content)
+	}
+	weights := &FileContent{}
+	if get("/contents?path=weights/model.bin", weights); weights.LFS == nil || weights.LFS.Size != 12345 {
+		t.Errorf("lfs pointer: got %v", weights.LFS)
+	}
+
+	commits := []Commit{}
+	if get("/commits?ref=main", &commits); len(commits) != 2 || commits[0].Message != "add weights" {
+		t.Errorf("commits: got %v", commits)
+	}
+	if get("/commits?path=weights", &commits); len(commits) != 1 {
+		t.Errorf("commits of weights: got %v", commits)
+	}
+
+	diff := &Diff{}
+	if get("/diff?from=main~1&to=main", diff); len(diff.Files) != 2 || diff.Patch == "" {
+		t.Errorf("diff: got %v", diff.Files)
+	}
+	rootdiff := &Diff{}
+	if code := get("/diff?to=main~1", rootdiff); code != http.StatusOK || len(rootdiff.Files) != 1 || rootdiff.Files[0].Path != "README.md" {
+		t.Errorf("diff of root commit: got %d %v", code, rootdiff.Files)
+	}
+	if code := get("/diff?to=notexists", nil); code != http.StatusNotFound {
+		t.Errorf("diff of unknown ref: want 404, got %d", code)
+	}
+	if resp, err := http.Get(srv.URL + "/user/notexists/refs"); err != nil {
+		t.Fatal(err)
+	} else if resp.Body.Close(); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("refs of unknown repository: want 404, got %d", resp.StatusCode)
+	}
+
+	resp, err := http.Get(srv.URL + "/user/model/contents?path=README.md&raw=true")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if raw, _ := io.ReadAll(resp.Body); resp.StatusCode != http.StatusOK || string(raw) != "# model\n\nmodel card\n" {
+		t.Errorf("raw readme: got %d %q", resp.StatusCode, raw)
+	}
+}
+
+func TestGitOutputLimit(t *testing.T) {
+	gitbase := t.TempDir()
+	setupTestRepository(t, gitbase)
+	wd := filepath.Join(gitbase, "user", "model.git")
+	ctx := context.Background()
+
+	full, err := GitOutput(ctx, wd, "diff", "main~1", "main", "--")
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, truncated, err := GitOutputLimit(ctx, wd, int64(len(full)), "diff", "main~1", "main", "--")
+	if err != nil || truncated || string(out) != string(full) {
+		t.Errorf("within limit: got %q %v %v", out, truncated, err)
+	}
+	out, truncated, err = GitOutputLimit(ctx, wd, 10, "diff", "main~1", "main", "--")
+	if err != nil || !truncated || string(out) != string(full[:10]) {
+		t.Errorf("exceeds limit: got %q %v %v", out, truncated, err)
+	}
+	if _, _, err := GitOutputLimit(ctx, wd, 10, "diff", "notexists", "--"); !IsGitNotFound(err) {
+		t.Errorf("unknown revision: want not found, got %v", err)
+	}
+}
+
+func TestGitError(t *testing.T) {
+	gitbase := t.TempDir()
+	setupTestRepository(t, gitbase)
+	wd := filepath.Join(gitbase, "user", "model.git")
+	ctx := context.Background()
+
+	tests := []struct {
+		name string
+		wd   string
+		args []string
+		want int
+	}{
+		{name: "unknown repository", wd: filepath.Join(gitbase, "user", "notexists.git"), args: []string{"for-each-ref"}, want: http.StatusNotFound},
+		{name: "unknown revision", wd: wd, args: []string{"ls-tree", "notexists"}, want: http.StatusNotFound},
+		{name: "unknown path", wd: wd, args: []string{"rev-parse", "main:notexists"}, want: http.StatusNotFound},
+		{name: "other failures", wd: wd, args: []string{"notacommand"}, want: http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := GitOutput(ctx, tt.wd, tt.args...)
+			if err == nil {
+				t.Fatal("want error")
+			}
+			rec := httptest.NewRecorder()
+			gitError(rec, err)
+			if rec.Code != tt.want {
+				t.Errorf("want %d, got %d: %v", tt.want, rec.Code, err)
+			}
+		})
+	}
+}
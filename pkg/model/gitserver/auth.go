@@ -0,0 +1,188 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gitserver
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+type Permission string
+
+// admin implies write, write implies read
+const (
+	PermissionRead  Permission = "read"
+	PermissionWrite Permission = "write"
+	PermissionAdmin Permission = "admin"
+)
+
+const (
+	anonymousUser = "anonymous"
+	authRealm     = "kubegems"
+)
+
+var ErrUnauthorized = errors.New("unauthorized")
+
+// Authenticator authenticates the request user.
+type Authenticator interface {
+	// BasicAuth returns the username of the basic auth credentials
+	BasicAuth(ctx context.Context, username, password string) (string, error)
+	// BearerAuth returns the username of the bearer token
+	BearerAuth(ctx context.Context, token string) (string, error)
+}
+
+// Authorizer decides whether the user has the permission on the repository,
+// username is empty for anonymous requests.
+type Authorizer interface {
+	Authorize(ctx context.Context, username string, repository string, permission Permission) (bool, error)
+}
+
+type usernameContextKey struct{}
+
+// Username returns the name of the authenticated request user,
+// anonymous is returned if no authentication configured.
+func (s *Server) Username(r *http.Request) string {
+	if username, _ := r.Context().Value(usernameContextKey{}).(string); username != "" {
+		return username
+	}
+	return anonymousUser
+}
+
+// Authorized wraps the handler with authentication and authorization of the required permission.
+func (s *Server) Authorized(permission Permission, h http.HandlerFunc) http.HandlerFunc {
+	return s.AuthorizedBy(func(*http.Request) Permission { return permission }, h)
+}
+
+// AuthorizedBy wraps the handler, the required permission is decided by the request.
+func (s *Server) AuthorizedBy(permissionof func(r *http.Request) Permission, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.Authc == nil {
+			h(w, r)
+			return
+		}
+		ctx := r.Context()
+		username, err := s.authenticate(r)
+		if err != nil {
+			unauthorized(w, r, err.Error())
+			return
+		}
+		if s.Authz != nil {
+			allowed, err := s.Authz.Authorize(ctx, username, s.repositoryName(r), permissionof(r))
+			if err != nil {
+				InternalServerError(w, err.Error())
+				return
+			}
+			if !allowed {
+				if username == "" {
+					// let client retry with credentials
+					unauthorized(w, r, "authentication required")
+				} else {
+					forbidden(w, r, "permission denied")
+				}
+				return
+			}
+		} else if username == "" {
+			unauthorized(w, r, "authentication required")
+			return
+		}
+		if username != "" {
+			r = r.WithContext(context.WithValue(ctx, usernameContextKey{}, username))
+		}
+		h(w, r)
+	}
+}
+
+// Allowed checks the additional permission of the request user in handlers wrapped by Authorized,
+// all permissions are allowed if no authentication or authorization configured.
+func (s *Server) Allowed(r *http.Request, permission Permission) (bool, error) {
+	if s.Authc == nil || s.Authz == nil {
+		return true, nil
+	}
+	username, _ := r.Context().Value(usernameContextKey{}).(string)
+	return s.Authz.Authorize(r.Context(), username, s.repositoryName(r), permission)
+}
+
+// authenticate returns empty username if no credentials in request.
+func (s *Server) authenticate(r *http.Request) (string, error) {
+	ctx := r.Context()
+	if username, password, ok := r.BasicAuth(); ok {
+		return s.Authc.BasicAuth(ctx, username, password)
+	}
+	if authorization := r.Header.Get("Authorization"); strings.HasPrefix(authorization, "Bearer ") {
+		return s.Authc.BearerAuth(ctx, strings.TrimPrefix(authorization, "Bearer "))
+	}
+	return "", nil
+}
+
+// repositoryName returns "{username}/{repository}"
+func (s *Server) repositoryName(r *http.Request) string {
+	vars := mux.Vars(r)
+	return path.Join(vars["username"], vars["repository"])
+}
+
+func isLFSRequest(r *http.Request) bool {
+	return strings.Contains(r.URL.Path, "/info/lfs/")
+}
+
+// https://github.com/git-lfs/git-lfs/blob/main/docs/api/authentication.md
+func unauthorized(w http.ResponseWriter, r *http.Request, message string) {
+	challenge := `Basic realm="` + authRealm + `"`
+	if isLFSRequest(r) {
+		w.Header().Set("LFS-Authenticate", challenge)
+		w.Header().Set("WWW-Authenticate", challenge)
+		lfsResponse(w, http.StatusUnauthorized, BatchError{Message: message})
+		return
+	}
+	RawResponse(w, http.StatusUnauthorized, map[string]string{"WWW-Authenticate": challenge}, message)
+}
+
+func forbidden(w http.ResponseWriter, r *http.Request, message string) {
+	if isLFSRequest(r) {
+		lfsResponse(w, http.StatusForbidden, BatchError{Message: message})
+		return
+	}
+	RawResponse(w, http.StatusForbidden, nil, message)
+}
+
+// GitServicePermission requires write permission on push.
+func GitServicePermission(r *http.Request) Permission {
+	if r.URL.Query().Get("service") == "git-receive-pack" || strings.HasSuffix(r.URL.Path, "/git-receive-pack") {
+		return PermissionWrite
+	}
+	return PermissionRead
+}
+
+// LFSBatchPermission requires write permission on upload operation,
+// the body is read and put back for the handler, an oversized body is truncated and fails in the handler.
+func LFSBatchPermission(r *http.Request) Permission {
+	body, err := io.ReadAll(http.MaxBytesReader(nil, r.Body, lfsBatchMaxBodySize))
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return PermissionWrite
+	}
+	batch := &Batch{}
+	if err := json.Unmarshal(body, batch); err != nil || batch.Operation != OperationDownload {
+		return PermissionWrite
+	}
+	return PermissionRead
+}
@@ -0,0 +1,129 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gitserver
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type fakeAuthenticator map[string]string
+
+func (a fakeAuthenticator) BasicAuth(ctx context.Context, username, password string) (string, error) {
+	if a[username] != password {
+		return "", ErrUnauthorized
+	}
+	return username, nil
+}
+
+func (a fakeAuthenticator) BearerAuth(ctx context.Context, token string) (string, error) {
+	return a.BasicAuth(ctx, token, token)
+}
+
+type fakeAuthorizer map[string]Permission
+
+func (a fakeAuthorizer) Authorize(ctx context.Context, username, repository string, permission Permission) (bool, error) {
+	granted, ok := a[username+"@"+repository]
+	if !ok {
+		return false, nil
+	}
+	levels := map[Permission]int{PermissionRead: 1, PermissionWrite: 2, PermissionAdmin: 3}
+	return levels[granted] >= levels[permission], nil
+}
+
+func TestServer_Authorized(t *testing.T) {
+	s := &Server{
+		GitBase: t.TempDir(),
+		LFS:     &LocalContentManager{Dir: t.TempDir()},
+		Locks:   NewLocalLockManager(t.TempDir()),
+		Authc:   fakeAuthenticator{"alice": "alice-pass", "bob": "bob-pass"},
+		Authz:   fakeAuthorizer{"alice@team/model": PermissionWrite, "bob@team/model": PermissionRead, "@team/public": PermissionRead},
+	}
+	srv := httptest.NewServer(s.routes(true, false))
+	defer srv.Close()
+
+	batch, _ := json.Marshal(Batch{Operation: OperationUpload, Objects: []BatchObject{}})
+	tests := []struct {
+		name      string
+		user      string
+		password  string
+		method    string
+		path      string
+		body      []byte
+		wantCode  int
+		wantLFSWA bool
+	}{
+		{name: "anonymous on private repo", method: http.MethodGet, path: "/team/model.git/info/lfs/locks", wantCode: http.StatusUnauthorized, wantLFSWA: true},
+		{name: "anonymous on public repo", method: http.MethodGet, path: "/team/public.git/info/lfs/locks", wantCode: http.StatusOK},
+		{name: "wrong password", user: "alice", password: "bad", method: http.MethodGet, path: "/team/model.git/info/lfs/locks", wantCode: http.StatusUnauthorized, wantLFSWA: true},
+		{name: "reader list locks", user: "bob", password: "bob-pass", method: http.MethodGet, path: "/team/model.git/info/lfs/locks", wantCode: http.StatusOK},
+		{name: "reader upload", user: "bob", password: "bob-pass", method: http.MethodPost, path: "/team/model.git/info/lfs/objects/batch", body: batch, wantCode: http.StatusForbidden},
+		{name: "writer upload", user: "alice", password: "alice-pass", method: http.MethodPost, path: "/team/model.git/info/lfs/objects/batch", body: batch, wantCode: http.StatusOK},
+		{name: "writer delete repository", user: "alice", password: "alice-pass", method: http.MethodDelete, path: "/team/model", wantCode: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest(tt.method, srv.URL+tt.path, bytes.NewReader(tt.body))
+			req.Header.Set("Accept", mimeGitLFSJSON)
+			req.Header.Set("Content-Type", mimeGitLFSJSON)
+			if tt.user != "" {
+				req.SetBasicAuth(tt.user, tt.password)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.wantCode {
+				t.Errorf("want status %d, got %d", tt.wantCode, resp.StatusCode)
+			}
+			if tt.wantLFSWA && resp.Header.Get("LFS-Authenticate") == "" {
+				t.Errorf("want LFS-Authenticate header")
+			}
+		})
+	}
+}
+
+func TestLFSBatchPermission(t *testing.T) {
+	download, _ := json.Marshal(Batch{Operation: OperationDownload})
+	oversized := append(append([]byte{}, download[:len(download)-1]...), bytes.Repeat([]byte(" "), lfsBatchMaxBodySize)...)
+	oversized = append(oversized, '}')
+	tests := []struct {
+		name string
+		body []byte
+		want Permission
+	}{
+		{name: "download", body: download, want: PermissionRead},
+		{name: "upload", body: []byte(`{"operation":"upload"}`), want: PermissionWrite},
+		{name: "oversized download", body: oversized, want: PermissionWrite},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/team/model.git/info/lfs/objects/batch", bytes.NewReader(tt.body))
+			if got := LFSBatchPermission(req); got != tt.want {
+				t.Errorf("LFSBatchPermission() = %v, want %v", got, tt.want)
+			}
+			// the body is put back for the handler, limited in size
+			body := &bytes.Buffer{}
+			if _, err := body.ReadFrom(req.Body); err != nil || body.Len() > lfsBatchMaxBodySize {
+				t.Errorf("body put back: %d bytes, %v", body.Len(), err)
+			}
+		})
+	}
+}
@@ -34,12 +34,25 @@ func NewDefaultOptions() *Options {
 type Server struct {
 	GitBase string
 	LFS     LFSMetaManager
+	Locks   LFSLockManager // locks are stored in git repository if not set
+	Authc   Authenticator  // no authentication if not set
+	Authz   Authorizer     // any authenticated user has all permissions if not set
+	// TrustedProxies are ips or cidrs of the reverse proxies,
+	// X-Forwarded-Host and X-Forwarded-Proto are ignored if the request is not from them.
+	TrustedProxies []string
+	// OnPush is called after objects received, repository is "{username}/{repository}"
+	OnPush func(ctx context.Context, repository string)
+	// OnRemove is called after the repository removed
+	OnRemove func(ctx context.Context, repository string)
 }
 
 func (s *Server) Run(ctx context.Context, opts *Options) error {
 	if opts == nil {
 		opts = NewDefaultOptions()
 	}
+	if s.Locks == nil {
+		s.Locks = NewLocalLockManager(s.GitBase)
+	}
 	httpserver := &http.Server{
 		Addr:    opts.Listen,
 		Handler: s.routes(s.LFS != nil, opts.UseGitHTTPBackend),
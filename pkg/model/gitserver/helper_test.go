@@ -0,0 +1,47 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gitserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRawResponse(t *testing.T) {
+	tests := []struct {
+		name            string
+		code            int
+		body            interface{}
+		wantContentType string
+	}{
+		{name: "string", code: http.StatusBadRequest, body: "bad request", wantContentType: "text/plain"},
+		{name: "bytes", code: http.StatusOK, body: []byte("ok"), wantContentType: "text/plain; charset=utf-8"},
+		{name: "nil", code: http.StatusNotFound, body: nil},
+		{name: "json", code: http.StatusBadRequest, body: map[string]string{"message": "bad request"}, wantContentType: "application/json"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			RawResponse(rec, tt.code, nil, tt.body)
+			if rec.Code != tt.code {
+				t.Errorf("RawResponse() code = %d, want %d", rec.Code, tt.code)
+			}
+			if got := rec.Header().Get("Content-Type"); got != tt.wantContentType {
+				t.Errorf("RawResponse() Content-Type = %q, want %q", got, tt.wantContentType)
+			}
+		})
+	}
+}
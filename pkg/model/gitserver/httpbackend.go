@@ -19,6 +19,7 @@ import (
 	"net/http"
 	"net/http/cgi"
 	"os/exec"
+	"strings"
 )
 
 func (s *Server) GitHTTPBackend(w http.ResponseWriter, r *http.Request) {
@@ -37,5 +38,20 @@ func (s *Server) GitHTTPBackend(w http.ResponseWriter, r *http.Request) {
 		},
 		Logger: log.Default(),
 	}
-	h.ServeHTTP(w, r)
+	rw := &statusRecorder{ResponseWriter: w, code: http.StatusOK}
+	h.ServeHTTP(rw, r)
+	if r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, "/git-receive-pack") && rw.code == http.StatusOK {
+		s.notify(s.OnPush, r)
+	}
+}
+
+// statusRecorder records the status code written by cgi handler.
+type statusRecorder struct {
+	http.ResponseWriter
+	code int
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	r.code = code
+	r.ResponseWriter.WriteHeader(code)
 }
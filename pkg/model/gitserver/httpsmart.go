@@ -17,6 +17,7 @@ package gitserver
 import (
 	"bytes"
 	"compress/gzip"
+	"context"
 	"fmt"
 	"io"
 	"net/http"
@@ -77,15 +78,27 @@ func (s *Server) UploadPack(w http.ResponseWriter, r *http.Request) {
 
 func (s *Server) ReceivePack(w http.ResponseWriter, r *http.Request) {
 	wd := filepath.Join(s.GitBase, s.RepositoryPath(r))
-	GitServiceCall(w, r, wd, "receive-pack", "--stateless-rpc", ".")
+	if err := GitServiceCall(w, r, wd, "receive-pack", "--stateless-rpc", "."); err != nil {
+		return
+	}
+	s.notify(s.OnPush, r)
 }
 
-func GitServiceCall(w http.ResponseWriter, r *http.Request, repopath, servicename string, args ...string) {
+// notify calls the hook with the repository, it runs in background and never blocks the response.
+func (s *Server) notify(hook func(ctx context.Context, repository string), r *http.Request) {
+	if hook == nil {
+		return
+	}
+	// request context is canceled after the response
+	go hook(context.Background(), s.repositoryName(r))
+}
+
+func GitServiceCall(w http.ResponseWriter, r *http.Request, repopath, servicename string, args ...string) error {
 	if r.Header.Get("Content-Encoding") == "gzip" {
 		reqBody, err := gzip.NewReader(r.Body)
 		if err != nil {
 			InternalServerError(w, err.Error())
-			return
+			return err
 		}
 		r.Body = reqBody
 	}
@@ -106,8 +119,9 @@ func GitServiceCall(w http.ResponseWriter, r *http.Request, repopath, servicenam
 	cmd.Stdin, cmd.Stdout, cmd.Stderr = r.Body, w, os.Stderr
 	if err := cmd.Run(); err != nil {
 		InternalServerError(w, err.Error())
-		return
+		return err
 	}
+	return nil
 }
 
 func UpperCaseAndUnderscore(r rune) rune {
@@ -0,0 +1,74 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gitserver
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServer_ReceivePackOnPush(t *testing.T) {
+	pushed := make(chan string, 1)
+	s := &Server{
+		GitBase: t.TempDir(),
+		OnPush:  func(ctx context.Context, repository string) { pushed <- repository },
+	}
+	setupTestRepository(t, s.GitBase)
+	srv := httptest.NewServer(s.routes(false, false))
+	defer srv.Close()
+
+	// failed push
+	resp, err := http.Post(srv.URL+"/user/model.git/git-receive-pack", "application/x-git-receive-pack-request", strings.NewReader("invalid"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	select {
+	case name := <-pushed:
+		t.Fatalf("OnPush called on failed push: %s", name)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	work := t.TempDir()
+	run := func(args ...string) {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = work
+		cmd.Env = append(os.Environ(), "GIT_AUTHOR_NAME=tester", "GIT_AUTHOR_EMAIL=tester@example.com",
+			"GIT_COMMITTER_NAME=tester", "GIT_COMMITTER_EMAIL=tester@example.com")
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v: %s", args, err, out)
+		}
+	}
+	run("clone", srv.URL+"/user/model.git", ".")
+	os.WriteFile(filepath.Join(work, "config.json"), []byte("{}"), 0o644)
+	run("add", ".")
+	run("commit", "-m", "add config")
+	run("push", "origin", "main")
+	select {
+	case name := <-pushed:
+		if name != "user/model" {
+			t.Errorf("OnPush repository = %s, want user/model", name)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("OnPush not called after push")
+	}
+}
@@ -0,0 +1,111 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gitserver
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// LocalContentManager stores lfs objects in local filesystem,
+// objects are stored as "{dir}/{repository}/{oid[0:2]}/{oid[2:4]}/{oid}".
+type LocalContentManager struct {
+	Dir string
+}
+
+func NewLocalContentManager(dir string) (*LocalContentManager, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &LocalContentManager{Dir: dir}, nil
+}
+
+func (m *LocalContentManager) objectPath(repo, oid string) string {
+	return filepath.Join(m.Dir, repo, oid[0:2], oid[2:4], oid)
+}
+
+func objectHref(repo, oid string) string {
+	return "/" + path.Join(repo, "info/lfs/objects", oid)
+}
+
+func (m *LocalContentManager) Upload(ctx context.Context, repo string, oid string) (*Link, error) {
+	if _, err := os.Stat(m.objectPath(repo, oid)); err == nil {
+		return nil, nil
+	}
+	return &Link{Href: objectHref(repo, oid)}, nil
+}
+
+func (m *LocalContentManager) Download(ctx context.Context, repo string, oid string) (*Link, error) {
+	if _, err := os.Stat(m.objectPath(repo, oid)); err != nil {
+		return nil, err
+	}
+	return &Link{Href: objectHref(repo, oid)}, nil
+}
+
+func (m *LocalContentManager) Verify(ctx context.Context, repo string, oid string) (*BatchObject, error) {
+	fi, err := os.Stat(m.objectPath(repo, oid))
+	if err != nil {
+		return nil, err
+	}
+	return &BatchObject{OID: oid, Size: fi.Size()}, nil
+}
+
+func (m *LocalContentManager) Delete(ctx context.Context, repo string, oid string) error {
+	return os.Remove(m.objectPath(repo, oid))
+}
+
+func (m *LocalContentManager) Get(ctx context.Context, repo string, oid string) (io.ReadCloser, int64, error) {
+	f, err := os.Open(m.objectPath(repo, oid))
+	if err != nil {
+		return nil, 0, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, 0, err
+	}
+	return f, fi.Size(), nil
+}
+
+// Put writes content into a temporary file and moves it to the object path after the oid verified.
+func (m *LocalContentManager) Put(ctx context.Context, repo string, oid string, content io.Reader) error {
+	objpath := m.objectPath(repo, oid)
+	if err := os.MkdirAll(filepath.Dir(objpath), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(objpath), oid+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(tmp, h), content); err != nil {
+		return err
+	}
+	if hex.EncodeToString(h.Sum(nil)) != oid {
+		return ErrOIDMismatch
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), objpath)
+}
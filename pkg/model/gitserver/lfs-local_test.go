@@ -0,0 +1,176 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gitserver
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestServer_LFSBasicTransfer(t *testing.T) {
+	lfs, err := NewLocalContentManager(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{GitBase: t.TempDir(), LFS: lfs, Locks: NewLocalLockManager(t.TempDir())}
+	srv := httptest.NewServer(s.routes(true, false))
+	defer srv.Close()
+
+	content := []byte("model weights")
+	sum := sha256.Sum256(content)
+	oid := hex.EncodeToString(sum[:])
+
+	batch := func(operation string) BatchObject {
+		body, _ := json.Marshal(Batch{Operation: operation, Objects: []BatchObject{{OID: oid, Size: int64(len(content))}}})
+		req, _ := http.NewRequest(http.MethodPost, srv.URL+"/user/repo.git/info/lfs/objects/batch", bytes.NewReader(body))
+		req.Header.Set("Accept", mimeGitLFSJSON)
+		req.Header.Set("Content-Type", mimeGitLFSJSON)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		ret := &Batch{}
+		if err := json.NewDecoder(resp.Body).Decode(ret); err != nil {
+			t.Fatal(err)
+		}
+		return ret.Objects[0]
+	}
+
+	if obj := batch(OperationDownload); obj.Error == nil || obj.Error.Code != http.StatusNotFound {
+		t.Fatalf("download missing object: want 404 error, got %v", obj.Error)
+	}
+
+	obj := batch(OperationUpload)
+	upload, ok := obj.Actions["upload"]
+	if !ok {
+		t.Fatalf("no upload action: %v", obj)
+	}
+	// wrong content
+	req, _ := http.NewRequest(http.MethodPut, upload.Href, bytes.NewReader([]byte("other")))
+	if resp, err := http.DefaultClient.Do(req); err != nil || resp.StatusCode != http.StatusUnprocessableEntity {
+		t.Fatalf("upload mismatched content: want 422, got %v %v", resp.StatusCode, err)
+	}
+	req, _ = http.NewRequest(http.MethodPut, upload.Href, bytes.NewReader(content))
+	if resp, err := http.DefaultClient.Do(req); err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("upload: want 200, got %v %v", resp.StatusCode, err)
+	}
+
+	verifybody, _ := json.Marshal(BatchObject{OID: oid, Size: int64(len(content))})
+	req, _ = http.NewRequest(http.MethodPost, obj.Actions["verify"].Href, bytes.NewReader(verifybody))
+	req.Header.Set("Accept", mimeGitLFSJSON)
+	req.Header.Set("Content-Type", mimeGitLFSJSON)
+	if resp, err := http.DefaultClient.Do(req); err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("verify: want 200, got %v %v", resp.StatusCode, err)
+	}
+
+	if obj := batch(OperationUpload); len(obj.Actions) != 0 {
+		t.Fatalf("upload existing object: want no actions, got %v", obj.Actions)
+	}
+	download, ok := batch(OperationDownload).Actions["download"]
+	if !ok {
+		t.Fatal("no download action")
+	}
+	resp, err := http.Get(download.Href)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if got, _ := io.ReadAll(resp.Body); !bytes.Equal(got, content) {
+		t.Fatalf("download: want %q, got %q", content, got)
+	}
+}
+
+func TestServer_LFSLocks(t *testing.T) {
+	s := &Server{
+		GitBase: t.TempDir(),
+		LFS:     &LocalContentManager{Dir: t.TempDir()},
+		Locks:   NewLocalLockManager(t.TempDir()),
+		Authc:   fakeAuthenticator{"alice": "alice-pass", "bob": "bob-pass", "carol": "carol-pass"},
+		Authz:   fakeAuthorizer{"alice@user/repo": PermissionWrite, "bob@user/repo": PermissionWrite, "carol@user/repo": PermissionAdmin},
+	}
+	srv := httptest.NewServer(s.routes(true, false))
+	defer srv.Close()
+
+	base := srv.URL + "/user/repo.git/info/lfs/locks"
+	do := func(user, method, url string, body any, into any) int {
+		content, _ := json.Marshal(body)
+		req, _ := http.NewRequest(method, url, bytes.NewReader(content))
+		req.SetBasicAuth(user, user+"-pass")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if into != nil {
+			json.NewDecoder(resp.Body).Decode(into)
+		}
+		return resp.StatusCode
+	}
+
+	created := &LockResponse{}
+	if code := do("alice", http.MethodPost, base, LockRequest{Path: "model.bin"}, created); code != http.StatusCreated {
+		t.Fatalf("create lock: want 201, got %d", code)
+	}
+	if code := do("bob", http.MethodPost, base, LockRequest{Path: "model.bin"}, nil); code != http.StatusConflict {
+		t.Fatalf("create existing lock: want 409, got %d", code)
+	}
+
+	list := &LockListResponse{}
+	if code := do("bob", http.MethodGet, base+"?path=model.bin", nil, list); code != http.StatusOK || len(list.Locks) != 1 {
+		t.Fatalf("list locks: got %d %v", code, list.Locks)
+	}
+	verify := &LockListResponse{}
+	if do("bob", http.MethodPost, base+"/verify", LockListRequest{}, verify); len(verify.Ours) != 0 || len(verify.Theirs) != 1 {
+		t.Fatalf("verify locks of bob: got ours %v theirs %v", verify.Ours, verify.Theirs)
+	}
+
+	if created.Lock.Owner == nil || created.Lock.Owner.Name != "alice" {
+		t.Fatalf("lock owner: want alice, got %v", created.Lock.Owner)
+	}
+
+	unlock := base + "/" + created.Lock.ID + "/unlock"
+	// the owner is the authenticated user, not the username sent
+	spoofed, _ := http.NewRequest(http.MethodPost, unlock, bytes.NewReader([]byte("{}")))
+	spoofed.SetBasicAuth("alice", "bob-pass")
+	if resp, err := http.DefaultClient.Do(spoofed); err != nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("unlock with spoofed owner: want 401, got %v %v", resp.StatusCode, err)
+	}
+	if code := do("bob", http.MethodPost, unlock, LockRequest{}, nil); code != http.StatusForbidden {
+		t.Fatalf("unlock by others: want 403, got %d", code)
+	}
+	if code := do("bob", http.MethodPost, unlock, LockRequest{Force: true}, nil); code != http.StatusForbidden {
+		t.Fatalf("force unlock without admin: want 403, got %d", code)
+	}
+	if code := do("alice", http.MethodPost, unlock, LockRequest{}, nil); code != http.StatusOK {
+		t.Fatalf("unlock by owner: want 200, got %d", code)
+	}
+	if code := do("alice", http.MethodPost, unlock, LockRequest{}, nil); code != http.StatusNotFound {
+		t.Fatalf("unlock again: want 404, got %d", code)
+	}
+
+	if code := do("alice", http.MethodPost, base, LockRequest{Path: "model.bin"}, created); code != http.StatusCreated {
+		t.Fatalf("create lock again: want 201, got %d", code)
+	}
+	if code := do("carol", http.MethodPost, base+"/"+created.Lock.ID+"/unlock", LockRequest{Force: true}, nil); code != http.StatusOK {
+		t.Fatalf("force unlock by admin: want 200, got %d", code)
+	}
+}
@@ -0,0 +1,336 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gitserver
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// https://github.com/git-lfs/git-lfs/blob/main/docs/api/locking.md
+
+const (
+	defaultLocksLimit = 100
+)
+
+// nolint: tagliatelle
+type Lock struct {
+	ID       string     `json:"id"`
+	Path     string     `json:"path"`
+	LockedAt time.Time  `json:"locked_at"`
+	Owner    *LockOwner `json:"owner,omitempty"`
+}
+
+type LockOwner struct {
+	Name string `json:"name"`
+}
+
+type LockRequest struct {
+	Path  string   `json:"path,omitempty"`
+	Ref   BatchRef `json:"ref,omitempty"`
+	Force bool     `json:"force,omitempty"` // unlock only
+}
+
+// nolint: tagliatelle
+type LockListRequest struct {
+	Cursor string   `json:"cursor,omitempty"`
+	Limit  int      `json:"limit,omitempty"`
+	Ref    BatchRef `json:"ref,omitempty"`
+}
+
+type LockResponse struct {
+	Lock    *Lock  `json:"lock,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+// nolint: tagliatelle
+type LockListResponse struct {
+	Locks      []Lock `json:"locks,omitempty"`
+	Ours       []Lock `json:"ours,omitempty"`
+	Theirs     []Lock `json:"theirs,omitempty"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+type LockExistsError struct {
+	Lock *Lock
+}
+
+func (e *LockExistsError) Error() string {
+	return fmt.Sprintf("%s is already locked by %s", e.Lock.Path, e.Lock.Owner.Name)
+}
+
+type LFSLockManager interface {
+	// Create creates a lock on the path, a LockExistsError returned if the path is locked.
+	Create(ctx context.Context, repo string, lock Lock) (*Lock, error)
+	// List lists all locks sorted by lock time.
+	List(ctx context.Context, repo string) ([]Lock, error)
+	// Delete removes the lock by id, fs.ErrNotExist returned if not found.
+	Delete(ctx context.Context, repo string, id string) (*Lock, error)
+}
+
+func (s *Server) LFSCreateLock(w http.ResponseWriter, r *http.Request) {
+	req := &LockRequest{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil || req.Path == "" {
+		lfsResponse(w, http.StatusBadRequest, LockResponse{Message: "invalid request, path is required"})
+		return
+	}
+	lock, err := s.Locks.Create(r.Context(), s.RepositoryPath(r), Lock{
+		Path:     req.Path,
+		LockedAt: time.Now().UTC().Truncate(time.Second),
+		Owner:    &LockOwner{Name: s.Username(r)},
+	})
+	if err != nil {
+		if existserr, ok := err.(*LockExistsError); ok {
+			lfsResponse(w, http.StatusConflict, LockResponse{Lock: existserr.Lock, Message: err.Error()})
+			return
+		}
+		lfsResponse(w, http.StatusInternalServerError, LockResponse{Message: err.Error()})
+		return
+	}
+	lfsResponse(w, http.StatusCreated, LockResponse{Lock: lock})
+}
+
+func (s *Server) LFSListLocks(w http.ResponseWriter, r *http.Request) {
+	locks, err := s.Locks.List(r.Context(), s.RepositoryPath(r))
+	if err != nil {
+		lfsResponse(w, http.StatusInternalServerError, LockResponse{Message: err.Error()})
+		return
+	}
+	query := r.URL.Query()
+	filtered := make([]Lock, 0, len(locks))
+	for _, lock := range locks {
+		if path := query.Get("path"); path != "" && lock.Path != path {
+			continue
+		}
+		if id := query.Get("id"); id != "" && lock.ID != id {
+			continue
+		}
+		filtered = append(filtered, lock)
+	}
+	limit, _ := strconv.Atoi(query.Get("limit"))
+	page, next, err := pageLocks(filtered, query.Get("cursor"), limit)
+	if err != nil {
+		lfsResponse(w, http.StatusBadRequest, LockResponse{Message: err.Error()})
+		return
+	}
+	OK(w, LockListResponse{Locks: page, NextCursor: next})
+}
+
+// LFSVerifyLocks lists locks of the current user as "ours" and others as "theirs".
+func (s *Server) LFSVerifyLocks(w http.ResponseWriter, r *http.Request) {
+	req := &LockListRequest{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		lfsResponse(w, http.StatusBadRequest, LockResponse{Message: err.Error()})
+		return
+	}
+	locks, err := s.Locks.List(r.Context(), s.RepositoryPath(r))
+	if err != nil {
+		lfsResponse(w, http.StatusInternalServerError, LockResponse{Message: err.Error()})
+		return
+	}
+	page, next, err := pageLocks(locks, req.Cursor, req.Limit)
+	if err != nil {
+		lfsResponse(w, http.StatusBadRequest, LockResponse{Message: err.Error()})
+		return
+	}
+	username := s.Username(r)
+	resp := LockListResponse{Ours: []Lock{}, Theirs: []Lock{}, NextCursor: next}
+	for _, lock := range page {
+		if lock.Owner != nil && lock.Owner.Name == username {
+			resp.Ours = append(resp.Ours, lock)
+		} else {
+			resp.Theirs = append(resp.Theirs, lock)
+		}
+	}
+	OK(w, resp)
+}
+
+// LFSUnlock removes the lock, only the owner can unlock it unless force by an admin.
+func (s *Server) LFSUnlock(w http.ResponseWriter, r *http.Request) {
+	req := &LockRequest{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		lfsResponse(w, http.StatusBadRequest, LockResponse{Message: err.Error()})
+		return
+	}
+	ctx, repopath, id := r.Context(), s.RepositoryPath(r), mux.Vars(r)["id"]
+	locks, err := s.Locks.List(ctx, repopath)
+	if err != nil {
+		lfsResponse(w, http.StatusInternalServerError, LockResponse{Message: err.Error()})
+		return
+	}
+	var lock *Lock
+	for i := range locks {
+		if locks[i].ID == id {
+			lock = &locks[i]
+			break
+		}
+	}
+	if lock == nil {
+		lfsResponse(w, http.StatusNotFound, LockResponse{Message: "lock not found"})
+		return
+	}
+	if username := s.Username(r); lock.Owner == nil || lock.Owner.Name != username {
+		if !req.Force {
+			lfsResponse(w, http.StatusForbidden, LockResponse{Lock: lock, Message: "lock is owned by others, use force to unlock"})
+			return
+		}
+		allowed, err := s.Allowed(r, PermissionAdmin)
+		if err != nil {
+			lfsResponse(w, http.StatusInternalServerError, LockResponse{Message: err.Error()})
+			return
+		}
+		if !allowed {
+			lfsResponse(w, http.StatusForbidden, LockResponse{Lock: lock, Message: "force unlock requires admin permission"})
+			return
+		}
+	}
+	deleted, err := s.Locks.Delete(ctx, repopath, id)
+	if err != nil {
+		lfsResponse(w, lfsErrorCode(err), LockResponse{Message: err.Error()})
+		return
+	}
+	OK(w, LockResponse{Lock: deleted})
+}
+
+// pageLocks use the offset as cursor
+func pageLocks(locks []Lock, cursor string, limit int) ([]Lock, string, error) {
+	offset := 0
+	if cursor != "" {
+		i, err := strconv.Atoi(cursor)
+		if err != nil || i < 0 {
+			return nil, "", fmt.Errorf("invalid cursor: %s", cursor)
+		}
+		offset = i
+	}
+	if limit <= 0 {
+		limit = defaultLocksLimit
+	}
+	if offset >= len(locks) {
+		return []Lock{}, "", nil
+	}
+	end := offset + limit
+	if end >= len(locks) {
+		return locks[offset:], "", nil
+	}
+	return locks[offset:end], strconv.Itoa(end), nil
+}
+
+// LocalLockManager stores locks of a repository in "{dir}/{repository}/lfs/locks.json".
+type LocalLockManager struct {
+	Dir string
+	mu  sync.Mutex
+}
+
+func NewLocalLockManager(dir string) *LocalLockManager {
+	return &LocalLockManager{Dir: dir}
+}
+
+func (m *LocalLockManager) Create(ctx context.Context, repo string, lock Lock) (*Lock, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	locks, err := m.load(repo)
+	if err != nil {
+		return nil, err
+	}
+	for i := range locks {
+		if locks[i].Path == lock.Path {
+			return nil, &LockExistsError{Lock: &locks[i]}
+		}
+	}
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	lock.ID = hex.EncodeToString(id)
+	locks = append(locks, lock)
+	if err := m.save(repo, locks); err != nil {
+		return nil, err
+	}
+	return &lock, nil
+}
+
+func (m *LocalLockManager) List(ctx context.Context, repo string) ([]Lock, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.load(repo)
+}
+
+func (m *LocalLockManager) Delete(ctx context.Context, repo string, id string) (*Lock, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	locks, err := m.load(repo)
+	if err != nil {
+		return nil, err
+	}
+	for i, lock := range locks {
+		if lock.ID != id {
+			continue
+		}
+		if err := m.save(repo, append(locks[:i:i], locks[i+1:]...)); err != nil {
+			return nil, err
+		}
+		return &lock, nil
+	}
+	return nil, fs.ErrNotExist
+}
+
+func (m *LocalLockManager) filename(repo string) string {
+	return filepath.Join(m.Dir, repo, "lfs", "locks.json")
+}
+
+func (m *LocalLockManager) load(repo string) ([]Lock, error) {
+	content, err := os.ReadFile(m.filename(repo))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []Lock{}, nil
+		}
+		return nil, err
+	}
+	locks := []Lock{}
+	if err := json.Unmarshal(content, &locks); err != nil {
+		return nil, err
+	}
+	sort.SliceStable(locks, func(i, j int) bool { return locks[i].LockedAt.Before(locks[j].LockedAt) })
+	return locks, nil
+}
+
+func (m *LocalLockManager) save(repo string, locks []Lock) error {
+	filename := m.filename(repo)
+	if err := os.MkdirAll(filepath.Dir(filename), 0o755); err != nil {
+		return err
+	}
+	content, err := json.Marshal(locks)
+	if err != nil {
+		return err
+	}
+	tmp := filename + ".tmp"
+	if err := os.WriteFile(tmp, content, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filename)
+}
@@ -16,10 +16,15 @@ package gitserver
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
 	"path"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
@@ -106,13 +111,32 @@ func (m *S3ContentManager) Download(ctx context.Context, dir string, oid string)
 	}, nil
 }
 
-func (m *S3ContentManager) Verify(ctx context.Context, path string, oid string) (*BatchObject, error) {
-	headresult, err := m.s3cli.HeadObject(ctx, &s3.HeadObjectInput{})
+func (m *S3ContentManager) Verify(ctx context.Context, dir string, oid string) (*BatchObject, error) {
+	headresult, err := m.s3cli.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(m.options.Bucket),
+		Key:    aws.String(path.Join(dir, oid)),
+	})
 	if err != nil {
-		return nil, err
+		return nil, s3NotFoundAsNotExist(err)
 	}
 	return &BatchObject{
 		OID:  oid,
 		Size: headresult.ContentLength,
 	}, nil
 }
+
+func (m *S3ContentManager) Delete(ctx context.Context, dir string, oid string) error {
+	_, err := m.s3cli.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(m.options.Bucket),
+		Key:    aws.String(path.Join(dir, oid)),
+	})
+	return s3NotFoundAsNotExist(err)
+}
+
+func s3NotFoundAsNotExist(err error) error {
+	resperr := &awshttp.ResponseError{}
+	if errors.As(err, &resperr) && resperr.HTTPStatusCode() == http.StatusNotFound {
+		return fmt.Errorf("%w: %v", fs.ErrNotExist, err)
+	}
+	return err
+}
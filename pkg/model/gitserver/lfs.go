@@ -17,14 +17,25 @@ package gitserver
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
+	"net"
 	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
 	"time"
+
+	"github.com/gorilla/mux"
 )
 
 const (
 	OperationUpload   = "upload"
 	OperationDownload = "download"
+
+	lfsBatchMaxBodySize = 10 << 20
 )
 
 // nolint: tagliatelle
@@ -131,8 +142,8 @@ type Link struct {
 // https://github.com/git-lfs/git-lfs/blob/main/docs/api/batch.md#git-lfs-batch-api
 func (s *Server) LFSBatch(w http.ResponseWriter, r *http.Request) {
 	batch := &Batch{}
-	if err := json.NewDecoder(r.Body).Decode(batch); err != nil {
-		BadRequest(w, ObjectError{Code: http.StatusBadRequest, Message: err.Error()})
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, lfsBatchMaxBodySize)).Decode(batch); err != nil {
+		lfsResponse(w, http.StatusBadRequest, ObjectError{Code: http.StatusBadRequest, Message: err.Error()})
 		return
 	}
 
@@ -148,11 +159,15 @@ func (s *Server) LFSBatch(w http.ResponseWriter, r *http.Request) {
 	switch batch.Operation {
 	case OperationUpload:
 		for _, obj := range batch.Objects {
-			if link, err := s.LFS.Upload(ctx, repopath, obj.OID); err != nil {
-				obj.Error = &ObjectError{Code: http.StatusInternalServerError, Message: err.Error()}
-			} else {
+			if !ValidOID(obj.OID) {
+				obj.Error = &ObjectError{Code: http.StatusUnprocessableEntity, Message: "invalid oid"}
+			} else if link, err := s.LFS.Upload(ctx, repopath, obj.OID); err != nil {
+				obj.Error = &ObjectError{Code: lfsErrorCode(err), Message: err.Error()}
+			} else if link != nil {
+				// no actions if the object already exists
 				obj.Actions = map[string]Link{
-					"upload": *link,
+					"upload": *s.absLink(r, link),
+					"verify": {Href: s.baseURL(r) + "/" + repopath + "/info/lfs/verify"},
 				}
 			}
 			batchResponse.Objects = append(batchResponse.Objects, obj)
@@ -160,18 +175,20 @@ func (s *Server) LFSBatch(w http.ResponseWriter, r *http.Request) {
 		OK(w, batchResponse)
 	case OperationDownload:
 		for _, obj := range batch.Objects {
-			if link, err := s.LFS.Download(ctx, repopath, obj.OID); err != nil {
-				obj.Error = &ObjectError{Code: http.StatusInternalServerError, Message: err.Error()}
+			if !ValidOID(obj.OID) {
+				obj.Error = &ObjectError{Code: http.StatusUnprocessableEntity, Message: "invalid oid"}
+			} else if link, err := s.LFS.Download(ctx, repopath, obj.OID); err != nil {
+				obj.Error = &ObjectError{Code: lfsErrorCode(err), Message: err.Error()}
 			} else {
 				obj.Actions = map[string]Link{
-					"download": *link,
+					"download": *s.absLink(r, link),
 				}
 			}
 			batchResponse.Objects = append(batchResponse.Objects, obj)
 		}
 		OK(w, batchResponse)
 	default:
-		BadRequest(w, BatchError{
+		lfsResponse(w, http.StatusBadRequest, BatchError{
 			RequestID:   "",
 			DocumentURL: "",
 			Message:     fmt.Sprintf("Invalid operation: %s", batch.Operation),
@@ -179,31 +196,231 @@ func (s *Server) LFSBatch(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// LFSUpload handles the legacy api, returns the upload link of the object.
 func (s *Server) LFSUpload(w http.ResponseWriter, r *http.Request) {
-	w.Write([]byte("ok"))
+	obj := &BatchObject{}
+	if err := json.NewDecoder(r.Body).Decode(obj); err != nil {
+		lfsResponse(w, http.StatusBadRequest, BatchError{Message: err.Error()})
+		return
+	}
+	if !ValidOID(obj.OID) {
+		lfsResponse(w, http.StatusUnprocessableEntity, BatchError{Message: "invalid oid"})
+		return
+	}
+	link, err := s.LFS.Upload(r.Context(), s.RepositoryPath(r), obj.OID)
+	if err != nil {
+		lfsResponse(w, lfsErrorCode(err), BatchError{Message: err.Error()})
+		return
+	}
+	if link == nil {
+		OK(w, obj)
+		return
+	}
+	obj.Actions = map[string]Link{"upload": *s.absLink(r, link)}
+	lfsResponse(w, http.StatusAccepted, obj)
 }
 
+// LFSDownload serves the object content,
+// redirect to the download link if objects are not stored by server itself.
 func (s *Server) LFSDownload(w http.ResponseWriter, r *http.Request) {
-	w.Write([]byte("ok"))
+	oid, repopath := mux.Vars(r)["oid"], s.RepositoryPath(r)
+	if !ValidOID(oid) {
+		lfsResponse(w, http.StatusUnprocessableEntity, BatchError{Message: "invalid oid"})
+		return
+	}
+	ctx := r.Context()
+	cm, ok := s.LFS.(LFSContentManager)
+	if !ok {
+		link, err := s.LFS.Download(ctx, repopath, oid)
+		if err != nil {
+			lfsResponse(w, lfsErrorCode(err), BatchError{Message: err.Error()})
+			return
+		}
+		http.Redirect(w, r, link.Href, http.StatusTemporaryRedirect)
+		return
+	}
+	content, size, err := cm.Get(ctx, repopath, oid)
+	if err != nil {
+		lfsResponse(w, lfsErrorCode(err), BatchError{Message: err.Error()})
+		return
+	}
+	defer content.Close()
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	SetHeaderCacheForever(w)
+	w.WriteHeader(http.StatusOK)
+	io.Copy(w, content)
 }
 
+// LFSUpdate receives the object content in basic transfer.
 func (s *Server) LFSUpdate(w http.ResponseWriter, r *http.Request) {
-	w.Write([]byte("ok"))
+	oid, repopath := mux.Vars(r)["oid"], s.RepositoryPath(r)
+	if !ValidOID(oid) {
+		lfsResponse(w, http.StatusUnprocessableEntity, BatchError{Message: "invalid oid"})
+		return
+	}
+	ctx := r.Context()
+	defer r.Body.Close()
+	cm, ok := s.LFS.(LFSContentManager)
+	if !ok {
+		link, err := s.LFS.Upload(ctx, repopath, oid)
+		if err != nil {
+			lfsResponse(w, lfsErrorCode(err), BatchError{Message: err.Error()})
+			return
+		}
+		if link == nil {
+			OK(w, nil)
+			return
+		}
+		http.Redirect(w, r, link.Href, http.StatusTemporaryRedirect)
+		return
+	}
+	if err := cm.Put(ctx, repopath, oid, r.Body); err != nil {
+		lfsResponse(w, lfsErrorCode(err), BatchError{Message: err.Error()})
+		return
+	}
+	OK(w, nil)
 }
 
 func (s *Server) LFSDelete(w http.ResponseWriter, r *http.Request) {
-	w.Write([]byte("ok"))
+	oid, repopath := mux.Vars(r)["oid"], s.RepositoryPath(r)
+	if !ValidOID(oid) {
+		lfsResponse(w, http.StatusUnprocessableEntity, BatchError{Message: "invalid oid"})
+		return
+	}
+	if err := s.LFS.Delete(r.Context(), repopath, oid); err != nil {
+		lfsResponse(w, lfsErrorCode(err), BatchError{Message: err.Error()})
+		return
+	}
+	OK(w, nil)
 }
 
+// LFSVerify checks the object uploaded exists and has the expected size.
 func (s *Server) LFSVerify(w http.ResponseWriter, r *http.Request) {
-	w.Write([]byte("ok"))
+	obj := &BatchObject{}
+	if err := json.NewDecoder(r.Body).Decode(obj); err != nil {
+		lfsResponse(w, http.StatusBadRequest, BatchError{Message: err.Error()})
+		return
+	}
+	if !ValidOID(obj.OID) {
+		lfsResponse(w, http.StatusUnprocessableEntity, BatchError{Message: "invalid oid"})
+		return
+	}
+	exist, err := s.LFS.Verify(r.Context(), s.RepositoryPath(r), obj.OID)
+	if err != nil {
+		lfsResponse(w, lfsErrorCode(err), BatchError{Message: err.Error()})
+		return
+	}
+	if exist.Size != obj.Size {
+		lfsResponse(w, http.StatusUnprocessableEntity, BatchError{
+			Message: fmt.Sprintf("size mismatch, expected %d but got %d", obj.Size, exist.Size),
+		})
+		return
+	}
+	OK(w, exist)
 }
 
 type LFSMetaManager interface {
-	// Upload get upload url and verify url for a given object
+	// Upload get upload url and verify url for a given object, a nil link returned if the object exists.
+	// a link href starts with "/" is relative to the server.
 	Upload(ctx context.Context, path string, oid string) (*Link, error)
 	// Download get download url for a given object
 	Download(ctx context.Context, path string, oid string) (*Link, error)
 	// Verify verfiy object exists
 	Verify(ctx context.Context, path string, oid string) (*BatchObject, error)
+	// Delete removes the object
+	Delete(ctx context.Context, path string, oid string) error
+}
+
+// LFSContentManager stores the objects content in server,
+// objects are transferred by basic transfer handlers of the server.
+type LFSContentManager interface {
+	LFSMetaManager
+	Get(ctx context.Context, path string, oid string) (io.ReadCloser, int64, error)
+	Put(ctx context.Context, path string, oid string, content io.Reader) error
+}
+
+var (
+	oidRegexp      = regexp.MustCompile(`^[0-9a-f]{64}$`)
+	ErrOIDMismatch = errors.New("oid mismatch with the content")
+)
+
+// ValidOID checks the oid is a sha256 hex string.
+func ValidOID(oid string) bool {
+	return oidRegexp.MatchString(oid)
+}
+
+func lfsErrorCode(err error) int {
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return http.StatusNotFound
+	case errors.Is(err, ErrOIDMismatch):
+		return http.StatusUnprocessableEntity
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// lfsResponse writes the json body with the status code in git lfs media type.
+func lfsResponse(w http.ResponseWriter, code int, body interface{}) {
+	w.Header().Set("Content-Type", mimeGitLFSJSON)
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(body)
+}
+
+func (s *Server) absLink(r *http.Request, link *Link) *Link {
+	if strings.HasPrefix(link.Href, "/") {
+		link.Href = s.baseURL(r) + link.Href
+	}
+	return link
+}
+
+// baseURL returns the external url of the server from the request,
+// X-Forwarded-Proto and X-Forwarded-Host are used only if the request comes from a trusted proxy.
+func (s *Server) baseURL(r *http.Request) string {
+	scheme, host := "http", r.Host
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if s.fromTrustedProxy(r) {
+		if proto := lastForwarded(r.Header.Get("X-Forwarded-Proto")); proto == "http" || proto == "https" {
+			scheme = proto
+		}
+		if fhost := lastForwarded(r.Header.Get("X-Forwarded-Host")); fhost != "" {
+			host = fhost
+		}
+	}
+	return scheme + "://" + host
+}
+
+// fromTrustedProxy checks the remote address is in the trusted proxies.
+func (s *Server) fromTrustedProxy(r *http.Request) bool {
+	if len(s.TrustedProxies) == 0 {
+		return false
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, proxy := range s.TrustedProxies {
+		if _, cidr, err := net.ParseCIDR(proxy); err == nil {
+			if cidr.Contains(ip) {
+				return true
+			}
+		} else if proxyip := net.ParseIP(proxy); proxyip != nil && proxyip.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// lastForwarded returns the value appended by the nearest proxy,
+// values before it may be sent by the client.
+func lastForwarded(value string) string {
+	values := strings.Split(value, ",")
+	return strings.TrimSpace(values[len(values)-1])
 }
@@ -0,0 +1,76 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gitserver
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestServer_baseURL(t *testing.T) {
+	tests := []struct {
+		name           string
+		trustedProxies []string
+		remoteAddr     string
+		headers        map[string]string
+		want           string
+	}{
+		{
+			name:       "no proxy",
+			remoteAddr: "10.0.0.1:1234",
+			want:       "http://registry.example.com",
+		},
+		{
+			name:       "untrusted forwarded headers",
+			remoteAddr: "10.0.0.1:1234",
+			headers:    map[string]string{"X-Forwarded-Proto": "https", "X-Forwarded-Host": "evil.example.com"},
+			want:       "http://registry.example.com",
+		},
+		{
+			name:           "forwarded by trusted proxy",
+			trustedProxies: []string{"10.0.0.0/8"},
+			remoteAddr:     "10.0.0.1:1234",
+			headers:        map[string]string{"X-Forwarded-Proto": "https", "X-Forwarded-Host": "git.example.com"},
+			want:           "https://git.example.com",
+		},
+		{
+			name:           "forwarded by untrusted proxy",
+			trustedProxies: []string{"192.168.1.1"},
+			remoteAddr:     "10.0.0.1:1234",
+			headers:        map[string]string{"X-Forwarded-Proto": "https", "X-Forwarded-Host": "git.example.com"},
+			want:           "http://registry.example.com",
+		},
+		{
+			name:           "values appended by trusted proxy",
+			trustedProxies: []string{"192.168.1.1"},
+			remoteAddr:     "192.168.1.1:1234",
+			headers:        map[string]string{"X-Forwarded-Proto": "javascript, https", "X-Forwarded-Host": "evil.example.com, git.example.com"},
+			want:           "https://git.example.com",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{TrustedProxies: tt.trustedProxies}
+			req := httptest.NewRequest("GET", "http://registry.example.com/user/repo.git/info/lfs/objects/batch", nil)
+			req.RemoteAddr = tt.remoteAddr
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			if got := s.baseURL(req); got != tt.want {
+				t.Errorf("Server.baseURL() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -35,9 +35,14 @@ func (s *Server) routes(lfsenabled bool, githttpbackendenabled bool) http.Handle
 	r := mux.NewRouter()
 	repoapi := r.PathPrefix("/{username}/{repository}").Subrouter()
 	// admin
-	repoapi.HandleFunc("", s.CreateRepository).Methods("POST")
-	repoapi.HandleFunc("", s.RemoveRepository).Methods("DELETE")
-	repoapi.HandleFunc("/files", s.ListFiles).Methods("GET")
+	repoapi.HandleFunc("", s.Authorized(PermissionAdmin, s.CreateRepository)).Methods("POST")
+	repoapi.HandleFunc("", s.Authorized(PermissionAdmin, s.RemoveRepository)).Methods("DELETE")
+	// browse
+	repoapi.HandleFunc("/refs", s.Authorized(PermissionRead, s.ListRefs)).Methods("GET")
+	repoapi.HandleFunc("/files", s.Authorized(PermissionRead, s.ListFiles)).Methods("GET")
+	repoapi.HandleFunc("/contents", s.Authorized(PermissionRead, s.GetFileContent)).Methods("GET")
+	repoapi.HandleFunc("/commits", s.Authorized(PermissionRead, s.ListCommits)).Methods("GET")
+	repoapi.HandleFunc("/diff", s.Authorized(PermissionRead, s.Diff)).Methods("GET")
 
 	// .git
 	gitrepor := r.PathPrefix("/{username}/{repository}.git").Subrouter()
@@ -46,14 +51,19 @@ func (s *Server) routes(lfsenabled bool, githttpbackendenabled bool) http.Handle
 	if lfsenabled {
 		// https://github.com/git-lfs/git-lfs/blob/main/docs/api/server-discovery.md#server-discovery
 		gitlfsr := gitrepor.PathPrefix("/info/lfs").Subrouter()
-		gitlfsr.HandleFunc("/objects/batch", s.LFSBatch).Methods("POST").MatcherFunc(LFSBatchMatcher)
+		gitlfsr.HandleFunc("/objects/batch", s.AuthorizedBy(LFSBatchPermission, s.LFSBatch)).Methods("POST").MatcherFunc(LFSBatchMatcher)
 		// https://github.com/git-lfs/git-lfs/blob/main/docs/api/basic-transfers.md#basic-transfer-api
-		gitlfsr.HandleFunc("/objects", s.LFSUpload).Methods("POST")
-		gitlfsr.HandleFunc("/objects/{oid}", s.LFSDownload).Methods("GET")
-		gitlfsr.HandleFunc("/objects/{oid}", s.LFSUpdate).Methods("PUT")
-		gitlfsr.HandleFunc("/objects/{oid}", s.LFSDelete).Methods("DELETE")
+		gitlfsr.HandleFunc("/objects", s.Authorized(PermissionWrite, s.LFSUpload)).Methods("POST")
+		gitlfsr.HandleFunc("/objects/{oid}", s.Authorized(PermissionRead, s.LFSDownload)).Methods("GET")
+		gitlfsr.HandleFunc("/objects/{oid}", s.Authorized(PermissionWrite, s.LFSUpdate)).Methods("PUT")
+		gitlfsr.HandleFunc("/objects/{oid}", s.Authorized(PermissionAdmin, s.LFSDelete)).Methods("DELETE")
 		// https://github.com/git-lfs/git-lfs/blob/main/docs/api/basic-transfers.md#verification
-		gitlfsr.HandleFunc("/verify", s.LFSVerify).Methods("POST").MatcherFunc(LFSBatchMatcher)
+		gitlfsr.HandleFunc("/verify", s.Authorized(PermissionWrite, s.LFSVerify)).Methods("POST").MatcherFunc(LFSBatchMatcher)
+		// https://github.com/git-lfs/git-lfs/blob/main/docs/api/locking.md
+		gitlfsr.HandleFunc("/locks", s.Authorized(PermissionWrite, s.LFSCreateLock)).Methods("POST")
+		gitlfsr.HandleFunc("/locks", s.Authorized(PermissionRead, s.LFSListLocks)).Methods("GET")
+		gitlfsr.HandleFunc("/locks/verify", s.Authorized(PermissionWrite, s.LFSVerifyLocks)).Methods("POST")
+		gitlfsr.HandleFunc("/locks/{id}/unlock", s.Authorized(PermissionWrite, s.LFSUnlock)).Methods("POST")
 	}
 
 	// git http
@@ -74,25 +84,25 @@ func (s *Server) routes(lfsenabled bool, githttpbackendenabled bool) http.Handle
 			"/git-receive-pack",
 		}
 		for _, path := range paths {
-			gitrepor.HandleFunc(path, s.GitHTTPBackend).Methods("GET", "POST")
+			gitrepor.HandleFunc(path, s.AuthorizedBy(GitServicePermission, s.GitHTTPBackend)).Methods("GET", "POST")
 		}
 	} else {
 		// smart http
-		gitrepor.HandleFunc("/info/refs", s.GetInfoRefsWithService).Methods("GET").Queries("service", "{servicename:.*}")
-		gitrepor.HandleFunc("/git-upload-pack", s.UploadPack).Methods("POST")
-		gitrepor.HandleFunc("/git-receive-pack", s.ReceivePack).Methods("POST")
+		gitrepor.HandleFunc("/info/refs", s.AuthorizedBy(GitServicePermission, s.GetInfoRefsWithService)).Methods("GET").Queries("service", "{servicename:.*}")
+		gitrepor.HandleFunc("/git-upload-pack", s.Authorized(PermissionRead, s.UploadPack)).Methods("POST")
+		gitrepor.HandleFunc("/git-receive-pack", s.Authorized(PermissionWrite, s.ReceivePack)).Methods("POST")
 		// dumb http
-		gitrepor.HandleFunc("/HEAD", s.GetHead).Methods("GET")
-		gitrepor.HandleFunc("/info/refs", s.GetInfoRefs).Methods("GET")
-		gitrepor.HandleFunc("/objects/info/alternates", s.GetAlternative).Methods("GET")
-		gitrepor.HandleFunc("/objects/info/http-alternates", s.GetHTTPAlternative).Methods("GET")
-		gitrepor.HandleFunc("/objects/info/packs", s.GetInfoPacks).Methods("GET")
-		gitrepor.HandleFunc("/objects/{hash-dir:[0-9a-f]{2}}/{hash:[0-9a-f]{38}}", s.GetLooseObject).Methods("GET")
-		gitrepor.HandleFunc("/objects/{hash-dir:[0-9a-f]{2}}/{hash:[0-9a-f]{62}}", s.GetLooseObject).Methods("GET")
-		gitrepor.HandleFunc("/objects/pack/pack-{hash:[0-9a-f]{40}}.pack", s.GetPackFile).Methods("GET")
-		gitrepor.HandleFunc("/objects/pack/pack-{hash:[0-9a-f]{64}}.pack", s.GetPackFile).Methods("GET")
-		gitrepor.HandleFunc("/objects/pack/pack-{hash:[0-9a-f]{40}}.idx", s.GetIdxFile).Methods("GET")
-		gitrepor.HandleFunc("/objects/pack/pack-{hash:[0-9a-f]{64}}.idx", s.GetIdxFile).Methods("GET")
+		gitrepor.HandleFunc("/HEAD", s.Authorized(PermissionRead, s.GetHead)).Methods("GET")
+		gitrepor.HandleFunc("/info/refs", s.Authorized(PermissionRead, s.GetInfoRefs)).Methods("GET")
+		gitrepor.HandleFunc("/objects/info/alternates", s.Authorized(PermissionRead, s.GetAlternative)).Methods("GET")
+		gitrepor.HandleFunc("/objects/info/http-alternates", s.Authorized(PermissionRead, s.GetHTTPAlternative)).Methods("GET")
+		gitrepor.HandleFunc("/objects/info/packs", s.Authorized(PermissionRead, s.GetInfoPacks)).Methods("GET")
+		gitrepor.HandleFunc("/objects/{hash-dir:[0-9a-f]{2}}/{hash:[0-9a-f]{38}}", s.Authorized(PermissionRead, s.GetLooseObject)).Methods("GET")
+		gitrepor.HandleFunc("/objects/{hash-dir:[0-9a-f]{2}}/{hash:[0-9a-f]{62}}", s.Authorized(PermissionRead, s.GetLooseObject)).Methods("GET")
+		gitrepor.HandleFunc("/objects/pack/pack-{hash:[0-9a-f]{40}}.pack", s.Authorized(PermissionRead, s.GetPackFile)).Methods("GET")
+		gitrepor.HandleFunc("/objects/pack/pack-{hash:[0-9a-f]{64}}.pack", s.Authorized(PermissionRead, s.GetPackFile)).Methods("GET")
+		gitrepor.HandleFunc("/objects/pack/pack-{hash:[0-9a-f]{40}}.idx", s.Authorized(PermissionRead, s.GetIdxFile)).Methods("GET")
+		gitrepor.HandleFunc("/objects/pack/pack-{hash:[0-9a-f]{64}}.idx", s.Authorized(PermissionRead, s.GetIdxFile)).Methods("GET")
 	}
 	r.Use(mux.CORSMethodMiddleware(r))
 	r.Use(LoggingMiddleware)
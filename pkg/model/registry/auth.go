@@ -0,0 +1,101 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package registry
+
+import (
+	"context"
+	"errors"
+	"path"
+
+	"kubegems.io/kubegems/pkg/model/gitserver"
+	"kubegems.io/kubegems/pkg/model/store/auth"
+)
+
+const (
+	// permission = <resource>:<action>:<id>, e.g. "repository:write:user/model"
+	PermissionResourceRepository = "repository"
+	// permissions of anonymous requests are granted to this user
+	AnonymousUser = "anonymous"
+)
+
+// StoreAuthenticator authenticates users with passwords in model store,
+// or tokens issued by kubegems service, tokens can also be used as the password of basic auth.
+type StoreAuthenticator struct {
+	Tokens    auth.AuthenticationManager // nil to disable token authentication
+	Passwords auth.PasswordManager
+}
+
+func (a *StoreAuthenticator) BasicAuth(ctx context.Context, username, password string) (string, error) {
+	if a.Tokens != nil {
+		if info, err := a.Tokens.UserInfo(ctx, password); err == nil {
+			return info.Username, nil
+		}
+	}
+	if err := a.Passwords.VerifyPassword(ctx, username, password); err != nil {
+		return "", gitserver.ErrUnauthorized
+	}
+	return username, nil
+}
+
+func (a *StoreAuthenticator) BearerAuth(ctx context.Context, token string) (string, error) {
+	if a.Tokens == nil {
+		return "", errors.New("token authentication is not enabled")
+	}
+	info, err := a.Tokens.UserInfo(ctx, token)
+	if err != nil {
+		return "", gitserver.ErrUnauthorized
+	}
+	return info.Username, nil
+}
+
+// StoreAuthorizer authorizes with permissions in model store.
+type StoreAuthorizer struct {
+	Authorization auth.AuthorizationManager
+	// OwnerAdmin grants users admin permission on repositories under their own name,"{username}/*",
+	// without permissions in model store.
+	OwnerAdmin bool
+}
+
+func (a *StoreAuthorizer) Authorize(ctx context.Context, username string, repository string, permission gitserver.Permission) (bool, error) {
+	if username == "" {
+		username = AnonymousUser
+	} else if owner, _ := path.Split(repository); a.OwnerAdmin && path.Clean(owner) == username {
+		return true, nil
+	}
+	permissions, err := a.Authorization.ListPermissions(ctx, username)
+	if err != nil {
+		return false, err
+	}
+	for _, granted := range permissions {
+		for _, action := range impliedBy(permission) {
+			if auth.MatchPermission(granted, auth.Permission(PermissionResourceRepository, string(action), repository)) {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// impliedBy returns permissions which include the permission
+func impliedBy(permission gitserver.Permission) []gitserver.Permission {
+	switch permission {
+	case gitserver.PermissionRead:
+		return []gitserver.Permission{gitserver.PermissionRead, gitserver.PermissionWrite, gitserver.PermissionAdmin}
+	case gitserver.PermissionWrite:
+		return []gitserver.Permission{gitserver.PermissionWrite, gitserver.PermissionAdmin}
+	default:
+		return []gitserver.Permission{permission}
+	}
+}
@@ -16,18 +16,43 @@ package registry
 
 import (
 	"context"
+	"fmt"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/go-logr/logr"
 	"kubegems.io/kubegems/pkg/log"
 	"kubegems.io/kubegems/pkg/model/gitserver"
+	"kubegems.io/kubegems/pkg/model/store/auth"
+	"kubegems.io/kubegems/pkg/model/store/repository"
+	"kubegems.io/kubegems/pkg/utils/mongo"
 )
 
 type Options struct {
-	Listen string       `json:"listen,omitempty" description:"http server listen address"`
-	S3     LFSS3Options `json:"s3,omitempty" description:"s3 options"`
-	Git    GitOptions   `json:"git,omitempty" description:"git options"`
+	Listen         string       `json:"listen,omitempty" description:"http server listen address"`
+	TrustedProxies []string     `json:"trustedProxies,omitempty" description:"ips or cidrs of reverse proxies whose X-Forwarded-* headers are trusted"`
+	LFS            LFSOptions   `json:"lfs,omitempty" description:"lfs options"`
+	S3             LFSS3Options `json:"s3,omitempty" description:"s3 options"`
+	Git            GitOptions   `json:"git,omitempty" description:"git options"`
+	Auth           AuthOptions  `json:"auth,omitempty" description:"auth options"`
+	Sync           SyncOptions  `json:"sync,omitempty" description:"sync models into model store"`
+}
+
+type AuthOptions struct {
+	Enabled    bool           `json:"enabled,omitempty" description:"enable authentication and authorization"`
+	JWTCert    string         `json:"jwtCert,omitempty" description:"cert file to verify jwt issued by kubegems, token authentication is disabled if empty"`
+	OwnerAdmin bool           `json:"ownerAdmin,omitempty" description:"users have admin permission on repositories {username}/* without granted permissions"`
+	Mongo      *mongo.Options `json:"mongo,omitempty" description:"mongo options of model store, also used by sync"`
+}
+
+const (
+	LFSStorageLocal = "local"
+	LFSStorageS3    = "s3"
+)
+
+type LFSOptions struct {
+	Storage string `json:"storage,omitempty" description:"lfs objects storage, local or s3"`
+	Dir     string `json:"dir,omitempty" description:"directory the lfs objects are stored in when using local storage"`
 }
 
 type GitOptions struct {
@@ -45,6 +70,10 @@ type LFSS3Options struct {
 func DefaultOptions() *Options {
 	return &Options{
 		Listen: ":8080",
+		LFS: LFSOptions{
+			Storage: LFSStorageLocal,
+			Dir:     "lfs",
+		},
 		S3: LFSS3Options{
 			Addr:         "http://s3.example.com",
 			Bucket:       "git-lfs",
@@ -53,25 +82,59 @@ func DefaultOptions() *Options {
 		Git: GitOptions{
 			Dir: "repositories",
 		},
+		Auth: AuthOptions{
+			Enabled:    false,
+			JWTCert:    "certs/jwt/tls.crt",
+			Mongo:      mongo.DefaultOptions(),
+			OwnerAdmin: true,
+		},
+		Sync: SyncOptions{
+			Enabled:  false,
+			Source:   "registry",
+			Interval: time.Hour,
+		},
 	}
 }
 
 func Run(ctx context.Context, opts *Options) error {
 	ctx = log.NewContext(ctx, log.LogrLogger)
 
-	s3lfsman, err := gitserver.NewS3ContentManager(ctx, &gitserver.S3ContentManagerOptions{
-		URL:    opts.S3.Addr,
-		Bucket: opts.S3.Bucket,
-		Credential: aws.Credentials{
-			AccessKeyID:     opts.S3.AccessKey,
-			SecretAccessKey: opts.S3.SecretKey,
-		},
-		LinkExpireIn: opts.S3.LinkExpireIn,
-	})
+	lfsman, err := newLFSMetaManager(ctx, opts)
 	if err != nil {
 		return err
 	}
-	s := gitserver.Server{GitBase: opts.Git.Dir, LFS: s3lfsman}
+	s := gitserver.Server{GitBase: opts.Git.Dir, LFS: lfsman, TrustedProxies: opts.TrustedProxies}
+	if opts.Auth.Enabled || opts.Sync.Enabled {
+		mongocli, mongodb, err := mongo.New(ctx, opts.Auth.Mongo)
+		if err != nil {
+			return fmt.Errorf("setup mongo: %v", err)
+		}
+		defer mongocli.Disconnect(ctx)
+
+		if opts.Auth.Enabled {
+			authorization := auth.NewLocalAuthorization(ctx, mongodb)
+			authc := &StoreAuthenticator{Passwords: authorization}
+			if opts.Auth.JWTCert != "" {
+				tokens, err := auth.NewJWTAuthenticationManager(opts.Auth.JWTCert)
+				if err != nil {
+					return fmt.Errorf("setup jwt authentication: %v", err)
+				}
+				authc.Tokens = tokens
+			}
+			s.Authc, s.Authz = authc, &StoreAuthorizer{Authorization: authorization, OwnerAdmin: opts.Auth.OwnerAdmin}
+		}
+		if opts.Sync.Enabled {
+			synchronizer := &Synchronizer{
+				GitBase: opts.Git.Dir,
+				Source:  opts.Sync.Source,
+				Models:  repository.NewModelsRepository(mongodb),
+			}
+			// use the server context which has logger
+			s.OnPush = func(_ context.Context, name string) { synchronizer.OnPush(ctx, name) }
+			s.OnRemove = s.OnPush
+			go synchronizer.Run(ctx, opts.Sync.Interval)
+		}
+	}
 	log := logr.FromContextOrDiscard(ctx)
 	log.Info("starting git http server", "listen", opts.Listen)
 	if err := s.Run(ctx, &gitserver.Options{Listen: opts.Listen, UseGitHTTPBackend: true}); err != nil {
@@ -79,3 +142,22 @@ func Run(ctx context.Context, opts *Options) error {
 	}
 	return nil
 }
+
+func newLFSMetaManager(ctx context.Context, opts *Options) (gitserver.LFSMetaManager, error) {
+	switch opts.LFS.Storage {
+	case LFSStorageS3:
+		return gitserver.NewS3ContentManager(ctx, &gitserver.S3ContentManagerOptions{
+			URL:    opts.S3.Addr,
+			Bucket: opts.S3.Bucket,
+			Credential: aws.Credentials{
+				AccessKeyID:     opts.S3.AccessKey,
+				SecretAccessKey: opts.S3.SecretKey,
+			},
+			LinkExpireIn: opts.S3.LinkExpireIn,
+		})
+	case LFSStorageLocal, "":
+		return gitserver.NewLocalContentManager(opts.LFS.Dir)
+	default:
+		return nil, fmt.Errorf("unsupported lfs storage: %s", opts.LFS.Storage)
+	}
+}
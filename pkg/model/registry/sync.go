@@ -0,0 +1,342 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package registry
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"kubegems.io/kubegems/pkg/model/gitserver"
+	"kubegems.io/kubegems/pkg/model/store/repository"
+	"kubegems.io/kubegems/pkg/utils/httputil/response"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	AnnotationRepository = "registry.kubegems.io/repository"
+	AnnotationRefs       = "registry.kubegems.io/refs" // digest of the refs when synced
+	modelCardFilename    = "README.md"
+	lfsPointerMaxSize    = 1024
+)
+
+type SyncOptions struct {
+	Enabled  bool          `json:"enabled,omitempty" description:"sync models in registry into model store"`
+	Source   string        `json:"source,omitempty" description:"model store source name the models synced into"`
+	Interval time.Duration `json:"interval,omitempty" description:"interval of full sync, 0 to sync on push only"`
+}
+
+// ModelCard is the metadata in front matter of README.md
+// https://huggingface.co/docs/hub/model-cards#model-card-metadata
+// nolint: tagliatelle
+type ModelCard struct {
+	Author      string            `json:"author,omitempty"`
+	License     string            `json:"license,omitempty"`
+	Tags        []string          `json:"tags,omitempty"`
+	PipelineTag string            `json:"pipeline_tag,omitempty"`
+	LibraryName string            `json:"library_name,omitempty"`
+	Paper       map[string]string `json:"paper,omitempty"`
+}
+
+// Synchronizer indexes repositories in registry into model store.
+type Synchronizer struct {
+	GitBase string
+	Source  string
+	Models  *repository.ModelsRepository
+
+	mu sync.Mutex // avoid syncing concurrently
+}
+
+func (s *Synchronizer) Run(ctx context.Context, interval time.Duration) error {
+	log := logr.FromContextOrDiscard(ctx)
+	if interval <= 0 {
+		return nil
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := s.SyncAll(ctx); err != nil {
+			log.Error(err, "sync models")
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// OnPush could be used as the push and remove hook of gitserver.
+func (s *Synchronizer) OnPush(ctx context.Context, name string) {
+	if err := s.Sync(ctx, name); err != nil {
+		logr.FromContextOrDiscard(ctx).Error(err, "sync model", "name", name)
+	}
+}
+
+// SyncAll syncs all repositories "{username}/{repository}.git" under git base directory,
+// models synced from the repositories not found are removed, other models of the source are kept.
+func (s *Synchronizer) SyncAll(ctx context.Context) error {
+	// avoid removing all models on a wrong git base directory
+	if _, err := os.Stat(s.GitBase); err != nil {
+		return err
+	}
+	matches, err := filepath.Glob(filepath.Join(s.GitBase, "*", "*.git"))
+	if err != nil {
+		return err
+	}
+	var errs []string
+	names := []string{}
+	for _, match := range matches {
+		rel, err := filepath.Rel(s.GitBase, match)
+		if err != nil {
+			continue
+		}
+		name := strings.TrimSuffix(filepath.ToSlash(rel), ".git")
+		names = append(names, name)
+		if err := s.Sync(ctx, name); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", name, err))
+		}
+	}
+	s.mu.Lock()
+	removed, err := s.Models.DeleteNotIn(ctx, s.Source, names, AnnotationRepository)
+	s.mu.Unlock()
+	if err != nil {
+		errs = append(errs, fmt.Sprintf("remove models of deleted repositories: %v", err))
+	} else if removed > 0 {
+		logr.FromContextOrDiscard(ctx).Info("removed models of deleted repositories", "count", removed)
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("sync failed: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// Sync upserts the model of repository "{username}/{repository}",
+// the model is removed if the repository not exists, and kept if the refs of the repository not changed.
+func (s *Synchronizer) Sync(ctx context.Context, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	dir := filepath.Join(s.GitBase, name+".git")
+	synced, err := s.synced(ctx, name)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(dir); err != nil {
+		if !os.IsNotExist(err) {
+			return err
+		}
+		if synced == nil {
+			return nil
+		}
+		return s.Models.Delete(ctx, s.Source, name)
+	}
+	if synced != nil {
+		if digest, err := refsDigest(ctx, dir); err == nil && synced.Annotations[AnnotationRefs] == digest {
+			return nil
+		}
+	}
+	model, err := ParseModel(ctx, dir, name)
+	if err != nil {
+		return err
+	}
+	if model == nil {
+		// empty repository
+		return nil
+	}
+	model.Source = s.Source
+	return s.Models.Upsert(ctx, model)
+}
+
+// synced returns the model synced from the repository, nil if not found or the model is not synced from registry.
+func (s *Synchronizer) synced(ctx context.Context, name string) (*repository.Model, error) {
+	existing, err := s.Models.Get(ctx, s.Source, name, true)
+	if err != nil {
+		statuserr := &response.StatusError{}
+		if errors.As(err, &statuserr) && statuserr.Status == http.StatusNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if existing.Annotations[AnnotationRepository] != name {
+		return nil, nil
+	}
+	return &existing.Model, nil
+}
+
+// ParseModel parses model from repository, versions are the tags or the default branch if no tags.
+// returns nil if the repository has no commits.
+func ParseModel(ctx context.Context, dir string, name string) (*repository.Model, error) {
+	if _, err := os.Stat(dir); err != nil {
+		return nil, err
+	}
+	if _, err := gitserver.GitOutput(ctx, dir, "rev-parse", "--verify", "--quiet", "HEAD"); err != nil {
+		return nil, nil
+	}
+	digest, err := refsDigest(ctx, dir)
+	if err != nil {
+		return nil, err
+	}
+	refs, err := versionRefs(ctx, dir)
+	if err != nil {
+		return nil, err
+	}
+	owner, _ := path.Split(name)
+	now := time.Now()
+	model := &repository.Model{
+		Name:         name,
+		Author:       strings.TrimSuffix(owner, "/"),
+		LastModified: &now,
+		Enabled:      true,
+		Annotations:  map[string]string{AnnotationRepository: name, AnnotationRefs: digest},
+	}
+	for _, ref := range refs {
+		version, err := parseVersion(ctx, dir, ref)
+		if err != nil {
+			return nil, fmt.Errorf("parse version %s: %w", ref, err)
+		}
+		model.Versions = append(model.Versions, *version)
+	}
+	// metadata from the latest commit
+	card := ParseModelCard([]byte(readFile(ctx, dir, "HEAD", modelCardFilename)))
+	if card.Author != "" {
+		model.Author = card.Author
+	}
+	model.License, model.Tags, model.Paper = card.License, card.Tags, card.Paper
+	model.Task, model.Framework = card.PipelineTag, card.LibraryName
+	if model.Tags == nil {
+		model.Tags = []string{}
+	}
+	if first, err := commitTime(ctx, dir, "--reverse", "HEAD"); err == nil {
+		model.CreateAt = &first
+	}
+	if last, err := commitTime(ctx, dir, "-1", "HEAD"); err == nil {
+		model.UpdateAt = &last
+	}
+	return model, nil
+}
+
+// refsDigest returns the digest of all refs and the default branch, it changes on every push.
+func refsDigest(ctx context.Context, dir string) (string, error) {
+	refs, err := gitserver.GitOutput(ctx, dir, "for-each-ref", "--format=%(objectname) %(refname)")
+	if err != nil {
+		return "", err
+	}
+	head, _ := gitserver.GitOutput(ctx, dir, "symbolic-ref", "HEAD")
+	sum := sha256.Sum256(append(head, refs...))
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func versionRefs(ctx context.Context, dir string) ([]string, error) {
+	out, err := gitserver.GitOutput(ctx, dir, "tag", "--list", "--sort=-creatordate")
+	if err != nil {
+		return nil, err
+	}
+	if tags := strings.Fields(string(out)); len(tags) > 0 {
+		return tags, nil
+	}
+	branch, err := gitserver.GitOutput(ctx, dir, "symbolic-ref", "--short", "HEAD")
+	if err != nil {
+		return nil, err
+	}
+	return []string{strings.TrimSpace(string(branch))}, nil
+}
+
+func parseVersion(ctx context.Context, dir string, ref string) (*repository.ModelVersion, error) {
+	out, err := gitserver.GitOutput(ctx, dir, "ls-tree", "-r", "-l", "-z", ref)
+	if err != nil {
+		return nil, err
+	}
+	version := &repository.ModelVersion{
+		Name:  ref,
+		Files: []repository.ModelFile{},
+		Intro: readFile(ctx, dir, ref, modelCardFilename),
+	}
+	for _, line := range strings.Split(string(out), "\x00") {
+		// <mode> SP <type> SP <object> SP <object size> TAB <file>
+		meta, filename, ok := strings.Cut(line, "\t")
+		if !ok {
+			continue
+		}
+		fields := strings.Fields(meta)
+		if len(fields) != 4 || fields[1] != "blob" {
+			continue
+		}
+		size, _ := strconv.ParseInt(fields[3], 10, 64)
+		if size <= lfsPointerMaxSize {
+			if pointer := gitserver.ParseLFSPointer([]byte(readFile(ctx, dir, ref, filename))); pointer != nil {
+				size = pointer.Size
+			}
+		}
+		version.Files = append(version.Files, repository.ModelFile{Filename: filename, Size: size})
+	}
+	if first, err := commitTime(ctx, dir, "--reverse", ref); err == nil {
+		version.CreationTime = first
+	}
+	if last, err := commitTime(ctx, dir, "-1", ref); err == nil {
+		version.UpdationTime = last
+	}
+	return version, nil
+}
+
+func readFile(ctx context.Context, dir, ref, filename string) string {
+	out, err := gitserver.GitOutput(ctx, dir, "cat-file", "blob", ref+":"+filename)
+	if err != nil {
+		return ""
+	}
+	return string(out)
+}
+
+// commitTime returns the time of first commit listed by "git log {args}"
+func commitTime(ctx context.Context, dir string, args ...string) (time.Time, error) {
+	out, err := gitserver.GitOutput(ctx, dir, append([]string{"log", "--format=%ct"}, args...)...)
+	if err != nil {
+		return time.Time{}, err
+	}
+	first, _, _ := strings.Cut(strings.TrimSpace(string(out)), "\n")
+	ts, err := strconv.ParseInt(first, 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(ts, 0), nil
+}
+
+// ParseModelCard parses the yaml front matter of model card, empty card returned if no metadata found.
+func ParseModelCard(content []byte) ModelCard {
+	card := ModelCard{}
+	content = bytes.TrimPrefix(content, []byte("\xef\xbb\xbf")) // utf-8 bom
+	if !bytes.HasPrefix(content, []byte("---")) {
+		return card
+	}
+	rest := content[3:]
+	end := bytes.Index(rest, []byte("\n---"))
+	if end < 0 {
+		return card
+	}
+	_ = yaml.Unmarshal(rest[:end], &card)
+	return card
+}
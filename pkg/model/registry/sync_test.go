@@ -0,0 +1,234 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package registry
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+const testLFSPointer = `version https://git-lfs.github.com/spec/v1
+oid sha256:4d7a214614ab2935c943f9e0ff69d22eadbb8f32b1258daaa5e2ca24d17e2393
+size 12345
+`
+
+const testModelCard = `---
+author: kubegems
+license: apache-2.0
+tags:
+  - nlp
+  - bert
+pipeline_tag: fill-mask
+library_name: transformers
+---
+
+# bert
+`
+
+type testCommit struct {
+	date  string
+	files map[string]string
+	tag   string
+}
+
+// setupTestRepository creates bare repository "{gitbase}/user/{name}.git",
+// files are committed in order, tags are created on the commit if set.
+func setupTestRepository(t *testing.T, gitbase, name string, commits []testCommit) string {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not found")
+	}
+	bare := filepath.Join(gitbase, "user", name+".git")
+	work := t.TempDir()
+	run := func(dir string, date string, args ...string) {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(), "GIT_AUTHOR_NAME=tester", "GIT_AUTHOR_EMAIL=tester@example.com",
+			"GIT_COMMITTER_NAME=tester", "GIT_COMMITTER_EMAIL=tester@example.com")
+		if date != "" {
+			cmd.Env = append(cmd.Env, "GIT_AUTHOR_DATE="+date, "GIT_COMMITTER_DATE="+date)
+		}
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v: %s", args, err, out)
+		}
+	}
+	if err := os.MkdirAll(bare, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	run(bare, "", "init", "--bare", "--initial-branch=main", ".")
+	if len(commits) == 0 {
+		return bare
+	}
+	run(work, "", "init", "--initial-branch=main", ".")
+	for _, commit := range commits {
+		for filename, content := range commit.files {
+			os.MkdirAll(filepath.Dir(filepath.Join(work, filename)), 0o755)
+			os.WriteFile(filepath.Join(work, filename), []byte(content), 0o644)
+		}
+		run(work, commit.date, "add", ".")
+		run(work, commit.date, "commit", "-m", "update")
+		if commit.tag != "" {
+			run(work, commit.date, "tag", commit.tag)
+		}
+	}
+	run(work, "", "push", "--tags", bare, "main")
+	return bare
+}
+
+func TestParseModel(t *testing.T) {
+	ctx := context.Background()
+	gitbase := t.TempDir()
+
+	bare := setupTestRepository(t, gitbase, "bert", []testCommit{
+		{date: "2022-01-01T00:00:00Z", files: map[string]string{"README.md": "# bert\n", "config.json": "{}"}, tag: "v1"},
+		{date: "2022-02-01T00:00:00Z", files: map[string]string{"README.md": testModelCard, "weights/model.bin": testLFSPointer}, tag: "v2"},
+	})
+	model, err := ParseModel(ctx, bare, "user/bert")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if model.Name != "user/bert" || model.Author != "kubegems" || model.License != "apache-2.0" {
+		t.Errorf("ParseModel() name, author, license = %s, %s, %s", model.Name, model.Author, model.License)
+	}
+	if !reflect.DeepEqual(model.Tags, []string{"nlp", "bert"}) || model.Task != "fill-mask" || model.Framework != "transformers" {
+		t.Errorf("ParseModel() tags, task, framework = %v, %s, %s", model.Tags, model.Task, model.Framework)
+	}
+	if model.Annotations[AnnotationRepository] != "user/bert" {
+		t.Errorf("ParseModel() annotations = %v", model.Annotations)
+	}
+	if model.CreateAt == nil || model.CreateAt.UTC().Format("2006-01-02") != "2022-01-01" ||
+		model.UpdateAt == nil || model.UpdateAt.UTC().Format("2006-01-02") != "2022-02-01" {
+		t.Errorf("ParseModel() create at %v, update at %v", model.CreateAt, model.UpdateAt)
+	}
+	// latest version first
+	if len(model.Versions) != 2 || model.Versions[0].Name != "v2" || model.Versions[1].Name != "v1" {
+		t.Fatalf("ParseModel() versions = %v", model.Versions)
+	}
+	v2, v1 := model.Versions[0], model.Versions[1]
+	sizes := map[string]int64{}
+	for _, file := range v2.Files {
+		sizes[file.Filename] = file.Size
+	}
+	wantSizes := map[string]int64{"README.md": int64(len(testModelCard)), "config.json": 2, "weights/model.bin": 12345}
+	if !reflect.DeepEqual(sizes, wantSizes) {
+		t.Errorf("version v2 files = %v, want %v", sizes, wantSizes)
+	}
+	if v2.Intro != testModelCard || v1.Intro != "# bert\n" {
+		t.Errorf("version intro: v2 %q, v1 %q", v2.Intro, v1.Intro)
+	}
+	if len(v1.Files) != 2 || v1.UpdationTime.UTC().Format("2006-01-02") != "2022-01-01" {
+		t.Errorf("version v1 files %v, updated at %v", v1.Files, v1.UpdationTime)
+	}
+
+	// default branch is the version if no tags
+	untagged := setupTestRepository(t, gitbase, "untagged", []testCommit{
+		{date: "2022-01-01T00:00:00Z", files: map[string]string{"README.md": "# untagged\n"}},
+	})
+	model, err = ParseModel(ctx, untagged, "user/untagged")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if model.Author != "user" || len(model.Versions) != 1 || model.Versions[0].Name != "main" {
+		t.Errorf("ParseModel() untagged author %s, versions %v", model.Author, model.Versions)
+	}
+	if model.Tags == nil {
+		t.Errorf("ParseModel() untagged tags should not be nil")
+	}
+
+	empty := setupTestRepository(t, gitbase, "empty", nil)
+	if model, err := ParseModel(ctx, empty, "user/empty"); err != nil || model != nil {
+		t.Errorf("ParseModel() empty repository = %v, %v, want nil", model, err)
+	}
+	if _, err := ParseModel(ctx, filepath.Join(gitbase, "user", "notexists.git"), "user/notexists"); !os.IsNotExist(err) {
+		t.Errorf("ParseModel() removed repository error = %v, want not exist", err)
+	}
+}
+
+func TestParseModelCard(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    ModelCard
+	}{
+		{
+			name:    "front matter",
+			content: testModelCard,
+			want: ModelCard{
+				Author:      "kubegems",
+				License:     "apache-2.0",
+				Tags:        []string{"nlp", "bert"},
+				PipelineTag: "fill-mask",
+				LibraryName: "transformers",
+			},
+		},
+		{
+			name:    "utf-8 bom",
+			content: "\xef\xbb\xbf---\nlicense: mit\n---\n",
+			want:    ModelCard{License: "mit"},
+		},
+		{
+			name:    "no front matter",
+			content: "# model\n\nlicense: mit\n",
+			want:    ModelCard{},
+		},
+		{
+			name:    "unclosed front matter",
+			content: "---\nlicense: mit\n",
+			want:    ModelCard{},
+		},
+		{
+			name:    "invalid yaml",
+			content: "---\nlicense: [mit\n---\n",
+			want:    ModelCard{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ParseModelCard([]byte(tt.content)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseModelCard() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRefsDigest(t *testing.T) {
+	ctx := context.Background()
+	bare := setupTestRepository(t, t.TempDir(), "bert", []testCommit{
+		{date: "2022-01-01T00:00:00Z", files: map[string]string{"README.md": "# bert\n"}, tag: "v1"},
+	})
+	first, err := refsDigest(ctx, bare)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := refsDigest(ctx, bare); again != first {
+		t.Errorf("refsDigest() changed without push: %s, %s", first, again)
+	}
+	if out, err := exec.Command("git", "-C", bare, "tag", "v2", "main").CombinedOutput(); err != nil {
+		t.Fatalf("git tag: %v: %s", err, out)
+	}
+	if changed, _ := refsDigest(ctx, bare); changed == first {
+		t.Errorf("refsDigest() not changed after tag created")
+	}
+	model, err := ParseModel(ctx, bare, "user/bert")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if digest, _ := refsDigest(ctx, bare); model.Annotations[AnnotationRefs] != digest {
+		t.Errorf("ParseModel() refs annotation = %s, want %s", model.Annotations[AnnotationRefs], digest)
+	}
+}
@@ -245,6 +245,13 @@ func (m *ModelsAPI) registerAdminRoute() *route.Group {
 					Parameters(route.PathParameter("source", "source name")).
 					Response(repository.Source{}),
 			),
+			// users
+			route.NewGroup("/users/{username}").
+				Parameters(route.PathParameter("username", "username")).
+				AddRoutes(
+					route.PUT("/password").To(m.AdminSetUserPassword).Doc("set user password").
+						Parameters(route.BodyParameter("body", UserPassword{})),
+				),
 			// source selector
 			route.NewGroup("/sources/{source}/selector").
 				Parameters(route.PathParameter("source", "source name")).
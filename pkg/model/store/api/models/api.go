@@ -33,6 +33,7 @@ type ModelsAPI struct {
 	SyncService       *SyncService
 
 	authorization auth.AuthorizationManager
+	passwords     auth.PasswordManager
 }
 
 func NewModelsAPI(ctx context.Context, db *mongo.Database, syncopt *SyncOptions) (*ModelsAPI, error) {
@@ -40,9 +41,10 @@ func NewModelsAPI(ctx context.Context, db *mongo.Database, syncopt *SyncOptions)
 		ModelRepository:   repository.NewModelsRepository(db),
 		CommentRepository: repository.NewCommentsRepository(db),
 		SourcesRepository: repository.NewSourcesRepository(db),
-		authorization:     auth.NewLocalAuthorization(ctx, db),
 		SyncService:       NewSyncService(syncopt),
 	}
+	localauthorization := auth.NewLocalAuthorization(ctx, db)
+	api.authorization, api.passwords = localauthorization, localauthorization
 	if err := api.InitSchemas(ctx); err != nil {
 		return nil, fmt.Errorf("init schemas: %v", err)
 	}
@@ -56,3 +56,25 @@ func (o *ModelsAPI) DeleteSourceAdmin(req *restful.Request, resp *restful.Respon
 		response.OK(resp, nil)
 	}
 }
+
+type UserPassword struct {
+	Password string `json:"password"`
+}
+
+// AdminSetUserPassword sets the password used on basic auth of model registry.
+func (o *ModelsAPI) AdminSetUserPassword(req *restful.Request, resp *restful.Response) {
+	body := &UserPassword{}
+	if err := req.ReadEntity(body); err != nil {
+		response.BadRequest(resp, err.Error())
+		return
+	}
+	if body.Password == "" {
+		response.BadRequest(resp, "password is required")
+		return
+	}
+	if err := o.passwords.SetPassword(req.Request.Context(), req.PathParameter("username"), body.Password); err != nil {
+		response.Error(resp, err)
+	} else {
+		response.OK(resp, nil)
+	}
+}
@@ -16,7 +16,9 @@ package auth
 
 import (
 	"context"
+	"crypto/rsa"
 	"fmt"
+	"os"
 
 	"github.com/golang-jwt/jwt"
 )
@@ -48,3 +50,37 @@ func (a *UnVerifyJWTAuthenticationManager) UserInfo(ctx context.Context, token s
 	}
 	return UserInfo{Username: username}, nil
 }
+
+// JWTAuthenticationManager verifies tokens issued by kubegems service with its public key.
+type JWTAuthenticationManager struct {
+	publicKey *rsa.PublicKey
+}
+
+func NewJWTAuthenticationManager(certfile string) (*JWTAuthenticationManager, error) {
+	content, err := os.ReadFile(certfile)
+	if err != nil {
+		return nil, err
+	}
+	publicKey, err := jwt.ParseRSAPublicKeyFromPEM(content)
+	if err != nil {
+		return nil, fmt.Errorf("parse public key: %v", err)
+	}
+	return &JWTAuthenticationManager{publicKey: publicKey}, nil
+}
+
+func (a *JWTAuthenticationManager) UserInfo(ctx context.Context, token string) (UserInfo, error) {
+	claims := &jwt.StandardClaims{}
+	_, err := jwt.ParseWithClaims(token, claims, func(t *jwt.Token) (interface{}, error) {
+		if _, ok := t.Method.(*jwt.SigningMethodRSA); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", t.Header["alg"])
+		}
+		return a.publicKey, nil
+	})
+	if err != nil {
+		return UserInfo{}, fmt.Errorf("parse token: %v", err)
+	}
+	if claims.Subject == "" {
+		return UserInfo{}, fmt.Errorf("sub not found in token")
+	}
+	return UserInfo{Username: claims.Subject}, nil
+}
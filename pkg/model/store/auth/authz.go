@@ -17,9 +17,12 @@ package auth
 import (
 	"context"
 	"fmt"
+	"path"
+	"strings"
 
 	"go.mongodb.org/mongo-driver/mongo"
 	"kubegems.io/kubegems/pkg/model/store/repository"
+	"kubegems.io/kubegems/pkg/utils"
 )
 
 const (
@@ -39,6 +42,29 @@ type AuthorizationManager interface {
 	HasPermission(ctx context.Context, username string, permission string) bool
 }
 
+type PasswordManager interface {
+	SetPassword(ctx context.Context, username string, password string) error
+	VerifyPassword(ctx context.Context, username string, password string) error
+}
+
+// MatchPermission checks the permission matches the pattern, "*" in pattern matches any part.
+// e.g. "repository:*:user/*" matches "repository:read:user/model"
+func MatchPermission(pattern, permission string) bool {
+	patterns, parts := strings.Split(pattern, ":"), strings.Split(permission, ":")
+	if len(patterns) != len(parts) {
+		return pattern == "*"
+	}
+	for i := range patterns {
+		if patterns[i] == "*" {
+			continue
+		}
+		if matched, _ := path.Match(patterns[i], parts[i]); !matched {
+			return false
+		}
+	}
+	return true
+}
+
 type LocalAuthorization struct {
 	repository *repository.AuthorizationRepository
 }
@@ -122,3 +148,25 @@ func (a *LocalAuthorization) HasPermission(ctx context.Context, username string,
 	}
 	return false
 }
+
+func (a *LocalAuthorization) SetPassword(ctx context.Context, username string, password string) error {
+	hashed, err := utils.MakePassword(password)
+	if err != nil {
+		return err
+	}
+	return a.repository.SetPassword(ctx, username, hashed)
+}
+
+func (a *LocalAuthorization) VerifyPassword(ctx context.Context, username string, password string) error {
+	authorization, err := a.repository.Get(ctx, username)
+	if err != nil {
+		return err
+	}
+	if authorization.Password == "" {
+		return fmt.Errorf("no password set for user %s", username)
+	}
+	if err := utils.ValidatePassword(password, authorization.Password); err != nil {
+		return fmt.Errorf("invalid password")
+	}
+	return nil
+}
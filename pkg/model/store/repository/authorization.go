@@ -55,6 +55,7 @@ func (a *AuthorizationRepository) InitSchema(ctx context.Context) error {
 type Authorization struct {
 	Username    string
 	Permissions []string
+	Password    string `bson:"password,omitempty" json:"-"` // hashed password
 }
 
 func (a *AuthorizationRepository) Set(ctx context.Context, authorization *Authorization) error {
@@ -69,6 +70,18 @@ func (a *AuthorizationRepository) Set(ctx context.Context, authorization *Author
 	return err
 }
 
+func (a *AuthorizationRepository) SetPassword(ctx context.Context, username string, hashedPassword string) error {
+	_, err := a.collection.UpdateOne(ctx,
+		bson.M{"username": username},
+		bson.M{"$set": bson.M{
+			"username": username,
+			"password": hashedPassword,
+		}},
+		options.Update().SetUpsert(true),
+	)
+	return err
+}
+
 func (a *AuthorizationRepository) Add(ctx context.Context, authorization *Authorization) error {
 	_, err := a.collection.InsertOne(ctx, authorization)
 	if err != nil {
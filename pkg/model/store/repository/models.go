@@ -250,11 +250,73 @@ func (m *ModelsRepository) Update(ctx context.Context, model *Model) error {
 	return nil
 }
 
+// Upsert creates or updates the model synced from source,
+// fields managed by admin (recomment, enabled...) are kept on update.
+func (m *ModelsRepository) Upsert(ctx context.Context, model *Model) error {
+	_, err := m.Collection.UpdateOne(ctx,
+		bson.M{"source": model.Source, "name": model.Name},
+		bson.M{
+			"$set": bson.M{
+				"tags":         model.Tags,
+				"author":       model.Author,
+				"license":      model.License,
+				"framework":    model.Framework,
+				"task":         model.Task,
+				"paper":        model.Paper,
+				"versions":     model.Versions,
+				"create_at":    model.CreateAt,
+				"update_at":    model.UpdateAt,
+				"lastModified": model.LastModified,
+				"annotations":  model.Annotations,
+			},
+			"$setOnInsert": bson.M{
+				"enabled": model.Enabled,
+			},
+		},
+		options.Update().SetUpsert(true),
+	)
+	return err
+}
+
 func (m *ModelsRepository) Delete(ctx context.Context, source, name string) error {
 	_, err := m.Collection.DeleteOne(ctx, bson.M{"source": source, "name": name})
 	return err
 }
 
+// DeleteNotIn removes models of the source which are not in names and have the annotation, returns the count deleted.
+// models without the annotation are not managed by the caller and kept.
+func (m *ModelsRepository) DeleteNotIn(ctx context.Context, source string, names []string, annotation string) (int64, error) {
+	if names == nil {
+		names = []string{}
+	}
+	// annotation keys may contain dots which can not be queried by mongo, filter them here
+	cur, err := m.Collection.Find(ctx,
+		bson.M{"source": source, "name": bson.M{"$nin": names}},
+		options.Find().SetProjection(bson.M{"name": 1, "annotations": 1}),
+	)
+	if err != nil {
+		return 0, err
+	}
+	candidates := []Model{}
+	if err := cur.All(ctx, &candidates); err != nil {
+		return 0, err
+	}
+	todelete := []string{}
+	for _, model := range candidates {
+		if _, ok := model.Annotations[annotation]; ok {
+			todelete = append(todelete, model.Name)
+		}
+	}
+	if len(todelete) == 0 {
+		return 0, nil
+	}
+	result, err := m.Collection.DeleteMany(ctx, bson.M{"source": source, "name": bson.M{"$in": todelete}})
+	if err != nil {
+		return 0, err
+	}
+	return result.DeletedCount, nil
+}
+
 type Selectors struct {
 	Tags       []string `json:"tags"`
 	Frameworks []string `json:"frameworks"`
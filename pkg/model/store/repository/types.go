@@ -64,6 +64,7 @@ type Model struct {
 type ModelFile struct {
 	Filename string `json:"filename"`
 	Content  string `json:"content"`
+	Size     int64  `json:"size,omitempty"` // size of file, the object size for lfs files
 }
 
 const (
@@ -57,9 +57,15 @@ func Run(ctx context.Context, options *options.Options) error {
 	eg.Go(func() error {
 		return tasks.RunTasksCollector(ctx, deps.Switcher, deps.Redis)
 	})
+	eg.Go(func() error {
+		return deps.Switcher.RunEscalation(ctx)
+	})
 	eg.Go(func() error {
 		return pprof.Run(ctx)
 	})
+	eg.Go(func() error {
+		return deps.AgentsClientSet.RunTunnel(ctx)
+	})
 	return eg.Wait()
 }
 
@@ -91,6 +97,7 @@ func prepareDependencies(ctx context.Context, options *options.Options) (*Depend
 	if err != nil {
 		return nil, err
 	}
+	agentclientset.EnableTunnel(options.Tunnel, "msgbus-")
 
 	// argo 客户端
 	argocli, err := argo.NewClient(ctx, options.Argo)
@@ -15,6 +15,7 @@
 package options
 
 import (
+	"kubegems.io/kubegems/pkg/utils/agents"
 	"kubegems.io/kubegems/pkg/utils/argo"
 	"kubegems.io/kubegems/pkg/utils/database"
 	"kubegems.io/kubegems/pkg/utils/jwt"
@@ -23,12 +24,13 @@ import (
 )
 
 type Options struct {
-	System   *system.Options   `json:"system,omitempty"`
-	Argo     *argo.Options     `json:"argo,omitempty"`
-	JWT      *jwt.Options      `json:"jwt,omitempty"`
-	LogLevel string            `json:"logLevel,omitempty"`
-	Mysql    *database.Options `json:"mysql,omitempty"`
-	Redis    *redis.Options    `json:"redis,omitempty"`
+	System   *system.Options       `json:"system,omitempty"`
+	Argo     *argo.Options         `json:"argo,omitempty"`
+	JWT      *jwt.Options          `json:"jwt,omitempty"`
+	LogLevel string                `json:"logLevel,omitempty"`
+	Mysql    *database.Options     `json:"mysql,omitempty"`
+	Redis    *redis.Options        `json:"redis,omitempty"`
+	Tunnel   *agents.TunnelOptions `json:"tunnel,omitempty"`
 }
 
 func DefaultOptions() *Options {
@@ -39,6 +41,7 @@ func DefaultOptions() *Options {
 		Mysql:    database.NewDefaultOptions(),
 		Redis:    redis.NewDefaultOptions(),
 		System:   system.NewDefaultOptions(),
+		Tunnel:   agents.NewDefaultTunnelOptions(),
 	}
 	defaultoptions.System.Listen = ":8020"
 	return defaultoptions
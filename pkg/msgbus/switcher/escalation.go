@@ -0,0 +1,297 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package switcher
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"kubegems.io/kubegems/pkg/log"
+	"kubegems.io/kubegems/pkg/service/models"
+	"kubegems.io/kubegems/pkg/utils/msgbus"
+	"kubegems.io/kubegems/pkg/utils/prometheus"
+	"kubegems.io/kubegems/pkg/utils/prometheus/channels"
+	"kubegems.io/kubegems/pkg/utils/set"
+)
+
+const (
+	escalationInterval = 30 * time.Second
+	// 渠道通知超时时间
+	channelNotifyTimeout = 30 * time.Second
+)
+
+// handleOnCallAlert 值班渠道的告警触发时开始通知，恢复时结束；
+// 恢复前重复发送的告警不会重新开始通知，已结束的通知保持结束
+func (ms *MessageSwitcher) handleOnCallAlert(webhookAlert prometheus.WebhookAlert) {
+	_, channelID := models.ChannelIDNameByReceiverName(webhookAlert.Receiver)
+	if channelID == 0 {
+		return
+	}
+	now := time.Now()
+	for fingerprint, alerts := range webhookAlert.FingerprintMap() {
+		latest := alerts[len(alerts)-1]
+		esc := &models.AlertEscalation{}
+		err := ms.DataBase.DB().Where("fingerprint = ? and alert_channel_id = ? and resolved_at is null", fingerprint, channelID).
+			Order("id desc").First(esc).Error
+		if err != nil && !models.IsNotFound(err) {
+			log.Error(err, "get alert escalation", "fingerprint", fingerprint)
+			continue
+		}
+		exist := err == nil
+		switch {
+		case latest.Status == "firing" && !exist:
+			content, _ := json.Marshal(prometheus.WebhookAlert{
+				Receiver:          webhookAlert.Receiver,
+				Status:            latest.Status,
+				Alerts:            []prometheus.Alert{latest},
+				CommonLabels:      latest.Labels,
+				CommonAnnotations: latest.Annotations,
+			})
+			esc = &models.AlertEscalation{
+				Fingerprint:    fingerprint,
+				AlertChannelID: channelID,
+				Alert:          content,
+				NextAt:         &now,
+			}
+			if err := ms.DataBase.DB().Create(esc).Error; err != nil {
+				log.Error(err, "create alert escalation", "fingerprint", fingerprint)
+				continue
+			}
+			ms.escalate(esc)
+		case latest.Status == "resolved" && exist:
+			if err := ms.DataBase.DB().Model(esc).
+				Updates(map[string]interface{}{"next_at": nil, "resolved_at": now}).Error; err != nil {
+				log.Error(err, "resolve alert escalation", "id", esc.ID)
+			}
+		}
+	}
+}
+
+// RunEscalation 定时检查到期未确认的值班告警并升级通知
+func (ms *MessageSwitcher) RunEscalation(ctx context.Context) error {
+	ticker := time.NewTicker(escalationInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			escs := []*models.AlertEscalation{}
+			if err := ms.DataBase.DB().Where("next_at <= ?", time.Now()).Find(&escs).Error; err != nil {
+				log.Error(err, "list alert escalations")
+				continue
+			}
+			for _, esc := range escs {
+				ms.escalate(esc)
+			}
+		}
+	}
+}
+
+// escalate 通知当前级别，并设置下次升级的时间
+func (ms *MessageSwitcher) escalate(esc *models.AlertEscalation) {
+	// 告警事件已确认或已解决则不再通知
+	var handled int64
+	if err := ms.DataBase.DB().Model(&models.AlertIncident{}).
+		Where("fingerprint = ? and (status = ? or (status = ? and resolved_at >= ?))",
+			esc.Fingerprint, models.IncidentStatusAcknowledged, models.IncidentStatusResolved, esc.CreatedAt).
+		Count(&handled).Error; err != nil {
+		log.Error(err, "count alert incidents", "fingerprint", esc.Fingerprint)
+		return
+	}
+	if handled > 0 {
+		ms.finishEscalation(esc)
+		return
+	}
+	policy, err := ms.escalationPolicyOf(esc.AlertChannelID)
+	if err != nil {
+		log.Error(err, "get escalation policy", "channel", esc.AlertChannelID)
+		ms.finishEscalation(esc)
+		return
+	}
+	level, ok := policy.LevelAt(esc.Step)
+	if !ok {
+		ms.finishEscalation(esc)
+		return
+	}
+	alert := prometheus.WebhookAlert{}
+	if err := json.Unmarshal(esc.Alert, &alert); err != nil {
+		log.Error(err, "unmarshal alert", "escalation", esc.ID)
+		ms.finishEscalation(esc)
+		return
+	}
+	// 先按当前进度更新，更新成功才通知，避免定时检查与告警同时通知同一级别
+	var nextAt *time.Time
+	if _, ok := policy.LevelAt(esc.Step + 1); ok {
+		next := time.Now().Add(time.Duration(level.DelayMinutes) * time.Minute)
+		nextAt = &next
+	}
+	result := ms.DataBase.DB().Model(&models.AlertEscalation{}).
+		Where("id = ? and step = ? and next_at is not null", esc.ID, esc.Step).
+		Updates(map[string]interface{}{"step": esc.Step + 1, "next_at": nextAt})
+	if result.Error != nil {
+		log.Error(result.Error, "update alert escalation", "id", esc.ID)
+		return
+	}
+	if result.RowsAffected == 0 {
+		// 已被通知或已结束
+		return
+	}
+	ms.notifyLevel(esc, level, alert)
+}
+
+func (ms *MessageSwitcher) finishEscalation(esc *models.AlertEscalation) {
+	if err := ms.DataBase.DB().Model(esc).Update("next_at", nil).Error; err != nil {
+		log.Error(err, "finish alert escalation", "id", esc.ID)
+	}
+}
+
+// escalationPolicyOf 值班渠道对应的升级策略，只配置了值班表时只通知一次当前值班人
+func (ms *MessageSwitcher) escalationPolicyOf(channelID uint) (*models.EscalationPolicy, error) {
+	ch := &models.AlertChannel{}
+	if err := ms.DataBase.DB().First(ch, "id = ?", channelID).Error; err != nil {
+		return nil, err
+	}
+	oncall, ok := ch.ChannelConfig.ChannelIf.(*channels.OnCall)
+	if !ok {
+		return nil, fmt.Errorf("channel %s is not an oncall channel", ch.Name)
+	}
+	if oncall.ScheduleID != 0 {
+		return &models.EscalationPolicy{
+			Levels: models.EscalationLevels{{ScheduleIDs: []uint{oncall.ScheduleID}}},
+		}, nil
+	}
+	policy := &models.EscalationPolicy{}
+	if err := ms.DataBase.DB().First(policy, "id = ?", oncall.EscalationPolicyID).Error; err != nil {
+		return nil, err
+	}
+	return policy, nil
+}
+
+// notifyLevel 通知级别中的值班人、用户及告警渠道，并记录到告警事件的时间线
+func (ms *MessageSwitcher) notifyLevel(esc *models.AlertEscalation, level *models.EscalationLevel, alert prometheus.WebhookAlert) {
+	now := time.Now()
+	// 告警事件可能还未创建
+	incident := &models.AlertIncident{}
+	if err := ms.DataBase.DB().Where("fingerprint = ? and status <> ?", esc.Fingerprint, models.IncidentStatusResolved).
+		Order("id desc").First(incident).Error; err != nil && !models.IsNotFound(err) {
+		log.Error(err, "get alert incident", "fingerprint", esc.Fingerprint)
+	}
+	usernames := set.NewSet[string]().Append(level.Users...)
+	for _, id := range level.ScheduleIDs {
+		schedule := &models.OnCallSchedule{}
+		if err := ms.DataBase.DB().First(schedule, "id = ?", id).Error; err != nil {
+			log.Error(err, "get oncall schedule", "id", id)
+			continue
+		}
+		if user := schedule.OnCallAt(now); user != "" {
+			usernames.Append(user)
+		}
+	}
+
+	msgs := channels.NewAlertMessages(alert)
+	detail := msgs.Title
+	if len(msgs.Messages) > 0 {
+		detail = fmt.Sprintf("%s %s", msgs.Title, msgs.Messages[0].Message)
+	}
+	if usernames.Len() > 0 {
+		users := []models.User{}
+		if err := ms.DataBase.DB().Find(&users, "username in ?", usernames.Slice()).Error; err != nil {
+			log.Error(err, "list oncall users")
+		}
+		ms.sendOnCallMessage(users, incident.ID, detail)
+	}
+
+	notified := usernames.Slice()
+	for _, id := range level.ChannelIDs {
+		ch := &models.AlertChannel{}
+		if err := ms.DataBase.DB().First(ch, "id = ?", id).Error; err != nil {
+			log.Error(err, "get alert channel", "id", id)
+			continue
+		}
+		// 避免循环通知
+		if _, ok := ch.ChannelConfig.ChannelIf.(*channels.OnCall); ok {
+			continue
+		}
+		// 异步发送，避免阻塞升级检查
+		go notifyChannel(ch.Name, ch.ChannelConfig.ChannelIf, alert)
+		notified = append(notified, ch.Name)
+	}
+
+	if incident.ID == 0 {
+		return
+	}
+	if err := ms.DataBase.DB().Create(&models.AlertIncidentEvent{
+		AlertIncidentID: incident.ID,
+		Kind:            models.IncidentEventEscalated,
+		Creator:         models.IncidentSystemCreator,
+		Content:         fmt.Sprintf("第%d次通知: %s", esc.Step+1, strings.Join(notified, ",")),
+	}).Error; err != nil {
+		log.Error(err, "save alert incident event", "incident", incident.ID)
+	}
+}
+
+// notifyChannel 发送告警到渠道，超时后取消
+func notifyChannel(name string, ch channels.ChannelIf, alert prometheus.WebhookAlert) {
+	ctx, cancel := context.WithTimeout(context.Background(), channelNotifyTimeout)
+	defer cancel()
+	if err := ch.Notify(ctx, alert); err != nil {
+		log.Error(err, "notify alert channel", "channel", name)
+	}
+}
+
+// sendOnCallMessage 发送站内消息给值班人员，并保存到用户消息表
+func (ms *MessageSwitcher) sendOnCallMessage(users []models.User, incidentID uint, detail string) {
+	if len(users) == 0 {
+		return
+	}
+	content := msgbus.MessageContent{
+		ResourceType: msgbus.Incident,
+		ResouceID:    incidentID,
+		CreatedAt:    time.Now(),
+		From:         models.IncidentSystemCreator,
+		Detail:       detail,
+	}
+	for _, u := range users {
+		content.To = append(content.To, u.ID)
+	}
+	contentJson, _ := json.Marshal(content)
+	dbmsg := models.Message{
+		MessageType: string(msgbus.Message),
+		Title:       detail,
+		CreatedAt:   content.CreatedAt,
+		Content:     contentJson,
+	}
+	if err := ms.DataBase.DB().Save(&dbmsg).Error; err != nil {
+		log.Error(err, "save oncall message")
+		return
+	}
+	usermsgs := make([]models.UserMessageStatus, len(users))
+	for i := range users {
+		usermsgs[i].UserID = users[i].ID
+		usermsgs[i].MessageID = &dbmsg.ID
+		ms.SendMessageToUser(&msgbus.NotifyMessage{
+			MessageType: msgbus.Message,
+			EventKind:   msgbus.Add,
+			Content:     content,
+		}, users[i].ID)
+	}
+	if err := ms.DataBase.DB().Create(&usermsgs).Error; err != nil {
+		log.Error(err, "save oncall user message")
+	}
+}
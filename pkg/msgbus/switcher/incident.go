@@ -0,0 +1,150 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package switcher
+
+import (
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+	"kubegems.io/kubegems/pkg/i18n"
+	"kubegems.io/kubegems/pkg/log"
+	"kubegems.io/kubegems/pkg/service/models"
+	"kubegems.io/kubegems/pkg/utils/msgbus"
+	"kubegems.io/kubegems/pkg/utils/prometheus"
+	"kubegems.io/kubegems/pkg/utils/set"
+)
+
+// syncIncidents 按指纹将告警归并到未解决的告警事件中，返回状态有变化的事件
+// 告警触发时如果没有未解决的事件则新建，告警恢复时自动解决
+// 告警仍在触发时被手动解决的事件，在告警恢复前不再新建事件
+func syncIncidents(db *gorm.DB, fingerprintMap map[string][]prometheus.Alert) []*models.AlertIncident {
+	now := time.Now()
+	changed := []*models.AlertIncident{}
+	for fingerprint, alerts := range fingerprintMap {
+		latest := alerts[len(alerts)-1]
+		incident := &models.AlertIncident{}
+		err := db.Preload("AlertInfo").
+			Where("fingerprint = ? and status <> ?", fingerprint, models.IncidentStatusResolved).
+			Order("id desc").First(incident).Error
+		if err != nil && !models.IsNotFound(err) {
+			log.Error(err, "get alert incident", "fingerprint", fingerprint)
+			continue
+		}
+		exist := err == nil
+		event := &models.AlertIncidentEvent{
+			Creator: models.IncidentSystemCreator,
+			Content: latest.Annotations[prometheus.MessageAnnotationsKey],
+		}
+		switch {
+		case latest.Status == "firing" && !exist:
+			if manuallyResolved(db, fingerprint, latest) {
+				continue
+			}
+			incident = &models.AlertIncident{
+				Fingerprint: fingerprint,
+				Severity:    latest.Labels[prometheus.SeverityLabel],
+				Message:     latest.Annotations[prometheus.MessageAnnotationsKey],
+				Status:      models.IncidentStatusOpen,
+				StartsAt:    latest.StartsAt,
+				AlertInfo:   &models.AlertInfo{Name: latest.Labels[prometheus.AlertNameLabel]},
+			}
+			event.Kind = models.IncidentEventFiring
+		case latest.Status == "firing":
+			// 重复告警只更新消息
+			if err := db.Model(incident).Updates(map[string]interface{}{
+				"severity": latest.Labels[prometheus.SeverityLabel],
+				"message":  latest.Annotations[prometheus.MessageAnnotationsKey],
+			}).Error; err != nil {
+				log.Error(err, "update alert incident", "id", incident.ID)
+			}
+			continue
+		case exist:
+			incident.Status = models.IncidentStatusResolved
+			incident.ResolvedAt = &now
+			incident.AlertEndsAt = &now
+			event.Kind = models.IncidentEventResolved
+		default:
+			// 手动解决的事件在告警恢复后结束抑制
+			if err := db.Model(&models.AlertIncident{}).
+				Where("fingerprint = ? and status = ? and alert_ends_at is null", fingerprint, models.IncidentStatusResolved).
+				Update("alert_ends_at", now).Error; err != nil {
+				log.Error(err, "update alert incident", "fingerprint", fingerprint)
+			}
+			continue
+		}
+		if err := db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Omit("AlertInfo").Save(incident).Error; err != nil {
+				return err
+			}
+			event.AlertIncidentID = incident.ID
+			return tx.Create(event).Error
+		}); err != nil {
+			log.Error(err, "save alert incident", "fingerprint", fingerprint)
+			continue
+		}
+		changed = append(changed, incident)
+	}
+	return changed
+}
+
+// manuallyResolved 判断告警是否属于告警仍在触发时被手动解决的事件
+func manuallyResolved(db *gorm.DB, fingerprint string, alert prometheus.Alert) bool {
+	incident := &models.AlertIncident{}
+	if err := db.Where("fingerprint = ? and status = ? and alert_ends_at is null", fingerprint, models.IncidentStatusResolved).
+		Order("id desc").First(incident).Error; err != nil {
+		if !models.IsNotFound(err) {
+			log.Error(err, "get alert incident", "fingerprint", fingerprint)
+		}
+		return false
+	}
+	// 开始时间不同说明告警已经恢复过，只是没有收到恢复通知
+	if alert.StartsAt != nil && incident.StartsAt != nil {
+		if d := alert.StartsAt.Sub(*incident.StartsAt); d > time.Second || d < -time.Second {
+			return false
+		}
+	}
+	return true
+}
+
+// notifyIncidents 通知在线用户告警事件的状态变化
+func (ms *MessageSwitcher) notifyIncidents(incidents []*models.AlertIncident, toUsers *set.Set[uint]) {
+	now := time.Now()
+	for _, incident := range incidents {
+		name := ""
+		if incident.AlertInfo != nil {
+			name = incident.AlertInfo.Name
+		}
+		detail := i18n.Sprintf(context.TODO(), "alert incident %s opened", name)
+		if incident.IsResolved() {
+			detail = i18n.Sprintf(context.TODO(), "alert incident %s resolved", name)
+		}
+		for _, u := range ms.Users {
+			if toUsers.Has(u.UserID) {
+				_ = ms.Send(u, &msgbus.NotifyMessage{
+					MessageType: msgbus.Message,
+					EventKind:   msgbus.Update,
+					Content: msgbus.MessageContent{
+						ResourceType: msgbus.Incident,
+						ResouceID:    incident.ID,
+						CreatedAt:    now,
+						From:         models.IncidentSystemCreator,
+						Detail:       detail,
+					},
+				})
+			}
+		}
+	}
+}
@@ -0,0 +1,122 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package switcher
+
+import (
+	"testing"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"kubegems.io/kubegems/pkg/service/models"
+	"kubegems.io/kubegems/pkg/utils/prometheus"
+)
+
+func setupIncidentDB(t *testing.T) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open(t.TempDir()+"/incident.db"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&models.AlertInfo{}, &models.AlertIncident{}, &models.AlertIncidentEvent{}); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func incidentAlert(status, message string) prometheus.Alert {
+	return prometheus.Alert{
+		Status:      status,
+		Labels:      map[string]string{prometheus.AlertNameLabel: "cpu-usage", prometheus.SeverityLabel: "error"},
+		Annotations: map[string]string{prometheus.MessageAnnotationsKey: message},
+	}
+}
+
+func incidentTimeline(t *testing.T, db *gorm.DB, id uint) []string {
+	events := []models.AlertIncidentEvent{}
+	if err := db.Order("id").Find(&events, "alert_incident_id = ?", id).Error; err != nil {
+		t.Fatal(err)
+	}
+	kinds := []string{}
+	for _, e := range events {
+		kinds = append(kinds, e.Kind)
+	}
+	return kinds
+}
+
+func Test_syncIncidents(t *testing.T) {
+	db := setupIncidentDB(t)
+	if err := db.Create(&models.AlertInfo{Fingerprint: "fp", Name: "cpu-usage"}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	// 告警触发时新建事件
+	changed := syncIncidents(db, map[string][]prometheus.Alert{"fp": {incidentAlert("firing", "cpu 90%")}})
+	if len(changed) != 1 || changed[0].Status != models.IncidentStatusOpen {
+		t.Fatalf("open: changed = %v, want one open incident", changed)
+	}
+	first := changed[0].ID
+
+	// 重复告警只更新消息，不产生新的事件和时间线
+	if changed := syncIncidents(db, map[string][]prometheus.Alert{"fp": {incidentAlert("firing", "cpu 95%")}}); len(changed) != 0 {
+		t.Fatalf("repeat: changed = %v, want none", changed)
+	}
+	incident := &models.AlertIncident{}
+	if err := db.First(incident, first).Error; err != nil {
+		t.Fatal(err)
+	}
+	if incident.Message != "cpu 95%" || incident.Status != models.IncidentStatusOpen {
+		t.Errorf("repeat: message = %s, status = %s", incident.Message, incident.Status)
+	}
+	var count int64
+	db.Model(&models.AlertIncident{}).Count(&count)
+	if count != 1 {
+		t.Errorf("repeat: incidents = %d, want 1", count)
+	}
+
+	// 告警恢复时自动解决
+	changed = syncIncidents(db, map[string][]prometheus.Alert{"fp": {incidentAlert("resolved", "cpu 30%")}})
+	if len(changed) != 1 || changed[0].ID != first || !changed[0].IsResolved() || changed[0].ResolvedAt == nil {
+		t.Fatalf("resolve: changed = %v, want incident %d resolved", changed, first)
+	}
+	if got := incidentTimeline(t, db, first); len(got) != 2 || got[0] != models.IncidentEventFiring || got[1] != models.IncidentEventResolved {
+		t.Errorf("resolve: timeline = %v", got)
+	}
+
+	// 没有未解决事件时的恢复通知被忽略
+	if changed := syncIncidents(db, map[string][]prometheus.Alert{"fp": {incidentAlert("resolved", "cpu 30%")}}); len(changed) != 0 {
+		t.Errorf("resolve again: changed = %v, want none", changed)
+	}
+
+	// 解决后再次触发产生新的事件
+	changed = syncIncidents(db, map[string][]prometheus.Alert{"fp": {incidentAlert("firing", "cpu 91%")}})
+	if len(changed) != 1 || changed[0].ID == first || changed[0].Status != models.IncidentStatusOpen {
+		t.Fatalf("reopen: changed = %v, want a new open incident", changed)
+	}
+	second := changed[0].ID
+
+	// 告警仍在触发时手动解决，告警恢复前不产生新的事件
+	if err := db.Model(&models.AlertIncident{}).Where("id = ?", second).Update("status", models.IncidentStatusResolved).Error; err != nil {
+		t.Fatal(err)
+	}
+	if changed := syncIncidents(db, map[string][]prometheus.Alert{"fp": {incidentAlert("firing", "cpu 92%")}}); len(changed) != 0 {
+		t.Fatalf("repeat after manually resolved: changed = %v, want none", changed)
+	}
+	if changed := syncIncidents(db, map[string][]prometheus.Alert{"fp": {incidentAlert("resolved", "cpu 30%")}}); len(changed) != 0 {
+		t.Fatalf("resolve after manually resolved: changed = %v, want none", changed)
+	}
+	changed = syncIncidents(db, map[string][]prometheus.Alert{"fp": {incidentAlert("firing", "cpu 93%")}})
+	if len(changed) != 1 || changed[0].ID == second || changed[0].Status != models.IncidentStatusOpen {
+		t.Fatalf("reopen after manually resolved: changed = %v, want a new open incident", changed)
+	}
+}
@@ -73,6 +73,8 @@ func (ms *MessageSwitcher) DispatchMessage(msg *msgbus.NotifyMessage) {
 		// 存告警消息表
 		fingerprintMap := webhookAlert.FingerprintMap()
 		dbalertMsgs := ms.saveFingerprintMapToDB(fingerprintMap)
+		// 告警事件需要在告警信息入库之后更新
+		incidents := syncIncidents(ms.DataBase.DB(), fingerprintMap)
 
 		// 发消息并存用户消息表
 		_, isMonitor := webhookAlert.CommonLabels["prometheus"]
@@ -113,6 +115,8 @@ func (ms *MessageSwitcher) DispatchMessage(msg *msgbus.NotifyMessage) {
 			dbUserMsgs = append(dbUserMsgs, usermsgs...)
 		}
 
+		ms.notifyIncidents(incidents, toUsers)
+
 		if err := ms.DataBase.DB().Save(&dbUserMsgs).Error; err != nil {
 			log.Error(err, "save user message status")
 			return
@@ -125,6 +129,18 @@ func (ms *MessageSwitcher) DispatchMessage(msg *msgbus.NotifyMessage) {
 			len(webhookAlert.Alerts),
 			len(dbUserMsgs),
 		)
+	case msgbus.OnCall:
+		webhookAlert := prometheus.WebhookAlert{}
+		b, ok := msg.Content.(string)
+		if !ok {
+			log.Errorf("content type is not string: %s", msg.Content)
+			return
+		}
+		if err := json.Unmarshal([]byte(b), &webhookAlert); err != nil {
+			log.Error(err, "json unmarshal error")
+			return
+		}
+		ms.handleOnCallAlert(webhookAlert)
 	case msgbus.Changed:
 		for _, u := range ms.Users {
 			if u.IsWatchObject(msg) {
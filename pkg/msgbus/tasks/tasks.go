@@ -16,11 +16,15 @@ package tasks
 
 import (
 	"context"
+	"sync"
+	"time"
 
 	"kubegems.io/kubegems/pkg/apis/gems"
+	"kubegems.io/kubegems/pkg/i18n"
 	"kubegems.io/kubegems/pkg/log"
 	"kubegems.io/kubegems/pkg/msgbus/switcher"
 	"kubegems.io/kubegems/pkg/service/handlers/application"
+	"kubegems.io/kubegems/pkg/service/models"
 	"kubegems.io/kubegems/pkg/utils/msgbus"
 	"kubegems.io/kubegems/pkg/utils/redis"
 	"kubegems.io/kubegems/pkg/utils/retry"
@@ -30,8 +34,14 @@ import (
 type TaskProducer struct {
 	Bus             *switcher.MessageSwitcher
 	ApplicationTask *application.TaskProcessor
+
+	mu          sync.Mutex
+	interrupted map[string]workflow.TaskStatusCode // uid -> 已通知的中断状态
 }
 
+// 记录的中断状态超过该数量后清空，避免无限增长
+const maxInterruptedTasks = 1024
+
 func RunTasksCollector(ctx context.Context, ms *switcher.MessageSwitcher, redis *redis.Client) error {
 	task := &TaskProducer{
 		Bus: ms,
@@ -54,6 +64,8 @@ func (p *TaskProducer) runapplicationtasks(ctx context.Context) error {
 		if len(task.Addtionals) == 0 {
 			return nil
 		}
+		// 取消和暂停的任务同样推送，前端根据状态展示，同时通知任务的提交者
+		p.notifyInterrupted(task)
 		msg := &msgbus.NotifyMessage{
 			MessageType: msgbus.Changed,
 			EventKind:   msgbus.Update,
@@ -76,3 +88,55 @@ func (p *TaskProducer) runapplicationtasks(ctx context.Context) error {
 	}
 	return nil
 }
+
+// notifyInterrupted 任务被取消或者暂停时通知任务的提交者，每个状态只通知一次
+func (p *TaskProducer) notifyInterrupted(task *workflow.Task) {
+	if task.Status == nil {
+		return
+	}
+	status := task.Status.Status
+	p.mu.Lock()
+	if p.interrupted == nil || len(p.interrupted) > maxInterruptedTasks {
+		p.interrupted = map[string]workflow.TaskStatusCode{}
+	}
+	notified := p.interrupted[task.UID]
+	switch status {
+	case workflow.TaskStatusCancelled, workflow.TaskStatusPaused:
+		p.interrupted[task.UID] = status
+	default:
+		delete(p.interrupted, task.UID)
+	}
+	p.mu.Unlock()
+
+	var detail string
+	appname, typ := task.Addtionals[application.LabelApplication], task.Addtionals["type"]
+	switch {
+	case notified == status:
+		return
+	case status == workflow.TaskStatusCancelled:
+		detail = i18n.Sprintf(context.TODO(), "task %s of application %s cancelled", typ, appname)
+	case status == workflow.TaskStatusPaused:
+		detail = i18n.Sprintf(context.TODO(), "task %s of application %s paused", typ, appname)
+	default:
+		return
+	}
+	committer := task.Addtionals[application.TaskAddtionalKeyCommiter]
+	if committer == "" || p.Bus.DataBase == nil {
+		return
+	}
+	user := &models.User{}
+	if err := p.Bus.DataBase.DB().First(user, "username = ?", committer).Error; err != nil {
+		log.Error(err, "get task committer", "username", committer)
+		return
+	}
+	p.Bus.SendMessageToUser(&msgbus.NotifyMessage{
+		MessageType: msgbus.Message,
+		EventKind:   msgbus.Update,
+		Content: msgbus.MessageContent{
+			ResourceType: msgbus.Application,
+			CreatedAt:    time.Now(),
+			From:         committer,
+			Detail:       detail,
+		},
+	}, user.ID)
+}
@@ -134,7 +134,7 @@ func (c *AgentMessageCollector) MessageChan(ctx context.Context, clustername str
 				case msgbus.Changed:
 					tmp.InvolvedObject.Cluster = clustername
 					c.messageCh <- &tmp
-				case msgbus.Alert:
+				case msgbus.Alert, msgbus.OnCall:
 					c.messageCh <- &tmp
 				}
 			}
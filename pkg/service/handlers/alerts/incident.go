@@ -0,0 +1,399 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package alerthandler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/common/model"
+	"gorm.io/gorm"
+	"kubegems.io/kubegems/pkg/i18n"
+	"kubegems.io/kubegems/pkg/log"
+	msgclient "kubegems.io/kubegems/pkg/msgbus/client"
+	"kubegems.io/kubegems/pkg/msgbus/switcher"
+	"kubegems.io/kubegems/pkg/service/handlers"
+	"kubegems.io/kubegems/pkg/service/models"
+	"kubegems.io/kubegems/pkg/service/observe"
+	"kubegems.io/kubegems/pkg/utils/msgbus"
+)
+
+// IncidentReq 告警事件操作
+type IncidentReq struct {
+	Note            string `json:"note"`            // 备注
+	Link            string `json:"link"`            // 关联链接，仅添加备注时使用
+	Assignee        string `json:"assignee"`        // 处理人，指派时必填，确认时默认为当前用户
+	SilenceDuration string `json:"silenceDuration"` // 确认时创建的静默时长，为空不创建, eg. 2h
+}
+
+// ListIncidents 告警事件列表
+// @Tags        Alert
+// @Summary     告警事件列表
+// @Description 告警事件列表
+// @Accept      json
+// @Produce     json
+// @Param       tenant_id   path     string                                                                         true  "租户ID, _all 为所有租户"
+// @Param       cluster     query    string                                                                         false "集群, 默认所有"
+// @Param       namespace   query    string                                                                         false "命名空间, 默认所有"
+// @Param       project     query    string                                                                         false "项目, 默认所有"
+// @Param       environment query    string                                                                         false "环境, 默认所有"
+// @Param       status      query    string                                                                         false "状态, open/acknowledged/resolved, 默认所有"
+// @Param       assignee    query    string                                                                         false "处理人, 默认所有"
+// @Param       page        query    int                                                                            false "page"
+// @Param       size        query    int                                                                            false "size"
+// @Success     200         {object} handlers.ResponseStruct{Data=pagination.PageData{List=[]models.AlertIncident}} "resp"
+// @Router      /v1/alerts/tenant/{tenant_id}/incidents [get]
+// @Security    JWT
+func (h *AlertsHandler) ListIncidents(c *gin.Context) {
+	var ret []models.AlertIncident
+	query, err := handlers.GetQuery(c, nil)
+	if err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	cond := &handlers.PageQueryCond{
+		Model:  "AlertIncident",
+		Join:   handlers.Args("left join alert_infos on alert_incidents.fingerprint = alert_infos.fingerprint"),
+		Select: handlers.Args("alert_incidents.*"),
+	}
+	ctx := c.Request.Context()
+	if tenantID := c.Param("tenant_id"); tenantID != "_all" {
+		t := models.Tenant{}
+		if err := h.GetDB().WithContext(ctx).First(&t, "id = ?", tenantID).Error; err != nil {
+			handlers.NotOK(c, err)
+			return
+		}
+		cond.Where = append(cond.Where, handlers.Args("alert_infos.tenant_name = ?", t.TenantName))
+	}
+	for param, column := range map[string]string{
+		"cluster":     "alert_infos.cluster_name",
+		"namespace":   "alert_infos.namespace",
+		"project":     "alert_infos.project_name",
+		"environment": "alert_infos.environment_name",
+		"status":      "alert_incidents.status",
+		"assignee":    "alert_incidents.assignee",
+	} {
+		if v := c.Query(param); v != "" {
+			cond.Where = append(cond.Where, handlers.Args(column+" = ?", v))
+		}
+	}
+
+	total, page, size, err := query.PageList(h.GetDB().WithContext(ctx).Preload("AlertInfo").Order("alert_incidents.id desc"), cond, &ret)
+	if err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	handlers.OK(c, handlers.Page(total, ret, int64(page), int64(size)))
+}
+
+// GetIncident 告警事件详情
+// @Tags        Alert
+// @Summary     告警事件详情
+// @Description 告警事件详情，包括时间线
+// @Accept      json
+// @Produce     json
+// @Param       cluster     path     string                                          true "集群"
+// @Param       namespace   path     string                                          true "命名空间"
+// @Param       incident_id path     uint                                            true "告警事件ID"
+// @Success     200         {object} handlers.ResponseStruct{Data=models.AlertIncident} "resp"
+// @Router      /v1/alerts/cluster/{cluster}/namespaces/{namespace}/incidents/{incident_id} [get]
+// @Security    JWT
+func (h *AlertsHandler) GetIncident(c *gin.Context) {
+	incident := &models.AlertIncident{}
+	tx := h.GetDB().WithContext(c.Request.Context()).
+		Preload("Timeline", func(tx *gorm.DB) *gorm.DB { return tx.Order("id") })
+	if err := getIncident(c, tx, incident); err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	handlers.OK(c, incident)
+}
+
+// AcknowledgeIncident 确认告警事件
+// @Tags        Alert
+// @Summary     确认告警事件
+// @Description 确认告警事件，可同时为该告警创建一段时间的静默
+// @Accept      json
+// @Produce     json
+// @Param       cluster     path     string                               true "集群"
+// @Param       namespace   path     string                               true "命名空间"
+// @Param       incident_id path     uint                                 true "告警事件ID"
+// @Param       form        body     IncidentReq                          true "备注、处理人及静默时长"
+// @Success     200         {object} handlers.ResponseStruct{Data=string} "resp"
+// @Router      /v1/alerts/cluster/{cluster}/namespaces/{namespace}/incidents/{incident_id}/acknowledge [post]
+// @Security    JWT
+func (h *AlertsHandler) AcknowledgeIncident(c *gin.Context) {
+	h.changeIncident(c, "acknowledge", "acknowledged alert incident %s", acknowledgeIncident,
+		// 静默在事务提交后创建，避免事务回滚后遗留静默
+		func(ctx context.Context, incident *models.AlertIncident, req *IncidentReq, username string) error {
+			if req.SilenceDuration == "" {
+				return nil
+			}
+			if err := h.silenceIncident(ctx, incident, req.SilenceDuration, username); err != nil {
+				return fmt.Errorf("告警事件已确认, 创建静默失败: %w", err)
+			}
+			return nil
+		})
+}
+
+// AssignIncident 指派告警事件
+// @Tags        Alert
+// @Summary     指派告警事件
+// @Description 指派告警事件的处理人
+// @Accept      json
+// @Produce     json
+// @Param       cluster     path     string                               true "集群"
+// @Param       namespace   path     string                               true "命名空间"
+// @Param       incident_id path     uint                                 true "告警事件ID"
+// @Param       form        body     IncidentReq                          true "处理人及备注"
+// @Success     200         {object} handlers.ResponseStruct{Data=string} "resp"
+// @Router      /v1/alerts/cluster/{cluster}/namespaces/{namespace}/incidents/{incident_id}/assign [post]
+// @Security    JWT
+func (h *AlertsHandler) AssignIncident(c *gin.Context) {
+	h.changeIncident(c, "assign", "assigned alert incident %s", assignIncident, nil)
+}
+
+// ResolveIncident 解决告警事件
+// @Tags        Alert
+// @Summary     解决告警事件
+// @Description 手动解决告警事件，告警再次触发时会产生新的事件
+// @Accept      json
+// @Produce     json
+// @Param       cluster     path     string                               true "集群"
+// @Param       namespace   path     string                               true "命名空间"
+// @Param       incident_id path     uint                                 true "告警事件ID"
+// @Param       form        body     IncidentReq                          true "备注"
+// @Success     200         {object} handlers.ResponseStruct{Data=string} "resp"
+// @Router      /v1/alerts/cluster/{cluster}/namespaces/{namespace}/incidents/{incident_id}/resolve [post]
+// @Security    JWT
+func (h *AlertsHandler) ResolveIncident(c *gin.Context) {
+	h.changeIncident(c, "resolve", "resolved alert incident %s", resolveIncident, nil)
+}
+
+// AddIncidentNote 添加告警事件备注
+// @Tags        Alert
+// @Summary     添加告警事件备注
+// @Description 添加告警事件备注或关联链接
+// @Accept      json
+// @Produce     json
+// @Param       cluster     path     string                               true "集群"
+// @Param       namespace   path     string                               true "命名空间"
+// @Param       incident_id path     uint                                 true "告警事件ID"
+// @Param       form        body     IncidentReq                          true "备注及链接"
+// @Success     200         {object} handlers.ResponseStruct{Data=string} "resp"
+// @Router      /v1/alerts/cluster/{cluster}/namespaces/{namespace}/incidents/{incident_id}/notes [post]
+// @Security    JWT
+func (h *AlertsHandler) AddIncidentNote(c *gin.Context) {
+	h.changeIncident(c, "add", "added note to alert incident %s", noteIncident, nil)
+}
+
+// changeIncident 在事务中修改告警事件并记录时间线，提交后执行 after，成功后通过消息总线通知相关用户
+func (h *AlertsHandler) changeIncident(c *gin.Context, action, detailFormat string,
+	f incidentAction,
+	after func(ctx context.Context, incident *models.AlertIncident, req *IncidentReq, username string) error,
+) {
+	u, exist := h.GetContextUser(c)
+	if !exist {
+		handlers.NotOK(c, fmt.Errorf("not login"))
+		return
+	}
+	req := &IncidentReq{}
+	if err := c.BindJSON(req); err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	ctx := c.Request.Context()
+	incident := &models.AlertIncident{}
+	if err := h.GetDB().WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := getIncident(c, tx, incident); err != nil {
+			return err
+		}
+		events, err := f(tx, incident, req, u.GetUsername())
+		if err != nil {
+			return err
+		}
+		if err := tx.Omit("AlertInfo", "Timeline").Save(incident).Error; err != nil {
+			return err
+		}
+		for _, event := range events {
+			event.AlertIncidentID = incident.ID
+			event.Creator = u.GetUsername()
+		}
+		return tx.Create(&events).Error
+	}); err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	if after != nil {
+		if err := after(ctx, incident, req, u.GetUsername()); err != nil {
+			handlers.NotOK(c, err)
+			return
+		}
+	}
+
+	info := incident.AlertInfo
+	h.SetAuditData(c, i18n.Sprintf(ctx, action), i18n.Sprintf(ctx, "alert incident"), info.Name)
+	h.SendToMsgbus(c, func(msg *msgclient.MsgRequest) {
+		msg.EventKind = msgbus.Update
+		msg.ResourceType = msgbus.Incident
+		msg.ResourceID = incident.ID
+		msg.Detail = i18n.Sprintf(ctx, detailFormat, info.Name)
+		msg.ToUsers.Append(h.incidentUsers(info)...)
+	})
+	handlers.OK(c, "ok")
+}
+
+// incidentAction 告警事件的状态变更，返回需要记录的时间线
+type incidentAction func(tx *gorm.DB, incident *models.AlertIncident, req *IncidentReq, username string) ([]*models.AlertIncidentEvent, error)
+
+// acknowledgeIncident 确认未处理的告警事件，未指定处理人时指派给当前用户
+func acknowledgeIncident(tx *gorm.DB, incident *models.AlertIncident, req *IncidentReq, username string) ([]*models.AlertIncidentEvent, error) {
+	if incident.Status != models.IncidentStatusOpen {
+		return nil, fmt.Errorf("告警事件状态为%s, 不能确认", incident.Status)
+	}
+	if req.SilenceDuration != "" {
+		if _, err := model.ParseDuration(req.SilenceDuration); err != nil {
+			return nil, err
+		}
+	}
+	now := time.Now()
+	incident.Status = models.IncidentStatusAcknowledged
+	incident.AcknowledgedAt = &now
+	events := []*models.AlertIncidentEvent{{Kind: models.IncidentEventAcknowledged, Content: req.Note}}
+	assignee := req.Assignee
+	if assignee == "" {
+		assignee = username
+	}
+	if assignee != incident.Assignee {
+		if err := setIncidentAssignee(tx, incident, assignee); err != nil {
+			return nil, err
+		}
+		events = append(events, &models.AlertIncidentEvent{Kind: models.IncidentEventAssigned, Content: assignee})
+	}
+	return events, nil
+}
+
+func assignIncident(tx *gorm.DB, incident *models.AlertIncident, req *IncidentReq, username string) ([]*models.AlertIncidentEvent, error) {
+	if incident.IsResolved() {
+		return nil, fmt.Errorf("告警事件已解决")
+	}
+	if req.Assignee == "" {
+		return nil, fmt.Errorf("处理人不能为空")
+	}
+	if err := setIncidentAssignee(tx, incident, req.Assignee); err != nil {
+		return nil, err
+	}
+	events := []*models.AlertIncidentEvent{{Kind: models.IncidentEventAssigned, Content: req.Assignee}}
+	if req.Note != "" {
+		events = append(events, &models.AlertIncidentEvent{Kind: models.IncidentEventNote, Content: req.Note})
+	}
+	return events, nil
+}
+
+func resolveIncident(tx *gorm.DB, incident *models.AlertIncident, req *IncidentReq, username string) ([]*models.AlertIncidentEvent, error) {
+	if incident.IsResolved() {
+		return nil, fmt.Errorf("告警事件已解决")
+	}
+	now := time.Now()
+	incident.Status = models.IncidentStatusResolved
+	incident.ResolvedAt = &now
+	return []*models.AlertIncidentEvent{{Kind: models.IncidentEventResolved, Content: req.Note}}, nil
+}
+
+func noteIncident(tx *gorm.DB, incident *models.AlertIncident, req *IncidentReq, username string) ([]*models.AlertIncidentEvent, error) {
+	if req.Note == "" && req.Link == "" {
+		return nil, fmt.Errorf("备注和链接不能同时为空")
+	}
+	event := &models.AlertIncidentEvent{Kind: models.IncidentEventNote, Content: req.Note}
+	if req.Link != "" {
+		event.Kind = models.IncidentEventLink
+		event.Link = req.Link
+	}
+	return []*models.AlertIncidentEvent{event}, nil
+}
+
+func setIncidentAssignee(tx *gorm.DB, incident *models.AlertIncident, assignee string) error {
+	if err := tx.First(&models.User{}, "username = ?", assignee).Error; err != nil {
+		return fmt.Errorf("处理人%s不存在: %w", assignee, err)
+	}
+	incident.Assignee = assignee
+	return nil
+}
+
+// getIncident 获取路径中集群和命名空间下的告警事件
+func getIncident(c *gin.Context, tx *gorm.DB, incident *models.AlertIncident) error {
+	if err := tx.Preload("AlertInfo").First(incident, "id = ?", c.Param("incident_id")).Error; err != nil {
+		return err
+	}
+	info := incident.AlertInfo
+	if info == nil || info.ClusterName != c.Param("cluster") || info.Namespace != c.Param("namespace") {
+		return fmt.Errorf("告警事件%s不存在", c.Param("incident_id"))
+	}
+	return nil
+}
+
+// silenceIncident 为告警创建静默，与告警黑名单共用同一静默，可在黑名单中提前移除
+// 先创建静默再记录到数据库，失败时不会留下未记录的静默
+func (h *AlertsHandler) silenceIncident(ctx context.Context, incident *models.AlertIncident, duration, username string) error {
+	d, err := model.ParseDuration(duration)
+	if err != nil {
+		return err
+	}
+	info := incident.AlertInfo
+	now := time.Now()
+	end := now.Add(time.Duration(d))
+	// 已有更长的静默
+	if info.SilenceEndsAt != nil && info.SilenceEndsAt.After(end) {
+		return nil
+	}
+	info.LabelMap = map[string]string{}
+	if err := json.Unmarshal(info.Labels, &info.LabelMap); err != nil {
+		return err
+	}
+	info.SilenceCreator = username
+	info.SilenceUpdatedAt = &now
+	info.SilenceStartsAt = &now
+	info.SilenceEndsAt = &end
+	db := h.GetDB().WithContext(ctx)
+	cli, err := h.GetAgents().ClientOf(ctx, info.ClusterName)
+	if err != nil {
+		return err
+	}
+	if err := observe.NewClient(cli, db).CreateOrUpdateSilenceIfNotExist(ctx, *info); err != nil {
+		return err
+	}
+	incident.SilenceEndsAt = &end
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(info).Error; err != nil {
+			return err
+		}
+		return tx.Model(incident).Update("silence_ends_at", end).Error
+	})
+}
+
+// incidentUsers 与告警消息的接收用户一致
+func (h *AlertsHandler) incidentUsers(info *models.AlertInfo) []uint {
+	labels := map[string]string{}
+	_ = json.Unmarshal(info.Labels, &labels)
+	_, isMonitor := labels["prometheus"]
+	pos, err := h.GetDataBase().GetAlertPosition(info.ClusterName, info.Namespace, info.Name, isMonitor)
+	if err != nil {
+		log.Error(err, "get alert position", "fingerprint", info.Fingerprint)
+	}
+	return switcher.GetAlertUsers(pos, h.GetDataBase()).Slice()
+}
@@ -0,0 +1,153 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package alerthandler
+
+import (
+	"testing"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"kubegems.io/kubegems/pkg/service/models"
+)
+
+func Test_incidentActions(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(t.TempDir()+"/incident.db"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&models.User{}); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"alice", "bob"} {
+		if err := db.Create(&models.User{Username: name}).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	type step struct {
+		name       string
+		action     incidentAction
+		req        IncidentReq
+		wantErr    bool
+		wantStatus string
+		wantAssign string
+		wantEvents []string
+	}
+	steps := []step{
+		{
+			name:       "note without content",
+			action:     noteIncident,
+			wantErr:    true,
+			wantStatus: models.IncidentStatusOpen,
+		},
+		{
+			name:       "assign to unknown user",
+			action:     assignIncident,
+			req:        IncidentReq{Assignee: "nobody"},
+			wantErr:    true,
+			wantStatus: models.IncidentStatusOpen,
+		},
+		{
+			name:       "acknowledge with invalid silence",
+			action:     acknowledgeIncident,
+			req:        IncidentReq{SilenceDuration: "forever"},
+			wantErr:    true,
+			wantStatus: models.IncidentStatusOpen,
+		},
+		{
+			name:       "acknowledge assigns current user",
+			action:     acknowledgeIncident,
+			req:        IncidentReq{Note: "looking", SilenceDuration: "2h"},
+			wantStatus: models.IncidentStatusAcknowledged,
+			wantAssign: "alice",
+			wantEvents: []string{models.IncidentEventAcknowledged, models.IncidentEventAssigned},
+		},
+		{
+			name:       "acknowledge twice",
+			action:     acknowledgeIncident,
+			wantErr:    true,
+			wantStatus: models.IncidentStatusAcknowledged,
+			wantAssign: "alice",
+		},
+		{
+			name:       "assign with note",
+			action:     assignIncident,
+			req:        IncidentReq{Assignee: "bob", Note: "handover"},
+			wantStatus: models.IncidentStatusAcknowledged,
+			wantAssign: "bob",
+			wantEvents: []string{models.IncidentEventAssigned, models.IncidentEventNote},
+		},
+		{
+			name:       "link",
+			action:     noteIncident,
+			req:        IncidentReq{Link: "https://example.com/issues/1"},
+			wantStatus: models.IncidentStatusAcknowledged,
+			wantAssign: "bob",
+			wantEvents: []string{models.IncidentEventLink},
+		},
+		{
+			name:       "resolve",
+			action:     resolveIncident,
+			wantStatus: models.IncidentStatusResolved,
+			wantAssign: "bob",
+			wantEvents: []string{models.IncidentEventResolved},
+		},
+		{
+			name:       "resolve twice",
+			action:     resolveIncident,
+			wantErr:    true,
+			wantStatus: models.IncidentStatusResolved,
+			wantAssign: "bob",
+		},
+		{
+			name:       "assign resolved",
+			action:     assignIncident,
+			req:        IncidentReq{Assignee: "alice"},
+			wantErr:    true,
+			wantStatus: models.IncidentStatusResolved,
+			wantAssign: "bob",
+		},
+	}
+
+	incident := &models.AlertIncident{Status: models.IncidentStatusOpen}
+	for _, s := range steps {
+		t.Run(s.name, func(t *testing.T) {
+			// 失败的操作在事务中回滚，不修改事件
+			current := *incident
+			events, err := s.action(db, &current, &s.req, "alice")
+			if (err != nil) != s.wantErr {
+				t.Fatalf("error = %v, wantErr %v", err, s.wantErr)
+			}
+			if err == nil {
+				*incident = current
+			}
+			if incident.Status != s.wantStatus || incident.Assignee != s.wantAssign {
+				t.Errorf("status = %s, assignee = %s, want %s, %s", incident.Status, incident.Assignee, s.wantStatus, s.wantAssign)
+			}
+			kinds := []string{}
+			for _, e := range events {
+				kinds = append(kinds, e.Kind)
+			}
+			if len(kinds) != len(s.wantEvents) {
+				t.Fatalf("events = %v, want %v", kinds, s.wantEvents)
+			}
+			for i := range kinds {
+				if kinds[i] != s.wantEvents[i] {
+					t.Errorf("events = %v, want %v", kinds, s.wantEvents)
+				}
+			}
+		})
+	}
+}
@@ -29,4 +29,11 @@ func (h *AlertsHandler) RegistRouter(rg *gin.RouterGroup) {
 	rg.POST("/alerts/blacklist", h.AddToBlackList)
 	rg.DELETE("/alerts/blacklist/:fingerprint", h.RemoveInBlackList)
 
+	rg.GET("/alerts/tenant/:tenant_id/incidents", h.CheckByTenantID, h.ListIncidents)
+	rg.GET("/alerts/cluster/:cluster/namespaces/:namespace/incidents/:incident_id", h.CheckByClusterNamespace, h.GetIncident)
+	rg.POST("/alerts/cluster/:cluster/namespaces/:namespace/incidents/:incident_id/acknowledge", h.CheckByClusterNamespace, h.AcknowledgeIncident)
+	rg.POST("/alerts/cluster/:cluster/namespaces/:namespace/incidents/:incident_id/assign", h.CheckByClusterNamespace, h.AssignIncident)
+	rg.POST("/alerts/cluster/:cluster/namespaces/:namespace/incidents/:incident_id/resolve", h.CheckByClusterNamespace, h.ResolveIncident)
+	rg.POST("/alerts/cluster/:cluster/namespaces/:namespace/incidents/:incident_id/notes", h.CheckByClusterNamespace, h.AddIncidentNote)
+
 }
@@ -145,6 +145,7 @@ func (h *ApplicationHandler) asyncUpdateImages(ctx context.Context, ref PathRef,
 			Name:     "sync",
 			Function: TaskFunction_Application_Sync,
 			Args:     workflow.ArgsOf(ref),
+			Retry:    SyncRetryPolicy,
 		},
 		// {
 		// 	Name:     "wait-healthy",
@@ -23,6 +23,7 @@ import (
 	"sort"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/argoproj/argo-cd/v2/pkg/apis/application"
 	"github.com/argoproj/argo-cd/v2/pkg/apis/application/v1alpha1"
@@ -64,6 +65,9 @@ const (
 	TaskFunction_Application_Undo                      = "application_undo"
 )
 
+// SyncRetryPolicy 应用同步步骤的重试策略，argo 同步偶发失败时无需用户手动重试
+var SyncRetryPolicy = &workflow.RetryPolicy{Limit: 3, Backoff: 5 * time.Second, Factor: 2, MaxBackoff: time.Minute}
+
 // ProvideFuntions 用于对异步任务框架指出所使用的方法
 func (p *ApplicationProcessor) ProvideFuntions() map[string]interface{} {
 	return map[string]interface{}{
@@ -101,6 +105,7 @@ func NewApplicationProcessor(db *database.Database, gitp *git.SimpleLocalProvide
 }
 
 func (p *ApplicationProcessor) UpdateImages(ctx context.Context, ref PathRef, images []string, version string) error {
+	log.FromContextOrDiscard(ctx).Info("updating images", "app", ref.FullName(), "images", images, "version", version)
 	updatefunc := func(ctx context.Context, store GitStore) error {
 		return UpdateContentImages(ctx, store, images, version)
 	}
@@ -593,15 +598,19 @@ func (h *ApplicationProcessor) createArgoGitRepo(ctx context.Context, gitCloneUr
 }
 
 func (h *ApplicationProcessor) Sync(ctx context.Context, ref PathRef, resources ...v1alpha1.SyncOperationResource) error {
+	log := log.FromContextOrDiscard(ctx).WithValues("app", ref.FullName())
 	// do check in case of cluster config update
+	log.Info("preparing argo application")
 	if _, _, _, err := h.prepareArgoApplication(ctx, ref); err != nil {
 		return err
 	}
+	log.Info("syncing argo application", "resources", len(resources))
 	if err := h.Argo.Sync(ctx, ref.FullName(), resources); err != nil {
 		if !errors.IsNotFound(err) && grpcstatus.Code(err) != grpccodes.NotFound {
 			return fmt.Errorf("sync app %s: %v", ref.Name, err)
 		}
 		// if not found do a fully deploy
+		log.Info("argo application not found, deploying")
 		if _, err := h.deployKustomizeApplication(ctx, ref, true); err != nil {
 			return fmt.Errorf("deploy app %s: %w", ref.Name, err)
 		}
@@ -773,6 +782,7 @@ func (p *ApplicationProcessor) BatchUpdateImages(ctx context.Context, ref PathRe
 				Name:     "sync",
 				Function: TaskFunction_Application_Sync,
 				Args:     workflow.ArgsOf(iref),
+				Retry:    SyncRetryPolicy,
 			},
 		}
 
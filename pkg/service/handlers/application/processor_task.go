@@ -68,8 +68,33 @@ func (p *TaskProcessor) GetTaskLatest(ctx context.Context, ref PathRef, typ stri
 }
 
 func (p *TaskProcessor) WatchTasks(ctx context.Context, ref PathRef,
-	typ string, callback func(ctx context.Context, task *workflow.Task) error) error {
-	return p.Workflowcli.WatchTasks(ctx, TaskGroupApplication, TaskNameOf(ref, typ), callback)
+	typ string, callback func(ctx context.Context, task *workflow.Task) error, opts ...workflow.WatchOption) error {
+	return p.Workflowcli.WatchTasks(ctx, TaskGroupApplication, TaskNameOf(ref, typ), callback, opts...)
+}
+
+// StepLogs 获取任务的步骤日志
+func (p *TaskProcessor) StepLogs(ctx context.Context, ref PathRef, typ string, uid string, step string) ([]workflow.StepLogChunk, error) {
+	return p.Workflowcli.StepLogs(ctx, TaskGroupApplication, TaskNameOf(ref, typ), uid, step)
+}
+
+// RetryTask 从失败的步骤或者指定的步骤重试任务
+func (p *TaskProcessor) RetryTask(ctx context.Context, ref PathRef, typ string, uid string, fromStep string) error {
+	return p.Workflowcli.RetryTask(ctx, TaskGroupApplication, TaskNameOf(ref, typ), uid, fromStep)
+}
+
+// CancelTask 取消正在执行或者等待执行的任务
+func (p *TaskProcessor) CancelTask(ctx context.Context, ref PathRef, typ string, uid string) error {
+	return p.Workflowcli.CancelTask(ctx, TaskGroupApplication, TaskNameOf(ref, typ), uid)
+}
+
+// PauseTask 暂停任务，正在执行的步骤完成后暂停
+func (p *TaskProcessor) PauseTask(ctx context.Context, ref PathRef, typ string, uid string) error {
+	return p.Workflowcli.PauseTask(ctx, TaskGroupApplication, TaskNameOf(ref, typ), uid)
+}
+
+// ResumeTask 恢复暂停的任务
+func (p *TaskProcessor) ResumeTask(ctx context.Context, ref PathRef, typ string, uid string) error {
+	return p.Workflowcli.ResumeTask(ctx, TaskGroupApplication, TaskNameOf(ref, typ), uid)
 }
 
 func TaskNameOf(ref PathRef, taskname string) string {
@@ -86,7 +86,10 @@ func (h *ApplicationHandler) RegistRouter(rg *gin.RouterGroup) error {
 	// 应用部署异步结果
 	task := deploy.Task
 	rg.GET("/tenant/:tenant_id/project/:project_id/environment/:environment_id/applications/:name/tasks", h.CheckByEnvironmentID, task.List)
+	rg.POST("/tenant/:tenant_id/project/:project_id/environment/:environment_id/applications/:name/tasks/:uid/:action", h.CheckByEnvironmentID, task.Control)
+	rg.GET("/tenant/:tenant_id/project/:project_id/environment/:environment_id/applications/:name/tasks/:uid/logs", h.CheckByEnvironmentID, task.StepLogs)
 	rg.GET("/tenant/:tenant_id/project/:project_id/environment/:environment_id/applications/_/tasks", h.CheckByEnvironmentID, task.BatchList)
+	rg.GET("/tenant/:tenant_id/project/:project_id/environment/:environment_id/applications/:name/taskhistory", h.CheckByEnvironmentID, task.ListHistory)
 
 	// 应用部署编排文件
 	rg.GET("/tenant/:tenant_id/project/:project_id/environment/:environment_id/applications/:name/files", h.CheckByEnvironmentID, deploy.ListFiles)
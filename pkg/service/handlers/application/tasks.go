@@ -16,8 +16,11 @@ package application
 
 import (
 	"context"
+	"fmt"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"kubegems.io/kubegems/pkg/log"
@@ -27,14 +30,17 @@ import (
 type TaskHandler struct {
 	BaseHandler
 	Processor *TaskProcessor
+	History   *workflow.HistoryStore
 }
 
 func NewTaskHandler(base BaseHandler) *TaskHandler {
+	history := workflow.NewHistoryStore(base.GetDataBase())
 	return &TaskHandler{
 		BaseHandler: base,
 		Processor: &TaskProcessor{
-			workflow.NewClientFromBackend(workflow.NewRedisBackendFromClient(base.GetRedis().Client)),
+			workflow.NewClientFromBackend(workflow.NewRedisBackendFromClient(base.GetRedis().Client)).WithHistory(history),
 		},
+		History: history,
 	}
 }
 
@@ -50,12 +56,14 @@ func NewTaskHandler(base BaseHandler) *TaskHandler {
 // @Param       watch          query    string                                        false "is watch sse ,sse key 为 'data'"
 // @Param       limit          query    int                                           false "限制返回的条数，返回最新的n条记录"
 // @Param       type           query    string                                        false "限制返回的任务类型，例如仅返回 部署镜像(update-image),切换模式(switch-strategy)  的任务"
+// @Param       logs           query    bool                                          false "watch 时同时推送步骤日志，sse key 为 'log'"
 // @Success     200            {object} handlers.ResponseStruct{Data=[]workflow.Task} "task status"
 // @Router      /v1/tenant/{tenant_id}/project/{project_id}/environment/{environment_id}/applications/{name}/tasks [get]
 // @Security    JWT
 func (h *TaskHandler) List(c *gin.Context) {
 	h.NamedRefFunc(c, nil, func(ctx context.Context, ref PathRef) (interface{}, error) {
 		iswatch, _ := strconv.ParseBool(c.Query("watch"))
+		withlogs, _ := strconv.ParseBool(c.Query("logs"))
 		limit, _ := strconv.Atoi(c.Query("limit"))
 		kind := c.Query("type")
 
@@ -73,7 +81,21 @@ func (h *TaskHandler) List(c *gin.Context) {
 		c.SSEvent("data", tasks)
 		c.Writer.Flush()
 
+		// 任务与步骤日志的回调可能并发
+		mu := sync.Mutex{}
+		opts := []workflow.WatchOption{}
+		if withlogs {
+			opts = append(opts, workflow.WithStepLogs(func(_ context.Context, chunk *workflow.StepLogChunk) error {
+				mu.Lock()
+				defer mu.Unlock()
+				c.SSEvent("log", chunk)
+				c.Writer.Flush()
+				return nil
+			}))
+		}
 		err = h.Processor.WatchTasks(ctx, ref, kind, func(_ context.Context, task *workflow.Task) error {
+			mu.Lock()
+			defer mu.Unlock()
 			//  更新 list中的task
 			updated := false
 			for i, item := range tasks {
@@ -94,7 +116,7 @@ func (h *TaskHandler) List(c *gin.Context) {
 			c.Writer.Flush()
 
 			return nil
-		})
+		}, opts...)
 		if err != nil {
 			log.Info("watch tasks closed", "err", err.Error())
 		}
@@ -102,6 +124,119 @@ func (h *TaskHandler) List(c *gin.Context) {
 	})
 }
 
+// @Tags        Application
+// @Summary     应用异步任务步骤日志
+// @Description 应用异步任务步骤日志，按照步骤位置以及 seq 排序；实时日志使用 tasks 接口的 watch 以及 logs 参数
+// @Accept      json
+// @Produce     json
+// @Param       tenant_id      path     int                                                true  "tenaut id"
+// @Param       project_id     path     int                                                true  "project id"
+// @Param       environment_id path     int                                                true  "environment_id"
+// @Param       name           path     string                                             true  "application name"
+// @Param       uid            path     string                                             true  "task uid"
+// @Param       type           query    string                                             true  "任务类型，例如 部署镜像(update-image)"
+// @Param       step           query    string                                             false "步骤名称或者位置，为空时返回所有步骤的日志"
+// @Success     200            {object} handlers.ResponseStruct{Data=[]workflow.StepLogChunk} "step logs"
+// @Router      /v1/tenant/{tenant_id}/project/{project_id}/environment/{environment_id}/applications/{name}/tasks/{uid}/logs [get]
+// @Security    JWT
+func (h *TaskHandler) StepLogs(c *gin.Context) {
+	h.NamedRefFunc(c, nil, func(ctx context.Context, ref PathRef) (interface{}, error) {
+		return h.Processor.StepLogs(ctx, ref, c.Query("type"), c.Param("uid"), c.Query("step"))
+	})
+}
+
+// @Tags        Application
+// @Summary     控制应用异步任务
+// @Description 取消(cancel)，暂停(pause)，恢复(resume)，重试(retry)应用异步任务。取消后任务状态为 Cancelled，暂停后任务状态为 Paused
+// @Description 重试时从失败的步骤重新执行，已经成功的步骤不会重复执行；若指定 step 则从该步骤开始重新执行
+// @Accept      json
+// @Produce     json
+// @Param       tenant_id      path     int                                  true  "tenaut id"
+// @Param       project_id     path     int                                  true  "project id"
+// @Param       environment_id path     int                                  true  "environment_id"
+// @Param       name           path     string                               true  "application name"
+// @Param       uid            path     string                               true  "task uid"
+// @Param       action         path     string                               true  "cancel,pause,resume,retry"
+// @Param       type           query    string                               true  "任务类型，例如 部署镜像(update-image)"
+// @Param       step           query    string                               false "重试时从该步骤开始重新执行"
+// @Success     200            {object} handlers.ResponseStruct{Data=string} "ok"
+// @Router      /v1/tenant/{tenant_id}/project/{project_id}/environment/{environment_id}/applications/{name}/tasks/{uid}/{action} [post]
+// @Security    JWT
+func (h *TaskHandler) Control(c *gin.Context) {
+	h.NamedRefFunc(c, nil, func(ctx context.Context, ref PathRef) (interface{}, error) {
+		kind, uid := c.Query("type"), c.Param("uid")
+		switch action := c.Param("action"); action {
+		case "cancel":
+			h.SetAuditData(c, "取消", "应用任务", ref.Name)
+			return "ok", h.Processor.CancelTask(ctx, ref, kind, uid)
+		case "pause":
+			h.SetAuditData(c, "暂停", "应用任务", ref.Name)
+			return "ok", h.Processor.PauseTask(ctx, ref, kind, uid)
+		case "resume":
+			h.SetAuditData(c, "恢复", "应用任务", ref.Name)
+			return "ok", h.Processor.ResumeTask(ctx, ref, kind, uid)
+		case "retry":
+			h.SetAuditData(c, "重试", "应用任务", ref.Name)
+			return "ok", h.Processor.RetryTask(ctx, ref, kind, uid, c.Query("step"))
+		default:
+			return nil, fmt.Errorf("unsupported task action %s", action)
+		}
+	})
+}
+
+// @Tags        Application
+// @Summary     应用异步任务历史
+// @Description 应用异步任务历史，包含已经结束以及已经过期清理的任务，按照创建时间倒序
+// @Accept      json
+// @Produce     json
+// @Param       tenant_id      path     int                                                                     true  "tenaut id"
+// @Param       project_id     path     int                                                                     true  "project id"
+// @Param       environment_id path     int                                                                     true  "environment_id"
+// @Param       name           path     string                                                                  true  "application name"
+// @Param       type           query    string                                                                  false "任务类型，例如 部署镜像(update-image)"
+// @Param       status         query    string                                                                  false "任务状态,逗号','分隔，例如 Error,Cancelled"
+// @Param       executer       query    string                                                                  false "执行者"
+// @Param       event          query    string                                                                  false "记录事件,Finished,Expired,Orphaned"
+// @Param       start          query    string                                                                  false "创建时间起始,RFC3339"
+// @Param       end            query    string                                                                  false "创建时间结束,RFC3339"
+// @Param       page           query    int                                                                     false "page"
+// @Param       size           query    int                                                                     false "size"
+// @Success     200            {object} handlers.ResponseStruct{Data=handlers.PageData{List=[]workflow.TaskHistory}} "task history"
+// @Router      /v1/tenant/{tenant_id}/project/{project_id}/environment/{environment_id}/applications/{name}/taskhistory [get]
+// @Security    JWT
+func (h *TaskHandler) ListHistory(c *gin.Context) {
+	h.NamedRefFunc(c, nil, func(ctx context.Context, ref PathRef) (interface{}, error) {
+		query := workflow.TaskHistoryQuery{
+			Group:    TaskGroupApplication,
+			Name:     TaskNameOf(ref, c.Query("type")),
+			Executer: c.Query("executer"),
+			Event:    workflow.TaskEvent(c.Query("event")),
+		}
+		query.Page, _ = strconv.Atoi(c.Query("page"))
+		query.Size, _ = strconv.Atoi(c.Query("size"))
+		if val := c.Query("status"); val != "" {
+			for _, status := range strings.Split(val, ",") {
+				query.Status = append(query.Status, workflow.TaskStatusCode(status))
+			}
+		}
+		if val := c.Query("start"); val != "" {
+			start, err := time.Parse(time.RFC3339, val)
+			if err != nil {
+				return nil, fmt.Errorf("invalid start time: %w", err)
+			}
+			query.Since = start
+		}
+		if val := c.Query("end"); val != "" {
+			end, err := time.Parse(time.RFC3339, val)
+			if err != nil {
+				return nil, fmt.Errorf("invalid end time: %w", err)
+			}
+			query.Until = end
+		}
+		return h.History.Query(ctx, query)
+	})
+}
+
 // @Tags        Application
 // @Summary     应用列表的异步任务列表
 // @Description 应用列表的异步任务列表
@@ -18,6 +18,7 @@ import (
 	"context"
 	"crypto/tls"
 	"crypto/x509"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"regexp"
@@ -176,7 +177,13 @@ func (h *ClusterHandler) PutCluster(c *gin.Context) {
 	action, module := i18n.Sprintf(c, "update"), i18n.Sprintf(c, "cluster")
 	h.SetAuditData(c, action, module, obj.ClusterName)
 
-	if err := c.BindJSON(&obj); err != nil {
+	if obj.IsTunnelMode() {
+		// 隧道模式的集群没有 kubeconfig，跳过表单中 kubeconfig 的校验
+		if err := json.NewDecoder(c.Request.Body).Decode(&obj); err != nil {
+			handlers.NotOK(c, err)
+			return
+		}
+	} else if err := c.BindJSON(&obj); err != nil {
 		handlers.NotOK(c, err)
 		return
 	}
@@ -184,7 +191,13 @@ func (h *ClusterHandler) PutCluster(c *gin.Context) {
 		handlers.NotOK(c, i18n.Errorf(c, "URL parameter mismatched with body"))
 		return
 	}
-	if err := OnKubeConfig(c, obj.KubeConfig, func(ctx context.Context, clientSet *kubernetes.Clientset, config *rest.Config) error {
+	if obj.IsTunnelMode() {
+		// 隧道模式的集群没有 kubeconfig
+		if err := h.GetDB().WithContext(ctx).Save(&obj).Error; err != nil {
+			handlers.NotOK(c, err)
+			return
+		}
+	} else if err := OnKubeConfig(c, obj.KubeConfig, func(ctx context.Context, clientSet *kubernetes.Clientset, config *rest.Config) error {
 		if err := CompleteCluster(ctx, &obj, config, clientSet); err != nil {
 			return err
 		}
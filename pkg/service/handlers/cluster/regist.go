@@ -27,6 +27,8 @@ func (h *ClusterHandler) RegistRouter(rg *gin.RouterGroup) {
 	rg.GET("/cluster", h.CheckIsSysADMIN, h.ListCluster)
 	rg.GET("/cluster/:cluster_id", h.CheckIsSysADMIN, h.RetrieveCluster)
 	rg.POST("/cluster", h.CheckIsSysADMIN, h.PostCluster)
+	rg.POST("/cluster/tunnel", h.CheckIsSysADMIN, h.PostTunnelCluster)
+	rg.POST("/cluster/:cluster_id/bootstrap-token", h.CheckIsSysADMIN, h.RefreshBootstrapToken)
 	rg.PUT("/cluster/:cluster_id", h.CheckIsSysADMIN, h.PutCluster)
 	rg.DELETE("/cluster/:cluster_id", h.CheckIsSysADMIN, h.DeleteCluster)
 	rg.GET("/cluster/_/status", h.CheckIsSysADMIN, h.ListClusterStatus)
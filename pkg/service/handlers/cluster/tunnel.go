@@ -0,0 +1,155 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package clusterhandler
+
+import (
+	"errors"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"kubegems.io/kubegems/pkg/i18n"
+	msgclient "kubegems.io/kubegems/pkg/msgbus/client"
+	"kubegems.io/kubegems/pkg/service/handlers"
+	"kubegems.io/kubegems/pkg/service/models"
+	"kubegems.io/kubegems/pkg/utils/agents"
+	"kubegems.io/kubegems/pkg/utils/msgbus"
+)
+
+type TunnelClusterForm struct {
+	ClusterName         string `json:"clusterName" binding:"required"`
+	Vendor              string `json:"vendor"`
+	ImageRepo           string `json:"imageRepo"`
+	DefaultStorageClass string `json:"defaultStorageClass"`
+	Primary             bool   `json:"primary"`
+}
+
+// TunnelBootstrap agent 接入隧道所需的信息
+type TunnelBootstrap struct {
+	ClusterName string     `json:"clusterName"`
+	TunnelAddr  string     `json:"tunnelAddr"`
+	Token       string     `json:"token"` // 仅返回一次
+	ExpireAt    *time.Time `json:"expireAt"`
+	AgentArgs   []string   `json:"agentArgs"` // agent 启动参数
+}
+
+// PostTunnelCluster 创建隧道模式的Cluster
+// @Tags        Cluster
+// @Summary     创建隧道模式的Cluster
+// @Description 创建隧道模式的Cluster，集群 agent 使用返回的 token 主动连接，不需要 kubeconfig
+// @Accept      json
+// @Produce     json
+// @Param       param body     TunnelClusterForm                              true "表单"
+// @Success     200   {object} handlers.ResponseStruct{Data=TunnelBootstrap} "bootstrap"
+// @Router      /v1/cluster/tunnel [post]
+// @Security    JWT
+func (h *ClusterHandler) PostTunnelCluster(c *gin.Context) {
+	form := &TunnelClusterForm{}
+	if err := c.BindJSON(form); err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	ctx := c.Request.Context()
+
+	action, module := i18n.Sprintf(ctx, "create"), i18n.Sprintf(ctx, "cluster")
+	h.SetAuditData(c, action, module, form.ClusterName)
+
+	if h.GetAgents().TunnelHost() == "" {
+		handlers.NotOK(c, i18n.Errorf(ctx, "tunnel is not enabled"))
+		return
+	}
+	cluster := &models.Cluster{
+		ClusterName:         form.ClusterName,
+		APIServer:           agents.TunnelAPIServerPrefix + form.ClusterName,
+		Vendor:              form.Vendor,
+		ImageRepo:           form.ImageRepo,
+		DefaultStorageClass: form.DefaultStorageClass,
+		Primary:             form.Primary,
+		AgentMode:           models.ClusterAgentModeTunnel,
+	}
+	if err := CheckBeforeAdd(ctx, h.GetDataBase(), cluster); err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	if err := h.GetDB().WithContext(ctx).Create(cluster).Error; err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	bootstrap, err := h.tunnelBootstrap(c, cluster)
+	if err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+
+	h.SendToMsgbus(c, func(msg *msgclient.MsgRequest) {
+		msg.EventKind, msg.ResourceType, msg.ResourceID = msgbus.Add, msgbus.Cluster, cluster.ID
+		msg.Detail = i18n.Sprintf(c, "add a new cluster %s into kubegems", cluster.ClusterName)
+		msg.ToUsers.Append(h.GetDataBase().SystemAdmins()...)
+	})
+
+	handlers.Created(c, bootstrap)
+}
+
+// RefreshBootstrapToken 重新生成隧道模式集群的接入token
+// @Tags        Cluster
+// @Summary     重新生成隧道模式集群的接入token
+// @Description 重新生成隧道模式集群的接入token，agent 之前绑定的凭证失效并断开连接，需要使用新的 token 重新接入
+// @Accept      json
+// @Produce     json
+// @Param       cluster_id path     uint                                           true "cluster_id"
+// @Success     200        {object} handlers.ResponseStruct{Data=TunnelBootstrap} "bootstrap"
+// @Router      /v1/cluster/{cluster_id}/bootstrap-token [post]
+// @Security    JWT
+func (h *ClusterHandler) RefreshBootstrapToken(c *gin.Context) {
+	cluster := &models.Cluster{}
+	ctx := c.Request.Context()
+	if err := h.GetDB().WithContext(ctx).First(cluster, c.Param(PrimaryKeyName)).Error; err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+
+	action, module := i18n.Sprintf(ctx, "update"), i18n.Sprintf(ctx, "cluster")
+	h.SetAuditData(c, action, module, cluster.ClusterName)
+
+	if !cluster.IsTunnelMode() {
+		handlers.NotOK(c, errors.New("cluster is not in tunnel mode"))
+		return
+	}
+	bootstrap, err := h.tunnelBootstrap(c, cluster)
+	if err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	handlers.OK(c, bootstrap)
+}
+
+func (h *ClusterHandler) tunnelBootstrap(c *gin.Context, cluster *models.Cluster) (*TunnelBootstrap, error) {
+	agentscli := h.GetAgents()
+	token, err := agentscli.NewBootstrapToken(c.Request.Context(), cluster)
+	if err != nil {
+		return nil, err
+	}
+	addr := agentscli.TunnelHost()
+	return &TunnelBootstrap{
+		ClusterName: cluster.ClusterName,
+		TunnelAddr:  addr,
+		Token:       token,
+		ExpireAt:    cluster.BootstrapTokenExpireAt,
+		AgentArgs: []string{
+			"--tunnel-addr=" + addr,
+			"--tunnel-clustername=" + cluster.ClusterName,
+			"--tunnel-token=" + token,
+		},
+	}, nil
+}
@@ -1385,11 +1385,12 @@ func (p *AlertRuleProcessor) syncAlertmanagerConfig(ctx context.Context, alertru
 }
 
 func (p *AlertRuleProcessor) syncEmailSecret(ctx context.Context, alertrule *models.AlertRule) error {
-	emails := map[string]*channels.Email{}
+	secrets := map[string]string{}
 	for _, rec := range alertrule.Receivers {
-		switch v := rec.AlertChannel.ChannelConfig.ChannelIf.(type) {
-		case *channels.Email:
-			emails[rec.AlertChannel.ReceiverName()] = v
+		if v, ok := rec.AlertChannel.ChannelConfig.ChannelIf.(channels.SecretChannel); ok {
+			for k, val := range v.SecretData(rec.AlertChannel.ReceiverName()) {
+				secrets[k] = val
+			}
 		}
 	}
 	sec := &v1.Secret{
@@ -1406,8 +1407,8 @@ func (p *AlertRuleProcessor) syncEmailSecret(ctx context.Context, alertrule *mod
 		if sec.Data == nil {
 			sec.Data = make(map[string][]byte)
 		}
-		for recName, v := range emails {
-			sec.Data[channels.EmailSecretKey(recName, v.From)] = []byte(v.AuthPassword) // 不需要encode
+		for k, v := range secrets {
+			sec.Data[k] = []byte(v) // 不需要encode
 		}
 		return nil
 	})
@@ -1603,6 +1604,10 @@ func (p *AlertRuleProcessor) syncLoggingAlertRule(ctx context.Context, alertrule
 }
 
 func (p *AlertRuleProcessor) CreateAlertRule(ctx context.Context, req *models.AlertRule) error {
+	// slo- 前缀保留给 SLO 生成的告警规则
+	if strings.HasPrefix(req.Name, prometheus.SLOAlertPrefix) {
+		return errors.Errorf("alert rule name %s can't start with %s, it's reserved for service level objective", req.Name, prometheus.SLOAlertPrefix)
+	}
 	return p.DBWithCtx(ctx).Transaction(func(tx *gorm.DB) error {
 		allRules := []models.AlertRule{}
 		if err := tx.Find(&allRules, "cluster = ? and namespace = ? and name = ?", req.Cluster, req.Namespace, req.Name).Error; err != nil {
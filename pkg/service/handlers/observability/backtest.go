@@ -0,0 +1,293 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package observability
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	prommodel "github.com/prometheus/common/model"
+	"kubegems.io/kubegems/pkg/service/handlers"
+	"kubegems.io/kubegems/pkg/service/models"
+	"kubegems.io/kubegems/pkg/utils/gormdatatypes"
+	"kubegems.io/kubegems/pkg/utils/prometheus"
+)
+
+const (
+	defaultBacktestRange = 24 * time.Hour
+	maxBacktestRange     = 30 * 24 * time.Hour
+	defaultBacktestStep  = time.Minute
+	// prometheus range query 最多返回 11000 个点
+	maxBacktestPoints = 11000
+)
+
+// BacktestAlertRuleForm 回测的告警规则草稿，回测不需要名称及接收器
+type BacktestAlertRuleForm struct {
+	Expr            string                  `json:"expr"`
+	For             string                  `json:"for"`
+	Message         string                  `json:"message"`
+	InhibitLabels   []string                `json:"inhibitLabels"`
+	AlertLevels     models.AlertLevels      `json:"alertLevels"`
+	PromqlGenerator *models.PromqlGenerator `json:"promqlGenerator"`
+	LogqlGenerator  *models.LogqlGenerator  `json:"logqlGenerator"`
+}
+
+type BacktestLevelResult struct {
+	Severity      string                        `json:"severity"`
+	Expr          string                        `json:"expr"`          // 该级别实际查询的表达式
+	Count         int                           `json:"count"`         // 告警次数
+	FiringSeconds float64                       `json:"firingSeconds"` // 该级别所有告警的 firing 时长之和
+	Intervals     []prometheus.BacktestInterval `json:"intervals"`
+}
+
+type BacktestResult struct {
+	Start         time.Time             `json:"start"`
+	End           time.Time             `json:"end"`
+	Step          string                `json:"step"`
+	Count         int                   `json:"count"`         // 告警总次数
+	FiringSeconds float64               `json:"firingSeconds"` // 至少有一个告警处于 firing 的总时长
+	Levels        []BacktestLevelResult `json:"levels"`
+}
+
+// BacktestMonitorAlertRule 回测监控告警规则
+// @Tags        Observability
+// @Summary     回测监控告警规则
+// @Description 使用历史数据回放告警规则，模拟 pending->firing 的状态变化，返回会产生的告警
+// @Accept      json
+// @Produce     json
+// @Param       cluster   path     string                                       true  "cluster"
+// @Param       namespace path     string                                       true  "namespace"
+// @Param       start     query    string                                       false "开始时间，格式 2006-01-02T15:04:05Z07:00，默认为一天前"
+// @Param       end       query    string                                       false "结束时间，格式 2006-01-02T15:04:05Z07:00，默认为当前时间"
+// @Param       step      query    string                                       false "评估间隔, eg. 30s, 1m，默认为1m"
+// @Param       form      body     BacktestAlertRuleForm                        true  "body"
+// @Success     200       {object} handlers.ResponseStruct{Data=BacktestResult} "resp"
+// @Router      /v1/observability/cluster/{cluster}/namespaces/{namespace}/monitor/alerts/_/backtest [post]
+// @Security    JWT
+func (h *ObservabilityHandler) BacktestMonitorAlertRule(c *gin.Context) {
+	h.backtestAlertRule(c, prometheus.AlertTypeMonitor)
+}
+
+// BacktestLoggingAlertRule 回测日志告警规则
+// @Tags        Observability
+// @Summary     回测日志告警规则
+// @Description 使用历史数据回放告警规则，模拟 pending->firing 的状态变化，返回会产生的告警
+// @Accept      json
+// @Produce     json
+// @Param       cluster   path     string                                       true  "cluster"
+// @Param       namespace path     string                                       true  "namespace"
+// @Param       start     query    string                                       false "开始时间，格式 2006-01-02T15:04:05Z07:00，默认为一天前"
+// @Param       end       query    string                                       false "结束时间，格式 2006-01-02T15:04:05Z07:00，默认为当前时间"
+// @Param       step      query    string                                       false "评估间隔, eg. 30s, 1m，默认为1m"
+// @Param       form      body     BacktestAlertRuleForm                        true  "body"
+// @Success     200       {object} handlers.ResponseStruct{Data=BacktestResult} "resp"
+// @Router      /v1/observability/cluster/{cluster}/namespaces/{namespace}/logging/alerts/_/backtest [post]
+// @Security    JWT
+func (h *ObservabilityHandler) BacktestLoggingAlertRule(c *gin.Context) {
+	h.backtestAlertRule(c, prometheus.AlertTypeLogging)
+}
+
+func (h *ObservabilityHandler) backtestAlertRule(c *gin.Context, alerttype string) {
+	start, end, step, err := parseBacktestRange(c.Query("start"), c.Query("end"), c.Query("step"), time.Now())
+	if err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	form := &BacktestAlertRuleForm{}
+	if err := c.BindJSON(form); err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	req := &models.AlertRule{
+		Cluster:         c.Param("cluster"),
+		Namespace:       c.Param("namespace"),
+		AlertType:       alerttype,
+		Expr:            form.Expr,
+		For:             form.For,
+		Message:         form.Message,
+		InhibitLabels:   gormdatatypes.JSONSlice(form.InhibitLabels),
+		AlertLevels:     form.AlertLevels,
+		PromqlGenerator: form.PromqlGenerator,
+		LogqlGenerator:  form.LogqlGenerator,
+	}
+
+	var ret *BacktestResult
+	if err := h.withAlertRuleProcessor(c.Request.Context(), req.Cluster, func(ctx context.Context, p *AlertRuleProcessor) error {
+		ret, err = p.Backtest(ctx, req, start, end, step)
+		return err
+	}); err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	handlers.OK(c, ret)
+}
+
+func parseBacktestRange(startstr, endstr, stepstr string, now time.Time) (start, end time.Time, step time.Duration, err error) {
+	end = now
+	if endstr != "" {
+		if end, err = time.Parse(time.RFC3339, endstr); err != nil {
+			return
+		}
+	}
+	start = end.Add(-defaultBacktestRange)
+	if startstr != "" {
+		if start, err = time.Parse(time.RFC3339, startstr); err != nil {
+			return
+		}
+	}
+	if !start.Before(end) {
+		err = errors.New("start must be before end")
+		return
+	}
+	if end.Sub(start) > maxBacktestRange {
+		err = errors.Errorf("backtest range can't be longer than %s", prommodel.Duration(maxBacktestRange))
+		return
+	}
+	step = defaultBacktestStep
+	if stepstr != "" {
+		d, perr := prommodel.ParseDuration(stepstr)
+		if perr != nil {
+			err = errors.Wrapf(perr, "step %s not valid", stepstr)
+			return
+		}
+		step = time.Duration(d)
+	}
+	if step < time.Second {
+		step = time.Second
+	}
+	// 点数过多时增大step
+	if minStep := end.Sub(start) / maxBacktestPoints; step < minStep {
+		step = minStep.Truncate(time.Second) + time.Second
+	}
+	return
+}
+
+// Backtest 在 [start, end] 上以 step 为评估间隔回放告警规则，各告警级别分别计算
+func (p *AlertRuleProcessor) Backtest(ctx context.Context, alertrule *models.AlertRule, start, end time.Time, step time.Duration) (*BacktestResult, error) {
+	if err := p.mutateBacktestAlertRule(alertrule); err != nil {
+		return nil, err
+	}
+	var forDuration time.Duration
+	if alertrule.For != "" {
+		d, err := prommodel.ParseDuration(alertrule.For)
+		if err != nil {
+			return nil, errors.Wrapf(err, "for %s not valid", alertrule.For)
+		}
+		forDuration = time.Duration(d)
+	}
+
+	rg := GenerateRuleGroup(alertrule)
+	ret := &BacktestResult{
+		Start: start,
+		End:   end,
+		Step:  prommodel.Duration(step).String(),
+	}
+	levelIntervals := make([][]prometheus.BacktestInterval, len(alertrule.AlertLevels))
+	for i, level := range alertrule.AlertLevels {
+		expr := rg.Rules[i].Expr.String()
+		matrix, err := p.queryRange(ctx, alertrule.AlertType, expr, start, end, step)
+		if err != nil {
+			return nil, errors.Wrapf(err, "query %s", expr)
+		}
+		intervals := prometheus.SimulateAlerts(matrix, forDuration, step, end)
+		for j := range intervals {
+			intervals[j].Severity = level.Severity
+		}
+		levelIntervals[i] = intervals
+	}
+	inhibitBacktestAlerts(alertrule, levelIntervals, end)
+
+	all := []prometheus.BacktestInterval{}
+	for i, level := range alertrule.AlertLevels {
+		intervals := levelIntervals[i]
+		levelret := BacktestLevelResult{
+			Severity:  level.Severity,
+			Expr:      rg.Rules[i].Expr.String(),
+			Count:     len(intervals),
+			Intervals: intervals,
+		}
+		for j := range intervals {
+			levelret.FiringSeconds += intervals[j].FiringSeconds
+		}
+		ret.Levels = append(ret.Levels, levelret)
+		ret.Count += levelret.Count
+		all = append(all, intervals...)
+	}
+	ret.FiringSeconds = prometheus.FiringDuration(all, end).Seconds()
+	return ret, nil
+}
+
+// inhibitBacktestAlerts 按 GenerateAmcfgSpec 中的抑制规则，critical 级别告警 firing 时抑制相同抑制标签的 error 级别告警，
+// 被抑制的告警不计入告警次数
+func inhibitBacktestAlerts(alertrule *models.AlertRule, levelIntervals [][]prometheus.BacktestInterval, end time.Time) {
+	if len(alertrule.InhibitLabels) == 0 {
+		return
+	}
+	sources := []prometheus.BacktestInterval{}
+	for i, level := range alertrule.AlertLevels {
+		if level.Severity == prometheus.SeverityCritical {
+			sources = append(sources, levelIntervals[i]...)
+		}
+	}
+	// 告警命名空间及名称标签在各级别中相同，无需比较
+	equal := append([]string{}, alertrule.InhibitLabels...)
+	if alertrule.Namespace != prometheus.GlobalAlertNamespace {
+		equal = append(equal, "namespace")
+	}
+	for i, level := range alertrule.AlertLevels {
+		if level.Severity != prometheus.SeverityError {
+			continue
+		}
+		levelIntervals[i] = prometheus.InhibitAlerts(levelIntervals[i], sources, equal, end)
+	}
+}
+
+// mutateBacktestAlertRule 与 MutateAlertRule 相同，但回测不需要名称及接收器
+func (p *AlertRuleProcessor) mutateBacktestAlertRule(alertrule *models.AlertRule) error {
+	if alertrule.Namespace == "" {
+		return errors.Errorf("namespace can't be empty")
+	}
+	if alertrule.PromqlGenerator != nil {
+		tpl, err := p.db.FindPromqlTpl(alertrule.PromqlGenerator.Scope, alertrule.PromqlGenerator.Resource, alertrule.PromqlGenerator.Rule)
+		if err != nil {
+			return err
+		}
+		alertrule.PromqlGenerator.Tpl = tpl
+	}
+	generatedExpr, err := GenerateExpr(alertrule)
+	if err != nil {
+		return err
+	}
+	alertrule.Expr = generatedExpr
+	return checkAlertLevels(alertrule)
+}
+
+func (p *AlertRuleProcessor) queryRange(ctx context.Context, alerttype, expr string, start, end time.Time, step time.Duration) (prommodel.Matrix, error) {
+	// agent 只支持该格式的时间
+	startstr, endstr := start.UTC().Format("2006-01-02T15:04:05Z"), end.UTC().Format("2006-01-02T15:04:05Z")
+	stepstr := strconv.Itoa(int(step.Seconds()))
+	switch alerttype {
+	case prometheus.AlertTypeMonitor:
+		return p.cli.Extend().PrometheusQueryRange(ctx, expr, startstr, endstr, stepstr)
+	case prometheus.AlertTypeLogging:
+		return p.cli.Extend().LokiQueryRange(ctx, expr, startstr, endstr, stepstr)
+	default:
+		return nil, fmt.Errorf("unknown alert type: %s", alerttype)
+	}
+}
@@ -37,6 +37,7 @@ func (h *ObservabilityHandler) RegistRouter(rg *gin.RouterGroup) {
 	rg.GET("/observability/cluster/:cluster/namespaces/:namespace/monitor/alerts/_/status", h.CheckByClusterNamespace, h.ListMonitorAlertRulesStatus)
 	rg.GET("/observability/cluster/:cluster/namespaces/:namespace/monitor/alerts/:name", h.CheckByClusterNamespace, h.GetMonitorAlertRule)
 	rg.POST("/observability/cluster/:cluster/namespaces/:namespace/monitor/alerts", h.CheckByClusterNamespace, h.CreateMonitorAlertRule)
+	rg.POST("/observability/cluster/:cluster/namespaces/:namespace/monitor/alerts/_/backtest", h.CheckByClusterNamespace, h.BacktestMonitorAlertRule)
 	rg.PUT("/observability/cluster/:cluster/namespaces/:namespace/monitor/alerts/:name", h.CheckByClusterNamespace, h.UpdateMonitorAlertRule)
 	rg.DELETE("/observability/cluster/:cluster/namespaces/:namespace/monitor/alerts/:name", h.CheckByClusterNamespace, h.DeleteMonitorAlertRule)
 
@@ -51,10 +52,24 @@ func (h *ObservabilityHandler) RegistRouter(rg *gin.RouterGroup) {
 	rg.DELETE("/observability/tenant/:tenant_id/channels/:channel_id", h.CheckByTenantID, h.DeleteChannel)
 	rg.POST("/observability/tenant/:tenant_id/channels/:channel_id/test", h.TestChannel)
 
+	// oncall
+	rg.GET("/observability/tenant/:tenant_id/oncall/schedules", h.CheckByTenantID, h.ListOnCallSchedules)
+	rg.GET("/observability/tenant/:tenant_id/oncall/schedules/:schedule_id", h.CheckByTenantID, h.GetOnCallSchedule)
+	rg.GET("/observability/tenant/:tenant_id/oncall/schedules/:schedule_id/oncall", h.CheckByTenantID, h.WhoIsOnCall)
+	rg.POST("/observability/tenant/:tenant_id/oncall/schedules", h.CheckByTenantID, h.CreateOnCallSchedule)
+	rg.PUT("/observability/tenant/:tenant_id/oncall/schedules/:schedule_id", h.CheckByTenantID, h.UpdateOnCallSchedule)
+	rg.DELETE("/observability/tenant/:tenant_id/oncall/schedules/:schedule_id", h.CheckByTenantID, h.DeleteOnCallSchedule)
+	rg.GET("/observability/tenant/:tenant_id/oncall/escalationpolicies", h.CheckByTenantID, h.ListEscalationPolicies)
+	rg.GET("/observability/tenant/:tenant_id/oncall/escalationpolicies/:policy_id", h.CheckByTenantID, h.GetEscalationPolicy)
+	rg.POST("/observability/tenant/:tenant_id/oncall/escalationpolicies", h.CheckByTenantID, h.CreateEscalationPolicy)
+	rg.PUT("/observability/tenant/:tenant_id/oncall/escalationpolicies/:policy_id", h.CheckByTenantID, h.UpdateEscalationPolicy)
+	rg.DELETE("/observability/tenant/:tenant_id/oncall/escalationpolicies/:policy_id", h.CheckByTenantID, h.DeleteEscalationPolicy)
+
 	rg.GET("/observability/cluster/:cluster/namespaces/:namespace/logging/alerts", h.CheckByClusterNamespace, h.ListLoggingAlertRule)
 	rg.GET("/observability/cluster/:cluster/namespaces/:namespace/logging/alerts/_/status", h.CheckByClusterNamespace, h.ListLoggingAlertRulesStatus)
 	rg.GET("/observability/cluster/:cluster/namespaces/:namespace/logging/alerts/:name", h.CheckByClusterNamespace, h.GetLoggingAlertRule)
 	rg.POST("/observability/cluster/:cluster/namespaces/:namespace/logging/alerts", h.CheckByClusterNamespace, h.CreateLoggingAlertRule)
+	rg.POST("/observability/cluster/:cluster/namespaces/:namespace/logging/alerts/_/backtest", h.CheckByClusterNamespace, h.BacktestLoggingAlertRule)
 	rg.PUT("/observability/cluster/:cluster/namespaces/:namespace/logging/alerts/:name", h.CheckByClusterNamespace, h.UpdateLoggingAlertRule)
 	rg.DELETE("/observability/cluster/:cluster/namespaces/:namespace/logging/alerts/:name", h.CheckByClusterNamespace, h.DeleteLoggingAlertRule)
 
@@ -101,6 +116,15 @@ func (h *ObservabilityHandler) RegistRouter(rg *gin.RouterGroup) {
 	rg.PUT("/observability/template/dashboard/:name", h.CheckIsSysADMIN, h.UpdateDashboardTemplates)
 	rg.DELETE("/observability/template/dashboard/:name", h.CheckIsSysADMIN, h.DeleteDashboardTemplate)
 
+	// slo
+	rg.GET("/observability/environment/:environment_id/slos", h.CheckByEnvironmentID, h.ListSLO)
+	rg.GET("/observability/environment/:environment_id/slos/:slo_id", h.CheckByEnvironmentID, h.GetSLO)
+	rg.POST("/observability/environment/:environment_id/slos", h.CheckByEnvironmentID, h.CreateSLO)
+	rg.PUT("/observability/environment/:environment_id/slos/:slo_id", h.CheckByEnvironmentID, h.UpdateSLO)
+	rg.DELETE("/observability/environment/:environment_id/slos/:slo_id", h.CheckByEnvironmentID, h.DeleteSLO)
+	rg.GET("/observability/environment/:environment_id/slos/:slo_id/status", h.CheckByEnvironmentID, h.SLOStatus)
+	rg.GET("/observability/environment/:environment_id/slos/:slo_id/graphs", h.CheckByEnvironmentID, h.SLOGraphs)
+
 	// exporter
 	rg.GET("/observability/monitor/exporters/:name/schema", h.ExporterSchema)
 
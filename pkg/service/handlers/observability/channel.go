@@ -48,6 +48,9 @@ func (h *ObservabilityHandler) getChannelReq(c *gin.Context) (*models.AlertChann
 	if err := req.ChannelConfig.ChannelIf.Check(); err != nil {
 		return nil, err
 	}
+	if err := h.checkOnCallChannel(c, &req); err != nil {
+		return nil, err
+	}
 	return &req, nil
 }
 
@@ -242,6 +245,29 @@ func (h *ObservabilityHandler) DeleteChannel(c *gin.Context) {
 		handlers.NotOK(c, fmt.Errorf("该告警渠道正在被告警规则: [%s] 使用", strings.Join(tmp, ",")))
 		return
 	}
+	// SLO 的接收器以 json 保存，没有外键约束
+	slos := []models.SLO{}
+	if err := h.GetDB().WithContext(ctx).Preload("Environment").Select("id", "name", "receivers", "environment_id").Find(&slos).Error; err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	usedby := []string{}
+	for _, slo := range slos {
+		for _, rec := range slo.Receivers {
+			if rec.AlertChannelID == ch.ID {
+				if slo.Environment != nil {
+					usedby = append(usedby, slo.Environment.EnvironmentName+"/"+slo.Name)
+				} else {
+					usedby = append(usedby, slo.Name)
+				}
+				break
+			}
+		}
+	}
+	if len(usedby) > 0 {
+		handlers.NotOK(c, fmt.Errorf("该告警渠道正在被SLO: [%s] 使用", strings.Join(usedby, ",")))
+		return
+	}
 	if err := h.GetDB().WithContext(ctx).Delete(ch).Error; err != nil {
 		handlers.NotOK(c, err)
 		return
@@ -0,0 +1,474 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package observability
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"kubegems.io/kubegems/pkg/service/handlers"
+	"kubegems.io/kubegems/pkg/service/models"
+	"kubegems.io/kubegems/pkg/utils/prometheus/channels"
+)
+
+// ListOnCallSchedules 值班表列表
+// @Tags        Observability
+// @Summary     值班表列表
+// @Description 值班表列表
+// @Accept      json
+// @Produce     json
+// @Param       tenant_id path     string                                                                          true  "租户id"
+// @Param       search    query    string                                                                          false "search in (name)"
+// @Param       page      query    int                                                                             false "page"
+// @Param       size      query    int                                                                             false "size"
+// @Success     200       {object} handlers.ResponseStruct{Data=pagination.PageData{List=[]models.OnCallSchedule}} "resp"
+// @Router      /v1/observability/tenant/{tenant_id}/oncall/schedules [get]
+// @Security    JWT
+func (h *ObservabilityHandler) ListOnCallSchedules(c *gin.Context) {
+	list := []models.OnCallSchedule{}
+	query, err := handlers.GetQuery(c, nil)
+	if err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	cond := &handlers.PageQueryCond{
+		Model:        "OnCallSchedule",
+		SearchFields: []string{"name"},
+		Where:        []*handlers.QArgs{handlers.Args("tenant_id = ?", c.Param("tenant_id"))},
+	}
+	total, page, size, err := query.PageList(h.GetDB().WithContext(c.Request.Context()), cond, &list)
+	if err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	handlers.OK(c, handlers.Page(total, list, page, size))
+}
+
+// GetOnCallSchedule 值班表详情
+// @Tags        Observability
+// @Summary     值班表详情
+// @Description 值班表详情
+// @Accept      json
+// @Produce     json
+// @Param       tenant_id   path     string                                              true "租户id"
+// @Param       schedule_id path     uint                                                true "值班表id"
+// @Success     200         {object} handlers.ResponseStruct{Data=models.OnCallSchedule} "resp"
+// @Router      /v1/observability/tenant/{tenant_id}/oncall/schedules/{schedule_id} [get]
+// @Security    JWT
+func (h *ObservabilityHandler) GetOnCallSchedule(c *gin.Context) {
+	ret := models.OnCallSchedule{}
+	if err := h.GetDB().WithContext(c.Request.Context()).
+		First(&ret, "id = ? and tenant_id = ?", c.Param("schedule_id"), c.Param("tenant_id")).Error; err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	handlers.OK(c, ret)
+}
+
+// WhoIsOnCall 当前值班人
+// @Tags        Observability
+// @Summary     当前值班人
+// @Description 值班表在某一时刻的值班人，为空表示无人值班
+// @Accept      json
+// @Produce     json
+// @Param       tenant_id   path     string                               true  "租户id"
+// @Param       schedule_id path     uint                                 true  "值班表id"
+// @Param       time        query    string                               false "时间，格式 2006-01-02T15:04:05Z07:00，默认为当前时间"
+// @Success     200         {object} handlers.ResponseStruct{Data=string} "值班人"
+// @Router      /v1/observability/tenant/{tenant_id}/oncall/schedules/{schedule_id}/oncall [get]
+// @Security    JWT
+func (h *ObservabilityHandler) WhoIsOnCall(c *gin.Context) {
+	t := time.Now()
+	if timestr := c.Query("time"); timestr != "" {
+		var err error
+		if t, err = time.Parse(time.RFC3339, timestr); err != nil {
+			handlers.NotOK(c, err)
+			return
+		}
+	}
+	schedule := models.OnCallSchedule{}
+	if err := h.GetDB().WithContext(c.Request.Context()).
+		First(&schedule, "id = ? and tenant_id = ?", c.Param("schedule_id"), c.Param("tenant_id")).Error; err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	handlers.OK(c, schedule.OnCallAt(t))
+}
+
+// CreateOnCallSchedule 创建值班表
+// @Tags        Observability
+// @Summary     创建值班表
+// @Description 创建值班表
+// @Accept      json
+// @Produce     json
+// @Param       tenant_id path     string                               true "租户id"
+// @Param       form      body     models.OnCallSchedule                true "body"
+// @Success     200       {object} handlers.ResponseStruct{Data=string} "resp"
+// @Router      /v1/observability/tenant/{tenant_id}/oncall/schedules [post]
+// @Security    JWT
+func (h *ObservabilityHandler) CreateOnCallSchedule(c *gin.Context) {
+	req := &models.OnCallSchedule{}
+	if err := h.bindOnCallReq(c, req, &req.TenantID, &req.Creator); err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	h.SetAuditData(c, "创建", "值班表", req.Name)
+	if err := req.Validate(); err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	if err := h.GetDB().WithContext(c.Request.Context()).Create(req).Error; err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	handlers.OK(c, "ok")
+}
+
+// UpdateOnCallSchedule 更新值班表
+// @Tags        Observability
+// @Summary     更新值班表
+// @Description 更新值班表
+// @Accept      json
+// @Produce     json
+// @Param       tenant_id   path     string                               true "租户id"
+// @Param       schedule_id path     uint                                 true "值班表id"
+// @Param       form        body     models.OnCallSchedule                true "body"
+// @Success     200         {object} handlers.ResponseStruct{Data=string} "resp"
+// @Router      /v1/observability/tenant/{tenant_id}/oncall/schedules/{schedule_id} [put]
+// @Security    JWT
+func (h *ObservabilityHandler) UpdateOnCallSchedule(c *gin.Context) {
+	req := &models.OnCallSchedule{}
+	if err := h.bindOnCallReq(c, req, &req.TenantID, &req.Creator); err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	h.SetAuditData(c, "更新", "值班表", req.Name)
+	if err := req.Validate(); err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	if err := h.GetDB().WithContext(c.Request.Context()).
+		Select("name", "description", "timezone", "layers", "overrides").
+		Where("id = ? and tenant_id = ?", c.Param("schedule_id"), c.Param("tenant_id")).
+		Updates(req).Error; err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	handlers.OK(c, "ok")
+}
+
+// DeleteOnCallSchedule 删除值班表
+// @Tags        Observability
+// @Summary     删除值班表
+// @Description 删除值班表，被告警渠道或升级策略使用时不能删除
+// @Accept      json
+// @Produce     json
+// @Param       tenant_id   path     string                               true "租户id"
+// @Param       schedule_id path     uint                                 true "值班表id"
+// @Success     200         {object} handlers.ResponseStruct{Data=string} "resp"
+// @Router      /v1/observability/tenant/{tenant_id}/oncall/schedules/{schedule_id} [delete]
+// @Security    JWT
+func (h *ObservabilityHandler) DeleteOnCallSchedule(c *gin.Context) {
+	ctx := c.Request.Context()
+	schedule := &models.OnCallSchedule{}
+	if err := h.GetDB().WithContext(ctx).First(schedule, "id = ? and tenant_id = ?", c.Param("schedule_id"), c.Param("tenant_id")).Error; err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	h.SetAuditData(c, "删除", "值班表", schedule.Name)
+	h.SetExtraAuditData(c, models.ResTenant, *schedule.TenantID)
+
+	users, err := h.onCallReferences(c, func(oncall *channels.OnCall) bool { return oncall.ScheduleID == schedule.ID })
+	if err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	policies := []models.EscalationPolicy{}
+	if err := h.GetDB().WithContext(ctx).Find(&policies, "tenant_id = ?", schedule.TenantID).Error; err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	for _, p := range policies {
+		for _, level := range p.Levels {
+			if containsUint(level.ScheduleIDs, schedule.ID) {
+				users = append(users, p.Name)
+				break
+			}
+		}
+	}
+	if len(users) > 0 {
+		handlers.NotOK(c, fmt.Errorf("该值班表正在被: [%s] 使用", strings.Join(users, ",")))
+		return
+	}
+	if err := h.GetDB().WithContext(ctx).Delete(schedule).Error; err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	handlers.OK(c, "ok")
+}
+
+// ListEscalationPolicies 升级策略列表
+// @Tags        Observability
+// @Summary     升级策略列表
+// @Description 升级策略列表
+// @Accept      json
+// @Produce     json
+// @Param       tenant_id path     string                                                                            true  "租户id"
+// @Param       search    query    string                                                                            false "search in (name)"
+// @Param       page      query    int                                                                               false "page"
+// @Param       size      query    int                                                                               false "size"
+// @Success     200       {object} handlers.ResponseStruct{Data=pagination.PageData{List=[]models.EscalationPolicy}} "resp"
+// @Router      /v1/observability/tenant/{tenant_id}/oncall/escalationpolicies [get]
+// @Security    JWT
+func (h *ObservabilityHandler) ListEscalationPolicies(c *gin.Context) {
+	list := []models.EscalationPolicy{}
+	query, err := handlers.GetQuery(c, nil)
+	if err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	cond := &handlers.PageQueryCond{
+		Model:        "EscalationPolicy",
+		SearchFields: []string{"name"},
+		Where:        []*handlers.QArgs{handlers.Args("tenant_id = ?", c.Param("tenant_id"))},
+	}
+	total, page, size, err := query.PageList(h.GetDB().WithContext(c.Request.Context()), cond, &list)
+	if err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	handlers.OK(c, handlers.Page(total, list, page, size))
+}
+
+// GetEscalationPolicy 升级策略详情
+// @Tags        Observability
+// @Summary     升级策略详情
+// @Description 升级策略详情
+// @Accept      json
+// @Produce     json
+// @Param       tenant_id path     string                                                true "租户id"
+// @Param       policy_id path     uint                                                  true "升级策略id"
+// @Success     200       {object} handlers.ResponseStruct{Data=models.EscalationPolicy} "resp"
+// @Router      /v1/observability/tenant/{tenant_id}/oncall/escalationpolicies/{policy_id} [get]
+// @Security    JWT
+func (h *ObservabilityHandler) GetEscalationPolicy(c *gin.Context) {
+	ret := models.EscalationPolicy{}
+	if err := h.GetDB().WithContext(c.Request.Context()).
+		First(&ret, "id = ? and tenant_id = ?", c.Param("policy_id"), c.Param("tenant_id")).Error; err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	handlers.OK(c, ret)
+}
+
+// CreateEscalationPolicy 创建升级策略
+// @Tags        Observability
+// @Summary     创建升级策略
+// @Description 创建升级策略
+// @Accept      json
+// @Produce     json
+// @Param       tenant_id path     string                               true "租户id"
+// @Param       form      body     models.EscalationPolicy              true "body"
+// @Success     200       {object} handlers.ResponseStruct{Data=string} "resp"
+// @Router      /v1/observability/tenant/{tenant_id}/oncall/escalationpolicies [post]
+// @Security    JWT
+func (h *ObservabilityHandler) CreateEscalationPolicy(c *gin.Context) {
+	req := &models.EscalationPolicy{}
+	if err := h.bindOnCallReq(c, req, &req.TenantID, &req.Creator); err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	h.SetAuditData(c, "创建", "升级策略", req.Name)
+	if err := h.checkEscalationPolicy(c, req); err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	if err := h.GetDB().WithContext(c.Request.Context()).Create(req).Error; err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	handlers.OK(c, "ok")
+}
+
+// UpdateEscalationPolicy 更新升级策略
+// @Tags        Observability
+// @Summary     更新升级策略
+// @Description 更新升级策略，进行中的升级在下次通知时使用新的策略
+// @Accept      json
+// @Produce     json
+// @Param       tenant_id path     string                               true "租户id"
+// @Param       policy_id path     uint                                 true "升级策略id"
+// @Param       form      body     models.EscalationPolicy              true "body"
+// @Success     200       {object} handlers.ResponseStruct{Data=string} "resp"
+// @Router      /v1/observability/tenant/{tenant_id}/oncall/escalationpolicies/{policy_id} [put]
+// @Security    JWT
+func (h *ObservabilityHandler) UpdateEscalationPolicy(c *gin.Context) {
+	req := &models.EscalationPolicy{}
+	if err := h.bindOnCallReq(c, req, &req.TenantID, &req.Creator); err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	h.SetAuditData(c, "更新", "升级策略", req.Name)
+	if err := h.checkEscalationPolicy(c, req); err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	if err := h.GetDB().WithContext(c.Request.Context()).
+		Select("name", "description", "levels", "repeat").
+		Where("id = ? and tenant_id = ?", c.Param("policy_id"), c.Param("tenant_id")).
+		Updates(req).Error; err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	handlers.OK(c, "ok")
+}
+
+// DeleteEscalationPolicy 删除升级策略
+// @Tags        Observability
+// @Summary     删除升级策略
+// @Description 删除升级策略，被告警渠道使用时不能删除
+// @Accept      json
+// @Produce     json
+// @Param       tenant_id path     string                               true "租户id"
+// @Param       policy_id path     uint                                 true "升级策略id"
+// @Success     200       {object} handlers.ResponseStruct{Data=string} "resp"
+// @Router      /v1/observability/tenant/{tenant_id}/oncall/escalationpolicies/{policy_id} [delete]
+// @Security    JWT
+func (h *ObservabilityHandler) DeleteEscalationPolicy(c *gin.Context) {
+	ctx := c.Request.Context()
+	policy := &models.EscalationPolicy{}
+	if err := h.GetDB().WithContext(ctx).First(policy, "id = ? and tenant_id = ?", c.Param("policy_id"), c.Param("tenant_id")).Error; err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	h.SetAuditData(c, "删除", "升级策略", policy.Name)
+	h.SetExtraAuditData(c, models.ResTenant, *policy.TenantID)
+
+	users, err := h.onCallReferences(c, func(oncall *channels.OnCall) bool { return oncall.EscalationPolicyID == policy.ID })
+	if err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	if len(users) > 0 {
+		handlers.NotOK(c, fmt.Errorf("该升级策略正在被告警渠道: [%s] 使用", strings.Join(users, ",")))
+		return
+	}
+	if err := h.GetDB().WithContext(ctx).Delete(policy).Error; err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	handlers.OK(c, "ok")
+}
+
+// bindOnCallReq 绑定值班表或升级策略的请求，并设置租户及创建者
+func (h *ObservabilityHandler) bindOnCallReq(c *gin.Context, req interface{}, tenantID **uint, creator *string) error {
+	if err := c.BindJSON(req); err != nil {
+		return err
+	}
+	t, _ := strconv.Atoi(c.Param("tenant_id"))
+	if t == 0 {
+		return fmt.Errorf("tenant id not valid")
+	}
+	tmp := uint(t)
+	*tenantID = &tmp
+	h.SetExtraAuditData(c, models.ResTenant, tmp)
+	u, exist := h.GetContextUser(c)
+	if !exist {
+		return fmt.Errorf("not login")
+	}
+	*creator = u.GetUsername()
+	return nil
+}
+
+// checkEscalationPolicy 检查升级策略中的值班表、告警渠道是否存在，用户是否为租户成员
+func (h *ObservabilityHandler) checkEscalationPolicy(c *gin.Context, req *models.EscalationPolicy) error {
+	if err := req.Validate(); err != nil {
+		return err
+	}
+	db := h.GetDB().WithContext(c.Request.Context())
+	for _, level := range req.Levels {
+		for _, id := range level.ScheduleIDs {
+			if err := db.First(&models.OnCallSchedule{}, "id = ? and tenant_id = ?", id, req.TenantID).Error; err != nil {
+				return fmt.Errorf("值班表 %d 不存在: %w", id, err)
+			}
+		}
+		for _, username := range level.Users {
+			// 只能通知租户成员
+			var count int64
+			if err := db.Model(&models.User{}).
+				Joins("join tenant_user_rels on tenant_user_rels.user_id = users.id").
+				Where("users.username = ? and tenant_user_rels.tenant_id = ?", username, req.TenantID).
+				Count(&count).Error; err != nil {
+				return err
+			}
+			if count == 0 {
+				return fmt.Errorf("用户 %s 不是该租户的成员", username)
+			}
+		}
+		for _, id := range level.ChannelIDs {
+			ch := &models.AlertChannel{}
+			if err := db.First(ch, "id = ? and (tenant_id is null or tenant_id = ?)", id, req.TenantID).Error; err != nil {
+				return fmt.Errorf("告警渠道 %d 不存在: %w", id, err)
+			}
+			if _, ok := ch.ChannelConfig.ChannelIf.(*channels.OnCall); ok {
+				return fmt.Errorf("升级策略不能使用值班渠道 %s", ch.Name)
+			}
+		}
+	}
+	return nil
+}
+
+// checkOnCallChannel 检查值班渠道引用的值班表或升级策略是否属于该租户
+func (h *ObservabilityHandler) checkOnCallChannel(c *gin.Context, ch *models.AlertChannel) error {
+	oncall, ok := ch.ChannelConfig.ChannelIf.(*channels.OnCall)
+	if !ok {
+		return nil
+	}
+	if ch.TenantID == nil {
+		return fmt.Errorf("值班渠道必须属于租户")
+	}
+	db := h.GetDB().WithContext(c.Request.Context())
+	if oncall.ScheduleID != 0 {
+		return db.First(&models.OnCallSchedule{}, "id = ? and tenant_id = ?", oncall.ScheduleID, ch.TenantID).Error
+	}
+	return db.First(&models.EscalationPolicy{}, "id = ? and tenant_id = ?", oncall.EscalationPolicyID, ch.TenantID).Error
+}
+
+// onCallReferences 返回引用了值班表或升级策略的值班渠道名称
+func (h *ObservabilityHandler) onCallReferences(c *gin.Context, match func(oncall *channels.OnCall) bool) ([]string, error) {
+	chs := []models.AlertChannel{}
+	if err := h.GetDB().WithContext(c.Request.Context()).Find(&chs, "tenant_id = ?", c.Param("tenant_id")).Error; err != nil {
+		return nil, err
+	}
+	ret := []string{}
+	for _, ch := range chs {
+		if oncall, ok := ch.ChannelConfig.ChannelIf.(*channels.OnCall); ok && match(oncall) {
+			ret = append(ret, ch.Name)
+		}
+	}
+	return ret, nil
+}
+
+func containsUint(list []uint, v uint) bool {
+	for _, item := range list {
+		if item == v {
+			return true
+		}
+	}
+	return false
+}
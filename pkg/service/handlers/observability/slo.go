@@ -0,0 +1,523 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package observability
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	monitoringv1 "github.com/prometheus-operator/prometheus-operator/pkg/apis/monitoring/v1"
+	prommodel "github.com/prometheus/common/model"
+	"gorm.io/gorm"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"kubegems.io/kubegems/pkg/apis/gems"
+	"kubegems.io/kubegems/pkg/i18n"
+	"kubegems.io/kubegems/pkg/service/handlers"
+	"kubegems.io/kubegems/pkg/service/models"
+	"kubegems.io/kubegems/pkg/utils/agents"
+	"kubegems.io/kubegems/pkg/utils/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// SLOStatus SLO 当前状态，无数据时为空
+type SLOStatus struct {
+	Objective            float64             `json:"objective"`
+	Window               string              `json:"window"`
+	ErrorBudget          float64             `json:"errorBudget"`          // 错误预算比例, eg. 0.001
+	SLI                  *float64            `json:"sli"`                  // 统计窗口内的 SLI
+	ErrorBudgetRemaining *float64            `json:"errorBudgetRemaining"` // 剩余错误预算比例，小于0表示已耗尽
+	BurnRates            map[string]*float64 `json:"burnRates"`            // 各窗口的燃烧速率
+}
+
+// ListSLO SLO列表
+// @Tags        Observability
+// @Summary     SLO列表
+// @Description SLO列表
+// @Accept      json
+// @Produce     json
+// @Param       environment_id path     string                                    true "环境ID"
+// @Success     200            {object} handlers.ResponseStruct{Data=[]models.SLO} "SLO列表"
+// @Router      /v1/observability/environment/{environment_id}/slos [get]
+// @Security    JWT
+func (h *ObservabilityHandler) ListSLO(c *gin.Context) {
+	ret := []models.SLO{}
+	if err := h.GetDB().WithContext(c.Request.Context()).Find(&ret, "environment_id = ?", c.Param("environment_id")).Error; err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	handlers.OK(c, ret)
+}
+
+// GetSLO SLO详情
+// @Tags        Observability
+// @Summary     SLO详情
+// @Description SLO详情
+// @Accept      json
+// @Produce     json
+// @Param       environment_id path     string                                  true "环境ID"
+// @Param       slo_id         path     uint                                    true "slo id"
+// @Success     200            {object} handlers.ResponseStruct{Data=models.SLO} "SLO详情"
+// @Router      /v1/observability/environment/{environment_id}/slos/{slo_id} [get]
+// @Security    JWT
+func (h *ObservabilityHandler) GetSLO(c *gin.Context) {
+	slo, err := h.getSLO(c)
+	if err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	handlers.OK(c, slo)
+}
+
+// CreateSLO 创建SLO
+// @Tags        Observability
+// @Summary     创建SLO
+// @Description 创建SLO，同时生成 recording rules 及多窗口多燃烧速率告警规则
+// @Accept      json
+// @Produce     json
+// @Param       environment_id path     string                               true "环境ID"
+// @Param       form           body     models.SLO                           true "SLO"
+// @Success     200            {object} handlers.ResponseStruct{Data=string} "resp"
+// @Router      /v1/observability/environment/{environment_id}/slos [post]
+// @Security    JWT
+func (h *ObservabilityHandler) CreateSLO(c *gin.Context) {
+	req, err := h.getSLOReq(c)
+	if err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	action := i18n.Sprintf(context.TODO(), "create")
+	module := i18n.Sprintf(context.TODO(), "service level objective")
+	h.SetAuditData(c, action, module, req.Name)
+	h.SetExtraAuditData(c, models.ResEnvironment, *req.EnvironmentID)
+
+	env := req.Environment
+	if err := h.withAlertRuleProcessor(c.Request.Context(), env.Cluster.ClusterName, func(ctx context.Context, p *AlertRuleProcessor) error {
+		return p.DBWithCtx(ctx).Transaction(func(tx *gorm.DB) error {
+			// 与告警规则共用 alertmanagerconfig 的命名空间
+			var count int64
+			if err := tx.Model(&models.AlertRule{}).
+				Where("cluster = ? and namespace = ? and name = ?", env.Cluster.ClusterName, env.Namespace, req.AlertName()).
+				Count(&count).Error; err != nil {
+				return err
+			}
+			if count > 0 {
+				return errors.Errorf("alert rule %s is already exist", req.AlertName())
+			}
+			if err := tx.Omit("Environment").Create(req).Error; err != nil {
+				return err
+			}
+			return p.SyncSLO(ctx, req)
+		})
+	}); err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	handlers.OK(c, "ok")
+}
+
+// UpdateSLO 更新SLO
+// @Tags        Observability
+// @Summary     更新SLO
+// @Description 更新SLO，名称不可修改
+// @Accept      json
+// @Produce     json
+// @Param       environment_id path     string                               true "环境ID"
+// @Param       slo_id         path     uint                                 true "slo id"
+// @Param       form           body     models.SLO                           true "SLO"
+// @Success     200            {object} handlers.ResponseStruct{Data=string} "resp"
+// @Router      /v1/observability/environment/{environment_id}/slos/{slo_id} [put]
+// @Security    JWT
+func (h *ObservabilityHandler) UpdateSLO(c *gin.Context) {
+	old, err := h.getSLO(c)
+	if err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	req, err := h.getSLOReq(c)
+	if err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	req.ID = old.ID
+	req.Name = old.Name
+	action := i18n.Sprintf(context.TODO(), "update")
+	module := i18n.Sprintf(context.TODO(), "service level objective")
+	h.SetAuditData(c, action, module, req.Name)
+	h.SetExtraAuditData(c, models.ResEnvironment, *req.EnvironmentID)
+
+	if err := h.withAlertRuleProcessor(c.Request.Context(), req.Environment.Cluster.ClusterName, func(ctx context.Context, p *AlertRuleProcessor) error {
+		return p.DBWithCtx(ctx).Transaction(func(tx *gorm.DB) error {
+			if err := tx.Select("description", "application", "sli", "objective", "window", "receivers").
+				Omit("Environment").Updates(req).Error; err != nil {
+				return err
+			}
+			return p.SyncSLO(ctx, req)
+		})
+	}); err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	handlers.OK(c, "ok")
+}
+
+// DeleteSLO 删除SLO
+// @Tags        Observability
+// @Summary     删除SLO
+// @Description 删除SLO及其生成的规则
+// @Accept      json
+// @Produce     json
+// @Param       environment_id path     string                               true "环境ID"
+// @Param       slo_id         path     uint                                 true "slo id"
+// @Success     200            {object} handlers.ResponseStruct{Data=string} "resp"
+// @Router      /v1/observability/environment/{environment_id}/slos/{slo_id} [delete]
+// @Security    JWT
+func (h *ObservabilityHandler) DeleteSLO(c *gin.Context) {
+	slo, err := h.getSLO(c)
+	if err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	action := i18n.Sprintf(context.TODO(), "delete")
+	module := i18n.Sprintf(context.TODO(), "service level objective")
+	h.SetAuditData(c, action, module, slo.Name)
+	h.SetExtraAuditData(c, models.ResEnvironment, *slo.EnvironmentID)
+
+	if err := h.withAlertRuleProcessor(c.Request.Context(), slo.Environment.Cluster.ClusterName, func(ctx context.Context, p *AlertRuleProcessor) error {
+		return p.DBWithCtx(ctx).Transaction(func(tx *gorm.DB) error {
+			if err := tx.Delete(slo).Error; err != nil {
+				return err
+			}
+			return p.deleteMonitorAlertRule(ctx, &models.AlertRule{Namespace: slo.Environment.Namespace, Name: slo.AlertName()})
+		})
+	}); err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	handlers.OK(c, "ok")
+}
+
+// SLOStatus SLO当前状态
+// @Tags        Observability
+// @Summary     SLO当前状态
+// @Description SLO当前的 SLI、剩余错误预算及各窗口的燃烧速率
+// @Accept      json
+// @Produce     json
+// @Param       environment_id path     string                                  true "环境ID"
+// @Param       slo_id         path     uint                                    true "slo id"
+// @Success     200            {object} handlers.ResponseStruct{Data=SLOStatus} "resp"
+// @Router      /v1/observability/environment/{environment_id}/slos/{slo_id}/status [get]
+// @Security    JWT
+func (h *ObservabilityHandler) SLOStatus(c *gin.Context) {
+	slo, err := h.getSLO(c)
+	if err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	budget, period, err := prometheus.ParseSLO(slo.Objective, slo.Window)
+	if err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	ret := SLOStatus{
+		Objective:   slo.Objective,
+		Window:      slo.Window,
+		ErrorBudget: budget,
+		BurnRates:   map[string]*float64{},
+	}
+	queries := sloGraphQueries(slo, budget, period)
+	if err := h.Execute(c.Request.Context(), slo.Environment.Cluster.ClusterName, func(ctx context.Context, cli agents.Client) error {
+		for name, query := range queries {
+			vector, err := cli.Extend().PrometheusVector(ctx, query)
+			if err != nil {
+				return errors.Wrapf(err, "query %s", query)
+			}
+			var value *float64
+			if len(vector) > 0 {
+				if v := float64(vector[0].Value); !math.IsNaN(v) && !math.IsInf(v, 0) {
+					value = &v
+				}
+			}
+			switch name {
+			case "sli":
+				ret.SLI = value
+			case "errorBudgetRemaining":
+				ret.ErrorBudgetRemaining = value
+			default:
+				ret.BurnRates[name] = value
+			}
+		}
+		return nil
+	}); err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	handlers.OK(c, ret)
+}
+
+// SLOGraphs SLO趋势图
+// @Tags        Observability
+// @Summary     SLO趋势图
+// @Description SLO的 SLI、剩余错误预算及各窗口燃烧速率的趋势图
+// @Accept      json
+// @Produce     json
+// @Param       environment_id path     string                                                    true  "环境ID"
+// @Param       slo_id         path     uint                                                      true  "slo id"
+// @Param       start          query    string                                                    false "开始时间，格式 2006-01-02T15:04:05Z07:00，默认为一天前"
+// @Param       end            query    string                                                    false "结束时间，格式 2006-01-02T15:04:05Z07:00，默认为当前时间"
+// @Param       step           query    int                                                       false "step, 单位秒，默认自动计算"
+// @Success     200            {object} handlers.ResponseStruct{Data=map[string]prommodel.Matrix} "key为 sli、errorBudgetRemaining 及 burnRate 窗口, eg. 1h"
+// @Router      /v1/observability/environment/{environment_id}/slos/{slo_id}/graphs [get]
+// @Security    JWT
+func (h *ObservabilityHandler) SLOGraphs(c *gin.Context) {
+	slo, err := h.getSLO(c)
+	if err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	budget, period, err := prometheus.ParseSLO(slo.Objective, slo.Window)
+	if err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	end, start := time.Now(), time.Time{}
+	if endstr := c.Query("end"); endstr != "" {
+		if end, err = time.Parse(time.RFC3339, endstr); err != nil {
+			handlers.NotOK(c, err)
+			return
+		}
+	}
+	start = end.Add(-24 * time.Hour)
+	if startstr := c.Query("start"); startstr != "" {
+		if start, err = time.Parse(time.RFC3339, startstr); err != nil {
+			handlers.NotOK(c, err)
+			return
+		}
+	}
+	// agent 只支持该格式的时间
+	startstr, endstr := start.UTC().Format("2006-01-02T15:04:05Z"), end.UTC().Format("2006-01-02T15:04:05Z")
+
+	ret := map[string]prommodel.Matrix{}
+	if err := h.Execute(c.Request.Context(), slo.Environment.Cluster.ClusterName, func(ctx context.Context, cli agents.Client) error {
+		for name, query := range sloGraphQueries(slo, budget, period) {
+			matrix, err := cli.Extend().PrometheusQueryRange(ctx, query, startstr, endstr, c.Query("step"))
+			if err != nil {
+				return errors.Wrapf(err, "query %s", query)
+			}
+			ret[name] = matrix
+		}
+		return nil
+	}); err != nil {
+		handlers.NotOK(c, err)
+		return
+	}
+	handlers.OK(c, ret)
+}
+
+func (h *ObservabilityHandler) getSLO(c *gin.Context) (*models.SLO, error) {
+	slo := &models.SLO{}
+	if err := h.GetDB().WithContext(c.Request.Context()).Preload("Environment.Cluster").
+		First(slo, "id = ? and environment_id = ?", c.Param("slo_id"), c.Param("environment_id")).Error; err != nil {
+		return nil, err
+	}
+	return slo, nil
+}
+
+func (h *ObservabilityHandler) getSLOReq(c *gin.Context) (*models.SLO, error) {
+	req := &models.SLO{}
+	if err := c.BindJSON(req); err != nil {
+		return nil, err
+	}
+	envid, err := strconv.Atoi(c.Param("environment_id"))
+	if err != nil {
+		return nil, errors.Wrap(err, "environment_id")
+	}
+	uintid := uint(envid)
+	req.EnvironmentID = &uintid
+	u, exist := h.GetContextUser(c)
+	if !exist {
+		return nil, fmt.Errorf("not login")
+	}
+	req.Creator = u.GetUsername()
+
+	env := &models.Environment{}
+	if err := h.GetDB().WithContext(c.Request.Context()).Preload("Cluster").First(env, "id = ?", req.EnvironmentID).Error; err != nil {
+		return nil, err
+	}
+	req.Environment = env
+
+	if err := models.IsValidAlertRuleName(req.AlertName()); err != nil {
+		return nil, err
+	}
+	if g := req.SLI.Generator; g != nil && g.Service == "" {
+		g.Service = req.Application
+	}
+	if _, _, err := prometheus.ParseSLO(req.Objective, req.Window); err != nil {
+		return nil, err
+	}
+	if _, _, err := req.SLI.Queries(env.Namespace); err != nil {
+		return nil, err
+	}
+	if len(req.Receivers) == 0 {
+		return nil, fmt.Errorf("告警接收器不能为空")
+	}
+	return req, nil
+}
+
+// SyncSLO 同步 SLO 生成的 prometheusrule 及 alertmanagerconfig，slo 的 Environment.Cluster 需要预先加载
+func (p *AlertRuleProcessor) SyncSLO(ctx context.Context, slo *models.SLO) error {
+	namespace := slo.Environment.Namespace
+	groups, err := GenerateSLORuleGroups(slo, namespace)
+	if err != nil {
+		return err
+	}
+	// 复用告警规则的 alertmanagerconfig 同步
+	alertrule := &models.AlertRule{
+		Cluster:       slo.Environment.Cluster.ClusterName,
+		Namespace:     namespace,
+		Name:          slo.AlertName(),
+		AlertType:     prometheus.AlertTypeMonitor,
+		InhibitLabels: []string{prometheus.SLOLabel},
+		IsOpen:        true,
+	}
+	for _, rec := range slo.Receivers {
+		alertrule.Receivers = append(alertrule.Receivers, &models.AlertReceiver{
+			AlertChannelID: rec.AlertChannelID,
+			Interval:       rec.Interval,
+		})
+	}
+	if err := SetReceivers(alertrule, p.DBWithCtx(ctx)); err != nil {
+		return err
+	}
+	if err := p.syncEmailSecret(ctx, alertrule); err != nil {
+		return errors.Wrap(err, "sync secret failed")
+	}
+	prule := &monitoringv1.PrometheusRule{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: namespace,
+			Name:      alertrule.Name,
+			Labels: map[string]string{
+				gems.LabelPrometheusRuleType: prometheus.SLORuleType,
+				gems.LabelPrometheusRuleName: alertrule.Name,
+			},
+		},
+	}
+	if _, err := controllerutil.CreateOrUpdate(ctx, p.cli, prule, func() error {
+		prule.Spec.Groups = groups
+		return nil
+	}); err != nil {
+		return errors.Wrap(err, "sync prometheusrule failed")
+	}
+	if err := p.syncAlertmanagerConfig(ctx, alertrule); err != nil {
+		return errors.Wrap(err, "sync alertmanagerconfig failed")
+	}
+	return nil
+}
+
+func sloSelector(slo *models.SLO) string {
+	return fmt.Sprintf(`{%s="%s", namespace="%s"}`, prometheus.SLOLabel, slo.Name, slo.Environment.Namespace)
+}
+
+// GenerateSLORuleGroups 生成 SLO 的 recording rules 及多窗口多燃烧速率告警规则
+// https://sre.google/workbook/alerting-on-slos/
+func GenerateSLORuleGroups(slo *models.SLO, namespace string) ([]monitoringv1.RuleGroup, error) {
+	budget, period, err := prometheus.ParseSLO(slo.Objective, slo.Window)
+	if err != nil {
+		return nil, err
+	}
+	good, total, err := slo.SLI.Queries(namespace)
+	if err != nil {
+		return nil, err
+	}
+	labels := map[string]string{
+		prometheus.SLOLabel:           slo.Name,
+		prometheus.PromqlNamespaceKey: namespace,
+	}
+	selector := sloSelector(slo)
+
+	// recording rules
+	windows := prometheus.SLORecordingWindows(period)
+	recordings := monitoringv1.RuleGroup{Name: slo.AlertName() + "-sli"}
+	for _, w := range windows {
+		recordings.Rules = append(recordings.Rules, monitoringv1.Rule{
+			Record: prometheus.SLIErrorRatioRecord(w),
+			Expr:   intstr.FromString(prometheus.SLIErrorRatioExpr(good, total, w)),
+			Labels: labels,
+		})
+	}
+	// 统计窗口内的错误率按请求数加权，即 increase(errors[window]) / increase(total[window])，
+	// 对短窗口错误率取平均会使低流量时段与高流量时段权重相同
+	recordings.Rules = append(recordings.Rules, monitoringv1.Rule{
+		Record: prometheus.SLIErrorRatioRecord(period),
+		Expr:   intstr.FromString(prometheus.SLIErrorRatioExpr(good, total, period)),
+		Labels: labels,
+	})
+
+	// 多窗口多燃烧速率告警，同一告警级别的多组窗口以 or 连接
+	alerts := monitoringv1.RuleGroup{Name: slo.AlertName()}
+	exprs := map[string]string{}
+	severities := []string{}
+	for _, w := range prometheus.BurnRateWindows(period) {
+		expr := fmt.Sprintf("(%s%s / %s > %s and %s%s / %s > %s)",
+			prometheus.SLIErrorRatioRecord(w.LongWindow), selector, prometheus.FormatFloat(budget), prometheus.FormatFloat(w.BurnRate),
+			prometheus.SLIErrorRatioRecord(w.ShortWindow), selector, prometheus.FormatFloat(budget), prometheus.FormatFloat(w.BurnRate),
+		)
+		if exist, ok := exprs[w.Severity]; ok {
+			exprs[w.Severity] = exist + " or " + expr
+		} else {
+			exprs[w.Severity] = expr
+			severities = append(severities, w.Severity)
+		}
+	}
+	message := fmt.Sprintf("%s: [cluster:{{ $externalLabels.%s }}] [namespace:%s] SLO %s 错误预算燃烧过快, 目标: %s%%, 统计窗口: %s, 当前燃烧速率: %s",
+		slo.AlertName(), prometheus.AlertClusterKey, namespace, slo.Name, prometheus.FormatFloat(slo.Objective), period, prometheus.ValueAnnotationExpr)
+	for _, severity := range severities {
+		alerts.Rules = append(alerts.Rules, monitoringv1.Rule{
+			Alert: slo.AlertName(),
+			Expr:  intstr.FromString(exprs[severity]),
+			Labels: map[string]string{
+				prometheus.AlertNamespaceLabel: namespace,
+				prometheus.AlertNameLabel:      slo.AlertName(),
+				prometheus.SeverityLabel:       severity,
+				prometheus.SLOLabel:            slo.Name,
+			},
+			Annotations: map[string]string{
+				prometheus.MessageAnnotationsKey: message,
+				prometheus.ValueAnnotationKey:    prometheus.ValueAnnotationExpr,
+			},
+		})
+	}
+	return []monitoringv1.RuleGroup{recordings, alerts}, nil
+}
+
+// sloGraphQueries 返回 SLI、剩余错误预算及各告警长窗口燃烧速率的查询语句
+func sloGraphQueries(slo *models.SLO, budget float64, period prommodel.Duration) map[string]string {
+	selector := sloSelector(slo)
+	b := prometheus.FormatFloat(budget)
+	periodRatio := prometheus.SLIErrorRatioRecord(period) + selector
+	ret := map[string]string{
+		"sli":                  fmt.Sprintf("1 - %s", periodRatio),
+		"errorBudgetRemaining": fmt.Sprintf("1 - %s / %s", periodRatio, b),
+	}
+	for _, w := range prometheus.BurnRateWindows(period) {
+		ret[w.LongWindow.String()] = fmt.Sprintf("%s%s / %s", prometheus.SLIErrorRatioRecord(w.LongWindow), selector, b)
+	}
+	return ret
+}
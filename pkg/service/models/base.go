@@ -34,6 +34,7 @@ import (
 	"kubegems.io/kubegems/pkg/utils/database"
 	"kubegems.io/kubegems/pkg/utils/prometheus/templates"
 	"kubegems.io/kubegems/pkg/utils/redis"
+	"kubegems.io/kubegems/pkg/utils/workflow"
 	"sigs.k8s.io/yaml"
 )
 
@@ -200,16 +201,24 @@ func MigrateModels(db *gorm.DB) error {
 		&AlertRule{}, &AlertReceiver{},
 		// 告警信息表
 		&AlertInfo{}, &AlertMessage{},
+		// 告警事件表
+		&AlertIncident{}, &AlertIncidentEvent{},
 		// alert channels
 		&AlertChannel{},
+		// 值班表及升级策略
+		&OnCallSchedule{}, &EscalationPolicy{}, &AlertEscalation{},
 		// 监控面板表
 		&MonitorDashboard{}, &MonitorDashboardTpl{},
+		// SLO
+		&SLO{},
 		// 登陆源
 		&AuthSource{},
 		// promql templates
 		&PromqlTplScope{}, &PromqlTplResource{}, &PromqlTplRule{},
 		// 公告
 		&Announcement{},
+		// 异步任务历史
+		&workflow.TaskHistory{},
 	)
 }
 
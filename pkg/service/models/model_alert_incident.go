@@ -0,0 +1,82 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"time"
+)
+
+// 告警事件状态
+const (
+	IncidentStatusOpen         = "open"
+	IncidentStatusAcknowledged = "acknowledged"
+	IncidentStatusResolved     = "resolved"
+)
+
+// 告警事件时间线类型
+const (
+	IncidentEventFiring       = "firing"       // 告警触发
+	IncidentEventAcknowledged = "acknowledged" // 确认处理
+	IncidentEventAssigned     = "assigned"     // 指派处理人
+	IncidentEventResolved     = "resolved"     // 解决
+	IncidentEventNote         = "note"         // 备注
+	IncidentEventLink         = "link"         // 关联链接
+	IncidentEventEscalated    = "escalated"    // 值班升级通知
+)
+
+// IncidentSystemCreator 由告警自动产生的时间线记录的创建者
+const IncidentSystemCreator = "alertmanager"
+
+// AlertIncident 告警事件，同一指纹的告警在解决前归为同一个事件
+type AlertIncident struct {
+	ID uint `gorm:"primarykey" json:"id"`
+
+	// 级联删除
+	Fingerprint string     `gorm:"type:varchar(50);index" json:"fingerprint"`
+	AlertInfo   *AlertInfo `gorm:"foreignKey:Fingerprint;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"alertInfo,omitempty"`
+
+	Severity string `gorm:"type:varchar(50);" json:"severity"`
+	Message  string `json:"message"` // 最近一次告警的消息
+	Status   string `gorm:"type:varchar(50);index" json:"status"`
+	Assignee string `gorm:"type:varchar(50);index" json:"assignee"`
+
+	StartsAt       *time.Time `gorm:"index" json:"startsAt"` // 告警开始时间
+	AcknowledgedAt *time.Time `json:"acknowledgedAt"`
+	ResolvedAt     *time.Time `json:"resolvedAt"`
+	SilenceEndsAt  *time.Time `json:"silenceEndsAt"` // 确认时创建的静默的结束时间
+	AlertEndsAt    *time.Time `json:"alertEndsAt"`   // 告警恢复时间，手动解决时告警可能仍在触发
+	CreatedAt      time.Time  `json:"createdAt"`
+	UpdatedAt      time.Time  `json:"updatedAt"`
+
+	Timeline []*AlertIncidentEvent `json:"timeline,omitempty"`
+}
+
+// AlertIncidentEvent 告警事件时间线，包括状态变更、备注及关联链接
+type AlertIncidentEvent struct {
+	ID uint `gorm:"primarykey" json:"id"`
+
+	AlertIncidentID uint           `json:"alertIncidentID"`
+	AlertIncident   *AlertIncident `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"-"`
+
+	Kind      string    `gorm:"type:varchar(50);" json:"kind"`
+	Creator   string    `gorm:"type:varchar(50);" json:"creator"`
+	Content   string    `json:"content"`
+	Link      string    `gorm:"type:varchar(512);" json:"link"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+func (i *AlertIncident) IsResolved() bool {
+	return i.Status == IncidentStatusResolved
+}
@@ -47,4 +47,19 @@ type Cluster struct {
 	ClusterResourceQuota datatypes.JSON
 	DeletedAt            gorm.DeletedAt // soft delete
 	ClientCertExpireAt   *time.Time     // 证书过期时间
+	// AgentMode agent 连接方式，为 tunnel 时由 agent 主动连接至控制面隧道，此时不需要 KubeConfig 以及 AgentAddr
+	AgentMode string `gorm:"type:varchar(32)"`
+	// BootstrapTokenHash 一次性的 agent 接入 token，agent 首次接入后失效
+	BootstrapTokenHash     string     `json:"-"`
+	BootstrapTokenExpireAt *time.Time // 接入 token 过期时间
+	// AgentTokenHash agent 首次接入时绑定的凭证，之后的连接使用该凭证认证
+	AgentTokenHash string `json:"-"`
+}
+
+const (
+	ClusterAgentModeTunnel = "tunnel"
+)
+
+func (c *Cluster) IsTunnelMode() bool {
+	return c.AgentMode == ClusterAgentModeTunnel
 }
@@ -0,0 +1,314 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"gorm.io/datatypes"
+)
+
+// OnCallSchedule 值班表
+type OnCallSchedule struct {
+	ID          uint            `gorm:"primarykey" json:"id"`
+	Name        string          `gorm:"type:varchar(50);uniqueIndex:uniq_idx_tenant_oncall_schedule" binding:"required" json:"name"`
+	Description string          `json:"description"`
+	Timezone    string          `gorm:"type:varchar(50)" json:"timezone"` // 时区, eg. Asia/Shanghai, 为空使用UTC
+	Layers      OnCallLayers    `json:"layers"`                           // 轮值层，靠后的层优先
+	Overrides   OnCallOverrides `json:"overrides"`                        // 替班，优先于所有轮值层
+
+	Creator   string     `gorm:"type:varchar(50)" json:"creator"`
+	CreatedAt *time.Time `json:"createdAt"`
+	UpdatedAt *time.Time `json:"updatedAt"`
+
+	TenantID *uint   `gorm:"uniqueIndex:uniq_idx_tenant_oncall_schedule" json:"tenantID"`
+	Tenant   *Tenant `gorm:"constraint:OnUpdate:RESTRICT,OnDelete:CASCADE;" json:"tenant,omitempty"`
+}
+
+// OnCallLayer 轮值层，Users 从 Start 开始每个 Rotation 周期轮换一次
+type OnCallLayer struct {
+	Name     string     `json:"name"`
+	Users    []string   `json:"users"`    // 按顺序轮值的用户名
+	Start    time.Time  `json:"start"`    // 开始时间，也是每次交接的时间点
+	End      *time.Time `json:"end"`      // 结束时间，为空表示一直有效
+	Rotation string     `json:"rotation"` // 轮换周期, eg. 12h, 1d, 1w, 整天的周期按值班表时区的日历交接
+	// 每天的生效时段，按值班表时区，格式 15:04，为空表示全天，结束早于开始表示跨天
+	DailyStart string `json:"dailyStart"`
+	DailyEnd   string `json:"dailyEnd"`
+}
+
+// OnCallOverride 替班，在时间段内由 User 值班
+type OnCallOverride struct {
+	User  string    `json:"user"`
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+type (
+	OnCallLayers    []OnCallLayer
+	OnCallOverrides []OnCallOverride
+)
+
+func (s *OnCallSchedule) Location() (*time.Location, error) {
+	if s.Timezone == "" {
+		return time.UTC, nil
+	}
+	return time.LoadLocation(s.Timezone)
+}
+
+func (s *OnCallSchedule) Validate() error {
+	if _, err := s.Location(); err != nil {
+		return fmt.Errorf("时区 %s 不合法: %w", s.Timezone, err)
+	}
+	if len(s.Layers) == 0 {
+		return fmt.Errorf("轮值层不能为空")
+	}
+	for i := range s.Layers {
+		if err := s.Layers[i].validate(); err != nil {
+			return fmt.Errorf("轮值层 %d: %w", i+1, err)
+		}
+	}
+	for _, o := range s.Overrides {
+		if o.User == "" {
+			return fmt.Errorf("替班用户不能为空")
+		}
+		if !o.End.After(o.Start) {
+			return fmt.Errorf("替班 %s 的结束时间必须晚于开始时间", o.User)
+		}
+	}
+	return nil
+}
+
+// OnCallAt 返回 t 时刻的值班用户，替班优先，其次是靠后的轮值层，无人值班返回空
+func (s *OnCallSchedule) OnCallAt(t time.Time) string {
+	for i := len(s.Overrides) - 1; i >= 0; i-- {
+		if o := s.Overrides[i]; !t.Before(o.Start) && t.Before(o.End) {
+			return o.User
+		}
+	}
+	loc, err := s.Location()
+	if err != nil {
+		loc = time.UTC
+	}
+	for i := len(s.Layers) - 1; i >= 0; i-- {
+		if user, ok := s.Layers[i].OnCallAt(t, loc); ok {
+			return user
+		}
+	}
+	return ""
+}
+
+func (l *OnCallLayer) validate() error {
+	if len(l.Users) == 0 {
+		return fmt.Errorf("轮值用户不能为空")
+	}
+	if l.Start.IsZero() {
+		return fmt.Errorf("开始时间不能为空")
+	}
+	if l.End != nil && !l.End.After(l.Start) {
+		return fmt.Errorf("结束时间必须晚于开始时间")
+	}
+	if _, err := l.rotation(); err != nil {
+		return err
+	}
+	if l.DailyStart == "" && l.DailyEnd == "" {
+		return nil
+	}
+	start, err := parseClock(l.DailyStart)
+	if err != nil {
+		return fmt.Errorf("每日开始时间 %s 不合法", l.DailyStart)
+	}
+	end, err := parseClock(l.DailyEnd)
+	if err != nil {
+		return fmt.Errorf("每日结束时间 %s 不合法", l.DailyEnd)
+	}
+	if start == end {
+		return fmt.Errorf("每日开始时间和结束时间不能相同")
+	}
+	return nil
+}
+
+func (l *OnCallLayer) rotation() (time.Duration, error) {
+	d, err := model.ParseDuration(l.Rotation)
+	if err != nil {
+		return 0, fmt.Errorf("轮换周期 %s 不合法: %w", l.Rotation, err)
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("轮换周期必须大于0")
+	}
+	return time.Duration(d), nil
+}
+
+// handoff 第 n 次交接的时间，整天的周期按日历计算，避免夏令时造成偏移
+func (l *OnCallLayer) handoff(n int, rotation time.Duration, loc *time.Location) time.Time {
+	if rotation%(24*time.Hour) == 0 {
+		return l.Start.In(loc).AddDate(0, 0, n*int(rotation/(24*time.Hour)))
+	}
+	return l.Start.Add(time.Duration(n) * rotation)
+}
+
+// OnCallAt 返回该层 t 时刻的值班用户，不在生效时间内返回false
+func (l *OnCallLayer) OnCallAt(t time.Time, loc *time.Location) (string, bool) {
+	if len(l.Users) == 0 || t.Before(l.Start) || (l.End != nil && !t.Before(*l.End)) {
+		return "", false
+	}
+	rotation, err := l.rotation()
+	if err != nil {
+		return "", false
+	}
+	if (l.DailyStart != "" || l.DailyEnd != "") && !inDailyWindow(t.In(loc), l.DailyStart, l.DailyEnd) {
+		return "", false
+	}
+	// 先估算再按实际交接时间修正
+	n := int(t.Sub(l.Start) / rotation)
+	for n > 0 && l.handoff(n, rotation, loc).After(t) {
+		n--
+	}
+	for !l.handoff(n+1, rotation, loc).After(t) {
+		n++
+	}
+	return l.Users[n%len(l.Users)], true
+}
+
+// parseClock 解析 15:04 格式的时间，返回当天的分钟数
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, err
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+func inDailyWindow(t time.Time, start, end string) bool {
+	s, err := parseClock(start)
+	if err != nil {
+		return false
+	}
+	e, err := parseClock(end)
+	if err != nil {
+		return false
+	}
+	m := t.Hour()*60 + t.Minute()
+	if s < e {
+		return m >= s && m < e
+	}
+	// 跨天
+	return m >= s || m < e
+}
+
+// EscalationPolicy 升级策略，告警未被确认时逐级通知
+type EscalationPolicy struct {
+	ID          uint             `gorm:"primarykey" json:"id"`
+	Name        string           `gorm:"type:varchar(50);uniqueIndex:uniq_idx_tenant_escalation_policy" binding:"required" json:"name"`
+	Description string           `json:"description"`
+	Levels      EscalationLevels `json:"levels"`
+	Repeat      int              `json:"repeat"` // 所有级别都通知后仍未确认时重复的次数
+
+	Creator   string     `gorm:"type:varchar(50)" json:"creator"`
+	CreatedAt *time.Time `json:"createdAt"`
+	UpdatedAt *time.Time `json:"updatedAt"`
+
+	TenantID *uint   `gorm:"uniqueIndex:uniq_idx_tenant_escalation_policy" json:"tenantID"`
+	Tenant   *Tenant `gorm:"constraint:OnUpdate:RESTRICT,OnDelete:CASCADE;" json:"tenant,omitempty"`
+}
+
+// EscalationLevel 升级级别，通知后 DelayMinutes 分钟内未确认则升级到下一级
+type EscalationLevel struct {
+	DelayMinutes int      `json:"delayMinutes"`
+	ScheduleIDs  []uint   `json:"scheduleIDs"` // 通知值班表当前的值班人
+	Users        []string `json:"users"`       // 通知的用户名
+	ChannelIDs   []uint   `json:"channelIDs"`  // 同时通知的告警渠道
+}
+
+type EscalationLevels []EscalationLevel
+
+const maxEscalationRepeat = 9
+
+func (p *EscalationPolicy) Validate() error {
+	if len(p.Levels) == 0 {
+		return fmt.Errorf("升级级别不能为空")
+	}
+	for i, level := range p.Levels {
+		if level.DelayMinutes < 1 {
+			return fmt.Errorf("级别 %d: 升级时间至少为1分钟", i+1)
+		}
+		if len(level.ScheduleIDs)+len(level.Users)+len(level.ChannelIDs) == 0 {
+			return fmt.Errorf("级别 %d: 通知对象不能为空", i+1)
+		}
+	}
+	if p.Repeat < 0 || p.Repeat > maxEscalationRepeat {
+		return fmt.Errorf("重复次数必须在0到%d之间", maxEscalationRepeat)
+	}
+	return nil
+}
+
+// LevelAt 返回第 step 次通知的级别，所有级别及重复次数都用完后返回false
+func (p *EscalationPolicy) LevelAt(step int) (*EscalationLevel, bool) {
+	if step < 0 || len(p.Levels) == 0 || step >= len(p.Levels)*(p.Repeat+1) {
+		return nil, false
+	}
+	return &p.Levels[step%len(p.Levels)], true
+}
+
+// AlertEscalation 值班告警的通知进度，每个指纹在每个值班渠道下只有一个未恢复的记录
+type AlertEscalation struct {
+	ID uint `gorm:"primarykey" json:"id"`
+
+	Fingerprint    string        `gorm:"type:varchar(50);index" json:"fingerprint"`
+	AlertChannelID uint          `json:"alertChannelID"`
+	AlertChannel   *AlertChannel `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"alertChannel,omitempty"`
+
+	Alert      datatypes.JSON `json:"alert"`               // 告警内容，通知时使用
+	Step       int            `json:"step"`                // 已通知的次数
+	NextAt     *time.Time     `gorm:"index" json:"nextAt"` // 下次通知的时间，为空表示已结束
+	ResolvedAt *time.Time     `json:"resolvedAt"`          // 告警恢复的时间，恢复前告警重复发送时不再重新通知
+	CreatedAt  time.Time      `json:"createdAt"`
+	UpdatedAt  time.Time      `json:"updatedAt"`
+}
+
+func (m OnCallLayers) Value() (driver.Value, error)     { return jsonValue(m) }
+func (m *OnCallLayers) Scan(val interface{}) error      { return jsonScan(val, m) }
+func (m OnCallLayers) GormDataType() string             { return "json" }
+func (m OnCallOverrides) Value() (driver.Value, error)  { return jsonValue(m) }
+func (m *OnCallOverrides) Scan(val interface{}) error   { return jsonScan(val, m) }
+func (m OnCallOverrides) GormDataType() string          { return "json" }
+func (m EscalationLevels) Value() (driver.Value, error) { return jsonValue(m) }
+func (m *EscalationLevels) Scan(val interface{}) error  { return jsonScan(val, m) }
+func (m EscalationLevels) GormDataType() string         { return "json" }
+
+func jsonValue(v interface{}) (driver.Value, error) {
+	ba, err := json.Marshal(v)
+	return string(ba), err
+}
+
+func jsonScan(val interface{}, dest interface{}) error {
+	var ba []byte
+	switch v := val.(type) {
+	case nil:
+		return nil
+	case []byte:
+		ba = v
+	case string:
+		ba = []byte(v)
+	default:
+		return errors.New(fmt.Sprint("Failed to unmarshal JSONB value:", val))
+	}
+	return json.Unmarshal(ba, dest)
+}
@@ -0,0 +1,133 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func mustTime(t *testing.T, s string) time.Time {
+	ret, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ret
+}
+
+func TestOnCallScheduleOnCallAt(t *testing.T) {
+	schedule := &OnCallSchedule{
+		Timezone: "Asia/Shanghai",
+		Layers: OnCallLayers{
+			{
+				Name:     "weekly",
+				Users:    []string{"alice", "bob", "carol"},
+				Start:    mustTime(t, "2022-08-01T09:00:00+08:00"),
+				Rotation: "1w",
+			},
+			{
+				Name:       "night",
+				Users:      []string{"dave", "erin"},
+				Start:      mustTime(t, "2022-08-01T09:00:00+08:00"),
+				Rotation:   "1d",
+				DailyStart: "22:00",
+				DailyEnd:   "06:00",
+			},
+		},
+		Overrides: OnCallOverrides{
+			{User: "frank", Start: mustTime(t, "2022-08-10T12:00:00+08:00"), End: mustTime(t, "2022-08-10T14:00:00+08:00")},
+		},
+	}
+	if err := schedule.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		time string
+		want string
+	}{
+		{time: "2022-07-31T09:00:00+08:00", want: ""},
+		{time: "2022-08-01T09:00:00+08:00", want: "alice"},
+		{time: "2022-08-08T08:59:59+08:00", want: "alice"},
+		{time: "2022-08-08T09:00:00+08:00", want: "bob"},
+		{time: "2022-08-15T10:00:00+08:00", want: "carol"},
+		{time: "2022-08-22T10:00:00+08:00", want: "alice"},
+		// 夜间层优先, 08-01 09:00 开始 dave 值第一天
+		{time: "2022-08-01T23:00:00+08:00", want: "dave"},
+		{time: "2022-08-02T05:59:00+08:00", want: "dave"},
+		{time: "2022-08-02T06:00:00+08:00", want: "alice"},
+		{time: "2022-08-02T22:30:00+08:00", want: "erin"},
+		// UTC 时间同样按值班表时区计算
+		{time: "2022-08-02T15:00:00Z", want: "erin"},
+		// 替班
+		{time: "2022-08-10T13:00:00+08:00", want: "frank"},
+		{time: "2022-08-10T14:00:00+08:00", want: "bob"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.time, func(t *testing.T) {
+			if got := schedule.OnCallAt(mustTime(t, tt.time)); got != tt.want {
+				t.Errorf("OnCallAt() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOnCallLayerDST(t *testing.T) {
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip(err)
+	}
+	layer := OnCallLayer{
+		Users:    []string{"alice", "bob"},
+		Start:    time.Date(2022, 11, 5, 9, 0, 0, 0, loc),
+		Rotation: "1d",
+	}
+	// 11-06 夏令时结束，当天有25小时，交接时间仍为当地9点
+	tests := []struct {
+		time time.Time
+		want string
+	}{
+		{time: time.Date(2022, 11, 6, 8, 30, 0, 0, loc), want: "alice"},
+		{time: time.Date(2022, 11, 6, 9, 0, 0, 0, loc), want: "bob"},
+		{time: time.Date(2022, 11, 7, 8, 59, 0, 0, loc), want: "bob"},
+		{time: time.Date(2022, 11, 7, 9, 0, 0, 0, loc), want: "alice"},
+	}
+	for _, tt := range tests {
+		if got, _ := layer.OnCallAt(tt.time, loc); got != tt.want {
+			t.Errorf("OnCallAt(%s) = %v, want %v", tt.time, got, tt.want)
+		}
+	}
+}
+
+func TestEscalationPolicyLevelAt(t *testing.T) {
+	policy := &EscalationPolicy{
+		Levels: EscalationLevels{
+			{DelayMinutes: 5, Users: []string{"alice"}},
+			{DelayMinutes: 10, Users: []string{"bob"}},
+		},
+		Repeat: 1,
+	}
+	if err := policy.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	for step, want := range []string{"alice", "bob", "alice", "bob"} {
+		level, ok := policy.LevelAt(step)
+		if !ok || level.Users[0] != want {
+			t.Errorf("LevelAt(%d) = %v, %v, want %s", step, level, ok, want)
+		}
+	}
+	if _, ok := policy.LevelAt(4); ok {
+		t.Errorf("LevelAt(4) should be out of levels")
+	}
+}
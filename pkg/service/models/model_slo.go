@@ -0,0 +1,89 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"kubegems.io/kubegems/pkg/utils/prometheus"
+)
+
+// SLO 服务等级目标，属于环境或者环境下的应用
+type SLO struct {
+	ID          uint   `gorm:"primarykey" json:"id"`
+	Name        string `gorm:"type:varchar(50);uniqueIndex:uniq_idx_env_slo" binding:"required" json:"name"`
+	Description string `json:"description"`
+	Application string `gorm:"type:varchar(50)" json:"application"` // 关联的应用，为空表示整个环境
+
+	SLI       prometheus.SLI `json:"sli"`
+	Objective float64        `json:"objective"`                      // 目标百分比, eg. 99.9
+	Window    string         `gorm:"type:varchar(50)" json:"window"` // 统计窗口, eg. 28d, 30d
+	Receivers SLOReceivers   `json:"receivers"`                      // 燃烧速率告警的接收器
+
+	Creator   string     `gorm:"type:varchar(50)" json:"creator"`
+	CreatedAt *time.Time `json:"createdAt"`
+	UpdatedAt *time.Time `json:"updatedAt"`
+
+	EnvironmentID *uint        `gorm:"uniqueIndex:uniq_idx_env_slo" json:"environmentID"`
+	Environment   *Environment `gorm:"constraint:OnUpdate:RESTRICT,OnDelete:CASCADE;" json:"environment,omitempty"`
+}
+
+// AlertName 生成的 prometheusrule、alertmanagerconfig 及告警的名称
+func (s *SLO) AlertName() string {
+	return prometheus.SLOAlertPrefix + s.Name
+}
+
+type SLOReceiver struct {
+	AlertChannelID uint   `json:"alertChannelID"`
+	Interval       string `json:"interval"`
+}
+
+type SLOReceivers []SLOReceiver
+
+func (m SLOReceivers) Value() (driver.Value, error) {
+	if m == nil {
+		return nil, nil
+	}
+	ba, err := json.Marshal(m)
+	return string(ba), err
+}
+
+func (m *SLOReceivers) Scan(val interface{}) error {
+	if val == nil {
+		*m = make(SLOReceivers, 0)
+		return nil
+	}
+	var ba []byte
+	switch v := val.(type) {
+	case []byte:
+		ba = v
+	case string:
+		ba = []byte(v)
+	default:
+		return errors.New(fmt.Sprint("Failed to unmarshal JSONB value:", val))
+	}
+	t := SLOReceivers{}
+	err := json.Unmarshal(ba, &t)
+	*m = t
+	return err
+}
+
+func (m SLOReceivers) GormDataType() string {
+	return "json"
+}
@@ -74,6 +74,24 @@ func (r *AlertReceiver) SetChannelAndStatus(getter channels.ChannelGetter) {
 			r.ChannelStatus = StatusChanged
 		}
 	}
+	if len(r.RawReceiver.SlackConfigs) > 0 {
+		s := r.RawReceiver.SlackConfigs[0]
+		slack, ok := channelIf.(*channels.Slack)
+		if ok && s.APIURL != nil && s.APIURL.Key == channels.SlackSecretKey(r.AlertChannel.ReceiverName(), slack.URL) &&
+			s.Channel == slack.Channel && s.Username == slack.Username {
+			r.ChannelStatus = StatusNormal
+		} else {
+			r.ChannelStatus = StatusChanged
+		}
+	}
+	if len(r.RawReceiver.PagerDutyConfigs) > 0 {
+		p := r.RawReceiver.PagerDutyConfigs[0]
+		if p.URL == channelIf.String() {
+			r.ChannelStatus = StatusNormal
+		} else {
+			r.ChannelStatus = StatusChanged
+		}
+	}
 	if len(r.RawReceiver.WebhookConfigs) > 0 {
 		w := r.RawReceiver.WebhookConfigs[0]
 		if *w.URL == channelIf.String() {
@@ -438,11 +438,12 @@ func (c *ObserveClient) CommitRawMonitorAlertResource(ctx context.Context, raw *
 }
 
 func (c *ObserveClient) CreateOrUpdateAlertEmailSecret(ctx context.Context, namespace string, receivers []AlertReceiver) error {
-	emails := map[string]*channels.Email{}
+	secrets := map[string]string{}
 	for _, rec := range receivers {
-		switch v := rec.AlertChannel.ChannelConfig.ChannelIf.(type) {
-		case *channels.Email:
-			emails[rec.AlertChannel.ReceiverName()] = v
+		if v, ok := rec.AlertChannel.ChannelConfig.ChannelIf.(channels.SecretChannel); ok {
+			for k, val := range v.SecretData(rec.AlertChannel.ReceiverName()) {
+				secrets[k] = val
+			}
 		}
 	}
 
@@ -460,8 +461,8 @@ func (c *ObserveClient) CreateOrUpdateAlertEmailSecret(ctx context.Context, name
 		if sec.Data == nil {
 			sec.Data = make(map[string][]byte)
 		}
-		for recName, v := range emails {
-			sec.Data[channels.EmailSecretKey(recName, v.From)] = []byte(v.AuthPassword) // 不需要encode
+		for k, v := range secrets {
+			sec.Data[k] = []byte(v) // 不需要encode
 		}
 		return nil
 	})
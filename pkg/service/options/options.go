@@ -16,6 +16,7 @@ package options
 
 import (
 	microservice "kubegems.io/kubegems/pkg/service/handlers/microservice/options"
+	"kubegems.io/kubegems/pkg/utils/agents"
 	"kubegems.io/kubegems/pkg/utils/argo"
 	"kubegems.io/kubegems/pkg/utils/database"
 	"kubegems.io/kubegems/pkg/utils/git"
@@ -46,6 +47,7 @@ type Options struct {
 	Models       *ModelsOptions                    `json:"models,omitempty"`
 	Edge         *EdgeOptions                      `json:"edge,omitempty"`
 	Otel         *otel.Options                     `json:"otel,omitempty"`
+	Tunnel       *agents.TunnelOptions             `json:"tunnel,omitempty"`
 }
 
 type ModelsOptions struct {
@@ -86,6 +88,7 @@ func DefaultOptions() *Options {
 		Models:       NewDefaultModelsOptions(),
 		Edge:         NewDefaultEdgeOptions(),
 		Otel:         otel.NewDefaultOptions(),
+		Tunnel:       agents.NewDefaultTunnelOptions(),
 	}
 	defaultoptions.System.Listen = ":8020"
 	return defaultoptions
@@ -70,6 +70,7 @@ func prepareDependencies(ctx context.Context, opts *options.Options) (*Dependenc
 		log.Errorf("failed to init agents: %v", err)
 		return nil, err
 	}
+	agentclientset.EnableTunnel(opts.Tunnel, "service-")
 	// git
 	gitprovider, err := git.NewProvider(opts.Git)
 	if err != nil {
@@ -131,6 +132,9 @@ func Run(ctx context.Context, opts *options.Options) error {
 	eg.Go(func() error {
 		return pprof.Run(ctx)
 	})
+	eg.Go(func() error {
+		return deps.Agentscli.RunTunnel(ctx)
+	})
 	eg.Go(func() error {
 		// 启动prometheus exporter
 		return exporterHandler.Run(ctx, opts.Exporter)
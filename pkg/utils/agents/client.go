@@ -50,16 +50,23 @@ type Client interface {
 var _ Client = &DelegateClient{}
 
 func NewDelegateClientClient(options *ClientOptions, name string, apiserver *url.URL, discovery discovery.DiscoveryInterface, tracer trace.Tracer) Client {
+	return newDelegateClient(options, name, apiserver, discovery)
+}
+
+func newDelegateClient(options *ClientOptions, name string, apiserver *url.URL, discovery discovery.DiscoveryInterface) *DelegateClient {
 	cli := NewTypedClient(options, kube.GetScheme())
-	return &DelegateClient{
+	delegate := &DelegateClient{
 		name:            name,
 		apiserverAddr:   apiserver,
 		baseaddr:        options.Addr,
-		discovery:       memory.NewMemCacheClient(discovery),
 		TypedClient:     cli,
 		ExtendClient:    NewExtendClientFrom(cli),
 		WebsocketClient: NewWebsocketClient(options),
 	}
+	if discovery != nil {
+		delegate.discovery = memory.NewMemCacheClient(discovery)
+	}
+	return delegate
 }
 
 type DelegateClient struct {
@@ -70,6 +77,7 @@ type DelegateClient struct {
 	baseaddr      *url.URL
 	apiserverAddr *url.URL
 	discovery     discovery.DiscoveryInterface
+	version       string // 没有 discovery 时使用的 apiserver 版本，例如隧道模式下 agent 上报的版本
 }
 
 func (c *DelegateClient) Extend() *ExtendClient {
@@ -89,6 +97,9 @@ func (c *DelegateClient) APIServerAddr() url.URL {
 }
 
 func (c *DelegateClient) APIServerVersion() string {
+	if c.discovery == nil {
+		return c.version
+	}
 	version, err := c.discovery.ServerVersion()
 	if err != nil {
 		return ""
@@ -16,6 +16,7 @@ package agents
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/url"
@@ -216,3 +217,33 @@ func (c *ExtendClient) LokiQuery(ctx context.Context, logql string) (loki.QueryR
 	}
 	return ret, nil
 }
+
+// LokiQueryRange 查询 logql 指标表达式，返回与 prometheus 相同格式的 matrix
+func (c *ExtendClient) LokiQueryRange(ctx context.Context, logql, start, end, step string) (prommodel.Matrix, error) {
+	log.Debugf("loki query range: %s", logql)
+	data := loki.QueryResponseData{}
+	values := url.Values{}
+	values.Add("query", logql)
+	values.Add("start", start)
+	values.Add("end", end)
+	values.Add("step", step)
+	if err := c.Inner.DoRequest(ctx, Request{
+		Path:  "/custom/loki/v1/queryrange",
+		Query: values,
+		Into:  WrappedResponse(&data),
+	}); err != nil {
+		return nil, err
+	}
+	if data.ResultType != loki.ResultTypeMatrix {
+		return nil, fmt.Errorf("unexpected loki result type %s, logql must be a metric query", data.ResultType)
+	}
+	bts, err := json.Marshal(data.Result)
+	if err != nil {
+		return nil, err
+	}
+	ret := prommodel.Matrix{}
+	if err := json.Unmarshal(bts, &ret); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
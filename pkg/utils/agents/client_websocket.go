@@ -46,6 +46,7 @@ func NewWebsocketClient(options *ClientOptions) *WebsocketClient {
 			TLSClientConfig:  options.TLS,
 			HandshakeTimeout: DefaultWebSocketHandshakeTimeout,
 			Proxy:            OptionAuthAsProxy(options),
+			NetDialContext:   options.DialContext,
 		},
 	}
 }
@@ -28,6 +28,7 @@ import (
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
+	"kubegems.io/kubegems/pkg/edge/tunnel"
 	"kubegems.io/kubegems/pkg/service/models"
 	"kubegems.io/kubegems/pkg/utils/database"
 )
@@ -36,6 +37,10 @@ type ClientSet struct {
 	database *database.Database
 	clients  sync.Map // name -> *Client
 	tracer   trace.Tracer
+
+	tunnel        *tunnel.TunnelServer
+	tunnelAuth    *ClusterTunnelAuth
+	tunnelOptions *TunnelOptions
 }
 
 // Initialize for gorm plugin
@@ -118,6 +123,16 @@ func (h *ClientSet) newClientFor(ctx context.Context, name string) (Client, erro
 	if err != nil {
 		return nil, err
 	}
+	if kubeconfig == nil {
+		// 直接访问 agent 时没有 apiserver 的访问凭证，apiserver 版本使用 agent 上报并记录在集群中的版本
+		cli := newDelegateClient(clientOptions, name, clientOptions.Addr, nil)
+		cluster := &models.Cluster{}
+		if err := h.database.DB().WithContext(ctx).Select("version").First(cluster, "cluster_name = ?", name).Error; err != nil {
+			return nil, err
+		}
+		cli.version = cluster.Version
+		return cli, nil
+	}
 	clientset, err := kubernetes.NewForConfig(kubeconfig)
 	if err != nil {
 		return nil, err
@@ -132,6 +147,10 @@ func (h *ClientSet) ClientOptionsFrom(ctx context.Context, name string) (*Client
 	if err := h.database.DB().WithContext(ctx).First(&cluster, "cluster_name = ?", name).Error; err != nil {
 		return nil, nil, err
 	}
+	// from tunnel
+	if cluster.IsTunnelMode() {
+		return h.tunnelClientOptions(cluster)
+	}
 	// from origin
 	if len(cluster.KubeConfig) == 0 || cluster.AgentAddr != "" {
 		baseaddr, err := url.Parse(cluster.AgentAddr)
@@ -174,6 +193,34 @@ func (h *ClientSet) ClientOptionsFrom(ctx context.Context, name string) (*Client
 	return serverinfo, restconfig, nil
 }
 
+func (h *ClientSet) tunnelClientOptions(cluster *models.Cluster) (*ClientOptions, *rest.Config, error) {
+	if h.tunnel == nil {
+		return nil, nil, fmt.Errorf("cluster %s is in tunnel mode but tunnel is not enabled", cluster.ClusterName)
+	}
+	agentaddr := cluster.AgentAddr
+	if agentaddr == "" {
+		agentaddr = DefaultTunnelAgentAddr
+	}
+	baseaddr, err := url.Parse(agentaddr)
+	if err != nil {
+		return nil, nil, err
+	}
+	tlscfg, err := TLSConfigFrom([]byte(cluster.AgentCA), []byte(cluster.AgentCert), []byte(cluster.AgentKey))
+	if err != nil {
+		return nil, nil, err
+	}
+	if cluster.AgentCA == "" {
+		// 隧道连接已经过认证，agent 使用自签名证书时跳过校验
+		tlscfg.InsecureSkipVerify = true
+	}
+	info := &ClientOptions{
+		Addr:        baseaddr,
+		TLS:         tlscfg,
+		DialContext: h.tunnel.DialerOn(cluster.ClusterName).DialContext,
+	}
+	return info, nil, nil
+}
+
 func ApiServerProxyPath(namespace, schema, svcname, port string) string {
 	if namespace == "" {
 		namespace = "kubegems-local"
@@ -15,8 +15,10 @@
 package agents
 
 import (
+	"context"
 	"crypto/tls"
 	"crypto/x509"
+	"net"
 	"net/http"
 	"net/url"
 )
@@ -25,6 +27,8 @@ type ClientOptions struct {
 	Addr *url.URL
 	TLS  *tls.Config
 	Auth Auth
+	// DialContext 不为空时使用该方法建立连接，例如通过隧道访问 agent
+	DialContext func(ctx context.Context, network, addr string) (net.Conn, error)
 }
 
 type Auth struct {
@@ -0,0 +1,210 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agents
+
+import (
+	"context"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+	"kubegems.io/kubegems/pkg/edge/tunnel"
+	"kubegems.io/kubegems/pkg/log"
+	"kubegems.io/kubegems/pkg/service/models"
+	"kubegems.io/kubegems/pkg/utils/system"
+)
+
+// 隧道模式
+// 集群 agent 主动连接至 service 的隧道服务，控制面通过隧道访问 agent，不需要 agent 对外暴露或者提供 kubeconfig。
+// - service 监听隧道端口，worker/msgbus 等组件作为隧道的下游连接至 service，通过 service 转发至 agent。
+// - agent 首次接入时使用一次性的 bootstrap token，同时绑定 agent 生成的凭证，之后的连接使用该凭证认证。
+// - token 以及凭证与集群名称绑定，不能用于其他集群；重新生成 bootstrap token 后已连接的 agent 会被断开。
+
+const (
+	AnnotationKeyAgentAddress     = "agent.kubegems.io/address"
+	AnnotationKeyKubernetesVerion = "agent.kubegems.io/kubernetes-version"
+
+	// 隧道模式下 agent 默认的地址，为 agent 所在集群内的地址
+	DefaultTunnelAgentAddr = "http://127.0.0.1:8041"
+	// 隧道模式集群的 apiserver 占位地址，集群的 apiserver 字段为唯一索引
+	TunnelAPIServerPrefix = "tunnel://"
+
+	// 检查已连接的 agent 凭证是否失效的间隔，用于断开连接至其他副本的 agent
+	tunnelRevokeCheckInterval = 30 * time.Second
+)
+
+type TunnelOptions struct {
+	Listen            string        `json:"listen,omitempty" description:"tunnel server listen address,empty to disable"`
+	Host              string        `json:"host,omitempty" description:"tunnel address advertised to agents"`
+	UpstreamAddr      string        `json:"upstreamAddr,omitempty" description:"connect to upstream tunnel server,used by components other than service"`
+	Token             string        `json:"token,omitempty" description:"token for internal components connecting to tunnel server"`
+	TLS               *system.TLS   `json:"tls,omitempty"`
+	BootstrapTokenTTL time.Duration `json:"bootstrapTokenTTL,omitempty" description:"ttl of agent bootstrap token"`
+}
+
+func NewDefaultTunnelOptions() *TunnelOptions {
+	return &TunnelOptions{
+		TLS:               system.NewDefaultTLS(),
+		BootstrapTokenTTL: 24 * time.Hour,
+	}
+}
+
+func (o *TunnelOptions) IsEnabled() bool {
+	return o != nil && (o.Listen != "" || o.UpstreamAddr != "")
+}
+
+// EnableTunnel 开启隧道，开启后隧道模式的集群通过隧道访问
+func (h *ClientSet) EnableTunnel(options *TunnelOptions, idprefix string) {
+	if !options.IsEnabled() {
+		return
+	}
+	h.tunnelOptions = options
+	h.tunnelAuth = &ClusterTunnelAuth{
+		DB:    h.database.DB(),
+		Token: options.Token,
+	}
+	h.tunnel = tunnel.NewTunnelServer(tunnel.RandomServerID(idprefix), h.tunnelAuth)
+}
+
+// RunTunnel 启动隧道服务或者连接至上游隧道
+func (h *ClientSet) RunTunnel(ctx context.Context) error {
+	if h.tunnel == nil {
+		return nil
+	}
+	server := tunnel.GrpcTunnelServer{TunnelServer: h.tunnel}
+	eg, ctx := errgroup.WithContext(ctx)
+	if listen := h.tunnelOptions.Listen; listen != "" {
+		tlsConfig, err := h.tunnelOptions.TLS.ToTLSConfig()
+		if err != nil {
+			return err
+		}
+		eg.Go(func() error {
+			return server.ServeGrpc(ctx, listen, tlsConfig)
+		})
+		eg.Go(func() error {
+			return h.syncTunnelClusters(ctx)
+		})
+		eg.Go(func() error {
+			return h.kickRevokedAgents(ctx)
+		})
+	}
+	if upstream := h.tunnelOptions.UpstreamAddr; upstream != "" {
+		eg.Go(func() error {
+			return server.ConnectUpstreamWithRetry(ctx, upstream, nil, h.tunnelOptions.Token, nil)
+		})
+	}
+	return eg.Wait()
+}
+
+// syncTunnelClusters 根据 agent 上报的信息更新集群
+func (h *ClientSet) syncTunnelClusters(ctx context.Context) error {
+	log := log.FromContextOrDiscard(ctx).WithName("tunnel")
+	watcher := h.tunnel.Wacth(ctx)
+	defer watcher.Close()
+	for event := range watcher.Result() {
+		if event.Kind == tunnel.EventKindDisConnected {
+			for name := range event.Peers {
+				h.tunnelAuth.forget(name)
+			}
+			continue
+		}
+		for name, annotations := range event.Peers {
+			if err := h.onTunnelPeer(ctx, name, annotations); err != nil {
+				log.Error(err, "update cluster from tunnel", "cluster", name)
+			}
+		}
+	}
+	return nil
+}
+
+// kickRevokedAgents 定期断开凭证已经失效的 agent，
+// bootstrap token 可能在其他副本上重新生成，此时本副本上的连接需要在这里断开
+func (h *ClientSet) kickRevokedAgents(ctx context.Context) error {
+	log := log.FromContextOrDiscard(ctx).WithName("tunnel")
+	ticker := time.NewTicker(tunnelRevokeCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+		revoked, err := h.tunnelAuth.Revoked(ctx)
+		if err != nil {
+			log.Error(err, "check revoked agents")
+			continue
+		}
+		for _, name := range revoked {
+			log.Info("disconnect revoked agent", "cluster", name)
+			h.tunnel.Revoke(name)
+		}
+	}
+}
+
+func (h *ClientSet) onTunnelPeer(ctx context.Context, name string, annotations tunnel.Annotations) error {
+	agentaddr := annotations[AnnotationKeyAgentAddress]
+	if agentaddr == "" {
+		// 非 agent 的下游，例如 worker
+		return nil
+	}
+	cluster := &models.Cluster{}
+	if err := h.database.DB().WithContext(ctx).First(cluster, "cluster_name = ?", name).Error; err != nil {
+		return err
+	}
+	if !cluster.IsTunnelMode() {
+		return nil
+	}
+	updates := map[string]interface{}{}
+	if cluster.AgentAddr != agentaddr {
+		updates["agent_addr"] = agentaddr
+	}
+	if version := annotations[AnnotationKeyKubernetesVerion]; version != "" && cluster.Version != version {
+		updates["version"] = version
+	}
+	if len(updates) == 0 {
+		return nil
+	}
+	if err := h.database.DB().WithContext(ctx).Model(cluster).Updates(updates).Error; err != nil {
+		return err
+	}
+	h.Invalidate(ctx, name)
+	return nil
+}
+
+// NewBootstrapToken 为隧道模式的集群生成新的接入 token，已绑定的 agent 凭证会失效，已连接的 agent 会被断开
+func (h *ClientSet) NewBootstrapToken(ctx context.Context, cluster *models.Cluster) (string, error) {
+	ttl := 24 * time.Hour
+	if h.tunnelOptions != nil && h.tunnelOptions.BootstrapTokenTTL > 0 {
+		ttl = h.tunnelOptions.BootstrapTokenTTL
+	}
+	token, err := resetBootstrapToken(ctx, h.database.DB(), cluster, ttl)
+	if err != nil {
+		return "", err
+	}
+	// 连接至其他副本的 agent 由 kickRevokedAgents 断开
+	if h.tunnel != nil {
+		h.tunnelAuth.forget(cluster.ClusterName)
+		h.tunnel.Revoke(cluster.ClusterName)
+	}
+	h.Invalidate(ctx, cluster.ClusterName)
+	return token, nil
+}
+
+// TunnelHost 返回 agent 需要连接的隧道地址
+func (h *ClientSet) TunnelHost() string {
+	if h.tunnelOptions == nil {
+		return ""
+	}
+	return h.tunnelOptions.Host
+}
@@ -0,0 +1,187 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agents
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"kubegems.io/kubegems/pkg/service/models"
+)
+
+// ClusterTunnelAuth 隧道连接的认证
+// 内部组件使用 TunnelOptions.Token 认证，且不能使用集群名称作为隧道 id。
+// agent 的隧道 id 为集群名称，token 格式为 {bootstrap token}.{agent key}，token 的哈希与集群名称绑定。
+type ClusterTunnelAuth struct {
+	DB    *gorm.DB
+	Token string
+
+	mu        sync.Mutex
+	connected map[string]string // 集群名称 -> 认证通过时的 agent 凭证哈希
+}
+
+func (a *ClusterTunnelAuth) Authentication(ctx context.Context, name string, token string) error {
+	if a.Token != "" && subtle.ConstantTimeCompare([]byte(a.Token), []byte(token)) == 1 {
+		// 内部组件不能冒充 agent
+		var count int64
+		if err := a.DB.WithContext(ctx).Model(&models.Cluster{}).Where("cluster_name = ?", name).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return fmt.Errorf("tunnel id %s is reserved for cluster", name)
+		}
+		return nil
+	}
+	bootstrap, agentkey, ok := strings.Cut(token, ".")
+	if !ok || agentkey == "" {
+		return errors.New("invalid tunnel token")
+	}
+	cluster := &models.Cluster{}
+	if err := a.DB.WithContext(ctx).First(cluster, "cluster_name = ?", name).Error; err != nil {
+		return fmt.Errorf("cluster %s: %w", name, err)
+	}
+	if !cluster.IsTunnelMode() {
+		return fmt.Errorf("cluster %s is not in tunnel mode", name)
+	}
+	agenthash := hashToken(name, agentkey)
+	// 已绑定凭证
+	if cluster.AgentTokenHash != "" {
+		if subtle.ConstantTimeCompare([]byte(cluster.AgentTokenHash), []byte(agenthash)) != 1 {
+			return errors.New("invalid agent key")
+		}
+		a.remember(name, agenthash)
+		return nil
+	}
+	// 首次接入
+	if cluster.BootstrapTokenHash == "" || !hashEqual(cluster.BootstrapTokenHash, name, bootstrap) {
+		return errors.New("invalid bootstrap token")
+	}
+	if cluster.BootstrapTokenExpireAt != nil && cluster.BootstrapTokenExpireAt.Before(time.Now()) {
+		return errors.New("bootstrap token expired")
+	}
+	// bootstrap token 仅可使用一次
+	result := a.DB.WithContext(ctx).Model(cluster).
+		Where("bootstrap_token_hash = ?", cluster.BootstrapTokenHash).
+		Updates(map[string]interface{}{
+			"agent_token_hash":          agenthash,
+			"bootstrap_token_hash":      "",
+			"bootstrap_token_expire_at": nil,
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errors.New("bootstrap token already used")
+	}
+	a.remember(name, agenthash)
+	return nil
+}
+
+func (a *ClusterTunnelAuth) remember(name, agenthash string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.connected == nil {
+		a.connected = map[string]string{}
+	}
+	a.connected[name] = agenthash
+}
+
+func (a *ClusterTunnelAuth) forget(name string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.connected, name)
+}
+
+// Revoked 返回通过本实例认证但是凭证已经失效的集群，例如重新生成了 bootstrap token 或者集群被删除
+func (a *ClusterTunnelAuth) Revoked(ctx context.Context) ([]string, error) {
+	a.mu.Lock()
+	connected := make(map[string]string, len(a.connected))
+	for name, agenthash := range a.connected {
+		connected[name] = agenthash
+	}
+	a.mu.Unlock()
+	if len(connected) == 0 {
+		return nil, nil
+	}
+
+	names := make([]string, 0, len(connected))
+	for name := range connected {
+		names = append(names, name)
+	}
+	clusters := []models.Cluster{}
+	if err := a.DB.WithContext(ctx).Where("cluster_name IN ?", names).Find(&clusters).Error; err != nil {
+		return nil, err
+	}
+	valid := map[string]bool{}
+	for _, cluster := range clusters {
+		if cluster.IsTunnelMode() && cluster.AgentTokenHash == connected[cluster.ClusterName] {
+			valid[cluster.ClusterName] = true
+		}
+	}
+	revoked := []string{}
+	for _, name := range names {
+		if !valid[name] {
+			revoked = append(revoked, name)
+			a.forget(name)
+		}
+	}
+	return revoked, nil
+}
+
+func resetBootstrapToken(ctx context.Context, db *gorm.DB, cluster *models.Cluster, ttl time.Duration) (string, error) {
+	token, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+	expireAt := time.Now().Add(ttl)
+	if err := db.WithContext(ctx).Model(cluster).Updates(map[string]interface{}{
+		"agent_mode":                models.ClusterAgentModeTunnel,
+		"bootstrap_token_hash":      hashToken(cluster.ClusterName, token),
+		"bootstrap_token_expire_at": expireAt,
+		"agent_token_hash":          "",
+	}).Error; err != nil {
+		return "", err
+	}
+	cluster.AgentMode = models.ClusterAgentModeTunnel
+	cluster.BootstrapTokenExpireAt = &expireAt
+	return token, nil
+}
+
+func randomToken() (string, error) {
+	data := make([]byte, 16)
+	if _, err := rand.Read(data); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(data), nil
+}
+
+// hashToken 计算与集群名称绑定的 token 哈希，相同的 token 用于其他集群时哈希不同
+func hashToken(cluster, token string) string {
+	sum := sha256.Sum256([]byte(cluster + "/" + token))
+	return hex.EncodeToString(sum[:])
+}
+
+func hashEqual(hash, cluster, token string) bool {
+	return subtle.ConstantTimeCompare([]byte(hash), []byte(hashToken(cluster, token))) == 1
+}
@@ -0,0 +1,112 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agents
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"kubegems.io/kubegems/pkg/service/models"
+)
+
+func TestClusterTunnelAuth(t *testing.T) {
+	ctx := context.Background()
+	db, err := gorm.Open(sqlite.Open(t.TempDir()+"/tunnel.db"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&models.Cluster{}); err != nil {
+		t.Fatal(err)
+	}
+	clusters := map[string]*models.Cluster{}
+	for _, name := range []string{"a", "b", "expired", "direct"} {
+		cluster := &models.Cluster{ClusterName: name, APIServer: TunnelAPIServerPrefix + name}
+		if err := db.Create(cluster).Error; err != nil {
+			t.Fatal(err)
+		}
+		clusters[name] = cluster
+	}
+	tokens := map[string]string{}
+	for _, name := range []string{"a", "b", "expired"} {
+		token, err := resetBootstrapToken(ctx, db, clusters[name], time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tokens[name] = token
+	}
+	if err := db.Model(clusters["expired"]).Update("bootstrap_token_expire_at", time.Now().Add(-time.Minute)).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	auth := &ClusterTunnelAuth{DB: db, Token: "internal"}
+	steps := []struct {
+		name    string
+		id      string
+		token   string
+		wantErr bool
+	}{
+		{name: "internal component", id: "worker-1", token: "internal"},
+		{name: "internal token as cluster", id: "a", token: "internal", wantErr: true},
+		{name: "unknown cluster", id: "unknown", token: tokens["a"] + ".key", wantErr: true},
+		{name: "not tunnel mode", id: "direct", token: tokens["a"] + ".key", wantErr: true},
+		{name: "token of other cluster", id: "b", token: tokens["a"] + ".key", wantErr: true},
+		{name: "without agent key", id: "a", token: tokens["a"], wantErr: true},
+		{name: "expired bootstrap token", id: "expired", token: tokens["expired"] + ".key", wantErr: true},
+		{name: "register", id: "a", token: tokens["a"] + ".key-a"},
+		{name: "bootstrap token used", id: "a", token: tokens["a"] + ".other", wantErr: true},
+		{name: "registered agent key", id: "a", token: "any.key-a"},
+		{name: "agent key of other cluster", id: "b", token: "any.key-a", wantErr: true},
+		{name: "register other cluster", id: "b", token: tokens["b"] + ".key-a"},
+	}
+	for _, step := range steps {
+		if err := auth.Authentication(ctx, step.id, step.token); (err != nil) != step.wantErr {
+			t.Fatalf("%s: Authentication() error = %v, wantErr %v", step.name, err, step.wantErr)
+		}
+	}
+
+	revoked, err := auth.Revoked(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(revoked) != 0 {
+		t.Fatalf("Revoked() = %v, want none", revoked)
+	}
+	// 重新生成 bootstrap token 以及删除集群后，已连接的 agent 需要断开
+	if _, err := resetBootstrapToken(ctx, db, clusters["a"], time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Delete(clusters["b"]).Error; err != nil {
+		t.Fatal(err)
+	}
+	revoked, err = auth.Revoked(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(revoked)
+	if strings.Join(revoked, ",") != "a,b" {
+		t.Fatalf("Revoked() = %v, want [a b]", revoked)
+	}
+	if revoked, _ := auth.Revoked(ctx); len(revoked) != 0 {
+		t.Fatalf("Revoked() twice = %v, want none", revoked)
+	}
+	if err := auth.Authentication(ctx, "a", "any.key-a"); err == nil {
+		t.Fatal("Authentication() with old agent key error = nil, want error")
+	}
+}
@@ -67,6 +67,7 @@ func NewTypedClient(options *ClientOptions, scheme *runtime.Scheme) *TypedClient
 			Transport: &http.Transport{
 				TLSClientConfig: options.TLS,
 				Proxy:           OptionAuthAsProxy(options),
+				DialContext:     options.DialContext,
 			},
 		},
 		RuntimeScheme: scheme,
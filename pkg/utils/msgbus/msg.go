@@ -27,6 +27,7 @@ const (
 	Message MessageType = "message"       // 消息
 	Changed MessageType = "objectChanged" // k8s 对象变动
 	Alert   MessageType = "alert"         // 告警消息
+	OnCall  MessageType = "oncall"        // 值班告警消息
 )
 
 type EventKind string
@@ -47,6 +48,7 @@ const (
 	Application  ResourceType = "application"
 	Cluster      ResourceType = "cluster"
 	User         ResourceType = "user"
+	Incident     ResourceType = "alertIncident"
 
 	TenantResourceQuota ResourceType = "tenant-resource-quota"
 )
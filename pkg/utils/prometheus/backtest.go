@@ -0,0 +1,184 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prometheus
+
+import (
+	"sort"
+	"time"
+
+	"github.com/prometheus/common/model"
+)
+
+// BacktestInterval 回测得到的一次告警
+type BacktestInterval struct {
+	Labels        map[string]string `json:"labels"`
+	Severity      string            `json:"severity"`
+	ActiveAt      time.Time         `json:"activeAt"`      // 进入pending的时间
+	StartsAt      time.Time         `json:"startsAt"`      // 进入firing的时间
+	EndsAt        *time.Time        `json:"endsAt"`        // 恢复时间，为空表示回测结束时仍在告警
+	FiringSeconds float64           `json:"firingSeconds"` // firing 持续时间
+}
+
+// SimulateAlerts 按照告警规则的 for 时长模拟 pending->firing->resolved 的状态变化
+// matrix 为告警表达式(包含阈值比较)在 [start, end] 以 step 为间隔的 range query 结果，
+// 某时刻存在样本即表示该时刻告警条件成立，与 prometheus 以 step 为评估间隔时的行为一致
+func SimulateAlerts(matrix model.Matrix, forDuration, step time.Duration, end time.Time) []BacktestInterval {
+	ret := []BacktestInterval{}
+	for _, series := range matrix {
+		labels := map[string]string{}
+		for k, v := range series.Metric {
+			if k == model.MetricNameLabel {
+				continue
+			}
+			labels[string(k)] = string(v)
+		}
+		values := series.Values
+		sort.Slice(values, func(i, j int) bool { return values[i].Timestamp.Before(values[j].Timestamp) })
+
+		var (
+			activeAt, lastSeen time.Time
+			firing             *BacktestInterval
+		)
+		resolve := func(at time.Time) {
+			if firing != nil {
+				firing.EndsAt = &at
+				firing.FiringSeconds = at.Sub(firing.StartsAt).Seconds()
+				ret = append(ret, *firing)
+				firing = nil
+			}
+			activeAt = time.Time{}
+		}
+		for _, v := range values {
+			t := v.Timestamp.Time()
+			// 中间有评估时刻条件不成立，告警恢复
+			if !activeAt.IsZero() && t.Sub(lastSeen) > step {
+				resolve(lastSeen.Add(step))
+			}
+			if activeAt.IsZero() {
+				activeAt = t
+			}
+			lastSeen = t
+			if firing == nil && t.Sub(activeAt) >= forDuration {
+				firing = &BacktestInterval{Labels: labels, ActiveAt: activeAt, StartsAt: t}
+			}
+		}
+		if activeAt.IsZero() {
+			continue
+		}
+		if end.Sub(lastSeen) >= step {
+			resolve(lastSeen.Add(step))
+		} else if firing != nil {
+			firing.FiringSeconds = end.Sub(firing.StartsAt).Seconds()
+			ret = append(ret, *firing)
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].StartsAt.Before(ret[j].StartsAt) })
+	return ret
+}
+
+// FiringDuration 返回 intervals 合并后的 firing 总时长，即至少有一个告警处于 firing 的时长
+func FiringDuration(intervals []BacktestInterval, end time.Time) time.Duration {
+	type span struct{ start, end time.Time }
+	spans := make([]span, 0, len(intervals))
+	for _, v := range intervals {
+		s := span{start: v.StartsAt, end: end}
+		if v.EndsAt != nil {
+			s.end = *v.EndsAt
+		}
+		spans = append(spans, s)
+	}
+	sort.Slice(spans, func(i, j int) bool { return spans[i].start.Before(spans[j].start) })
+
+	var total time.Duration
+	var cur *span
+	for i := range spans {
+		s := spans[i]
+		switch {
+		case cur == nil:
+			cur = &s
+		case !s.start.After(cur.end):
+			if s.end.After(cur.end) {
+				cur.end = s.end
+			}
+		default:
+			total += cur.end.Sub(cur.start)
+			cur = &s
+		}
+	}
+	if cur != nil {
+		total += cur.end.Sub(cur.start)
+	}
+	return total
+}
+
+// InhibitAlerts 模拟 alertmanager 的告警抑制，source 告警 firing 期间抑制 equal 标签值相同的 target 告警，
+// 被完全抑制的告警不再返回，被部分抑制的告警按未被抑制的时段拆分为多次告警
+func InhibitAlerts(targets, sources []BacktestInterval, equal []string, end time.Time) []BacktestInterval {
+	ret := []BacktestInterval{}
+	for _, target := range targets {
+		remains := []BacktestInterval{target}
+		for _, source := range sources {
+			if !labelsEqual(target.Labels, source.Labels, equal) {
+				continue
+			}
+			sourceEnd := end
+			if source.EndsAt != nil {
+				sourceEnd = *source.EndsAt
+			}
+			next := []BacktestInterval{}
+			for _, v := range remains {
+				next = append(next, subtractInterval(v, source.StartsAt, sourceEnd, end)...)
+			}
+			remains = next
+		}
+		ret = append(ret, remains...)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].StartsAt.Before(ret[j].StartsAt) })
+	return ret
+}
+
+func labelsEqual(a, b map[string]string, names []string) bool {
+	for _, name := range names {
+		if a[name] != b[name] {
+			return false
+		}
+	}
+	return true
+}
+
+// subtractInterval 去除告警在 [start, stop) 内 firing 的部分
+func subtractInterval(v BacktestInterval, start, stop, end time.Time) []BacktestInterval {
+	vend := end
+	if v.EndsAt != nil {
+		vend = *v.EndsAt
+	}
+	if !start.Before(vend) || !stop.After(v.StartsAt) {
+		return []BacktestInterval{v}
+	}
+	ret := []BacktestInterval{}
+	if start.After(v.StartsAt) {
+		before, endsAt := v, start
+		before.EndsAt = &endsAt
+		before.FiringSeconds = endsAt.Sub(before.StartsAt).Seconds()
+		ret = append(ret, before)
+	}
+	if stop.Before(vend) {
+		after := v
+		after.StartsAt = stop
+		after.FiringSeconds = vend.Sub(stop).Seconds()
+		ret = append(ret, after)
+	}
+	return ret
+}
@@ -0,0 +1,159 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prometheus
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+)
+
+func TestSimulateAlerts(t *testing.T) {
+	start := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	step := time.Minute
+	// 生成在第 minutes 分钟存在样本的序列
+	series := func(minutes ...int) *model.SampleStream {
+		s := &model.SampleStream{Metric: model.Metric{model.MetricNameLabel: "{}", "pod": "a"}}
+		for _, m := range minutes {
+			s.Values = append(s.Values, model.SamplePair{Timestamp: model.TimeFromUnixNano(start.Add(time.Duration(m) * step).UnixNano()), Value: 1})
+		}
+		return s
+	}
+	at := func(m int) time.Time { return start.Add(time.Duration(m) * step) }
+	end := at(60)
+
+	tests := []struct {
+		name     string
+		series   *model.SampleStream
+		for_     time.Duration
+		want     [][2]int // startsAt, endsAt(-1 表示仍在告警)
+		duration time.Duration
+	}{
+		{
+			name:   "pending only",
+			series: series(1, 2, 3, 10, 11),
+			for_:   5 * time.Minute,
+		},
+		{
+			name:     "firing and resolved",
+			series:   series(1, 2, 3, 4, 5, 6, 7, 20, 21),
+			for_:     3 * time.Minute,
+			want:     [][2]int{{4, 8}},
+			duration: 4 * time.Minute,
+		},
+		{
+			name:     "zero for",
+			series:   series(1, 2, 20),
+			want:     [][2]int{{1, 3}, {20, 21}},
+			duration: 3 * time.Minute,
+		},
+		{
+			name:     "firing at end",
+			series:   series(55, 56, 57, 58, 59, 60),
+			for_:     2 * time.Minute,
+			want:     [][2]int{{57, -1}},
+			duration: 3 * time.Minute,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := SimulateAlerts(model.Matrix{tt.series}, tt.for_, step, end)
+			if len(got) != len(tt.want) {
+				t.Fatalf("SimulateAlerts() = %v, want %v", got, tt.want)
+			}
+			for i, w := range tt.want {
+				if !got[i].StartsAt.Equal(at(w[0])) {
+					t.Errorf("startsAt = %v, want %v", got[i].StartsAt, at(w[0]))
+				}
+				if w[1] < 0 {
+					if got[i].EndsAt != nil {
+						t.Errorf("endsAt = %v, want nil", got[i].EndsAt)
+					}
+				} else if got[i].EndsAt == nil || !got[i].EndsAt.Equal(at(w[1])) {
+					t.Errorf("endsAt = %v, want %v", got[i].EndsAt, at(w[1]))
+				}
+				if _, ok := got[i].Labels[string(model.MetricNameLabel)]; ok {
+					t.Errorf("labels should not contain metric name: %v", got[i].Labels)
+				}
+			}
+			if d := FiringDuration(got, end); d != tt.duration {
+				t.Errorf("FiringDuration() = %v, want %v", d, tt.duration)
+			}
+		})
+	}
+}
+
+func TestFiringDuration(t *testing.T) {
+	start := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	at := func(m int) *time.Time {
+		t := start.Add(time.Duration(m) * time.Minute)
+		return &t
+	}
+	intervals := []BacktestInterval{
+		{StartsAt: *at(0), EndsAt: at(10)},
+		{StartsAt: *at(5), EndsAt: at(15)},
+		{StartsAt: *at(20), EndsAt: at(25)},
+		{StartsAt: *at(50)},
+	}
+	if d := FiringDuration(intervals, *at(60)); d != 30*time.Minute {
+		t.Errorf("FiringDuration() = %v, want %v", d, 30*time.Minute)
+	}
+}
+
+func TestInhibitAlerts(t *testing.T) {
+	start := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	at := func(m int) *time.Time {
+		t := start.Add(time.Duration(m) * time.Minute)
+		return &t
+	}
+	end := *at(60)
+	podA, podB := map[string]string{"pod": "a"}, map[string]string{"pod": "b"}
+	targets := []BacktestInterval{
+		{Labels: podA, StartsAt: *at(0), EndsAt: at(10)},                      // 部分被抑制
+		{Labels: podA, StartsAt: *at(20), EndsAt: at(25)},                     // 完全被抑制
+		{Labels: podB, StartsAt: *at(20), EndsAt: at(25), FiringSeconds: 300}, // 标签不同，不被抑制
+		{Labels: podA, StartsAt: *at(40)},                                     // 中间被抑制
+	}
+	sources := []BacktestInterval{
+		{Labels: podA, StartsAt: *at(5), EndsAt: at(30)},
+		{Labels: podA, StartsAt: *at(45), EndsAt: at(50)},
+	}
+	want := []struct {
+		pod        string
+		start, end int // end -1 表示仍在告警
+	}{
+		{"a", 0, 5},
+		{"b", 20, 25},
+		{"a", 40, 45},
+		{"a", 50, -1},
+	}
+	got := InhibitAlerts(targets, sources, []string{"pod"}, end)
+	if len(got) != len(want) {
+		t.Fatalf("InhibitAlerts() = %v, want %v", got, want)
+	}
+	for i, w := range want {
+		if got[i].Labels["pod"] != w.pod || !got[i].StartsAt.Equal(*at(w.start)) {
+			t.Errorf("alert %d = %v, want %v", i, got[i], w)
+		}
+		if w.end < 0 {
+			if got[i].EndsAt != nil || got[i].FiringSeconds != end.Sub(*at(w.start)).Seconds() {
+				t.Errorf("alert %d = %v, want firing at end", i, got[i])
+			}
+		} else if got[i].EndsAt == nil || !got[i].EndsAt.Equal(*at(w.end)) || got[i].FiringSeconds != float64((w.end-w.start)*60) {
+			t.Errorf("alert %d = %v, want %v", i, got[i], w)
+		}
+	}
+}
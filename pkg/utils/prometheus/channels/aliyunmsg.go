@@ -15,6 +15,7 @@
 package channels
 
 import (
+	"context"
 	"fmt"
 	"net/url"
 
@@ -62,7 +63,11 @@ func (m *AliyunMsg) Check() error {
 }
 
 func (m *AliyunMsg) Test(alert prometheus.WebhookAlert) error {
-	return testAlertproxy(m.formatURL(), alert)
+	return m.Notify(context.Background(), alert)
+}
+
+func (m *AliyunMsg) Notify(ctx context.Context, alert prometheus.WebhookAlert) error {
+	return notifyAlertproxy(ctx, m.formatURL(), alert)
 }
 
 func (m *AliyunMsg) String() string {
@@ -15,6 +15,7 @@
 package channels
 
 import (
+	"context"
 	"fmt"
 	"net/url"
 
@@ -60,7 +61,11 @@ func (v *AliyunVoice) Check() error {
 }
 
 func (v *AliyunVoice) Test(alert prometheus.WebhookAlert) error {
-	return testAlertproxy(v.formatURL(), alert)
+	return v.Notify(context.Background(), alert)
+}
+
+func (v *AliyunVoice) Notify(ctx context.Context, alert prometheus.WebhookAlert) error {
+	return notifyAlertproxy(ctx, v.formatURL(), alert)
 }
 
 func (v *AliyunVoice) String() string {
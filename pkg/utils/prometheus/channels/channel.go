@@ -15,20 +15,23 @@
 package channels
 
 import (
-	"bytes"
+	"context"
 	"database/sql/driver"
 	"encoding/json"
 	"fmt"
-	"io"
 	"net/http"
+	"net/url"
+	"strings"
 
 	"github.com/pkg/errors"
+	monv1 "github.com/prometheus-operator/prometheus-operator/pkg/apis/monitoring/v1"
 	"github.com/prometheus-operator/prometheus-operator/pkg/apis/monitoring/v1alpha1"
 	"gorm.io/gorm"
 	"gorm.io/gorm/schema"
 	"kubegems.io/kubegems/pkg/apis/gems"
 	"kubegems.io/kubegems/pkg/log"
 	"kubegems.io/kubegems/pkg/utils/prometheus"
+	"kubegems.io/kubegems/pkg/utils/slice"
 )
 
 type ChannelType string
@@ -40,6 +43,12 @@ const (
 	TypeDingding    ChannelType = "dingding"
 	TypeAliyunMsg   ChannelType = "aliyunMsg"
 	TypeAliyunVoice ChannelType = "aliyunVoice"
+	TypeSlack       ChannelType = "slack"
+	TypeWeCom       ChannelType = "wecom"
+	TypeMSTeams     ChannelType = "msteams"
+	TypeTelegram    ChannelType = "telegram"
+	TypePagerDuty   ChannelType = "pagerduty"
+	TypeOnCall      ChannelType = "oncall"
 )
 
 var (
@@ -51,9 +60,17 @@ type ChannelIf interface {
 	ToReceiver(name string) v1alpha1.Receiver
 	Check() error
 	Test(alert prometheus.WebhookAlert) error
+	// Notify 由 kubegems 直接发送告警，用于值班升级及 kubegems webhook
+	Notify(ctx context.Context, alert prometheus.WebhookAlert) error
 	String() string
 }
 
+// SecretChannel 需要将敏感信息保存到secret中的渠道
+type SecretChannel interface {
+	// SecretData 返回secret中的key及其值
+	SecretData(receiverName string) map[string]string
+}
+
 type BaseChannel struct {
 	ChannelType  ChannelType `json:"channelType"`
 	SendResolved bool        `json:"sendResolved"`
@@ -155,6 +172,42 @@ func (m *ChannelConfig) UnmarshalJSON(b []byte) error {
 			return errors.Wrap(err, "unmarshal aliyunVoice channel")
 		}
 		m.ChannelIf = &aliyunVoice
+	case TypeSlack:
+		slack := Slack{}
+		if err := json.Unmarshal(b, &slack); err != nil {
+			return errors.Wrap(err, "unmarshal slack channel")
+		}
+		m.ChannelIf = &slack
+	case TypeWeCom:
+		wecom := WeCom{}
+		if err := json.Unmarshal(b, &wecom); err != nil {
+			return errors.Wrap(err, "unmarshal wecom channel")
+		}
+		m.ChannelIf = &wecom
+	case TypeMSTeams:
+		msteams := MSTeams{}
+		if err := json.Unmarshal(b, &msteams); err != nil {
+			return errors.Wrap(err, "unmarshal msteams channel")
+		}
+		m.ChannelIf = &msteams
+	case TypeTelegram:
+		telegram := Telegram{}
+		if err := json.Unmarshal(b, &telegram); err != nil {
+			return errors.Wrap(err, "unmarshal telegram channel")
+		}
+		m.ChannelIf = &telegram
+	case TypePagerDuty:
+		pagerduty := PagerDuty{}
+		if err := json.Unmarshal(b, &pagerduty); err != nil {
+			return errors.Wrap(err, "unmarshal pagerduty channel")
+		}
+		m.ChannelIf = &pagerduty
+	case TypeOnCall:
+		oncall := OnCall{}
+		if err := json.Unmarshal(b, &oncall); err != nil {
+			return errors.Wrap(err, "unmarshal oncall channel")
+		}
+		m.ChannelIf = &oncall
 
 	default:
 		return fmt.Errorf("unknown channel type: %s", tmp.ChannelType)
@@ -180,17 +233,76 @@ func (ChannelConfig) GormDBDataType(db *gorm.DB, field *schema.Field) string {
 	return ""
 }
 
-// test for alertproxy
-func testAlertproxy(u string, alert prometheus.WebhookAlert) error {
-	buf := bytes.NewBuffer(nil)
-	if err := json.NewEncoder(buf).Encode(alert); err != nil {
+// kubegemsNotifyURL 返回由 kubegems 发送告警的 webhook 地址，q 中为不含敏感信息的渠道配置
+func kubegemsNotifyURL(q url.Values) string {
+	return KubegemsWebhookURL + "?" + q.Encode()
+}
+
+// kubegemsNotifyHTTPConfig agent 使用自签名证书
+func kubegemsNotifyHTTPConfig() *v1alpha1.HTTPConfig {
+	return &v1alpha1.HTTPConfig{
+		TLSConfig: &monv1.SafeTLSConfig{
+			InsecureSkipVerify: true,
+		},
+	}
+}
+
+// 由 kubegems 发送告警的渠道地址白名单，按 host 精确匹配，
+// 使用其他地址(如 teams 租户的 xxx.webhook.office.com)时需添加到对应的列表中
+var (
+	WeComHosts   = []string{"qyapi.weixin.qq.com"}
+	MSTeamsHosts = []string{"outlook.office.com", "outlook.office365.com"}
+)
+
+// checkWebhookHost 检查地址为 https 且 host 在白名单中
+func checkWebhookHost(rawurl string, hosts []string) error {
+	u, err := url.ParseRequestURI(rawurl)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "https" {
+		return fmt.Errorf("url must be https")
+	}
+	if u.User != nil {
+		return fmt.Errorf("url must not contain user info")
+	}
+	if !slice.ContainStr(hosts, u.Host) {
+		return fmt.Errorf("host %s is not allowed", u.Host)
+	}
+	return nil
+}
+
+// NotifyByWebhook 按 kubegems webhook 请求中的渠道配置发送告警，敏感信息来自 Authorization 头
+func NotifyByWebhook(r *http.Request, alert prometheus.WebhookAlert) error {
+	q := r.URL.Query()
+	var notifier ChannelIf
+	switch ChannelType(q.Get("type")) {
+	case TypeWeCom:
+		notifier = &WeCom{URL: q.Get("url"), MentionedList: q.Get("mentionedList")}
+	case TypeMSTeams:
+		notifier = &MSTeams{URL: q.Get("url")}
+	case TypeTelegram:
+		// 只发送到 telegram bot api，地址不从请求中获取
+		notifier = &Telegram{
+			BotToken: strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "),
+			ChatID:   q.Get("chatID"),
+		}
+	default:
+		return fmt.Errorf("channel type %s is not sent by kubegems", q.Get("type"))
+	}
+	// 企业微信和 teams 的地址需在白名单中，避免 webhook 被用于发送到任意地址
+	if err := notifier.Check(); err != nil {
 		return err
 	}
-	resp, err := http.Post(u, "application/json", buf)
+	return notifier.Notify(r.Context(), alert)
+}
+
+// notifyAlertproxy 由 alertproxy 按渠道模板发送告警
+func notifyAlertproxy(ctx context.Context, u string, alert prometheus.WebhookAlert) error {
+	bts, err := postJSON(ctx, u, alert)
 	if err != nil {
 		return err
 	}
-	bts, _ := io.ReadAll(resp.Body)
-	log.Info("test alertproxy success", "url", u, "resp", string(bts))
+	log.Info("notify alertproxy success", "url", u, "resp", string(bts))
 	return nil
 }
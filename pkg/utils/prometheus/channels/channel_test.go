@@ -0,0 +1,157 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package channels
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTelegram_ToReceiver(t *testing.T) {
+	tg := &Telegram{BotToken: "123456:secret", ChatID: "-1001"}
+	receiver := tg.ToReceiver("ops-id-1")
+	webhook := receiver.WebhookConfigs[0]
+	if strings.Contains(*webhook.URL, "secret") {
+		t.Errorf("bot token in webhook url: %s", *webhook.URL)
+	}
+	if !strings.HasPrefix(*webhook.URL, KubegemsWebhookURL+"?") {
+		t.Errorf("webhook url = %s, want sent to kubegems", *webhook.URL)
+	}
+	token := webhook.HTTPConfig.BearerTokenSecret
+	if token == nil || token.Name != EmailSecretName || token.Key != TelegramSecretKey("ops-id-1") {
+		t.Fatalf("bearer token secret = %v", token)
+	}
+	if data := tg.SecretData("ops-id-1"); data[token.Key] != tg.BotToken {
+		t.Errorf("secret data = %v", data)
+	}
+	if tg.String() != *webhook.URL {
+		t.Errorf("String() = %s, want the webhook url", tg.String())
+	}
+}
+
+func TestNotifyByWebhook(t *testing.T) {
+	var gotPath string
+	var gotBody map[string]interface{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		json.NewDecoder(r.Body).Decode(&gotBody)
+		w.Write([]byte(`{"ok":true}`))
+	}))
+	defer srv.Close()
+	defer func(u string) { telegramAPIURL = u }(telegramAPIURL)
+	telegramAPIURL = srv.URL
+
+	tg := &Telegram{BotToken: "123456:secret", ChatID: "-1001"}
+	u, _ := url.Parse(tg.formatURL())
+	// api url in query is ignored
+	q := u.Query()
+	q.Set("apiURL", "http://127.0.0.1:1")
+	u.RawQuery = q.Encode()
+	req := httptest.NewRequest(http.MethodPost, u.RequestURI(), nil)
+	req.Header.Set("Authorization", "Bearer "+tg.BotToken)
+	if err := NotifyByWebhook(req, testWebhookAlert("critical")); err != nil {
+		t.Fatal(err)
+	}
+	if gotPath != "/bot123456:secret/sendMessage" || gotBody["chat_id"] != "-1001" {
+		t.Errorf("telegram request path %s, body %v", gotPath, gotBody)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/alert?type=wecom&url=http://127.0.0.1/send", nil)
+	if err := NotifyByWebhook(req, testWebhookAlert("critical")); err == nil {
+		t.Error("NotifyByWebhook() to invalid wecom url: want error")
+	}
+	req = httptest.NewRequest(http.MethodPost, "/alert?type=webhook", nil)
+	if err := NotifyByWebhook(req, testWebhookAlert("critical")); err == nil {
+		t.Error("NotifyByWebhook() of webhook channel: want error")
+	}
+}
+
+func TestPagerDuty_Notify(t *testing.T) {
+	var events []map[string]interface{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		event := map[string]interface{}{}
+		json.NewDecoder(r.Body).Decode(&event)
+		events = append(events, event)
+		w.Write([]byte(`{"status":"success"}`))
+	}))
+	defer srv.Close()
+
+	alert := testWebhookAlert("critical")
+	alert.Alerts[0].Fingerprint = "fp-firing"
+	resolved := alert.Alerts[0]
+	resolved.Status, resolved.Fingerprint = "resolved", "fp-resolved"
+	alert.Alerts = append(alert.Alerts, resolved)
+
+	pd := &PagerDuty{RoutingKey: strings.Repeat("a", 32), URL: srv.URL}
+	if err := pd.Notify(context.Background(), alert); err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 {
+		t.Fatalf("events = %v, want 2", events)
+	}
+	for i, want := range []struct{ action, dedupKey string }{{"trigger", "fp-firing"}, {"resolve", "fp-resolved"}} {
+		if events[i]["event_action"] != want.action || events[i]["dedup_key"] != want.dedupKey {
+			t.Errorf("event %d: action = %v dedup_key = %v, want %s %s", i, events[i]["event_action"], events[i]["dedup_key"], want.action, want.dedupKey)
+		}
+	}
+}
+
+func TestPostJSON(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	u := srv.URL + "/bot123456:secret/sendMessage"
+	srv.Close()
+	_, err := postJSON(context.Background(), u, map[string]string{})
+	if err == nil {
+		t.Fatal("postJSON() to closed server: want error")
+	}
+	if strings.Contains(err.Error(), "secret") {
+		t.Errorf("url in error: %v", err)
+	}
+}
+
+func TestEmail_NotifyCanceled(t *testing.T) {
+	// smtp server never responds
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	e := &Email{SMTPServer: ln.Addr().String(), From: "alert@example.com", To: "ops@example.com"}
+	start := time.Now()
+	if err := e.Notify(ctx, testWebhookAlert("critical")); err == nil {
+		t.Fatal("Email.Notify() to unresponsive server: want error")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Email.Notify() returned after %s, want canceled with context", elapsed)
+	}
+}
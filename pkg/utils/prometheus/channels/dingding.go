@@ -15,6 +15,7 @@
 package channels
 
 import (
+	"context"
 	"fmt"
 	"net/url"
 	"strings"
@@ -61,7 +62,11 @@ func (f *Dingding) Check() error {
 }
 
 func (f *Dingding) Test(alert prometheus.WebhookAlert) error {
-	return testAlertproxy(f.formatURL(), alert)
+	return f.Notify(context.Background(), alert)
+}
+
+func (f *Dingding) Notify(ctx context.Context, alert prometheus.WebhookAlert) error {
+	return notifyAlertproxy(ctx, f.formatURL(), alert)
 }
 
 func (f *Dingding) String() string {
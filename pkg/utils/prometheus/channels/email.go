@@ -16,7 +16,11 @@ package channels
 
 import (
 	"bytes"
-	"encoding/json"
+	"context"
+	"errors"
+	"io"
+	"mime"
+	"net"
 	"strings"
 
 	"github.com/emersion/go-sasl"
@@ -36,6 +40,7 @@ type Email struct {
 	AuthPassword string `json:"authPassword" binding:"required"`
 }
 
+// 邮件密码、slack url、pagerduty key 等敏感信息均保存在此secret中
 var (
 	EmailSecretName       = "gemscloud-email-password"
 	EmailSecretLabelKey   = "gemcloud"
@@ -76,23 +81,89 @@ func (e *Email) ToReceiver(name string) v1alpha1.Receiver {
 	}
 }
 
+func (e *Email) SecretData(receiverName string) map[string]string {
+	return map[string]string{EmailSecretKey(receiverName, e.From): e.AuthPassword}
+}
+
 func (e *Email) Check() error {
 	return nil
 }
 
 func (e *Email) Test(alert prometheus.WebhookAlert) error {
+	return e.Notify(context.Background(), alert)
+}
+
+func (e *Email) Notify(ctx context.Context, alert prometheus.WebhookAlert) error {
+	msg := NewAlertMessages(alert)
 	auth := sasl.NewPlainClient("", e.From, e.AuthPassword)
 	receivers := strings.Split(e.To, ",")
-	buf := bytes.NewBufferString("To: " + e.To + "\r\n" +
-		"Subject: Kubegems test email" + "\r\n" +
+	buf := bytes.NewBufferString("From: " + e.From + "\r\n" +
+		"To: " + e.To + "\r\n" +
+		"Subject: " + mime.QEncoding.Encode("utf-8", "Kubegems alert "+msg.Title) + "\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: text/plain; charset=UTF-8\r\n" +
 		"\r\n")
+	buf.WriteString(strings.ReplaceAll(msg.Render(emailTemplate), "\n", "\r\n"))
+	return sendMail(ctx, e.SMTPServer, auth, e.From, receivers, buf)
+}
 
-	encoder := json.NewEncoder(buf)
-	encoder.SetIndent("", "    ")
-	if err := encoder.Encode(alert); err != nil {
+// sendMail 同 smtp.SendMail，ctx 结束时关闭连接以中断发送
+func sendMail(ctx context.Context, addr string, auth sasl.Client, from string, to []string, r io.Reader) error {
+	for _, line := range append([]string{from}, to...) {
+		if strings.ContainsAny(line, "\r\n") {
+			return errors.New("smtp: A line must not contain CR or LF")
+		}
+	}
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-stop:
+		}
+	}()
+	host, _, _ := net.SplitHostPort(addr)
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(nil); err != nil {
+			return err
+		}
+	}
+	if ok, _ := c.Extension("AUTH"); !ok {
+		return errors.New("smtp: server doesn't support AUTH")
+	}
+	if err := c.Auth(auth); err != nil {
+		return err
+	}
+	if err := c.Mail(from, nil); err != nil {
+		return err
+	}
+	for _, rcpt := range to {
+		if err := c.Rcpt(rcpt); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, r); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
 		return err
 	}
-	return smtp.SendMail(e.SMTPServer, auth, e.From, receivers, buf)
+	return c.Quit()
 }
 
 func (e *Email) String() string {
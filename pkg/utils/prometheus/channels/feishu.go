@@ -15,6 +15,7 @@
 package channels
 
 import (
+	"context"
 	"fmt"
 	"net/url"
 	"strings"
@@ -61,7 +62,11 @@ func (f *Feishu) Check() error {
 }
 
 func (f *Feishu) Test(alert prometheus.WebhookAlert) error {
-	return testAlertproxy(f.formatURL(), alert)
+	return f.Notify(context.Background(), alert)
+}
+
+func (f *Feishu) Notify(ctx context.Context, alert prometheus.WebhookAlert) error {
+	return notifyAlertproxy(ctx, f.formatURL(), alert)
 }
 
 func (f *Feishu) String() string {
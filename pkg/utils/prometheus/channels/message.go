@@ -0,0 +1,188 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package channels
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"text/template"
+	"time"
+
+	"kubegems.io/kubegems/pkg/log"
+	"kubegems.io/kubegems/pkg/utils/prometheus"
+)
+
+// alertmanager 中使用的消息模板，与 AlertMessage 的字段保持一致
+const (
+	amTitleTemplate = `[{{ .Status | toUpper }}{{ if eq .Status "firing" }}:{{ .Alerts.Firing | len }}{{ end }}] {{ .CommonLabels.gems_alertname }}`
+	amTextTemplate  = `{{ range .Alerts }}[{{ .Status }}] {{ .Annotations.message }}
+cluster: {{ .Labels.cluster }} namespace: {{ .Labels.gems_namespace }} severity: {{ .Labels.severity }} value: {{ .Annotations.value }}
+startsAt: {{ .StartsAt.Format "2006-01-02 15:04:05" }}
+{{ end }}`
+)
+
+// AlertMessage 单条告警的渲染内容
+type AlertMessage struct {
+	AlertName string
+	Status    string
+	Severity  string
+	Cluster   string
+	Namespace string
+	Message   string
+	Value     string
+	StartsAt  *time.Time
+	EndsAt    *time.Time
+}
+
+// AlertMessages 一组告警的渲染内容
+type AlertMessages struct {
+	Title    string
+	Status   string
+	Severity string
+	Messages []AlertMessage
+}
+
+func NewAlertMessages(alert prometheus.WebhookAlert) AlertMessages {
+	ret := AlertMessages{Status: alert.Status}
+	firing := 0
+	for _, v := range alert.Alerts {
+		msg := AlertMessage{
+			AlertName: v.Labels[prometheus.AlertNameLabel],
+			Status:    v.Status,
+			Severity:  v.Labels[prometheus.SeverityLabel],
+			Cluster:   v.Labels[prometheus.AlertClusterKey],
+			Namespace: v.Labels[prometheus.AlertNamespaceLabel],
+			Message:   v.Annotations[prometheus.MessageAnnotationsKey],
+			Value:     v.Annotations[prometheus.ValueAnnotationKey],
+			StartsAt:  v.StartsAt,
+			EndsAt:    v.EndsAt,
+		}
+		if msg.Status == "firing" {
+			firing++
+		}
+		// 取最高的告警级别
+		if ret.Severity != prometheus.SeverityCritical && msg.Severity != "" {
+			ret.Severity = msg.Severity
+		}
+		ret.Messages = append(ret.Messages, msg)
+	}
+	alertname := alert.CommonLabels[prometheus.AlertNameLabel]
+	if alertname == "" && len(ret.Messages) > 0 {
+		alertname = ret.Messages[0].AlertName
+	}
+	if ret.Status == "firing" {
+		ret.Title = fmt.Sprintf("[FIRING:%d] %s", firing, alertname)
+	} else {
+		ret.Title = fmt.Sprintf("[%s] %s", strings.ToUpper(ret.Status), alertname)
+	}
+	return ret
+}
+
+var messageFuncs = template.FuncMap{
+	"formatTime": func(t *time.Time) string {
+		if t == nil || t.IsZero() {
+			return "-"
+		}
+		return t.Format("2006-01-02 15:04:05")
+	},
+}
+
+// 各渠道的消息模板
+var (
+	markdownTemplate = template.Must(template.New("markdown").Funcs(messageFuncs).Parse(
+		`{{ range .Messages }}**[{{ .Status }}] {{ .AlertName }}**
+> {{ .Message }}
+> cluster: {{ .Cluster }} namespace: {{ .Namespace }} severity: {{ .Severity }} value: {{ .Value }}
+> startsAt: {{ formatTime .StartsAt }}
+{{ end }}`))
+	slackTemplate = template.Must(template.New("slack").Funcs(messageFuncs).Parse(
+		`{{ range .Messages }}*[{{ .Status }}] {{ .AlertName }}*
+> {{ .Message }}
+> cluster: ` + "`{{ .Cluster }}`" + ` namespace: ` + "`{{ .Namespace }}`" + ` severity: ` + "`{{ .Severity }}`" + ` value: ` + "`{{ .Value }}`" + `
+> startsAt: {{ formatTime .StartsAt }}
+{{ end }}`))
+	emailTemplate = template.Must(template.New("email").Funcs(messageFuncs).Parse(
+		`{{ range .Messages }}[{{ .Status }}] {{ .AlertName }}
+{{ .Message }}
+cluster: {{ .Cluster }} namespace: {{ .Namespace }} severity: {{ .Severity }} value: {{ .Value }}
+startsAt: {{ formatTime .StartsAt }}
+
+{{ end }}`))
+	htmlTemplate = template.Must(template.New("html").Funcs(messageFuncs).Parse(
+		`<b>{{ html .Title }}</b>
+{{ range .Messages }}
+<b>[{{ html .Status }}] {{ html .AlertName }}</b>
+{{ html .Message }}
+cluster: <code>{{ html .Cluster }}</code> namespace: <code>{{ html .Namespace }}</code> severity: <code>{{ html .Severity }}</code> value: <code>{{ html .Value }}</code>
+startsAt: {{ formatTime .StartsAt }}
+{{ end }}`))
+)
+
+func (m AlertMessages) Render(tpl *template.Template) string {
+	buf := bytes.NewBuffer(nil)
+	if err := tpl.Execute(buf, m); err != nil {
+		log.Error(err, "render alert message", "template", tpl.Name())
+	}
+	return buf.String()
+}
+
+// Color 按告警状态及级别返回颜色
+func (m AlertMessages) Color() string {
+	switch {
+	case m.Status == "resolved":
+		return "#2EB886"
+	case m.Severity == prometheus.SeverityCritical:
+		return "#D00000"
+	default:
+		return "#FF9900"
+	}
+}
+
+var notifyClient = &http.Client{Timeout: 10 * time.Second}
+
+// postJSON 发送json请求，非2xx状态码返回错误，
+// url 中可能含有 token(如 telegram)，返回的错误中不包含 url
+func postJSON(ctx context.Context, u string, body interface{}) ([]byte, error) {
+	buf := bytes.NewBuffer(nil)
+	if err := json.NewEncoder(buf).Encode(body); err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, buf)
+	if err != nil {
+		// 解析失败的错误中也含有 url
+		return nil, errors.New("invalid request url")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := notifyClient.Do(req)
+	if err != nil {
+		if uerr, ok := err.(*url.Error); ok {
+			return nil, fmt.Errorf("%s request: %w", uerr.Op, uerr.Err)
+		}
+		return nil, err
+	}
+	defer resp.Body.Close()
+	bts, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		return bts, fmt.Errorf("%s: %s", resp.Status, string(bts))
+	}
+	return bts, nil
+}
@@ -0,0 +1,105 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package channels
+
+import (
+	"strings"
+	"testing"
+	"text/template"
+	"time"
+
+	"kubegems.io/kubegems/pkg/utils/prometheus"
+)
+
+func testWebhookAlert(severity string) prometheus.WebhookAlert {
+	now := time.Date(2022, 1, 1, 8, 0, 0, 0, time.UTC)
+	return prometheus.WebhookAlert{
+		Status: "firing",
+		Alerts: []prometheus.Alert{
+			{
+				Status: "firing",
+				Labels: map[string]string{
+					prometheus.AlertNameLabel:      "cpu-usage",
+					prometheus.SeverityLabel:       severity,
+					prometheus.AlertClusterKey:     "kubegems",
+					prometheus.AlertNamespaceLabel: "default",
+				},
+				Annotations: map[string]string{
+					prometheus.MessageAnnotationsKey: "cpu usage > 80%",
+					prometheus.ValueAnnotationKey:    "85.1",
+				},
+				StartsAt: &now,
+			},
+		},
+	}
+}
+
+func TestNewAlertMessages(t *testing.T) {
+	msg := NewAlertMessages(testWebhookAlert(prometheus.SeverityCritical))
+	if msg.Title != "[FIRING:1] cpu-usage" {
+		t.Errorf("title = %s", msg.Title)
+	}
+	if msg.Color() != "#D00000" {
+		t.Errorf("color = %s", msg.Color())
+	}
+	for _, tpl := range []*template.Template{markdownTemplate, slackTemplate, emailTemplate, htmlTemplate} {
+		content := msg.Render(tpl)
+		for _, want := range []string{"cpu-usage", "cpu usage &gt; 80%", "kubegems", "default", "85.1", "2022-01-01 08:00:00"} {
+			if tpl != htmlTemplate {
+				want = strings.ReplaceAll(want, "&gt;", ">")
+			}
+			if !strings.Contains(content, want) {
+				t.Errorf("%s template: %q not found in %q", tpl.Name(), want, content)
+			}
+		}
+	}
+
+	resolved := testWebhookAlert(prometheus.SeverityError)
+	resolved.Status = "resolved"
+	msg = NewAlertMessages(resolved)
+	if msg.Title != "[RESOLVED] cpu-usage" || msg.Color() != "#2EB886" {
+		t.Errorf("title = %s color = %s", msg.Title, msg.Color())
+	}
+}
+
+func TestChannelCheck(t *testing.T) {
+	tests := []struct {
+		ch      ChannelIf
+		wantErr bool
+	}{
+		{ch: &Slack{URL: "https://hooks.slack.com/services/T000/B000/XXX"}},
+		{ch: &Slack{URL: "hooks.slack.com"}, wantErr: true},
+		{ch: &WeCom{URL: "https://qyapi.weixin.qq.com/cgi-bin/webhook/send?key=xxx"}},
+		{ch: &WeCom{URL: "https://example.com"}, wantErr: true},
+		{ch: &WeCom{URL: "https://example.com/?qyapi.weixin.qq.com"}, wantErr: true},
+		{ch: &WeCom{URL: "https://qyapi.weixin.qq.com.example.com/cgi-bin/webhook/send"}, wantErr: true},
+		{ch: &WeCom{URL: "http://qyapi.weixin.qq.com/cgi-bin/webhook/send"}, wantErr: true},
+		{ch: &MSTeams{URL: "https://outlook.office.com/webhook/xxx"}},
+		{ch: &MSTeams{URL: "http://outlook.office.com/webhook/xxx"}, wantErr: true},
+		{ch: &MSTeams{URL: "https://user@outlook.office.com/webhook/xxx"}, wantErr: true},
+		{ch: &MSTeams{URL: "https://127.0.0.1/webhook/xxx"}, wantErr: true},
+		{ch: &Telegram{BotToken: "123456:ABC-DEF", ChatID: "-1001234567"}},
+		{ch: &Telegram{BotToken: "123456:ABC-DEF", ChatID: "@kubegems"}},
+		{ch: &Telegram{BotToken: "123456", ChatID: "-1001234567"}, wantErr: true},
+		{ch: &Telegram{BotToken: "123456:ABC-DEF", ChatID: "kubegems"}, wantErr: true},
+		{ch: &PagerDuty{RoutingKey: strings.Repeat("a", 32)}},
+		{ch: &PagerDuty{RoutingKey: "abc"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		if err := tt.ch.Check(); (err != nil) != tt.wantErr {
+			t.Errorf("%T.Check() error = %v, wantErr %v", tt.ch, err, tt.wantErr)
+		}
+	}
+}
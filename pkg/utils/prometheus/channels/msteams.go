@@ -0,0 +1,81 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package channels
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+
+	"github.com/prometheus-operator/prometheus-operator/pkg/apis/monitoring/v1alpha1"
+	"kubegems.io/kubegems/pkg/utils"
+	"kubegems.io/kubegems/pkg/utils/prometheus"
+)
+
+// MSTeams Microsoft Teams incoming webhook，告警由 kubegems 发送
+type MSTeams struct {
+	BaseChannel `json:",inline"`
+	URL         string `json:"url" binding:"required"` // teams incoming webhook url
+}
+
+func (t *MSTeams) formatURL() string {
+	q := url.Values{}
+	q.Add("type", string(TypeMSTeams))
+	q.Add("url", t.URL)
+	return kubegemsNotifyURL(q)
+}
+
+func (t *MSTeams) ToReceiver(name string) v1alpha1.Receiver {
+	u := t.formatURL()
+	return v1alpha1.Receiver{
+		Name: name,
+		WebhookConfigs: []v1alpha1.WebhookConfig{
+			{
+				URL:          &u,
+				SendResolved: utils.BoolPointer(t.SendResolved),
+				HTTPConfig:   kubegemsNotifyHTTPConfig(),
+			},
+		},
+	}
+}
+
+func (t *MSTeams) Check() error {
+	if err := checkWebhookHost(t.URL, MSTeamsHosts); err != nil {
+		return fmt.Errorf("teams webhook url not valid: %w", err)
+	}
+	return nil
+}
+
+func (t *MSTeams) Test(alert prometheus.WebhookAlert) error {
+	return t.Notify(context.Background(), alert)
+}
+
+func (t *MSTeams) Notify(ctx context.Context, alert prometheus.WebhookAlert) error {
+	msg := NewAlertMessages(alert)
+	// https://learn.microsoft.com/en-us/outlook/actionable-messages/message-card-reference
+	_, err := postJSON(ctx, t.URL, map[string]interface{}{
+		"@type":      "MessageCard",
+		"@context":   "https://schema.org/extensions",
+		"themeColor": msg.Color()[1:],
+		"summary":    msg.Title,
+		"title":      msg.Title,
+		"text":       msg.Render(markdownTemplate),
+	})
+	return err
+}
+
+func (t *MSTeams) String() string {
+	return t.formatURL()
+}
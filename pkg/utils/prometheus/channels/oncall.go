@@ -0,0 +1,77 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package channels
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pkg/errors"
+	monv1 "github.com/prometheus-operator/prometheus-operator/pkg/apis/monitoring/v1"
+	"github.com/prometheus-operator/prometheus-operator/pkg/apis/monitoring/v1alpha1"
+	"kubegems.io/kubegems/pkg/utils"
+	"kubegems.io/kubegems/pkg/utils/prometheus"
+)
+
+// OnCall 值班通知，按值班表或升级策略通知值班人员，二者只能选一个
+// 告警发送到 kubegems webhook，由 msgbus 按值班表及升级策略通知
+type OnCall struct {
+	BaseChannel        `json:",inline"`
+	ScheduleID         uint `json:"scheduleID"`         // 值班表
+	EscalationPolicyID uint `json:"escalationPolicyID"` // 升级策略
+}
+
+func (o *OnCall) url() string {
+	return fmt.Sprintf("%s?type=%s&schedule=%d&escalation=%d", KubegemsWebhookURL, TypeOnCall, o.ScheduleID, o.EscalationPolicyID)
+}
+
+func (o *OnCall) ToReceiver(name string) v1alpha1.Receiver {
+	u := o.url()
+	return v1alpha1.Receiver{
+		Name: name,
+		WebhookConfigs: []v1alpha1.WebhookConfig{
+			{
+				URL: &u,
+				// 需要恢复消息以停止升级
+				SendResolved: utils.BoolPointer(true),
+				HTTPConfig: &v1alpha1.HTTPConfig{
+					TLSConfig: &monv1.SafeTLSConfig{
+						InsecureSkipVerify: true,
+					},
+				},
+			},
+		},
+	}
+}
+
+func (o *OnCall) Check() error {
+	if (o.ScheduleID == 0) == (o.EscalationPolicyID == 0) {
+		return errors.New("值班表和升级策略必须且只能选一个")
+	}
+	return nil
+}
+
+func (o *OnCall) Test(alert prometheus.WebhookAlert) error {
+	return errors.New("值班通知只能由告警触发")
+}
+
+// Notify 值班通知由 msgbus 按值班表及升级策略发送，不能直接通知
+func (o *OnCall) Notify(ctx context.Context, alert prometheus.WebhookAlert) error {
+	return errors.New("值班通知只能由告警触发")
+}
+
+func (o *OnCall) String() string {
+	return o.url()
+}
@@ -0,0 +1,147 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package channels
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/prometheus-operator/prometheus-operator/pkg/apis/monitoring/v1alpha1"
+	v1 "k8s.io/api/core/v1"
+	"kubegems.io/kubegems/pkg/utils"
+	"kubegems.io/kubegems/pkg/utils/prometheus"
+)
+
+const defaultPagerDutyURL = "https://events.pagerduty.com/v2/enqueue"
+
+// PagerDuty events api v2
+type PagerDuty struct {
+	BaseChannel `json:",inline"`
+	RoutingKey  string `json:"routingKey" binding:"required"` // integration key
+	URL         string `json:"url"`                           // events api地址，默认为 https://events.pagerduty.com/v2/enqueue
+}
+
+func PagerDutySecretKey(receverName string) string {
+	return receverName + "-pagerduty-routing-key"
+}
+
+func (p *PagerDuty) ToReceiver(name string) v1alpha1.Receiver {
+	return v1alpha1.Receiver{
+		Name: name,
+		PagerDutyConfigs: []v1alpha1.PagerDutyConfig{
+			{
+				RoutingKey: &v1.SecretKeySelector{
+					LocalObjectReference: v1.LocalObjectReference{
+						Name: EmailSecretName,
+					},
+					Key: PagerDutySecretKey(name),
+				},
+				URL:         p.URL,
+				Severity:    `{{ if eq .CommonLabels.severity "critical" }}critical{{ else }}error{{ end }}`,
+				Description: amTitleTemplate,
+				Class:       `{{ .CommonLabels.gems_alertname }}`,
+				Component:   `{{ .CommonLabels.gems_namespace }}`,
+				Group:       `{{ .CommonLabels.cluster }}`,
+				Details: []v1alpha1.KeyValue{
+					{Key: "firing", Value: `{{ .Alerts.Firing | len }}`},
+					{Key: "message", Value: `{{ .CommonAnnotations.message }}`},
+				},
+				SendResolved: utils.BoolPointer(p.SendResolved),
+			},
+		},
+	}
+}
+
+func (p *PagerDuty) SecretData(receiverName string) map[string]string {
+	return map[string]string{PagerDutySecretKey(receiverName): p.RoutingKey}
+}
+
+func (p *PagerDuty) Check() error {
+	// integration key 为32位
+	if len(p.RoutingKey) != 32 {
+		return fmt.Errorf("pagerduty routing key not valid")
+	}
+	return nil
+}
+
+func (p *PagerDuty) Test(alert prometheus.WebhookAlert) error {
+	return p.Notify(context.Background(), alert)
+}
+
+// Notify 每条告警发送一个事件，以告警 fingerprint 作为 dedup_key，告警恢复时 resolve 对应的事件
+func (p *PagerDuty) Notify(ctx context.Context, alert prometheus.WebhookAlert) error {
+	msg := NewAlertMessages(alert)
+	u := p.URL
+	if u == "" {
+		u = defaultPagerDutyURL
+	}
+	for i, v := range msg.Messages {
+		if err := p.sendEvent(ctx, u, alert.Alerts[i].Fingerprint, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *PagerDuty) sendEvent(ctx context.Context, u, dedupKey string, msg AlertMessage) error {
+	action, severity := "trigger", "error"
+	if msg.Status == "resolved" {
+		action = "resolve"
+	}
+	if msg.Severity == prometheus.SeverityCritical {
+		severity = "critical"
+	}
+	event := map[string]interface{}{
+		"routing_key":  p.RoutingKey,
+		"event_action": action,
+		"payload": map[string]interface{}{
+			"summary":   fmt.Sprintf("[%s] %s: %s", strings.ToUpper(msg.Status), msg.AlertName, msg.Message),
+			"source":    "kubegems",
+			"severity":  severity,
+			"timestamp": time.Now().Format(time.RFC3339),
+			"component": msg.Namespace,
+			"group":     msg.Cluster,
+			"class":     msg.AlertName,
+			"custom_details": map[string]string{
+				"cluster":   msg.Cluster,
+				"namespace": msg.Namespace,
+				"value":     msg.Value,
+			},
+		},
+	}
+	if dedupKey != "" {
+		event["dedup_key"] = dedupKey
+	}
+	bts, err := postJSON(ctx, u, event)
+	if err != nil {
+		return err
+	}
+	resp := struct {
+		Status  string `json:"status"`
+		Message string `json:"message"`
+	}{}
+	if err := json.Unmarshal(bts, &resp); err == nil && resp.Status != "" && resp.Status != "success" {
+		return fmt.Errorf("pagerduty: %s", resp.Message)
+	}
+	return nil
+}
+
+// String 不包含routing key，routing key保存在secret中，无法和 PagerDutyConfig 比对
+func (p *PagerDuty) String() string {
+	return p.URL
+}
@@ -0,0 +1,110 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package channels
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+
+	"github.com/prometheus-operator/prometheus-operator/pkg/apis/monitoring/v1alpha1"
+	v1 "k8s.io/api/core/v1"
+	"kubegems.io/kubegems/pkg/utils"
+	"kubegems.io/kubegems/pkg/utils/prometheus"
+)
+
+// Slack incoming webhook
+type Slack struct {
+	BaseChannel `json:",inline"`
+	URL         string `json:"url" binding:"required"` // slack incoming webhook url
+	Channel     string `json:"channel"`                // 覆盖webhook默认的channel，eg. #alerts
+	Username    string `json:"username"`               // 发送者名称
+}
+
+// SlackSecretKey 包含 url 的摘要，url 修改后 SlackConfig 中的 key 随之变化
+func SlackSecretKey(receverName, url string) string {
+	sum := sha256.Sum256([]byte(url))
+	return receverName + "-slack-url-" + hex.EncodeToString(sum[:8])
+}
+
+func (s *Slack) ToReceiver(name string) v1alpha1.Receiver {
+	return v1alpha1.Receiver{
+		Name: name,
+		SlackConfigs: []v1alpha1.SlackConfig{
+			{
+				APIURL: &v1.SecretKeySelector{
+					LocalObjectReference: v1.LocalObjectReference{
+						Name: EmailSecretName,
+					},
+					Key: SlackSecretKey(name, s.URL),
+				},
+				Channel:      s.Channel,
+				Username:     s.Username,
+				Color:        `{{ if eq .Status "firing" }}{{ if eq .CommonLabels.severity "critical" }}#D00000{{ else }}#FF9900{{ end }}{{ else }}#2EB886{{ end }}`,
+				Title:        amTitleTemplate,
+				Text:         amTextTemplate,
+				SendResolved: utils.BoolPointer(s.SendResolved),
+			},
+		},
+	}
+}
+
+func (s *Slack) SecretData(receiverName string) map[string]string {
+	return map[string]string{SlackSecretKey(receiverName, s.URL): s.URL}
+}
+
+func (s *Slack) Check() error {
+	u, err := url.ParseRequestURI(s.URL)
+	if err != nil {
+		return fmt.Errorf("slack webhook url not valid: %w", err)
+	}
+	if u.Scheme != "https" {
+		return fmt.Errorf("slack webhook url must be https")
+	}
+	return nil
+}
+
+func (s *Slack) Test(alert prometheus.WebhookAlert) error {
+	return s.Notify(context.Background(), alert)
+}
+
+func (s *Slack) Notify(ctx context.Context, alert prometheus.WebhookAlert) error {
+	msg := NewAlertMessages(alert)
+	body := map[string]interface{}{
+		"attachments": []map[string]interface{}{
+			{
+				"color":     msg.Color(),
+				"title":     msg.Title,
+				"text":      msg.Render(slackTemplate),
+				"mrkdwn_in": []string{"text"},
+			},
+		},
+	}
+	if s.Channel != "" {
+		body["channel"] = s.Channel
+	}
+	if s.Username != "" {
+		body["username"] = s.Username
+	}
+	_, err := postJSON(ctx, s.URL, body)
+	return err
+}
+
+// String url保存在secret中，和 SlackConfig 比对时使用 SlackSecretKey
+func (s *Slack) String() string {
+	return s.URL + s.Channel + s.Username
+}
@@ -0,0 +1,119 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package channels
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus-operator/prometheus-operator/pkg/apis/monitoring/v1alpha1"
+	v1 "k8s.io/api/core/v1"
+	"kubegems.io/kubegems/pkg/utils"
+	"kubegems.io/kubegems/pkg/utils/prometheus"
+)
+
+// telegramAPIURL telegram bot api 地址
+var telegramAPIURL = "https://api.telegram.org"
+
+// Telegram bot，告警由 kubegems 发送，bot token 保存在 secret 中
+type Telegram struct {
+	BaseChannel `json:",inline"`
+	BotToken    string `json:"botToken" binding:"required"` // bot token，eg. 123456:ABC-DEF
+	ChatID      string `json:"chatID" binding:"required"`   // chat id 或者 @channelusername
+}
+
+func (t *Telegram) formatURL() string {
+	q := url.Values{}
+	q.Add("type", string(TypeTelegram))
+	q.Add("chatID", t.ChatID)
+	return kubegemsNotifyURL(q)
+}
+
+func TelegramSecretKey(receverName string) string {
+	return receverName + "-telegram-token"
+}
+
+func (t *Telegram) ToReceiver(name string) v1alpha1.Receiver {
+	u := t.formatURL()
+	httpconfig := kubegemsNotifyHTTPConfig()
+	// bot token 作为 bearer token 发送给 kubegems
+	httpconfig.BearerTokenSecret = &v1.SecretKeySelector{
+		LocalObjectReference: v1.LocalObjectReference{
+			Name: EmailSecretName,
+		},
+		Key: TelegramSecretKey(name),
+	}
+	return v1alpha1.Receiver{
+		Name: name,
+		WebhookConfigs: []v1alpha1.WebhookConfig{
+			{
+				URL:          &u,
+				SendResolved: utils.BoolPointer(t.SendResolved),
+				HTTPConfig:   httpconfig,
+			},
+		},
+	}
+}
+
+func (t *Telegram) SecretData(receiverName string) map[string]string {
+	return map[string]string{TelegramSecretKey(receiverName): t.BotToken}
+}
+
+func (t *Telegram) Check() error {
+	if id, secret, ok := strings.Cut(t.BotToken, ":"); !ok || id == "" || secret == "" {
+		return fmt.Errorf("telegram bot token not valid")
+	}
+	if !strings.HasPrefix(t.ChatID, "@") {
+		if _, err := strconv.ParseInt(t.ChatID, 10, 64); err != nil {
+			return fmt.Errorf("telegram chat id not valid")
+		}
+	}
+	return nil
+}
+
+func (t *Telegram) Test(alert prometheus.WebhookAlert) error {
+	return t.Notify(context.Background(), alert)
+}
+
+func (t *Telegram) Notify(ctx context.Context, alert prometheus.WebhookAlert) error {
+	msg := NewAlertMessages(alert)
+	bts, err := postJSON(ctx, fmt.Sprintf("%s/bot%s/sendMessage", telegramAPIURL, t.BotToken), map[string]interface{}{
+		"chat_id":    t.ChatID,
+		"text":       msg.Render(htmlTemplate),
+		"parse_mode": "HTML",
+	})
+	if err != nil {
+		return err
+	}
+	resp := struct {
+		OK          bool   `json:"ok"`
+		Description string `json:"description"`
+	}{}
+	if err := json.Unmarshal(bts, &resp); err != nil {
+		return err
+	}
+	if !resp.OK {
+		return fmt.Errorf("telegram: %s", resp.Description)
+	}
+	return nil
+}
+
+func (t *Telegram) String() string {
+	return t.formatURL()
+}
@@ -16,8 +16,10 @@ package channels
 
 import (
 	"bytes"
+	"context"
 	"crypto/tls"
 	"encoding/json"
+	"fmt"
 	"io"
 	"net/http"
 	"net/url"
@@ -62,28 +64,37 @@ func (w *Webhook) Check() error {
 }
 
 func (w *Webhook) Test(alert prometheus.WebhookAlert) error {
+	return w.Notify(context.Background(), alert)
+}
+
+func (w *Webhook) Notify(ctx context.Context, alert prometheus.WebhookAlert) error {
 	buf := bytes.NewBuffer(nil)
 	if err := json.NewEncoder(buf).Encode(alert); err != nil {
 		return err
 	}
-	req, err := http.NewRequest(http.MethodPost, w.URL, buf)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, buf)
 	if err != nil {
 		return err
 	}
-	testCli := &http.Client{}
+	req.Header.Set("Content-Type", "application/json")
+	cli := &http.Client{}
 	if w.InsecureSkipVerify {
-		testCli.Transport = &http.Transport{
+		cli.Transport = &http.Transport{
 			TLSClientConfig: &tls.Config{
 				InsecureSkipVerify: true,
 			},
 		}
 	}
-	resp, err := testCli.Do(req)
+	resp, err := cli.Do(req)
 	if err != nil {
 		return err
 	}
+	defer resp.Body.Close()
 	bts, _ := io.ReadAll(resp.Body)
-	log.Info("test webhook success", "url", w.URL, "resp", string(bts))
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("%s: %s", resp.Status, string(bts))
+	}
+	log.Info("notify webhook success", "url", w.URL, "resp", string(bts))
 	return nil
 }
 
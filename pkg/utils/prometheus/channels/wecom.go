@@ -0,0 +1,100 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package channels
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/prometheus-operator/prometheus-operator/pkg/apis/monitoring/v1alpha1"
+	"kubegems.io/kubegems/pkg/utils"
+	"kubegems.io/kubegems/pkg/utils/prometheus"
+)
+
+// WeCom 企业微信群机器人，告警由 kubegems 发送
+type WeCom struct {
+	BaseChannel   `json:",inline"`
+	URL           string `json:"url" binding:"required"` // wecom robot webhook url
+	MentionedList string `json:"mentionedList"`          // 要@的用户id，多个中间以","隔开，所有人则是 @all
+}
+
+func (w *WeCom) formatURL() string {
+	q := url.Values{}
+	q.Add("type", string(TypeWeCom))
+	q.Add("url", w.URL)
+	q.Add("mentionedList", w.MentionedList)
+	return kubegemsNotifyURL(q)
+}
+
+func (w *WeCom) ToReceiver(name string) v1alpha1.Receiver {
+	u := w.formatURL()
+	return v1alpha1.Receiver{
+		Name: name,
+		WebhookConfigs: []v1alpha1.WebhookConfig{
+			{
+				URL:          &u,
+				SendResolved: utils.BoolPointer(w.SendResolved),
+				HTTPConfig:   kubegemsNotifyHTTPConfig(),
+			},
+		},
+	}
+}
+
+func (w *WeCom) Check() error {
+	if err := checkWebhookHost(w.URL, WeComHosts); err != nil {
+		return fmt.Errorf("wecom robot url not valid: %w", err)
+	}
+	return nil
+}
+
+func (w *WeCom) Test(alert prometheus.WebhookAlert) error {
+	return w.Notify(context.Background(), alert)
+}
+
+func (w *WeCom) Notify(ctx context.Context, alert prometheus.WebhookAlert) error {
+	msg := NewAlertMessages(alert)
+	content := "## " + msg.Title + "\n" + msg.Render(markdownTemplate)
+	// markdown 消息不支持 mentioned_list，直接在内容中@
+	for _, user := range strings.Split(w.MentionedList, ",") {
+		if user = strings.TrimSpace(user); user != "" {
+			content += fmt.Sprintf("<@%s>", user)
+		}
+	}
+	bts, err := postJSON(ctx, w.URL, map[string]interface{}{
+		"msgtype":  "markdown",
+		"markdown": map[string]string{"content": content},
+	})
+	if err != nil {
+		return err
+	}
+	resp := struct {
+		ErrCode int    `json:"errcode"`
+		ErrMsg  string `json:"errmsg"`
+	}{}
+	if err := json.Unmarshal(bts, &resp); err != nil {
+		return err
+	}
+	if resp.ErrCode != 0 {
+		return fmt.Errorf("wecom: %d %s", resp.ErrCode, resp.ErrMsg)
+	}
+	return nil
+}
+
+func (w *WeCom) String() string {
+	return w.formatURL()
+}
@@ -0,0 +1,214 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prometheus
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/common/model"
+)
+
+const (
+	SLOLabel       = "slo"
+	SLORuleType    = "slo"
+	SLOWindowVar   = "{{.window}}" // SLI 查询语句中的时间窗口变量
+	SLOAlertPrefix = "slo-"
+
+	SLISourceIstio = "istio"
+	SLISourceOtel  = "otel"
+
+	SLITypeAvailability = "availability"
+	SLITypeLatency      = "latency"
+
+	// SLI 错误率的 recording rule 名，eg. slo:sli_error:ratio_rate5m
+	SLIErrorRatioRecordPrefix = "slo:sli_error:ratio_rate"
+
+	minSLOWindow = 7 * 24 * time.Hour
+	maxSLOWindow = 90 * 24 * time.Hour
+)
+
+// SLI 服务等级指标，定义为 good/total 事件比例
+type SLI struct {
+	GoodQuery  string        `json:"goodQuery"`           // 好事件速率，使用 {{.window}} 表示时间窗口, eg. sum(rate(http_requests_total{code!~"5.."}[{{.window}}]))
+	TotalQuery string        `json:"totalQuery"`          // 总事件速率，使用 {{.window}} 表示时间窗口
+	Generator  *SLIGenerator `json:"generator,omitempty"` // 从 otel/istio 指标生成，设置后忽略 GoodQuery/TotalQuery
+}
+
+type SLIGenerator struct {
+	Source           string `json:"source"`           // istio, otel
+	Type             string `json:"type"`             // availability, latency
+	Service          string `json:"service"`          // 服务名
+	LatencyThreshold string `json:"latencyThreshold"` // 延迟阈值，单位ms，需要和直方图的le一致, eg. 500
+}
+
+func (s *SLI) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case []byte:
+		return json.Unmarshal(v, s)
+	case string:
+		return json.Unmarshal([]byte(v), s)
+	}
+	return nil
+}
+
+func (s SLI) Value() (driver.Value, error) {
+	bts, err := json.Marshal(s)
+	return string(bts), err
+}
+
+func (s SLI) GormDataType() string {
+	return "json"
+}
+
+// Queries 返回 good/total 查询语句，查询语句需包含 namespace 筛选
+func (s SLI) Queries(namespace string) (good, total string, err error) {
+	if g := s.Generator; g != nil {
+		return g.queries(namespace)
+	}
+	if s.GoodQuery == "" || s.TotalQuery == "" {
+		return "", "", errors.New("goodQuery and totalQuery can't be empty")
+	}
+	for _, q := range []string{s.GoodQuery, s.TotalQuery} {
+		if !strings.Contains(q, SLOWindowVar) {
+			return "", "", fmt.Errorf("query %s must contains window variable %s", q, SLOWindowVar)
+		}
+		if namespace != GlobalAlertNamespace && !strings.Contains(q, fmt.Sprintf(`namespace="%s"`, namespace)) {
+			return "", "", fmt.Errorf(`query %[1]s must contains namespace %[2]s, eg: {namespace="%[2]s"}`, q, namespace)
+		}
+	}
+	return s.GoodQuery, s.TotalQuery, nil
+}
+
+func (g *SLIGenerator) queries(namespace string) (good, total string, err error) {
+	if g.Service == "" {
+		return "", "", errors.New("service can't be empty")
+	}
+	if g.Type == SLITypeLatency {
+		if _, err := strconv.ParseFloat(g.LatencyThreshold, 64); err != nil {
+			return "", "", fmt.Errorf("latency threshold %s not valid", g.LatencyThreshold)
+		}
+	}
+	var (
+		selector                    string
+		requests, goodFilter        string
+		latencyBucket, latencyCount string
+	)
+	switch g.Source {
+	case SLISourceIstio:
+		selector = fmt.Sprintf(`namespace="%s", reporter="destination", destination_service_name="%s"`, namespace, g.Service)
+		requests, goodFilter = "istio_requests_total", `response_code!~"5.."`
+		latencyBucket, latencyCount = "istio_request_duration_milliseconds_bucket", "istio_request_duration_milliseconds_count"
+	case SLISourceOtel:
+		selector = fmt.Sprintf(`namespace="%s", service_name="%s"`, namespace, g.Service)
+		requests, goodFilter = "calls_total", `status_code!="STATUS_CODE_ERROR"`
+		latencyBucket, latencyCount = "latency_bucket", "latency_count"
+	default:
+		return "", "", fmt.Errorf("unknown sli source: %s", g.Source)
+	}
+	switch g.Type {
+	case SLITypeAvailability:
+		good = fmt.Sprintf("sum(rate(%s{%s, %s}[%s]))", requests, selector, goodFilter, SLOWindowVar)
+		total = fmt.Sprintf("sum(rate(%s{%s}[%s]))", requests, selector, SLOWindowVar)
+	case SLITypeLatency:
+		good = fmt.Sprintf(`sum(rate(%s{%s, le="%s"}[%s]))`, latencyBucket, selector, g.LatencyThreshold, SLOWindowVar)
+		total = fmt.Sprintf("sum(rate(%s{%s}[%s]))", latencyCount, selector, SLOWindowVar)
+	default:
+		return "", "", fmt.Errorf("unknown sli type: %s", g.Type)
+	}
+	return good, total, nil
+}
+
+// SLIErrorRatioExpr 返回窗口内的错误率表达式
+func SLIErrorRatioExpr(good, total string, window model.Duration) string {
+	w := window.String()
+	return fmt.Sprintf("1 - ((%s) / (%s))", strings.ReplaceAll(good, SLOWindowVar, w), strings.ReplaceAll(total, SLOWindowVar, w))
+}
+
+func SLIErrorRatioRecord(window model.Duration) string {
+	return SLIErrorRatioRecordPrefix + window.String()
+}
+
+// ParseSLO 校验目标及窗口，返回错误预算比例, eg. 99.9 -> 0.001
+func ParseSLO(objective float64, window string) (errorBudget float64, period model.Duration, err error) {
+	if objective <= 0 || objective >= 100 {
+		return 0, 0, fmt.Errorf("objective %v must be in (0, 100)", objective)
+	}
+	period, err = model.ParseDuration(window)
+	if err != nil {
+		return 0, 0, fmt.Errorf("window %s not valid: %w", window, err)
+	}
+	if time.Duration(period) < minSLOWindow || time.Duration(period) > maxSLOWindow {
+		return 0, 0, fmt.Errorf("window %s must be between %s and %s", window, model.Duration(minSLOWindow), model.Duration(maxSLOWindow))
+	}
+	return RoundFloat((100 - objective) / 100), period, nil
+}
+
+// RoundFloat 去掉浮点运算的误差, eg. 0.0009999999999 -> 0.001
+func RoundFloat(v float64) float64 {
+	return math.Round(v*1e10) / 1e10
+}
+
+func FormatFloat(v float64) string {
+	return strconv.FormatFloat(RoundFloat(v), 'f', -1, 64)
+}
+
+// BurnRateWindow 多窗口多燃烧速率告警的一组窗口
+// https://sre.google/workbook/alerting-on-slos/#6-multiwindow-multi-burn-rate-alerts
+type BurnRateWindow struct {
+	Severity       string         `json:"severity"`
+	LongWindow     model.Duration `json:"longWindow"`
+	ShortWindow    model.Duration `json:"shortWindow"`
+	BudgetConsumed float64        `json:"budgetConsumed"` // 长窗口内消耗的错误预算比例
+	BurnRate       float64        `json:"burnRate"`       // 燃烧速率阈值
+}
+
+// BurnRateWindows 返回 period 对应的各组窗口，燃烧速率 = 消耗的错误预算比例 * period / 长窗口
+// period 为30d时，即为 14.4(1h/5m)、6(6h/30m)、3(1d/2h)、1(3d/6h)
+func BurnRateWindows(period model.Duration) []BurnRateWindow {
+	hour := model.Duration(time.Hour)
+	ret := []BurnRateWindow{
+		{Severity: SeverityCritical, LongWindow: hour, ShortWindow: 5 * hour / 60, BudgetConsumed: 0.02},
+		{Severity: SeverityCritical, LongWindow: 6 * hour, ShortWindow: 30 * hour / 60, BudgetConsumed: 0.05},
+		{Severity: SeverityError, LongWindow: 24 * hour, ShortWindow: 2 * hour, BudgetConsumed: 0.1},
+		{Severity: SeverityError, LongWindow: 72 * hour, ShortWindow: 6 * hour, BudgetConsumed: 0.1},
+	}
+	for i := range ret {
+		ret[i].BurnRate = RoundFloat(ret[i].BudgetConsumed * float64(period) / float64(ret[i].LongWindow))
+	}
+	return ret
+}
+
+// SLORecordingWindows 返回需要记录错误率的窗口，从小到大排列
+func SLORecordingWindows(period model.Duration) []model.Duration {
+	set := map[model.Duration]bool{}
+	for _, w := range BurnRateWindows(period) {
+		set[w.LongWindow] = true
+		set[w.ShortWindow] = true
+	}
+	ret := []model.Duration{}
+	for w := range set {
+		ret = append(ret, w)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i] < ret[j] })
+	return ret
+}
@@ -0,0 +1,120 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prometheus
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+)
+
+func TestBurnRateWindows(t *testing.T) {
+	got := []float64{}
+	for _, w := range BurnRateWindows(model.Duration(30 * 24 * time.Hour)) {
+		got = append(got, w.BurnRate)
+	}
+	if want := []float64{14.4, 6, 3, 1}; !reflect.DeepEqual(got, want) {
+		t.Errorf("BurnRateWindows() = %v, want %v", got, want)
+	}
+	windows := []string{}
+	for _, w := range SLORecordingWindows(model.Duration(30 * 24 * time.Hour)) {
+		windows = append(windows, w.String())
+	}
+	if want := []string{"5m", "30m", "1h", "2h", "6h", "1d", "3d"}; !reflect.DeepEqual(windows, want) {
+		t.Errorf("SLORecordingWindows() = %v, want %v", windows, want)
+	}
+}
+
+func TestParseSLO(t *testing.T) {
+	budget, period, err := ParseSLO(99.9, "30d")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if budget != 0.001 || period.String() != "30d" {
+		t.Errorf("ParseSLO() = %v, %v", budget, period)
+	}
+	for _, tt := range []struct {
+		objective float64
+		window    string
+	}{{100, "30d"}, {0, "30d"}, {99, "1d"}, {99, "1y"}, {99, "abc"}} {
+		if _, _, err := ParseSLO(tt.objective, tt.window); err == nil {
+			t.Errorf("ParseSLO(%v, %s) should return error", tt.objective, tt.window)
+		}
+	}
+}
+
+func TestSLIQueries(t *testing.T) {
+	tests := []struct {
+		name      string
+		sli       SLI
+		wantGood  string
+		wantTotal string
+		wantErr   bool
+	}{
+		{
+			name: "raw",
+			sli: SLI{
+				GoodQuery:  `sum(rate(http_requests_total{namespace="ns", code!~"5.."}[{{.window}}]))`,
+				TotalQuery: `sum(rate(http_requests_total{namespace="ns"}[{{.window}}]))`,
+			},
+			wantGood:  `sum(rate(http_requests_total{namespace="ns", code!~"5.."}[{{.window}}]))`,
+			wantTotal: `sum(rate(http_requests_total{namespace="ns"}[{{.window}}]))`,
+		},
+		{
+			name:    "raw without window",
+			sli:     SLI{GoodQuery: `sum(rate(a{namespace="ns"}[5m]))`, TotalQuery: `sum(rate(b{namespace="ns"}[{{.window}}]))`},
+			wantErr: true,
+		},
+		{
+			name:    "raw without namespace",
+			sli:     SLI{GoodQuery: `sum(rate(a[{{.window}}]))`, TotalQuery: `sum(rate(b[{{.window}}]))`},
+			wantErr: true,
+		},
+		{
+			name:      "otel availability",
+			sli:       SLI{Generator: &SLIGenerator{Source: SLISourceOtel, Type: SLITypeAvailability, Service: "svc"}},
+			wantGood:  `sum(rate(calls_total{namespace="ns", service_name="svc", status_code!="STATUS_CODE_ERROR"}[{{.window}}]))`,
+			wantTotal: `sum(rate(calls_total{namespace="ns", service_name="svc"}[{{.window}}]))`,
+		},
+		{
+			name:      "istio latency",
+			sli:       SLI{Generator: &SLIGenerator{Source: SLISourceIstio, Type: SLITypeLatency, Service: "svc", LatencyThreshold: "500"}},
+			wantGood:  `sum(rate(istio_request_duration_milliseconds_bucket{namespace="ns", reporter="destination", destination_service_name="svc", le="500"}[{{.window}}]))`,
+			wantTotal: `sum(rate(istio_request_duration_milliseconds_count{namespace="ns", reporter="destination", destination_service_name="svc"}[{{.window}}]))`,
+		},
+		{
+			name:    "latency without threshold",
+			sli:     SLI{Generator: &SLIGenerator{Source: SLISourceIstio, Type: SLITypeLatency, Service: "svc"}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			good, total, err := tt.sli.Queries("ns")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Queries() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if good != tt.wantGood || total != tt.wantTotal {
+				t.Errorf("Queries() = %s, %s, want %s, %s", good, total, tt.wantGood, tt.wantTotal)
+			}
+		})
+	}
+	if got, want := SLIErrorRatioExpr("sum(rate(a[{{.window}}]))", "sum(rate(b[{{.window}}]))", model.Duration(time.Hour)),
+		"1 - ((sum(rate(a[1h]))) / (sum(rate(b[1h]))))"; got != want {
+		t.Errorf("SLIErrorRatioExpr() = %s, want %s", got, want)
+	}
+}
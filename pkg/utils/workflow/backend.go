@@ -16,6 +16,7 @@ package workflow
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"strings"
@@ -42,16 +43,27 @@ type Backend interface {
 
 	// kv存储
 	Get(ctx context.Context, key string) ([]byte, error)
+	// ttl 为空或者为 0 时不过期
 	Put(ctx context.Context, key string, val []byte, ttl ...time.Duration) error
 	Del(ctx context.Context, key string) error
 	List(ctx context.Context, keyprefix string) (map[string][]byte, error)
+	// 监听前缀为 key 的变更，key 被删除或者过期时 val 为空
 	Watch(ctx context.Context, key string, onchange OnChangeFunc) error
 }
 
+// ControlBackend 支持控制通道的 Backend，为可选实现。
+// 与队列不同，每条消息会广播至所有监听者，不保证送达，用于通知正在执行任务的 server。
+// Backend 未实现时，取消指令在 server 下一次处理该任务时生效，正在执行的步骤不会被立即取消。
+type ControlBackend interface {
+	Notify(ctx context.Context, channel string, val []byte) error
+	Listen(ctx context.Context, channel string, onchange OnChangeFunc) error
+}
+
 type RedisBackend struct {
-	kvprefix    string
-	steamprefix string
-	cli         *redis.Client
+	kvprefix      string
+	steamprefix   string
+	controlprefix string
+	cli           *redis.Client
 }
 
 func NewRedisBackend(addr, username, password string) *RedisBackend {
@@ -61,9 +73,10 @@ func NewRedisBackend(addr, username, password string) *RedisBackend {
 
 func NewRedisBackendFromClient(c *redis.Client) *RedisBackend {
 	return &RedisBackend{
-		kvprefix:    "/workflow-store/",
-		steamprefix: "/workflow-queue/",
-		cli:         c,
+		kvprefix:      "/workflow-store/",
+		steamprefix:   "/workflow-queue/",
+		controlprefix: "/workflow-control/",
+		cli:           c,
 	}
 }
 
@@ -186,7 +199,7 @@ func (b *RedisBackend) Pub(ctx context.Context, name string, key string, val []b
 // kv存储
 func (b *RedisBackend) Put(ctx context.Context, key string, val []byte, ttl ...time.Duration) error {
 	prefixedKey := b.kvprefix + key
-	set := b.cli.Set(ctx, prefixedKey, val, 0)
+	set := b.cli.Set(ctx, prefixedKey, val, ttlOf(ttl))
 	return set.Err()
 }
 
@@ -241,8 +254,18 @@ func (b *RedisBackend) Watch(ctx context.Context, key string, onchange OnChangeF
 				return err
 			}
 			name := strings.TrimPrefix(msg.Channel, channelprefix)
-			val, err := b.Get(ctx, name)
-			if err != nil {
+			var val []byte
+			switch msg.Payload {
+			case "set":
+				if val, err = b.Get(ctx, name); err != nil {
+					if !errors.Is(err, redis.Nil) {
+						continue
+					}
+					val = nil
+				}
+			case "del", "expired":
+			default:
+				// 其他事件(例如设置过期时间)不会改变 value
 				continue
 			}
 			if err := onchange(ctx, name, val); err != nil {
@@ -251,3 +274,34 @@ func (b *RedisBackend) Watch(ctx context.Context, key string, onchange OnChangeF
 		}
 	}
 }
+
+func (b *RedisBackend) Notify(ctx context.Context, channel string, val []byte) error {
+	return b.cli.Publish(ctx, b.controlprefix+channel, val).Err()
+}
+
+func (b *RedisBackend) Listen(ctx context.Context, channel string, onchange OnChangeFunc) error {
+	pubsub := b.cli.Subscribe(ctx, b.controlprefix+channel)
+	defer pubsub.Close()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		default:
+			msg, err := pubsub.ReceiveMessage(ctx)
+			if err != nil {
+				return err
+			}
+			if err := onchange(ctx, channel, []byte(msg.Payload)); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func ttlOf(ttl []time.Duration) time.Duration {
+	if len(ttl) == 0 || ttl[0] < 0 {
+		return 0
+	}
+	return ttl[0]
+}
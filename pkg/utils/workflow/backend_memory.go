@@ -0,0 +1,303 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workflow
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrKeyNotFound = errors.New("key not found")
+
+// MemoryBackend 进程内的 Backend 实现，用于单体部署以及测试。
+// 数据不会持久化，进程退出后所有任务丢失。
+type MemoryBackend struct {
+	mu        sync.RWMutex
+	kvs       map[string][]byte
+	expires   map[string]*memoryExpire // 设置了 ttl 的 key
+	queues    map[string]*memoryQueue
+	watchers  map[*memoryWatcher]struct{}
+	listeners map[*memoryWatcher]struct{}
+}
+
+type memoryMessage struct {
+	key string
+	val []byte
+}
+
+type memoryQueue struct {
+	mu      sync.Mutex
+	items   []memoryMessage
+	pending []memoryMessage // 未确认的消息，在下一次 Sub 时重新消费
+	signal  chan struct{}
+}
+
+type memoryExpire struct {
+	timer *time.Timer
+}
+
+// memoryWatcher 的事件队列不限长度，写入时不会阻塞，事件的顺序与写入顺序一致
+type memoryWatcher struct {
+	prefix string
+	mu     sync.Mutex
+	items  []memoryMessage
+	signal chan struct{}
+}
+
+func (w *memoryWatcher) push(msg memoryMessage) {
+	w.mu.Lock()
+	w.items = append(w.items, msg)
+	w.mu.Unlock()
+	select {
+	case w.signal <- struct{}{}:
+	default:
+	}
+}
+
+func (w *memoryWatcher) drain() []memoryMessage {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	items := w.items
+	w.items = nil
+	return items
+}
+
+func NewMemoryBackend() *MemoryBackend {
+	return &MemoryBackend{
+		kvs:       map[string][]byte{},
+		expires:   map[string]*memoryExpire{},
+		queues:    map[string]*memoryQueue{},
+		watchers:  map[*memoryWatcher]struct{}{},
+		listeners: map[*memoryWatcher]struct{}{},
+	}
+}
+
+func (b *MemoryBackend) queue(name string) *memoryQueue {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	q, ok := b.queues[name]
+	if !ok {
+		q = &memoryQueue{signal: make(chan struct{}, 1)}
+		b.queues[name] = q
+	}
+	return q
+}
+
+func (q *memoryQueue) push(msg memoryMessage) {
+	q.mu.Lock()
+	q.items = append(q.items, msg)
+	q.mu.Unlock()
+	select {
+	case q.signal <- struct{}{}:
+	default:
+	}
+}
+
+func (q *memoryQueue) pop(ctx context.Context) (memoryMessage, bool) {
+	for {
+		q.mu.Lock()
+		if len(q.items) > 0 {
+			msg := q.items[0]
+			q.items = q.items[1:]
+			q.mu.Unlock()
+			return msg, true
+		}
+		q.mu.Unlock()
+		select {
+		case <-ctx.Done():
+			return memoryMessage{}, false
+		case <-q.signal:
+		}
+	}
+}
+
+// 队列
+func (b *MemoryBackend) Sub(ctx context.Context, name string, onchange OnChangeFunc, opts ...SubOption) error {
+	options := &SubOptions{Concurrency: 1}
+	for _, opt := range opts {
+		opt(options)
+	}
+	q := b.queue(name)
+
+	// 在启动时，消费上次未确认的任务
+	q.mu.Lock()
+	q.items = append(q.pending, q.items...)
+	q.pending = nil
+	q.mu.Unlock()
+
+	// concurrent
+	concurrentchan := make(chan struct{}, options.Concurrency)
+	for {
+		msg, ok := q.pop(ctx)
+		if !ok {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			q.push(msg)
+			return nil
+		case concurrentchan <- struct{}{}:
+			go func(msg memoryMessage) {
+				if err := onchange(ctx, msg.key, msg.val); err != nil && !options.AutoACK {
+					q.mu.Lock()
+					q.pending = append(q.pending, msg)
+					q.mu.Unlock()
+				}
+				// put it back
+				<-concurrentchan
+			}(msg)
+		}
+	}
+}
+
+func (b *MemoryBackend) Pub(ctx context.Context, name string, key string, val []byte) error {
+	b.queue(name).push(memoryMessage{key: key, val: val})
+	return nil
+}
+
+// kv存储
+func (b *MemoryBackend) Put(ctx context.Context, key string, val []byte, ttl ...time.Duration) error {
+	b.mu.Lock()
+	b.kvs[key] = val
+	b.stopExpire(key)
+	if expiration := ttlOf(ttl); expiration > 0 {
+		expire := &memoryExpire{}
+		expire.timer = time.AfterFunc(expiration, func() { b.expire(key, expire) })
+		b.expires[key] = expire
+	}
+	// 持有锁时分发，保证同一个 key 的事件顺序
+	dispatch(b.match(b.watchers, key), memoryMessage{key: key, val: val})
+	b.mu.Unlock()
+	return nil
+}
+
+func (b *MemoryBackend) Del(ctx context.Context, key string) error {
+	b.mu.Lock()
+	_, ok := b.kvs[key]
+	delete(b.kvs, key)
+	b.stopExpire(key)
+	if ok {
+		dispatch(b.match(b.watchers, key), memoryMessage{key: key})
+	}
+	b.mu.Unlock()
+	return nil
+}
+
+// expire 删除过期的 key，expire 已被替换时说明 key 在过期前被重新写入
+func (b *MemoryBackend) expire(key string, expire *memoryExpire) {
+	b.mu.Lock()
+	if b.expires[key] != expire {
+		b.mu.Unlock()
+		return
+	}
+	delete(b.expires, key)
+	delete(b.kvs, key)
+	dispatch(b.match(b.watchers, key), memoryMessage{key: key})
+	b.mu.Unlock()
+}
+
+func (b *MemoryBackend) stopExpire(key string) {
+	if expire, ok := b.expires[key]; ok {
+		expire.timer.Stop()
+		delete(b.expires, key)
+	}
+}
+
+func (b *MemoryBackend) Get(ctx context.Context, key string) ([]byte, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	val, ok := b.kvs[key]
+	if !ok {
+		return nil, ErrKeyNotFound
+	}
+	return val, nil
+}
+
+func (b *MemoryBackend) List(ctx context.Context, keyprefix string) (map[string][]byte, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	list := map[string][]byte{}
+	for key, val := range b.kvs {
+		if strings.HasPrefix(key, keyprefix) {
+			list[strings.TrimPrefix(key, keyprefix)] = val
+		}
+	}
+	return list, nil
+}
+
+func (b *MemoryBackend) Watch(ctx context.Context, key string, onchange OnChangeFunc) error {
+	return b.watch(ctx, b.watchers, key, onchange)
+}
+
+// 控制通道
+func (b *MemoryBackend) Notify(ctx context.Context, channel string, val []byte) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for l := range b.listeners {
+		if l.prefix == channel {
+			l.push(memoryMessage{key: channel, val: val})
+		}
+	}
+	return nil
+}
+
+func (b *MemoryBackend) Listen(ctx context.Context, channel string, onchange OnChangeFunc) error {
+	return b.watch(ctx, b.listeners, channel, onchange)
+}
+
+func (b *MemoryBackend) watch(ctx context.Context, set map[*memoryWatcher]struct{}, prefix string, onchange OnChangeFunc) error {
+	w := &memoryWatcher{prefix: prefix, signal: make(chan struct{}, 1)}
+	b.mu.Lock()
+	set[w] = struct{}{}
+	b.mu.Unlock()
+
+	defer func() {
+		b.mu.Lock()
+		delete(set, w)
+		b.mu.Unlock()
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-w.signal:
+			for _, msg := range w.drain() {
+				if err := onchange(ctx, msg.key, msg.val); err != nil {
+					return err
+				}
+			}
+		}
+	}
+}
+
+func (b *MemoryBackend) match(set map[*memoryWatcher]struct{}, key string) []*memoryWatcher {
+	matched := []*memoryWatcher{}
+	for w := range set {
+		if strings.HasPrefix(key, w.prefix) {
+			matched = append(matched, w)
+		}
+	}
+	return matched
+}
+
+func dispatch(watchers []*memoryWatcher, msg memoryMessage) {
+	for _, w := range watchers {
+		w.push(msg)
+	}
+}
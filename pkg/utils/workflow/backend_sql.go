@@ -0,0 +1,451 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workflow
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"kubegems.io/kubegems/pkg/log"
+	"kubegems.io/kubegems/pkg/utils/database"
+)
+
+const (
+	DefaultSQLPollInterval      = time.Second
+	DefaultSQLVisibilityTimeout = 2 * DefaultTaskTimeout
+	DefaultSQLExpireInterval    = time.Minute
+	DefaultSQLEventRetention    = 10 * time.Minute
+	DefaultSQLCommitWindow      = 10 * time.Second
+)
+
+// SQLBackend 基于数据库的 Backend 实现，kv 数据持久化在数据库中可以作为任务历史。
+// 队列使用 SELECT ... FOR UPDATE SKIP LOCKED 实现多个消费者之间的无重复消费，需要 MySQL 8.0 以上。
+// Watch/Listen 通过轮询实现，kv 的每次变更记录在自增 id 的事件表中，Watch 按 id 顺序消费。
+// 自增 id 在插入时分配，事务提交的顺序可能与 id 不同，CommitWindow 内的事件按 id 去重后消费，不会因为后提交而丢失。
+type SQLBackend struct {
+	db *gorm.DB
+	// 轮询间隔
+	PollInterval time.Duration
+	// 已被消费但是未确认的消息超过该时间后重新消费，消费期间会定期续约
+	VisibilityTimeout time.Duration
+	// 清理过期 key 以及历史事件的间隔
+	ExpireInterval time.Duration
+	// kv 变更事件的保留时间，Watch 落后超过该时间的事件会丢失
+	EventRetention time.Duration
+	// 事件从写入到提交的最长时间，超过该时间提交的事件可能不会被 Watch/Listen 收到
+	CommitWindow time.Duration
+
+	mu         sync.Mutex
+	lastExpire time.Time
+}
+
+type WorkflowKV struct {
+	Key       string `gorm:"primaryKey;size:512"`
+	Value     []byte
+	ExpiresAt *time.Time `gorm:"index"` // 为空时不过期
+	UpdatedAt time.Time
+}
+
+// WorkflowKVEvent kv 的变更事件，Value 为空表示 key 被删除或者过期
+type WorkflowKVEvent struct {
+	ID        uint64 `gorm:"primaryKey;autoIncrement"`
+	Key       string `gorm:"size:512"`
+	Value     []byte
+	CreatedAt time.Time `gorm:"index"`
+}
+
+type WorkflowQueueMessage struct {
+	ID        uint64 `gorm:"primaryKey;autoIncrement"`
+	Queue     string `gorm:"size:128;index"`
+	Key       string `gorm:"size:512"`
+	Value     []byte
+	ClaimedBy string `gorm:"size:128"`
+	ClaimedAt *time.Time
+	CreatedAt time.Time
+}
+
+type WorkflowNotification struct {
+	ID        uint64 `gorm:"primaryKey;autoIncrement"`
+	Channel   string `gorm:"size:128;index"`
+	Value     []byte
+	CreatedAt time.Time `gorm:"index"`
+}
+
+func NewSQLBackend(db *database.Database) (*SQLBackend, error) {
+	return NewSQLBackendFromDB(db.DB())
+}
+
+func NewSQLBackendFromDB(db *gorm.DB) (*SQLBackend, error) {
+	if err := db.AutoMigrate(&WorkflowKV{}, &WorkflowKVEvent{}, &WorkflowQueueMessage{}, &WorkflowNotification{}); err != nil {
+		return nil, err
+	}
+	return &SQLBackend{
+		db:                db,
+		PollInterval:      DefaultSQLPollInterval,
+		VisibilityTimeout: DefaultSQLVisibilityTimeout,
+		ExpireInterval:    DefaultSQLExpireInterval,
+		EventRetention:    DefaultSQLEventRetention,
+		CommitWindow:      DefaultSQLCommitWindow,
+	}, nil
+}
+
+// 队列
+func (b *SQLBackend) Sub(ctx context.Context, name string, onchange OnChangeFunc, opts ...SubOption) error {
+	options := &SubOptions{Concurrency: 1}
+	for _, opt := range opts {
+		opt(options)
+	}
+	hostname, _ := os.Hostname()
+	// 同一主机上可能有多个消费者，续约时需要区分
+	consumer := hostname + "/" + uuid.New().String()
+
+	// concurrent
+	concurrentchan := make(chan struct{}, options.Concurrency)
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case concurrentchan <- struct{}{}:
+		}
+		msg, err := b.claim(ctx, name, consumer)
+		if err != nil {
+			<-concurrentchan
+			if errors.Is(err, context.Canceled) {
+				return nil
+			}
+			return err
+		}
+		if msg == nil {
+			<-concurrentchan
+			if !sleepContext(ctx, b.PollInterval) {
+				return nil
+			}
+			continue
+		}
+		go func(msg *WorkflowQueueMessage) {
+			stop := b.keepalive(ctx, msg, consumer)
+			err := onchange(ctx, msg.Key, msg.Value)
+			stop()
+			if err == nil || options.AutoACK {
+				// ack
+				b.db.WithContext(ctx).Delete(msg)
+			}
+			// 未确认的消息在 VisibilityTimeout 后重新消费
+			// put it back
+			<-concurrentchan
+		}(msg)
+	}
+}
+
+// claim 锁定并取出队列中的第一条可消费消息，没有消息时返回 nil
+func (b *SQLBackend) claim(ctx context.Context, name, consumer string) (*WorkflowQueueMessage, error) {
+	var claimed *WorkflowQueueMessage
+	err := b.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		msg := &WorkflowQueueMessage{}
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("queue = ? AND (claimed_at IS NULL OR claimed_at < ?)", name, time.Now().Add(-b.VisibilityTimeout)).
+			Order("id").
+			Take(msg).Error
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil
+			}
+			return err
+		}
+		now := time.Now()
+		if err := tx.Model(msg).Updates(map[string]interface{}{"claimed_by": consumer, "claimed_at": now}).Error; err != nil {
+			return err
+		}
+		claimed = msg
+		return nil
+	})
+	return claimed, err
+}
+
+// keepalive 在消息处理期间定期更新 claimed_at，避免处理时间超过 VisibilityTimeout 的消息被其他消费者重复消费
+func (b *SQLBackend) keepalive(ctx context.Context, msg *WorkflowQueueMessage, consumer string) (stop func()) {
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(b.VisibilityTimeout / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				err := b.db.WithContext(ctx).Model(&WorkflowQueueMessage{}).
+					Where("id = ? AND claimed_by = ?", msg.ID, consumer).
+					Update("claimed_at", time.Now()).Error
+				if err != nil && !errors.Is(err, context.Canceled) {
+					log.FromContextOrDiscard(ctx).Error(err, "renew workflow queue message", "id", msg.ID)
+				}
+			}
+		}
+	}()
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+func (b *SQLBackend) Pub(ctx context.Context, name string, key string, val []byte) error {
+	return b.db.WithContext(ctx).Create(&WorkflowQueueMessage{Queue: name, Key: key, Value: val}).Error
+}
+
+// kv存储
+func (b *SQLBackend) Put(ctx context.Context, key string, val []byte, ttl ...time.Duration) error {
+	b.expireIfNeeded(ctx)
+
+	now := time.Now()
+	kv := &WorkflowKV{Key: key, Value: val, UpdatedAt: now}
+	if expiration := ttlOf(ttl); expiration > 0 {
+		expiresAt := now.Add(expiration)
+		kv.ExpiresAt = &expiresAt
+	}
+	return b.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.OnConflict{UpdateAll: true}).Create(kv).Error; err != nil {
+			return err
+		}
+		return tx.Create(&WorkflowKVEvent{Key: key, Value: val}).Error
+	})
+}
+
+func (b *SQLBackend) Del(ctx context.Context, key string) error {
+	return b.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("`key` = ?", key).Delete(&WorkflowKV{})
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+		return tx.Create(&WorkflowKVEvent{Key: key}).Error
+	})
+}
+
+func (b *SQLBackend) Get(ctx context.Context, key string) ([]byte, error) {
+	kv := &WorkflowKV{}
+	if err := b.db.WithContext(ctx).Scopes(notExpired).Where("`key` = ?", key).Take(kv).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrKeyNotFound
+		}
+		return nil, err
+	}
+	return kv.Value, nil
+}
+
+func (b *SQLBackend) List(ctx context.Context, keyprefix string) (map[string][]byte, error) {
+	kvs := []WorkflowKV{}
+	if err := b.db.WithContext(ctx).Scopes(notExpired).Where("`key` LIKE ? ESCAPE '!'", likePrefix(keyprefix)).Find(&kvs).Error; err != nil {
+		return nil, err
+	}
+	list := map[string][]byte{}
+	for _, kv := range kvs {
+		list[strings.TrimPrefix(kv.Key, keyprefix)] = kv.Value
+	}
+	return list, nil
+}
+
+func (b *SQLBackend) Watch(ctx context.Context, key string, onchange OnChangeFunc) error {
+	cursor, err := b.newEventCursor(ctx, &WorkflowKVEvent{})
+	if err != nil {
+		return err
+	}
+	for {
+		if !sleepContext(ctx, b.PollInterval) {
+			return nil
+		}
+		// 没有写入时同样需要清理过期的 key
+		b.expireIfNeeded(ctx)
+
+		events := []WorkflowKVEvent{}
+		if err := b.db.WithContext(ctx).
+			Where("`key` LIKE ? ESCAPE '!' AND id > ?", likePrefix(key), cursor.lastid).
+			Order("id").
+			Find(&events).Error; err != nil {
+			return err
+		}
+		for _, event := range events {
+			if !cursor.visit(event.ID, event.CreatedAt) {
+				continue
+			}
+			if err := onchange(ctx, event.Key, event.Value); err != nil {
+				return err
+			}
+		}
+		cursor.advance(time.Now())
+	}
+}
+
+// eventCursor 记录 Watch/Listen 已消费的事件。
+// 事务提交的顺序可能与 id 顺序不同，较小的 id 可能在较大的 id 之后才可见，
+// 因此 CommitWindow 内的事件记录在 seen 中按 id 去重，超出 CommitWindow 后才移动 lastid。
+type eventCursor struct {
+	window time.Duration
+	lastid uint64               // 小于等于 lastid 的事件均已消费或者已超出 window
+	seen   map[uint64]time.Time // 大于 lastid 的已消费事件 -> 创建时间
+}
+
+type eventMeta struct {
+	ID        uint64
+	CreatedAt time.Time
+}
+
+// newEventCursor 从当前位置开始消费，CommitWindow 内已存在的事件视为已消费
+func (b *SQLBackend) newEventCursor(ctx context.Context, model interface{}) (*eventCursor, error) {
+	cursor := &eventCursor{window: b.CommitWindow, seen: map[uint64]time.Time{}}
+	now := time.Now()
+	if err := b.db.WithContext(ctx).Model(model).
+		Where("created_at < ?", now.Add(-b.CommitWindow)).
+		Select("COALESCE(MAX(id), 0)").Scan(&cursor.lastid).Error; err != nil {
+		return nil, err
+	}
+	recent := []eventMeta{}
+	if err := b.db.WithContext(ctx).Model(model).
+		Where("id > ?", cursor.lastid).
+		Select("id", "created_at").Find(&recent).Error; err != nil {
+		return nil, err
+	}
+	for _, e := range recent {
+		cursor.seen[e.ID] = e.CreatedAt
+	}
+	return cursor, nil
+}
+
+// visit 记录事件已消费，事件已经消费过时返回 false
+func (c *eventCursor) visit(id uint64, createdAt time.Time) bool {
+	if id <= c.lastid {
+		return false
+	}
+	if _, ok := c.seen[id]; ok {
+		return false
+	}
+	c.seen[id] = createdAt
+	return true
+}
+
+// advance 将 lastid 移动到超出 window 的最大 id
+func (c *eventCursor) advance(now time.Time) {
+	for id, createdAt := range c.seen {
+		if id > c.lastid && createdAt.Before(now.Add(-c.window)) {
+			c.lastid = id
+		}
+	}
+	for id := range c.seen {
+		if id <= c.lastid {
+			delete(c.seen, id)
+		}
+	}
+}
+
+// expireIfNeeded 每隔 ExpireInterval 删除过期的 key 并记录删除事件，同时清理超过 EventRetention 的事件
+func (b *SQLBackend) expireIfNeeded(ctx context.Context) {
+	b.mu.Lock()
+	if time.Since(b.lastExpire) < b.ExpireInterval {
+		b.mu.Unlock()
+		return
+	}
+	b.lastExpire = time.Now()
+	b.mu.Unlock()
+
+	if err := b.expire(ctx, time.Now()); err != nil {
+		log.FromContextOrDiscard(ctx).Error(err, "expire workflow kv")
+	}
+}
+
+func (b *SQLBackend) expire(ctx context.Context, now time.Time) error {
+	db := b.db.WithContext(ctx)
+	if err := db.Where("created_at < ?", now.Add(-b.EventRetention)).Delete(&WorkflowKVEvent{}).Error; err != nil {
+		return err
+	}
+	keys := []string{}
+	if err := db.Model(&WorkflowKV{}).Where("expires_at <= ?", now).Pluck("key", &keys).Error; err != nil {
+		return err
+	}
+	for _, key := range keys {
+		err := db.Transaction(func(tx *gorm.DB) error {
+			// 在此期间被重新写入的 key 不会被删除
+			result := tx.Where("`key` = ? AND expires_at <= ?", key, now).Delete(&WorkflowKV{})
+			if result.Error != nil || result.RowsAffected == 0 {
+				return result.Error
+			}
+			return tx.Create(&WorkflowKVEvent{Key: key}).Error
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func notExpired(db *gorm.DB) *gorm.DB {
+	return db.Where("expires_at IS NULL OR expires_at > ?", time.Now())
+}
+
+// 控制通道
+func (b *SQLBackend) Notify(ctx context.Context, channel string, val []byte) error {
+	db := b.db.WithContext(ctx)
+	// 清理过期的通知
+	db.Where("created_at < ?", time.Now().Add(-time.Minute)).Delete(&WorkflowNotification{})
+	return db.Create(&WorkflowNotification{Channel: channel, Value: val}).Error
+}
+
+func (b *SQLBackend) Listen(ctx context.Context, channel string, onchange OnChangeFunc) error {
+	cursor, err := b.newEventCursor(ctx, &WorkflowNotification{})
+	if err != nil {
+		return err
+	}
+	for {
+		if !sleepContext(ctx, b.PollInterval) {
+			return nil
+		}
+		notifications := []WorkflowNotification{}
+		if err := b.db.WithContext(ctx).
+			Where("channel = ? AND id > ?", channel, cursor.lastid).
+			Order("id").
+			Find(&notifications).Error; err != nil {
+			return err
+		}
+		for _, n := range notifications {
+			if !cursor.visit(n.ID, n.CreatedAt) {
+				continue
+			}
+			if err := onchange(ctx, n.Channel, n.Value); err != nil {
+				return err
+			}
+		}
+		cursor.advance(time.Now())
+	}
+}
+
+// likePrefix 返回前缀匹配的 LIKE 模式，需配合 ESCAPE '!' 使用
+func likePrefix(prefix string) string {
+	return strings.NewReplacer(`!`, `!!`, `%`, `!%`, `_`, `!_`).Replace(prefix) + "%"
+}
+
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
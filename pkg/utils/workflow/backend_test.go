@@ -16,12 +16,52 @@ package workflow
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"os"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/alicebob/miniredis/v2"
 	"github.com/go-redis/redis/v8"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"kubegems.io/kubegems/pkg/utils/database"
 )
 
+// 内置的 Backend 均支持控制通道
+var (
+	_ ControlBackend = &RedisBackend{}
+	_ ControlBackend = &MemoryBackend{}
+	_ ControlBackend = &SQLBackend{}
+)
+
+func TestRedisBackend(t *testing.T) {
+	s := miniredis.RunT(t)
+	backend := NewRedisBackendFromClient(redis.NewClient(&redis.Options{Addr: s.Addr()}))
+	// miniredis 不支持 keyspace notifications，不测试 watch
+	t.Run("kv", func(t *testing.T) { testBackendKV(t, backend) })
+	t.Run("queue", func(t *testing.T) { testBackendQueue(t, backend) })
+	t.Run("notify", func(t *testing.T) { testBackendNotify(t, backend) })
+	t.Run("ttl", func(t *testing.T) {
+		ctx := context.Background()
+		if err := backend.Put(ctx, "ttl/key", []byte("value"), time.Minute); err != nil {
+			t.Fatalf("Put() error = %v", err)
+		}
+		if err := backend.Put(ctx, "ttl/persistent", []byte("value")); err != nil {
+			t.Fatalf("Put() error = %v", err)
+		}
+		s.FastForward(2 * time.Minute)
+		if _, err := backend.Get(ctx, "ttl/key"); !errors.Is(err, redis.Nil) {
+			t.Fatalf("Get() after ttl error = %v, want redis.Nil", err)
+		}
+		if _, err := backend.Get(ctx, "ttl/persistent"); err != nil {
+			t.Fatalf("Get() error = %v", err)
+		}
+	})
+}
+
 func TestRedisBackend_Sub(t *testing.T) {
 	cli := setupRedis(t)
 
@@ -68,3 +108,443 @@ func TestRedisBackend_Sub(t *testing.T) {
 		})
 	}
 }
+
+// TestRedisBackend_PubSub 发布的消息能够被 Sub 收到
+func TestRedisBackend_PubSub(t *testing.T) {
+	cli := setupRedis(t)
+
+	type fields struct {
+		prefix string
+		cli    *redis.Client
+	}
+	type args struct {
+		name string
+		key  string
+		val  []byte
+	}
+	tests := []struct {
+		name    string
+		fields  fields
+		args    args
+		wantErr bool
+	}{
+		{
+			name: "",
+			fields: fields{
+				prefix: "/test/",
+				cli:    cli,
+			},
+			args: args{
+				name: "test-channel",
+				key:  "key",
+				val:  []byte("value"),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := &RedisBackend{
+				kvprefix:    tt.fields.prefix,
+				steamprefix: tt.fields.prefix,
+				cli:         tt.fields.cli,
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := b.Pub(ctx, tt.args.name, tt.args.key, tt.args.val); err != nil {
+				t.Fatalf("RedisBackend.Pub() error = %v", err)
+			}
+			received := make(chan string, 1)
+			onchange := func(_ context.Context, key string, val []byte) error {
+				select {
+				case received <- key + "->" + string(val):
+				default:
+				}
+				return nil
+			}
+			errch := make(chan error, 1)
+			go func() { errch <- b.Sub(ctx, tt.args.name, onchange) }()
+			select {
+			case got := <-received:
+				if want := tt.args.key + "->" + string(tt.args.val); got != want {
+					t.Errorf("RedisBackend.Sub() received %s, want %s", got, want)
+				}
+			case err := <-errch:
+				if (err != nil) != tt.wantErr {
+					t.Errorf("RedisBackend.Sub() error = %v, wantErr %v", err, tt.wantErr)
+				}
+			case <-ctx.Done():
+				t.Error("RedisBackend.Sub() received nothing")
+			}
+		})
+	}
+}
+
+func TestMemoryBackend(t *testing.T) {
+	testBackend(t, NewMemoryBackend())
+}
+
+// 默认使用 sqlite，设置 TEST_MYSQL_ADDR 后使用 MySQL，例如 TEST_MYSQL_ADDR=127.0.0.1:3306
+func TestSQLBackend(t *testing.T) {
+	backend, err := NewSQLBackendFromDB(setupSQL(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	backend.PollInterval = 10 * time.Millisecond
+	backend.ExpireInterval = 10 * time.Millisecond
+	testBackend(t, backend)
+	t.Run("late commit", func(t *testing.T) { testSQLBackendLateCommit(t, backend) })
+	t.Run("keepalive", func(t *testing.T) { testSQLBackendKeepalive(t, backend) })
+}
+
+// 较小的 id 在较大的 id 之后提交时同样能够被 Watch 收到
+func testSQLBackendLateCommit(t *testing.T, backend *SQLBackend) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	prefix := fmt.Sprintf("late-%d/", time.Now().UnixNano())
+	changed := make(chan string, 16)
+	go backend.Watch(ctx, prefix, func(_ context.Context, key string, val []byte) error {
+		select {
+		case changed <- string(val):
+		case <-ctx.Done():
+		}
+		return nil
+	})
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	waitWatched(ctx, t, changed, ticker.C, func() {
+		if err := backend.Put(ctx, prefix+"key", []byte("value")); err != nil {
+			t.Fatalf("Put() error = %v", err)
+		}
+	})
+	ticker.Stop()
+
+	var maxid uint64
+	if err := backend.db.Model(&WorkflowKVEvent{}).Select("MAX(id)").Scan(&maxid).Error; err != nil {
+		t.Fatal(err)
+	}
+	// 模拟 id 较小的事务晚于 id 较大的事务提交
+	for _, event := range []*WorkflowKVEvent{
+		{ID: maxid + 10, Key: prefix + "key", Value: []byte("first")},
+		{ID: maxid + 5, Key: prefix + "key", Value: []byte("late")},
+	} {
+		if err := backend.db.Create(event).Error; err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(5 * backend.PollInterval)
+	}
+	received := map[string]int{}
+	for received["late"] == 0 {
+		select {
+		case val := <-changed:
+			received[val]++
+		case <-ctx.Done():
+			t.Fatalf("Watch() received %v, want late committed event", received)
+		}
+	}
+	// 等待可能的重复事件
+	time.Sleep(5 * backend.PollInterval)
+	for len(changed) > 0 {
+		received[<-changed]++
+	}
+	if received["first"] != 1 || received["late"] != 1 {
+		t.Errorf("Watch() received %v, want first and late once", received)
+	}
+}
+
+// 处理时间超过 VisibilityTimeout 的消息不会被重复消费
+func testSQLBackendKeepalive(t *testing.T, shared *SQLBackend) {
+	backend, err := NewSQLBackendFromDB(shared.db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	backend.PollInterval = shared.PollInterval
+	backend.VisibilityTimeout = 100 * time.Millisecond
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	queue := fmt.Sprintf("keepalive-%d", time.Now().UnixNano())
+	mu := sync.Mutex{}
+	consumed := 0
+	done := make(chan struct{}, 2)
+	onchange := func(ctx context.Context, key string, val []byte) error {
+		mu.Lock()
+		consumed++
+		mu.Unlock()
+		select {
+		case <-time.After(5 * backend.VisibilityTimeout):
+		case <-ctx.Done():
+		}
+		done <- struct{}{}
+		return nil
+	}
+	for i := 0; i < 2; i++ {
+		go backend.Sub(ctx, queue, onchange)
+	}
+	if err := backend.Pub(ctx, queue, "", []byte("slow")); err != nil {
+		t.Fatalf("Pub() error = %v", err)
+	}
+	select {
+	case <-done:
+	case <-ctx.Done():
+		t.Fatal("message not consumed")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if consumed != 1 {
+		t.Errorf("message consumed %d times, want 1", consumed)
+	}
+}
+
+func setupSQL(t *testing.T) *gorm.DB {
+	if addr := os.Getenv("TEST_MYSQL_ADDR"); addr != "" {
+		options := database.NewDefaultOptions()
+		options.Addr = addr
+		options.Password = os.Getenv("TEST_MYSQL_PASSWORD")
+		db, err := database.NewDatabase(options)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return db.DB()
+	}
+	db, err := gorm.Open(sqlite.Open(t.TempDir()+"/workflow.db?_busy_timeout=5000"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// sqlite 不支持并发写入
+	sqldb, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqldb.SetMaxOpenConns(1)
+	return db
+}
+
+// testBackend 为所有 Backend 实现需要满足的行为
+func testBackend(t *testing.T, backend Backend) {
+	t.Run("kv", func(t *testing.T) { testBackendKV(t, backend) })
+	t.Run("queue", func(t *testing.T) { testBackendQueue(t, backend) })
+	t.Run("watch", func(t *testing.T) { testBackendWatch(t, backend) })
+	t.Run("ttl", func(t *testing.T) { testBackendTTL(t, backend) })
+	if notifier, ok := backend.(ControlBackend); ok {
+		t.Run("notify", func(t *testing.T) { testBackendNotify(t, notifier) })
+	}
+}
+
+func testBackendKV(t *testing.T, backend Backend) {
+	ctx := context.Background()
+	prefix := fmt.Sprintf("kv-%d/", time.Now().UnixNano())
+	for _, key := range []string{"a/1", "a/2", "b/1"} {
+		if err := backend.Put(ctx, prefix+key, []byte(key)); err != nil {
+			t.Fatalf("Put() error = %v", err)
+		}
+	}
+	val, err := backend.Get(ctx, prefix+"a/1")
+	if err != nil || string(val) != "a/1" {
+		t.Fatalf("Get() = %s, %v, want a/1", val, err)
+	}
+	list, err := backend.List(ctx, prefix+"a/")
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	if len(list) != 2 || string(list["1"]) != "a/1" || string(list["2"]) != "a/2" {
+		t.Fatalf("List() = %v, want keys 1,2 relative to prefix", list)
+	}
+	if err := backend.Put(ctx, prefix+"a/1", []byte("updated")); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	if val, _ := backend.Get(ctx, prefix+"a/1"); string(val) != "updated" {
+		t.Fatalf("Get() = %s, want updated", val)
+	}
+	if err := backend.Del(ctx, prefix+"a/1"); err != nil {
+		t.Fatalf("Del() error = %v", err)
+	}
+	if _, err := backend.Get(ctx, prefix+"a/1"); err == nil {
+		t.Fatal("Get() after Del() error = nil, want error")
+	}
+}
+
+func testBackendQueue(t *testing.T, backend Backend) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	queue := fmt.Sprintf("queue-%d", time.Now().UnixNano())
+	const total = 10
+
+	mu := sync.Mutex{}
+	received := map[string]int{}
+	done := make(chan struct{})
+	onchange := func(_ context.Context, key string, val []byte) error {
+		mu.Lock()
+		defer mu.Unlock()
+		received[string(val)]++
+		if len(received) == total {
+			close(done)
+		}
+		return nil
+	}
+	// 多个消费者共享同一个队列
+	for i := 0; i < 2; i++ {
+		go backend.Sub(ctx, queue, onchange, WithConcurrency(2))
+	}
+	// 等待消费者启动
+	time.Sleep(100 * time.Millisecond)
+	for i := 0; i < total; i++ {
+		if err := backend.Pub(ctx, queue, "", []byte(fmt.Sprintf("msg-%d", i))); err != nil {
+			t.Fatalf("Pub() error = %v", err)
+		}
+	}
+	select {
+	case <-done:
+	case <-ctx.Done():
+		t.Fatalf("received %d messages, want %d", len(received), total)
+	}
+	// 等待可能的重复消费
+	time.Sleep(100 * time.Millisecond)
+	mu.Lock()
+	defer mu.Unlock()
+	for msg, count := range received {
+		if count != 1 {
+			t.Errorf("message %s consumed %d times, want 1", msg, count)
+		}
+	}
+}
+
+func testBackendWatch(t *testing.T, backend Backend) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	prefix := fmt.Sprintf("watch-%d/", time.Now().UnixNano())
+	changed := make(chan string, 16)
+	go backend.Watch(ctx, prefix, func(_ context.Context, key string, val []byte) error {
+		select {
+		case changed <- string(val):
+		case <-ctx.Done():
+		}
+		return nil
+	})
+	// watch 建立之前的变更可能不会收到，持续写入直到收到为止
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	waitWatched(ctx, t, changed, ticker.C, func() {
+		if err := backend.Put(ctx, prefix+"key", []byte("value")); err != nil {
+			t.Fatalf("Put() error = %v", err)
+		}
+	})
+	// 删除时 val 为空
+	if err := backend.Del(ctx, prefix+"key"); err != nil {
+		t.Fatalf("Del() error = %v", err)
+	}
+	for {
+		select {
+		case val := <-changed:
+			if val == "" {
+				return
+			}
+		case <-ctx.Done():
+			t.Fatal("Watch() received no delete event")
+		}
+	}
+}
+
+// waitWatched 持续调用 put 直到 watch 收到 value
+func waitWatched(ctx context.Context, t *testing.T, changed <-chan string, tick <-chan time.Time, put func()) {
+	for {
+		select {
+		case val := <-changed:
+			if val != "value" {
+				t.Fatalf("Watch() got %s, want value", val)
+			}
+			return
+		case <-tick:
+			put()
+		case <-ctx.Done():
+			t.Fatal("Watch() received nothing")
+		}
+	}
+}
+
+func testBackendTTL(t *testing.T, backend Backend) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	prefix := fmt.Sprintf("ttl-%d/", time.Now().UnixNano())
+	changed := make(chan string, 16)
+	go backend.Watch(ctx, prefix, func(_ context.Context, key string, val []byte) error {
+		select {
+		case changed <- string(val):
+		case <-ctx.Done():
+		}
+		return nil
+	})
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	waitWatched(ctx, t, changed, ticker.C, func() {
+		if err := backend.Put(ctx, prefix+"persistent", []byte("value")); err != nil {
+			t.Fatalf("Put() error = %v", err)
+		}
+	})
+	ticker.Stop()
+
+	if err := backend.Put(ctx, prefix+"key", []byte("expiring"), 200*time.Millisecond); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	if val, err := backend.Get(ctx, prefix+"key"); err != nil || string(val) != "expiring" {
+		t.Fatalf("Get() = %s, %v, want expiring", val, err)
+	}
+	// 过期时 val 为空
+	for expired := false; !expired; {
+		select {
+		case val := <-changed:
+			expired = val == ""
+		case <-ctx.Done():
+			t.Fatal("Watch() received no expire event")
+		}
+	}
+	if _, err := backend.Get(ctx, prefix+"key"); err == nil {
+		t.Fatal("Get() after ttl error = nil, want error")
+	}
+	if list, _ := backend.List(ctx, prefix); len(list) != 1 || list["persistent"] == nil {
+		t.Fatalf("List() after ttl = %v, want only persistent", list)
+	}
+}
+
+func testBackendNotify(t *testing.T, backend ControlBackend) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	channel := fmt.Sprintf("channel-%d", time.Now().UnixNano())
+	received := make(chan int, 16)
+	// 多个监听者均能收到通知
+	for i := 0; i < 2; i++ {
+		go func(i int) {
+			backend.Listen(ctx, channel, func(_ context.Context, _ string, val []byte) error {
+				if string(val) != "cancel" {
+					return nil
+				}
+				select {
+				case received <- i:
+				case <-ctx.Done():
+				}
+				return nil
+			})
+		}(i)
+	}
+	// 通知不保证送达，持续通知直到所有监听者均收到
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	listeners := map[int]bool{}
+	for len(listeners) < 2 {
+		select {
+		case i := <-received:
+			listeners[i] = true
+		case <-ticker.C:
+			if err := backend.Notify(ctx, channel, []byte("cancel")); err != nil {
+				t.Fatalf("Notify() error = %v", err)
+			}
+		case <-ctx.Done():
+			t.Fatalf("%d listeners received notification, want 2", len(listeners))
+		}
+	}
+}
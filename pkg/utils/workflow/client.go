@@ -18,6 +18,7 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"path"
 	"sort"
 	"time"
@@ -25,6 +26,7 @@ import (
 	"github.com/go-redis/redis/v8"
 	"github.com/google/uuid"
 	"github.com/robfig/cron/v3"
+	"golang.org/x/sync/errgroup"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"kubegems.io/kubegems/pkg/log"
 )
@@ -32,6 +34,7 @@ import (
 type Client struct {
 	backend Backend
 	crontab *cron.Cron
+	history *HistoryStore
 }
 
 func NewClient(options *Options) *Client {
@@ -52,6 +55,12 @@ func NewClientFromBackend(backend Backend) *Client {
 	return cli
 }
 
+// WithHistory 设置任务历史，任务从 backend 中过期后仍然可以从历史中重试
+func (c *Client) WithHistory(history *HistoryStore) *Client {
+	c.history = history
+	return c
+}
+
 func (c *Client) SubmitCronTask(ctx context.Context, task Task, crontabexp string) error {
 	log := log.FromContextOrDiscard(ctx).WithValues("task", task, "cron", crontabexp)
 	log.Info("register cron task")
@@ -71,6 +80,9 @@ func (c *Client) SubmitTask(ctx context.Context, task Task) error {
 	if task.Name == "" {
 		return errors.New("empty task name")
 	}
+	if err := validateSteps(task.Steps, task.Steps); err != nil {
+		return err
+	}
 	task.CreationTimestamp = metav1.Now()
 	if task.UID == "" {
 		task.UID = uuid.New().String()
@@ -102,7 +114,10 @@ func (c *Client) ListTasks(ctx context.Context, group, name string) ([]Task, err
 	}
 
 	list := make([]Task, 0, len(kvs))
-	for _, v := range kvs {
+	for k, v := range kvs {
+		if isInternalKey(k) {
+			continue
+		}
 		task := Task{}
 		_ = json.Unmarshal(v, &task)
 		list = append(list, task)
@@ -117,20 +132,167 @@ func (c *Client) ListTasks(ctx context.Context, group, name string) ([]Task, err
 
 func (c *Client) RemoveTask(ctx context.Context, group, name string, uid string) error {
 	keyprefix := path.Join(group, name, uid)
+	_ = c.backend.Del(ctx, controlKeyOf(group, name, uid))
+	_ = removeKeys(ctx, c.backend, logKeyOf(group, name, uid)+"/")
 	return c.backend.Del(ctx, keyprefix)
 }
 
-func (c *Client) WatchTasks(ctx context.Context, group, name string, onchange func(ctx context.Context, task *Task) error) error {
+// OrphanTasks 将执行者为 executer 的未完成任务标记为失败并返回，用于执行者离线后清理任务
+func (c *Client) OrphanTasks(ctx context.Context, executer string) ([]Task, error) {
+	kvs, err := c.backend.List(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	orphaned := []Task{}
+	for k, v := range kvs {
+		if isInternalKey(k) {
+			continue
+		}
+		task := &jsonArgsTask{}
+		if err := json.Unmarshal(v, task); err != nil {
+			continue
+		}
+		if task.Status.Status.IsFinished() || !orphanSteps(task.Steps, executer) {
+			continue
+		}
+		task.Status.Status = TaskStatusError
+		task.Status.Message = fmt.Sprintf("executer %s offline", executer)
+		task.Status.FinishTimestamp = metav1.Now()
+		if err := c.putTask(ctx, task); err != nil {
+			return orphaned, err
+		}
+		content, err := json.Marshal(task)
+		if err != nil {
+			return orphaned, err
+		}
+		out := Task{}
+		_ = json.Unmarshal(content, &out)
+		orphaned = append(orphaned, out)
+	}
+	return orphaned, nil
+}
+
+// orphanSteps 将 executer 正在执行的步骤标记为失败，存在这样的步骤时返回 true
+func orphanSteps(steps []*jsonArgsStep, executer string) bool {
+	found := false
+	for _, step := range steps {
+		if step.Status.Status == TaskStatusRunning && step.Status.Executer == executer {
+			step.Status.Status = TaskStatusError
+			step.Status.Message = fmt.Sprintf("executer %s offline", executer)
+			step.Status.FinishTimestamp = metav1.Now()
+			found = true
+		}
+		if orphanSteps(step.SubSteps, executer) {
+			found = true
+		}
+	}
+	return found
+}
+
+// RetryTask 从失败的步骤重新执行已经结束的任务，已经成功的步骤不会重复执行。
+// fromStep 不为空时，该步骤以及所有依赖于该步骤的步骤也会重新执行。
+func (c *Client) RetryTask(ctx context.Context, group, name string, uid string, fromStep string) error {
+	taskjkey := path.Join(group, name, uid)
+	task, err := c.getTask(ctx, group, name, uid)
+	if err != nil {
+		// 任务已经从 backend 中过期，从历史中恢复
+		if task, err = c.getArchivedTask(ctx, group, name, uid, err); err != nil {
+			return err
+		}
+	}
+	if !task.Status.Status.IsFinished() {
+		return fmt.Errorf("task %s is %s, only finished task can be retried", uid, task.Status.Status)
+	}
+	if fromStep != "" && !resetFrom(task.Steps, fromStep) {
+		return fmt.Errorf("step %s not found in task %s", fromStep, uid)
+	}
+	resetUnsucceeded(task.Steps)
+	task.Status = TaskStatus{Status: TaskStatusPending}
+
+	content, err := json.Marshal(task)
+	if err != nil {
+		return err
+	}
+	if err := c.backend.Put(ctx, taskjkey, content); err != nil {
+		return err
+	}
+	return c.backend.Pub(ctx, "submit", "", content)
+}
+
+// getArchivedTask 从任务历史中获取任务，没有设置任务历史或者历史中不存在时返回 notfound
+func (c *Client) getArchivedTask(ctx context.Context, group, name, uid string, notfound error) (*jsonArgsTask, error) {
+	if c.history == nil {
+		return nil, notfound
+	}
+	archived, err := c.history.Get(ctx, uid)
+	if err != nil || archived.Group != group || archived.Name != name {
+		return nil, notfound
+	}
+	content, err := json.Marshal(archived)
+	if err != nil {
+		return nil, err
+	}
+	task := &jsonArgsTask{}
+	if err := json.Unmarshal(content, task); err != nil {
+		return nil, err
+	}
+	return task, nil
+}
+
+type WatchOptions struct {
+	// 步骤日志更新时调用，为空时不 watch 步骤日志
+	OnStepLog func(ctx context.Context, chunk *StepLogChunk) error
+}
+
+type WatchOption func(*WatchOptions)
+
+// WithStepLogs 同时 watch 任务的步骤日志，onlog 与 onchange 可能会被并发调用
+func WithStepLogs(onlog func(ctx context.Context, chunk *StepLogChunk) error) WatchOption {
+	return func(o *WatchOptions) {
+		o.OnStepLog = onlog
+	}
+}
+
+func (c *Client) WatchTasks(ctx context.Context, group, name string, onchange func(ctx context.Context, task *Task) error, opts ...WatchOption) error {
+	options := &WatchOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
 	keyprefix := group + "/" + name
 	if group == "" && name == "" {
 		keyprefix = ""
 	}
 
-	return c.backend.Watch(ctx, keyprefix, func(ctx context.Context, _ string, val []byte) error {
-		task := &Task{}
-		if err := json.Unmarshal(val, task); err != nil {
-			return err
-		}
-		return onchange(ctx, task)
+	watchtasks := func(ctx context.Context) error {
+		return c.backend.Watch(ctx, keyprefix, func(ctx context.Context, key string, val []byte) error {
+			// 任务被删除或者过期
+			if isInternalKey(key) || len(val) == 0 {
+				return nil
+			}
+			task := &Task{}
+			if err := json.Unmarshal(val, task); err != nil {
+				return err
+			}
+			return onchange(ctx, task)
+		})
+	}
+	if options.OnStepLog == nil {
+		return watchtasks(ctx)
+	}
+
+	eg, ctx := errgroup.WithContext(ctx)
+	eg.Go(func() error {
+		return watchtasks(ctx)
+	})
+	eg.Go(func() error {
+		logprefix := path.Join(logKeyPrefix, group) + "/" + name
+		return c.backend.Watch(ctx, logprefix, func(ctx context.Context, key string, val []byte) error {
+			chunk := &StepLogChunk{}
+			if err := json.Unmarshal(val, chunk); err != nil {
+				return nil // ignore error
+			}
+			return options.OnStepLog(ctx, chunk)
+		})
 	})
+	return eg.Wait()
 }
@@ -0,0 +1,195 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workflow
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"kubegems.io/kubegems/pkg/log"
+)
+
+// 任务控制
+// 控制指令同时存储在 kv 中并通过控制通道广播:
+// - kv 中的指令在 server 每次处理任务时检查，用于处理在队列中等待的任务。
+// - 控制通道用于通知正在执行该任务的 server 立即取消正在执行的步骤。
+
+type TaskControlAction string
+
+const (
+	TaskControlCancel TaskControlAction = "cancel"
+	TaskControlPause  TaskControlAction = "pause"
+)
+
+const (
+	controlChannel   = "control"
+	controlKeyPrefix = "__control__"
+)
+
+type taskControl struct {
+	Group  string            `json:"group,omitempty"`
+	Name   string            `json:"name,omitempty"`
+	UID    string            `json:"uid,omitempty"`
+	Action TaskControlAction `json:"action,omitempty"`
+}
+
+func controlKeyOf(group, name, uid string) string {
+	return path.Join(controlKeyPrefix, group, name, uid)
+}
+
+func isControlKey(key string) bool {
+	return strings.HasPrefix(strings.TrimPrefix(key, "/"), controlKeyPrefix)
+}
+
+// CancelTask 取消任务，正在执行的步骤会被立即取消，未执行的步骤不再执行
+func (c *Client) CancelTask(ctx context.Context, group, name, uid string) error {
+	task, err := c.getTask(ctx, group, name, uid)
+	if err != nil {
+		return err
+	}
+	switch task.Status.Status {
+	case TaskStatusPaused:
+		// 暂停的任务不在队列中，直接标记取消
+		cancelTask(task, "cancelled")
+		return c.putTask(ctx, task)
+	default:
+		if task.Status.Status.IsFinished() {
+			return fmt.Errorf("task %s is already %s", uid, task.Status.Status)
+		}
+	}
+	return c.control(ctx, taskControl{Group: group, Name: name, UID: uid, Action: TaskControlCancel})
+}
+
+// PauseTask 暂停任务，正在执行的步骤完成后不再调度新的步骤，直到 ResumeTask
+func (c *Client) PauseTask(ctx context.Context, group, name, uid string) error {
+	task, err := c.getTask(ctx, group, name, uid)
+	if err != nil {
+		return err
+	}
+	if task.Status.Status.IsFinished() || task.Status.Status == TaskStatusPaused {
+		return fmt.Errorf("task %s is already %s", uid, task.Status.Status)
+	}
+	return c.control(ctx, taskControl{Group: group, Name: name, UID: uid, Action: TaskControlPause})
+}
+
+// ResumeTask 恢复暂停的任务
+func (c *Client) ResumeTask(ctx context.Context, group, name, uid string) error {
+	task, err := c.getTask(ctx, group, name, uid)
+	if err != nil {
+		return err
+	}
+	if err := c.backend.Del(ctx, controlKeyOf(group, name, uid)); err != nil {
+		return err
+	}
+	if task.Status.Status != TaskStatusPaused {
+		// 任务还没有被暂停，移除暂停指令即可
+		return nil
+	}
+	task.Status.Status = TaskStatusRunning
+	task.Status.Message = ""
+	content, err := json.Marshal(task)
+	if err != nil {
+		return err
+	}
+	if err := c.backend.Put(ctx, path.Join(group, name, uid), content); err != nil {
+		return err
+	}
+	return c.backend.Pub(ctx, "submit", "", content)
+}
+
+func (c *Client) control(ctx context.Context, ctrl taskControl) error {
+	content, err := json.Marshal(ctrl)
+	if err != nil {
+		return err
+	}
+	if err := c.backend.Put(ctx, controlKeyOf(ctrl.Group, ctrl.Name, ctrl.UID), content); err != nil {
+		return err
+	}
+	if notifier, ok := c.backend.(ControlBackend); ok {
+		return notifier.Notify(ctx, controlChannel, content)
+	}
+	return nil
+}
+
+func (c *Client) getTask(ctx context.Context, group, name, uid string) (*jsonArgsTask, error) {
+	content, err := c.backend.Get(ctx, path.Join(group, name, uid))
+	if err != nil {
+		return nil, err
+	}
+	task := &jsonArgsTask{}
+	if err := json.Unmarshal(content, task); err != nil {
+		return nil, err
+	}
+	return task, nil
+}
+
+func (c *Client) putTask(ctx context.Context, task *jsonArgsTask) error {
+	return putTask(ctx, c.backend, task)
+}
+
+// cancelTask 将任务以及未完成的步骤标记为取消
+func cancelTask(task *jsonArgsTask, message string) {
+	now := metav1.Now()
+	var cancelSteps func(steps []*jsonArgsStep)
+	cancelSteps = func(steps []*jsonArgsStep) {
+		for _, step := range steps {
+			if !step.Status.Status.IsFinished() {
+				step.Status.Status = TaskStatusCancelled
+				step.Status.Message = message
+				step.Status.FinishTimestamp = now
+			}
+			cancelSteps(step.SubSteps)
+		}
+	}
+	cancelSteps(task.Steps)
+	task.Status.Status = TaskStatusCancelled
+	task.Status.Message = message
+	task.Status.FinishTimestamp = now
+}
+
+// controlOf 读取任务的控制指令
+func (s *Server) controlOf(ctx context.Context, task *jsonArgsTask) TaskControlAction {
+	content, err := s.backend.Get(ctx, controlKeyOf(task.Group, task.Name, task.UID))
+	if err != nil || len(content) == 0 {
+		return ""
+	}
+	ctrl := taskControl{}
+	if err := json.Unmarshal(content, &ctrl); err != nil {
+		return ""
+	}
+	return ctrl.Action
+}
+
+// watchControl 监听控制通道，取消本 server 正在执行的任务
+func (s *Server) watchControl(ctx context.Context, listener ControlBackend) error {
+	return listener.Listen(ctx, controlChannel, func(ctx context.Context, _ string, val []byte) error {
+		ctrl := taskControl{}
+		if err := json.Unmarshal(val, &ctrl); err != nil {
+			return nil // ignore error
+		}
+		if ctrl.Action != TaskControlCancel {
+			return nil
+		}
+		if cancel, ok := s.running.Load(ctrl.UID); ok {
+			log.FromContextOrDiscard(ctx).Info("cancel running task", "uid", ctrl.UID)
+			cancel.(context.CancelFunc)()
+		}
+		return nil
+	})
+}
@@ -0,0 +1,425 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workflow
+
+import (
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// 步骤之间的依赖关系:
+// - 同级步骤中没有任何步骤设置 DependsOn/When 时，按照顺序执行，后一个步骤依赖前一个步骤。
+// - 否则按照 DAG 调度，未设置依赖的步骤为起始步骤，多个步骤依赖同一个步骤时并行执行。
+// - 步骤的 SubSteps 在步骤本身执行成功后调度，所有 SubSteps 完成后该步骤才算完成。
+// - 依赖的步骤失败时，当前步骤被跳过，除非当前步骤设置了 When 条件(用于失败分支)。
+
+const (
+	messageSkippedByCondition  = "skipped: condition not matched"
+	messageSkippedByDependency = "skipped: dependency failed"
+)
+
+func isDAG(steps []*jsonArgsStep) bool {
+	for _, step := range steps {
+		if len(step.DependsOn) > 0 || step.When != nil {
+			return true
+		}
+	}
+	return false
+}
+
+func findStep(steps []*jsonArgsStep, name string) *jsonArgsStep {
+	for _, step := range steps {
+		if step.Name == name {
+			return step
+		}
+		if found := findStep(step.SubSteps, name); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+func indexOfStep(steps []*jsonArgsStep, name string) int {
+	for i, step := range steps {
+		if step.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// phaseOf 返回步骤及其子步骤的整体状态
+func phaseOf(step *jsonArgsStep) TaskStatusCode {
+	switch step.Status.Status {
+	case TaskStatusSuccess:
+		if len(step.SubSteps) == 0 {
+			return TaskStatusSuccess
+		}
+		return phaseOfSteps(step.SubSteps)
+	case "":
+		return TaskStatusPending
+	default:
+		return step.Status.Status
+	}
+}
+
+func phaseOfSteps(steps []*jsonArgsStep) TaskStatusCode {
+	phase := TaskStatusSuccess
+	for _, step := range steps {
+		switch phaseOf(step) {
+		case TaskStatusSuccess, TaskStatusSkipped:
+		case TaskStatusError, TaskStatusCancelled:
+			phase = TaskStatusError
+		default:
+			return TaskStatusRunning
+		}
+	}
+	return phase
+}
+
+// isFailed 依赖步骤失败或者因为依赖失败被跳过
+func isFailed(step *jsonArgsStep) bool {
+	switch phaseOf(step) {
+	case TaskStatusError, TaskStatusCancelled:
+		return true
+	case TaskStatusSkipped:
+		return step.Status.Message == messageSkippedByDependency
+	default:
+		return false
+	}
+}
+
+// firstError 返回第一个失败的步骤
+func firstError(steps []*jsonArgsStep) *jsonArgsStep {
+	for _, step := range steps {
+		if step.Status.Status == TaskStatusError {
+			return step
+		}
+		if failed := firstError(step.SubSteps); failed != nil {
+			return failed
+		}
+	}
+	return nil
+}
+
+func dependenciesOf(steps []*jsonArgsStep, i int, dag bool) []*jsonArgsStep {
+	if !dag {
+		if i == 0 {
+			return nil
+		}
+		return []*jsonArgsStep{steps[i-1]}
+	}
+	deps := []*jsonArgsStep{}
+	for _, name := range steps[i].DependsOn {
+		if idx := indexOfStep(steps, name); idx >= 0 {
+			deps = append(deps, steps[idx])
+		}
+	}
+	return deps
+}
+
+// dependentsOf 返回直接或者间接依赖于 steps[i] 的同级步骤
+func dependentsOf(steps []*jsonArgsStep, i int) []*jsonArgsStep {
+	if !isDAG(steps) {
+		return steps[i+1:]
+	}
+	visited := map[string]bool{steps[i].Name: true}
+	queue := []string{steps[i].Name}
+	dependents := []*jsonArgsStep{}
+	for len(queue) > 0 {
+		name := queue[0]
+		queue = queue[1:]
+		for _, step := range steps {
+			if visited[step.Name] {
+				continue
+			}
+			if containsString(step.DependsOn, name) || (step.When != nil && step.When.Step == name) {
+				visited[step.Name] = true
+				dependents = append(dependents, step)
+				queue = append(queue, step.Name)
+			}
+		}
+	}
+	return dependents
+}
+
+// evalCondition 判断条件是否满足，若条件依赖的步骤尚未完成，则 finished 为 false
+func evalCondition(all []*jsonArgsStep, cond *StepCondition) (matched bool, finished bool) {
+	target := findStep(all, cond.Step)
+	if target == nil {
+		return false, true
+	}
+	phase := phaseOf(target)
+	if !phase.IsFinished() {
+		return false, false
+	}
+	expect := cond.Status
+	if expect == "" {
+		expect = TaskStatusSuccess
+	}
+	matched = phase == expect
+	if matched && cond.Result != "" {
+		matched = len(target.Status.Result) > 0 && fmt.Sprint(target.Status.Result[0]) == cond.Result
+	}
+	if cond.Not {
+		matched = !matched
+	}
+	return matched, true
+}
+
+// schedule 返回当前可以执行的步骤，没有 Function 的步骤以及需要跳过的步骤会直接更新状态，
+// 若有状态更新则 changed 为 true，需要重新调度。
+func schedule(steps []*jsonArgsStep, all []*jsonArgsStep) (ready []*jsonArgsStep, changed bool) {
+	dag := isDAG(steps)
+	for i, step := range steps {
+		switch step.Status.Status {
+		case "", TaskStatusPending, TaskStatusRunning:
+			waiting, failed := false, false
+			for _, dep := range dependenciesOf(steps, i, dag) {
+				if isFailed(dep) {
+					failed = true
+				} else if !phaseOf(dep).IsFinished() {
+					waiting = true
+				}
+			}
+			if waiting {
+				continue
+			}
+			if step.When != nil {
+				matched, finished := evalCondition(all, step.When)
+				if !finished {
+					continue
+				}
+				if !matched {
+					skipStep(step, messageSkippedByCondition)
+					changed = true
+					continue
+				}
+			} else if failed {
+				skipStep(step, messageSkippedByDependency)
+				changed = true
+				continue
+			}
+			if step.Function == "" {
+				// 没有执行任务，可以继续寻找下一个可执行任务
+				now := metav1.Now()
+				step.Status = TaskStatus{Status: TaskStatusSuccess, StartTimestamp: now, FinishTimestamp: now}
+				changed = true
+				continue
+			}
+			ready = append(ready, step)
+		case TaskStatusSuccess:
+			subready, subchanged := schedule(step.SubSteps, all)
+			ready = append(ready, subready...)
+			changed = changed || subchanged
+		}
+	}
+	return ready, changed
+}
+
+func skipStep(step *jsonArgsStep, message string) {
+	now := metav1.Now()
+	step.Status = TaskStatus{
+		Status:          TaskStatusSkipped,
+		Message:         message,
+		StartTimestamp:  now,
+		FinishTimestamp: now,
+	}
+}
+
+func resetStep(step *jsonArgsStep) {
+	step.Status = TaskStatus{}
+	for _, sub := range step.SubSteps {
+		resetStep(sub)
+	}
+}
+
+// resetUnsucceeded 重置所有未成功的步骤，已成功的步骤不会重新执行
+func resetUnsucceeded(steps []*jsonArgsStep) {
+	for _, step := range steps {
+		if phaseOf(step) == TaskStatusSuccess {
+			continue
+		}
+		if step.Status.Status == TaskStatusSuccess {
+			resetUnsucceeded(step.SubSteps)
+			continue
+		}
+		resetStep(step)
+	}
+}
+
+// resetFrom 重置名称为 name 的步骤以及所有依赖于它的步骤
+func resetFrom(steps []*jsonArgsStep, name string) bool {
+	for i, step := range steps {
+		if step.Name == name {
+			resetStep(step)
+		} else if !resetFrom(step.SubSteps, name) {
+			continue
+		}
+		for _, dependent := range dependentsOf(steps, i) {
+			resetStep(dependent)
+		}
+		return true
+	}
+	return false
+}
+
+// validateSteps 校验步骤之间的依赖关系，依赖的步骤必须存在且整个步骤树中不能存在环
+func validateSteps(steps []Step, all []Step) error {
+	if err := validateLevel(steps, all); err != nil {
+		return err
+	}
+	return checkCycle(steps, all)
+}
+
+func validateLevel(steps []Step, all []Step) error {
+	names := map[string]bool{}
+	dag := isStepsDAG(steps)
+	for _, step := range steps {
+		if dag {
+			if step.Name == "" {
+				return fmt.Errorf("step name is required when steps have dependencies")
+			}
+			if names[step.Name] {
+				return fmt.Errorf("duplicated step name %s", step.Name)
+			}
+			names[step.Name] = true
+		}
+		if step.When != nil && !stepExists(all, step.When.Step) {
+			return fmt.Errorf("step %s: condition step %s not found", step.Name, step.When.Step)
+		}
+		if err := validateLevel(step.SubSteps, all); err != nil {
+			return err
+		}
+	}
+	for _, step := range steps {
+		for _, dep := range step.DependsOn {
+			if !names[dep] {
+				return fmt.Errorf("step %s: dependency %s not found", step.Name, dep)
+			}
+		}
+	}
+	return nil
+}
+
+func isStepsDAG(steps []Step) bool {
+	for _, step := range steps {
+		if len(step.DependsOn) > 0 || step.When != nil {
+			return true
+		}
+	}
+	return false
+}
+
+func stepExists(steps []Step, name string) bool {
+	return lookupStep(steps, name) != nil
+}
+
+// lookupStep 与 findStep 一致，返回第一个名称匹配的步骤
+func lookupStep(steps []Step, name string) *Step {
+	for i := range steps {
+		if steps[i].Name == name {
+			return &steps[i]
+		}
+		if found := lookupStep(steps[i].SubSteps, name); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+// checkCycle 检查整个步骤树中的环，每个步骤分为开始和结束两个节点，边表示先后顺序:
+// 步骤开始后才结束；父步骤开始后子步骤才开始，子步骤结束后父步骤才结束；
+// 依赖及条件中的步骤结束后当前步骤才开始，条件可以指向其他层级的步骤。
+func checkCycle(steps []Step, all []Step) error {
+	type node struct {
+		step *Step
+		end  bool
+	}
+	edges := map[node][]node{}
+	var build func(steps []Step, parent *Step)
+	build = func(steps []Step, parent *Step) {
+		dag := isStepsDAG(steps)
+		for i := range steps {
+			step := &steps[i]
+			start, end := node{step: step}, node{step: step, end: true}
+			edges[start] = append(edges[start], end)
+			if parent != nil {
+				edges[node{step: parent}] = append(edges[node{step: parent}], start)
+				edges[end] = append(edges[end], node{step: parent, end: true})
+			}
+			deps := []*Step{}
+			if !dag && i > 0 {
+				deps = append(deps, &steps[i-1])
+			}
+			for _, name := range step.DependsOn {
+				for j := range steps {
+					if steps[j].Name == name {
+						deps = append(deps, &steps[j])
+					}
+				}
+			}
+			if step.When != nil {
+				if target := lookupStep(all, step.When.Step); target != nil {
+					deps = append(deps, target)
+				}
+			}
+			for _, dep := range deps {
+				edges[node{step: dep, end: true}] = append(edges[node{step: dep, end: true}], start)
+			}
+			build(step.SubSteps, step)
+		}
+	}
+	build(steps, nil)
+
+	const (
+		visiting = 1
+		visited  = 2
+	)
+	states := map[node]int{}
+	var visit func(n node) error
+	visit = func(n node) error {
+		switch states[n] {
+		case visiting:
+			return fmt.Errorf("circular dependency found at step %s", n.step.Name)
+		case visited:
+			return nil
+		}
+		states[n] = visiting
+		for _, next := range edges[n] {
+			if err := visit(next); err != nil {
+				return err
+			}
+		}
+		states[n] = visited
+		return nil
+	}
+	for n := range edges {
+		if err := visit(n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
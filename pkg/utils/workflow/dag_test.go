@@ -0,0 +1,203 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workflow
+
+import (
+	"reflect"
+	"testing"
+)
+
+func namesOf(steps []*jsonArgsStep) []string {
+	names := []string{}
+	for _, step := range steps {
+		names = append(names, step.Name)
+	}
+	return names
+}
+
+func finish(steps []*jsonArgsStep, status TaskStatusCode, result ...interface{}) {
+	for _, step := range steps {
+		step.Status = TaskStatus{Status: status, Result: result}
+	}
+}
+
+func TestSchedule(t *testing.T) {
+	steps := []*jsonArgsStep{
+		{Name: "git", Function: "fn"},
+		{Name: "sync-a", Function: "fn", DependsOn: []string{"git"}},
+		{Name: "sync-b", Function: "fn", DependsOn: []string{"git"}},
+		{Name: "wait", Function: "fn", DependsOn: []string{"sync-a", "sync-b"}},
+		{Name: "rollback", Function: "fn", DependsOn: []string{"wait"}, When: &StepCondition{Step: "wait", Status: TaskStatusError}},
+	}
+
+	ready, _ := schedule(steps, steps)
+	if got := namesOf(ready); !reflect.DeepEqual(got, []string{"git"}) {
+		t.Fatalf("schedule() = %v, want [git]", got)
+	}
+	finish(ready, TaskStatusSuccess)
+
+	// fan-out
+	ready, _ = schedule(steps, steps)
+	if got := namesOf(ready); !reflect.DeepEqual(got, []string{"sync-a", "sync-b"}) {
+		t.Fatalf("schedule() = %v, want [sync-a sync-b]", got)
+	}
+	finish(ready[:1], TaskStatusSuccess)
+
+	// fan-in waits for all dependencies
+	ready, _ = schedule(steps, steps)
+	if got := namesOf(ready); !reflect.DeepEqual(got, []string{"sync-b"}) {
+		t.Fatalf("schedule() = %v, want [sync-b]", got)
+	}
+	finish(ready, TaskStatusSuccess)
+
+	ready, _ = schedule(steps, steps)
+	if got := namesOf(ready); !reflect.DeepEqual(got, []string{"wait"}) {
+		t.Fatalf("schedule() = %v, want [wait]", got)
+	}
+	finish(ready, TaskStatusSuccess)
+
+	// condition not matched, rollback skipped
+	ready, changed := schedule(steps, steps)
+	if len(ready) != 0 || !changed {
+		t.Fatalf("schedule() = %v, changed %v, want [] and changed", namesOf(ready), changed)
+	}
+	if steps[4].Status.Status != TaskStatusSkipped {
+		t.Fatalf("rollback status = %s, want Skipped", steps[4].Status.Status)
+	}
+	if phase := phaseOfSteps(steps); phase != TaskStatusSuccess {
+		t.Fatalf("phaseOfSteps() = %s, want Success", phase)
+	}
+}
+
+func TestScheduleSerialFailure(t *testing.T) {
+	steps := []*jsonArgsStep{
+		{Name: "a", Function: "fn"},
+		{Name: "b", Function: "fn"},
+		{Name: "c", Function: "fn"},
+	}
+	finish(steps[:1], TaskStatusError)
+
+	for {
+		ready, changed := schedule(steps, steps)
+		if len(ready) != 0 {
+			t.Fatalf("schedule() = %v, want none", namesOf(ready))
+		}
+		if !changed {
+			break
+		}
+	}
+	for _, step := range steps[1:] {
+		if step.Status.Status != TaskStatusSkipped {
+			t.Errorf("step %s status = %s, want Skipped", step.Name, step.Status.Status)
+		}
+	}
+	if phase := phaseOfSteps(steps); phase != TaskStatusError {
+		t.Fatalf("phaseOfSteps() = %s, want Error", phase)
+	}
+
+	// retry from failed step
+	resetUnsucceeded(steps)
+	ready, _ := schedule(steps, steps)
+	if got := namesOf(ready); !reflect.DeepEqual(got, []string{"a"}) {
+		t.Fatalf("schedule() after retry = %v, want [a]", got)
+	}
+}
+
+func TestResetFrom(t *testing.T) {
+	steps := []*jsonArgsStep{
+		{Name: "a", Function: "fn"},
+		{Name: "b", Function: "fn", DependsOn: []string{"a"}},
+		{Name: "c", Function: "fn", DependsOn: []string{"a"}},
+		{Name: "d", Function: "fn", DependsOn: []string{"b"}},
+	}
+	finish(steps, TaskStatusSuccess)
+
+	if !resetFrom(steps, "b") {
+		t.Fatal("resetFrom() = false, want true")
+	}
+	want := []TaskStatusCode{TaskStatusSuccess, "", TaskStatusSuccess, ""}
+	for i, step := range steps {
+		if step.Status.Status != want[i] {
+			t.Errorf("step %s status = %q, want %q", step.Name, step.Status.Status, want[i])
+		}
+	}
+	if resetFrom(steps, "not-exists") {
+		t.Error("resetFrom() = true for missing step, want false")
+	}
+}
+
+func TestValidateSteps(t *testing.T) {
+	tests := []struct {
+		name    string
+		steps   []Step
+		wantErr bool
+	}{
+		{
+			name:  "serial",
+			steps: []Step{{Function: "fn"}, {Function: "fn"}},
+		},
+		{
+			name:    "missing dependency",
+			steps:   []Step{{Name: "a"}, {Name: "b", DependsOn: []string{"c"}}},
+			wantErr: true,
+		},
+		{
+			name:    "cycle",
+			steps:   []Step{{Name: "a", DependsOn: []string{"b"}}, {Name: "b", DependsOn: []string{"a"}}},
+			wantErr: true,
+		},
+		{
+			name: "condition on another level",
+			steps: []Step{
+				{Name: "a", SubSteps: []Step{{Name: "a1", Function: "fn"}}},
+				{Name: "b", DependsOn: []string{"a"}, When: &StepCondition{Step: "a1"}},
+			},
+		},
+		{
+			name: "cycle through sub step condition",
+			steps: []Step{
+				{Name: "a", When: &StepCondition{Step: "b1"}},
+				{Name: "b", DependsOn: []string{"a"}, SubSteps: []Step{{Name: "b1", Function: "fn"}}},
+			},
+			wantErr: true,
+		},
+		{
+			name:    "sub step waits for its parent",
+			steps:   []Step{{Name: "a", SubSteps: []Step{{Name: "a1", When: &StepCondition{Step: "a"}}}}},
+			wantErr: true,
+		},
+		{
+			name:    "duplicated name",
+			steps:   []Step{{Name: "a"}, {Name: "a", DependsOn: []string{"a"}}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateSteps(tt.steps, tt.steps); (err != nil) != tt.wantErr {
+				t.Errorf("validateSteps() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRetryPolicy_BackoffOf(t *testing.T) {
+	p := &RetryPolicy{Backoff: 2, Factor: 2, MaxBackoff: 10}
+	for attempt, want := range map[int]int64{1: 2, 2: 4, 3: 8, 4: 10} {
+		if got := p.BackoffOf(attempt); int64(got) != want {
+			t.Errorf("BackoffOf(%d) = %d, want %d", attempt, got, want)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workflow
+
+import (
+	"context"
+	"errors"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"kubegems.io/kubegems/pkg/log"
+)
+
+// 执行者心跳
+// server 运行期间定期写入带有 ttl 的心跳，心跳过期说明执行者已经离线，
+// 其正在执行的步骤无法继续，可以通过 Client.OrphanTasks 清理。
+
+const (
+	heartbeatKeyPrefix        = "__heartbeat__"
+	ExecuterHeartbeatInterval = 30 * time.Second
+	ExecuterHeartbeatTTL      = 3 * ExecuterHeartbeatInterval
+)
+
+func heartbeatKeyOf(executer string) string {
+	return path.Join(heartbeatKeyPrefix, executer)
+}
+
+func isHeartbeatKey(key string) bool {
+	return strings.HasPrefix(strings.TrimPrefix(key, "/"), heartbeatKeyPrefix)
+}
+
+// heartbeat 定期刷新本 server 的心跳直到 ctx 结束
+func (s *Server) heartbeat(ctx context.Context) {
+	log := log.FromContextOrDiscard(ctx)
+	for {
+		now := []byte(time.Now().Format(time.RFC3339))
+		if err := s.backend.Put(ctx, heartbeatKeyOf(s.executerid), now, ExecuterHeartbeatTTL); err != nil {
+			log.Error(err, "refresh executer heartbeat")
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(ExecuterHeartbeatInterval):
+		}
+	}
+}
+
+// OfflineExecuters 返回正在执行步骤但是心跳已经过期的执行者
+func (c *Client) OfflineExecuters(ctx context.Context) ([]string, error) {
+	tasks, err := c.ListTasks(ctx, "", "")
+	if err != nil {
+		return nil, err
+	}
+	executers := map[string]bool{}
+	var collect func(steps []Step)
+	collect = func(steps []Step) {
+		for _, step := range steps {
+			if step.Status != nil && step.Status.Status == TaskStatusRunning && step.Status.Executer != "" {
+				executers[step.Status.Executer] = true
+			}
+			collect(step.SubSteps)
+		}
+	}
+	for _, task := range tasks {
+		if task.Status != nil && !task.Status.Status.IsFinished() {
+			collect(task.Steps)
+		}
+	}
+	offline := []string{}
+	for executer := range executers {
+		alive, err := c.ExecuterAlive(ctx, executer)
+		if err != nil {
+			return nil, err
+		}
+		if !alive {
+			offline = append(offline, executer)
+		}
+	}
+	return offline, nil
+}
+
+// ExecuterAlive 执行者的心跳未过期时返回 true
+func (c *Client) ExecuterAlive(ctx context.Context, executer string) (bool, error) {
+	_, err := c.backend.Get(ctx, heartbeatKeyOf(executer))
+	switch {
+	case err == nil:
+		return true, nil
+	case errors.Is(err, ErrKeyNotFound), errors.Is(err, redis.Nil):
+		return false, nil
+	default:
+		return false, err
+	}
+}
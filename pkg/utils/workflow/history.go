@@ -0,0 +1,217 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workflow
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"kubegems.io/kubegems/pkg/log"
+	"kubegems.io/kubegems/pkg/utils/database"
+	"kubegems.io/kubegems/pkg/utils/httputil/response"
+)
+
+// TaskEvent 任务生命周期事件
+type TaskEvent string
+
+const (
+	TaskEventFinished TaskEvent = "Finished" // 任务执行结束
+	TaskEventExpired  TaskEvent = "Expired"  // 任务超过保留时间被清理
+	TaskEventOrphaned TaskEvent = "Orphaned" // 任务的执行者长时间离线
+)
+
+// TaskHook 任务生命周期事件发生时调用
+type TaskHook func(ctx context.Context, event TaskEvent, task *Task)
+
+// TaskHistory 持久化至数据库的任务记录，Content 中保存完整的任务以及步骤
+type TaskHistory struct {
+	ID                uint           `gorm:"primarykey" json:"id"`
+	UID               string         `gorm:"size:64;uniqueIndex" json:"uid"`
+	Group             string         `gorm:"size:128;index" json:"group"`
+	Name              string         `gorm:"size:512;index" json:"name"`
+	Status            TaskStatusCode `gorm:"size:32;index" json:"status"`
+	Executer          string         `gorm:"size:128;index" json:"executer"`
+	Message           string         `gorm:"type:text" json:"message"`
+	Event             TaskEvent      `gorm:"size:32" json:"event"` // 最近一次记录的事件
+	CreationTimestamp time.Time      `gorm:"index" json:"creationTimestamp"`
+	StartTimestamp    *time.Time     `json:"startTimestamp"`
+	FinishTimestamp   *time.Time     `json:"finishTimestamp"`
+	Content           datatypes.JSON `json:"content"`
+	UpdatedAt         time.Time      `json:"updatedAt"`
+}
+
+// TaskHistoryQuery 任务历史查询条件，为空的条件不生效
+type TaskHistoryQuery struct {
+	Group    string
+	Name     string // 前缀匹配
+	Status   []TaskStatusCode
+	Executer string
+	Event    TaskEvent
+	Since    time.Time // 按照任务创建时间过滤
+	Until    time.Time
+	Page     int
+	Size     int
+}
+
+// HistoryStore 任务历史存储，数据表随 models.MigrateModels 迁移
+type HistoryStore struct {
+	db *gorm.DB
+}
+
+func NewHistoryStore(db *database.Database) *HistoryStore {
+	return &HistoryStore{db: db.DB()}
+}
+
+// Save 保存或者更新任务记录
+func (s *HistoryStore) Save(ctx context.Context, event TaskEvent, task *Task) error {
+	history, err := historyOf(event, task)
+	if err != nil {
+		return err
+	}
+	return s.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "uid"}},
+		UpdateAll: true,
+	}).Create(history).Error
+}
+
+// Archive 保存任务记录，记录已存在时不做修改
+func (s *HistoryStore) Archive(ctx context.Context, event TaskEvent, task *Task) error {
+	history, err := historyOf(event, task)
+	if err != nil {
+		return err
+	}
+	return s.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "uid"}},
+		DoNothing: true,
+	}).Create(history).Error
+}
+
+func historyOf(event TaskEvent, task *Task) (*TaskHistory, error) {
+	content, err := json.Marshal(task)
+	if err != nil {
+		return nil, err
+	}
+	history := &TaskHistory{
+		UID:               task.UID,
+		Group:             task.Group,
+		Name:              task.Name,
+		Event:             event,
+		CreationTimestamp: task.CreationTimestamp.Time,
+		Executer:          executerOf(task.Steps),
+		Content:           content,
+	}
+	if task.Status != nil {
+		history.Status = task.Status.Status
+		history.Message = task.Status.Message
+		if !task.Status.StartTimestamp.IsZero() {
+			history.StartTimestamp = &task.Status.StartTimestamp.Time
+		}
+		if !task.Status.FinishTimestamp.IsZero() {
+			history.FinishTimestamp = &task.Status.FinishTimestamp.Time
+		}
+	}
+	return history, nil
+}
+
+// Hook 返回将任务事件记录至数据库的 TaskHook
+func (s *HistoryStore) Hook() TaskHook {
+	return func(ctx context.Context, event TaskEvent, task *Task) {
+		if err := s.Save(ctx, event, task); err != nil {
+			log.FromContextOrDiscard(ctx).Error(err, "save task history", "uid", task.UID, "event", event)
+		}
+	}
+}
+
+// Get 获取任务记录中的完整任务
+func (s *HistoryStore) Get(ctx context.Context, uid string) (*Task, error) {
+	history := &TaskHistory{}
+	if err := s.db.WithContext(ctx).Where("uid = ?", uid).Take(history).Error; err != nil {
+		return nil, err
+	}
+	task := &Task{}
+	if err := json.Unmarshal(history.Content, task); err != nil {
+		return nil, err
+	}
+	return task, nil
+}
+
+// Query 按照条件分页查询任务记录，结果按照创建时间倒序
+func (s *HistoryStore) Query(ctx context.Context, query TaskHistoryQuery) (*response.Page[TaskHistory], error) {
+	if query.Page < 1 {
+		query.Page = 1
+	}
+	if query.Size < 1 {
+		query.Size = response.DefaultPageSize
+	}
+
+	db := s.db.WithContext(ctx).Model(&TaskHistory{})
+	if query.Group != "" {
+		db = db.Where("`group` = ?", query.Group)
+	}
+	if query.Name != "" {
+		db = db.Where("name LIKE ? ESCAPE '!'", likePrefix(query.Name))
+	}
+	if len(query.Status) > 0 {
+		db = db.Where("status IN ?", query.Status)
+	}
+	if query.Executer != "" {
+		db = db.Where("executer = ?", query.Executer)
+	}
+	if query.Event != "" {
+		db = db.Where("event = ?", query.Event)
+	}
+	if !query.Since.IsZero() {
+		db = db.Where("creation_timestamp >= ?", query.Since)
+	}
+	if !query.Until.IsZero() {
+		db = db.Where("creation_timestamp < ?", query.Until)
+	}
+
+	var total int64
+	if err := db.Count(&total).Error; err != nil {
+		return nil, err
+	}
+	list := []TaskHistory{}
+	if err := db.Order("creation_timestamp DESC").
+		Offset((query.Page - 1) * query.Size).
+		Limit(query.Size).
+		Find(&list).Error; err != nil {
+		return nil, err
+	}
+	return &response.Page[TaskHistory]{
+		Total: total,
+		List:  list,
+		Page:  int64(query.Page),
+		Size:  int64(query.Size),
+	}, nil
+}
+
+// executerOf 返回最后一个执行步骤的执行者
+func executerOf(steps []Step) string {
+	executer := ""
+	for _, step := range steps {
+		if step.Status != nil && step.Status.Executer != "" {
+			executer = step.Status.Executer
+		}
+		if sub := executerOf(step.SubSteps); sub != "" {
+			executer = sub
+		}
+	}
+	return executer
+}
@@ -20,8 +20,9 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"path"
 	"reflect"
-	"strings"
+	"sync"
 	"time"
 
 	"github.com/go-logr/logr"
@@ -34,6 +35,8 @@ import (
 
 const (
 	DefaultTaskTimeout = 5 * time.Minute
+	// 已结束的任务在 backend 中保留的时间，未结束的任务(包括暂停的任务)不会过期
+	FinishedTaskTTL = 7 * 24 * time.Hour
 )
 
 type Options struct {
@@ -46,6 +49,8 @@ type Server struct {
 	backend    Backend
 	registered map[string]interface{}
 	executerid string
+	running    sync.Map // uid -> context.CancelFunc, 正在执行的任务
+	hooks      []TaskHook
 }
 
 func NewServerFromRedisClient(cli *redis.Client) *Server {
@@ -70,8 +75,25 @@ func (s *Server) NewClient(ctx context.Context) *Client {
 	return NewClientFromBackend(s.backend)
 }
 
+// AddHook 添加任务结束时的回调，需要在 Run 之前调用
+func (s *Server) AddHook(hook TaskHook) {
+	s.hooks = append(s.hooks, hook)
+}
+
 func (s *Server) Run(ctx context.Context) error {
 	log := log.FromContextOrDiscard(ctx)
+	// 心跳用于判断执行者是否离线
+	go s.heartbeat(ctx)
+	// watch control channel
+	if listener, ok := s.backend.(ControlBackend); ok {
+		go retry.OnError(retry.NotContextCancelError, func() error {
+			if err := s.watchControl(ctx, listener); err != nil {
+				log.Error(err, "watch control failed, retry...")
+				return err
+			}
+			return nil
+		})
+	}
 	// consume submit queue
 	return retry.OnError(retry.NotContextCancelError, func() error {
 		log.Info("starting work consumer...")
@@ -111,97 +133,232 @@ func (s *Server) consume(ctx context.Context, _ string, val []byte) error {
 	return nil
 }
 
-// 每次寻找一个没有处理完成的 task 中的一个 stask 进行处理
+// 每次寻找没有处理完成的 task 中所有可以执行的 step 进行处理,可以并行的 step 会同时执行
 func (s *Server) process(ctx context.Context, task *jsonArgsTask) bool {
 	// foreach task
 	if task.UID == "" {
 		task.UID = uuid.New().String()
 	}
-	if err := s.processone(ctx, task, task.Steps); err != nil {
-		// 如果出错了 也为finished
-		task.Status.FinishTimestamp = metav1.Now()
-		task.Status.Status = TaskStatusError
-		task.Status.Message = err.Error()
-		_ = s.updateTask(ctx, task)
+	if s.processControl(ctx, task) {
 		return true
-	} else if isAllFinished(task.Steps) {
+	}
+	if ready := nextSteps(task, nil); len(ready) > 0 {
+		s.processone(ctx, task, ready)
+		if s.processControl(ctx, task) {
+			return true
+		}
+		// 执行完成后重新入队，寻找下一批可执行的 step
+		return false
+	}
+
+	task.Status.FinishTimestamp = metav1.Now()
+	switch phaseOfSteps(task.Steps) {
+	case TaskStatusSuccess:
 		// 如果所有子任务都完成则为 finished
-		task.Status.FinishTimestamp = metav1.Now()
 		task.Status.Status = TaskStatusSuccess
-		_ = s.updateTask(ctx, task)
-		return true
-	} else {
-		// 否则未完成，进入队列执行下一个任务
+	case TaskStatusError:
+		// 如果出错了 也为finished
+		task.Status.Status = TaskStatusError
+		if failed := firstError(task.Steps); failed != nil {
+			task.Status.Message = failed.Status.Message
+		}
+	default:
+		// 没有可执行的 step 但是任务未完成，说明依赖无法满足
+		task.Status.Status = TaskStatusError
+		task.Status.Message = "no runnable steps, check step dependencies"
+	}
+	_ = s.updateTask(ctx, task)
+	s.runHooks(ctx, TaskEventFinished, task)
+	return true
+}
+
+// processControl 处理任务的控制指令，如果任务被取消或者暂停则返回 true
+func (s *Server) processControl(ctx context.Context, task *jsonArgsTask) bool {
+	log := log.FromContextOrDiscard(ctx)
+
+	switch s.controlOf(ctx, task) {
+	case TaskControlCancel:
+		log.Info("task cancelled")
+		cancelTask(task, "cancelled")
+	case TaskControlPause:
+		log.Info("task paused")
+		task.Status.Status = TaskStatusPaused
+		task.Status.Message = "paused"
+	default:
 		return false
 	}
+	_ = s.updateTask(ctx, task)
+	_ = s.backend.Del(ctx, controlKeyOf(task.Group, task.Name, task.UID))
+	if task.Status.Status.IsFinished() {
+		s.runHooks(ctx, TaskEventFinished, task)
+	}
+	return true
 }
 
-func isAllFinished(steps []*jsonArgsStep) bool {
-	for _, step := range steps {
-		if step.Status.Status != TaskStatusSuccess {
-			return false
+func (s *Server) runHooks(ctx context.Context, event TaskEvent, task *jsonArgsTask) {
+	if len(s.hooks) == 0 {
+		return
+	}
+	// 转换为 Task 供 hook 使用
+	content, err := json.Marshal(task)
+	if err != nil {
+		return
+	}
+	out := &Task{}
+	if err := json.Unmarshal(content, out); err != nil {
+		return
+	}
+	for _, hook := range s.hooks {
+		hook(ctx, event, out)
+	}
+}
+
+// nextSteps 返回可以执行且不在 running 中的 step，需要跳过的 step 以及没有 Function 的 step 会直接更新状态
+func nextSteps(task *jsonArgsTask, running map[*jsonArgsStep]bool) []*jsonArgsStep {
+	for {
+		ready, changed := schedule(task.Steps, task.Steps)
+		notrunning := make([]*jsonArgsStep, 0, len(ready))
+		for _, step := range ready {
+			if !running[step] {
+				notrunning = append(notrunning, step)
+			}
 		}
-		if !isAllFinished(step.SubSteps) {
-			return false
+		if len(notrunning) > 0 || !changed {
+			return notrunning
 		}
 	}
-	return true
 }
 
-func (s *Server) processone(ctx context.Context, task *jsonArgsTask, steps []*jsonArgsStep) error {
+// processone 并行执行一批 step, 每个 step 完成后立即调度依赖于它的 step，所有 step 执行完成后返回
+func (s *Server) processone(ctx context.Context, task *jsonArgsTask, steps []*jsonArgsStep) {
 	// 准备带value的context
 	ctx = WithValues(ctx, task.Addtionals)
 
-	for _, step := range steps {
-		switch step.Status.Status {
-		case "", TaskStatusRunning:
-			// save init state
+	// 可以通过控制通道取消
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	s.running.Store(task.UID, cancel)
+	defer s.running.Delete(task.UID)
+
+	if !task.Status.Status.IsFinished() && task.Status.Status != TaskStatusRunning {
+		task.Status.Status = TaskStatusRunning
+		task.Status.StartTimestamp = metav1.Now()
+	}
+
+	mu := sync.Mutex{}
+	running := map[*jsonArgsStep]bool{}
+	finished := make(chan *jsonArgsStep)
+	start := func(steps []*jsonArgsStep) {
+		// save init state
+		for _, step := range steps {
 			step.Status = TaskStatus{
 				Status:         TaskStatusRunning,
 				StartTimestamp: metav1.Now(),
 				Executer:       s.executerid,
 			}
-
-			_ = s.updateTask(ctx, task)
-			if step.Function != "" {
-				if err := s.execute(ctx, step); err != nil {
-					step.Status.Status = TaskStatusError
-					step.Status.Message = err.Error()
-					// 如果出错则终止执行
-					step.Status.FinishTimestamp = metav1.Now()
-					_ = s.updateTask(ctx, task)
-					return err
-				} else {
-					step.Status.Status = TaskStatusSuccess
-					step.Status.FinishTimestamp = metav1.Now()
+			running[step] = true
+		}
+		_ = s.updateTask(ctx, task)
+		for _, step := range steps {
+			go func(step *jsonArgsStep) {
+				// 步骤执行期间的日志写入步骤日志
+				logs := newStepLogWriter(s.backend, task, step)
+				s.executeWithRetry(withStepLogger(ctx, logs), step, func(status TaskStatus) {
+					mu.Lock()
+					defer mu.Unlock()
+					step.Status = status
 					_ = s.updateTask(ctx, task)
-					// 如果step执行成功，则返回 nil 重新入队
-					// 如果不返回nil则只需执行，直到错误或者完成
-					return nil
-				}
-			} else {
-				// 没有执行任务，可以继续寻找下一个可执行任务
-				step.Status.FinishTimestamp = metav1.Now()
-				step.Status.Status = TaskStatusSuccess
+				})
+				logs.Close()
+				finished <- step
+			}(step)
+		}
+	}
+
+	mu.Lock()
+	start(steps)
+	mu.Unlock()
+	for len(running) > 0 {
+		step := <-finished
+		mu.Lock()
+		delete(running, step)
+		// 任务被取消或者暂停时不再调度新的 step，等待正在执行的 step 完成
+		if ctx.Err() == nil && s.controlOf(ctx, task) == "" {
+			if ready := nextSteps(task, running); len(ready) > 0 {
+				start(ready)
 			}
-		case TaskStatusError:
-			return errors.New(step.Status.Message) // 因为失败，所以认为已经完成所有阶段
 		}
-		// 执行 substeps
-		if err := s.processone(ctx, task, step.SubSteps); err != nil {
-			return err
+		mu.Unlock()
+	}
+}
+
+// executeWithRetry 执行 step，失败时按照 step 的重试策略重试，每次状态变化时调用 update
+func (s *Server) executeWithRetry(ctx context.Context, step *jsonArgsStep, update func(status TaskStatus)) {
+	log := log.FromContextOrDiscard(ctx)
+
+	exec := *step
+	status := exec.Status
+	for attempt := 1; ; attempt++ {
+		exec.Status.Result = nil
+		err := s.execute(ctx, &exec)
+		status.Attempts = attempt
+		status.Result = exec.Status.Result
+		if err == nil {
+			status.Status = TaskStatusSuccess
+			status.Message = ""
+			status.FinishTimestamp = metav1.Now()
+			update(status)
+			return
+		}
+		status.Message = err.Error()
+		if errors.Is(ctx.Err(), context.Canceled) {
+			status.Status = TaskStatusCancelled
+			status.FinishTimestamp = metav1.Now()
+			update(status)
+			return
+		}
+		if step.Retry == nil || attempt > step.Retry.Limit || ctx.Err() != nil {
+			// 如果出错则终止执行
+			status.Status = TaskStatusError
+			status.FinishTimestamp = metav1.Now()
+			update(status)
+			return
+		}
+		// 更新重试状态
+		update(status)
+
+		backoff := step.Retry.BackoffOf(attempt)
+		log.Info("retry step", "step", step.Name, "attempt", attempt, "backoff", backoff.String())
+		select {
+		case <-ctx.Done():
+			status.Status = TaskStatusCancelled
+			status.FinishTimestamp = metav1.Now()
+			update(status)
+			return
+		case <-time.After(backoff):
 		}
 	}
-	return nil
 }
 
 func (n *Server) updateTask(ctx context.Context, task *jsonArgsTask) error {
+	return putTask(ctx, n.backend, task)
+}
+
+// putTask 保存任务，已结束的任务在 FinishedTaskTTL 后过期
+func putTask(ctx context.Context, backend Backend, task *jsonArgsTask) error {
 	content, err := json.Marshal(task)
 	if err != nil {
 		return err
 	}
-	taskjkey := strings.Join([]string{task.Group, task.Name, task.UID}, "/")
-	return n.backend.Put(ctx, taskjkey, content, 5*time.Minute)
+	taskjkey := path.Join(task.Group, task.Name, task.UID)
+	if !task.Status.Status.IsFinished() {
+		return backend.Put(ctx, taskjkey, content)
+	}
+	// 步骤日志与任务一同过期
+	if err := expireStepLogs(ctx, backend, task, FinishedTaskTTL); err != nil {
+		log.FromContextOrDiscard(ctx).Error(err, "expire step logs", "uid", task.UID)
+	}
+	return backend.Put(ctx, taskjkey, content, FinishedTaskTTL)
 }
 
 func (n *Server) Register(name string, fun interface{}) error {
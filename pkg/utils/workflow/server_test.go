@@ -17,11 +17,16 @@ package workflow
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"path"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/alicebob/miniredis/v2"
+	"github.com/go-logr/logr"
 	"github.com/go-redis/redis/v8"
 )
 
@@ -206,3 +211,570 @@ func TestServer_execute(t *testing.T) {
 		})
 	}
 }
+
+func waitTask(ctx context.Context, t *testing.T, cli *Client, name string, cond func(task Task) bool) Task {
+	for {
+		tasks, err := cli.ListTasks(ctx, "test", name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, task := range tasks {
+			if task.Status != nil && cond(task) {
+				return task
+			}
+		}
+		select {
+		case <-ctx.Done():
+			t.Fatalf("wait task %s timeout, tasks: %v", name, tasks)
+		case <-time.After(20 * time.Millisecond):
+		}
+	}
+}
+
+func TestServer_RunDAG(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	backend := NewMemoryBackend()
+	server := NewServerFromBackend(backend)
+	_ = server.Register("echo", func(val string) string { return val })
+	_ = server.Register("fail", func() error { return errors.New("failed") })
+	go server.Run(ctx)
+
+	cli := NewClientFromBackend(backend)
+	task := Task{
+		Name:  "dag",
+		Group: "test",
+		Steps: []Step{
+			{Name: "a", Function: "echo", Args: ArgsOf("a")},
+			{Name: "b", Function: "fail", DependsOn: []string{"a"}, Retry: &RetryPolicy{Limit: 1}},
+			{Name: "c", Function: "echo", Args: ArgsOf("c"), DependsOn: []string{"a"}},
+			{Name: "d", Function: "echo", Args: ArgsOf("d"), DependsOn: []string{"b", "c"}},
+			{Name: "rollback", Function: "echo", Args: ArgsOf("rollback"), When: &StepCondition{Step: "b", Status: TaskStatusError}},
+		},
+	}
+	if err := cli.SubmitTask(ctx, task); err != nil {
+		t.Fatal(err)
+	}
+	finished := waitTask(ctx, t, cli, "dag", func(task Task) bool { return task.Status.Status.IsFinished() })
+	if finished.Status.Status != TaskStatusError {
+		t.Fatalf("task status = %s, want Error", finished.Status.Status)
+	}
+	want := map[string]TaskStatusCode{
+		"a":        TaskStatusSuccess,
+		"b":        TaskStatusError,
+		"c":        TaskStatusSuccess,
+		"d":        TaskStatusSkipped,
+		"rollback": TaskStatusSuccess,
+	}
+	for _, step := range finished.Steps {
+		if step.Status == nil || step.Status.Status != want[step.Name] {
+			t.Errorf("step %s status = %v, want %s", step.Name, step.Status, want[step.Name])
+		}
+	}
+	if attempts := finished.Steps[1].Status.Attempts; attempts != 2 {
+		t.Errorf("step b attempts = %d, want 2", attempts)
+	}
+
+	// 重试时已经成功的步骤不会重新执行
+	if err := cli.RetryTask(ctx, "test", "dag", finished.UID, ""); err != nil {
+		t.Fatal(err)
+	}
+	_ = waitTask(ctx, t, cli, "dag", func(task Task) bool { return task.Status.Status == TaskStatusPending })
+	retried := waitTask(ctx, t, cli, "dag", func(task Task) bool { return task.Status.Status.IsFinished() })
+	if retried.Steps[0].Status.StartTimestamp != finished.Steps[0].Status.StartTimestamp {
+		t.Errorf("step a executed again on retry")
+	}
+}
+
+func TestServer_RunDAGWithoutWaitingSiblings(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	backend := NewMemoryBackend()
+	server := NewServerFromBackend(backend)
+	started := make(chan struct{})
+	_ = server.Register("echo", func(val string) string { return val })
+	_ = server.Register("notify", func() error {
+		close(started)
+		return nil
+	})
+	// slow 在 next 开始执行之后才完成
+	_ = server.Register("wait", func(ctx context.Context) error {
+		select {
+		case <-started:
+			return nil
+		case <-time.After(5 * time.Second):
+			return errors.New("dependent step waits for sibling")
+		}
+	})
+	go server.Run(ctx)
+
+	cli := NewClientFromBackend(backend)
+	task := Task{
+		Name:  "eager",
+		Group: "test",
+		Steps: []Step{
+			{Name: "slow", Function: "wait"},
+			{Name: "fast", Function: "echo", Args: ArgsOf("fast")},
+			{Name: "next", Function: "notify", DependsOn: []string{"fast"}},
+		},
+	}
+	if err := cli.SubmitTask(ctx, task); err != nil {
+		t.Fatal(err)
+	}
+	finished := waitTask(ctx, t, cli, "eager", func(task Task) bool { return task.Status.Status.IsFinished() })
+	if finished.Status.Status != TaskStatusSuccess {
+		t.Fatalf("task status = %s, message = %s, want Success", finished.Status.Status, finished.Status.Message)
+	}
+}
+
+func TestServer_CancelTask(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	backend := NewMemoryBackend()
+	server := NewServerFromBackend(backend)
+	_ = server.Register("block", func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	go server.Run(ctx)
+
+	cli := NewClientFromBackend(backend)
+	// 与 tasks collector 一样通过 WatchTasks 获取任务状态变化
+	notified := make(chan *Task, 1)
+	go cli.WatchTasks(ctx, "test", "cancel", func(ctx context.Context, task *Task) error {
+		if task.Status != nil && task.Status.Status == TaskStatusCancelled {
+			select {
+			case notified <- task:
+			default:
+			}
+		}
+		return nil
+	})
+	time.Sleep(100 * time.Millisecond)
+
+	task := Task{
+		Name:  "cancel",
+		Group: "test",
+		Steps: []Step{{Name: "block", Function: "block"}, {Name: "next", Function: "block"}},
+	}
+	if err := cli.SubmitTask(ctx, task); err != nil {
+		t.Fatal(err)
+	}
+	running := waitTask(ctx, t, cli, "cancel", func(task Task) bool {
+		return len(task.Steps) > 0 && task.Steps[0].Status != nil && task.Steps[0].Status.Status == TaskStatusRunning
+	})
+	// 控制通道不保证送达，重复取消直到任务被取消
+	cancelled := waitTask(ctx, t, cli, "cancel", func(task Task) bool {
+		_ = cli.CancelTask(ctx, "test", "cancel", running.UID)
+		return task.Status.Status == TaskStatusCancelled
+	})
+	for _, step := range cancelled.Steps {
+		if step.Status == nil || step.Status.Status != TaskStatusCancelled {
+			t.Errorf("step %s status = %v, want Cancelled", step.Name, step.Status)
+		}
+	}
+	select {
+	case task := <-notified:
+		if task.UID != running.UID {
+			t.Errorf("watched cancelled task %s, want %s", task.UID, running.UID)
+		}
+	case <-ctx.Done():
+		t.Fatal("cancelled task not watched")
+	}
+}
+
+func TestServer_PauseResumeTask(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	backend := NewMemoryBackend()
+	server := NewServerFromBackend(backend)
+	mu := sync.Mutex{}
+	executed := map[string]int{}
+	release := make(chan struct{})
+	_ = server.Register("gate", func(name string) error {
+		mu.Lock()
+		executed[name]++
+		mu.Unlock()
+		<-release
+		return nil
+	})
+	_ = server.Register("count", func(name string) error {
+		mu.Lock()
+		executed[name]++
+		mu.Unlock()
+		return nil
+	})
+	executedOf := func(name string) int {
+		mu.Lock()
+		defer mu.Unlock()
+		return executed[name]
+	}
+	go server.Run(ctx)
+
+	cli := NewClientFromBackend(backend)
+	task := Task{
+		Name:  "pause",
+		Group: "test",
+		Steps: []Step{
+			{Name: "first", Function: "gate", Args: ArgsOf("first")},
+			{Name: "second", Function: "count", Args: ArgsOf("second")},
+		},
+	}
+	if err := cli.SubmitTask(ctx, task); err != nil {
+		t.Fatal(err)
+	}
+	running := waitTask(ctx, t, cli, "pause", func(task Task) bool {
+		return len(task.Steps) > 0 && task.Steps[0].Status != nil && task.Steps[0].Status.Status == TaskStatusRunning
+	})
+	if err := cli.PauseTask(ctx, "test", "pause", running.UID); err != nil {
+		t.Fatal(err)
+	}
+	// 正在执行的步骤完成后任务暂停，不再调度新的步骤
+	close(release)
+	paused := waitTask(ctx, t, cli, "pause", func(task Task) bool { return task.Status.Status == TaskStatusPaused })
+	if paused.Steps[0].Status.Status != TaskStatusSuccess {
+		t.Errorf("step first status = %v, want Success", paused.Steps[0].Status)
+	}
+	time.Sleep(200 * time.Millisecond)
+	stored, err := cli.getTask(ctx, "test", "pause", running.UID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Status.Status != TaskStatusPaused {
+		t.Fatalf("task status = %s after pause, want Paused", stored.Status.Status)
+	}
+	if n := executedOf("second"); n != 0 {
+		t.Fatalf("step second executed %d times while paused, want 0", n)
+	}
+
+	// 恢复后只执行未完成的步骤
+	if err := cli.ResumeTask(ctx, "test", "pause", running.UID); err != nil {
+		t.Fatal(err)
+	}
+	finished := waitTask(ctx, t, cli, "pause", func(task Task) bool { return task.Status.Status.IsFinished() })
+	if finished.Status.Status != TaskStatusSuccess {
+		t.Fatalf("task status = %s, message = %s, want Success", finished.Status.Status, finished.Status.Message)
+	}
+	if first, second := executedOf("first"), executedOf("second"); first != 1 || second != 1 {
+		t.Errorf("steps executed first=%d second=%d, want 1 and 1", first, second)
+	}
+}
+
+func TestServer_Hook(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	backend := NewMemoryBackend()
+	server := NewServerFromBackend(backend)
+	_ = server.Register("echo", func(val string) string { return val })
+	finished := make(chan *Task, 1)
+	server.AddHook(func(ctx context.Context, event TaskEvent, task *Task) {
+		if event == TaskEventFinished {
+			finished <- task
+		}
+	})
+	go server.Run(ctx)
+
+	cli := NewClientFromBackend(backend)
+	if err := cli.SubmitTask(ctx, Task{
+		Name:  "hook",
+		Group: "test",
+		Steps: []Step{{Name: "echo", Function: "echo", Args: ArgsOf("hello")}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case task := <-finished:
+		if task.Status.Status != TaskStatusSuccess || task.Steps[0].Status.Result[0] != "hello" {
+			t.Errorf("hook got task status %v, want Success with step result", task.Status)
+		}
+	case <-ctx.Done():
+		t.Fatal("hook not called")
+	}
+}
+
+func TestClient_OrphanTasks(t *testing.T) {
+	ctx := context.Background()
+	backend := NewMemoryBackend()
+	cli := NewClientFromBackend(backend)
+
+	running := &jsonArgsTask{
+		UID: "running", Name: "orphan", Group: "test",
+		Status: TaskStatus{Status: TaskStatusRunning},
+		Steps: []*jsonArgsStep{
+			{Name: "a", Status: TaskStatus{Status: TaskStatusSuccess, Executer: "offline"}},
+			{Name: "b", Status: TaskStatus{Status: TaskStatusRunning, Executer: "offline"}},
+		},
+	}
+	other := &jsonArgsTask{
+		UID: "other", Name: "orphan", Group: "test",
+		Status: TaskStatus{Status: TaskStatusRunning},
+		Steps:  []*jsonArgsStep{{Name: "a", Status: TaskStatus{Status: TaskStatusRunning, Executer: "online"}}},
+	}
+	for _, task := range []*jsonArgsTask{running, other} {
+		if err := cli.putTask(ctx, task); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	orphaned, err := cli.OrphanTasks(ctx, "offline")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(orphaned) != 1 || orphaned[0].UID != "running" {
+		t.Fatalf("OrphanTasks() = %v, want task running", orphaned)
+	}
+	stored, err := cli.getTask(ctx, "test", "orphan", "running")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Status.Status != TaskStatusError || stored.Steps[1].Status.Status != TaskStatusError {
+		t.Errorf("stored task status = %v, step b = %v, want Error", stored.Status, stored.Steps[1].Status)
+	}
+	if stored.Steps[0].Status.Status != TaskStatusSuccess {
+		t.Errorf("step a status = %v, want Success", stored.Steps[0].Status)
+	}
+}
+
+func TestClient_OfflineExecuters(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	backend := NewMemoryBackend()
+	cli := NewClientFromBackend(backend)
+	server := NewServerFromBackend(backend)
+	server.executerid = "online"
+	go server.Run(ctx)
+
+	for _, executer := range []string{"online", "offline"} {
+		task := &jsonArgsTask{
+			UID: executer, Name: "heartbeat", Group: "test",
+			Status: TaskStatus{Status: TaskStatusRunning},
+			Steps:  []*jsonArgsStep{{Name: "a", Status: TaskStatus{Status: TaskStatusRunning, Executer: executer}}},
+		}
+		if err := cli.putTask(ctx, task); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for {
+		alive, err := cli.ExecuterAlive(ctx, "online")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if alive {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			t.Fatal("server heartbeat not found")
+		case <-time.After(20 * time.Millisecond):
+		}
+	}
+	offline, err := cli.OfflineExecuters(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(offline) != 1 || offline[0] != "offline" {
+		t.Errorf("OfflineExecuters() = %v, want [offline]", offline)
+	}
+	// 心跳不会出现在任务列表中
+	tasks, err := cli.ListTasks(ctx, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tasks) != 2 {
+		t.Errorf("ListTasks() got %d tasks, want 2", len(tasks))
+	}
+}
+
+func TestClient_RetryArchivedTask(t *testing.T) {
+	ctx := context.Background()
+	db := setupSQL(t)
+	if err := db.AutoMigrate(&TaskHistory{}); err != nil {
+		t.Fatal(err)
+	}
+	history := &HistoryStore{db: db}
+	cli := NewClientFromBackend(NewMemoryBackend()).WithHistory(history)
+
+	task := &Task{
+		UID: "archived", Name: "retry", Group: "test",
+		Status: &TaskStatus{Status: TaskStatusError},
+		Steps:  []Step{{Name: "a", Function: "echo", Status: &TaskStatus{Status: TaskStatusError}}},
+	}
+	if err := history.Save(ctx, TaskEventFinished, task); err != nil {
+		t.Fatal(err)
+	}
+	// 已存在的记录不会被覆盖
+	if err := history.Archive(ctx, TaskEventExpired, task); err != nil {
+		t.Fatal(err)
+	}
+	page, err := history.Query(ctx, TaskHistoryQuery{Group: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.Total != 1 || page.List[0].Event != TaskEventFinished {
+		t.Fatalf("Query() = %v, want one Finished record", page.List)
+	}
+
+	// 任务已经不在 backend 中，从历史中恢复
+	if err := cli.RetryTask(ctx, "test", "retry", "archived", ""); err != nil {
+		t.Fatalf("RetryTask() error = %v", err)
+	}
+	stored, err := cli.getTask(ctx, "test", "retry", "archived")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Status.Status != TaskStatusPending || stored.Steps[0].Status.Status != "" {
+		t.Errorf("retried task status = %v, step a = %v, want Pending and reset", stored.Status, stored.Steps[0].Status)
+	}
+	if err := cli.RetryTask(ctx, "test", "other", "archived", ""); err == nil {
+		t.Error("RetryTask() with mismatched name error = nil, want error")
+	}
+}
+
+func TestPutTask_TTL(t *testing.T) {
+	ctx := context.Background()
+	backend := NewMemoryBackend()
+	task := &jsonArgsTask{UID: "uid", Name: "ttl", Group: "test", Status: TaskStatus{Status: TaskStatusPaused}}
+	logkey := logKeyOf(task.Group, task.Name, task.UID) + "/0/000000"
+	if err := backend.Put(ctx, logkey, []byte("{}")); err != nil {
+		t.Fatal(err)
+	}
+	taskkey := path.Join(task.Group, task.Name, task.UID)
+
+	// 暂停的任务以及日志不过期
+	if err := putTask(ctx, backend, task); err != nil {
+		t.Fatal(err)
+	}
+	if backend.expires[taskkey] != nil || backend.expires[logkey] != nil {
+		t.Fatal("paused task or its logs have ttl, want none")
+	}
+	// 已结束的任务与日志一同过期
+	task.Status.Status = TaskStatusCancelled
+	if err := putTask(ctx, backend, task); err != nil {
+		t.Fatal(err)
+	}
+	if backend.expires[taskkey] == nil || backend.expires[logkey] == nil {
+		t.Fatal("finished task or its logs have no ttl")
+	}
+}
+
+func TestServer_StepLogs(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	backend := NewMemoryBackend()
+	server := NewServerFromBackend(backend)
+	_ = server.Register("logging", func(ctx context.Context, n int) error {
+		log := logr.FromContextOrDiscard(ctx)
+		for i := 0; i < n; i++ {
+			log.Info("line", "i", i)
+		}
+		return errors.New("failed")
+	})
+	go server.Run(ctx)
+
+	cli := NewClientFromBackend(backend)
+	watched := make(chan *StepLogChunk, 16)
+	go cli.WatchTasks(ctx, "test", "logs", func(ctx context.Context, task *Task) error {
+		return nil
+	}, WithStepLogs(func(ctx context.Context, chunk *StepLogChunk) error {
+		select {
+		case watched <- chunk:
+		case <-ctx.Done():
+		}
+		return nil
+	}))
+	time.Sleep(100 * time.Millisecond)
+
+	if err := cli.SubmitTask(ctx, Task{
+		Name:  "logs",
+		Group: "test",
+		Steps: []Step{{Name: "logging", Function: "logging", Args: ArgsOf(3)}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	task := waitTask(ctx, t, cli, "logs", func(task Task) bool { return task.Status.Status.IsFinished() })
+
+	chunks, err := cli.StepLogs(ctx, "test", "logs", task.UID, "logging")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := []string{}
+	for _, chunk := range chunks {
+		lines = append(lines, chunk.Lines...)
+	}
+	content := strings.Join(lines, "\n")
+	for _, expect := range []string{"i=0", "i=2", "ERROR", "error=failed"} {
+		if !strings.Contains(content, expect) {
+			t.Errorf("step logs %q not contains %s", content, expect)
+		}
+	}
+	select {
+	case chunk := <-watched:
+		if chunk.UID != task.UID || chunk.Step != "logging" || chunk.Index != "0" {
+			t.Errorf("watched chunk = %+v, want step logging of task %s", chunk, task.UID)
+		}
+	case <-ctx.Done():
+		t.Fatal("step logs not watched")
+	}
+}
+
+func TestStepLogWriter_Truncate(t *testing.T) {
+	chunkSize, maxSize := StepLogChunkSize, MaxStepLogSize
+	StepLogChunkSize, MaxStepLogSize = 10, 25
+	defer func() { StepLogChunkSize, MaxStepLogSize = chunkSize, maxSize }()
+
+	backend := NewMemoryBackend()
+	cli := NewClientFromBackend(backend)
+	step := &jsonArgsStep{Name: "step"}
+	task := &jsonArgsTask{UID: "uid", Name: "truncate", Group: "test", Steps: []*jsonArgsStep{step}}
+	w := newStepLogWriter(backend, task, step)
+	for i := 0; i < 10; i++ {
+		w.Write("0123456789")
+	}
+	w.Close()
+
+	chunks, err := cli.StepLogs(context.Background(), "test", "truncate", "uid", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chunks) != 3 {
+		t.Fatalf("StepLogs() got %d chunks, want 3", len(chunks))
+	}
+	if last := chunks[len(chunks)-1]; !last.Truncated {
+		t.Errorf("last chunk = %+v, want truncated", last)
+	}
+}
+
+func TestClient_StepLogsOrder(t *testing.T) {
+	ctx := context.Background()
+	backend := NewMemoryBackend()
+	cli := NewClientFromBackend(backend)
+	steps := []*jsonArgsStep{}
+	for i := 0; i < 11; i++ {
+		steps = append(steps, &jsonArgsStep{Name: fmt.Sprintf("step-%d", i)})
+	}
+	steps[1].SubSteps = []*jsonArgsStep{{Name: "sub"}}
+	task := &jsonArgsTask{UID: "uid", Name: "order", Group: "test", Steps: steps}
+	for _, step := range []*jsonArgsStep{steps[10], steps[2], steps[1].SubSteps[0], steps[1]} {
+		w := newStepLogWriter(backend, task, step)
+		w.Write(step.Name)
+		w.Close()
+	}
+	chunks, err := cli.StepLogs(ctx, "test", "order", "uid", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := []string{}
+	for _, chunk := range chunks {
+		got = append(got, chunk.Index)
+	}
+	if want := []string{"1", "1.0", "2", "10"}; strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("StepLogs() indexes = %v, want %v", got, want)
+	}
+}
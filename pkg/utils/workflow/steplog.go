@@ -0,0 +1,333 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workflow
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"kubegems.io/kubegems/pkg/log"
+)
+
+// 步骤日志
+// 执行步骤时，context 中的 logger 会同时将日志写入步骤日志，注册的函数使用 log.FromContextOrDiscard(ctx) 即可。
+// 步骤日志按照 chunk 存储在 kv 中，key 为 __logs__/{group}/{name}/{uid}/{step-index}/{seq}:
+// - 当前 chunk 会被定期写入，写满后写入下一个 chunk，所以 watch 时同一个 seq 的 chunk 可能会收到多次，以最后一次为准。
+// - 单个步骤的日志总大小超过 MaxStepLogSize 后不再记录。
+
+const (
+	logKeyPrefix = "__logs__"
+)
+
+var (
+	StepLogChunkSize     = 16 << 10 // 单个 chunk 大小
+	MaxStepLogSize       = 1 << 20  // 单个步骤的日志总大小
+	StepLogFlushInterval = time.Second
+)
+
+type StepLogChunk struct {
+	UID       string   `json:"uid,omitempty"`
+	Step      string   `json:"step,omitempty"`  // 步骤名称
+	Index     string   `json:"index,omitempty"` // 步骤位置，例如 0.1 为第一个步骤的第二个子步骤
+	Seq       int      `json:"seq"`
+	Lines     []string `json:"lines,omitempty"`
+	Truncated bool     `json:"truncated,omitempty"` // 日志超过大小限制，之后的日志被丢弃
+}
+
+func logKeyOf(group, name, uid string) string {
+	return path.Join(logKeyPrefix, group, name, uid)
+}
+
+func isLogKey(key string) bool {
+	return strings.HasPrefix(strings.TrimPrefix(key, "/"), logKeyPrefix)
+}
+
+// isInternalKey 控制指令、步骤日志以及心跳等非任务的 key
+func isInternalKey(key string) bool {
+	return isControlKey(key) || isLogKey(key) || isHeartbeatKey(key)
+}
+
+// stepIndexOf 返回步骤在任务中的位置
+func stepIndexOf(steps []*jsonArgsStep, target *jsonArgsStep) string {
+	for i, step := range steps {
+		if step == target {
+			return strconv.Itoa(i)
+		}
+		if sub := stepIndexOf(step.SubSteps, target); sub != "" {
+			return strconv.Itoa(i) + "." + sub
+		}
+	}
+	return ""
+}
+
+// lessStepIndex 按照数字逐级比较步骤位置，例如 2 < 10，0.1 < 0.1.0 < 1
+func lessStepIndex(a, b string) bool {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		ai, aerr := strconv.Atoi(as[i])
+		bi, berr := strconv.Atoi(bs[i])
+		if aerr != nil || berr != nil {
+			if as[i] != bs[i] {
+				return as[i] < bs[i]
+			}
+			continue
+		}
+		if ai != bi {
+			return ai < bi
+		}
+	}
+	return len(as) < len(bs)
+}
+
+// StepLogs 获取任务的步骤日志，step 为步骤名称或者位置，为空时返回所有步骤的日志
+func (c *Client) StepLogs(ctx context.Context, group, name, uid string, step string) ([]StepLogChunk, error) {
+	kvs, err := c.backend.List(ctx, logKeyOf(group, name, uid)+"/")
+	if err != nil {
+		return nil, err
+	}
+	chunks := make([]StepLogChunk, 0, len(kvs))
+	for _, v := range kvs {
+		chunk := StepLogChunk{}
+		if err := json.Unmarshal(v, &chunk); err != nil {
+			continue
+		}
+		if step != "" && chunk.Step != step && chunk.Index != step {
+			continue
+		}
+		chunks = append(chunks, chunk)
+	}
+	sort.Slice(chunks, func(i, j int) bool {
+		if chunks[i].Index != chunks[j].Index {
+			return lessStepIndex(chunks[i].Index, chunks[j].Index)
+		}
+		return chunks[i].Seq < chunks[j].Seq
+	})
+	return chunks, nil
+}
+
+func removeKeys(ctx context.Context, backend Backend, keyprefix string) error {
+	kvs, err := backend.List(ctx, keyprefix)
+	if err != nil {
+		return err
+	}
+	for k := range kvs {
+		if err := backend.Del(ctx, keyprefix+k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// stepLogWriter 将步骤日志分块写入 kv
+type stepLogWriter struct {
+	backend   Backend
+	keyprefix string
+
+	mu        sync.Mutex
+	chunk     StepLogChunk
+	size      int // 当前 chunk 大小
+	total     int // 已记录的日志大小
+	dirty     bool
+	truncated bool
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func newStepLogWriter(backend Backend, task *jsonArgsTask, step *jsonArgsStep) *stepLogWriter {
+	index := stepIndexOf(task.Steps, step)
+	w := &stepLogWriter{
+		backend:   backend,
+		keyprefix: logKeyOf(task.Group, task.Name, task.UID) + "/" + index + "/",
+		chunk:     StepLogChunk{UID: task.UID, Step: step.Name, Index: index},
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	// 清理重试之前的日志
+	_ = removeKeys(context.Background(), backend, w.keyprefix)
+	go w.run()
+	return w
+}
+
+func (w *stepLogWriter) run() {
+	defer close(w.done)
+	ticker := time.NewTicker(StepLogFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-ticker.C:
+			w.mu.Lock()
+			w.flush()
+			w.mu.Unlock()
+		}
+	}
+}
+
+func (w *stepLogWriter) Write(line string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.truncated {
+		return
+	}
+	if w.total+len(line) > MaxStepLogSize {
+		w.truncated = true
+		w.chunk.Truncated = true
+		w.chunk.Lines = append(w.chunk.Lines, fmt.Sprintf("log exceeds %d bytes, truncated", MaxStepLogSize))
+		w.dirty = true
+		w.flush()
+		return
+	}
+	w.chunk.Lines = append(w.chunk.Lines, line)
+	w.size += len(line)
+	w.total += len(line)
+	w.dirty = true
+	if w.size >= StepLogChunkSize {
+		// 当前 chunk 已满，写入后开始下一个 chunk
+		w.flush()
+		w.chunk.Seq++
+		w.chunk.Lines = nil
+		w.size = 0
+	}
+}
+
+// Close 停止定期写入并写入剩余的日志
+func (w *stepLogWriter) Close() {
+	close(w.stop)
+	<-w.done
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.flush()
+}
+
+func (w *stepLogWriter) flush() {
+	if !w.dirty {
+		return
+	}
+	content, err := json.Marshal(w.chunk)
+	if err != nil {
+		return
+	}
+	// 日志的写入不受步骤取消的影响
+	key := w.keyprefix + fmt.Sprintf("%06d", w.chunk.Seq)
+	if err := w.backend.Put(context.Background(), key, content); err != nil {
+		return
+	}
+	w.dirty = false
+}
+
+// expireStepLogs 为任务的步骤日志设置过期时间，日志写入时不设置过期时间
+func expireStepLogs(ctx context.Context, backend Backend, task *jsonArgsTask, ttl time.Duration) error {
+	keyprefix := logKeyOf(task.Group, task.Name, task.UID) + "/"
+	kvs, err := backend.List(ctx, keyprefix)
+	if err != nil {
+		return err
+	}
+	for key, val := range kvs {
+		if err := backend.Put(ctx, keyprefix+key, val, ttl); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// withStepLogger 将 context 中的 logger 替换为同时写入步骤日志的 logger
+func withStepLogger(ctx context.Context, w *stepLogWriter) context.Context {
+	parent := log.FromContextOrDiscard(ctx)
+	return logr.NewContext(ctx, logr.New(&stepLogSink{parent: parent.GetSink(), writer: w}))
+}
+
+// stepLogSink 将 level 0 的日志写入步骤日志，所有日志均转发至原有的 LogSink
+type stepLogSink struct {
+	parent logr.LogSink
+	writer *stepLogWriter
+	name   string
+	values []interface{}
+}
+
+func (s *stepLogSink) Init(info logr.RuntimeInfo) {
+	if s.parent != nil {
+		s.parent.Init(info)
+	}
+}
+
+func (s *stepLogSink) Enabled(level int) bool {
+	return level == 0 || (s.parent != nil && s.parent.Enabled(level))
+}
+
+func (s *stepLogSink) Info(level int, msg string, keysAndValues ...interface{}) {
+	if s.parent != nil && s.parent.Enabled(level) {
+		s.parent.Info(level, msg, keysAndValues...)
+	}
+	if level == 0 {
+		s.writer.Write(s.format("INFO", msg, keysAndValues))
+	}
+}
+
+func (s *stepLogSink) Error(err error, msg string, keysAndValues ...interface{}) {
+	if s.parent != nil {
+		s.parent.Error(err, msg, keysAndValues...)
+	}
+	s.writer.Write(s.format("ERROR", msg, append(keysAndValues, "error", err)))
+}
+
+func (s *stepLogSink) WithValues(keysAndValues ...interface{}) logr.LogSink {
+	out := *s
+	if s.parent != nil {
+		out.parent = s.parent.WithValues(keysAndValues...)
+	}
+	out.values = append(append([]interface{}{}, s.values...), keysAndValues...)
+	return &out
+}
+
+func (s *stepLogSink) WithName(name string) logr.LogSink {
+	out := *s
+	if s.parent != nil {
+		out.parent = s.parent.WithName(name)
+	}
+	if s.name != "" {
+		name = s.name + "." + name
+	}
+	out.name = name
+	return &out
+}
+
+func (s *stepLogSink) format(level, msg string, keysAndValues []interface{}) string {
+	sb := &strings.Builder{}
+	sb.WriteString(time.Now().Format(time.RFC3339))
+	sb.WriteString(" " + level)
+	if s.name != "" {
+		sb.WriteString(" " + s.name)
+	}
+	sb.WriteString(" " + msg)
+	kvs := append(append([]interface{}{}, s.values...), keysAndValues...)
+	for i := 0; i < len(kvs); i += 2 {
+		var val interface{} = "<missing>"
+		if i+1 < len(kvs) {
+			val = kvs[i+1]
+		}
+		fmt.Fprintf(sb, " %v=%v", kvs[i], val)
+	}
+	return sb.String()
+}
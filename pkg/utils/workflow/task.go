@@ -40,11 +40,53 @@ type Task struct {
 }
 
 type Step struct {
-	Name     string        `json:"name,omitempty"`
-	Function string        `json:"function,omitempty"` // 任务所使用的 函数/组件/插件
-	Args     []interface{} `json:"args,omitempty"`     // 对应的参数
-	SubSteps []Step        `json:"subSteps,omitempty"` // 子任务
-	Status   *TaskStatus   `json:"status,omitempty"`
+	Name      string         `json:"name,omitempty"`
+	Function  string         `json:"function,omitempty"`  // 任务所使用的 函数/组件/插件
+	Args      []interface{}  `json:"args,omitempty"`      // 对应的参数
+	SubSteps  []Step         `json:"subSteps,omitempty"`  // 子任务
+	DependsOn []string       `json:"dependsOn,omitempty"` // 依赖的同级步骤名称，同级中任一步骤设置了依赖则按照 DAG 调度，否则按顺序执行
+	When      *StepCondition `json:"when,omitempty"`      // 执行条件，不满足时跳过该步骤
+	Retry     *RetryPolicy   `json:"retry,omitempty"`     // 失败重试策略
+	Timeout   time.Duration  `json:"timeout,omitempty"`   // 任务执行超时
+	Status    *TaskStatus    `json:"status,omitempty"`
+}
+
+// StepCondition 根据之前步骤的结果决定是否执行当前步骤，用于实现分支
+type StepCondition struct {
+	Step   string         `json:"step,omitempty"`   // 条件依赖的步骤名称
+	Status TaskStatusCode `json:"status,omitempty"` // 期望的步骤状态，为空时为 Success
+	Result string         `json:"result,omitempty"` // 期望的步骤第一个返回值，为空时不判断
+	Not    bool           `json:"not,omitempty"`    // 对条件结果取反，用于 else 分支
+}
+
+// RetryPolicy 步骤失败时的重试策略，重试间隔按照 Factor 指数增长，最大不超过 MaxBackoff
+type RetryPolicy struct {
+	Limit      int           `json:"limit,omitempty"`      // 最大重试次数
+	Backoff    time.Duration `json:"backoff,omitempty"`    // 首次重试间隔
+	Factor     float64       `json:"factor,omitempty"`     // 间隔倍率，小于1时为1
+	MaxBackoff time.Duration `json:"maxBackoff,omitempty"` // 最大重试间隔
+}
+
+// BackoffOf 返回第 attempt 次重试前需要等待的时间，attempt 从1开始
+func (p *RetryPolicy) BackoffOf(attempt int) time.Duration {
+	if p == nil || p.Backoff <= 0 {
+		return 0
+	}
+	factor := p.Factor
+	if factor < 1 {
+		factor = 1
+	}
+	backoff := float64(p.Backoff)
+	for i := 1; i < attempt; i++ {
+		backoff *= factor
+		if p.MaxBackoff > 0 && backoff > float64(p.MaxBackoff) {
+			return p.MaxBackoff
+		}
+	}
+	if p.MaxBackoff > 0 && backoff > float64(p.MaxBackoff) {
+		return p.MaxBackoff
+	}
+	return time.Duration(backoff)
 }
 
 type jsonArgsTask struct {
@@ -58,12 +100,15 @@ type jsonArgsTask struct {
 }
 
 type jsonArgsStep struct {
-	Name     string            `json:"name,omitempty"`
-	Function string            `json:"function,omitempty"`
-	Args     []json.RawMessage `json:"args,omitempty"`
-	SubSteps []*jsonArgsStep   `json:"subSteps,omitempty"`
-	Status   TaskStatus        `json:"status,omitempty"`
-	Timeout  time.Duration     `json:"timeout,omitempty"` // 任务执行超时
+	Name      string            `json:"name,omitempty"`
+	Function  string            `json:"function,omitempty"`
+	Args      []json.RawMessage `json:"args,omitempty"`
+	SubSteps  []*jsonArgsStep   `json:"subSteps,omitempty"`
+	DependsOn []string          `json:"dependsOn,omitempty"`
+	When      *StepCondition    `json:"when,omitempty"`
+	Retry     *RetryPolicy      `json:"retry,omitempty"`
+	Status    TaskStatus        `json:"status,omitempty"`
+	Timeout   time.Duration     `json:"timeout,omitempty"` // 任务执行超时
 }
 
 func ArgsOf(args ...interface{}) []interface{} {
@@ -73,12 +118,25 @@ func ArgsOf(args ...interface{}) []interface{} {
 type TaskStatusCode string
 
 const (
-	TaskStatusPending TaskStatusCode = "Pending"
-	TaskStatusRunning TaskStatusCode = "Running"
-	TaskStatusSuccess TaskStatusCode = "Success"
-	TaskStatusError   TaskStatusCode = "Error"
+	TaskStatusPending   TaskStatusCode = "Pending"
+	TaskStatusRunning   TaskStatusCode = "Running"
+	TaskStatusSuccess   TaskStatusCode = "Success"
+	TaskStatusError     TaskStatusCode = "Error"
+	TaskStatusSkipped   TaskStatusCode = "Skipped"   // 条件不满足或者依赖失败而未执行
+	TaskStatusCancelled TaskStatusCode = "Cancelled" // 被取消
+	TaskStatusPaused    TaskStatusCode = "Paused"    // 被暂停，恢复后继续执行
 )
 
+// IsFinished 判断状态是否为结束状态
+func (c TaskStatusCode) IsFinished() bool {
+	switch c {
+	case TaskStatusSuccess, TaskStatusError, TaskStatusSkipped, TaskStatusCancelled:
+		return true
+	default:
+		return false
+	}
+}
+
 type TaskStatus struct {
 	StartTimestamp  metav1.Time    `json:"startTimestamp,omitempty"`
 	FinishTimestamp metav1.Time    `json:"finishTimestamp,omitempty"`
@@ -86,4 +144,5 @@ type TaskStatus struct {
 	Result          []interface{}  `json:"result,omitempty"`
 	Executer        string         `json:"executer,omitempty"`
 	Message         string         `json:"message,omitempty"`
+	Attempts        int            `json:"attempts,omitempty"` // 已执行次数，包含重试
 }
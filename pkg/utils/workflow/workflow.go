@@ -20,16 +20,16 @@ package workflow
 
 - [ ] 任务定义
 	- [ ] 任务支持多阶段，多步骤，配置简，单模块化。
-	- [ ] 异步任务各个阶段支持依赖关系。支持 串行，并行，分支。
+	- [x] 异步任务各个阶段支持依赖关系。支持 串行，并行，分支。
 	- [ ] 支持定时任务，周期任务。
 - [ ] 任务控制
-	- [ ] 支持运行任务终止/中断执行。 如果支持这个特性则需要 node 和server之间长连接以接受控制。
-	- [ ] 支持从失败的任务阶段进行重试。
+	- [x] 支持运行任务终止/中断执行。 如果支持这个特性则需要 node 和server之间长连接以接受控制。
+	- [x] 支持从失败的任务阶段进行重试。
 - [ ] 任务执行
 	- [ ] 支持异步分布式worker模式。分散任务至多个worker处理。
 	- [ ] 支持实时状态更新通知。用于**实时**展示任务状态。
-  	- [ ] 支持历史任务查询，支持时间排序。
-  	- [ ] 支持任务过期通知。此项目用于在任务过期时接受通知并持久化至数据库，便于后续分析。
+  	- [x] 支持历史任务查询，支持时间排序。
+  	- [x] 支持任务过期通知。此项目用于在任务过期时接受通知并持久化至数据库，便于后续分析。
 */
 
 // server 作为控制端可以发布任务,多个 server 之间同级。
@@ -15,6 +15,7 @@
 package worker
 
 import (
+	"kubegems.io/kubegems/pkg/utils/agents"
 	"kubegems.io/kubegems/pkg/utils/argo"
 	"kubegems.io/kubegems/pkg/utils/database"
 	"kubegems.io/kubegems/pkg/utils/git"
@@ -33,6 +34,7 @@ type Options struct {
 	LogLevel string                      `json:"logLevel,omitempty"`
 	Mysql    *database.Options           `json:"mysql,omitempty"`
 	Redis    *redis.Options              `json:"redis,omitempty"`
+	Tunnel   *agents.TunnelOptions       `json:"tunnel,omitempty"`
 }
 
 func DefaultOptions() *Options {
@@ -45,5 +47,6 @@ func DefaultOptions() *Options {
 		LogLevel: "debug",
 		Mysql:    database.NewDefaultOptions(),
 		Redis:    redis.NewDefaultOptions(),
+		Tunnel:   agents.NewDefaultTunnelOptions(),
 	}
 }
@@ -29,16 +29,24 @@ type TaskArchiverTasker struct {
 	Databse *database.Database
 	taskcli *workflow.Client
 	Redis   *redis.Client
+	History *workflow.HistoryStore
 }
 
-func NewTaskArchiverTasker(databse *database.Database, redis *redis.Client) *TaskArchiverTasker {
+func NewTaskArchiverTasker(databse *database.Database, redis *redis.Client, history *workflow.HistoryStore) *TaskArchiverTasker {
 	return &TaskArchiverTasker{
 		taskcli: workflow.NewClientFromRedisClient(redis.Client),
 		Databse: databse,
 		Redis:   redis,
+		History: history,
 	}
 }
 
+// 任务在 backend 中保留的时间，需小于 workflow.FinishedTaskTTL 以保证已结束的任务在过期前被归档
+const ArchiveTaskDuration = 5 * 24 * time.Hour // 5 days
+
+// ArchiveOutdated 归档已结束的任务，并清理超过 ArchiveTaskDuration 的任务。
+// 已结束的任务通常已由 server 的 hook 归档，这里补充归档未配置 hook 或者 hook 失败的任务。
+// 暂停的任务等待用户操作，不会被清理。
 func (t *TaskArchiverTasker) ArchiveOutdated(ctx context.Context) error {
 	// list all tasks
 	tasks, err := t.taskcli.ListTasks(ctx, "", "")
@@ -47,10 +55,20 @@ func (t *TaskArchiverTasker) ArchiveOutdated(ctx context.Context) error {
 	}
 	log := log.FromContextOrDiscard(ctx)
 	for _, task := range tasks {
-		needarchive := time.Since(task.CreationTimestamp.Time) > 5*24*time.Hour // 5 days
-		if needarchive {
-			// todo : 存储历史任务
+		finished := task.Status != nil && task.Status.Status.IsFinished()
+		if finished {
+			if err := t.History.Archive(ctx, workflow.TaskEventFinished, &task); err != nil {
+				log.Error(err, "archive finished task", "uid", task.UID)
+				continue
+			}
+		}
+		if isOutdated(&task, time.Now()) {
 			log.Info("expired task", "name", task.Name, "creationtimestamp", task.CreationTimestamp)
+			// 存储历史任务，失败时保留该记录下次重试
+			if err := t.History.Save(ctx, workflow.TaskEventExpired, &task); err != nil {
+				log.Error(err, "save expired task", "uid", task.UID)
+				continue
+			}
 			// 删除该记录
 			if err := t.taskcli.RemoveTask(ctx, task.Group, task.Name, task.UID); err != nil {
 				log.Error(err, "remove expired task")
@@ -60,15 +78,49 @@ func (t *TaskArchiverTasker) ArchiveOutdated(ctx context.Context) error {
 	return nil
 }
 
+// isOutdated 已结束的任务按照结束时间计算，未结束的任务按照创建时间计算
+func isOutdated(task *workflow.Task, now time.Time) bool {
+	if task.Status == nil {
+		return now.Sub(task.CreationTimestamp.Time) > ArchiveTaskDuration
+	}
+	switch {
+	case task.Status.Status == workflow.TaskStatusPaused:
+		return false
+	case task.Status.Status.IsFinished() && !task.Status.FinishTimestamp.IsZero():
+		return now.Sub(task.Status.FinishTimestamp.Time) > ArchiveTaskDuration
+	default:
+		return now.Sub(task.CreationTimestamp.Time) > ArchiveTaskDuration
+	}
+}
+
 const RemoveConsumerDuration = 5 * time.Minute
 
-// RemoveOffline 删除长时间不活跃的worker正在处理的任务
+// RemoveOffline 将心跳已经过期的 worker 正在处理的任务标记为失败，并删除其在队列中长时间不活跃的 consumer。
+// worker 执行耗时较长的步骤时不会读取队列，consumer 的空闲时间不能用于判断 worker 是否在线。
 func (t *TaskArchiverTasker) RemoveOffline(ctx context.Context) error {
-	// 查看长时间未连接的worker
-	streamingkey := "/workflow/submit"
-
 	log := log.FromContextOrDiscard(ctx)
 
+	executers, err := t.taskcli.OfflineExecuters(ctx)
+	if err != nil {
+		return err
+	}
+	for _, executer := range executers {
+		// 该 worker 正在执行的任务无法继续，标记为失败并记录
+		orphaned, err := t.taskcli.OrphanTasks(ctx, executer)
+		if err != nil {
+			log.Error(err, "orphan tasks", "executer", executer)
+			return err
+		}
+		for i := range orphaned {
+			log.Info("orphaned task", "name", orphaned[i].Name, "uid", orphaned[i].UID, "executer", executer)
+			if err := t.History.Save(ctx, workflow.TaskEventOrphaned, &orphaned[i]); err != nil {
+				log.Error(err, "save orphaned task", "uid", orphaned[i].UID)
+			}
+		}
+	}
+
+	// 查看长时间未连接的worker
+	streamingkey := "/workflow-queue/submit"
 	// https://redis.io/commands/xinfo-consumers
 	consumers, err := t.Redis.Client.XInfoConsumers(ctx, streamingkey, workflow.DefaultGroup).Result()
 	if err != nil {
@@ -76,29 +128,40 @@ func (t *TaskArchiverTasker) RemoveOffline(ctx context.Context) error {
 	}
 	for _, consumer := range consumers {
 		// milliseconds
-		offlinetime := time.Duration(consumer.Idle) * time.Microsecond
+		offlinetime := time.Duration(consumer.Idle) * time.Millisecond
 
 		log.Info("consumer status", "name", consumer.Name, "idle", offlinetime)
 
-		if offlinetime > RemoveConsumerDuration {
-			// todo: 转移任务
-
-			// 删除 consumer
-			// https://redis.io/commands/xgroup-delconsumer
-			if _, err := t.Redis.Client.XGroupDelConsumer(ctx, streamingkey, workflow.DefaultGroup, consumer.Name).Result(); err != nil {
-				log.Error(err, "remove expired consumer")
-				return err
-			}
+		if offlinetime <= RemoveConsumerDuration {
+			continue
+		}
+		// consumer 名称即 worker 的 executer，心跳未过期的 worker 仍在线
+		alive, err := t.taskcli.ExecuterAlive(ctx, consumer.Name)
+		if err != nil {
+			return err
+		}
+		if alive {
+			continue
+		}
+		// 删除 consumer
+		// https://redis.io/commands/xgroup-delconsumer
+		if _, err := t.Redis.Client.XGroupDelConsumer(ctx, streamingkey, workflow.DefaultGroup, consumer.Name).Result(); err != nil {
+			log.Error(err, "remove expired consumer")
+			return err
 		}
 	}
 	return nil
 }
 
-const TaskFunction_ArchiveTasks = "task-archive"
+const (
+	TaskFunction_ArchiveTasks           = "task-archive"
+	TaskFunction_RemoveOfflineExecuters = "task-remove-offline"
+)
 
 func (t *TaskArchiverTasker) ProvideFuntions() map[string]interface{} {
 	return map[string]interface{}{
-		TaskFunction_ArchiveTasks: t.ArchiveOutdated,
+		TaskFunction_ArchiveTasks:           t.ArchiveOutdated,
+		TaskFunction_RemoveOfflineExecuters: t.RemoveOffline,
 	}
 }
 
@@ -114,5 +177,15 @@ func (t *TaskArchiverTasker) Crontasks() map[string]Task {
 				},
 			},
 		},
+		"@every 10m": {
+			Name:  "task-remove-offline",
+			Group: "tasks",
+			Steps: []workflow.Step{
+				{
+					Name:     "remove-offline",
+					Function: TaskFunction_RemoveOfflineExecuters,
+				},
+			},
+		},
 	}
 }
@@ -56,6 +56,9 @@ func Run(ctx context.Context, rediscli *redis.Client,
 	agents *agents.ClientSet,
 ) error {
 
+	// 任务结束以及过期时记录至数据库
+	history := workflow.NewHistoryStore(db)
+
 	p := &ProcessorContext{
 		server:    workflow.NewServerFromRedisClient(rediscli.Client),
 		client:    workflow.NewClientFromRedisClient(rediscli.Client),
@@ -63,6 +66,7 @@ func Run(ctx context.Context, rediscli *redis.Client,
 		crontasks: []CronTask{},
 		Logger:    log.FromContextOrDiscard(ctx),
 	}
+	p.server.AddHook(history.Hook())
 
 	// 注册支持的处理函数
 	taskers := []Tasker{
@@ -71,7 +75,7 @@ func Run(ctx context.Context, rediscli *redis.Client,
 		// application 应用部署相关
 		MustNewApplicationTasker(db, gitp, argocd, rediscli, agents),
 		// task-archive 持久化过期任务至database
-		NewTaskArchiverTasker(db, rediscli),
+		NewTaskArchiverTasker(db, rediscli, history),
 		// chart-sync 同步helmchart
 		&HelmSyncTasker{DB: db, ChartRepoUrl: helmOptions.Addr},
 		// cluster
@@ -62,6 +62,7 @@ func prepareDependencies(ctx context.Context, options *Options) (*Dependencies,
 	if err != nil {
 		return nil, err
 	}
+	agentclientset.EnableTunnel(options.Tunnel, "worker-")
 	// git
 	gitprovider, err := git.NewProvider(options.Git)
 	if err != nil {
@@ -116,6 +117,9 @@ func Run(ctx context.Context, options *Options) error {
 	eg.Go(func() error {
 		return pprof.Run(ctx)
 	})
+	eg.Go(func() error {
+		return deps.Agentscli.RunTunnel(ctx)
+	})
 	eg.Go(func() error {
 		return exporterHandler.Run(ctx, options.Exporter)
 	})